@@ -32,6 +32,18 @@ const (
 	EventBlacklistToggled EventName = "blacklistToggled"
 	// EventAccessRuleCreated is emitted when a new access ruleset is created
 	EventAccessRuleCreated EventName = "accessRuleCreated"
+	// EventConfigChanged is emitted when the configuration is changed through the management API
+	EventConfigChanged EventName = "configChanged"
+	// EventCertificateRevoked is emitted when the OCSP responder reports a loaded certificate as revoked
+	EventCertificateRevoked EventName = "certificateRevoked"
+	// EventCertificateExpiring is emitted when a certificate in the cert store is about to expire
+	EventCertificateExpiring EventName = "certificateExpiring"
+	// EventCertificateExpired is emitted when a certificate in the cert store has expired
+	EventCertificateExpired EventName = "certificateExpired"
+	// EventCertificateRenewalFailed is emitted when a certificate could not be obtained or renewed through ACME
+	EventCertificateRenewalFailed EventName = "certificateRenewalFailed"
+	// EventCertificateHostnameMismatch is emitted when a proxy endpoint is served a certificate not valid for its hostname
+	EventCertificateHostnameMismatch EventName = "certificateHostnameMismatch"
 	// A custom event emitted by a plugin, with the intention of being broadcast
 	// to the designated recipient(s)
 	EventCustom EventName = "customEvent"
@@ -42,11 +54,17 @@ const (
 )
 
 var validEventNames = map[EventName]bool{
-	EventBlacklistedIPBlocked: true,
-	EventBlacklistToggled:     true,
-	EventAccessRuleCreated:    true,
-	EventCustom:               true,
-	EventDummy:                true,
+	EventBlacklistedIPBlocked:        true,
+	EventBlacklistToggled:            true,
+	EventAccessRuleCreated:           true,
+	EventConfigChanged:               true,
+	EventCertificateRevoked:          true,
+	EventCertificateExpiring:         true,
+	EventCertificateExpired:          true,
+	EventCertificateRenewalFailed:    true,
+	EventCertificateHostnameMismatch: true,
+	EventCustom:                      true,
+	EventDummy:                       true,
 	// Add more event types as needed
 	// NOTE: Keep up-to-date with event names specified above
 }
@@ -106,6 +124,121 @@ func (e *AccessRuleCreatedEvent) GetEventSource() string {
 	return "accesslist-api"
 }
 
+// ConfigChange represents a single changed field of the configuration
+type ConfigChange struct {
+	Path   string `json:"path"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// ConfigChangedEvent represents an event when the configuration is changed through the management API
+type ConfigChangedEvent struct {
+	AuditID  string          `json:"audit_id"` // ID of the entry in the audit log
+	User     string          `json:"user"`
+	SourceIP string          `json:"source_ip"`
+	Method   string          `json:"method"`
+	Route    string          `json:"route"`
+	Targets  []string        `json:"targets"` // Proxy endpoints targeted by the change, if any
+	Changes  []*ConfigChange `json:"changes"`
+}
+
+func (e *ConfigChangedEvent) GetName() EventName {
+	return EventConfigChanged
+}
+
+func (e *ConfigChangedEvent) GetEventSource() string {
+	return "audit-log"
+}
+
+// CertificateRevokedEvent represents an event when a loaded certificate is reported as revoked by OCSP
+type CertificateRevokedEvent struct {
+	CertName         string   `json:"cert_name"`
+	Domains          []string `json:"domains"`
+	SerialNumber     string   `json:"serial_number"`
+	Issuer           string   `json:"issuer"`
+	RevokedAt        int64    `json:"revoked_at"`
+	RevocationReason int      `json:"revocation_reason"` // RFC 5280 CRLReason code
+	Renewed          bool     `json:"renewed"`           // If the certificate was renewed through ACME
+}
+
+func (e *CertificateRevokedEvent) GetName() EventName {
+	return EventCertificateRevoked
+}
+
+func (e *CertificateRevokedEvent) GetEventSource() string {
+	return "tls-cert"
+}
+
+// CertificateExpiringEvent represents an event when a certificate expires within the configured number of days
+type CertificateExpiringEvent struct {
+	CertName     string   `json:"cert_name"`
+	Domains      []string `json:"domains"`
+	SerialNumber string   `json:"serial_number"`
+	Issuer       string   `json:"issuer"`
+	NotAfter     int64    `json:"not_after"`
+	DaysLeft     int      `json:"days_left"`
+	Endpoints    []string `json:"endpoints"` // Proxy endpoints served with the certificate
+}
+
+func (e *CertificateExpiringEvent) GetName() EventName {
+	return EventCertificateExpiring
+}
+
+func (e *CertificateExpiringEvent) GetEventSource() string {
+	return "cert-monitor"
+}
+
+// CertificateExpiredEvent represents an event when a certificate in the cert store has expired
+type CertificateExpiredEvent struct {
+	CertName     string   `json:"cert_name"`
+	Domains      []string `json:"domains"`
+	SerialNumber string   `json:"serial_number"`
+	Issuer       string   `json:"issuer"`
+	NotAfter     int64    `json:"not_after"`
+	Endpoints    []string `json:"endpoints"` // Proxy endpoints served with the certificate
+}
+
+func (e *CertificateExpiredEvent) GetName() EventName {
+	return EventCertificateExpired
+}
+
+func (e *CertificateExpiredEvent) GetEventSource() string {
+	return "cert-monitor"
+}
+
+// CertificateRenewalFailedEvent represents an event when obtaining or renewing a certificate failed
+type CertificateRenewalFailedEvent struct {
+	CertName string   `json:"cert_name"`
+	Domains  []string `json:"domains"`
+	Reason   string   `json:"reason"`   // Why the certificate was requested, e.g. expiry, ari, revoked or auto-https
+	Failures int      `json:"failures"` // Consecutive failed attempts
+	Error    string   `json:"error"`
+}
+
+func (e *CertificateRenewalFailedEvent) GetName() EventName {
+	return EventCertificateRenewalFailed
+}
+
+func (e *CertificateRenewalFailedEvent) GetEventSource() string {
+	return "cert-monitor"
+}
+
+// CertificateHostnameMismatchEvent represents an event when the certificate served for a proxy endpoint does not cover its hostname
+type CertificateHostnameMismatchEvent struct {
+	Hostname    string   `json:"hostname"`
+	Endpoint    string   `json:"endpoint"`
+	CertName    string   `json:"cert_name"` // Empty if the build-in certificate is served
+	CertDomains []string `json:"cert_domains"`
+}
+
+func (e *CertificateHostnameMismatchEvent) GetName() EventName {
+	return EventCertificateHostnameMismatch
+}
+
+func (e *CertificateHostnameMismatchEvent) GetEventSource() string {
+	return "cert-monitor"
+}
+
 type CustomEvent struct {
 	SourcePlugin string         `json:"source_plugin"`
 	Recipients   []string       `json:"recipients"`
@@ -166,6 +299,60 @@ func ParseEvent(jsonData []byte, event *Event) error {
 			return err
 		}
 		event.Data = &payload.Data
+	case EventConfigChanged:
+		type tempData struct {
+			Data ConfigChangedEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
+	case EventCertificateRevoked:
+		type tempData struct {
+			Data CertificateRevokedEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
+	case EventCertificateExpiring:
+		type tempData struct {
+			Data CertificateExpiringEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
+	case EventCertificateExpired:
+		type tempData struct {
+			Data CertificateExpiredEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
+	case EventCertificateRenewalFailed:
+		type tempData struct {
+			Data CertificateRenewalFailedEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
+	case EventCertificateHostnameMismatch:
+		type tempData struct {
+			Data CertificateHostnameMismatchEvent `json:"data"`
+		}
+		var payload tempData
+		if err := json.Unmarshal(jsonData, &payload); err != nil {
+			return err
+		}
+		event.Data = &payload.Data
 	case EventCustom:
 		type tempData struct {
 			Data CustomEvent `json:"data"`
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
@@ -11,6 +14,7 @@ import (
 
 	"imuslab.com/zoraxy/mod/access"
 	"imuslab.com/zoraxy/mod/eventsystem"
+	"imuslab.com/zoraxy/mod/geodb"
 	"imuslab.com/zoraxy/mod/plugins/zoraxy_plugin/events"
 	"imuslab.com/zoraxy/mod/utils"
 )
@@ -23,6 +27,11 @@ import (
 	banning / whitelist a specific IP address or country code
 */
 
+var regionCodeRegex = regexp.MustCompile(`^[a-z]{2}-[a-z0-9]{1,3}$`)
+
+// ASN rules cannot match anything until the ASN dataset is downloaded
+var errASNDataNotLoaded = errors.New("ASN dataset not loaded, restart Zoraxy with -update_geoip to download it")
+
 /*
 	General Function
 */
@@ -221,6 +230,10 @@ func handleListBlacklisted(w http.ResponseWriter, r *http.Request) {
 		resulst = rule.GetAllBlacklistedCountryCode()
 	case "ip":
 		resulst = rule.GetAllBlacklistedIp()
+	case "asn":
+		resulst = rule.GetAllBlacklistedASN()
+	case "region":
+		resulst = rule.GetAllBlacklistedRegion()
 	}
 
 	js, _ := json.Marshal(resulst)
@@ -351,6 +364,144 @@ func handleIpBlacklistRemove(w http.ResponseWriter, r *http.Request) {
 	utils.SendOK(w)
 }
 
+func handleASNBlacklistAdd(w http.ResponseWriter, r *http.Request) {
+	if !asnDataLoaded() {
+		utils.SendErrorResponse(w, errASNDataNotLoaded.Error())
+		return
+	}
+
+	asnList, err := utils.PostPara(r, "asn")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty asn")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	comment, _ := utils.PostPara(r, "comment")
+	p := bluemonday.StripTagsPolicy()
+	comment = p.Sanitize(comment)
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Validate all ASNs before adding, accept both 13335 and AS13335
+	asns, err := parseASNList(asnList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, asn := range asns {
+		rule.AddASNToBlackList(asn, comment)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleASNBlacklistRemove(w http.ResponseWriter, r *http.Request) {
+	asnList, err := utils.PostPara(r, "asn")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty asn")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	asns, err := parseASNList(asnList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, asn := range asns {
+		rule.RemoveASNFromBlackList(asn)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleRegionBlacklistAdd(w http.ResponseWriter, r *http.Request) {
+	regionList, err := utils.PostPara(r, "region")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty region code")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	comment, _ := utils.PostPara(r, "comment")
+	p := bluemonday.StripTagsPolicy()
+	comment = p.Sanitize(comment)
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	regionCodes, err := parseRegionCodeList(regionList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, regionCode := range regionCodes {
+		rule.AddRegionToBlackList(regionCode, comment)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleRegionBlacklistRemove(w http.ResponseWriter, r *http.Request) {
+	regionList, err := utils.PostPara(r, "region")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty region code")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	regionCodes, err := parseRegionCodeList(regionList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, regionCode := range regionCodes {
+		rule.RemoveRegionFromBlackList(regionCode)
+	}
+
+	utils.SendOK(w)
+}
+
 func handleBlacklistEnable(w http.ResponseWriter, r *http.Request) {
 	enable, _ := utils.PostPara(r, "enable")
 	ruleID, err := utils.PostPara(r, "id")
@@ -414,6 +565,10 @@ func handleListWhitelisted(w http.ResponseWriter, r *http.Request) {
 		resulst = rule.GetAllWhitelistedCountryCode()
 	} else if bltype == "ip" {
 		resulst = rule.GetAllWhitelistedIp()
+	} else if bltype == "asn" {
+		resulst = rule.GetAllWhitelistedASN()
+	} else if bltype == "region" {
+		resulst = rule.GetAllWhitelistedRegion()
 	}
 
 	js, _ := json.Marshal(resulst)
@@ -540,6 +695,182 @@ func handleIpWhitelistRemove(w http.ResponseWriter, r *http.Request) {
 	utils.SendOK(w)
 }
 
+func handleASNWhitelistAdd(w http.ResponseWriter, r *http.Request) {
+	if !asnDataLoaded() {
+		utils.SendErrorResponse(w, errASNDataNotLoaded.Error())
+		return
+	}
+
+	asnList, err := utils.PostPara(r, "asn")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty asn")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	comment, _ := utils.PostPara(r, "comment")
+	p := bluemonday.StripTagsPolicy()
+	comment = p.Sanitize(comment)
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	asns, err := parseASNList(asnList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, asn := range asns {
+		rule.AddASNToWhiteList(asn, comment)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleASNWhitelistRemove(w http.ResponseWriter, r *http.Request) {
+	asnList, err := utils.PostPara(r, "asn")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty asn")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	asns, err := parseASNList(asnList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, asn := range asns {
+		rule.RemoveASNFromWhiteList(asn)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleRegionWhitelistAdd(w http.ResponseWriter, r *http.Request) {
+	regionList, err := utils.PostPara(r, "region")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty region code")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	comment, _ := utils.PostPara(r, "comment")
+	p := bluemonday.StripTagsPolicy()
+	comment = p.Sanitize(comment)
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	regionCodes, err := parseRegionCodeList(regionList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, regionCode := range regionCodes {
+		rule.AddRegionToWhiteList(regionCode, comment)
+	}
+
+	utils.SendOK(w)
+}
+
+func handleRegionWhitelistRemove(w http.ResponseWriter, r *http.Request) {
+	regionList, err := utils.PostPara(r, "region")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty region code")
+		return
+	}
+
+	ruleID, err := utils.PostPara(r, "id")
+	if err != nil {
+		ruleID = "default"
+	}
+
+	rule, err := accessController.GetAccessRuleByID(ruleID)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	regionCodes, err := parseRegionCodeList(regionList)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	for _, regionCode := range regionCodes {
+		rule.RemoveRegionFromWhiteList(regionCode)
+	}
+
+	utils.SendOK(w)
+}
+
+// Parse a comma seperated list of ISO 3166-2 region codes (e.g. us-ca, de-by)
+func parseRegionCodeList(regionList string) ([]string, error) {
+	results := []string{}
+	for _, regionCode := range strings.Split(regionList, ",") {
+		regionCode = strings.ToLower(strings.TrimSpace(regionCode))
+		if !regionCodeRegex.MatchString(regionCode) {
+			return nil, errors.New("invalid region code: " + regionCode + ", expecting ISO 3166-2 format like us-ca")
+		}
+		results = append(results, regionCode)
+	}
+	return results, nil
+}
+
+// asnDataLoaded checks if the ASN dataset is loaded so ASN rules can match requests
+func asnDataLoaded() bool {
+	return geodbStore != nil && geodbStore.HasASNData()
+}
+
+// Return if the ASN dataset is loaded
+func handleASNDataStatus(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(map[string]bool{
+		"loaded": asnDataLoaded(),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Parse a comma seperated list of ASN into normalized ASN number strings
+func parseASNList(asnList string) ([]string, error) {
+	results := []string{}
+	for _, asn := range strings.Split(asnList, ",") {
+		asnNumber, err := geodb.ParseASN(asn)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, strconv.FormatUint(uint64(asnNumber), 10))
+	}
+	return results, nil
+}
+
 func handleWhitelistEnable(w http.ResponseWriter, r *http.Request) {
 	enable, _ := utils.PostPara(r, "enable")
 	ruleID, err := utils.PostPara(r, "id")
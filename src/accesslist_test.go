@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestASNRulesRequireASNData(t *testing.T) {
+	defaultGeodbStore := geodbStore
+	t.Cleanup(func() { geodbStore = defaultGeodbStore })
+	geodbStore = nil
+
+	for _, handler := range []http.HandlerFunc{handleASNBlacklistAdd, handleASNWhitelistAdd} {
+		form := url.Values{"asn": {"AS13335"}, "id": {"default"}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if !strings.Contains(rec.Body.String(), errASNDataNotLoaded.Error()) {
+			t.Errorf("expected ASN rule to be refused without ASN data, got %s", rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleASNDataStatus(rec, httptest.NewRequest(http.MethodGet, "/api/access/asn/status", nil))
+	if strings.TrimSpace(rec.Body.String()) != `{"loaded":false}` {
+		t.Errorf("expected ASN data to be reported missing, got %s", rec.Body.String())
+	}
+}
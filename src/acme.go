@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -11,10 +13,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"imuslab.com/zoraxy/mod/acme"
 	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/eventsystem"
+	"imuslab.com/zoraxy/mod/plugins/zoraxy_plugin/events"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -118,12 +123,118 @@ func acmeDeregisterSpecialRoutingRule() {
 	dynamicProxyRouter.RemoveRoutingRule("acme-autorenew")
 }
 
+// Only one auto HTTPS issuance runs at a time as the HTTP-01 provider
+// binds the ACME handler port for the duration of the challenge
+var autoHTTPSIssueMutex sync.Mutex
+
+// obtainAutoHTTPSCertificate obtains a certificate for a hostname with auto
+// HTTPS enabled, using the preferred CA and the auto renew email
+func obtainAutoHTTPSCertificate(domain string) error {
+	autoHTTPSIssueMutex.Lock()
+	defer autoHTTPSIssueMutex.Unlock()
+
+	email := acmeAutoRenewer.RenewerConfig.Email
+	if email == "" {
+		return errors.New("ACME email is not set")
+	}
+
+	//Prefer HTTP-01 and use TLS-ALPN-01 on hosts that only expose 443
+	challengeType := acme.ChallengeTypeHTTP01
+	if dynamicProxyRouter.Option.Port != 80 && !dynamicProxyRouter.Option.ListenOnPort80 {
+		if !tlsALPNChallengeAvailable() {
+			return errors.New("auto HTTPS requires the port 80 listener or a TLS listener on port 443")
+		}
+		challengeType = acme.ChallengeTypeTLSALPN01
+	}
+
+	prefCA := "Let's Encrypt"
+	prefCAURL := ""
+	skipTLS := false
+	sysdb.Read("acmepref", "prefca", &prefCA)
+	sysdb.Read("acmepref", "prefcaurl", &prefCAURL)
+	sysdb.Read("acmepref", "skipTLS", &skipTLS)
+	if prefCA != "custom" {
+		prefCAURL = ""
+	}
+
+	_, err := acmeHandler.ObtainCert([]string{domain}, domain, email, prefCA, prefCAURL, skipTLS, challengeType, "", 0, "")
+	if err != nil {
+		reportAutoHTTPSFailure(domain, err)
+		return err
+	}
+
+	//Keep the new certificate renewed even if selective renew is used
+	if err := acmeAutoRenewer.AddRenewTarget(domain); err != nil {
+		SystemWideLogger.PrintAndLog("ACME", "Unable to add "+domain+" to auto renew list", err)
+	}
+	return nil
+}
+
+// Return the on-demand issuance state of auto HTTPS hostnames
+func HandleAutoHTTPSStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		//Clear the backoff of a failed domain so it is retried on the next handshake
+		domain, err := utils.PostPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain not given")
+			return
+		}
+		tlsCertManager.ResetAutoHTTPSBackoff(domain)
+		utils.SendOK(w)
+		return
+	}
+
+	js, _ := json.Marshal(tlsCertManager.GetAutoHTTPSStatus())
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleCertificateRevoked renews a certificate reported as revoked by its
+// OCSP responder and notifies the plugins subscribed to revocation events
+func handleCertificateRevoked(certName string, leaf *x509.Certificate, revokedAt time.Time, reason int) {
+	SystemWideLogger.PrintAndLog("ACME", "Certificate "+certName+" has been revoked, requesting a renewal", nil)
+
+	//Share the lock with auto HTTPS as both might bind the ACME handler port
+	autoHTTPSIssueMutex.Lock()
+	err := acmeAutoRenewer.RenewCertificate(certName)
+	autoHTTPSIssueMutex.Unlock()
+	if err != nil {
+		SystemWideLogger.PrintAndLog("ACME", "Unable to renew revoked certificate "+certName, err)
+	} else {
+		tlsCertManager.UpdateLoadedCertList()
+	}
+
+	if eventsystem.Publisher == nil {
+		return
+	}
+	eventsystem.Publisher.Emit(&events.CertificateRevokedEvent{
+		CertName:         certName,
+		Domains:          leaf.DNSNames,
+		SerialNumber:     fmt.Sprintf("%x", leaf.SerialNumber),
+		Issuer:           leaf.Issuer.CommonName,
+		RevokedAt:        revokedAt.Unix(),
+		RevocationReason: reason,
+		Renewed:          err == nil,
+	})
+}
+
+// TLS-ALPN-01 validations connect to port 443 of the main TLS listener
+func tlsALPNChallengeAvailable() bool {
+	return dynamicProxyRouter.Option.UseTls && dynamicProxyRouter.Option.Port == 443
+}
+
 // This function check if the renew setup is satisfied. If not, toggle them automatically
 func AcmeCheckAndHandleRenewCertificate(w http.ResponseWriter, r *http.Request) {
 	requireRestoreHttpsRedirect := false
 	requireRestorePort80 := false
 	dnsPara, _ := utils.PostBool(r, "dns")
-	if !dnsPara {
+	challengeType, _ := utils.PostPara(r, "challenge")
+	if challengeType == acme.ChallengeTypeTLSALPN01 {
+		//TLS-ALPN-01 challenge, answered by the main TLS listener
+		if !tlsALPNChallengeAvailable() {
+			utils.SendErrorResponse(w, "TLS-ALPN-01 challenge requires the TLS listener on port 443")
+			return
+		}
+	} else if !dnsPara && challengeType != acme.ChallengeTypeDNS01 {
 		//HTTP-01 challenge
 		switch dynamicProxyRouter.Option.Port {
 		case 443:
@@ -181,9 +292,9 @@ func AcmeCheckAndHandleRenewCertificate(w http.ResponseWriter, r *http.Request)
 func HandleACMEPreferredCA(w http.ResponseWriter, r *http.Request) {
 
 	type PreferredCA struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
-		SkipTLS bool `json:"skipTLS"`
+		Name    string `json:"name"`
+		URL     string `json:"url"`
+		SkipTLS bool   `json:"skipTLS"`
 	}
 
 	ca, err := utils.PostPara(r, "set")
@@ -196,15 +307,15 @@ func HandleACMEPreferredCA(w http.ResponseWriter, r *http.Request) {
 		sysdb.Read("acmepref", "prefcaurl", &prefCAURL)
 		sysdb.Read("acmepref", "skipTLS", &skipTLS)
 		js, _ := json.Marshal(PreferredCA{
-			Name: prefCA,
-			URL:  prefCAURL,
+			Name:    prefCA,
+			URL:     prefCAURL,
 			SkipTLS: skipTLS,
 		})
 		utils.SendJSONResponse(w, string(js))
 	} else {
 		//Check if the CA is supported
 		isSupported := acme.IsSupportedCA(ca, *acmeTestMode)
-		
+
 		if !isSupported && ca != "custom" {
 			utils.SendErrorResponse(w, "The specified ACME CA is not supported")
 			return
@@ -224,7 +335,7 @@ func HandleACMEPreferredCA(w http.ResponseWriter, r *http.Request) {
 			SystemWideLogger.Println("Updating prefered ACME CA URL to " + customCAURL)
 			sysdb.Write("acmepref", "skipTLS", skipTLS)
 			SystemWideLogger.Println("Updating prefered skipTLS to " + fmt.Sprintf("%t", skipTLS))
-		} 
+		}
 		//Set the new config
 		sysdb.Write("acmepref", "prefca", ca)
 		SystemWideLogger.Println("Updating prefered ACME CA to " + ca)
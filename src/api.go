@@ -15,6 +15,7 @@ import (
 	"imuslab.com/zoraxy/mod/ipscan"
 	"imuslab.com/zoraxy/mod/netstat"
 	"imuslab.com/zoraxy/mod/netutils"
+	"imuslab.com/zoraxy/mod/tlscert/localca"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -30,283 +31,358 @@ import (
 // Register the APIs for HTTP proxy management functions
 func RegisterHTTPProxyAPIs(authRouter *auth.RouterDef) {
 	/* Reverse Proxy Settings & Status */
-	authRouter.HandleFunc("/api/proxy/enable", ReverseProxyHandleOnOff)
-	authRouter.HandleFunc("/api/proxy/add", ReverseProxyHandleAddEndpoint)
-	authRouter.HandleFunc("/api/proxy/status", ReverseProxyStatus)
-	authRouter.HandleFunc("/api/proxy/toggle", ReverseProxyToggleRuleSet)
-	authRouter.HandleFunc("/api/proxy/list", ReverseProxyList)
-	authRouter.HandleFunc("/api/proxy/listTags", ReverseProxyListTags)
-	authRouter.HandleFunc("/api/proxy/detail", ReverseProxyListDetail)
-	authRouter.HandleFunc("/api/proxy/edit", ReverseProxyHandleEditEndpoint)
-	authRouter.HandleFunc("/api/proxy/setTags", ReverseProxyHandleSetTags)
-	authRouter.HandleFunc("/api/proxy/setAlias", ReverseProxyHandleAlias)
-	authRouter.HandleFunc("/api/proxy/setTlsConfig", ReverseProxyHandleSetTlsConfig)
-	authRouter.HandleFunc("/api/proxy/setHostname", ReverseProxyHandleSetHostname)
-	authRouter.HandleFunc("/api/proxy/del", DeleteProxyEndpoint)
-	authRouter.HandleFunc("/api/proxy/updateCredentials", UpdateProxyBasicAuthCredentials)
-	authRouter.HandleFunc("/api/proxy/listeningPorts/get", HandleGetListeningPorts)
-	authRouter.HandleFunc("/api/proxy/listeningPorts/set", HandleSetListeningPorts)
-	authRouter.HandleFunc("/api/proxy/listeningPorts/list", HandleListSecondaryListeners)
-	authRouter.HandleFunc("/api/proxy/tlscheck", domainsniff.HandleCheckSiteSupportTLS)
-	authRouter.HandleFunc("/api/proxy/setIncoming", HandleIncomingPortSet)
-	authRouter.HandleFunc("/api/proxy/useHttpsRedirect", HandleUpdateHttpsRedirect)
-	authRouter.HandleFunc("/api/proxy/listenPort80", HandleUpdatePort80Listener)
-	authRouter.HandleFunc("/api/proxy/requestIsProxied", HandleManagementProxyCheck)
-	authRouter.HandleFunc("/api/proxy/developmentMode", HandleDevelopmentModeChange)
-	authRouter.HandleFunc("/api/proxy/proxyProtocol", HandleProxyProtocolChange)
-	authRouter.HandleFunc("/api/proxy/timeouts", HandleGlobalProxyTimeoutSettings)
+	authRouter.HandleFunc("/api/proxy/enable", ReverseProxyHandleOnOff, auth.PermissionSystemManage)
+	authRouter.HandleScopedFunc("/api/proxy/add", ReverseProxyHandleAddEndpoint, auth.PermissionProxyManage, "rootname")
+	authRouter.HandleFunc("/api/proxy/status", ReverseProxyStatus, auth.PermissionView)
+	authRouter.HandleScopedFunc("/api/proxy/toggle", ReverseProxyToggleRuleSet, auth.PermissionProxyManage, "ep")
+	authRouter.HandleFunc("/api/proxy/list", ReverseProxyList, auth.PermissionView)
+	authRouter.HandleFunc("/api/proxy/listTags", ReverseProxyListTags, auth.PermissionView)
+	authRouter.HandleScopedFunc("/api/proxy/detail", ReverseProxyListDetail, auth.PermissionView, "epname")
+	authRouter.HandleScopedFunc("/api/proxy/edit", ReverseProxyHandleEditEndpoint, auth.PermissionProxyManage, "rootname")
+	authRouter.HandleScopedFunc("/api/proxy/setTags", ReverseProxyHandleSetTags, auth.PermissionProxyManage, "rootname")
+	authRouter.HandleScopedFunc("/api/proxy/setAlias", ReverseProxyHandleAlias, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/setTlsConfig", ReverseProxyHandleSetTlsConfig, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/setHostname", ReverseProxyHandleSetHostname, auth.PermissionProxyManage, "oldHostname", "newHostname")
+	authRouter.HandleScopedFunc("/api/proxy/del", DeleteProxyEndpoint, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/updateCredentials", UpdateProxyBasicAuthCredentials, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/listeningPorts/get", HandleGetListeningPorts, auth.PermissionView, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/listeningPorts/set", HandleSetListeningPorts, auth.PermissionProxyManage, "domain")
+	authRouter.HandleFunc("/api/proxy/listeningPorts/list", HandleListSecondaryListeners, auth.PermissionView)
+	authRouter.HandleFunc("/api/proxy/tlscheck", domainsniff.HandleCheckSiteSupportTLS, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/proxy/setIncoming", HandleIncomingPortSet, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/proxy/useHttpsRedirect", HandleUpdateHttpsRedirect, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/proxy/listenPort80", HandleUpdatePort80Listener, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/proxy/requestIsProxied", HandleManagementProxyCheck, auth.PermissionView)
+	authRouter.HandleFunc("/api/proxy/developmentMode", HandleDevelopmentModeChange, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/proxy/proxyProtocol", HandleProxyProtocolChange, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/proxy/timeouts", HandleGlobalProxyTimeoutSettings, auth.PermissionSystemManage)
 	/* Reverse proxy upstream (load balance) */
-	authRouter.HandleFunc("/api/proxy/upstream/list", ReverseProxyUpstreamList)
-	authRouter.HandleFunc("/api/proxy/upstream/add", ReverseProxyUpstreamAdd)
-	authRouter.HandleFunc("/api/proxy/upstream/setPriority", ReverseProxyUpstreamSetPriority)
-	authRouter.HandleFunc("/api/proxy/upstream/update", ReverseProxyUpstreamUpdate)
-	authRouter.HandleFunc("/api/proxy/upstream/remove", ReverseProxyUpstreamDelete)
+	authRouter.HandleScopedFunc("/api/proxy/upstream/list", ReverseProxyUpstreamList, auth.PermissionView, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/upstream/add", ReverseProxyUpstreamAdd, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/upstream/setPriority", ReverseProxyUpstreamSetPriority, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/upstream/update", ReverseProxyUpstreamUpdate, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/upstream/remove", ReverseProxyUpstreamDelete, auth.PermissionProxyManage, "ep")
 	/* Reverse proxy virtual directory */
-	authRouter.HandleFunc("/api/proxy/vdir/list", ReverseProxyListVdir)
-	authRouter.HandleFunc("/api/proxy/vdir/add", ReverseProxyAddVdir)
-	authRouter.HandleFunc("/api/proxy/vdir/del", ReverseProxyDeleteVdir)
-	authRouter.HandleFunc("/api/proxy/vdir/edit", ReverseProxyEditVdir)
-	authRouter.HandleFunc("/api/proxy/vdir/bulkForwardAuth", ReverseProxyBulkApplyVdirByForwardAuth)
+	authRouter.HandleScopedFunc("/api/proxy/vdir/list", ReverseProxyListVdir, auth.PermissionView, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/vdir/add", ReverseProxyAddVdir, auth.PermissionProxyManage, "endpoint")
+	authRouter.HandleScopedFunc("/api/proxy/vdir/del", ReverseProxyDeleteVdir, auth.PermissionProxyManage, "path")
+	authRouter.HandleScopedFunc("/api/proxy/vdir/edit", ReverseProxyEditVdir, auth.PermissionProxyManage, "path")
+	authRouter.HandleFunc("/api/proxy/vdir/bulkForwardAuth", ReverseProxyBulkApplyVdirByForwardAuth, auth.PermissionProxyManage)
 	/* Reverse proxy user-defined header */
-	authRouter.HandleFunc("/api/proxy/header/list", HandleCustomHeaderList)
-	authRouter.HandleFunc("/api/proxy/header/add", HandleCustomHeaderAdd)
-	authRouter.HandleFunc("/api/proxy/header/remove", HandleCustomHeaderRemove)
-	authRouter.HandleFunc("/api/proxy/header/handleHSTS", HandleHSTSState)
-	authRouter.HandleFunc("/api/proxy/header/handleHopByHop", HandleHopByHop)
-	authRouter.HandleFunc("/api/proxy/header/handleUserAgent", HandleUserAgent)
-	authRouter.HandleFunc("/api/proxy/header/handleHostOverwrite", HandleHostOverwrite)
-	authRouter.HandleFunc("/api/proxy/header/handlePermissionPolicy", HandlePermissionPolicy)
-	authRouter.HandleFunc("/api/proxy/header/handleWsHeaderBehavior", HandleWsHeaderBehavior)
+	authRouter.HandleScopedFunc("/api/proxy/header/list", HandleCustomHeaderList, auth.PermissionView, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/add", HandleCustomHeaderAdd, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/remove", HandleCustomHeaderRemove, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handleHSTS", HandleHSTSState, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handleHopByHop", HandleHopByHop, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handleUserAgent", HandleUserAgent, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handleHostOverwrite", HandleHostOverwrite, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handlePermissionPolicy", HandlePermissionPolicy, auth.PermissionProxyManage, "domain")
+	authRouter.HandleScopedFunc("/api/proxy/header/handleWsHeaderBehavior", HandleWsHeaderBehavior, auth.PermissionProxyManage, "domain")
 	/* Reverse proxy auth related */
-	authRouter.HandleFunc("/api/proxy/auth/exceptions/list", ListProxyBasicAuthExceptionPaths)
-	authRouter.HandleFunc("/api/proxy/auth/exceptions/add", AddProxyBasicAuthExceptionPaths)
-	authRouter.HandleFunc("/api/proxy/auth/exceptions/delete", RemoveProxyBasicAuthExceptionPaths)
+	authRouter.HandleScopedFunc("/api/proxy/auth/exceptions/list", ListProxyBasicAuthExceptionPaths, auth.PermissionView, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/auth/exceptions/add", AddProxyBasicAuthExceptionPaths, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/auth/exceptions/delete", RemoveProxyBasicAuthExceptionPaths, auth.PermissionProxyManage, "ep")
 	/* ZorxAuth SSO per-endpoint exception rules */
-	authRouter.HandleFunc("/api/proxy/auth/zorxauth/exceptions/list", ListProxyZorxAuthExceptionRules)
-	authRouter.HandleFunc("/api/proxy/auth/zorxauth/exceptions/add", AddProxyZorxAuthExceptionRule)
-	authRouter.HandleFunc("/api/proxy/auth/zorxauth/exceptions/delete", RemoveProxyZorxAuthExceptionRule)
+	authRouter.HandleScopedFunc("/api/proxy/auth/zorxauth/exceptions/list", ListProxyZorxAuthExceptionRules, auth.PermissionView, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/auth/zorxauth/exceptions/add", AddProxyZorxAuthExceptionRule, auth.PermissionProxyManage, "ep")
+	authRouter.HandleScopedFunc("/api/proxy/auth/zorxauth/exceptions/delete", RemoveProxyZorxAuthExceptionRule, auth.PermissionProxyManage, "ep")
+	/* JWT bearer token auth per-endpoint settings */
+	authRouter.HandleScopedFunc("/api/proxy/auth/jwt", handleJWTAuthEndpointConfig, auth.PermissionProxyManage, "domain")
+	/* API key auth */
+	authRouter.HandleScopedFunc("/api/proxy/auth/apikey", handleAPIKeyEndpointConfig, auth.PermissionProxyManage, "domain")
+	authRouter.HandleFunc("/api/apikey/list", handleAPIKeyList, auth.PermissionView)
+	authRouter.HandleFunc("/api/apikey/create", handleAPIKeyCreate, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/apikey/update", handleAPIKeyUpdate, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/apikey/rotate", handleAPIKeyRotate, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/apikey/delete", handleAPIKeyDelete, auth.PermissionAccessManage)
+}
+
+// Register the APIs for web application firewall management functions
+func RegisterWAFAPIs(authRouter *auth.RouterDef) {
+	authRouter.HandleFunc("/api/waf/rules/list", handleWAFListRules, auth.PermissionView)
+	authRouter.HandleFunc("/api/waf/rules/reload", handleWAFReloadRules, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/waf/audit", handleWAFAuditLog, auth.PermissionView)
+	authRouter.HandleScopedFunc("/api/waf/endpoint", handleWAFEndpointConfig, auth.PermissionAccessManage, "domain")
 }
 
 // Register the APIs for TLS / SSL certificate management functions
 func RegisterTLSAPIs(authRouter *auth.RouterDef) {
 	//Global certificate settings
-	authRouter.HandleFunc("/api/cert/tls", handleToggleTLSProxy)
-	authRouter.HandleFunc("/api/cert/tlsMinVersion", handleSetTlsMinVersion)
-	authRouter.HandleFunc("/api/cert/resolve", handleCertTryResolve)
-	authRouter.HandleFunc("/api/cert/setPreferredCertificate", handleSetDomainPreferredCertificate)
+	authRouter.HandleFunc("/api/cert/tls", handleToggleTLSProxy, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/tlsMinVersion", handleSetTlsMinVersion, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/resolve", handleCertTryResolve, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/setPreferredCertificate", handleSetDomainPreferredCertificate, auth.PermissionCertManage)
 
 	//Certificate store functions
-	authRouter.HandleFunc("/api/cert/setDefault", tlsCertManager.SetCertAsDefault)
-	authRouter.HandleFunc("/api/cert/getCommonName", tlsCertManager.HandleGetCertCommonName)
-	authRouter.HandleFunc("/api/cert/upload", tlsCertManager.HandleCertUpload)
-	authRouter.HandleFunc("/api/cert/download", tlsCertManager.HandleCertDownload)
-	authRouter.HandleFunc("/api/cert/list", tlsCertManager.HandleListCertificate)
-	authRouter.HandleFunc("/api/cert/listdomains", tlsCertManager.HandleListDomains)
-	authRouter.HandleFunc("/api/cert/checkDefault", tlsCertManager.HandleDefaultCertCheck)
-	authRouter.HandleFunc("/api/cert/delete", tlsCertManager.HandleCertRemove)
-	authRouter.HandleFunc("/api/cert/selfsign", tlsCertManager.HandleSelfSignCertGenerate)
+	authRouter.HandleFunc("/api/cert/setDefault", tlsCertManager.SetCertAsDefault, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/getCommonName", tlsCertManager.HandleGetCertCommonName, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/upload", tlsCertManager.HandleCertUpload, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/download", tlsCertManager.HandleCertDownload, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/list", tlsCertManager.HandleListCertificate, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/listdomains", tlsCertManager.HandleListDomains, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/checkDefault", tlsCertManager.HandleDefaultCertCheck, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/stats", tlsCertManager.HandleCertLookupStats, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/ocsp", tlsCertManager.HandleOCSPStatus, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/delete", tlsCertManager.HandleCertRemove, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/selfsign", tlsCertManager.HandleSelfSignCertGenerate, auth.PermissionCertManage)
+
+	//Client certificate (mTLS) functions
+	authRouter.HandleFunc("/api/mtls/list", handleMTLSList, auth.PermissionView)
+	authRouter.HandleFunc("/api/mtls/upload", handleMTLSUpload, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/mtls/remove", handleMTLSRemove, auth.PermissionCertManage)
+	authRouter.HandleScopedFunc("/api/mtls/endpoint", handleMTLSEndpointConfig, auth.PermissionCertManage, "domain")
+
+	//Local certificate authority functions
+	authRouter.HandleFunc("/api/localca/status", localCA.HandleStatus, auth.PermissionView)
+	authRouter.HandleFunc("/api/localca/config", localCA.HandleConfig, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/localca/issued", localCA.HandleListIssued, auth.PermissionView)
+	authRouter.HandleFunc("/api/localca/issue", localCA.HandleIssue, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/localca/revoke", localCA.HandleRevoke, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/localca/acmeAccounts", localCA.HandleListACMEAccounts, auth.PermissionView)
+	authRouter.HandleFunc("/api/localca/root", localCA.HandleRootDownload, auth.PermissionView)
+
+	//Certificate monitor functions
+	authRouter.HandleFunc("/api/cert/monitor/inventory", certMonitor.HandleInventory, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/monitor/alerts", certMonitor.HandleAlerts, auth.PermissionView)
+	authRouter.HandleFunc("/api/cert/monitor/config", certMonitor.HandleConfig, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/cert/monitor/test", certMonitor.HandleTestNotification, auth.PermissionCertManage)
 }
 
 // Register the APIs for Authentication handlers like Forward Auth and OAUTH2
 func RegisterAuthenticationHandlerAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/sso/forward-auth", forwardAuthRouter.HandleAPIOptions)
-	authRouter.HandleFunc("/api/sso/OAuth2", oauth2Router.HandleSetOAuth2Settings)
-	authRouter.HandleFunc("/api/sso/zorxauth/provider", zorxAuthRouter.HandleAuthProviderSettings)
-	authRouter.HandleFunc("/api/sso/zorxauth/gateway", zorxAuthRouter.HandleGatewaySettings)
+	authRouter.HandleFunc("/api/sso/forward-auth", forwardAuthRouter.HandleAPIOptions, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/OAuth2", oauth2Router.HandleSetOAuth2Settings, auth.PermissionSystemManage)
+	authRouter.HandleScopedFunc("/api/sso/OAuth2/endpoint", handleOAuth2EndpointConfig, auth.PermissionProxyManage, "domain")
+	authRouter.HandleFunc("/api/sso/zorxauth/provider", zorxAuthRouter.HandleAuthProviderSettings, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/gateway", zorxAuthRouter.HandleGatewaySettings, auth.PermissionSystemManage)
 }
 
 // Register ZorxAuth user management APIs separately from generic SSO provider settings routes
 func RegisterZorxAuthUserManagementAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/sso/zorxauth/users/list", zorxAuthRouter.HandleUsersList)
-	authRouter.HandleFunc("/api/sso/zorxauth/users/create", zorxAuthRouter.HandleUserCreate)
-	authRouter.HandleFunc("/api/sso/zorxauth/users/update", zorxAuthRouter.HandleUserUpdate)
-	authRouter.HandleFunc("/api/sso/zorxauth/users/delete", zorxAuthRouter.HandleUserDelete)
-	authRouter.HandleFunc("/api/sso/zorxauth/users/logoutAll", zorxAuthRouter.HandleLogoutAllUsers)
-	authRouter.HandleFunc("/api/sso/zorxauth/users/disable2fa", zorxAuthRouter.HandleDisableUserTOTP)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/list", zorxAuthRouter.HandleUsersList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/create", zorxAuthRouter.HandleUserCreate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/update", zorxAuthRouter.HandleUserUpdate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/delete", zorxAuthRouter.HandleUserDelete, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/logoutAll", zorxAuthRouter.HandleLogoutAllUsers, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/disable2fa", zorxAuthRouter.HandleDisableUserTOTP, auth.PermissionSystemManage)
+
+	// Session management
+	authRouter.HandleFunc("/api/sso/zorxauth/sessions/list", zorxAuthRouter.HandleSessionList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/sessions/revoke", zorxAuthRouter.HandleSessionRevoke, auth.PermissionSystemManage)
 
 	// Group Policy management
-	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/list", zorxAuthRouter.HandleGroupPolicyList)
-	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/create", zorxAuthRouter.HandleGroupPolicyCreate)
-	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/update", zorxAuthRouter.HandleGroupPolicyUpdate)
-	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/delete", zorxAuthRouter.HandleGroupPolicyDelete)
+	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/list", zorxAuthRouter.HandleGroupPolicyList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/create", zorxAuthRouter.HandleGroupPolicyCreate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/update", zorxAuthRouter.HandleGroupPolicyUpdate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/grouppolicy/delete", zorxAuthRouter.HandleGroupPolicyDelete, auth.PermissionSystemManage)
+
+	// OpenID Connect client management
+	authRouter.HandleFunc("/api/sso/zorxauth/oidc/clients/list", zorxAuthRouter.HandleOIDCClientList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/oidc/clients/create", zorxAuthRouter.HandleOIDCClientCreate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/oidc/clients/update", zorxAuthRouter.HandleOIDCClientUpdate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/oidc/clients/resetSecret", zorxAuthRouter.HandleOIDCClientResetSecret, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/oidc/clients/delete", zorxAuthRouter.HandleOIDCClientDelete, auth.PermissionSystemManage)
+
+	// LDAP / Active Directory backend
+	authRouter.HandleFunc("/api/sso/zorxauth/ldap", zorxAuthRouter.HandleLDAPSettings, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/ldap/test", zorxAuthRouter.HandleLDAPTest, auth.PermissionSystemManage)
+
+	// Email, password reset and invitations
+	authRouter.HandleFunc("/api/sso/zorxauth/email", zorxAuthRouter.HandleEmailSettings, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/email/test", zorxAuthRouter.HandleEmailTest, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/invites/list", zorxAuthRouter.HandleInviteList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/invites/create", zorxAuthRouter.HandleInviteCreate, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/invites/revoke", zorxAuthRouter.HandleInviteRevoke, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/sso/zorxauth/users/sendReset", zorxAuthRouter.HandleUserSendPasswordReset, auth.PermissionSystemManage)
 }
 
 // Register the APIs for redirection rules management functions
 func RegisterRedirectionAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/redirect/list", handleListRedirectionRules)
-	authRouter.HandleFunc("/api/redirect/add", handleAddRedirectionRule)
-	authRouter.HandleFunc("/api/redirect/delete", handleDeleteRedirectionRule)
-	authRouter.HandleFunc("/api/redirect/edit", handleEditRedirectionRule)
-	authRouter.HandleFunc("/api/redirect/toggle", handleToggleRedirectionRuleEnable)
-	authRouter.HandleFunc("/api/redirect/regex", handleToggleRedirectRegexpSupport)
-	authRouter.HandleFunc("/api/redirect/case_sensitive", handleToggleRedirectCaseSensitivity)
+	authRouter.HandleFunc("/api/redirect/list", handleListRedirectionRules, auth.PermissionView)
+	authRouter.HandleFunc("/api/redirect/add", handleAddRedirectionRule, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/redirect/delete", handleDeleteRedirectionRule, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/redirect/edit", handleEditRedirectionRule, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/redirect/toggle", handleToggleRedirectionRuleEnable, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/redirect/regex", handleToggleRedirectRegexpSupport, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/redirect/case_sensitive", handleToggleRedirectCaseSensitivity, auth.PermissionProxyManage)
 }
 
 // Register the APIs for access rules management functions
 func RegisterAccessRuleAPIs(authRouter *auth.RouterDef) {
 	/* Access Rules Settings & Status */
-	authRouter.HandleFunc("/api/access/list", handleListAccessRules)
-	authRouter.HandleFunc("/api/access/attach", handleAttachRuleToHost)
-	authRouter.HandleFunc("/api/access/create", handleCreateAccessRule)
-	authRouter.HandleFunc("/api/access/remove", handleRemoveAccessRule)
-	authRouter.HandleFunc("/api/access/update", handleUpadateAccessRule)
+	authRouter.HandleFunc("/api/access/list", handleListAccessRules, auth.PermissionView)
+	authRouter.HandleScopedFunc("/api/access/attach", handleAttachRuleToHost, auth.PermissionAccessManage, "host")
+	authRouter.HandleFunc("/api/access/create", handleCreateAccessRule, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/access/remove", handleRemoveAccessRule, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/access/update", handleUpadateAccessRule, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/access/asn/status", handleASNDataStatus, auth.PermissionView)
 	/* Blacklist */
-	authRouter.HandleFunc("/api/blacklist/list", handleListBlacklisted)
-	authRouter.HandleFunc("/api/blacklist/country/add", handleCountryBlacklistAdd)
-	authRouter.HandleFunc("/api/blacklist/country/remove", handleCountryBlacklistRemove)
-	authRouter.HandleFunc("/api/blacklist/ip/add", handleIpBlacklistAdd)
-	authRouter.HandleFunc("/api/blacklist/ip/remove", handleIpBlacklistRemove)
-	authRouter.HandleFunc("/api/blacklist/enable", handleBlacklistEnable)
+	authRouter.HandleFunc("/api/blacklist/list", handleListBlacklisted, auth.PermissionView)
+	authRouter.HandleFunc("/api/blacklist/country/add", handleCountryBlacklistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/country/remove", handleCountryBlacklistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/ip/add", handleIpBlacklistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/ip/remove", handleIpBlacklistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/asn/add", handleASNBlacklistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/asn/remove", handleASNBlacklistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/region/add", handleRegionBlacklistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/region/remove", handleRegionBlacklistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/blacklist/enable", handleBlacklistEnable, auth.PermissionAccessManage)
 	/* Whitelist */
-	authRouter.HandleFunc("/api/whitelist/list", handleListWhitelisted)
-	authRouter.HandleFunc("/api/whitelist/country/add", handleCountryWhitelistAdd)
-	authRouter.HandleFunc("/api/whitelist/country/remove", handleCountryWhitelistRemove)
-	authRouter.HandleFunc("/api/whitelist/ip/add", handleIpWhitelistAdd)
-	authRouter.HandleFunc("/api/whitelist/ip/remove", handleIpWhitelistRemove)
-	authRouter.HandleFunc("/api/whitelist/enable", handleWhitelistEnable)
-	authRouter.HandleFunc("/api/whitelist/allowLocal", handleWhitelistAllowLoopback)
-	authRouter.HandleFunc("/api/whitelist/trustProxy", handleWhitelistTrustProxy)
+	authRouter.HandleFunc("/api/whitelist/list", handleListWhitelisted, auth.PermissionView)
+	authRouter.HandleFunc("/api/whitelist/country/add", handleCountryWhitelistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/country/remove", handleCountryWhitelistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/ip/add", handleIpWhitelistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/ip/remove", handleIpWhitelistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/asn/add", handleASNWhitelistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/asn/remove", handleASNWhitelistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/region/add", handleRegionWhitelistAdd, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/region/remove", handleRegionWhitelistRemove, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/enable", handleWhitelistEnable, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/allowLocal", handleWhitelistAllowLoopback, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/whitelist/trustProxy", handleWhitelistTrustProxy, auth.PermissionAccessManage)
 	/* Quick Ban List */
-	authRouter.HandleFunc("/api/quickban/list", handleListQuickBan)
+	authRouter.HandleFunc("/api/quickban/list", handleListQuickBan, auth.PermissionView)
 	/* Trusted Proxies */
-	authRouter.HandleFunc("/api/trustedproxy/list", handleListTrustedProxies)
-	authRouter.HandleFunc("/api/trustedproxy/add", handleAddTrustedProxy)
-	authRouter.HandleFunc("/api/trustedproxy/remove", handleRemoveTrustedProxy)
-	authRouter.HandleFunc("/api/trustedproxy/update", handleUpdateTrustedProxy)
-	authRouter.HandleFunc("/api/trustedproxy/bulkUpdate", handleBulkUpdateTrustedProxies)
-	authRouter.HandleFunc("/api/trustedproxy/reset", handleResetDefaultTrustedProxies)
+	authRouter.HandleFunc("/api/trustedproxy/list", handleListTrustedProxies, auth.PermissionView)
+	authRouter.HandleFunc("/api/trustedproxy/add", handleAddTrustedProxy, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/trustedproxy/remove", handleRemoveTrustedProxy, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/trustedproxy/update", handleUpdateTrustedProxy, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/trustedproxy/bulkUpdate", handleBulkUpdateTrustedProxies, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/trustedproxy/reset", handleResetDefaultTrustedProxies, auth.PermissionAccessManage)
 }
 
 // Register the APIs for path blocking rules management functions, WIP
 func RegisterPathRuleAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/pathrule/add", pathRuleHandler.HandleAddBlockingPath)
-	authRouter.HandleFunc("/api/pathrule/list", pathRuleHandler.HandleListBlockingPath)
-	authRouter.HandleFunc("/api/pathrule/remove", pathRuleHandler.HandleRemoveBlockingPath)
+	authRouter.HandleFunc("/api/pathrule/add", pathRuleHandler.HandleAddBlockingPath, auth.PermissionAccessManage)
+	authRouter.HandleFunc("/api/pathrule/list", pathRuleHandler.HandleListBlockingPath, auth.PermissionView)
+	authRouter.HandleFunc("/api/pathrule/remove", pathRuleHandler.HandleRemoveBlockingPath, auth.PermissionAccessManage)
 }
 
 // Register the APIs statistic anlysis and uptime monitoring functions
 func RegisterStatisticalAPIs(authRouter *auth.RouterDef) {
 	/* Traffic Summary */
-	authRouter.HandleFunc("/api/stats/summary", statisticCollector.HandleTodayStatLoad)
-	authRouter.HandleFunc("/api/stats/overview", HandleDashboardOverview)
-	authRouter.HandleFunc("/api/stats/trafficmap", HandleTrafficMapData)
-	authRouter.HandleFunc("/api/stats/countries", HandleCountryDistrSummary)
-	authRouter.HandleFunc("/api/stats/netstat", netstatBuffers.HandleGetNetworkInterfaceStats)
-	authRouter.HandleFunc("/api/stats/netstatgraph", netstatBuffers.HandleGetBufferedNetworkInterfaceStats)
-	authRouter.HandleFunc("/api/stats/listnic", netstat.HandleListNetworkInterfaces)
+	authRouter.HandleFunc("/api/stats/summary", statisticCollector.HandleTodayStatLoad, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/overview", HandleDashboardOverview, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/trafficmap", HandleTrafficMapData, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/countries", HandleCountryDistrSummary, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/netstat", netstatBuffers.HandleGetNetworkInterfaceStats, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/netstatgraph", netstatBuffers.HandleGetBufferedNetworkInterfaceStats, auth.PermissionView)
+	authRouter.HandleFunc("/api/stats/listnic", netstat.HandleListNetworkInterfaces, auth.PermissionView)
 	/* Host System Information */
-	authRouter.HandleFunc("/api/stats/system", HandleSystemResourceUsage)
-	authRouter.HandleFunc("/api/sysinfo/cpu", hardwareinfo.CachedGetCPUInfo)
-	authRouter.HandleFunc("/api/sysinfo/ram", hardwareinfo.CachedGetRamInfo)
-	authRouter.HandleFunc("/api/sysinfo/drives", hardwareinfo.CachedGetDriveStat)
-	authRouter.HandleFunc("/api/sysinfo/nic", hardwareinfo.CachedIfconfig)
-	authRouter.HandleFunc("/api/sysinfo/usb", hardwareinfo.CachedGetUSB)
+	authRouter.HandleFunc("/api/stats/system", HandleSystemResourceUsage, auth.PermissionView)
+	authRouter.HandleFunc("/api/sysinfo/cpu", hardwareinfo.CachedGetCPUInfo, auth.PermissionView)
+	authRouter.HandleFunc("/api/sysinfo/ram", hardwareinfo.CachedGetRamInfo, auth.PermissionView)
+	authRouter.HandleFunc("/api/sysinfo/drives", hardwareinfo.CachedGetDriveStat, auth.PermissionView)
+	authRouter.HandleFunc("/api/sysinfo/nic", hardwareinfo.CachedIfconfig, auth.PermissionView)
+	authRouter.HandleFunc("/api/sysinfo/usb", hardwareinfo.CachedGetUSB, auth.PermissionView)
 	/* Zoraxy Analytic */
-	authRouter.HandleFunc("/api/analytic/list", AnalyticLoader.HandleSummaryList)
-	authRouter.HandleFunc("/api/analytic/load", AnalyticLoader.HandleLoadTargetDaySummary)
-	authRouter.HandleFunc("/api/analytic/loadRange", AnalyticLoader.HandleLoadTargetRangeSummary)
-	authRouter.HandleFunc("/api/analytic/exportRange", AnalyticLoader.HandleRangeExport)
-	authRouter.HandleFunc("/api/analytic/resetRange", AnalyticLoader.HandleRangeReset)
-	authRouter.HandleFunc("/api/analytic/resetAll", AnalyticLoader.HandleResetAllStats)
+	authRouter.HandleFunc("/api/analytic/list", AnalyticLoader.HandleSummaryList, auth.PermissionView)
+	authRouter.HandleFunc("/api/analytic/load", AnalyticLoader.HandleLoadTargetDaySummary, auth.PermissionView)
+	authRouter.HandleFunc("/api/analytic/loadRange", AnalyticLoader.HandleLoadTargetRangeSummary, auth.PermissionView)
+	authRouter.HandleFunc("/api/analytic/exportRange", AnalyticLoader.HandleRangeExport, auth.PermissionView)
+	authRouter.HandleFunc("/api/analytic/resetRange", AnalyticLoader.HandleRangeReset, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/analytic/resetAll", AnalyticLoader.HandleResetAllStats, auth.PermissionSystemManage)
 	/* UpTime Monitor */
-	authRouter.HandleFunc("/api/utm/list", HandleUptimeMonitorListing)
+	authRouter.HandleFunc("/api/utm/list", HandleUptimeMonitorListing, auth.PermissionView)
 }
 
 // Register the APIs for Stream (TCP / UDP) Proxy management functions
 func RegisterStreamProxyAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/streamprox/config/add", streamProxyManager.HandleAddProxyConfig)
-	authRouter.HandleFunc("/api/streamprox/config/edit", streamProxyManager.HandleEditProxyConfigs)
-	authRouter.HandleFunc("/api/streamprox/config/list", streamProxyManager.HandleListConfigs)
-	authRouter.HandleFunc("/api/streamprox/config/start", streamProxyManager.HandleStartProxy)
-	authRouter.HandleFunc("/api/streamprox/config/stop", streamProxyManager.HandleStopProxy)
-	authRouter.HandleFunc("/api/streamprox/config/delete", streamProxyManager.HandleRemoveProxy)
-	authRouter.HandleFunc("/api/streamprox/config/status", streamProxyManager.HandleGetProxyStatus)
+	authRouter.HandleFunc("/api/streamprox/config/add", streamProxyManager.HandleAddProxyConfig, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/streamprox/config/edit", streamProxyManager.HandleEditProxyConfigs, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/streamprox/config/list", streamProxyManager.HandleListConfigs, auth.PermissionView)
+	authRouter.HandleFunc("/api/streamprox/config/start", streamProxyManager.HandleStartProxy, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/streamprox/config/stop", streamProxyManager.HandleStopProxy, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/streamprox/config/delete", streamProxyManager.HandleRemoveProxy, auth.PermissionProxyManage)
+	authRouter.HandleFunc("/api/streamprox/config/status", streamProxyManager.HandleGetProxyStatus, auth.PermissionView)
 }
 
 // Register the APIs for mDNS service management functions
 func RegisterMDNSAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/mdns/list", HandleMdnsListing)
-	authRouter.HandleFunc("/api/mdns/discover", HandleMdnsScanning)
+	authRouter.HandleFunc("/api/mdns/list", HandleMdnsListing, auth.PermissionView)
+	authRouter.HandleFunc("/api/mdns/discover", HandleMdnsScanning, auth.PermissionProxyManage)
 }
 
 // Register the APIs for ACME and Auto Renewer management functions
 func RegisterACMEAndAutoRenewerAPIs(authRouter *auth.RouterDef) {
 	/* ACME Core */
-	authRouter.HandleFunc("/api/acme/listExpiredDomains", acmeHandler.HandleGetExpiredDomains)
-	authRouter.HandleFunc("/api/acme/obtainCert", AcmeCheckAndHandleRenewCertificate)
+	authRouter.HandleFunc("/api/acme/listExpiredDomains", acmeHandler.HandleGetExpiredDomains, auth.PermissionView)
+	authRouter.HandleFunc("/api/acme/obtainCert", AcmeCheckAndHandleRenewCertificate, auth.PermissionCertManage)
 	/* Auto Renewer */
-	authRouter.HandleFunc("/api/acme/autoRenew/enable", acmeAutoRenewer.HandleAutoRenewEnable)
-	authRouter.HandleFunc("/api/acme/autoRenew/ca", HandleACMEPreferredCA)
-	authRouter.HandleFunc("/api/acme/autoRenew/email", acmeAutoRenewer.HandleACMEEmail)
-	authRouter.HandleFunc("/api/acme/autoRenew/setDomains", acmeAutoRenewer.HandleSetAutoRenewDomains)
-	authRouter.HandleFunc("/api/acme/autoRenew/setEAB", acmeAutoRenewer.HanldeSetEAB)
-	authRouter.HandleFunc("/api/acme/autoRenew/setDNS", acmeAutoRenewer.HandleSetDNS)
-	authRouter.HandleFunc("/api/acme/autoRenew/listDomains", acmeAutoRenewer.HandleLoadAutoRenewDomains)
-	authRouter.HandleFunc("/api/acme/autoRenew/renewPolicy", acmeAutoRenewer.HandleRenewPolicy)
-	authRouter.HandleFunc("/api/acme/autoRenew/renewNow", acmeAutoRenewer.HandleRenewNow)
-	authRouter.HandleFunc("/api/acme/dns/providers", acmedns.HandleServeProvidersJson)
+	authRouter.HandleFunc("/api/acme/autoRenew/enable", acmeAutoRenewer.HandleAutoRenewEnable, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/ca", HandleACMEPreferredCA, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/email", acmeAutoRenewer.HandleACMEEmail, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/setDomains", acmeAutoRenewer.HandleSetAutoRenewDomains, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/setEAB", acmeAutoRenewer.HanldeSetEAB, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/setDNS", acmeAutoRenewer.HandleSetDNS, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/listDomains", acmeAutoRenewer.HandleLoadAutoRenewDomains, auth.PermissionView)
+	authRouter.HandleFunc("/api/acme/autoRenew/renewPolicy", acmeAutoRenewer.HandleRenewPolicy, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/renewNow", acmeAutoRenewer.HandleRenewNow, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoRenew/schedule", acmeAutoRenewer.HandleRenewSchedule, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/autoHTTPS/status", HandleAutoHTTPSStatus, auth.PermissionCertManage)
+	authRouter.HandleFunc("/api/acme/dns/providers", acmedns.HandleServeProvidersJson, auth.PermissionView)
 	/* ACME Wizard */
-	authRouter.HandleFunc("/api/acme/wizard", acmewizard.HandleGuidedStepCheck)
+	authRouter.HandleFunc("/api/acme/wizard", acmewizard.HandleGuidedStepCheck, auth.PermissionCertManage)
 }
 
 // Register the APIs for Static Web Server management functions
 func RegisterStaticWebServerAPIs(authRouter *auth.RouterDef) {
 	/* Static Web Server Controls */
-	authRouter.HandleFunc("/api/webserv/status", staticWebServer.HandleGetStatus)
-	authRouter.HandleFunc("/api/webserv/start", staticWebServer.HandleStartServer)
-	authRouter.HandleFunc("/api/webserv/stop", staticWebServer.HandleStopServer)
-	authRouter.HandleFunc("/api/webserv/setPort", HandleStaticWebServerPortChange)
-	authRouter.HandleFunc("/api/webserv/setDirList", staticWebServer.SetEnableDirectoryListing)
-	authRouter.HandleFunc("/api/webserv/disableListenAllInterface", staticWebServer.SetDisableListenToAllInterface)
+	authRouter.HandleFunc("/api/webserv/status", staticWebServer.HandleGetStatus, auth.PermissionView)
+	authRouter.HandleFunc("/api/webserv/start", staticWebServer.HandleStartServer, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/stop", staticWebServer.HandleStopServer, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/setPort", HandleStaticWebServerPortChange, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/setDirList", staticWebServer.SetEnableDirectoryListing, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/disableListenAllInterface", staticWebServer.SetDisableListenToAllInterface, auth.PermissionSystemManage)
 
 	/* WebDAV Server Controls */
-	authRouter.HandleFunc("/api/webserv/webdav/start", staticWebServer.HandleStartWebDAV)
-	authRouter.HandleFunc("/api/webserv/webdav/stop", staticWebServer.HandleStopWebDAV)
-	authRouter.HandleFunc("/api/webserv/webdav/setPort", staticWebServer.HandleWebDAVPortChange)
-	authRouter.HandleFunc("/api/webserv/webdav/setUseCustomCredentials", staticWebServer.HandleSetUseCustomCredentials)
-	authRouter.HandleFunc("/api/webserv/webdav/setCustomCredentials", staticWebServer.HandleSetCustomCredentials)
+	authRouter.HandleFunc("/api/webserv/webdav/start", staticWebServer.HandleStartWebDAV, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/webdav/stop", staticWebServer.HandleStopWebDAV, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/webdav/setPort", staticWebServer.HandleWebDAVPortChange, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/webdav/setUseCustomCredentials", staticWebServer.HandleSetUseCustomCredentials, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/webserv/webdav/setCustomCredentials", staticWebServer.HandleSetCustomCredentials, auth.PermissionSystemManage)
 }
 
 // Register the APIs for Network Utilities functions
 func RegisterNetworkUtilsAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/tools/ipscan", ipscan.HandleIpScan)
-	authRouter.HandleFunc("/api/tools/portscan", ipscan.HandleScanPort)
-	authRouter.HandleFunc("/api/tools/traceroute", netutils.HandleTraceRoute)
-	authRouter.HandleFunc("/api/tools/ping", netutils.HandlePing)
-	authRouter.HandleFunc("/api/tools/whois", netutils.HandleWhois)
-	authRouter.HandleFunc("/api/tools/webssh", HandleCreateProxySession)
-	authRouter.HandleFunc("/api/tools/websshSupported", HandleWebSshSupportCheck)
-	authRouter.HandleFunc("/api/tools/wol", HandleWakeOnLan)
-	authRouter.HandleFunc("/api/tools/fwdproxy/enable", forwardProxy.HandleToogle)
-	authRouter.HandleFunc("/api/tools/fwdproxy/port", forwardProxy.HandlePort)
+	authRouter.HandleFunc("/api/tools/ipscan", ipscan.HandleIpScan, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/portscan", ipscan.HandleScanPort, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/traceroute", netutils.HandleTraceRoute, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/ping", netutils.HandlePing, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/whois", netutils.HandleWhois, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/webssh", HandleCreateProxySession, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/websshSupported", HandleWebSshSupportCheck, auth.PermissionView)
+	authRouter.HandleFunc("/api/tools/wol", HandleWakeOnLan, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/fwdproxy/enable", forwardProxy.HandleToogle, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/tools/fwdproxy/port", forwardProxy.HandlePort, auth.PermissionSystemManage)
 }
 
 func RegisterPluginAPIs(authRouter *auth.RouterDef) {
-	authRouter.HandleFunc("/api/plugins/list", pluginManager.HandleListPlugins)
-	authRouter.HandleFunc("/api/plugins/enable", pluginManager.HandleEnablePlugin)
-	authRouter.HandleFunc("/api/plugins/disable", pluginManager.HandleDisablePlugin)
-	authRouter.HandleFunc("/api/plugins/rebuild", pluginManager.HandleRebuildPlugin)
-	authRouter.HandleFunc("/api/plugins/icon", pluginManager.HandleLoadPluginIcon)
-	authRouter.HandleFunc("/api/plugins/info", pluginManager.HandlePluginInfo)
-
-	authRouter.HandleFunc("/api/plugins/groups/list", pluginManager.HandleListPluginGroups)
-	authRouter.HandleFunc("/api/plugins/groups/add", pluginManager.HandleAddPluginToGroup)
-	authRouter.HandleFunc("/api/plugins/groups/remove", pluginManager.HandleRemovePluginFromGroup)
-	authRouter.HandleFunc("/api/plugins/groups/deleteTag", pluginManager.HandleRemovePluginGroup)
-
-	authRouter.HandleFunc("/api/plugins/store/list", pluginManager.HandleListDownloadablePlugins)
-	authRouter.HandleFunc("/api/plugins/store/resync", pluginManager.HandleResyncPluginList)
-	authRouter.HandleFunc("/api/plugins/store/install", pluginManager.HandleInstallPlugin)
-	authRouter.HandleFunc("/api/plugins/store/uninstall", pluginManager.HandleUninstallPlugin)
-	authRouter.HandleFunc("/api/plugins/store/checkUpdates", pluginManager.HandleCheckPluginUpdates)
-	authRouter.HandleFunc("/api/plugins/store/update", pluginManager.HandleUpdatePlugin)
-	authRouter.HandleFunc("/api/plugins/store/sources", pluginManager.HandleListStoreSources)
-	authRouter.HandleFunc("/api/plugins/store/sources/add", pluginManager.HandleAddStoreSource)
-	authRouter.HandleFunc("/api/plugins/store/sources/remove", pluginManager.HandleRemoveStoreSource)
+	authRouter.HandleFunc("/api/plugins/list", pluginManager.HandleListPlugins, auth.PermissionView)
+	authRouter.HandleFunc("/api/plugins/enable", pluginManager.HandleEnablePlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/disable", pluginManager.HandleDisablePlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/rebuild", pluginManager.HandleRebuildPlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/icon", pluginManager.HandleLoadPluginIcon, auth.PermissionView)
+	authRouter.HandleFunc("/api/plugins/info", pluginManager.HandlePluginInfo, auth.PermissionView)
+
+	authRouter.HandleFunc("/api/plugins/groups/list", pluginManager.HandleListPluginGroups, auth.PermissionView)
+	authRouter.HandleFunc("/api/plugins/groups/add", pluginManager.HandleAddPluginToGroup, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/groups/remove", pluginManager.HandleRemovePluginFromGroup, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/groups/deleteTag", pluginManager.HandleRemovePluginGroup, auth.PermissionSystemManage)
+
+	authRouter.HandleFunc("/api/plugins/store/list", pluginManager.HandleListDownloadablePlugins, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/resync", pluginManager.HandleResyncPluginList, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/install", pluginManager.HandleInstallPlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/uninstall", pluginManager.HandleUninstallPlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/checkUpdates", pluginManager.HandleCheckPluginUpdates, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/update", pluginManager.HandleUpdatePlugin, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/sources", pluginManager.HandleListStoreSources, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/sources/add", pluginManager.HandleAddStoreSource, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/store/sources/remove", pluginManager.HandleRemoveStoreSource, auth.PermissionSystemManage)
 
 	// Developer options
-	authRouter.HandleFunc("/api/plugins/developer/enableAutoReload", pluginManager.HandleEnableHotReload)
-	authRouter.HandleFunc("/api/plugins/developer/setAutoReloadInterval", pluginManager.HandleSetHotReloadInterval)
+	authRouter.HandleFunc("/api/plugins/developer/enableAutoReload", pluginManager.HandleEnableHotReload, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/plugins/developer/setAutoReloadInterval", pluginManager.HandleSetHotReloadInterval, auth.PermissionSystemManage)
 }
 
 // Register the APIs for Auth functions, due to scoping issue some functions are defined here
@@ -342,6 +418,15 @@ func RegisterAuthAPIs(requireAuth bool, targetMux *http.ServeMux) {
 			utils.SendErrorResponse(w, "Root management account already exists")
 		}
 	})
+	targetMux.HandleFunc("/api/auth/permissions", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth {
+			//Authentication disabled, the current user is a full admin
+			js, _ := json.Marshal(&auth.AdminUser{Role: auth.RoleAdmin, Permissions: auth.RoleAdmin.Permissions()})
+			utils.SendJSONResponse(w, string(js))
+			return
+		}
+		authAgent.HandleGetCurrentUserPermissions(w, r)
+	})
 	targetMux.HandleFunc("/api/auth/changePassword", func(w http.ResponseWriter, r *http.Request) {
 		username, err := authAgent.GetUserName(w, r)
 		if err != nil {
@@ -373,10 +458,35 @@ func RegisterAuthAPIs(requireAuth bool, targetMux *http.ServeMux) {
 			return
 		}
 
-		//Change the password of the root user
-		authAgent.UnregisterUser(username)
-		authAgent.CreateUserAccount(username, newPassword, "")
+		//Change the password of the current user
+		err = authAgent.ChangePassword(username, newPassword)
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+		utils.SendOK(w)
 	})
+
+	//Management interface SSO login
+	targetMux.HandleFunc("/api/auth/sso/status", authAgent.HandleAdminSSOStatus)
+	targetMux.HandleFunc(auth.ADMIN_SSO_LOGIN_PATH, authAgent.HandleAdminSSOLogin)
+	targetMux.HandleFunc(auth.ADMIN_SSO_CALLBACK_PATH, authAgent.HandleAdminSSOCallback)
+
+	//Two factor authentication of the current user
+	targetMux.HandleFunc("/api/auth/totp/status", authAgent.HandleTOTPStatus)
+	targetMux.HandleFunc("/api/auth/totp/setup", authAgent.HandleTOTPSetup)
+	targetMux.HandleFunc("/api/auth/totp/enable", authAgent.HandleTOTPEnable)
+	targetMux.HandleFunc("/api/auth/totp/disable", authAgent.HandleTOTPDisable)
+}
+
+// Register the APIs for management account, role and scope functions
+func RegisterAdminAccountAPIs(authRouter *auth.RouterDef) {
+	authRouter.HandleFunc("/api/auth/users/list", authAgent.HandleListUsers, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/auth/users/add", authAgent.HandleAddUser, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/auth/users/update", authAgent.HandleUpdateUser, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/auth/users/delete", authAgent.HandleDeleteUser, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/auth/users/resetTotp", authAgent.HandleResetUserTOTP, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/auth/sso/settings", authAgent.HandleAdminSSOSettings, auth.PermissionSystemManage)
 }
 
 /* Register all the APIs */
@@ -388,6 +498,8 @@ func initAPIs(targetMux *http.ServeMux) {
 		DeniedHandler: func(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
 		},
+		EndpointResolver: resolveProxyEndpointScope,
+		AuditLogger:      configAuditLogger,
 	})
 
 	// Register the standard web services URLs
@@ -411,14 +523,19 @@ func initAPIs(targetMux *http.ServeMux) {
 	advHandler := FSHandler(staticWebRes)
 	targetMux.Handle("/", advHandler)
 
+	//Root certificate, CRL and ACME server of the local CA are public
+	targetMux.Handle(localca.PublicPathPrefix, localCA)
+
 	//Register the APIs
 	RegisterAuthAPIs(requireAuth, targetMux)
+	RegisterAdminAccountAPIs(authRouter)
 	RegisterHTTPProxyAPIs(authRouter)
 	RegisterTLSAPIs(authRouter)
 	RegisterAuthenticationHandlerAPIs(authRouter)
 	RegisterZorxAuthUserManagementAPIs(authRouter)
 	RegisterRedirectionAPIs(authRouter)
 	RegisterAccessRuleAPIs(authRouter)
+	RegisterWAFAPIs(authRouter)
 	RegisterPathRuleAPIs(authRouter)
 	RegisterStatisticalAPIs(authRouter)
 	RegisterStreamProxyAPIs(authRouter)
@@ -429,22 +546,24 @@ func initAPIs(targetMux *http.ServeMux) {
 	RegisterPluginAPIs(authRouter)
 
 	//Docker UX Optimizations
-	authRouter.HandleFunc("/api/docker/available", DockerUXOptimizer.HandleDockerAvailable)
-	authRouter.HandleFunc("/api/docker/containers", DockerUXOptimizer.HandleDockerContainersList)
+	authRouter.HandleFunc("/api/docker/available", DockerUXOptimizer.HandleDockerAvailable, auth.PermissionView)
+	authRouter.HandleFunc("/api/docker/containers", DockerUXOptimizer.HandleDockerContainersList, auth.PermissionProxyManage)
 
 	//Others
 	targetMux.HandleFunc("/api/info/x", HandleZoraxyInfo)
-	authRouter.HandleFunc("/api/info/geoip", HandleGeoIpLookup)
-	authRouter.HandleFunc("/api/info/ipcheck", HandleIpAccessCheck)
-	authRouter.HandleFunc("/api/conf/export", ExportConfigAsZip)
-	authRouter.HandleFunc("/api/conf/import", ImportConfigFromZip)
-	authRouter.HandleFunc("/api/log/list", LogViewer.HandleListLog)
-	authRouter.HandleFunc("/api/log/read", LogViewer.HandleReadLog)
-	authRouter.HandleFunc("/api/log/summary", LogViewer.HandleReadLogSummary)
-	authRouter.HandleFunc("/api/log/errors", LogViewer.HandleLogErrorSummary)
-	authRouter.HandleFunc("/api/log/rotate/trigger", SystemWideLogger.HandleDebugTriggerLogRotation)
-	authRouter.HandleFunc("/api/logger/config", handleLoggerConfig)
+	authRouter.HandleFunc("/api/info/geoip", HandleGeoIpLookup, auth.PermissionView)
+	authRouter.HandleFunc("/api/info/ipcheck", HandleIpAccessCheck, auth.PermissionView)
+	authRouter.HandleFunc("/api/conf/export", ExportConfigAsZip, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/conf/import", ImportConfigFromZip, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/log/list", LogViewer.HandleListLog, auth.PermissionView)
+	authRouter.HandleFunc("/api/log/read", LogViewer.HandleReadLog, auth.PermissionView)
+	authRouter.HandleFunc("/api/log/summary", LogViewer.HandleReadLogSummary, auth.PermissionView)
+	authRouter.HandleFunc("/api/log/errors", LogViewer.HandleLogErrorSummary, auth.PermissionView)
+	authRouter.HandleFunc("/api/log/rotate/trigger", SystemWideLogger.HandleDebugTriggerLogRotation, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/logger/config", handleLoggerConfig, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/audit/list", configAuditLogger.HandleQuery, auth.PermissionSystemManage)
+	authRouter.HandleFunc("/api/audit/export", configAuditLogger.HandleExport, auth.PermissionSystemManage)
 
 	//Debug
-	authRouter.HandleFunc("/api/info/pprof", pprof.Index)
+	authRouter.HandleFunc("/api/info/pprof", pprof.Index, auth.PermissionSystemManage)
 }
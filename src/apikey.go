@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/auth"
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	apikey.go
+
+	This script handle the API for managing the API keys of
+	proxy endpoints using the API key authentication method
+*/
+
+// parseAPIKeySettings read the key settings from the POST form
+func parseAPIKeySettings(r *http.Request) (*auth.ProxyAPIKey, error) {
+	settings := &auth.ProxyAPIKey{}
+	settings.Name, _ = utils.PostPara(r, "name")
+	settings.Owner, _ = utils.PostPara(r, "owner")
+	settings.AllowedEndpoints = splitAllowlist(r.PostForm.Get("endpoints"))
+	settings.AllowedCIDRs = splitAllowlist(r.PostForm.Get("cidrs"))
+	settings.Disabled, _ = utils.PostBool(r, "disabled")
+
+	rateLimit, err := utils.PostInt(r, "rateLimit")
+	if err == nil {
+		settings.RateLimit = int64(rateLimit)
+	}
+
+	expiresAt, err := utils.PostPara(r, "expiresAt")
+	if err == nil {
+		//Accept RFC3339 or date only from the date picker
+		expiry, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			expiry, err = time.ParseInLocation("2006-01-02", expiresAt, time.Local)
+			if err != nil {
+				return nil, auth.ErrAPIKeyInvalidConfig
+			}
+			expiry = expiry.Add(24*time.Hour - time.Second)
+		}
+		settings.ExpiresAt = expiry
+	}
+	return settings, nil
+}
+
+// handleAPIKeyList list all proxy API keys, without the key hashes
+func handleAPIKeyList(w http.ResponseWriter, r *http.Request) {
+	keys := proxyApiKeyManager.ListKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	js, _ := json.Marshal(keys)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleAPIKeyCreate create a new API key, the plain key is only returned once
+func handleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	settings, err := parseAPIKeySettings(r)
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid expiry date")
+		return
+	}
+
+	plainKey, key, err := proxyApiKeyManager.CreateKey(settings)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	SystemWideLogger.PrintAndLog("apikey", "API key created: "+key.Name+" ("+key.ID+")", nil)
+
+	js, _ := json.Marshal(struct {
+		ID  string
+		Key string
+	}{
+		ID:  key.ID,
+		Key: plainKey,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleAPIKeyUpdate update the settings of an existing API key
+func handleAPIKeyUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "id not defined")
+		return
+	}
+	settings, err := parseAPIKeySettings(r)
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid expiry date")
+		return
+	}
+
+	err = proxyApiKeyManager.UpdateKey(id, settings)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// handleAPIKeyRotate replace the key secret, the old secret stay valid for the grace period in seconds
+func handleAPIKeyRotate(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "id not defined")
+		return
+	}
+	gracePeriod, err := utils.PostInt(r, "gracePeriod")
+	if err != nil {
+		gracePeriod = 0
+	}
+
+	plainKey, err := proxyApiKeyManager.RotateKey(id, time.Duration(gracePeriod)*time.Second)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	SystemWideLogger.PrintAndLog("apikey", "API key rotated: "+id, nil)
+
+	js, _ := json.Marshal(struct {
+		ID  string
+		Key string
+	}{
+		ID:  id,
+		Key: plainKey,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleAPIKeyDelete remove an API key
+func handleAPIKeyDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "id not defined")
+		return
+	}
+	err = proxyApiKeyManager.DeleteKey(id)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	SystemWideLogger.PrintAndLog("apikey", "API key deleted: "+id, nil)
+	utils.SendOK(w)
+}
+
+// handleAPIKeyEndpointConfig get or set where the API key is read from for a proxy endpoint
+func handleAPIKeyEndpointConfig(w http.ResponseWriter, r *http.Request) {
+	domain, err := utils.PostPara(r, "domain")
+	if err != nil {
+		domain, err = utils.GetPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain or matching rule not defined")
+			return
+		}
+	}
+
+	targetProxyEndpoint, err := dynamicProxyRouter.LoadProxy(domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "target endpoint not exists")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		header := dynamicproxy.DefaultAPIKeyHeader
+		queryParam := ""
+		useTrustedProxy := false
+		if targetProxyEndpoint.AuthenticationProvider != nil {
+			if targetProxyEndpoint.AuthenticationProvider.APIKeyHeader != "" {
+				header = targetProxyEndpoint.AuthenticationProvider.APIKeyHeader
+			}
+			queryParam = targetProxyEndpoint.AuthenticationProvider.APIKeyQueryParam
+			useTrustedProxy = targetProxyEndpoint.AuthenticationProvider.APIKeyUseTrustedProxy
+		}
+
+		js, _ := json.Marshal(struct {
+			Header          string
+			QueryParam      string
+			UseTrustedProxy bool
+		}{
+			Header:          header,
+			QueryParam:      queryParam,
+			UseTrustedProxy: useTrustedProxy,
+		})
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method == http.MethodPost {
+		header, err := utils.PostPara(r, "header")
+		if err != nil {
+			header = dynamicproxy.DefaultAPIKeyHeader
+		}
+		header = strings.TrimSpace(header)
+		queryParam := strings.TrimSpace(r.PostForm.Get("queryParam"))
+		if strings.ContainsAny(header, " \t\r\n:") || strings.ContainsAny(queryParam, " &=?#") {
+			utils.SendErrorResponse(w, "invalid header or query parameter name")
+			return
+		}
+		if strings.EqualFold(header, "Authorization") || strings.EqualFold(header, "Cookie") {
+			utils.SendErrorResponse(w, "header "+header+" cannot be used for API keys")
+			return
+		}
+		useTrustedProxy, _ := utils.PostBool(r, "useTrustedProxy")
+
+		if targetProxyEndpoint.AuthenticationProvider == nil {
+			targetProxyEndpoint.AuthenticationProvider = &dynamicproxy.AuthenticationProvider{
+				AuthMethod:              dynamicproxy.AuthMethodNone,
+				BasicAuthCredentials:    []*dynamicproxy.BasicAuthCredentials{},
+				BasicAuthExceptionRules: []*dynamicproxy.BasicAuthExceptionRule{},
+			}
+		}
+		targetProxyEndpoint.AuthenticationProvider.APIKeyHeader = header
+		targetProxyEndpoint.AuthenticationProvider.APIKeyQueryParam = queryParam
+		targetProxyEndpoint.AuthenticationProvider.APIKeyUseTrustedProxy = useTrustedProxy
+
+		err = SaveReverseProxyConfig(targetProxyEndpoint)
+		if err != nil {
+			utils.SendErrorResponse(w, "save API key config failed: "+err.Error())
+			return
+		}
+		targetProxyEndpoint.UpdateToRuntime()
+		utils.SendOK(w)
+		return
+	}
+
+	http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/auditlog"
+	"imuslab.com/zoraxy/mod/auth/sso/zorxauth"
+	"imuslab.com/zoraxy/mod/eventsystem"
+	"imuslab.com/zoraxy/mod/plugins/zoraxy_plugin/events"
+)
+
+/*
+	auditlog.go
+
+	This script provide the configuration snapshots for the
+	management API audit log and forward recorded changes
+	to the event system
+
+	Routes without a snapshot are still recorded, but without a diff.
+	These are actions that do not change the configuration itself, e.g.
+	revoking SSO sessions, sending invites or resetting statistics, and
+	config imports which replace the whole config and need a restart.
+	Routes that never change anything, e.g. network tools and connection
+	tests, are not recorded at all, see isUnauditedRoute
+*/
+
+// certificateSnapshot is the audited state of a certificate in the cert store
+type certificateSnapshot struct {
+	Fingerprint string
+	DNSNames    []string
+	NotAfter    int64
+}
+
+// snapshotAuditedConfig return the configuration that might be changed by the route
+func snapshotAuditedConfig(route string, targets []string) interface{} {
+	if len(targets) > 0 {
+		//Routes scoped to proxy endpoints
+		endpoints := map[string]interface{}{}
+		for _, target := range targets {
+			endpoint, err := dynamicProxyRouter.LoadProxy(target)
+			if err != nil {
+				continue
+			}
+			endpoints[endpoint.RootOrMatchingDomain] = endpoint
+		}
+		return endpoints
+	}
+
+	switch {
+	case strings.HasPrefix(route, "/api/access/"),
+		strings.HasPrefix(route, "/api/blacklist/"),
+		strings.HasPrefix(route, "/api/whitelist/"):
+		rules := map[string]interface{}{}
+		for _, rule := range accessController.ListAllAccessRules() {
+			rules[rule.ID] = rule
+		}
+		return rules
+	case strings.HasPrefix(route, "/api/trustedproxy/"):
+		return accessController.ListTrustedProxies()
+	case strings.HasPrefix(route, "/api/cert/"), route == "/api/acme/obtainCert":
+		return snapshotCertificates()
+	case strings.HasPrefix(route, "/api/redirect/"):
+		return redirectTable.GetAllRedirectRules()
+	case strings.HasPrefix(route, "/api/pathrule/"):
+		return pathRuleHandler.ListBlockingPath()
+	case strings.HasPrefix(route, "/api/streamprox/config/"):
+		return streamProxyManager.Configs
+	case strings.HasPrefix(route, "/api/auth/users/"):
+		users := map[string]interface{}{}
+		for _, username := range authAgent.ListUsers() {
+			users[username] = authAgent.GetAdminUser(username)
+		}
+		return users
+	case strings.HasPrefix(route, "/api/auth/sso/"):
+		settings := *authAgent.GetAdminSSOSettings()
+		settings.ClientSecret = ""
+		return settings
+	case route == "/api/waf/rules/reload":
+		return snapshotWAFRules()
+	case strings.HasPrefix(route, "/api/mtls/"):
+		return snapshotFolderFiles(CONF_MTLS_STORE)
+	case strings.HasPrefix(route, "/api/localca/"):
+		if localCA == nil {
+			return nil
+		}
+		return map[string]interface{}{
+			"Config": localCA.GetConfig(),
+			"Issued": localCA.ListIssued(),
+		}
+	case strings.HasPrefix(route, "/api/apikey/"):
+		keys := map[string]interface{}{}
+		for _, key := range proxyApiKeyManager.ListKeys() {
+			keys[key.ID] = key
+		}
+		return keys
+	case route == "/api/sso/forward-auth":
+		return snapshotDatabaseTable("auth_sso_forward", nil)
+	case route == "/api/sso/OAuth2":
+		return snapshotDatabaseTable("oauth2", nil)
+	case strings.HasPrefix(route, "/api/sso/zorxauth/users/"):
+		return snapshotDatabaseTable(zorxauth.DB_USERS_TABLE, nil)
+	case strings.HasPrefix(route, "/api/sso/zorxauth/oidc/"):
+		return snapshotDatabaseTable(zorxauth.DB_OIDC_CLIENTS_TABLE, nil)
+	case strings.HasPrefix(route, "/api/sso/zorxauth/sessions/"),
+		strings.HasPrefix(route, "/api/sso/zorxauth/invites/"):
+		//Session and invite tokens are not configuration
+		return nil
+	case strings.HasPrefix(route, "/api/sso/zorxauth/"):
+		//Provider, gateway, group policy, LDAP and email settings
+		return snapshotDatabaseTable(zorxauth.DB_NAME, nil)
+	case route == "/api/acme/autoRenew/ca":
+		return snapshotDatabaseTable("acmepref", func(key string) bool {
+			//Only the preferred CA, the rest of the table are ACME accounts
+			return key == "prefca" || key == "prefcaurl" || key == "skipTLS"
+		})
+	case route == "/api/acme/autoRenew/renewNow", route == "/api/acme/autoRenew/schedule":
+		return snapshotCertificates()
+	case strings.HasPrefix(route, "/api/acme/autoRenew/"):
+		return map[string]interface{}{
+			"Config":   acmeAutoRenewer.RenewerConfig,
+			"Settings": snapshotDatabaseTable("acme", nil),
+		}
+	case strings.HasPrefix(route, "/api/webserv/"):
+		return snapshotDatabaseTable("webserv", nil)
+	case strings.HasPrefix(route, "/api/plugins/"):
+		return snapshotDatabaseTable("plugins", nil)
+	case strings.HasPrefix(route, "/api/tools/fwdproxy/"):
+		return snapshotDatabaseTable("fwdproxy", nil)
+	case route == "/api/tools/wol":
+		return snapshotDatabaseTable("wolmac", nil)
+	case route == "/api/logger/config":
+		return snapshotJSONFile(CONF_LOG_CONFIG)
+	}
+	return nil
+}
+
+// isUnauditedRoute checks if the route never changes the configuration,
+// calls to these routes are not written to the audit log
+func isUnauditedRoute(route string) bool {
+	switch route {
+	case "/api/tools/wol", "/api/tools/fwdproxy/enable", "/api/tools/fwdproxy/port":
+		return false
+	case "/api/mdns/discover",
+		"/api/docker/containers",
+		"/api/conf/export",
+		"/api/acme/wizard",
+		"/api/acme/autoHTTPS/status",
+		"/api/sso/zorxauth/ldap/test",
+		"/api/sso/zorxauth/email/test",
+		"/api/plugins/store/list",
+		"/api/plugins/store/checkUpdates",
+		"/api/plugins/store/sources":
+		return true
+	}
+	return strings.HasPrefix(route, "/api/tools/") ||
+		strings.HasPrefix(route, "/api/audit/") ||
+		strings.HasPrefix(route, "/api/info/") ||
+		(strings.HasPrefix(route, "/api/sso/zorxauth/") && strings.HasSuffix(route, "/list"))
+}
+
+// snapshotDatabaseTable return the entries of a system database table, filtered by keep if set
+func snapshotDatabaseTable(table string, keep func(key string) bool) map[string]interface{} {
+	entries := map[string]interface{}{}
+	if !sysdb.TableExists(table) {
+		return entries
+	}
+	rows, err := sysdb.ListTable(table)
+	if err != nil {
+		return entries
+	}
+	for _, row := range rows {
+		key := string(row[0])
+		if keep != nil && !keep(key) {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(row[1], &value); err != nil {
+			continue
+		}
+		entries[key] = value
+	}
+	return entries
+}
+
+// snapshotFolderFiles return the SHA-256 of every file in the folder, e.g. mTLS CA bundles and CRLs
+func snapshotFolderFiles(folder string) map[string]string {
+	files := map[string]string{}
+	dirEntries, err := os.ReadDir(folder)
+	if err != nil {
+		return files
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(folder, dirEntry.Name()))
+		if err != nil {
+			continue
+		}
+		hash := sha256.Sum256(content)
+		files[dirEntry.Name()] = hex.EncodeToString(hash[:])
+	}
+	return files
+}
+
+// snapshotJSONFile return the content of a JSON config file
+func snapshotJSONFile(filename string) interface{} {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// snapshotWAFRules return the loaded WAF rules by rule ID
+func snapshotWAFRules() map[string]interface{} {
+	rules := map[string]interface{}{}
+	for _, rule := range wafEngine.GetRules() {
+		if rule.ID == 0 {
+			//Chained rules and markers
+			continue
+		}
+		rules[strconv.Itoa(rule.ID)] = map[string]interface{}{
+			"Msg":      rule.Msg,
+			"Severity": rule.Severity,
+			"File":     rule.File,
+		}
+	}
+	return rules
+}
+
+// snapshotCertificates return the fingerprint and validity of every certificate in the cert store
+func snapshotCertificates() map[string]*certificateSnapshot {
+	certs := map[string]*certificateSnapshot{}
+	filenames, err := tlsCertManager.ListCertDomains()
+	if err != nil {
+		return certs
+	}
+	for _, filename := range filenames {
+		certBytes, err := os.ReadFile(filepath.Join(tlsCertManager.CertStore, filename+".pem"))
+		if err != nil {
+			continue
+		}
+		snapshot := &certificateSnapshot{}
+		block, _ := pem.Decode(certBytes)
+		if block != nil {
+			hash := sha256.Sum256(block.Bytes)
+			snapshot.Fingerprint = hex.EncodeToString(hash[:])
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				snapshot.DNSNames = cert.DNSNames
+				snapshot.NotAfter = cert.NotAfter.Unix()
+			}
+		}
+		certs[filename] = snapshot
+	}
+	return certs
+}
+
+// emitConfigChangedEvent forward a recorded configuration change to the event system
+func emitConfigChangedEvent(entry *auditlog.Entry) {
+	if eventsystem.Publisher == nil {
+		return
+	}
+	changes := []*events.ConfigChange{}
+	for _, change := range entry.Changes {
+		changes = append(changes, &events.ConfigChange{
+			Path:   change.Path,
+			Before: change.Before,
+			After:  change.After,
+		})
+	}
+	eventsystem.Publisher.Emit(&events.ConfigChangedEvent{
+		AuditID:  entry.ID,
+		User:     entry.User,
+		SourceIP: entry.SourceIP,
+		Method:   entry.Method,
+		Route:    entry.Route,
+		Targets:  entry.Targets,
+		Changes:  changes,
+	})
+}
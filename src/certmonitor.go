@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/x509"
+
+	"imuslab.com/zoraxy/mod/tlscert/certmonitor"
+)
+
+/*
+	certmonitor.go
+
+	Glue between the certificate monitor, the proxy router and the
+	certificate issuers. Only endpoints served over TLS are checked
+	for hostname mismatches.
+*/
+
+// certMonitorListEndpoints lists the enabled proxy endpoints and their hostnames
+func certMonitorListEndpoints() []*certmonitor.Endpoint {
+	endpoints := []*certmonitor.Endpoint{}
+	if dynamicProxyRouter == nil || !dynamicProxyRouter.Option.UseTls {
+		return endpoints
+	}
+	for _, ep := range dynamicProxyRouter.GetProxyEndpointsAsMap() {
+		if ep.Disabled {
+			continue
+		}
+		endpoints = append(endpoints, &certmonitor.Endpoint{
+			Name:      ep.RootOrMatchingDomain,
+			Hostnames: append([]string{ep.RootOrMatchingDomain}, ep.MatchingDomainAlias...),
+		})
+	}
+	return endpoints
+}
+
+// certMonitorLookupCertificate returns the certificate served for the hostname
+func certMonitorLookupCertificate(hostname string) (string, *x509.Certificate) {
+	return tlsCertManager.LookupServedCertificate(hostname)
+}
+
+// reportAutoHTTPSFailure alerts a failed on-demand auto HTTPS issuance
+func reportAutoHTTPSFailure(domain string, err error) {
+	if certMonitor == nil {
+		return
+	}
+	//The manager records the failure after the issuer returns
+	failures := 1
+	for _, status := range tlsCertManager.GetAutoHTTPSStatus() {
+		if status.Domain == domain {
+			failures = status.Failures + 1
+			break
+		}
+	}
+	certMonitor.ReportRenewalFailure(domain, []string{domain}, "auto-https", failures, err)
+}
@@ -16,6 +16,7 @@ import (
 	"imuslab.com/zoraxy/mod/dynamicproxy"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
 	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/secrets"
 	"imuslab.com/zoraxy/mod/tlscert"
 	"imuslab.com/zoraxy/mod/utils"
 )
@@ -56,6 +57,12 @@ func LoadReverseProxyConfig(configFilepath string) error {
 		return err
 	}
 
+	//Decrypt the secrets sealed by SaveReverseProxyConfig
+	err = openProxyEndpointSecrets(&thisConfigEndpoint)
+	if err != nil {
+		return err
+	}
+
 	//Make sure the tags are not nil
 	if thisConfigEndpoint.Tags == nil {
 		thisConfigEndpoint.Tags = []string{}
@@ -116,6 +123,12 @@ func SaveReverseProxyConfig(endpoint *dynamicproxy.ProxyEndpoint) error {
 
 	filename = filterProxyConfigFilename(filename)
 
+	//Seal the secrets if secrets encryption is enabled
+	endpoint, err := sealProxyEndpointSecrets(endpoint)
+	if err != nil {
+		return err
+	}
+
 	//Save config to file
 	js, err := json.MarshalIndent(endpoint, "", " ")
 	if err != nil {
@@ -139,6 +152,78 @@ func RemoveReverseProxyConfig(endpoint string) error {
 	return os.Remove(filename)
 }
 
+// sealProxyEndpointSecrets returns a copy of the endpoint with the CAPTCHA
+// secret key and basic auth password hashes sealed for saving
+func sealProxyEndpointSecrets(endpoint *dynamicproxy.ProxyEndpoint) (*dynamicproxy.ProxyEndpoint, error) {
+	if !secrets.Enabled() {
+		return endpoint, nil
+	}
+	var err error
+	sealed := *endpoint
+	if endpoint.CaptchaConfig != nil {
+		captchaConfig := *endpoint.CaptchaConfig
+		if captchaConfig.SecretKey, err = secrets.SealString(captchaConfig.SecretKey); err != nil {
+			return nil, err
+		}
+		sealed.CaptchaConfig = &captchaConfig
+	}
+	if endpoint.AuthenticationProvider != nil {
+		authProvider := *endpoint.AuthenticationProvider
+		authProvider.BasicAuthCredentials = []*dynamicproxy.BasicAuthCredentials{}
+		for _, cred := range endpoint.AuthenticationProvider.BasicAuthCredentials {
+			sealedCred := *cred
+			if sealedCred.PasswordHash, err = secrets.SealString(cred.PasswordHash); err != nil {
+				return nil, err
+			}
+			authProvider.BasicAuthCredentials = append(authProvider.BasicAuthCredentials, &sealedCred)
+		}
+		if endpoint.AuthenticationProvider.JWTAuthConfig != nil {
+			jwtConfig := *endpoint.AuthenticationProvider.JWTAuthConfig
+			if jwtConfig.Secret, err = secrets.SealString(jwtConfig.Secret); err != nil {
+				return nil, err
+			}
+			authProvider.JWTAuthConfig = &jwtConfig
+		}
+		if endpoint.AuthenticationProvider.OAuth2ClaimForwarding != nil {
+			claimForwarding := *endpoint.AuthenticationProvider.OAuth2ClaimForwarding
+			if claimForwarding.SignedJWTSecret, err = secrets.SealString(claimForwarding.SignedJWTSecret); err != nil {
+				return nil, err
+			}
+			authProvider.OAuth2ClaimForwarding = &claimForwarding
+		}
+		sealed.AuthenticationProvider = &authProvider
+	}
+	return &sealed, nil
+}
+
+// openProxyEndpointSecrets decrypts the secrets of a loaded endpoint in place
+func openProxyEndpointSecrets(endpoint *dynamicproxy.ProxyEndpoint) error {
+	var err error
+	if endpoint.CaptchaConfig != nil {
+		if endpoint.CaptchaConfig.SecretKey, err = secrets.OpenString(endpoint.CaptchaConfig.SecretKey); err != nil {
+			return errors.New("unable to decrypt CAPTCHA secret key: " + err.Error())
+		}
+	}
+	if endpoint.AuthenticationProvider != nil {
+		for _, cred := range endpoint.AuthenticationProvider.BasicAuthCredentials {
+			if cred.PasswordHash, err = secrets.OpenString(cred.PasswordHash); err != nil {
+				return errors.New("unable to decrypt basic auth credential of " + cred.Username + ": " + err.Error())
+			}
+		}
+		if jwtConfig := endpoint.AuthenticationProvider.JWTAuthConfig; jwtConfig != nil {
+			if jwtConfig.Secret, err = secrets.OpenString(jwtConfig.Secret); err != nil {
+				return errors.New("unable to decrypt JWT shared secret: " + err.Error())
+			}
+		}
+		if claimForwarding := endpoint.AuthenticationProvider.OAuth2ClaimForwarding; claimForwarding != nil {
+			if claimForwarding.SignedJWTSecret, err = secrets.OpenString(claimForwarding.SignedJWTSecret); err != nil {
+				return errors.New("unable to decrypt claim forwarding JWT secret: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
 // Get the default root config that point to the internal static web server
 // this will be used if root config is not found (new deployment / missing root.config file)
 func GetDefaultRootConfig() (*dynamicproxy.ProxyEndpoint, error) {
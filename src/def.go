@@ -17,13 +17,16 @@ import (
 
 	"imuslab.com/zoraxy/mod/access"
 	"imuslab.com/zoraxy/mod/acme"
+	"imuslab.com/zoraxy/mod/auditlog"
 	"imuslab.com/zoraxy/mod/auth"
 	"imuslab.com/zoraxy/mod/auth/sso/forward"
 	"imuslab.com/zoraxy/mod/auth/sso/zorxauth"
 	"imuslab.com/zoraxy/mod/database"
 	"imuslab.com/zoraxy/mod/dockerux"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
 	"imuslab.com/zoraxy/mod/dynamicproxy/redirection"
+	"imuslab.com/zoraxy/mod/dynamicproxy/waf"
 	"imuslab.com/zoraxy/mod/forwardproxy"
 	"imuslab.com/zoraxy/mod/geodb"
 	"imuslab.com/zoraxy/mod/info/logger"
@@ -37,6 +40,8 @@ import (
 	"imuslab.com/zoraxy/mod/statistic/analytic"
 	"imuslab.com/zoraxy/mod/streamproxy"
 	"imuslab.com/zoraxy/mod/tlscert"
+	"imuslab.com/zoraxy/mod/tlscert/certmonitor"
+	"imuslab.com/zoraxy/mod/tlscert/localca"
 	"imuslab.com/zoraxy/mod/uptime"
 	"imuslab.com/zoraxy/mod/webserv"
 )
@@ -72,6 +77,7 @@ var (
 	mdnsName                   = flag.String("mdnsname", "", "mDNS name, leave empty to use default (zoraxy_{node-uuid}.local)")
 	runningInDocker            = flag.Bool("docker", false, "Run Zoraxy in docker compatibility mode")
 	enableHighSpeedGeoIPLookup = flag.Bool("fastgeoip", true, "Enable high speed geoip lookup, disable this if you are using a very small memory footprint device")
+	geoIPMMDBPath              = flag.String("geoip_mmdb", "", "Path to a GeoLite2 or DB-IP MMDB file for region and city level GeoIP lookup, leave empty to use built-in GeoIP data")
 	enableAutoUpdate           = flag.Bool("cfgupgrade", true, "Enable auto config upgrade if breaking change is detected")
 
 	/* Acme Configuration Flags */
@@ -102,6 +108,12 @@ var (
 	proxyH2ConnBufferSize   = flag.Int("h2_conn_buffer", 0, "HTTP/2 max upload buffer per connection in bytes, min 65536 (0 = Go default)")
 	proxyH2StreamBufferSize = flag.Int("h2_stream_buffer", 0, "HTTP/2 max upload buffer per stream in bytes, min 65536 (0 = Go default)")
 
+	/* Secrets Encryption Flags */
+	encryptSecrets   = flag.Bool("encrypt_secrets", false, "Encrypt private keys and secrets at rest with a master key, existing plaintext secrets are encrypted on startup")
+	masterKeyFile    = flag.String("master_key_file", "", "Master key file of the encrypted secrets, falls back to the ZORAXY_MASTER_KEY environment variable or a terminal prompt")
+	rekeySecrets     = flag.Bool("rekey", false, "Re-encrypt the data key of the encrypted secrets with a new master key and exit")
+	newMasterKeyFile = flag.String("new_master_key_file", "", "New master key file for -rekey, falls back to the ZORAXY_NEW_MASTER_KEY environment variable or a terminal prompt")
+
 	/* Path Configuration Flags */
 	path_database  = flag.String("dbpath", "./sys.db", "Database path")
 	path_conf      = flag.String("conf", "./conf", "Configuration folder path")
@@ -138,6 +150,11 @@ var (
 	CONF_GEODB_PATH            string //GeoIP database path
 	CONF_LOG_CONFIG            string //Log configuration path
 	CONF_TRUSTED_PROXIES       string //Trusted proxy IPs configuration path
+	CONF_WAF_RULES             string //Custom WAF rules folder path
+	CONF_MTLS_STORE            string //Client CA bundles and CRLs for mTLS
+	CONF_LOCAL_CA              string //Local certificate authority keys and state
+	CONF_CERT_MONITOR          string //Certificate monitor config and alert state
+	CONF_SECRETS_KEYRING       string //Wrapped data key of the encrypted secrets
 
 	/* mDNS */
 	previousmdnsScanResults = []*mdns.NetworkHost{}
@@ -162,6 +179,10 @@ var (
 	pathRuleHandler    *pathrule.Handler         //Handle specific path blocking or custom headers
 	geodbStore         *geodb.Store              //GeoIP database, for resolving IP into country code
 	accessController   *access.Controller        //Access controller, handle black list and white list
+	wafEngine          *waf.Engine               //Web application firewall rule engine
+	clientCertStore    *mtls.Store               //Client CA bundles and CRLs for mTLS endpoints
+	localCA            *localca.CA               //Built-in private certificate authority for internal services
+	certMonitor        *certmonitor.Monitor      //Certificate expiry, renewal failure and hostname mismatch alerts
 	netstatBuffers     *netstat.NetStatBuffers   //Realtime graph buffers
 	statisticCollector *statistic.Collector      //Collecting statistic from visitors
 	uptimeMonitor      *uptime.Monitor           //Uptime monitor service worker
@@ -174,14 +195,16 @@ var (
 	forwardProxy       *forwardproxy.Handler     //HTTP Forward proxy, basically VPN for web browser
 	loadBalancer       *loadbalance.RouteManager //Global scope loadbalancer, store the state of the lb routing
 	pluginManager      *plugins.Manager          //Plugin manager for managing plugins
+	configAuditLogger  *auditlog.Logger          //Audit log of configuration changes made through the management API
 
 	//Plugin auth related
 	pluginApiKeyManager *auth.APIKeyManager //API key manager for plugin authentication
 
 	//Authentication Provider
-	forwardAuthRouter *forward.AuthRouter  // Forward Auth router for Authelia/Authentik/etc authentication
-	oauth2Router      *oauth2.OAuth2Router //OAuth2Router router for OAuth2Router authentication
-	zorxAuthRouter    *zorxauth.AuthRouter //ZorxAuth router for ZorxAuth SSO authentication
+	forwardAuthRouter  *forward.AuthRouter      // Forward Auth router for Authelia/Authentik/etc authentication
+	oauth2Router       *oauth2.OAuth2Router     //OAuth2Router router for OAuth2Router authentication
+	zorxAuthRouter     *zorxauth.AuthRouter     //ZorxAuth router for ZorxAuth SSO authentication
+	proxyApiKeyManager *auth.ProxyAPIKeyManager //API keys for proxy endpoints using API key authentication
 
 	//Helper modules
 	AnalyticLoader    *analytic.DataLoader  //Data loader for Zoraxy Analytic
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	jwtauth.go
+
+	This script handle the per endpoint settings of the
+	JWT bearer token authentication method
+*/
+
+// handleJWTAuthEndpointConfig get or set the JWT bearer auth settings of a proxy endpoint
+func handleJWTAuthEndpointConfig(w http.ResponseWriter, r *http.Request) {
+	domain, err := utils.PostPara(r, "domain")
+	if err != nil {
+		domain, err = utils.GetPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain or matching rule not defined")
+			return
+		}
+	}
+
+	targetProxyEndpoint, err := dynamicProxyRouter.LoadProxy(domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "target endpoint not exists")
+		return
+	}
+
+	var currentConfig *oidc.BearerConfig
+	if targetProxyEndpoint.AuthenticationProvider != nil {
+		currentConfig = targetProxyEndpoint.AuthenticationProvider.JWTAuthConfig
+	}
+
+	if r.Method == http.MethodGet {
+		config := &oidc.BearerConfig{
+			KeySource:      oidc.BearerKeySourceJWKS,
+			RequiredScopes: []string{},
+			ForwardHeaders: []*oidc.ClaimHeader{},
+		}
+		if currentConfig != nil {
+			//Do not send the shared secret back to the UI
+			copied := *currentConfig
+			copied.Secret = ""
+			config = &copied
+		}
+
+		js, _ := json.Marshal(struct {
+			Config    *oidc.BearerConfig
+			SecretSet bool
+		}{
+			Config:    config,
+			SecretSet: currentConfig != nil && currentConfig.Secret != "",
+		})
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method == http.MethodPost {
+		configJSON, err := utils.PostPara(r, "config")
+		if err != nil {
+			utils.SendErrorResponse(w, "config not defined")
+			return
+		}
+		newConfig := &oidc.BearerConfig{}
+		err = json.Unmarshal([]byte(configJSON), newConfig)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid JWT auth config")
+			return
+		}
+
+		newConfig.JwksURL = strings.TrimSpace(newConfig.JwksURL)
+		newConfig.Issuer = strings.TrimSpace(newConfig.Issuer)
+		newConfig.Audience = strings.TrimSpace(newConfig.Audience)
+		if newConfig.RequiredScopes == nil {
+			newConfig.RequiredScopes = []string{}
+		}
+		if newConfig.ForwardHeaders == nil {
+			newConfig.ForwardHeaders = []*oidc.ClaimHeader{}
+		}
+
+		//Keep the existing secret if it is not changed in the UI
+		if newConfig.KeySource == oidc.BearerKeySourceSecret && newConfig.Secret == "" && currentConfig != nil {
+			newConfig.Secret = currentConfig.Secret
+		}
+		err = newConfig.Validate()
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+
+		//Only keep the key material of the selected source
+		switch newConfig.KeySource {
+		case oidc.BearerKeySourceSecret:
+			newConfig.PublicKey = ""
+			newConfig.JwksURL = ""
+		case oidc.BearerKeySourcePublicKey:
+			newConfig.Secret = ""
+			newConfig.JwksURL = ""
+		case oidc.BearerKeySourceJWKS:
+			newConfig.Secret = ""
+			newConfig.PublicKey = ""
+		}
+
+		if targetProxyEndpoint.AuthenticationProvider == nil {
+			targetProxyEndpoint.AuthenticationProvider = &dynamicproxy.AuthenticationProvider{
+				AuthMethod:              dynamicproxy.AuthMethodNone,
+				BasicAuthCredentials:    []*dynamicproxy.BasicAuthCredentials{},
+				BasicAuthExceptionRules: []*dynamicproxy.BasicAuthExceptionRule{},
+			}
+		}
+		targetProxyEndpoint.AuthenticationProvider.JWTAuthConfig = newConfig
+
+		err = SaveReverseProxyConfig(targetProxyEndpoint)
+		if err != nil {
+			utils.SendErrorResponse(w, "save JWT auth config failed: "+err.Error())
+			return
+		}
+		targetProxyEndpoint.UpdateToRuntime()
+		utils.SendOK(w)
+		return
+	}
+
+	http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+)
+
+/*
+	localca.go
+
+	Glue between the built-in local CA, the TLS certificate manager and
+	the proxy router. Certificates are only issued for internal names
+	that are configured as proxy endpoints.
+*/
+
+// isLocalCAHostname checks if the local CA should sign a certificate for the SNI hostname
+func isLocalCAHostname(hostname string) bool {
+	return localCA.AutoIssueEnabled() && localCA.IsAllowedHostname(hostname) && dynamicProxyRouter.IsEndpointHostname(hostname)
+}
+
+// localCARegisterSpecialRoutingRule serves the root certificate, CRL and
+// ACME server of the local CA on the proxy listeners
+func localCARegisterSpecialRoutingRule() {
+	err := dynamicProxyRouter.AddRoutingRules(&dynamicproxy.RoutingRule{
+		ID: "local-ca",
+		MatchRule: func(r *http.Request) bool {
+			return localCA.ShouldServe(r)
+		},
+		RoutingHandler:         localCA.ServeHTTP,
+		Enabled:                true,
+		UseSystemAccessControl: true,
+	})
+	if err != nil {
+		SystemWideLogger.PrintAndLog("local-ca", "Unable to register local CA routing rule", err)
+	}
+}
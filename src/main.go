@@ -77,6 +77,11 @@ func main() {
 	CONF_LOG_CONFIG = CONF_FOLDER + "/log_conf.json"
 	ACME_AUTORENEW_CONFIG_PATH = CONF_FOLDER + "/acme_conf.json"
 	CONF_TRUSTED_PROXIES = CONF_FOLDER + "/trusted_proxies.json"
+	CONF_WAF_RULES = CONF_FOLDER + "/waf"
+	CONF_MTLS_STORE = CONF_FOLDER + "/mtls"
+	CONF_LOCAL_CA = CONF_FOLDER + "/localca"
+	CONF_CERT_MONITOR = CONF_FOLDER + "/certmonitor"
+	CONF_SECRETS_KEYRING = CONF_FOLDER + "/keyring.json"
 
 	/* Maintaince Function Modes */
 	if *showver {
@@ -87,6 +92,15 @@ func main() {
 		geodb.DownloadGeoDBUpdate(CONF_GEODB_PATH)
 		os.Exit(0)
 	}
+	if *rekeySecrets {
+		//Rewrap the data key of the encrypted secrets with a new master key
+		if err := rekeySecretsKeyring(); err != nil {
+			fmt.Println("Unable to re-key encrypted secrets: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Encrypted secrets re-keyed, start Zoraxy with the new master key")
+		os.Exit(0)
+	}
 	if *reset_account {
 		//Reset admin account by removing all users from the system
 		auth.ResetAccount(databaseBackend, path_database)
@@ -104,6 +118,12 @@ func main() {
 		update.RunConfigUpdate(0, update.GetVersionIntFromVersionNumber(SYSTEM_VERSION))
 	}
 
+	//Unlock the encrypted secrets before any module reads them
+	if err := initSecretsEncryption(); err != nil {
+		fmt.Println("Unable to unlock encrypted secrets: " + err.Error())
+		os.Exit(1)
+	}
+
 	SetupCloseHandler()
 
 	//Read or create the system uuid
@@ -46,6 +46,10 @@ func NewAccessController(options *Options) (*Controller, error) {
 		WhiteListIP:           &map[string]string{},
 		BlackListContryCode:   &map[string]string{},
 		BlackListIP:           &map[string]string{},
+		WhiteListASN:          &map[string]string{},
+		BlackListASN:          &map[string]string{},
+		WhiteListRegion:       &map[string]string{},
+		BlackListRegion:       &map[string]string{},
 	}
 	defaultRuleSettingFile := filepath.Join(confFolder, "default.json")
 	if utils.FileExists(defaultRuleSettingFile) {
@@ -64,6 +68,8 @@ func NewAccessController(options *Options) (*Controller, error) {
 
 	}
 
+	defaultAccessRule.populateEmptyLists()
+
 	//Generate a controller object
 	thisController := Controller{
 		DefaultAccessRule: &defaultAccessRule,
@@ -99,6 +105,7 @@ func NewAccessController(options *Options) (*Controller, error) {
 			options.Logger.PrintAndLog("access", "Unable to parse config "+filepath.Base(configFile), err)
 			continue
 		}
+		thisAccessRule.populateEmptyLists()
 		thisAccessRule.parent = &thisController
 		ProxyAccessRules.Store(thisAccessRule.ID, &thisAccessRule)
 	}
@@ -180,18 +187,7 @@ func (c *Controller) AddNewAccessRule(newRule *AccessRule) error {
 	}
 
 	//Check if the blacklist and whitelist are populated with empty map
-	if newRule.BlackListContryCode == nil {
-		newRule.BlackListContryCode = &map[string]string{}
-	}
-	if newRule.BlackListIP == nil {
-		newRule.BlackListIP = &map[string]string{}
-	}
-	if newRule.WhiteListCountryCode == nil {
-		newRule.WhiteListCountryCode = &map[string]string{}
-	}
-	if newRule.WhiteListIP == nil {
-		newRule.WhiteListIP = &map[string]string{}
-	}
+	newRule.populateEmptyLists()
 
 	//Add access rule to runtime
 	newRule.parent = c
@@ -6,6 +6,8 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Check both blacklist and whitelist for access for both geoIP and ip / CIDR ranges
@@ -77,6 +79,14 @@ func (s *AccessRule) IsBlacklisted(ipAddr string) bool {
 		return true
 	}
 
+	if s.IsASNBlacklisted(s.resolveASN(ipAddr)) {
+		return true
+	}
+
+	if s.IsRegionBlacklisted(s.resolveRegion(ipAddr)) {
+		return true
+	}
+
 	return false
 }
 
@@ -111,11 +121,75 @@ func (s *AccessRule) IsWhitelisted(ipAddr string) bool {
 		return true
 	}
 
+	if s.IsASNWhitelisted(s.resolveASN(ipAddr)) {
+		return true
+	}
+
+	if s.IsRegionWhitelisted(s.resolveRegion(ipAddr)) {
+		return true
+	}
+
 	return false
 }
 
 /* Utilities function */
 
+// Resolve the ASN of the given IP address, return empty string if unknown
+func (s *AccessRule) resolveASN(ipAddr string) string {
+	if s.parent == nil || s.parent.Options.GeoDB == nil {
+		return ""
+	}
+
+	asnInfo, err := s.parent.Options.GeoDB.ResolveASNFromIP(ipAddr)
+	if err != nil || asnInfo.ASN == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(asnInfo.ASN), 10)
+}
+
+// Resolve the region code (e.g. us-ca) of the given IP address, return empty string
+// if unknown or no MMDB is loaded in GeoDB
+func (s *AccessRule) resolveRegion(ipAddr string) string {
+	if s.parent == nil || s.parent.Options.GeoDB == nil || !s.parent.Options.GeoDB.HasMMDB() {
+		return ""
+	}
+
+	location, err := s.parent.Options.GeoDB.ResolveLocationFromIP(ipAddr)
+	if err != nil || location.CountryIsoCode == "" || location.RegionIsoCode == "" {
+		return ""
+	}
+	return strings.ToLower(location.CountryIsoCode + "-" + location.RegionIsoCode)
+}
+
+// Populate nil blacklist and whitelist with empty map
+// for rules created or saved by older versions
+func (s *AccessRule) populateEmptyLists() {
+	if s.BlackListContryCode == nil {
+		s.BlackListContryCode = &map[string]string{}
+	}
+	if s.BlackListIP == nil {
+		s.BlackListIP = &map[string]string{}
+	}
+	if s.BlackListASN == nil {
+		s.BlackListASN = &map[string]string{}
+	}
+	if s.WhiteListCountryCode == nil {
+		s.WhiteListCountryCode = &map[string]string{}
+	}
+	if s.WhiteListIP == nil {
+		s.WhiteListIP = &map[string]string{}
+	}
+	if s.WhiteListASN == nil {
+		s.WhiteListASN = &map[string]string{}
+	}
+	if s.BlackListRegion == nil {
+		s.BlackListRegion = &map[string]string{}
+	}
+	if s.WhiteListRegion == nil {
+		s.WhiteListRegion = &map[string]string{}
+	}
+}
+
 // Update the current access rule to json file
 func (s *AccessRule) SaveChanges() error {
 	if s.parent == nil {
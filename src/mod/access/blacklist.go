@@ -94,8 +94,76 @@ func (s *AccessRule) IsIPBlacklisted(ipAddr string) bool {
 	return false
 }
 
+// ASN Blacklist
+func (s *AccessRule) AddASNToBlackList(asn string, comment string) {
+	newBlackListASN := deepCopy(*s.BlackListASN)
+	newBlackListASN[asn] = comment
+	s.BlackListASN = &newBlackListASN
+	s.SaveChanges()
+}
+
+func (s *AccessRule) RemoveASNFromBlackList(asn string) {
+	newBlackListASN := deepCopy(*s.BlackListASN)
+	delete(newBlackListASN, asn)
+	s.BlackListASN = &newBlackListASN
+	s.SaveChanges()
+}
+
+func (s *AccessRule) IsASNBlacklisted(asn string) bool {
+	if asn == "" || s.BlackListASN == nil {
+		return false
+	}
+	ASNBlacklist := *s.BlackListASN
+	_, ok := ASNBlacklist[asn]
+	return ok
+}
+
+func (s *AccessRule) GetAllBlacklistedASN() []string {
+	bannedASNs := []string{}
+	blacklistMap := *s.BlackListASN
+	for asn := range blacklistMap {
+		bannedASNs = append(bannedASNs, asn)
+	}
+	return bannedASNs
+}
+
+// Region Blacklist, region code is in ISO 3166-2 format (e.g. us-ca)
+func (s *AccessRule) AddRegionToBlackList(regionCode string, comment string) {
+	regionCode = strings.ToLower(regionCode)
+	newBlackListRegion := deepCopy(*s.BlackListRegion)
+	newBlackListRegion[regionCode] = comment
+	s.BlackListRegion = &newBlackListRegion
+	s.SaveChanges()
+}
+
+func (s *AccessRule) RemoveRegionFromBlackList(regionCode string) {
+	regionCode = strings.ToLower(regionCode)
+	newBlackListRegion := deepCopy(*s.BlackListRegion)
+	delete(newBlackListRegion, regionCode)
+	s.BlackListRegion = &newBlackListRegion
+	s.SaveChanges()
+}
+
+func (s *AccessRule) IsRegionBlacklisted(regionCode string) bool {
+	if regionCode == "" || s.BlackListRegion == nil {
+		return false
+	}
+	regionBlacklist := *s.BlackListRegion
+	_, ok := regionBlacklist[strings.ToLower(regionCode)]
+	return ok
+}
+
+func (s *AccessRule) GetAllBlacklistedRegion() []string {
+	bannedRegions := []string{}
+	blacklistMap := *s.BlackListRegion
+	for regionCode := range blacklistMap {
+		bannedRegions = append(bannedRegions, regionCode)
+	}
+	return bannedRegions
+}
+
 // GetBlacklistedIPComment returns the comment for a blacklisted IP address
-// Searches blacklist for a Country (if country-code provided), IP address, or CIDR that matches the IP address
+// Searches blacklist for a Country (if country-code provided), IP address, CIDR or ASN that matches the IP address
 // returns error if not found
 func (s *AccessRule) GetBlacklistedIPComment(ipAddr string) (string, error) {
 	if countryInfo, err := s.parent.Options.GeoDB.ResolveCountryCodeFromIP(ipAddr); err == nil {
@@ -125,6 +193,14 @@ func (s *AccessRule) GetBlacklistedIPComment(ipAddr string) (string, error) {
 		}
 	}
 
+	if asn := s.resolveASN(ipAddr); s.IsASNBlacklisted(asn) {
+		return (*s.BlackListASN)[asn], nil
+	}
+
+	if region := s.resolveRegion(ipAddr); s.IsRegionBlacklisted(region) {
+		return (*s.BlackListRegion)[region], nil
+	}
+
 	return "", fmt.Errorf("IP %s not found in blacklist", ipAddr)
 }
 
@@ -35,6 +35,10 @@ type AccessRule struct {
 	WhiteListIP          *map[string]string
 	BlackListContryCode  *map[string]string
 	BlackListIP          *map[string]string
+	WhiteListASN         *map[string]string //ASN number without the AS prefix, e.g. 13335
+	BlackListASN         *map[string]string
+	WhiteListRegion      *map[string]string //ISO 3166-2 region code, e.g. us-ca. Require MMDB to be loaded
+	BlackListRegion      *map[string]string
 
 	parent *Controller
 }
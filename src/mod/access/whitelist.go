@@ -15,12 +15,16 @@ import (
 const (
 	EntryType_CountryCode int = 0
 	EntryType_IP          int = 1
+	EntryType_ASN         int = 2
+	EntryType_Region      int = 3
 )
 
 type WhitelistEntry struct {
-	EntryType int    //Entry type of whitelist, Country Code or IP
+	EntryType int    //Entry type of whitelist, Country Code, IP, ASN or Region
 	CC        string //ISO Country Code
 	IP        string //IP address or range
+	ASN       string //Autonomous System Number
+	Region    string //ISO 3166-2 region code
 	Comment   string //Comment for this entry
 }
 
@@ -117,3 +121,81 @@ func (s *AccessRule) GetAllWhitelistedIp() []*WhitelistEntry {
 
 	return whitelistedIp
 }
+
+//ASN Whitelist
+
+func (s *AccessRule) AddASNToWhiteList(asn string, comment string) {
+	newWhitelistASN := deepCopy(*s.WhiteListASN)
+	newWhitelistASN[asn] = comment
+	s.WhiteListASN = &newWhitelistASN
+	s.SaveChanges()
+}
+
+func (s *AccessRule) RemoveASNFromWhiteList(asn string) {
+	newWhitelistASN := deepCopy(*s.WhiteListASN)
+	delete(newWhitelistASN, asn)
+	s.WhiteListASN = &newWhitelistASN
+	s.SaveChanges()
+}
+
+func (s *AccessRule) IsASNWhitelisted(asn string) bool {
+	if asn == "" || s.WhiteListASN == nil {
+		return false
+	}
+	whitelistASN := *s.WhiteListASN
+	_, ok := whitelistASN[asn]
+	return ok
+}
+
+func (s *AccessRule) GetAllWhitelistedASN() []*WhitelistEntry {
+	whitelistedASN := []*WhitelistEntry{}
+	currentWhitelistedASN := *s.WhiteListASN
+	for asn, comment := range currentWhitelistedASN {
+		whitelistedASN = append(whitelistedASN, &WhitelistEntry{
+			EntryType: EntryType_ASN,
+			ASN:       asn,
+			Comment:   comment,
+		})
+	}
+	return whitelistedASN
+}
+
+//Region Whitelist
+
+func (s *AccessRule) AddRegionToWhiteList(regionCode string, comment string) {
+	regionCode = strings.ToLower(regionCode)
+	newWhitelistRegion := deepCopy(*s.WhiteListRegion)
+	newWhitelistRegion[regionCode] = comment
+	s.WhiteListRegion = &newWhitelistRegion
+	s.SaveChanges()
+}
+
+func (s *AccessRule) RemoveRegionFromWhiteList(regionCode string) {
+	regionCode = strings.ToLower(regionCode)
+	newWhitelistRegion := deepCopy(*s.WhiteListRegion)
+	delete(newWhitelistRegion, regionCode)
+	s.WhiteListRegion = &newWhitelistRegion
+	s.SaveChanges()
+}
+
+func (s *AccessRule) IsRegionWhitelisted(regionCode string) bool {
+	if regionCode == "" || s.WhiteListRegion == nil {
+		return false
+	}
+	whitelistRegion := *s.WhiteListRegion
+	_, ok := whitelistRegion[strings.ToLower(regionCode)]
+	return ok
+}
+
+func (s *AccessRule) GetAllWhitelistedRegion() []*WhitelistEntry {
+	whitelistedRegion := []*WhitelistEntry{}
+	currentWhitelistedRegion := *s.WhiteListRegion
+	for regionCode, comment := range currentWhitelistedRegion {
+		whitelistedRegion = append(whitelistedRegion, &WhitelistEntry{
+			EntryType: EntryType_Region,
+			Region:    regionCode,
+			Comment:   comment,
+		})
+	}
+	return whitelistedRegion
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 
 	"github.com/go-acme/lego/v5/acme"
+	"imuslab.com/zoraxy/mod/secrets"
 )
 
 /*
@@ -64,7 +65,8 @@ func (u *ACMEUser) GetPrivateKey() crypto.Signer {
 
 // MarshalJSON implements json.Marshaler for ACMEUser.
 // It serializes the private key as a PEM string because
-// crypto.PrivateKey cannot be marshalled directly.
+// crypto.PrivateKey cannot be marshalled directly. The PEM
+// string is sealed if secrets encryption is enabled.
 func (u *ACMEUser) MarshalJSON() ([]byte, error) {
 	pemData := ""
 	if u.key == nil {
@@ -79,6 +81,10 @@ func (u *ACMEUser) MarshalJSON() ([]byte, error) {
 	pemData = string(pem.EncodeToMemory(&pem.Block{
 		Type: "EC PRIVATE KEY", Bytes: der,
 	}))
+	pemData, err := secrets.SealString(pemData)
+	if err != nil {
+		return nil, err
+	}
 
 	return json.Marshal(struct {
 		Email        string                `json:"email"`
@@ -109,8 +115,12 @@ func (u *ACMEUser) UnmarshalJSON(data []byte) error {
 	if aux.KeyPEM == "" {
 		return errors.New("no ACME account key stored")
 	}
+	keyPEM, err := secrets.OpenString(aux.KeyPEM)
+	if err != nil {
+		return errors.New("stored ACME account key could not be decrypted: " + err.Error())
+	}
 
-	block, _ := pem.Decode([]byte(aux.KeyPEM))
+	block, _ := pem.Decode([]byte(keyPEM))
 	if block == nil || block.Type != "EC PRIVATE KEY" {
 		return errors.New("stored ACME account key could not be decoded as EC private key")
 	}
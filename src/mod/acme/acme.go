@@ -30,6 +30,7 @@ import (
 	"imuslab.com/zoraxy/mod/database"
 	"imuslab.com/zoraxy/mod/info/logger"
 	"imuslab.com/zoraxy/mod/netutils"
+	"imuslab.com/zoraxy/mod/secrets"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -41,12 +42,14 @@ var defaultNameservers = []string{
 }
 
 type CertificateInfoJSON struct {
-	AcmeName    string   `json:"acme_name"`  // ACME provider name
-	AcmeUrl     string   `json:"acme_url"`   // Custom ACME URL (if any)
-	SkipTLS     bool     `json:"skip_tls"`   // Skip TLS verification of upstream
-	UseDNS      bool     `json:"dns"`        // Use DNS challenge
-	PropTimeout int      `json:"prop_time"`  // Propagation timeout
-	DNSServers  []string `json:"dnsServers"` // DNS servers
+	AcmeName      string   `json:"acme_name"`      // ACME provider name
+	AcmeUrl       string   `json:"acme_url"`       // Custom ACME URL (if any)
+	SkipTLS       bool     `json:"skip_tls"`       // Skip TLS verification of upstream
+	UseDNS        bool     `json:"dns"`            // Use DNS challenge
+	ChallengeType string   `json:"challenge_type"` // Challenge type used for this certificate
+	KeyType       string   `json:"key_type"`       // Key type of this certificate
+	PropTimeout   int      `json:"prop_time"`      // Propagation timeout
+	DNSServers    []string `json:"dnsServers"`     // DNS servers
 }
 
 type EABConfig struct {
@@ -62,6 +65,7 @@ type ACMEHandler struct {
 	TestMode       bool
 	KeyFileMode    os.FileMode
 	PublicFileMode os.FileMode
+	alpnProvider   *tlsALPNProvider //Pending TLS-ALPN-01 challenges served by the main TLS listener
 }
 
 // NewACME creates a new ACMEHandler instance.
@@ -76,6 +80,7 @@ func NewACME(
 		TestMode:       testMode,
 		KeyFileMode:    keyFileMode,
 		PublicFileMode: publicFileMode,
+		alpnProvider:   &tlsALPNProvider{},
 	}
 }
 
@@ -100,7 +105,8 @@ func (a *ACMEHandler) writeFileWithMode(filename string, data []byte, mode os.Fi
 }
 
 // ObtainCert obtains a certificate for the specified domains.
-func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email string, caName string, caUrl string, skipTLS bool, useDNS bool, propagationTimeout int, dnsServers string) (bool, error) {
+// Leave challengeType or keyType empty to reuse the one of an existing certificate
+func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email string, caName string, caUrl string, skipTLS bool, challengeType string, keyType string, propagationTimeout int, dnsServers string) (bool, error) {
 	ctx := context.TODO()
 
 	a.Logf("Obtaining certificate for: "+strings.Join(domains, ", "), nil)
@@ -165,18 +171,7 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 	// Ref: https://github.com/go-acme/lego/blob/6af2c756ac73a9cb401621afca722d0f4112b1b8/lego/client_config.go#L74
 	if skipTLS {
 		a.Logf("Ignoring TLS/SSL Verification Error for ACME Server", nil)
-		config.HTTPClient.Transport = &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			TLSHandshakeTimeout:   30 * time.Second,
-			ResponseHeaderTimeout: 30 * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
+		config.HTTPClient.Transport = insecureACMETransport()
 	}
 
 	client, err := lego.NewClient(config)
@@ -188,7 +183,12 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 	// Load certificate info from JSON file
 	certInfo, err := LoadCertInfoJSON(fmt.Sprintf("./conf/certs/%s.json", certificateName))
 	if err == nil {
-		useDNS = certInfo.UseDNS
+		if challengeType == "" {
+			challengeType = certInfo.GetChallengeType()
+		}
+		if keyType == "" {
+			keyType = certInfo.GetKeyType()
+		}
 		if dnsServers == "" && certInfo.DNSServers != nil && len(certInfo.DNSServers) > 0 {
 			dnsServers = strings.Join(certInfo.DNSServers, ",")
 		}
@@ -201,6 +201,20 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 		dnsNameservers[i] = strings.TrimSpace(dnsNameservers[i])
 	}
 
+	if challengeType == "" {
+		challengeType = ChallengeTypeHTTP01
+	}
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+	if !IsValidKeyType(keyType) {
+		return false, errors.New("unsupported key type " + keyType)
+	}
+	if !IsValidChallengeType(challengeType) {
+		return false, errors.New("unsupported challenge type " + challengeType)
+	}
+	useDNS := challengeType == ChallengeTypeDNS01
+
 	// setup how to receive challenge
 	if useDNS {
 		if !a.Database.TableExists("acme") {
@@ -249,6 +263,12 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 			a.Logf("Failed to resolve DNS01 Provider", err)
 			return false, err
 		}
+	} else if challengeType == ChallengeTypeTLSALPN01 {
+		err = client.Challenge.SetTLSALPN01Provider(a.alpnProvider)
+		if err != nil {
+			a.Logf("Failed to resolve TLSALPN01 Provider", err)
+			return false, err
+		}
 	} else {
 		err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", a.Port))
 		if err != nil {
@@ -292,13 +312,13 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 			return false, err
 		}
 
-		err = a.Database.Read("acme", config.CADirURL+"_hmacEncoded", &hmacEncoded)
+		err = a.Database.ReadSecret("acme", config.CADirURL+"_hmacEncoded", &hmacEncoded)
 		if err != nil {
 			a.Logf("Failed to read HMAC from database", err)
 			return false, err
 		}
 
-		a.Logf("EAB Credential retrieved: "+kid+" / "+hmacEncoded, nil)
+		a.Logf("EAB Credential retrieved: "+kid, nil)
 		if kid != "" && hmacEncoded != "" {
 			reg, err = client.Registration.RegisterWithExternalAccountBinding(ctx, registration.RegisterEABOptions{
 				TermsOfServiceAgreed: true,
@@ -334,7 +354,7 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 	request := certificate.ObtainRequest{
 		Domains: domains,
 		Bundle:  true,
-		KeyType: certcrypto.RSA2048,
+		KeyType: certcrypto.KeyType(keyType),
 	}
 
 	certificates, err := client.Certificate.Obtain(ctx, request)
@@ -350,7 +370,7 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 		a.Logf("Failed to write public key to disk", err)
 		return false, err
 	}
-	err = a.writeFileWithMode("./conf/certs/"+certificateName+".key", certificates.PrivateKey, a.KeyFileMode)
+	err = secrets.WriteFile("./conf/certs/"+certificateName+".key", certificates.PrivateKey, a.KeyFileMode)
 	if err != nil {
 		a.Logf("Failed to write private key to disk", err)
 		return false, err
@@ -358,12 +378,14 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 
 	// Save certificate's ACME info for renew usage
 	certInfo = &CertificateInfoJSON{
-		AcmeName:    caName,
-		AcmeUrl:     caUrl,
-		SkipTLS:     skipTLS,
-		UseDNS:      useDNS,
-		PropTimeout: propagationTimeout,
-		DNSServers:  dnsNameservers,
+		AcmeName:      caName,
+		AcmeUrl:       caUrl,
+		SkipTLS:       skipTLS,
+		UseDNS:        useDNS,
+		ChallengeType: challengeType,
+		KeyType:       keyType,
+		PropTimeout:   propagationTimeout,
+		DNSServers:    dnsNameservers,
 	}
 
 	certInfoBytes, err := json.Marshal(certInfo)
@@ -381,6 +403,23 @@ func (a *ACMEHandler) ObtainCert(domains []string, certificateName string, email
 	return true, nil
 }
 
+// insecureACMETransport returns the HTTP transport used for ACME servers
+// with TLS verification disabled
+func insecureACMETransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+}
+
 // CheckCertificate returns a list of domains that are in expired certificates.
 // It will return all domains that is in expired certificates
 // *** if there is a vaild certificate contains the domain and there is a expired certificate contains the same domain
@@ -541,6 +580,29 @@ func (a *ACMEHandler) HandleRenewCertificate(w http.ResponseWriter, r *http.Requ
 		dns = true
 	}
 
+	// Challenge type, the dns flag is kept for older clients. Leave it
+	// empty to reuse the challenge type of an existing certificate
+	challengeType, err := utils.PostPara(r, "challenge")
+	if err != nil {
+		challengeType = ""
+		if dns {
+			challengeType = ChallengeTypeDNS01
+		}
+	} else if !IsValidChallengeType(challengeType) {
+		utils.SendErrorResponse(w, "Invalid challenge type given")
+		return
+	}
+	dns = challengeType == ChallengeTypeDNS01
+
+	// Key type, leave it empty to reuse the key type of an existing certificate
+	keyType, err := utils.PostPara(r, "keyType")
+	if err != nil {
+		keyType = ""
+	} else if !IsValidKeyType(keyType) {
+		utils.SendErrorResponse(w, "Invalid key type given")
+		return
+	}
+
 	// Default propagation timeout is 600 seconds (10 minutes)
 	propagationTimeout := 600
 	if dns {
@@ -595,7 +657,7 @@ func (a *ACMEHandler) HandleRenewCertificate(w http.ResponseWriter, r *http.Requ
 	// Convert DNS servers slice to a single string
 	dnsServersString := strings.Join(dnsServers, ",")
 
-	result, err := a.ObtainCert(cleanedDomains, filename, email, ca, caUrl, skipTLS, dns, propagationTimeout, dnsServersString)
+	result, err := a.ObtainCert(cleanedDomains, filename, email, ca, caUrl, skipTLS, challengeType, keyType, propagationTimeout, dnsServersString)
 	if err != nil {
 		utils.SendErrorResponse(w, jsonEscape(err.Error()))
 		return
@@ -6,13 +6,22 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
+	"fmt"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"imuslab.com/zoraxy/mod/acme"
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+	"imuslab.com/zoraxy/mod/info/logger"
 )
 
 // Test if the issuer extraction is working
@@ -78,3 +87,128 @@ func TestExtractIssuerNameFromPEM(t *testing.T) {
 		t.Errorf("Unexpected issuer name. Expected: %s, Got: %s", expectedIssuer, issuerName)
 	}
 }
+
+// Test if certificates obtained by older versions renew with their original challenge
+func TestCertificateInfoChallengeType(t *testing.T) {
+	tests := []struct {
+		info     acme.CertificateInfoJSON
+		expected string
+	}{
+		{acme.CertificateInfoJSON{}, acme.ChallengeTypeHTTP01},
+		{acme.CertificateInfoJSON{UseDNS: true}, acme.ChallengeTypeDNS01},
+		{acme.CertificateInfoJSON{ChallengeType: acme.ChallengeTypeTLSALPN01}, acme.ChallengeTypeTLSALPN01},
+		{acme.CertificateInfoJSON{ChallengeType: "invalid"}, acme.ChallengeTypeHTTP01},
+	}
+	for _, test := range tests {
+		if challengeType := test.info.GetChallengeType(); challengeType != test.expected {
+			t.Errorf("expected %s for %+v, got %s", test.expected, test.info, challengeType)
+		}
+	}
+}
+
+// writeRenewTestCert writes a self-signed certificate into the cert folder,
+// with ACME info pointing to caUrl if it is set
+func writeRenewTestCert(t *testing.T, certFolder string, name string, notAfter time.Time, caUrl string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: name},
+		NotBefore:      notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:       notAfter,
+		DNSNames:       []string{name},
+		AuthorityKeyId: []byte{1, 2, 3, 4},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(certFolder, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644)
+	if caUrl != "" {
+		info, _ := json.Marshal(acme.CertificateInfoJSON{AcmeName: "custom", AcmeUrl: caUrl, SkipTLS: true})
+		os.WriteFile(filepath.Join(certFolder, name+".json"), info, 0644)
+	}
+}
+
+func TestRenewalScheduleARI(t *testing.T) {
+	//Fake ACME server only answering the directory and renewal info requests
+	windowStart := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+	windowEnd := windowStart.Add(time.Hour)
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/directory":
+			json.NewEncoder(w).Encode(map[string]string{
+				"newNonce":    server.URL + "/nonce",
+				"newAccount":  server.URL + "/account",
+				"newOrder":    server.URL + "/order",
+				"renewalInfo": server.URL + "/ari",
+			})
+		case strings.HasPrefix(r.URL.Path, "/ari/"):
+			w.Header().Set("Retry-After", "21600")
+			fmt.Fprintf(w, `{"suggestedWindow":{"start":%q,"end":%q},"explanationURL":"https://example.com/why"}`, windowStart.Format(time.RFC3339), windowEnd.Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	certFolder := filepath.Join(tmpDir, "certs")
+	os.MkdirAll(certFolder, 0775)
+	systemLogger, _ := logger.NewFmtLogger()
+	sysdb, err := database.NewDatabase(filepath.Join(tmpDir, "sys.db"), dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sysdb.Close()
+	handler := acme.NewACME("0", sysdb, systemLogger, false, 0600, 0644)
+	renewer, err := acme.NewAutoRenewer(filepath.Join(tmpDir, "acme_conf.json"), certFolder, 0, 30, handler, systemLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter := time.Now().Add(60 * 24 * time.Hour)
+	writeRenewTestCert(t, certFolder, "ari.example.com", notAfter, server.URL+"/directory")
+	writeRenewTestCert(t, certFolder, "local.example.com", notAfter, "")
+
+	//The ARI window is in the past so a renewal is attempted, which fails as
+	//the fake server cannot issue certificates
+	renewed, _ := renewer.CheckAndRenewCertificates()
+	if len(renewed) != 0 {
+		t.Fatalf("expected no renewed certificate, got %v", renewed)
+	}
+	schedules := renewer.GetRenewalSchedules()
+	if len(schedules) != 2 {
+		t.Fatalf("expected 2 renewal schedules, got %d", len(schedules))
+	}
+
+	ari := schedules[0]
+	if !ari.ARISupported || ari.WindowStart != windowStart.Unix() || ari.WindowEnd != windowEnd.Unix() || ari.ExplanationURL != "https://example.com/why" {
+		t.Errorf("expected the ARI suggested window, got %+v", ari)
+	}
+	if ari.ScheduledAt < ari.WindowStart || ari.ScheduledAt > ari.WindowEnd {
+		t.Errorf("expected the renewal time inside the window, got %d", ari.ScheduledAt)
+	}
+	if len(ari.History) != 1 || ari.History[0].Reason != acme.RenewReasonARI || ari.History[0].Success {
+		t.Errorf("expected a failed ARI renewal attempt, got %+v", ari.History)
+	}
+	if ari.Failures != 1 || ari.LastError == "" || ari.NextRetry <= time.Now().Unix() {
+		t.Errorf("expected the failed renewal to back off, got %+v", ari)
+	}
+
+	//Certificates without ARI use the early renew days
+	local := schedules[1]
+	expectedStart := notAfter.Add(-30 * 24 * time.Hour).Unix()
+	if local.ARISupported || local.WindowStart != expectedStart || local.ScheduledAt < local.WindowStart || local.ScheduledAt > local.WindowEnd {
+		t.Errorf("expected the early renew window, got %+v", local)
+	}
+	if len(local.History) != 0 {
+		t.Errorf("expected no renewal attempt outside of the window, got %+v", local.History)
+	}
+
+	//No retry until the backoff passed
+	renewer.CheckAndRenewCertificates()
+	if history := renewer.GetRenewalSchedules()[0].History; len(history) != 1 {
+		t.Errorf("expected the renewal to wait for the backoff, got %d attempts", len(history))
+	}
+}
@@ -0,0 +1,73 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/go-acme/lego/v5/acme/api"
+	"github.com/go-acme/lego/v5/certificate"
+	"github.com/go-acme/lego/v5/lego"
+)
+
+/*
+	ari.go
+
+	ACME Renewal Information (RFC 9773). CAs supporting ARI publish a
+	suggested renewal window per certificate, which replaces the fixed
+	early renew days when scheduling renewals.
+*/
+
+const ariRequestTimeout = 30 * time.Second
+
+// ErrNoRenewalInfo is returned when the CA does not support ARI
+var ErrNoRenewalInfo = api.ErrNoARI
+
+// resolveCADirURL returns the directory URL of the CA a certificate was obtained from
+func (a *ACMEHandler) resolveCADirURL(caName string, caUrl string) string {
+	if caName == "custom" || caUrl != "" {
+		return caUrl
+	}
+	if caName == "" {
+		caName = "Let's Encrypt"
+	}
+	if url, err := loadCAApiServerFromName(caName, a.TestMode); err == nil {
+		return url
+	}
+	url, _ := loadCAApiServerFromName("Let's Encrypt", a.TestMode)
+	return url
+}
+
+// GetRenewalInfo fetches the suggested renewal window of a certificate from
+// the CA it was obtained from. ErrNoRenewalInfo is returned if the CA does
+// not support ARI.
+func (a *ACMEHandler) GetRenewalInfo(certInfo *CertificateInfoJSON, leaf *x509.Certificate) (*certificate.RenewalInfo, error) {
+	caUrl := a.resolveCADirURL(certInfo.AcmeName, certInfo.AcmeUrl)
+	if caUrl == "" {
+		return nil, errors.New("unable to resolve CA directory URL")
+	}
+
+	//The renewalInfo endpoint is unauthenticated, an ephemeral account key is enough
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	config := lego.NewConfig(&ACMEUser{key: privateKey})
+	config.CADirURL = caUrl
+	if certInfo.SkipTLS {
+		config.HTTPClient.Transport = insecureACMETransport()
+	}
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ariRequestTimeout)
+	defer cancel()
+	return client.Certificate.GetRenewalInfo(ctx, leaf)
+}
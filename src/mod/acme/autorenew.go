@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"imuslab.com/zoraxy/mod/info/logger"
@@ -38,11 +39,19 @@ type AutoRenewer struct {
 	EarlyRenewDays    int //How many days before cert expire to renew certificate
 	TickerstopChan    chan bool
 	Logger            *logger.Logger //System wide logger
+	ScheduleFilePath  string         //Per certificate renewal schedule and history, see renewschedule.go
+
+	schedules            map[string]*RenewalSchedule //Cert name to renewal schedule
+	scheduleMutex        sync.Mutex
+	scheduleUpdated      chan struct{} //Wake the renew ticker after the schedule changed
+	renewalFailedHandler RenewalFailedHandler
+	renewMutex           sync.Mutex //Serialize renewals
 }
 
 type ExpiredCerts struct {
 	Domains  []string
 	Filepath string
+	Reason   string //Why the certificate is renewed, see RenewReason constants
 }
 
 // Create an auto renew agent, require config filepath and auto scan & renew interval (seconds)
@@ -91,7 +100,10 @@ func NewAutoRenewer(config string, certFolder string, renewCheckInterval int64,
 		RenewTickInterval: renewCheckInterval,
 		EarlyRenewDays:    earlyRenewDays,
 		Logger:            logger,
+		ScheduleFilePath:  strings.TrimSuffix(config, filepath.Ext(config)) + "_schedule.json",
+		scheduleUpdated:   make(chan struct{}, 1),
 	}
+	thisRenewer.loadRenewalSchedules()
 
 	thisRenewer.Logf("ACME early renew set to "+fmt.Sprint(earlyRenewDays)+" days and check interval set to "+fmt.Sprint(renewCheckInterval)+" seconds", nil)
 
@@ -118,19 +130,23 @@ func (a *AutoRenewer) StartAutoRenewTicker() {
 
 	time.Sleep(1 * time.Second)
 
-	ticker := time.NewTicker(time.Duration(a.RenewTickInterval) * time.Second)
 	done := make(chan bool)
 
-	//Start the ticker to check and renew every x seconds
+	//Wake up at the next scheduled renewal, or every x seconds at most
 	go func(a *AutoRenewer) {
+		timer := time.NewTimer(a.nextRenewalCheck(time.Now()))
+		defer timer.Stop()
 		for {
 			select {
 			case <-done:
 				return
-			case <-ticker.C:
+			case <-a.scheduleUpdated:
+				timer.Stop()
+			case <-timer.C:
 				a.Logf("Check and renew certificates in progress", nil)
 				a.CheckAndRenewCertificates()
 			}
+			timer.Reset(a.nextRenewalCheck(time.Now()))
 		}
 	}(a)
 
@@ -287,9 +303,13 @@ func (a *AutoRenewer) HandleACMEEmail(w http.ResponseWriter, r *http.Request) {
 }
 
 // Check and renew certificates. This check all the certificates in the
-// certificate folder and return a list of certs that is renewed in this call
-// Return string array with length 0 when no cert is expired
+// certificate folder, update their renewal schedule and return a list of
+// certs that is renewed in this call
+// Return string array with length 0 when no cert is due for renewal
 func (a *AutoRenewer) CheckAndRenewCertificates() ([]string, error) {
+	a.renewMutex.Lock()
+	defer a.renewMutex.Unlock()
+
 	certFolder := a.CertFolder
 	files, err := os.ReadDir(certFolder)
 	if err != nil {
@@ -297,62 +317,55 @@ func (a *AutoRenewer) CheckAndRenewCertificates() ([]string, error) {
 		return []string{}, err
 	}
 
-	expiredCertList := []*ExpiredCerts{}
-	if a.RenewerConfig.RenewAll {
-		//Scan and renew all
-		for _, file := range files {
-			if filepath.Ext(file.Name()) == ".crt" || filepath.Ext(file.Name()) == ".pem" {
-				//This is a public key file
-				certBytes, err := os.ReadFile(filepath.Join(certFolder, file.Name()))
-				if err != nil {
-					continue
-				}
-				if CertExpireSoon(certBytes, a.EarlyRenewDays) || CertIsExpired(certBytes) {
-					//This cert is expired
-					DNSName, err := ExtractDomains(certBytes)
-					if err != nil {
-						//Maybe self signed. Ignore this
-						a.Logf("Encounted error when trying to resolve DNS name for cert "+file.Name(), err)
-						continue
-					}
-
-					expiredCertList = append(expiredCertList, &ExpiredCerts{
-						Filepath: filepath.Join(certFolder, file.Name()),
-						Domains:  DNSName,
-					})
-				}
-			}
+	now := time.Now()
+	dueCertList := []*ExpiredCerts{}
+	renewable := map[string]bool{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".crt" && filepath.Ext(file.Name()) != ".pem" {
+			continue
 		}
-	} else {
-		//Only renew those in the list
-		for _, file := range files {
-			fileName := file.Name()
-			certName := fileName[:len(fileName)-len(filepath.Ext(fileName))]
-			if contains(a.RenewerConfig.FilesToRenew, certName) {
-				//This is the one to auto renew
-				certBytes, err := os.ReadFile(filepath.Join(certFolder, file.Name()))
-				if err != nil {
-					continue
-				}
-				if CertExpireSoon(certBytes, a.EarlyRenewDays) || CertIsExpired(certBytes) {
-					//This cert is expired
-					DNSName, err := ExtractDomains(certBytes)
-					if err != nil {
-						//Maybe self signed. Ignore this
-						a.Logf("Encounted error when trying to resolve DNS name for cert "+file.Name(), err)
-						continue
-					}
-
-					expiredCertList = append(expiredCertList, &ExpiredCerts{
-						Filepath: filepath.Join(certFolder, file.Name()),
-						Domains:  DNSName,
-					})
-				}
-			}
+
+		//Renew all or only those in the list
+		certName := certNameFromFilename(file.Name())
+		if !a.RenewerConfig.RenewAll && !contains(a.RenewerConfig.FilesToRenew, certName) {
+			continue
 		}
+
+		//This is a public key file
+		certBytes, err := os.ReadFile(filepath.Join(certFolder, file.Name()))
+		if err != nil {
+			continue
+		}
+		leaf, err := parseLeafCertificate(certBytes)
+		if err != nil {
+			continue
+		}
+		renewable[certName] = true
+
+		reason := a.updateRenewalSchedule(certName, leaf, now)
+		if reason == "" {
+			continue
+		}
+
+		//This cert is due for renewal
+		DNSName, err := ExtractDomains(certBytes)
+		if err != nil || len(DNSName) == 0 {
+			//Maybe self signed. Ignore this
+			a.Logf("Encounted error when trying to resolve DNS name for cert "+file.Name(), err)
+			continue
+		}
+
+		dueCertList = append(dueCertList, &ExpiredCerts{
+			Filepath: filepath.Join(certFolder, file.Name()),
+			Domains:  DNSName,
+			Reason:   reason,
+		})
 	}
+	a.pruneRenewalSchedules(renewable)
+	a.saveRenewalSchedules()
+	defer a.notifyScheduleUpdated()
 
-	return a.renewExpiredDomains(expiredCertList)
+	return a.renewExpiredDomains(dueCertList)
 }
 
 // Close the auto renewer
@@ -406,7 +419,12 @@ func (a *AutoRenewer) renewExpiredDomains(certs []*ExpiredCerts) ([]string, erro
 			a.Logf("Could not extract SANs from PEM for "+fileName+", using original domains", errSan)
 		}
 
-		_, err = a.AcmeHandler.ObtainCert(expiredCert.Domains, certName, a.RenewerConfig.Email, certInfo.AcmeName, certInfo.AcmeUrl, certInfo.SkipTLS, certInfo.UseDNS, certInfo.PropTimeout, dnsServers)
+		_, err = a.AcmeHandler.ObtainCert(expiredCert.Domains, certName, a.RenewerConfig.Email, certInfo.AcmeName, certInfo.AcmeUrl, certInfo.SkipTLS, certInfo.GetChallengeType(), certInfo.GetKeyType(), certInfo.PropTimeout, dnsServers)
+		reason := expiredCert.Reason
+		if reason == "" {
+			reason = RenewReasonExpiry
+		}
+		a.recordRenewalAttempt(certName, reason, err)
 		if err != nil {
 			a.Logf("Renew "+fileName+"("+strings.Join(expiredCert.Domains, ",")+") failed", err)
 		} else {
@@ -418,6 +436,83 @@ func (a *AutoRenewer) renewExpiredDomains(certs []*ExpiredCerts) ([]string, erro
 	return renewedCertFiles, nil
 }
 
+// RenewCertificate renews a single certificate obtained through ACME
+// regardless of its expiry date, e.g. after it has been revoked
+func (a *AutoRenewer) RenewCertificate(certificateName string) error {
+	if !a.RenewerConfig.Enabled {
+		return errors.New("auto renew is disabled")
+	}
+	if !a.RenewerConfig.RenewAll && !contains(a.RenewerConfig.FilesToRenew, certificateName) {
+		return errors.New("certificate is not in the auto renew list")
+	}
+	return a.renewCertificateByName(certificateName, RenewReasonRevoked)
+}
+
+// renewCertificateByName renews a certificate from the cert folder now
+func (a *AutoRenewer) renewCertificateByName(certificateName string, reason string) error {
+	a.renewMutex.Lock()
+	defer a.renewMutex.Unlock()
+	defer a.notifyScheduleUpdated()
+
+	//Only certificates obtained through ACME have the info needed for renewal
+	certFile := filepath.Join(a.CertFolder, certificateName+".pem")
+	if _, err := LoadCertInfoJSON(filepath.Join(a.CertFolder, certificateName+".json")); err != nil {
+		return errors.New("certificate was not obtained through ACME")
+	}
+	certBytes, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	domains, err := ExtractDomains(certBytes)
+	if err != nil {
+		return err
+	}
+
+	renewed, err := a.renewExpiredDomains([]*ExpiredCerts{{Filepath: certFile, Domains: domains, Reason: reason}})
+	if err != nil {
+		return err
+	}
+	if len(renewed) == 0 {
+		a.scheduleMutex.Lock()
+		lastError := a.getRenewalSchedule(certificateName).LastError
+		a.scheduleMutex.Unlock()
+		return errors.New("certificate renewal failed: " + lastError)
+	}
+	return nil
+}
+
+// HandleRenewSchedule returns the renewal schedule and history of the
+// certificates, POST with cert to renew a certificate now
+func (a *AutoRenewer) HandleRenewSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		certName, err := utils.PostPara(r, "cert")
+		if err != nil {
+			utils.SendErrorResponse(w, "cert not given")
+			return
+		}
+		certName = filepath.Base(certName)
+		if err := a.renewCertificateByName(certName, RenewReasonManual); err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+		utils.SendOK(w)
+		return
+	}
+
+	js, _ := json.Marshal(a.GetRenewalSchedules())
+	utils.SendJSONResponse(w, string(js))
+}
+
+// AddRenewTarget makes sure the certificate file is covered by selective
+// auto renew. Nothing is changed when all certificates are renewed.
+func (a *AutoRenewer) AddRenewTarget(certificateName string) error {
+	if a.RenewerConfig.RenewAll || contains(a.RenewerConfig.FilesToRenew, certificateName) {
+		return nil
+	}
+	a.RenewerConfig.FilesToRenew = append(a.RenewerConfig.FilesToRenew, certificateName)
+	return a.saveRenewConfigToFile()
+}
+
 // Write the current renewer config to file
 func (a *AutoRenewer) saveRenewConfigToFile() error {
 	js, _ := json.MarshalIndent(a.RenewerConfig, "", " ")
@@ -449,7 +544,7 @@ func (a *AutoRenewer) HanldeSetEAB(w http.ResponseWriter, r *http.Request) {
 	}
 
 	a.AcmeHandler.Database.Write("acme", acmeDirectoryURL+"_kid", kid)
-	a.AcmeHandler.Database.Write("acme", acmeDirectoryURL+"_hmacEncoded", hmacEncoded)
+	a.AcmeHandler.Database.WriteSecret("acme", acmeDirectoryURL+"_hmacEncoded", hmacEncoded)
 
 	utils.SendOK(w)
 
@@ -0,0 +1,40 @@
+package acme
+
+import (
+	"github.com/go-acme/lego/v5/certcrypto"
+)
+
+/*
+	keytype.go
+
+	Key types of the certificates obtained from the ACME server
+*/
+
+// Key types that can be selected when obtaining a certificate
+const (
+	KeyTypeEC256   = string(certcrypto.EC256)
+	KeyTypeEC384   = string(certcrypto.EC384)
+	KeyTypeRSA2048 = string(certcrypto.RSA2048)
+	KeyTypeRSA3072 = string(certcrypto.RSA3072)
+	KeyTypeRSA4096 = string(certcrypto.RSA4096)
+
+	DefaultKeyType = KeyTypeRSA2048
+)
+
+// IsValidKeyType checks if the key type is supported
+func IsValidKeyType(keyType string) bool {
+	switch keyType {
+	case KeyTypeEC256, KeyTypeEC384, KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096:
+		return true
+	}
+	return false
+}
+
+// GetKeyType returns the key type used to obtain the certificate.
+// Certificate info written by older versions have no key type recorded.
+func (c *CertificateInfoJSON) GetKeyType() string {
+	if IsValidKeyType(c.KeyType) {
+		return c.KeyType
+	}
+	return DefaultKeyType
+}
@@ -0,0 +1,350 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	renewschedule.go
+
+	Per certificate renewal scheduling. Each certificate gets a renewal
+	window, suggested by the CA through ARI or derived from the early
+	renew days, and a random renewal time inside it so certificates are
+	not all renewed at once. Failed renewals are retried with exponential
+	backoff and every attempt is kept in the renewal history.
+*/
+
+const (
+	renewRetryMinBackoff  = 1 * time.Hour  //Backoff after the first failed renewal
+	renewRetryMaxBackoff  = 24 * time.Hour //Upper bound of the backoff between retries
+	ariDefaultRecheck     = 6 * time.Hour  //ARI poll interval if the CA gives no Retry-After
+	ariMinRecheck         = 1 * time.Hour
+	ariMaxRecheck         = 24 * time.Hour
+	ariErrorRecheck       = 1 * time.Hour  //ARI poll interval after a failed request
+	ariUnsupportedRecheck = 24 * time.Hour //Interval to check again if the CA added ARI support
+	renewMinCheckInterval = 1 * time.Minute
+	renewHistoryLength    = 10 //Number of renewal attempts kept per certificate
+)
+
+// Reasons a renewal is attempted for
+const (
+	RenewReasonARI     = "ari"     //Inside the renewal window suggested by the CA
+	RenewReasonExpiry  = "expiry"  //Inside the early renew window before expiry
+	RenewReasonRevoked = "revoked" //The certificate has been revoked
+	RenewReasonManual  = "manual"  //Requested through the API
+)
+
+// RenewalAttempt is a single renewal attempt of a certificate
+type RenewalAttempt struct {
+	Time    int64  `json:"time"`
+	Reason  string `json:"reason"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RenewalSchedule is the renewal state of a certificate
+type RenewalSchedule struct {
+	CertName       string            `json:"cert_name"`
+	Domains        []string          `json:"domains"`
+	SerialNumber   string            `json:"serial_number"`
+	NotAfter       int64             `json:"not_after"`
+	ARISupported   bool              `json:"ari_supported"`   // If the window is suggested by the CA
+	ExplanationURL string            `json:"explanation_url"` // ARI explanation of the suggested window, if any
+	WindowStart    int64             `json:"window_start"`
+	WindowEnd      int64             `json:"window_end"`
+	ScheduledAt    int64             `json:"scheduled_at"` // Random time inside the window
+	NextARICheck   int64             `json:"next_ari_check"`
+	NextRenewal    int64             `json:"next_renewal"` // Next renewal attempt, including retry backoff
+	Failures       int               `json:"failures"`     // Consecutive failed renewals
+	NextRetry      int64             `json:"next_retry"`
+	LastAttempt    int64             `json:"last_attempt"`
+	LastSuccess    int64             `json:"last_success"`
+	LastError      string            `json:"last_error"`
+	History        []*RenewalAttempt `json:"history"`
+}
+
+// RenewalFailedHandler is called after each failed renewal attempt of a certificate
+type RenewalFailedHandler func(certName string, domains []string, reason string, failures int, err error)
+
+// SetRenewalFailedHandler sets the handler called when a renewal attempt failed
+func (a *AutoRenewer) SetRenewalFailedHandler(handler RenewalFailedHandler) {
+	a.scheduleMutex.Lock()
+	defer a.scheduleMutex.Unlock()
+	a.renewalFailedHandler = handler
+}
+
+// loadRenewalSchedules restores the renewal schedules from file
+func (a *AutoRenewer) loadRenewalSchedules() {
+	a.schedules = map[string]*RenewalSchedule{}
+	if !utils.FileExists(a.ScheduleFilePath) {
+		return
+	}
+	content, err := os.ReadFile(a.ScheduleFilePath)
+	if err != nil {
+		a.Logf("Failed to read renewal schedules", err)
+		return
+	}
+	if err := json.Unmarshal(content, &a.schedules); err != nil {
+		a.Logf("Malformed renewal schedule file, starting over", err)
+		a.schedules = map[string]*RenewalSchedule{}
+	}
+}
+
+// saveRenewalSchedules writes the renewal schedules to file
+func (a *AutoRenewer) saveRenewalSchedules() {
+	a.scheduleMutex.Lock()
+	js, _ := json.MarshalIndent(a.schedules, "", " ")
+	a.scheduleMutex.Unlock()
+	if err := os.WriteFile(a.ScheduleFilePath, js, 0775); err != nil {
+		a.Logf("Failed to save renewal schedules", err)
+	}
+}
+
+// getRenewalSchedule returns the schedule of a certificate, creating it if needed.
+// Must be called with scheduleMutex held.
+func (a *AutoRenewer) getRenewalSchedule(certName string) *RenewalSchedule {
+	schedule, ok := a.schedules[certName]
+	if !ok {
+		schedule = &RenewalSchedule{
+			CertName: certName,
+			History:  []*RenewalAttempt{},
+		}
+		a.schedules[certName] = schedule
+	}
+	return schedule
+}
+
+// updateRenewalSchedule refreshes the renewal window of a certificate and
+// returns the reason it is due for renewal, or an empty string if it is not
+func (a *AutoRenewer) updateRenewalSchedule(certName string, leaf *x509.Certificate, now time.Time) string {
+	serial := fmt.Sprintf("%x", leaf.SerialNumber)
+	a.scheduleMutex.Lock()
+	schedule := a.getRenewalSchedule(certName)
+	if schedule.SerialNumber != serial {
+		//A new certificate is in place, it gets its own window
+		*schedule = RenewalSchedule{
+			CertName:     certName,
+			SerialNumber: serial,
+			NotAfter:     leaf.NotAfter.Unix(),
+			LastAttempt:  schedule.LastAttempt,
+			LastSuccess:  schedule.LastSuccess,
+			History:      schedule.History,
+		}
+	}
+	schedule.Domains = leaf.DNSNames
+	checkARI := now.Unix() >= schedule.NextARICheck
+	a.scheduleMutex.Unlock()
+
+	//Ask the CA for its suggested window. Only certificates obtained through ACME know their CA.
+	var ariWindow *[2]time.Time
+	var explanationURL string
+	var ariErr error
+	nextARICheck := now.Add(ariUnsupportedRecheck)
+	if checkARI {
+		certInfo, err := LoadCertInfoJSON(filepath.Join(a.CertFolder, certName+".json"))
+		if err == nil {
+			info, err := a.AcmeHandler.GetRenewalInfo(certInfo, leaf)
+			if err == nil {
+				ariWindow = &[2]time.Time{info.SuggestedWindow.Start, info.SuggestedWindow.End}
+				explanationURL = info.ExplanationURL
+				nextARICheck = now.Add(clampDuration(info.RetryAfter, ariDefaultRecheck, ariMinRecheck, ariMaxRecheck))
+			} else if !errors.Is(err, ErrNoRenewalInfo) {
+				ariErr = err
+				nextARICheck = now.Add(ariErrorRecheck)
+				a.Logf("Unable to fetch renewal info for "+certName, err)
+			}
+		}
+	}
+
+	a.scheduleMutex.Lock()
+	defer a.scheduleMutex.Unlock()
+	if checkARI {
+		schedule.NextARICheck = nextARICheck.Unix()
+		if ariWindow != nil {
+			schedule.ARISupported = true
+			schedule.ExplanationURL = explanationURL
+			schedule.setWindow(ariWindow[0], ariWindow[1])
+		} else if ariErr == nil {
+			schedule.ARISupported = false
+			schedule.ExplanationURL = ""
+		}
+		//Keep the last suggested window while the CA is unreachable
+	}
+	if !schedule.ARISupported {
+		start, end := a.fallbackRenewalWindow(leaf)
+		schedule.setWindow(start, end)
+	}
+
+	schedule.NextRenewal = max(schedule.ScheduledAt, schedule.NextRetry)
+	if now.Unix() < schedule.NextRetry {
+		//Backing off after a failed renewal
+		return ""
+	}
+	if schedule.ARISupported && now.Unix() >= schedule.ScheduledAt {
+		return RenewReasonARI
+	}
+	if now.Unix() >= schedule.ScheduledAt || now.After(leaf.NotAfter) {
+		return RenewReasonExpiry
+	}
+	return ""
+}
+
+// fallbackRenewalWindow returns the renewal window of certificates without
+// ARI, starting at the early renew days before expiry and lasting a third of
+// the remaining validity
+func (a *AutoRenewer) fallbackRenewalWindow(leaf *x509.Certificate) (time.Time, time.Time) {
+	start := leaf.NotAfter.Add(-time.Duration(a.EarlyRenewDays) * 24 * time.Hour)
+	if start.Before(leaf.NotBefore) {
+		start = leaf.NotBefore
+	}
+	end := start.Add(leaf.NotAfter.Sub(start) / 3)
+	return start, end
+}
+
+// setWindow updates the renewal window, picking a new random renewal time
+// only if the window changed so the schedule is stable between checks
+func (s *RenewalSchedule) setWindow(start time.Time, end time.Time) {
+	if s.WindowStart == start.Unix() && s.WindowEnd == end.Unix() && s.ScheduledAt != 0 {
+		return
+	}
+	s.WindowStart = start.Unix()
+	s.WindowEnd = end.Unix()
+	s.ScheduledAt = s.WindowStart
+	if s.WindowEnd > s.WindowStart {
+		s.ScheduledAt += rand.Int63n(s.WindowEnd - s.WindowStart)
+	}
+}
+
+// recordRenewalAttempt adds a renewal attempt to the history of the
+// certificate and schedules the retry if it failed
+func (a *AutoRenewer) recordRenewalAttempt(certName string, reason string, err error) {
+	now := time.Now()
+	a.scheduleMutex.Lock()
+	schedule := a.getRenewalSchedule(certName)
+	attempt := &RenewalAttempt{
+		Time:    now.Unix(),
+		Reason:  reason,
+		Success: err == nil,
+	}
+	schedule.LastAttempt = now.Unix()
+	if err != nil {
+		attempt.Error = err.Error()
+		schedule.Failures++
+		schedule.LastError = err.Error()
+		schedule.NextRetry = now.Add(renewRetryBackoff(schedule.Failures)).Unix()
+	} else {
+		schedule.Failures = 0
+		schedule.LastError = ""
+		schedule.NextRetry = 0
+		schedule.LastSuccess = now.Unix()
+	}
+	schedule.NextRenewal = max(schedule.ScheduledAt, schedule.NextRetry)
+	schedule.History = append(schedule.History, attempt)
+	if len(schedule.History) > renewHistoryLength {
+		schedule.History = schedule.History[len(schedule.History)-renewHistoryLength:]
+	}
+	handler := a.renewalFailedHandler
+	domains := append([]string{}, schedule.Domains...)
+	failures := schedule.Failures
+	a.scheduleMutex.Unlock()
+	a.saveRenewalSchedules()
+
+	if err != nil && handler != nil {
+		go handler(certName, domains, reason, failures, err)
+	}
+}
+
+// renewRetryBackoff doubles the backoff on each consecutive failure
+func renewRetryBackoff(failures int) time.Duration {
+	backoff := renewRetryMinBackoff
+	for i := 1; i < failures && backoff < renewRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, renewRetryMaxBackoff)
+}
+
+// pruneRenewalSchedules removes the schedules of certificates that are no
+// longer renewed
+func (a *AutoRenewer) pruneRenewalSchedules(renewable map[string]bool) {
+	a.scheduleMutex.Lock()
+	defer a.scheduleMutex.Unlock()
+	for certName := range a.schedules {
+		if !renewable[certName] {
+			delete(a.schedules, certName)
+		}
+	}
+}
+
+// nextRenewalCheck returns the delay until the next scheduled renewal,
+// retry or ARI poll, bounded by the renew check interval
+func (a *AutoRenewer) nextRenewalCheck(now time.Time) time.Duration {
+	next := now.Add(time.Duration(a.RenewTickInterval) * time.Second)
+	a.scheduleMutex.Lock()
+	for _, schedule := range a.schedules {
+		for _, ts := range []int64{schedule.NextRenewal, schedule.NextARICheck} {
+			if ts > 0 && time.Unix(ts, 0).Before(next) {
+				next = time.Unix(ts, 0)
+			}
+		}
+	}
+	a.scheduleMutex.Unlock()
+	return max(next.Sub(now), renewMinCheckInterval)
+}
+
+// notifyScheduleUpdated wakes the renew ticker so it picks up the new schedule
+func (a *AutoRenewer) notifyScheduleUpdated() {
+	select {
+	case a.scheduleUpdated <- struct{}{}:
+	default:
+	}
+}
+
+// GetRenewalSchedules returns the renewal schedule and history of every
+// certificate covered by auto renew
+func (a *AutoRenewer) GetRenewalSchedules() []*RenewalSchedule {
+	a.scheduleMutex.Lock()
+	defer a.scheduleMutex.Unlock()
+	results := []*RenewalSchedule{}
+	for _, schedule := range a.schedules {
+		copied := *schedule
+		copied.History = append([]*RenewalAttempt{}, schedule.History...)
+		results = append(results, &copied)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CertName < results[j].CertName
+	})
+	return results
+}
+
+// parseLeafCertificate parses the first certificate of a PEM bundle
+func parseLeafCertificate(certBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certBytes)
+	if block == nil {
+		return nil, errors.New("decode cert bytes failed")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func clampDuration(value time.Duration, fallback time.Duration, lower time.Duration, upper time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return min(max(value, lower), upper)
+}
+
+// certNameFromFilename strips the extension of a cert store filename
+func certNameFromFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
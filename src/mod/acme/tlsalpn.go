@@ -0,0 +1,71 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v5/challenge/tlsalpn01"
+)
+
+/*
+	tlsalpn.go
+
+	TLS-ALPN-01 challenge provider. Instead of binding its own port like the
+	lego provider server, the challenge certificates are kept in memory and
+	served by the main TLS listener when a client offers the acme-tls/1 ALPN
+*/
+
+// Challenge types that can be used to obtain a certificate
+const (
+	ChallengeTypeHTTP01    = "http-01"
+	ChallengeTypeTLSALPN01 = "tls-alpn-01"
+	ChallengeTypeDNS01     = "dns-01"
+)
+
+// IsValidChallengeType checks if the challenge type is supported
+func IsValidChallengeType(challengeType string) bool {
+	return challengeType == ChallengeTypeHTTP01 || challengeType == ChallengeTypeTLSALPN01 || challengeType == ChallengeTypeDNS01
+}
+
+// GetChallengeType returns the challenge type used to obtain the certificate.
+// Certificate info written by older versions only record the DNS flag.
+func (c *CertificateInfoJSON) GetChallengeType() string {
+	if IsValidChallengeType(c.ChallengeType) {
+		return c.ChallengeType
+	}
+	if c.UseDNS {
+		return ChallengeTypeDNS01
+	}
+	return ChallengeTypeHTTP01
+}
+
+// tlsALPNProvider keeps the challenge certificates of pending TLS-ALPN-01 validations
+type tlsALPNProvider struct {
+	certs sync.Map //Lower case domain to *tls.Certificate
+}
+
+func (p *tlsALPNProvider) Present(ctx context.Context, domain, token, keyAuth string) error {
+	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+	p.certs.Store(strings.ToLower(domain), cert)
+	return nil
+}
+
+func (p *tlsALPNProvider) CleanUp(ctx context.Context, domain, token, keyAuth string) error {
+	p.certs.Delete(strings.ToLower(domain))
+	return nil
+}
+
+// GetTLSALPNChallengeCert returns the challenge certificate of a pending
+// TLS-ALPN-01 validation for the domain
+func (a *ACMEHandler) GetTLSALPNChallengeCert(domain string) (*tls.Certificate, bool) {
+	cert, ok := a.alpnProvider.certs.Load(strings.ToLower(domain))
+	if !ok {
+		return nil, false
+	}
+	return cert.(*tls.Certificate), true
+}
@@ -0,0 +1,414 @@
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/netutils"
+)
+
+/*
+	auditlog.go
+
+	The audit log keep an append-only trail of every configuration
+	change made through the management API. Each mutating call write
+	one JSON object per line, split by month like other Zoraxy logs,
+	e.g. config_audit_2025-1.log
+*/
+
+const (
+	LOG_FILE_PREFIX = "config_audit_"
+	LOG_FILE_EXT    = ".log"
+)
+
+// Change is a single changed field between the before and after snapshot
+type Change struct {
+	Path   string      //Dot separated path of the field, e.g. VirtualDirectories[0].Domain
+	Before interface{} //nil if the field is added
+	After  interface{} //nil if the field is removed
+}
+
+type Entry struct {
+	ID        string            //Unique ID of the entry
+	Timestamp int64             //Unix timestamp of the call
+	User      string            //Username of the caller, empty if authentication is disabled
+	SourceIP  string            //IP address of the caller
+	Method    string            //HTTP method of the call
+	Route     string            //API route, e.g. /api/proxy/add
+	Targets   []string          //Proxy endpoints targeted by the call, if any
+	Params    map[string]string //Request parameters with secrets redacted
+	Success   bool              //If the call succeeded
+	Error     string            //Error message returned by the API, if any
+	Before    interface{}       //Configuration snapshot before the call
+	After     interface{}       //Configuration snapshot after the call
+	Changes   []*Change         //Diff between the before and after snapshot
+}
+
+type Options struct {
+	LogFolder string //Folder to write the audit log, audit log is disabled if empty
+
+	//Return the configuration touched by the route, called before and after
+	//the handler is executed. Return nil if the route has no snapshot
+	Snapshot func(route string, targets []string) interface{}
+
+	//Called after a successful change is written to the log, e.g. for emitting events
+	OnChange func(entry *Entry)
+
+	//Return true for routes that never change the configuration, e.g. network
+	//tools or connection tests, calls to these routes are not recorded
+	Ignore func(route string) bool
+
+	Logger *logger.Logger
+}
+
+type Logger struct {
+	options *Options
+
+	currentFile string
+	file        *os.File
+	mutex       sync.Mutex
+}
+
+// NewAuditLogger create a new configuration audit logger
+func NewAuditLogger(options *Options) (*Logger, error) {
+	if options.LogFolder != "" {
+		err := os.MkdirAll(options.LogFolder, 0775)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Logger{
+		options: options,
+	}, nil
+}
+
+func (l *Logger) getLogFilepath(t time.Time) string {
+	year, month, _ := t.Date()
+	return filepath.Join(l.options.LogFolder, LOG_FILE_PREFIX+strconv.Itoa(year)+"-"+strconv.Itoa(int(month))+LOG_FILE_EXT)
+}
+
+// Audit execute the handler of a mutating API call and record the change made by it
+func (l *Logger) Audit(w http.ResponseWriter, r *http.Request, route string, username string, targets []string, handler http.HandlerFunc) {
+	if l == nil || l.options.LogFolder == "" || (l.options.Ignore != nil && l.options.Ignore(route)) {
+		handler(w, r)
+		return
+	}
+
+	var before interface{}
+	if l.options.Snapshot != nil {
+		before = normalize(l.options.Snapshot(route, targets))
+	}
+
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	handler(recorder, r)
+
+	var after interface{}
+	if l.options.Snapshot != nil {
+		after = normalize(l.options.Snapshot(route, targets))
+	}
+
+	entry := &Entry{
+		ID:        uuid.NewString(),
+		Timestamp: time.Now().Unix(),
+		User:      username,
+		SourceIP:  netutils.GetRequesterIP(r),
+		Method:    r.Method,
+		Route:     route,
+		Targets:   targets,
+		Params:    redactParams(r),
+		Before:    redactSecrets(before),
+		After:     redactSecrets(after),
+		Changes:   Diff(before, after),
+	}
+	entry.Success, entry.Error = recorder.result()
+
+	err := l.Record(entry)
+	if err != nil && l.options.Logger != nil {
+		l.options.Logger.PrintAndLog("audit", "Unable to write audit log entry for "+route, err)
+	}
+}
+
+// Record append an entry to the audit log
+func (l *Logger) Record(entry *Entry) error {
+	if l == nil || l.options.LogFolder == "" {
+		return nil
+	}
+
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	logFilePath := l.getLogFilepath(time.Unix(entry.Timestamp, 0))
+	if l.file == nil || l.currentFile != logFilePath {
+		//First write or change of month
+		if l.file != nil {
+			l.file.Close()
+		}
+		f, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			l.file = nil
+			l.mutex.Unlock()
+			return err
+		}
+		l.file = f
+		l.currentFile = logFilePath
+	}
+	_, err = l.file.Write(append(js, '\n'))
+	l.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if entry.Success && l.options.OnChange != nil {
+		l.options.OnChange(entry)
+	}
+	return nil
+}
+
+// Query return the entries matching the filter newest first, together with the total number of matches.
+// Log files are read newest month first and only the entries of the requested page are fully decoded
+func (l *Logger) Query(filter *Filter) ([]*Entry, int, error) {
+	results := []*Entry{}
+	if l == nil || l.options.LogFolder == "" {
+		return results, 0, nil
+	}
+
+	logFiles, err := filepath.Glob(filepath.Join(l.options.LogFolder, LOG_FILE_PREFIX+"*"+LOG_FILE_EXT))
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(logFiles, func(i, j int) bool {
+		return logFileMonth(logFiles[i]).After(logFileMonth(logFiles[j]))
+	})
+
+	skip, limit := 0, 0
+	if filter != nil {
+		skip, limit = filter.Offset, filter.Limit
+	}
+
+	total := 0
+	for _, logFile := range logFiles {
+		if !filter.matchLogFile(filepath.Base(logFile)) {
+			continue
+		}
+
+		//Once the page is full the remaining files are only counted
+		pageFull := limit > 0 && len(results) >= limit
+		count, positions, err := scanLogFile(logFile, filter, !pageFull)
+		if err != nil {
+			return nil, 0, err
+		}
+		total += count
+		if pageFull {
+			continue
+		}
+		if skip >= len(positions) {
+			skip -= len(positions)
+			continue
+		}
+
+		//Entries are appended in time order, walk the matches backward for newest first
+		f, err := os.Open(logFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := len(positions) - 1 - skip; i >= 0 && (limit <= 0 || len(results) < limit); i-- {
+			entry, err := readLogEntry(f, positions[i])
+			if err != nil {
+				continue
+			}
+			results = append(results, entry)
+		}
+		f.Close()
+		skip = 0
+	}
+	return results, total, nil
+}
+
+// logFileMonth return the month of a log file, zero time if the filename cannot be parsed
+func logFileMonth(logFile string) time.Time {
+	yearMonth := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(logFile), LOG_FILE_PREFIX), LOG_FILE_EXT)
+	month, _ := time.ParseInLocation("2006-1", yearMonth, time.Local)
+	return month
+}
+
+// logPosition is the byte range of an entry in a log file
+type logPosition struct {
+	offset int64
+	length int
+}
+
+// entryHeader is the part of an entry used by the filter, the snapshots are
+// skipped when decoding so scanning a log file does not load them into memory
+type entryHeader struct {
+	ID        string
+	Timestamp int64
+	User      string
+	SourceIP  string
+	Route     string
+	Targets   []string
+	Success   bool
+}
+
+// scanLogFile count the entries in a log file matching the filter, the position of
+// each match is returned if keepPositions is set
+func scanLogFile(logFile string, filter *Filter, keepPositions bool) (int, []logPosition, error) {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	count := 0
+	positions := []logPosition{}
+	offset := int64(0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		position := logPosition{offset: offset, length: len(line)}
+		offset += int64(len(line)) + 1
+
+		header := entryHeader{}
+		if err := json.Unmarshal(line, &header); err != nil {
+			continue
+		}
+		if !filter.Match(&Entry{
+			ID:        header.ID,
+			Timestamp: header.Timestamp,
+			User:      header.User,
+			SourceIP:  header.SourceIP,
+			Route:     header.Route,
+			Targets:   header.Targets,
+			Success:   header.Success,
+		}) {
+			continue
+		}
+		count++
+		if keepPositions {
+			positions = append(positions, position)
+		}
+	}
+	return count, positions, scanner.Err()
+}
+
+// readLogEntry read and decode the full entry at the given position of a log file
+func readLogEntry(f *os.File, position logPosition) (*Entry, error) {
+	line := make([]byte, position.length)
+	if _, err := f.ReadAt(line, position.offset); err != nil {
+		return nil, err
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Close the audit log file
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// redactParams return the form parameters of the request with secrets replaced
+func redactParams(r *http.Request) map[string]string {
+	params := map[string]string{}
+	for key, values := range r.Form {
+		if len(values) == 0 {
+			continue
+		}
+		value := strings.Join(values, ",")
+		if isSecretParam(key) {
+			value = "[REDACTED]"
+		} else if len(value) > 1024 {
+			value = value[:1024] + "..."
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// isSecretParam match on the end of the parameter name, so settings about a
+// secret like keyType or inviteTokenTTL stay visible in the record
+func isSecretParam(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" || key == "pem" {
+		return true
+	}
+	for _, suffix := range []string{"password", "passwd", "pwd", "secret", "secretkey", "privatekey", "private_key", "apikey", "api_key", "token", "credentials", "cred", "creds"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseRecorder keep the status code and the start of the response body
+// to find out if the call succeeded
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if len(rr.body) < 4096 {
+		remaining := 4096 - len(rr.body)
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		rr.body = append(rr.body, b[:remaining]...)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// result return if the call succeeded and the error message of the API
+func (rr *responseRecorder) result() (bool, string) {
+	if rr.status >= 400 {
+		return false, strings.TrimSpace(string(rr.body))
+	}
+	//utils.SendErrorResponse reply with {"error":"..."} and status 200
+	errResp := struct {
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(rr.body, &errResp) == nil && errResp.Error != "" {
+		return false, errResp.Error
+	}
+	//Error messages are not escaped, so the reply might not be valid JSON
+	body := strings.TrimSpace(string(rr.body))
+	if strings.HasPrefix(body, `{"error":"`) {
+		return false, strings.TrimSuffix(strings.TrimPrefix(body, `{"error":"`), `"}`)
+	}
+	return true, ""
+}
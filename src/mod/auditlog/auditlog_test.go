@@ -0,0 +1,272 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+type testEndpoint struct {
+	Domain string
+	Tags   []string
+}
+
+// newTestAuditLogger creates an audit logger snapshotting the given endpoint config
+func newTestAuditLogger(t *testing.T, config map[string]*testEndpoint, changes *[]*Entry) *Logger {
+	l, err := NewAuditLogger(&Options{
+		LogFolder: t.TempDir(),
+		Snapshot: func(route string, targets []string) interface{} {
+			snapshot := map[string]interface{}{}
+			for _, target := range targets {
+				if endpoint, ok := config[target]; ok {
+					snapshot[target] = endpoint
+				}
+			}
+			return snapshot
+		},
+		OnChange: func(entry *Entry) {
+			*changes = append(*changes, entry)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.Close)
+	return l
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"a.example.com": &testEndpoint{Domain: "127.0.0.1:8080", Tags: []string{"web"}},
+		"b.example.com": &testEndpoint{Domain: "127.0.0.1:8081"},
+	}
+	after := map[string]interface{}{
+		"a.example.com": &testEndpoint{Domain: "127.0.0.1:9090", Tags: []string{"web", "team"}},
+	}
+
+	changes := Diff(before, after)
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	expected := []string{`["a.example.com"].Domain`, `["a.example.com"].Tags[1]`, `["b.example.com"]`}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected diff paths: %v", paths)
+	}
+	if changes[0].Before != "127.0.0.1:8080" || changes[0].After != "127.0.0.1:9090" {
+		t.Errorf("unexpected change values: %+v", changes[0])
+	}
+	if changes[2].After != nil {
+		t.Errorf("expected removed endpoint to have no after value: %+v", changes[2])
+	}
+	if len(Diff(before, before)) != 0 {
+		t.Error("expected no changes between identical snapshots")
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	type credential struct {
+		Username     string
+		PasswordHash string
+	}
+	before := map[string]interface{}{
+		"JWTAuthConfig": map[string]interface{}{"Secret": "old-secret", "Issuer": "a"},
+		"Credentials":   []*credential{{Username: "alice", PasswordHash: "old-hash"}},
+	}
+	after := map[string]interface{}{
+		"JWTAuthConfig": map[string]interface{}{"Secret": "new-secret", "Issuer": "a"},
+		"Credentials":   []*credential{{Username: "alice", PasswordHash: "old-hash"}, {Username: "bob", PasswordHash: "bob-hash"}},
+	}
+
+	changes := Diff(before, after)
+	js, _ := json.Marshal(changes)
+	snapshot, _ := json.Marshal(redactSecrets(normalize(after)))
+	for _, secret := range []string{"old-secret", "new-secret", "bob-hash", "old-hash"} {
+		if strings.Contains(string(js), secret) || strings.Contains(string(snapshot), secret) {
+			t.Fatalf("secret %q leaked into the audit log: %s %s", secret, js, snapshot)
+		}
+	}
+	if len(changes) != 2 || changes[1].Path != "JWTAuthConfig.Secret" || changes[1].After != redactedValue {
+		t.Fatalf("expected the secret change to be recorded redacted: %s", js)
+	}
+}
+
+func TestSecretParams(t *testing.T) {
+	secrets := []string{"password", "bpwd", "smtpPassword", "clientSecret", "captchaSecretKey", "access_token", "dnsCredentials", "key"}
+	for _, param := range secrets {
+		if !isSecretParam(param) {
+			t.Errorf("expected %q to be redacted", param)
+		}
+	}
+	//Parameters about a secret are not secret themselves
+	visible := []string{"keyType", "captchaSiteKey", "inviteTokenTTL", "disablePasswordLogin", "notifyPasskeyRegistration", "upstream"}
+	for _, param := range visible {
+		if isSecretParam(param) {
+			t.Errorf("expected %q to stay visible", param)
+		}
+	}
+}
+
+func TestAuditRecordsChanges(t *testing.T) {
+	config := map[string]*testEndpoint{"a.example.com": {Domain: "127.0.0.1:8080"}}
+	changes := []*Entry{}
+	l := newTestAuditLogger(t, config, &changes)
+
+	call := func(form url.Values, handler http.HandlerFunc) {
+		req := httptest.NewRequest(http.MethodPost, "/api/proxy/edit", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "10.0.0.5:40000"
+		req.ParseForm()
+		l.Audit(httptest.NewRecorder(), req, "/api/proxy/edit", "admin", []string{"a.example.com"}, handler)
+	}
+
+	call(url.Values{"ep": {"a.example.com"}, "upstream": {"127.0.0.1:9090"}, "bpwd": {"hunter2"}}, func(w http.ResponseWriter, r *http.Request) {
+		config["a.example.com"].Domain = r.PostForm.Get("upstream")
+		utils.SendOK(w)
+	})
+	call(url.Values{"ep": {"a.example.com"}}, func(w http.ResponseWriter, r *http.Request) {
+		utils.SendErrorResponse(w, "invalid upstream")
+	})
+
+	if len(changes) != 1 {
+		t.Fatalf("expected a change event for the successful call only, got %d", len(changes))
+	}
+
+	entries, total, err := l.Query(nil)
+	if err != nil || total != 2 {
+		t.Fatalf("expected 2 audit entries, got %d (%v)", total, err)
+	}
+	failed, succeeded := entries[0], entries[1]
+	if !succeeded.Success || succeeded.User != "admin" || succeeded.SourceIP != "10.0.0.5" {
+		t.Errorf("unexpected audit entry: %+v", succeeded)
+	}
+	if len(succeeded.Changes) != 1 || succeeded.Changes[0].Path != `["a.example.com"].Domain` || succeeded.Changes[0].After != "127.0.0.1:9090" {
+		t.Errorf("unexpected audit changes: %+v", succeeded.Changes)
+	}
+	if succeeded.Params["bpwd"] != "[REDACTED]" || succeeded.Params["upstream"] != "127.0.0.1:9090" {
+		t.Errorf("expected secrets to be redacted: %+v", succeeded.Params)
+	}
+	if failed.Success || failed.Error != "invalid upstream" || len(failed.Changes) != 0 {
+		t.Errorf("unexpected failed audit entry: %+v", failed)
+	}
+}
+
+func TestQueryFilterAndExport(t *testing.T) {
+	changes := []*Entry{}
+	l := newTestAuditLogger(t, nil, &changes)
+	now := time.Now().Unix()
+	records := []*Entry{
+		{ID: "1", Timestamp: now - 300, User: "admin", SourceIP: "10.0.0.1", Route: "/api/proxy/add", Targets: []string{"a.example.com"}, Success: true},
+		{ID: "2", Timestamp: now - 200, User: "operator", SourceIP: "10.0.0.2", Route: "/api/access/create", Success: true},
+		{ID: "3", Timestamp: now - 100, User: "admin", SourceIP: "10.0.0.1", Route: "/api/proxy/del", Targets: []string{"b.example.com"}, Success: false},
+	}
+	for _, record := range records {
+		if err := l.Record(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	success := true
+	testcases := []struct {
+		name     string
+		filter   *Filter
+		expected string
+	}{
+		{"All", &Filter{}, "3,2,1"},
+		{"ByUser", &Filter{User: "admin"}, "3,1"},
+		{"ByRoute", &Filter{Route: "/api/proxy/"}, "3,1"},
+		{"ByTarget", &Filter{Target: "b.example"}, "3"},
+		{"ByIP", &Filter{SourceIP: "10.0.0.2"}, "2"},
+		{"BySuccess", &Filter{Success: &success}, "2,1"},
+		{"ByTime", &Filter{Since: now - 250, Until: now - 150}, "2"},
+		{"Paged", &Filter{Limit: 1, Offset: 1}, "2"},
+	}
+	for _, tc := range testcases {
+		entries, _, err := l.Query(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if strings.Join(ids, ",") != tc.expected {
+			t.Errorf("%s: expected %s, got %v", tc.name, tc.expected, ids)
+		}
+	}
+
+	//Query API
+	rec := httptest.NewRecorder()
+	l.HandleQuery(rec, httptest.NewRequest(http.MethodGet, "/api/audit/list?user=admin&limit=1", nil))
+	resp := struct {
+		Total   int
+		Entries []*Entry
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Total != 2 || len(resp.Entries) != 1 || resp.Entries[0].ID != "3" {
+		t.Errorf("unexpected query response: %s", rec.Body.String())
+	}
+
+	//CSV export
+	rec = httptest.NewRecorder()
+	l.HandleExport(rec, httptest.NewRequest(http.MethodGet, "/api/audit/export?format=csv&route=/api/access/", nil))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "2,") || !strings.Contains(rec.Header().Get("Content-Disposition"), ".csv") {
+		t.Errorf("unexpected csv export: %s", rec.Body.String())
+	}
+}
+
+func TestQueryPagesAcrossMonths(t *testing.T) {
+	changes := []*Entry{}
+	l := newTestAuditLogger(t, nil, &changes)
+	month := func(year int, m time.Month, day int) int64 {
+		return time.Date(year, m, day, 12, 0, 0, 0, time.Local).Unix()
+	}
+	records := []*Entry{
+		{ID: "dec", Timestamp: month(2024, time.December, 1), Route: "/api/proxy/add"},
+		{ID: "jan1", Timestamp: month(2025, time.January, 1), Route: "/api/proxy/add"},
+		{ID: "jan2", Timestamp: month(2025, time.January, 2), Route: "/api/proxy/add", Before: map[string]interface{}{"large": strings.Repeat("x", 1024)}},
+		{ID: "feb", Timestamp: month(2025, time.February, 1), Route: "/api/proxy/add"},
+		{ID: "other", Timestamp: month(2025, time.February, 2), Route: "/api/access/create"},
+	}
+	for _, record := range records {
+		if err := l.Record(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		name     string
+		filter   *Filter
+		expected string
+	}{
+		{"NewestMonthFirst", &Filter{Route: "/api/proxy/"}, "feb,jan2,jan1,dec"},
+		{"PageAcrossFiles", &Filter{Route: "/api/proxy/", Limit: 2, Offset: 1}, "jan2,jan1"},
+		{"SkipWholeFile", &Filter{Route: "/api/proxy/", Limit: 5, Offset: 3}, "dec"},
+		{"PastTheEnd", &Filter{Route: "/api/proxy/", Limit: 5, Offset: 10}, ""},
+	}
+	for _, tc := range testcases {
+		entries, total, err := l.Query(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if strings.Join(ids, ",") != tc.expected || total != 4 {
+			t.Errorf("%s: expected %s of 4, got %v of %d", tc.name, tc.expected, ids, total)
+		}
+	}
+
+	//Entries of the page are fully decoded
+	entries, _, _ := l.Query(&Filter{ID: "jan2"})
+	if len(entries) != 1 || entries[0].Before == nil {
+		t.Errorf("expected the snapshot of the entry to be returned, got %+v", entries)
+	}
+}
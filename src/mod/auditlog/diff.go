@@ -0,0 +1,167 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	diff.go
+
+	Generic JSON diff between two configuration snapshots. Snapshots
+	are first normalized into plain JSON values (maps, slices, strings,
+	numbers and booleans) so any serializable config struct can be compared
+
+	Secret fields, e.g. JWT secrets and password hashes, are compared
+	but never written to the log, a changed secret shows up as a change
+	of a redacted value
+*/
+
+const redactedValue = "[REDACTED]"
+
+// normalize convert a snapshot into its plain JSON representation
+func normalize(snapshot interface{}) interface{} {
+	if snapshot == nil {
+		return nil
+	}
+	js, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(js, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// Diff return the list of changed fields between two snapshots
+func Diff(before interface{}, after interface{}) []*Change {
+	changes := []*Change{}
+	diffValue("", "", normalize(before), normalize(after), &changes)
+	return changes
+}
+
+func diffValue(path string, key string, before interface{}, after interface{}, changes *[]*Change) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := []string{}
+		for key := range beforeMap {
+			keys = append(keys, key)
+		}
+		for key := range afterMap {
+			if _, ok := beforeMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffValue(joinPath(path, key), key, beforeMap[key], afterMap[key], changes)
+		}
+		return
+	}
+
+	beforeSlice, beforeIsSlice := before.([]interface{})
+	afterSlice, afterIsSlice := after.([]interface{})
+	if beforeIsSlice && afterIsSlice {
+		length := len(beforeSlice)
+		if len(afterSlice) > length {
+			length = len(afterSlice)
+		}
+		for i := 0; i < length; i++ {
+			var beforeItem, afterItem interface{}
+			if i < len(beforeSlice) {
+				beforeItem = beforeSlice[i]
+			}
+			if i < len(afterSlice) {
+				afterItem = afterSlice[i]
+			}
+			diffValue(path+"["+strconv.Itoa(i)+"]", key, beforeItem, afterItem, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		if isSecretField(key) {
+			before, after = redactValue(before), redactValue(after)
+		} else {
+			//Added or removed objects might contain secrets
+			before, after = redactSecrets(before), redactSecrets(after)
+		}
+		*changes = append(*changes, &Change{
+			Path:   path,
+			Before: before,
+			After:  after,
+		})
+	}
+}
+
+// redactSecrets replace the secret fields of a normalized snapshot
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for key, field := range v {
+			if isSecretField(key) {
+				redacted[key] = redactValue(field)
+			} else {
+				redacted[key] = redactSecrets(field)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactSecrets(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// redactValue hide a secret, keeping empty values visible so clearing or
+// setting a secret can still be told apart in the log
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return ""
+		}
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactValue(item)
+		}
+		return redacted
+	}
+	return redactedValue
+}
+
+// isSecretField checks if a config field or database key holds a secret by
+// its name, e.g. Secret, SecretKey, PasswordHash or oidc_signing_key
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	for _, keyword := range []string{"secret", "password", "passwd", "privatekey", "hmac", "signing_key", "_credentials"} {
+		if strings.Contains(key, keyword) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "hash")
+}
+
+func joinPath(path string, key string) string {
+	//Hostnames and paths are quoted to keep the path readable
+	if strings.ContainsAny(key, ".[]/ ") {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,189 @@
+package auditlog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	handler.go
+
+	HTTP handlers for querying and exporting the audit log
+*/
+
+type Filter struct {
+	ID       string //Exact entry ID
+	User     string //Exact username
+	Route    string //Route prefix, e.g. /api/proxy/
+	SourceIP string //Exact source IP
+	Target   string //Substring of the targeted proxy endpoints
+	Since    int64  //Unix timestamp, inclusive
+	Until    int64  //Unix timestamp, inclusive
+	Success  *bool  //Only successful or failed calls
+	Limit    int
+	Offset   int
+}
+
+// Match check if the entry match the filter
+func (f *Filter) Match(entry *Entry) bool {
+	if f == nil {
+		return true
+	}
+	if f.ID != "" && entry.ID != f.ID {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(entry.User, f.User) {
+		return false
+	}
+	if f.Route != "" && !strings.HasPrefix(entry.Route, f.Route) {
+		return false
+	}
+	if f.SourceIP != "" && entry.SourceIP != f.SourceIP {
+		return false
+	}
+	if f.Since > 0 && entry.Timestamp < f.Since {
+		return false
+	}
+	if f.Until > 0 && entry.Timestamp > f.Until {
+		return false
+	}
+	if f.Success != nil && entry.Success != *f.Success {
+		return false
+	}
+	if f.Target != "" {
+		matched := false
+		for _, target := range entry.Targets {
+			if strings.Contains(strings.ToLower(target), strings.ToLower(f.Target)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchLogFile check if the monthly log file might contain entries within the time range
+func (f *Filter) matchLogFile(filename string) bool {
+	if f == nil || (f.Since == 0 && f.Until == 0) {
+		return true
+	}
+	yearMonth := strings.TrimSuffix(strings.TrimPrefix(filename, LOG_FILE_PREFIX), LOG_FILE_EXT)
+	start, err := time.ParseInLocation("2006-1", yearMonth, time.Local)
+	if err != nil {
+		return true
+	}
+	end := start.AddDate(0, 1, 0)
+	if f.Since > 0 && end.Unix() <= f.Since {
+		return false
+	}
+	if f.Until > 0 && start.Unix() > f.Until {
+		return false
+	}
+	return true
+}
+
+// ParseFilter read the filter from the request query
+func ParseFilter(r *http.Request) *Filter {
+	query := r.URL.Query()
+	filter := &Filter{
+		ID:       strings.TrimSpace(query.Get("id")),
+		User:     strings.TrimSpace(query.Get("user")),
+		Route:    strings.TrimSpace(query.Get("route")),
+		SourceIP: strings.TrimSpace(query.Get("ip")),
+		Target:   strings.TrimSpace(query.Get("target")),
+	}
+	filter.Since, _ = strconv.ParseInt(query.Get("since"), 10, 64)
+	filter.Until, _ = strconv.ParseInt(query.Get("until"), 10, 64)
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+	if success, err := strconv.ParseBool(query.Get("success")); err == nil {
+		filter.Success = &success
+	}
+	return filter
+}
+
+// HandleQuery list the audit entries matching the filter in the request query.
+// Snapshots are only included if full=true is set
+func (l *Logger) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	filter := ParseFilter(r)
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+
+	entries, total, err := l.Query(filter)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	full, _ := utils.GetBool(r, "full")
+	if !full {
+		for _, entry := range entries {
+			entry.Before = nil
+			entry.After = nil
+		}
+	}
+
+	js, _ := json.Marshal(struct {
+		Total   int
+		Entries []*Entry
+	}{
+		Total:   total,
+		Entries: entries,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleExport download all audit entries matching the filter as json or csv
+func (l *Logger) HandleExport(w http.ResponseWriter, r *http.Request) {
+	filter := ParseFilter(r)
+	entries, _, err := l.Query(filter)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	filename := "zoraxy_audit_" + time.Now().Format("2006-01-02")
+	format, _ := utils.GetPara(r, "format")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"ID", "Time", "User", "SourceIP", "Method", "Route", "Targets", "Success", "Error", "Changes"})
+		for _, entry := range entries {
+			changes, _ := json.Marshal(entry.Changes)
+			writer.Write([]string{
+				entry.ID,
+				time.Unix(entry.Timestamp, 0).Format(time.RFC3339),
+				entry.User,
+				entry.SourceIP,
+				entry.Method,
+				entry.Route,
+				strings.Join(entry.Targets, " "),
+				strconv.FormatBool(entry.Success),
+				entry.Error,
+				string(changes),
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	js, err := json.MarshalIndent(entries, "", " ")
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".json\"")
+	w.Write(js)
+}
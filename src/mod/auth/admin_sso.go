@@ -0,0 +1,625 @@
+package auth
+
+/*
+	admin_sso.go
+
+	This file handle the single sign-on of the management interface.
+
+	Admins can sign in through an external OpenID Connect provider or
+	through the ZorxAuth built-in OIDC provider. The role and scope of
+	the account are mapped from the group claim on every login, so removing
+	a user from the mapped groups at the identity provider revokes the
+	access. Accounts are provisioned on first login and cannot sign in
+	with a password. Password login can be limited to break-glass accounts
+	for when the identity provider is not reachable.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+const (
+	DB_ADMIN_SSO_SETTINGS_KEY = "admin_sso/settings"
+	DB_SSO_USER_KEY_PREFIX    = "sso_user/"
+
+	ADMIN_SSO_LOGIN_PATH    = "/api/auth/sso/login"
+	ADMIN_SSO_CALLBACK_PATH = "/api/auth/sso/callback"
+	ADMIN_SSO_STATE_COOKIE  = "zoraxy_sso_state"
+	ADMIN_SSO_STATE_TIMEOUT = 10 * time.Minute
+)
+
+type AdminSSOProvider string
+
+const (
+	AdminSSOProviderOIDC     AdminSSOProvider = "oidc"     //External OpenID Connect provider
+	AdminSSOProviderZorxAuth AdminSSOProvider = "zorxauth" //ZorxAuth built-in OIDC provider
+)
+
+// AdminSSOGroupMapping grants a role and scope to members of an identity provider group
+type AdminSSOGroupMapping struct {
+	Group string       `json:"group"`
+	Role  Role         `json:"role"`
+	Scope *AccessScope `json:"scope"`
+}
+
+type AdminSSOSettings struct {
+	Enabled         bool                    `json:"enabled"`
+	Provider        AdminSSOProvider        `json:"provider"`
+	IssuerURL       string                  `json:"issuerUrl"` //Issuer of the external provider, discovery is loaded from /.well-known/openid-configuration
+	ClientID        string                  `json:"clientId"`
+	ClientSecret    string                  `json:"clientSecret"`
+	Scopes          []string                `json:"scopes"`
+	UsernameClaim   string                  `json:"usernameClaim"`
+	GroupsClaim     string                  `json:"groupsClaim"`     //Claim name or dotted path, e.g. groups, realm_access.roles
+	GroupMappings   []*AdminSSOGroupMapping `json:"groupMappings"`   //First matching group wins
+	SessionLifetime int                     `json:"sessionLifetime"` //Hours before SSO users need to sign in again
+
+	DisablePasswordLogin bool     `json:"disablePasswordLogin"` //Only break-glass accounts can sign in with password
+	BreakGlassAccounts   []string `json:"breakGlassAccounts"`   //Local accounts that can always sign in with password
+}
+
+// SSOUser is the identity provider record of an account provisioned by SSO login
+type SSOUser struct {
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
+	LastLogin int64  `json:"lastLogin"`
+}
+
+type adminSSOState struct {
+	Verifier string //PKCE code verifier
+	Nonce    string
+	Expires  time.Time
+}
+
+type adminSSODiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type adminSSOProvider struct {
+	issuerURL string
+	clientID  string
+	discovery *adminSSODiscovery
+	verifier  *oidc.Verifier
+	loaded    time.Time
+}
+
+var ssoHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// adminSSORuntime keeps the pending logins and the discovered provider
+type adminSSORuntime struct {
+	states   sync.Map //state -> *adminSSOState
+	mutex    sync.Mutex
+	provider *adminSSOProvider
+}
+
+func getDefaultAdminSSOSettings() *AdminSSOSettings {
+	return &AdminSSOSettings{
+		Provider:        AdminSSOProviderOIDC,
+		Scopes:          []string{"openid", "profile", "email", "groups"},
+		UsernameClaim:   "preferred_username",
+		GroupsClaim:     "groups",
+		GroupMappings:   []*AdminSSOGroupMapping{},
+		SessionLifetime: 8,
+	}
+}
+
+// Validate checks the SSO settings
+func (s *AdminSSOSettings) Validate() error {
+	if s.Provider != AdminSSOProviderOIDC && s.Provider != AdminSSOProviderZorxAuth {
+		return errors.New("invalid provider")
+	}
+	if !s.Enabled {
+		return nil
+	}
+	if s.Provider == AdminSSOProviderOIDC {
+		issuer, err := url.Parse(s.IssuerURL)
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			return errors.New("invalid issuer URL")
+		}
+	}
+	if s.ClientID == "" {
+		return errors.New("client ID is required")
+	}
+	if len(s.GroupMappings) == 0 {
+		return errors.New("at least one group mapping is required")
+	}
+	for _, mapping := range s.GroupMappings {
+		if strings.TrimSpace(mapping.Group) == "" {
+			return errors.New("group name cannot be empty")
+		}
+		if !mapping.Role.IsValid() {
+			return errors.New("invalid role for group " + mapping.Group)
+		}
+	}
+	if s.SessionLifetime <= 0 {
+		return errors.New("session lifetime must be at least one hour")
+	}
+	return nil
+}
+
+// IsBreakGlassAccount checks if the account can always sign in with password
+func (s *AdminSSOSettings) IsBreakGlassAccount(username string) bool {
+	for _, account := range s.BreakGlassAccounts {
+		if account == username {
+			return true
+		}
+	}
+	return false
+}
+
+// mapGroups returns the first group mapping matching the groups of the user
+func (s *AdminSSOSettings) mapGroups(groups []string) *AdminSSOGroupMapping {
+	for _, mapping := range s.GroupMappings {
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) {
+				return mapping
+			}
+		}
+	}
+	return nil
+}
+
+/* ===================== Settings & Accounts Storage ===================== */
+
+// GetAdminSSOSettings returns the SSO settings of the management interface
+func (a *AuthAgent) GetAdminSSOSettings() *AdminSSOSettings {
+	settings := getDefaultAdminSSOSettings()
+	if a.Database.KeyExists("auth", DB_ADMIN_SSO_SETTINGS_KEY) {
+		a.Database.Read("auth", DB_ADMIN_SSO_SETTINGS_KEY, settings)
+		clientSecret, err := secrets.OpenString(settings.ClientSecret)
+		if err != nil && a.Logger != nil {
+			a.Logger.PrintAndLog("auth", "Failed to decrypt the SSO client secret", err)
+		}
+		settings.ClientSecret = clientSecret
+	}
+	return settings
+}
+
+// SetAdminSSOSettings validates and saves the SSO settings of the management interface
+func (a *AuthAgent) SetAdminSSOSettings(settings *AdminSSOSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	//Make sure admins cannot lock themselves out when the identity provider is down
+	if settings.Enabled && settings.DisablePasswordLogin {
+		hasBreakGlassAdmin := false
+		for _, username := range settings.BreakGlassAccounts {
+			user := a.GetAdminUser(username)
+			if a.UserExists(username) && !a.IsSSOUser(username) && user.Role == RoleAdmin && user.Scope == nil {
+				hasBreakGlassAdmin = true
+				break
+			}
+		}
+		if !hasBreakGlassAdmin {
+			return errors.New("a local admin break-glass account is required to disable password login")
+		}
+	}
+
+	//The client secret is sealed if secrets encryption is enabled
+	stored := *settings
+	clientSecret, err := secrets.SealString(settings.ClientSecret)
+	if err != nil {
+		return err
+	}
+	stored.ClientSecret = clientSecret
+	err = a.Database.Write("auth", DB_ADMIN_SSO_SETTINGS_KEY, &stored)
+	if err != nil {
+		return err
+	}
+
+	//Rediscover the provider on next login
+	a.sso.mutex.Lock()
+	a.sso.provider = nil
+	a.sso.mutex.Unlock()
+	return nil
+}
+
+// IsSSOUser checks if the account is provisioned by SSO login
+func (a *AuthAgent) IsSSOUser(username string) bool {
+	return a.Database.KeyExists("auth", DB_SSO_USER_KEY_PREFIX+username)
+}
+
+// GetSSOUser returns the identity provider record of an SSO account
+func (a *AuthAgent) GetSSOUser(username string) (*SSOUser, error) {
+	ssoUser := SSOUser{}
+	err := a.Database.Read("auth", DB_SSO_USER_KEY_PREFIX+username, &ssoUser)
+	if err != nil {
+		return nil, err
+	}
+	return &ssoUser, nil
+}
+
+// CheckPasswordLoginAllowed checks if the account can sign in with its password
+func (a *AuthAgent) CheckPasswordLoginAllowed(username string) error {
+	if a.IsSSOUser(username) {
+		return errors.New("This account signs in with SSO")
+	}
+	settings := a.GetAdminSSOSettings()
+	if settings.Enabled && settings.DisablePasswordLogin && !settings.IsBreakGlassAccount(username) {
+		return errors.New("Password login is disabled, please sign in with SSO")
+	}
+	return nil
+}
+
+// checkSSOSessionExpired checks if the SSO login of the session is older than the session lifetime
+func (a *AuthAgent) checkSSOSessionExpired(loginTime int64) bool {
+	settings := a.GetAdminSSOSettings()
+	if !settings.Enabled {
+		//SSO turned off, SSO sessions are no longer valid
+		return true
+	}
+	return time.Since(time.Unix(loginTime, 0)) > time.Duration(settings.SessionLifetime)*time.Hour
+}
+
+/* ===================== OIDC Flow ===================== */
+
+// getAdminSSOIssuer returns the issuer URL of the configured provider
+func (a *AuthAgent) getAdminSSOIssuer(settings *AdminSSOSettings) (string, error) {
+	if settings.Provider == AdminSSOProviderZorxAuth {
+		if a.ZorxAuthIssuer == nil || a.ZorxAuthIssuer() == "" {
+			return "", errors.New("ZorxAuth SSO portal URL is not set")
+		}
+		return strings.TrimRight(a.ZorxAuthIssuer(), "/"), nil
+	}
+	return strings.TrimRight(settings.IssuerURL, "/"), nil
+}
+
+// getAdminSSOProvider returns the discovered provider, the discovery document is cached for an hour
+func (a *AuthAgent) getAdminSSOProvider(settings *AdminSSOSettings) (*adminSSOProvider, error) {
+	issuerURL, err := a.getAdminSSOIssuer(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	a.sso.mutex.Lock()
+	defer a.sso.mutex.Unlock()
+	current := a.sso.provider
+	if current != nil && current.issuerURL == issuerURL && current.clientID == settings.ClientID && time.Since(current.loaded) < time.Hour {
+		return current, nil
+	}
+
+	resp, err := ssoHTTPClient.Get(issuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery document returned %s", resp.Status)
+	}
+	discovery := adminSSODiscovery{}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+	if discovery.Issuer == "" {
+		discovery.Issuer = issuerURL
+	}
+
+	provider := &adminSSOProvider{
+		issuerURL: issuerURL,
+		clientID:  settings.ClientID,
+		discovery: &discovery,
+		loaded:    time.Now(),
+	}
+	if current != nil && current.verifier.KeySet.URL == discovery.JwksURI {
+		//Keep the cached key set
+		provider.verifier = oidc.NewVerifier(discovery.Issuer, settings.ClientID, current.verifier.KeySet)
+	} else {
+		provider.verifier = oidc.NewVerifier(discovery.Issuer, settings.ClientID, oidc.NewKeySet(discovery.JwksURI))
+	}
+	a.sso.provider = provider
+	return provider, nil
+}
+
+// getAdminSSOCallbackURL returns the redirect URI to register at the identity provider
+func getAdminSSOCallbackURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + ADMIN_SSO_CALLBACK_PATH
+}
+
+func (a *AuthAgent) newAdminSSOConfig(r *http.Request, settings *AdminSSOSettings, provider *adminSSOProvider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     settings.ClientID,
+		ClientSecret: settings.ClientSecret,
+		RedirectURL:  getAdminSSOCallbackURL(r),
+		Scopes:       settings.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.discovery.AuthorizationEndpoint,
+			TokenURL: provider.discovery.TokenEndpoint,
+		},
+	}
+}
+
+func generateSSOToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redirectSSOError sends the browser back to the login page with the error message
+func redirectSSOError(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, "/login.html?sso_error="+url.QueryEscape(message), http.StatusTemporaryRedirect)
+}
+
+// HandleAdminSSOLogin redirects the browser to the identity provider
+func (a *AuthAgent) HandleAdminSSOLogin(w http.ResponseWriter, r *http.Request) {
+	settings := a.GetAdminSSOSettings()
+	if !settings.Enabled {
+		redirectSSOError(w, r, "SSO login is not enabled")
+		return
+	}
+	provider, err := a.getAdminSSOProvider(settings)
+	if err != nil {
+		a.Logger.PrintAndLog("auth", "Unable to load SSO provider configuration", err)
+		redirectSSOError(w, r, "SSO provider is not available")
+		return
+	}
+
+	state := generateSSOToken()
+	pending := &adminSSOState{
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    generateSSOToken(),
+		Expires:  time.Now().Add(ADMIN_SSO_STATE_TIMEOUT),
+	}
+	a.sso.states.Store(state, pending)
+	time.AfterFunc(ADMIN_SSO_STATE_TIMEOUT, func() {
+		a.sso.states.Delete(state)
+	})
+
+	//Bind the state to this browser to prevent login CSRF
+	http.SetCookie(w, &http.Cookie{
+		Name:     ADMIN_SSO_STATE_COOKIE,
+		Value:    state,
+		Path:     ADMIN_SSO_CALLBACK_PATH,
+		MaxAge:   int(ADMIN_SSO_STATE_TIMEOUT.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	config := a.newAdminSSOConfig(r, settings, provider)
+	http.Redirect(w, r, config.AuthCodeURL(state, oauth2.S256ChallengeOption(pending.Verifier), oauth2.SetAuthURLParam("nonce", pending.Nonce)), http.StatusTemporaryRedirect)
+}
+
+// HandleAdminSSOCallback completes the SSO login and creates the management session
+func (a *AuthAgent) HandleAdminSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		a.Logger.PrintAndLog("auth", "SSO login rejected by identity provider: "+errCode, nil)
+		redirectSSOError(w, r, "SSO login rejected by identity provider")
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	stateCookie, err := r.Cookie(ADMIN_SSO_STATE_COOKIE)
+	if state == "" || err != nil || stateCookie.Value != state {
+		redirectSSOError(w, r, "Invalid SSO login state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: ADMIN_SSO_STATE_COOKIE, Value: "", Path: ADMIN_SSO_CALLBACK_PATH, MaxAge: -1})
+	pendingObj, ok := a.sso.states.LoadAndDelete(state)
+	if !ok || time.Now().After(pendingObj.(*adminSSOState).Expires) {
+		redirectSSOError(w, r, "SSO login expired, please try again")
+		return
+	}
+	pending := pendingObj.(*adminSSOState)
+
+	settings := a.GetAdminSSOSettings()
+	if !settings.Enabled {
+		redirectSSOError(w, r, "SSO login is not enabled")
+		return
+	}
+	provider, err := a.getAdminSSOProvider(settings)
+	if err != nil {
+		a.Logger.PrintAndLog("auth", "Unable to load SSO provider configuration", err)
+		redirectSSOError(w, r, "SSO provider is not available")
+		return
+	}
+
+	//Exchange the code and verify the ID token
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, ssoHTTPClient)
+	config := a.newAdminSSOConfig(r, settings, provider)
+	token, err := config.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		a.Logger.PrintAndLog("auth", "SSO token exchange failed", err)
+		redirectSSOError(w, r, "SSO token exchange failed")
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		redirectSSOError(w, r, "Identity provider did not return an ID token")
+		return
+	}
+	claims, err := provider.verifier.VerifyIDToken(rawIDToken)
+	if err != nil {
+		a.Logger.PrintAndLog("auth", "SSO ID token verification failed", err)
+		redirectSSOError(w, r, "Invalid ID token")
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != pending.Nonce {
+		redirectSSOError(w, r, "Invalid ID token nonce")
+		return
+	}
+
+	username, err := a.loginAdminSSOUser(settings, provider.discovery.Issuer, claims)
+	if err != nil {
+		a.Logger.PrintAndLog("auth", "SSO login rejected: "+err.Error(), nil)
+		redirectSSOError(w, r, err.Error())
+		return
+	}
+
+	a.saveLoginSession(w, r, username, false, time.Now().Unix())
+	a.Logger.PrintAndLog("auth", username+" logged in with SSO", nil)
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// loginAdminSSOUser maps the claims of the verified ID token to a management account,
+// provisioning the account on first login. Accounts that no longer match any group are removed
+func (a *AuthAgent) loginAdminSSOUser(settings *AdminSSOSettings, issuer string, claims map[string]interface{}) (string, error) {
+	subject, _ := claims["sub"].(string)
+	usernames := oidc.ClaimValues(claims, settings.UsernameClaim)
+	if subject == "" || len(usernames) == 0 {
+		return "", errors.New("ID token has no " + settings.UsernameClaim + " claim")
+	}
+	username := strings.TrimSpace(usernames[0])
+	if username == "" || strings.Contains(username, "/") {
+		return "", errors.New("invalid username from identity provider")
+	}
+
+	//Do not take over local accounts or accounts of another identity
+	if a.UserExists(username) {
+		ssoUser, err := a.GetSSOUser(username)
+		if err != nil {
+			return "", errors.New("a local account named " + username + " already exists")
+		}
+		if ssoUser.Issuer != issuer || ssoUser.Subject != subject {
+			return "", errors.New("account " + username + " belongs to another identity")
+		}
+	}
+
+	mapping := settings.mapGroups(oidc.ClaimValues(claims, settings.GroupsClaim))
+	if mapping == nil {
+		//Offboarded or never granted, remove the provisioned account
+		if a.IsSSOUser(username) {
+			a.UnregisterUser(username)
+			a.Logger.PrintAndLog("auth", "SSO account "+username+" removed as it no longer belongs to a mapped group", nil)
+		}
+		return "", errors.New("you are not a member of any group with access to this management interface")
+	}
+
+	if !a.UserExists(username) {
+		email, _ := claims["email"].(string)
+		//SSO accounts cannot sign in with password, use an unknown random password
+		if err := a.CreateUserAccount(username, generateSSOToken(), email); err != nil {
+			return "", err
+		}
+		a.Logger.PrintAndLog("auth", "Management account "+username+" provisioned by SSO login", nil)
+	}
+	if err := a.SetUserRoleAndScope(username, mapping.Role, mapping.Scope); err != nil {
+		return "", err
+	}
+	err := a.Database.Write("auth", DB_SSO_USER_KEY_PREFIX+username, &SSOUser{
+		Issuer:    issuer,
+		Subject:   subject,
+		LastLogin: time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleAdminSSOStatus returns the SSO login options for the login page
+func (a *AuthAgent) HandleAdminSSOStatus(w http.ResponseWriter, r *http.Request) {
+	settings := a.GetAdminSSOSettings()
+	js, _ := json.Marshal(map[string]interface{}{
+		"enabled":              settings.Enabled,
+		"provider":             settings.Provider,
+		"disablePasswordLogin": settings.Enabled && settings.DisablePasswordLogin,
+		"loginUrl":             ADMIN_SSO_LOGIN_PATH,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleAdminSSOSettings handles the SSO settings API endpoints
+func (a *AuthAgent) HandleAdminSSOSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleAdminSSOSettingsGET(w, r)
+	case http.MethodPost:
+		a.handleAdminSSOSettingsPOST(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminSSOSettingsGET returns the SSO settings, the client secret is never returned
+func (a *AuthAgent) handleAdminSSOSettingsGET(w http.ResponseWriter, r *http.Request) {
+	settings := *a.GetAdminSSOSettings()
+	secretSet := settings.ClientSecret != ""
+	settings.ClientSecret = ""
+
+	zorxAuthIssuer := ""
+	if a.ZorxAuthIssuer != nil {
+		zorxAuthIssuer = a.ZorxAuthIssuer()
+	}
+	js, _ := json.Marshal(map[string]interface{}{
+		"settings":        settings,
+		"clientSecretSet": secretSet,
+		"callbackUrl":     getAdminSSOCallbackURL(r),
+		"zorxAuthIssuer":  zorxAuthIssuer,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleAdminSSOSettingsPOST updates the SSO settings, the stored client secret
+// is kept if no new secret is given
+func (a *AuthAgent) handleAdminSSOSettingsPOST(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	settings := getDefaultAdminSSOSettings()
+	settings.Enabled, _ = utils.PostBool(r, "enabled")
+	settings.Provider = AdminSSOProvider(strings.TrimSpace(r.PostForm.Get("provider")))
+	settings.IssuerURL = strings.TrimSpace(r.PostForm.Get("issuerUrl"))
+	settings.ClientID = strings.TrimSpace(r.PostForm.Get("clientId"))
+	settings.ClientSecret = r.PostForm.Get("clientSecret")
+	if settings.ClientSecret == "" {
+		settings.ClientSecret = a.GetAdminSSOSettings().ClientSecret
+	}
+	if scopes := strings.Fields(strings.ReplaceAll(r.PostForm.Get("scopes"), ",", " ")); len(scopes) > 0 {
+		settings.Scopes = scopes
+	}
+	if claim := strings.TrimSpace(r.PostForm.Get("usernameClaim")); claim != "" {
+		settings.UsernameClaim = claim
+	}
+	if claim := strings.TrimSpace(r.PostForm.Get("groupsClaim")); claim != "" {
+		settings.GroupsClaim = claim
+	}
+	if lifetime, err := utils.PostInt(r, "sessionLifetime"); err == nil {
+		settings.SessionLifetime = lifetime
+	}
+	if groupMappings := r.PostForm.Get("groupMappings"); groupMappings != "" {
+		if err := json.Unmarshal([]byte(groupMappings), &settings.GroupMappings); err != nil {
+			utils.SendErrorResponse(w, "invalid group mappings")
+			return
+		}
+	}
+	settings.DisablePasswordLogin, _ = utils.PostBool(r, "disablePasswordLogin")
+	for _, account := range strings.Split(r.PostForm.Get("breakGlassAccounts"), ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			settings.BreakGlassAccounts = append(settings.BreakGlassAccounts, account)
+		}
+	}
+
+	err := a.SetAdminSSOSettings(settings)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.Logger.PrintAndLog("auth", "Management interface SSO settings updated", nil)
+	utils.SendOK(w)
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
+)
+
+const testSSOClientID = "zoraxy-admin"
+
+// mockSSOIssuer is a local OIDC issuer that signs ID tokens for the next user
+type mockSSOIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	nonce  string
+	claims jwt.MapClaims
+}
+
+func newMockSSOIssuer(t *testing.T) *mockSSOIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockSSOIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig",
+			"n": encode(key.PublicKey.N.Bytes()), "e": encode(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		issuer.mutex.Lock()
+		claims := jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"aud":   testSSOClientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": issuer.nonce,
+		}
+		for k, v := range issuer.claims {
+			claims[k] = v
+		}
+		issuer.mutex.Unlock()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// login runs the SSO login of the user with the given groups and returns the redirect location and cookies
+func (m *mockSSOIssuer) login(t *testing.T, agent *AuthAgent, username string, groups []string) (string, []*http.Cookie) {
+	rec := httptest.NewRecorder()
+	agent.HandleAdminSSOLogin(rec, httptest.NewRequest(http.MethodGet, ADMIN_SSO_LOGIN_PATH, nil))
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), m.server.URL+"/authorize") {
+		t.Fatalf("expected redirect to the identity provider, got %s", rec.Header().Get("Location"))
+	}
+	if authURL.Query().Get("code_challenge_method") != "S256" {
+		t.Fatal("expected PKCE challenge in authorization request")
+	}
+
+	m.mutex.Lock()
+	m.nonce = authURL.Query().Get("nonce")
+	m.claims = jwt.MapClaims{"sub": "sub-" + username, "preferred_username": username, "groups": groups}
+	m.mutex.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, ADMIN_SSO_CALLBACK_PATH+"?code=test&state="+authURL.Query().Get("state"), nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	agent.HandleAdminSSOCallback(rec, req)
+	return rec.Header().Get("Location"), rec.Result().Cookies()
+}
+
+func newTestSSOAgent(t *testing.T) (*AuthAgent, *mockSSOIssuer) {
+	agent := newTestAuthAgent(t)
+	issuer := newMockSSOIssuer(t)
+	settings := getDefaultAdminSSOSettings()
+	settings.Enabled = true
+	settings.IssuerURL = issuer.server.URL
+	settings.ClientID = testSSOClientID
+	settings.GroupMappings = []*AdminSSOGroupMapping{
+		{Group: "zoraxy-admins", Role: RoleAdmin},
+		{Group: "team-a", Role: RoleOperator, Scope: &AccessScope{Hostnames: []string{"*.team-a.example.com"}}},
+	}
+	if err := agent.SetAdminSSOSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	return agent, issuer
+}
+
+func TestAdminSSOLogin(t *testing.T) {
+	agent, issuer := newTestSSOAgent(t)
+
+	//First login provisions the account with the mapped role and scope
+	location, cookies := issuer.login(t, agent, "alice", []string{"team-a"})
+	if location != "/" || len(cookies) == 0 {
+		t.Fatalf("expected successful SSO login, got redirect %s", location)
+	}
+	user := agent.GetAdminUser("alice")
+	if !user.SSO || user.Role != RoleOperator || user.Scope == nil || user.Scope.Hostnames[0] != "*.team-a.example.com" {
+		t.Fatalf("unexpected provisioned account: %+v", user)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/checkLogin", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if !agent.CheckAuth(req) {
+		t.Error("expected SSO session to be valid")
+	}
+
+	//SSO accounts cannot sign in with password
+	if agent.CheckPasswordLoginAllowed("alice") == nil {
+		t.Error("expected password login of SSO account to be rejected")
+	}
+
+	//Group changes at the identity provider are applied on next login
+	issuer.login(t, agent, "alice", []string{"zoraxy-admins"})
+	if user := agent.GetAdminUser("alice"); user.Role != RoleAdmin || user.Scope != nil {
+		t.Errorf("expected role to follow group claim: %+v", user)
+	}
+
+	//Offboarded users are rejected and their account removed
+	location, _ = issuer.login(t, agent, "alice", []string{"contractors"})
+	if !strings.Contains(location, "sso_error=") || agent.UserExists("alice") {
+		t.Errorf("expected offboarded account to be removed, got redirect %s", location)
+	}
+
+	//Local accounts cannot be taken over by SSO logins
+	location, _ = issuer.login(t, agent, "viewer", []string{"zoraxy-admins"})
+	if !strings.Contains(location, "sso_error=") || agent.GetUserRole("viewer") != RoleViewer || agent.IsSSOUser("viewer") {
+		t.Errorf("expected local account to be kept, got redirect %s", location)
+	}
+
+	//Disabling SSO invalidates existing SSO sessions
+	issuer.login(t, agent, "bob", []string{"zoraxy-admins"})
+	settings := agent.GetAdminSSOSettings()
+	settings.Enabled = false
+	agent.SetAdminSSOSettings(settings)
+	if agent.CheckAuth(req) {
+		t.Error("expected SSO session to be invalid after SSO is disabled")
+	}
+}
+
+func TestAdminSSOBreakGlass(t *testing.T) {
+	agent, _ := newTestSSOAgent(t)
+	settings := agent.GetAdminSSOSettings()
+	settings.DisablePasswordLogin = true
+
+	//Scoped or missing accounts cannot be break-glass admins
+	settings.BreakGlassAccounts = []string{"team", "nobody"}
+	if agent.SetAdminSSOSettings(settings) == nil {
+		t.Fatal("expected password login to require a local admin break-glass account")
+	}
+
+	settings.BreakGlassAccounts = []string{"root"}
+	if err := agent.SetAdminSSOSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if agent.CheckPasswordLoginAllowed("root") != nil {
+		t.Error("expected break-glass account to sign in with password")
+	}
+	if agent.CheckPasswordLoginAllowed("viewer") == nil {
+		t.Error("expected password login of other accounts to be disabled")
+	}
+}
+
+func TestTOTPLogin(t *testing.T) {
+	agent := newTestAuthAgent(t)
+	secret := "JBSWY3DPEHPK3PXP"
+	agent.Database.Write("auth", DB_TOTP_KEY_PREFIX+"root", secret)
+
+	login := func(code string) string {
+		form := url.Values{"username": {"root"}, "password": {"password"}, "totp": {code}}
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		agent.HandleLogin(rec, req)
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	if resp := login(""); !strings.Contains(resp, "totp_required") {
+		t.Errorf("expected authenticator code to be required, got %s", resp)
+	}
+	if resp := login("000000"); !strings.Contains(resp, "Invalid authenticator code") {
+		t.Errorf("expected invalid code to be rejected, got %s", resp)
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+	if resp := login(code); strings.Contains(resp, "error") || strings.Contains(resp, "totp_required") {
+		t.Errorf("expected login with valid code, got %s", resp)
+	}
+
+	if resp := login(code); !strings.Contains(resp, "Invalid authenticator code") {
+		t.Errorf("expected a replayed code to be rejected, got %s", resp)
+	}
+
+	if err := agent.DisableTOTP("root"); err != nil || agent.IsTOTPEnabled("root") {
+		t.Error("expected TOTP to be disabled")
+	}
+}
+
+func TestTOTPBackoff(t *testing.T) {
+	agent := newTestAuthAgent(t)
+	secret := "JBSWY3DPEHPK3PXP"
+	agent.Database.Write("auth", DB_TOTP_KEY_PREFIX+"root", secret)
+
+	for i := 0; i < TOTP_FREE_ATTEMPTS; i++ {
+		if err := agent.ValidateTOTP("root", "000000"); err != ErrTOTPInvalid {
+			t.Fatalf("attempt %d: expected invalid code, got %v", i, err)
+		}
+	}
+
+	//Even a valid code is refused while the account is backed off
+	code, _ := totp.GenerateCode(secret, time.Now())
+	if err := agent.ValidateTOTP("root", code); err != ErrTOTPBackoff {
+		t.Fatalf("expected backoff after %d failures, got %v", TOTP_FREE_ATTEMPTS, err)
+	}
+
+	stateObj, _ := agent.totpAttempts.Load("root")
+	stateObj.(*totpAttemptState).BlockedUntil = time.Time{}
+	if err := agent.ValidateTOTP("root", code); err != nil {
+		t.Fatalf("expected valid code after the backoff, got %v", err)
+	}
+	if err := agent.ValidateTOTP("root", code); err != ErrTOTPReused {
+		t.Fatalf("expected replayed code to be rejected, got %v", err)
+	}
+
+	if totpBackoff(0) != TOTP_BACKOFF_BASE || totpBackoff(100) != TOTP_BACKOFF_MAX {
+		t.Error("unexpected backoff durations")
+	}
+}
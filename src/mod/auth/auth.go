@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/mail"
 	"strings"
+	"sync"
 
 	"encoding/hex"
 
@@ -28,6 +29,13 @@ type AuthAgent struct {
 	Database                *db.Database
 	LoginRedirectionHandler func(http.ResponseWriter, *http.Request)
 	Logger                  *logger.Logger
+
+	//Single sign-on of the management interface
+	ZorxAuthIssuer func() string //Return the issuer URL of the ZorxAuth OIDC provider, empty if not available
+	sso            adminSSORuntime
+
+	pendingTOTPSetup sync.Map //username -> *pendingTOTPSetup
+	totpAttempts     sync.Map //username -> *totpAttemptState
 }
 
 type AuthEndpoints struct {
@@ -121,7 +129,31 @@ func (a *AuthAgent) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	passwordCorrect, rejectionReason := a.ValidateUsernameAndPasswordWithReason(username, password)
 	//The database contain this user information. Check its password if it is correct
 	if passwordCorrect {
-		//Password correct
+		//Password correct, check if the account can sign in with password
+		if err := a.CheckPasswordLoginAllowed(username); err != nil {
+			a.Logger.PrintAndLog("auth", username+" login request rejected: "+err.Error(), nil)
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+
+		//Ask for the authenticator code if TOTP is enabled
+		if a.IsTOTPEnabled(username) {
+			code := strings.TrimSpace(r.PostForm.Get("totp"))
+			if code == "" {
+				utils.SendJSONResponse(w, `{"totp_required":true}`)
+				return
+			}
+			if err := a.ValidateTOTP(username, code); err != nil {
+				a.Logger.PrintAndLog("auth", username+" login request rejected: "+err.Error(), nil)
+				if errors.Is(err, ErrTOTPBackoff) {
+					utils.SendErrorResponse(w, "Too many invalid authenticator codes, try again later")
+					return
+				}
+				utils.SendErrorResponse(w, "Invalid authenticator code")
+				return
+			}
+		}
+
 		// Set user as authenticated
 		a.LoginUserByRequest(w, r, username, rememberme)
 
@@ -162,11 +194,17 @@ func (a *AuthAgent) ValidateUsernameAndPasswordWithReason(username string, passw
 
 // Login the user by creating a valid session for this user
 func (a *AuthAgent) LoginUserByRequest(w http.ResponseWriter, r *http.Request, username string, rememberme bool) {
+	a.saveLoginSession(w, r, username, rememberme, 0)
+}
+
+// saveLoginSession creates the session of the user, ssoLogin is the unix time of the SSO login or 0 for password logins
+func (a *AuthAgent) saveLoginSession(w http.ResponseWriter, r *http.Request, username string, rememberme bool, ssoLogin int64) {
 	session, _ := a.SessionStore.Get(r, a.SessionName)
 
 	session.Values["authenticated"] = true
 	session.Values["username"] = username
 	session.Values["rememberMe"] = rememberme
+	session.Values["ssoLogin"] = ssoLogin
 
 	//Check if remember me is clicked. If yes, set the maxage to 1 week.
 	if rememberme {
@@ -344,6 +382,11 @@ func (a *AuthAgent) CheckAuth(r *http.Request) bool {
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
 		return false
 	}
+
+	// SSO users need to sign in again through the identity provider after the session lifetime
+	if ssoLogin, ok := session.Values["ssoLogin"].(int64); ok && ssoLogin > 0 && a.checkSSOSessionExpired(ssoLogin) {
+		return false
+	}
 	return true
 }
 
@@ -385,9 +428,22 @@ func (a *AuthAgent) UnregisterUser(username string) error {
 	//OK! Remove the user from the database
 	a.Database.Delete("auth", "passhash/"+username)
 	a.Database.Delete("auth", "email/"+username)
+	a.Database.Delete("auth", DB_ROLE_KEY_PREFIX+username)
+	a.Database.Delete("auth", DB_SCOPE_KEY_PREFIX+username)
+	a.Database.Delete("auth", DB_SSO_USER_KEY_PREFIX+username)
+	a.Database.Delete("auth", DB_TOTP_KEY_PREFIX+username)
+	a.totpAttempts.Delete(username)
 	return nil
 }
 
+// Change the password of an existing user, role and scope are kept
+func (a *AuthAgent) ChangePassword(username string, newPassword string) error {
+	if !a.UserExists(username) {
+		return errors.New("this user does not exists")
+	}
+	return a.Database.Write("auth", "passhash/"+username, Hash(newPassword))
+}
+
 // Get the number of users in the system
 func (a *AuthAgent) GetUserCounts() int {
 	entries, _ := a.Database.ListTable("auth")
@@ -0,0 +1,364 @@
+package auth
+
+/*
+	permission.go
+
+	This file handle the role based access control of the management API.
+
+	Each admin account has a role that grants a set of permissions, and an
+	optional scope that restricts management actions to specific proxy
+	endpoints by hostname or tag. Accounts created before roles were
+	introduced have no role record and are treated as full admins.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+type Permission string
+
+const (
+	DB_ROLE_KEY_PREFIX  = "role/"
+	DB_SCOPE_KEY_PREFIX = "scope/"
+)
+
+const (
+	PermissionView         Permission = "view"          //Read only access to status, rules, certificates and statistics
+	PermissionProxyManage  Permission = "proxy.manage"  //Create, edit and remove proxy endpoints, redirections and stream proxies
+	PermissionAccessManage Permission = "access.manage" //Manage access rules, blacklist, whitelist and WAF
+	PermissionCertManage   Permission = "cert.manage"   //Manage certificates and ACME
+	PermissionSystemManage Permission = "system.manage" //Manage global settings, SSO, plugins, users and system tools
+)
+
+type Role string
+
+const (
+	RoleViewer      Role = "viewer"
+	RoleOperator    Role = "operator"
+	RoleCertManager Role = "certmanager"
+	RoleAdmin       Role = "admin"
+)
+
+// rolePermissions defines the permissions granted by each role
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:      {PermissionView},
+	RoleOperator:    {PermissionView, PermissionProxyManage, PermissionAccessManage},
+	RoleCertManager: {PermissionView, PermissionCertManage},
+	RoleAdmin:       {PermissionView, PermissionProxyManage, PermissionAccessManage, PermissionCertManage, PermissionSystemManage},
+}
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// IsValid checks if the role is one of the defined roles
+func (role Role) IsValid() bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission checks if the role grants the given permission
+func (role Role) HasPermission(permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions granted by the role
+func (role Role) Permissions() []Permission {
+	return append([]Permission{}, rolePermissions[role]...)
+}
+
+// AccessScope restricts the management actions of a user to specific proxy endpoints
+type AccessScope struct {
+	Hostnames []string `json:"hostnames"` //Hostnames the user can manage, supports *.example.com wildcards
+	Tags      []string `json:"tags"`      //Endpoint tags the user can manage
+}
+
+// IsRestricted returns true if the scope limits the user to specific endpoints
+func (s *AccessScope) IsRestricted() bool {
+	return s != nil && (len(s.Hostnames) > 0 || len(s.Tags) > 0)
+}
+
+// AllowsHostname checks if the hostname is covered by the scope hostnames
+func (s *AccessScope) AllowsHostname(hostname string) bool {
+	if !s.IsRestricted() {
+		return true
+	}
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if hostname == "" || strings.ContainsAny(hostname, ", \t/") {
+		//Hostname lists must be split and checked one by one
+		return false
+	}
+	for _, allowed := range s.Hostnames {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == hostname {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(hostname, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEndpoint checks if an existing proxy endpoint is covered by the scope
+func (s *AccessScope) AllowsEndpoint(hostname string, tags []string) bool {
+	if s.AllowsHostname(hostname) {
+		return true
+	}
+	for _, tag := range tags {
+		for _, allowed := range s.Tags {
+			if strings.EqualFold(strings.TrimSpace(tag), strings.TrimSpace(allowed)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AdminUser is the role and scope of a management account
+type AdminUser struct {
+	Username    string       `json:"username"`
+	Role        Role         `json:"role"`
+	Permissions []Permission `json:"permissions"`
+	Scope       *AccessScope `json:"scope"`
+	SSO         bool         `json:"sso"`  //Account provisioned by SSO login
+	TOTP        bool         `json:"totp"` //Two factor authentication enabled
+}
+
+/* ===================== Role & Scope Storage ===================== */
+
+// GetUserRole returns the role of the user, accounts without a role are admins
+func (a *AuthAgent) GetUserRole(username string) Role {
+	role := ""
+	if err := a.Database.Read("auth", DB_ROLE_KEY_PREFIX+username, &role); err != nil || !Role(role).IsValid() {
+		return RoleAdmin
+	}
+	return Role(role)
+}
+
+// GetUserScope returns the endpoint scope of the user, nil if unrestricted
+func (a *AuthAgent) GetUserScope(username string) *AccessScope {
+	if !a.Database.KeyExists("auth", DB_SCOPE_KEY_PREFIX+username) {
+		return nil
+	}
+	scope := AccessScope{}
+	if err := a.Database.Read("auth", DB_SCOPE_KEY_PREFIX+username, &scope); err != nil {
+		//Fail closed, a broken scope record must not grant access to every endpoint
+		return &AccessScope{Hostnames: []string{""}}
+	}
+	if !scope.IsRestricted() {
+		return nil
+	}
+	return &scope
+}
+
+// SetUserRoleAndScope updates the role and scope of the user
+func (a *AuthAgent) SetUserRoleAndScope(username string, role Role, scope *AccessScope) error {
+	if !role.IsValid() {
+		return errors.New("invalid role")
+	}
+	if err := a.Database.Write("auth", DB_ROLE_KEY_PREFIX+username, string(role)); err != nil {
+		return err
+	}
+	if !scope.IsRestricted() {
+		a.Database.Delete("auth", DB_SCOPE_KEY_PREFIX+username)
+		return nil
+	}
+	return a.Database.Write("auth", DB_SCOPE_KEY_PREFIX+username, scope)
+}
+
+// GetAdminUser returns the role, permissions and scope of the user
+func (a *AuthAgent) GetAdminUser(username string) *AdminUser {
+	role := a.GetUserRole(username)
+	return &AdminUser{
+		Username:    username,
+		Role:        role,
+		Permissions: role.Permissions(),
+		Scope:       a.GetUserScope(username),
+		SSO:         a.IsSSOUser(username),
+		TOTP:        a.IsTOTPEnabled(username),
+	}
+}
+
+// GetRequestUser returns the role and scope of the logged in user of the request
+func (a *AuthAgent) GetRequestUser(r *http.Request) (*AdminUser, error) {
+	if !a.CheckAuth(r) {
+		return nil, errors.New("user not logged in")
+	}
+	session, _ := a.SessionStore.Get(r, a.SessionName)
+	username, ok := session.Values["username"].(string)
+	if !ok || !a.UserExists(username) {
+		//Sessions of removed users are no longer valid
+		return nil, errors.New("user not logged in")
+	}
+	return a.GetAdminUser(username), nil
+}
+
+// CheckPermission checks if the logged in user of the request has the given permission
+func (a *AuthAgent) CheckPermission(r *http.Request, permission Permission) bool {
+	user, err := a.GetRequestUser(r)
+	if err != nil {
+		return false
+	}
+	return user.Role.HasPermission(permission)
+}
+
+// countAdmins returns the number of users with the admin role
+func (a *AuthAgent) countAdmins() int {
+	count := 0
+	for _, username := range a.ListUsers() {
+		if a.GetUserRole(username) == RoleAdmin && a.GetUserScope(username) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// parseAccessScope reads the scope of a user from the POST form
+func parseAccessScope(r *http.Request) *AccessScope {
+	splitList := func(value string) []string {
+		results := []string{}
+		for _, item := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == '\n' }) {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				results = append(results, item)
+			}
+		}
+		return results
+	}
+	return &AccessScope{
+		Hostnames: splitList(r.PostForm.Get("hostnames")),
+		Tags:      splitList(r.PostForm.Get("tags")),
+	}
+}
+
+// HandleListUsers returns all management accounts with their role and scope
+func (a *AuthAgent) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	usernames := a.ListUsers()
+	sort.Strings(usernames)
+	results := []*AdminUser{}
+	for _, username := range usernames {
+		results = append(results, a.GetAdminUser(username))
+	}
+	js, _ := json.Marshal(results)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleAddUser creates a new management account, require POST username, password, role
+// and optional hostnames and tags scope
+func (a *AuthAgent) HandleAddUser(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		utils.SendErrorResponse(w, "Missing 'username' paramter")
+		return
+	}
+	username = strings.TrimSpace(username)
+	if strings.Contains(username, "/") {
+		utils.SendErrorResponse(w, "Invalid username")
+		return
+	}
+	password, err := utils.PostPara(r, "password")
+	if err != nil {
+		utils.SendErrorResponse(w, "Missing 'password' paramter")
+		return
+	}
+	role, _ := utils.PostPara(r, "role")
+	if !Role(role).IsValid() {
+		utils.SendErrorResponse(w, "Invalid role")
+		return
+	}
+
+	err = a.CreateUserAccount(username, password, "")
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	err = a.SetUserRoleAndScope(username, Role(role), parseAccessScope(r))
+	if err != nil {
+		a.UnregisterUser(username)
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	a.Logger.PrintAndLog("auth", "Management account "+username+" created with role "+role, nil)
+	utils.SendOK(w)
+}
+
+// HandleUpdateUser updates the role and scope of a management account, require POST username, role
+func (a *AuthAgent) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil || !a.UserExists(username) {
+		utils.SendErrorResponse(w, "User not found")
+		return
+	}
+	role, _ := utils.PostPara(r, "role")
+	if !Role(role).IsValid() {
+		utils.SendErrorResponse(w, "Invalid role")
+		return
+	}
+	scope := parseAccessScope(r)
+
+	//Make sure there is always one unrestricted admin left
+	current := a.GetAdminUser(username)
+	if current.Role == RoleAdmin && current.Scope == nil && (Role(role) != RoleAdmin || scope.IsRestricted()) && a.countAdmins() <= 1 {
+		utils.SendErrorResponse(w, "Cannot remove the role of the last admin")
+		return
+	}
+
+	err = a.SetUserRoleAndScope(username, Role(role), scope)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.Logger.PrintAndLog("auth", "Management account "+username+" updated to role "+role, nil)
+	utils.SendOK(w)
+}
+
+// HandleDeleteUser removes a management account, require POST username
+func (a *AuthAgent) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil || !a.UserExists(username) {
+		utils.SendErrorResponse(w, "User not found")
+		return
+	}
+	currentUser, _ := a.GetUserName(w, r)
+	if currentUser == username {
+		utils.SendErrorResponse(w, "You cannot remove your own account")
+		return
+	}
+	target := a.GetAdminUser(username)
+	if target.Role == RoleAdmin && target.Scope == nil && a.countAdmins() <= 1 {
+		utils.SendErrorResponse(w, "Cannot remove the last admin")
+		return
+	}
+
+	err = a.UnregisterUser(username)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.Logger.PrintAndLog("auth", "Management account "+username+" removed", nil)
+	utils.SendOK(w)
+}
+
+// HandleGetCurrentUserPermissions returns the role, permissions and scope of the logged in user
+func (a *AuthAgent) HandleGetCurrentUserPermissions(w http.ResponseWriter, r *http.Request) {
+	user, err := a.GetRequestUser(r)
+	if err != nil {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	js, _ := json.Marshal(user)
+	utils.SendJSONResponse(w, string(js))
+}
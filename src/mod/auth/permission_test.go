@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/auditlog"
+	db "imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+// newTestAuthAgent creates an auth agent with the admin root, a viewer and an
+// operator scoped to *.team.example.com and endpoints tagged "team"
+func newTestAuthAgent(t *testing.T) *AuthAgent {
+	sysdb, err := db.NewDatabase(filepath.Join(t.TempDir(), "sys.db"), dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sysdb.Close() })
+	systemLogger, _ := logger.NewFmtLogger()
+	agent := NewAuthenticationAgent("zoraxy", []byte("test-session-key-0123456789abcdef"), sysdb, true, systemLogger, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+	})
+
+	agent.CreateUserAccount("root", "password", "")
+	agent.CreateUserAccount("viewer", "password", "")
+	agent.SetUserRoleAndScope("viewer", RoleViewer, nil)
+	agent.CreateUserAccount("team", "password", "")
+	agent.SetUserRoleAndScope("team", RoleOperator, &AccessScope{Hostnames: []string{"*.team.example.com"}, Tags: []string{"team"}})
+	return agent
+}
+
+// loginCookie returns the session cookie of the user
+func loginCookie(t *testing.T, agent *AuthAgent, username string) *http.Cookie {
+	rec := httptest.NewRecorder()
+	agent.LoginUserByRequest(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), username, false)
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("expected session cookie")
+	}
+	return cookies[0]
+}
+
+func TestRolePermissions(t *testing.T) {
+	if !RoleViewer.HasPermission(PermissionView) || RoleViewer.HasPermission(PermissionProxyManage) {
+		t.Error("viewer must only have view permission")
+	}
+	if !RoleOperator.HasPermission(PermissionProxyManage) || RoleOperator.HasPermission(PermissionCertManage) {
+		t.Error("operator must manage proxies but not certificates")
+	}
+	if !RoleCertManager.HasPermission(PermissionCertManage) || RoleCertManager.HasPermission(PermissionSystemManage) {
+		t.Error("certificate manager must manage certificates but not the system")
+	}
+	if !RoleAdmin.HasPermission(PermissionSystemManage) || Role("root").IsValid() {
+		t.Error("admin must have every permission and unknown roles must be invalid")
+	}
+
+	//Accounts created before roles were introduced are admins
+	agent := newTestAuthAgent(t)
+	if agent.GetUserRole("root") != RoleAdmin || agent.GetUserScope("root") != nil {
+		t.Error("expected legacy account to be an unrestricted admin")
+	}
+}
+
+func TestAccessScope(t *testing.T) {
+	scope := &AccessScope{Hostnames: []string{"app.example.com", "*.team.example.com"}, Tags: []string{"Team"}}
+	testcases := []struct {
+		hostname string
+		tags     []string
+		expected bool
+	}{
+		{"app.example.com", nil, true},
+		{"APP.example.com", nil, true},
+		{"api.team.example.com", nil, true},
+		{"team.example.com", nil, false},
+		{"evilteam.example.com", nil, false},
+		{"victim.com,x.team.example.com", nil, false},
+		{"other.example.com", []string{"team"}, true},
+		{"other.example.com", []string{"billing"}, false},
+	}
+	for _, tc := range testcases {
+		if scope.AllowsEndpoint(tc.hostname, tc.tags) != tc.expected {
+			t.Errorf("AllowsEndpoint(%s, %v) expected %v", tc.hostname, tc.tags, tc.expected)
+		}
+	}
+
+	var unrestricted *AccessScope
+	if unrestricted.IsRestricted() || !unrestricted.AllowsHostname("any.example.com") {
+		t.Error("nil scope must allow every endpoint")
+	}
+}
+
+func TestRouterPermissionEnforcement(t *testing.T) {
+	agent := newTestAuthAgent(t)
+	mux := http.NewServeMux()
+	router := NewManagedHTTPRouter(RouterOption{
+		AuthAgent:   agent,
+		RequireAuth: true,
+		TargetMux:   mux,
+		EndpointResolver: func(name string) (string, []string, bool) {
+			switch name {
+			case "legacy.example.com":
+				return name, []string{"team"}, true
+			case "billing.example.com", "api.team.example.com":
+				return name, nil, true
+			}
+			return "", nil, false
+		},
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }
+	router.HandleFunc("/api/proxy/list", ok, PermissionView)
+	router.HandleFunc("/api/proxy/timeouts", ok, PermissionSystemManage)
+	router.HandleFunc("/api/cert/upload", ok, PermissionCertManage)
+	router.HandleScopedFunc("/api/proxy/add", ok, PermissionProxyManage, "rootname")
+	router.HandleScopedFunc("/api/proxy/setHostname", ok, PermissionProxyManage, "oldHostname", "newHostname")
+
+	call := func(username string, path string, form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if username != "" {
+			req.AddCookie(loginCookie(t, agent, username))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	testcases := []struct {
+		name     string
+		username string
+		path     string
+		form     url.Values
+		expected int
+	}{
+		{"AnonymousRejected", "", "/api/proxy/list", nil, http.StatusUnauthorized},
+		{"ViewerCanView", "viewer", "/api/proxy/list", nil, http.StatusOK},
+		{"ViewerCannotManage", "viewer", "/api/proxy/add", url.Values{"rootname": {"api.team.example.com"}}, http.StatusForbidden},
+		{"AdminCanManage", "root", "/api/proxy/timeouts", nil, http.StatusOK},
+		{"OperatorCannotManageCerts", "team", "/api/cert/upload", nil, http.StatusForbidden},
+		{"ScopedUserCanView", "team", "/api/proxy/list", nil, http.StatusOK},
+		{"ScopedUserCannotChangeGlobalSettings", "team", "/api/proxy/timeouts", nil, http.StatusForbidden},
+		{"ScopedUserCanAddOwnHostname", "team", "/api/proxy/add", url.Values{"rootname": {"new.team.example.com"}}, http.StatusOK},
+		{"ScopedUserCannotAddOtherHostname", "team", "/api/proxy/add", url.Values{"rootname": {"new.example.com"}}, http.StatusForbidden},
+		{"ScopedUserMustNameEndpoint", "team", "/api/proxy/add", nil, http.StatusForbidden},
+		{"ScopedUserCanManageTaggedEndpoint", "team", "/api/proxy/setHostname", url.Values{"oldHostname": {"legacy.example.com"}, "newHostname": {"legacy.team.example.com"}}, http.StatusOK},
+		{"ScopedUserCannotTakeOverHostname", "team", "/api/proxy/setHostname", url.Values{"oldHostname": {"legacy.example.com"}, "newHostname": {"www.example.com"}}, http.StatusForbidden},
+		{"ScopedUserCannotManageOtherEndpoint", "team", "/api/proxy/setHostname", url.Values{"oldHostname": {"billing.example.com"}, "newHostname": {"billing.team.example.com"}}, http.StatusForbidden},
+	}
+	for _, tc := range testcases {
+		if code := call(tc.username, tc.path, tc.form); code != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, code)
+		}
+	}
+
+	//Sessions of removed users must stop working
+	cookie := loginCookie(t, agent, "viewer")
+	agent.UnregisterUser("viewer")
+	req := httptest.NewRequest(http.MethodGet, "/api/proxy/list", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected removed user to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestRouterAuditLog(t *testing.T) {
+	agent := newTestAuthAgent(t)
+	auditLogger, err := auditlog.NewAuditLogger(&auditlog.Options{LogFolder: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLogger.Close()
+
+	mux := http.NewServeMux()
+	router := NewManagedHTTPRouter(RouterOption{
+		AuthAgent:   agent,
+		RequireAuth: true,
+		TargetMux:   mux,
+		AuditLogger: auditLogger,
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }
+	router.HandleFunc("/api/proxy/list", ok, PermissionView)
+	router.HandleScopedFunc("/api/proxy/edit", ok, PermissionProxyManage, "ep")
+
+	call := func(method string, path string, form url.Values) {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(loginCookie(t, agent, "root"))
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	call(http.MethodPost, "/api/proxy/list", nil)
+	call(http.MethodGet, "/api/proxy/edit?ep=a.example.com", nil)
+	call(http.MethodPost, "/api/proxy/edit", url.Values{"ep": {"a.example.com"}})
+
+	//Only the mutating call is recorded
+	entries, total, err := auditLogger.Query(nil)
+	if err != nil || total != 1 {
+		t.Fatalf("expected 1 audit entry, got %d (%v)", total, err)
+	}
+	if entries[0].User != "root" || entries[0].Route != "/api/proxy/edit" || len(entries[0].Targets) != 1 || entries[0].Targets[0] != "a.example.com" {
+		t.Errorf("unexpected audit entry: %+v", entries[0])
+	}
+}
+
+func TestAdminAccountManagement(t *testing.T) {
+	agent := newTestAuthAgent(t)
+	post := func(handler http.HandlerFunc, username string, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(loginCookie(t, agent, username))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Body.String()
+	}
+
+	if resp := post(agent.HandleAddUser, "root", url.Values{"username": {"certbot"}, "password": {"pw"}, "role": {"certmanager"}, "hostnames": {"a.example.com, b.example.com"}}); resp != `"OK"` {
+		t.Fatalf("expected user to be created, got %s", resp)
+	}
+	user := agent.GetAdminUser("certbot")
+	if user.Role != RoleCertManager || user.Scope == nil || len(user.Scope.Hostnames) != 2 {
+		t.Errorf("unexpected created user: %+v", user)
+	}
+	if resp := post(agent.HandleAddUser, "root", url.Values{"username": {"bad"}, "password": {"pw"}, "role": {"superuser"}}); !strings.Contains(resp, "error") || agent.UserExists("bad") {
+		t.Error("expected invalid role to be rejected")
+	}
+
+	//Changing the password must not reset the role
+	agent.ChangePassword("certbot", "new-password")
+	if agent.GetUserRole("certbot") != RoleCertManager || !agent.ValidateUsernameAndPassword("certbot", "new-password") {
+		t.Error("expected password change to keep the role")
+	}
+
+	//The last unrestricted admin cannot be demoted or removed
+	if resp := post(agent.HandleUpdateUser, "root", url.Values{"username": {"root"}, "role": {"viewer"}}); !strings.Contains(resp, "error") {
+		t.Error("expected demoting the last admin to be rejected")
+	}
+	if resp := post(agent.HandleUpdateUser, "root", url.Values{"username": {"team"}, "role": {"admin"}}); resp != `"OK"` {
+		t.Fatalf("expected role update, got %s", resp)
+	}
+	if agent.GetUserScope("team") != nil {
+		t.Error("expected scope to be cleared when no hostnames or tags are given")
+	}
+	if resp := post(agent.HandleDeleteUser, "root", url.Values{"username": {"root"}}); !strings.Contains(resp, "error") {
+		t.Error("expected removing your own account to be rejected")
+	}
+	if resp := post(agent.HandleDeleteUser, "team", url.Values{"username": {"root"}}); resp != `"OK"` {
+		t.Errorf("expected admin to be removed while another admin exists, got %s", resp)
+	}
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	db "imuslab.com/zoraxy/mod/database"
+)
+
+/*
+	proxy_apikey_manager.go
+
+	API keys for proxy endpoints using the API key auth method.
+	Only the SHA-256 hash of a key is stored, the plain key is
+	shown once on creation or rotation. A rotated key keeps its
+	previous secret valid until the grace period ends
+*/
+
+const (
+	ProxyAPIKeyPrefix          = "zrx_"
+	proxyAPIKeyTable           = "apikey"
+	proxyAPIKeyLastUsedPersist = time.Minute //Minimum interval between persisting the last used time
+)
+
+var (
+	ErrInvalidAPIKey       = errors.New("invalid API key")
+	ErrAPIKeyExpired       = errors.New("API key expired")
+	ErrAPIKeyNotPermitted  = errors.New("API key not permitted for this endpoint or address")
+	ErrAPIKeyRateLimited   = errors.New("API key rate limit exceeded")
+	ErrAPIKeyNotFound      = errors.New("API key not found")
+	ErrAPIKeyInvalidConfig = errors.New("invalid API key settings")
+)
+
+// ProxyAPIKey represents an API key for proxy endpoints
+type ProxyAPIKey struct {
+	ID                string
+	Name              string
+	Owner             string
+	KeyHash           string //SHA-256 of the current key
+	KeyPrefix         string //First characters of the key for identification in the UI
+	PreviousKeyHash   string //SHA-256 of the key before the last rotation
+	PreviousKeyExpiry time.Time
+	AllowedEndpoints  []string //Root or matching domain of the endpoints, empty to allow all endpoints using API key auth
+	AllowedCIDRs      []string //IP or CIDR of allowed clients, empty to allow any address
+	RateLimit         int64    //Requests per second, 0 for unlimited
+	Disabled          bool
+	CreatedAt         time.Time
+	ExpiresAt         time.Time //Zero for never expire
+	RotatedAt         time.Time
+	LastUsed          time.Time
+}
+
+type proxyAPIKeyUsage struct {
+	window int64 //Unix second of the counting window
+	count  int64
+}
+
+// ProxyAPIKeyManager manages API keys for proxy endpoints
+type ProxyAPIKeyManager struct {
+	database *db.Database
+	keys     map[string]*ProxyAPIKey //key: ID
+	hashes   map[string]string       //key: key hash, value: ID
+	usage    map[string]*proxyAPIKeyUsage
+	persist  map[string]time.Time //Last time the last used time is written to database
+	mutex    sync.RWMutex
+}
+
+// NewProxyAPIKeyManager creates a new proxy API key manager and load keys from database
+func NewProxyAPIKeyManager(sysdb *db.Database) (*ProxyAPIKeyManager, error) {
+	err := sysdb.NewTable(proxyAPIKeyTable)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &ProxyAPIKeyManager{
+		database: sysdb,
+		keys:     map[string]*ProxyAPIKey{},
+		hashes:   map[string]string{},
+		usage:    map[string]*proxyAPIKeyUsage{},
+		persist:  map[string]time.Time{},
+	}
+
+	entries, err := sysdb.ListTable(proxyAPIKeyTable)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		key := ProxyAPIKey{}
+		if err := json.Unmarshal(entry[1], &key); err != nil || key.ID == "" {
+			continue
+		}
+		m.keys[key.ID] = &key
+		m.indexKey(&key)
+	}
+	return m, nil
+}
+
+func hashProxyAPIKey(plainKey string) string {
+	hash := sha256.Sum256([]byte(plainKey))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateProxyAPIKey() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return ProxyAPIKeyPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// indexKey add the current and previous hash of the key to the lookup index
+func (m *ProxyAPIKeyManager) indexKey(key *ProxyAPIKey) {
+	m.hashes[key.KeyHash] = key.ID
+	if key.PreviousKeyHash != "" {
+		m.hashes[key.PreviousKeyHash] = key.ID
+	}
+}
+
+func (m *ProxyAPIKeyManager) unindexKey(key *ProxyAPIKey) {
+	delete(m.hashes, key.KeyHash)
+	if key.PreviousKeyHash != "" {
+		delete(m.hashes, key.PreviousKeyHash)
+	}
+}
+
+func (m *ProxyAPIKeyManager) save(key *ProxyAPIKey) error {
+	return m.database.Write(proxyAPIKeyTable, key.ID, key)
+}
+
+// validateProxyAPIKey normalize and check the settings of a key
+func validateProxyAPIKey(key *ProxyAPIKey) error {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return errors.New("API key name cannot be empty")
+	}
+	if key.RateLimit < 0 {
+		return errors.New("rate limit cannot be negative")
+	}
+	if key.AllowedEndpoints == nil {
+		key.AllowedEndpoints = []string{}
+	}
+	if key.AllowedCIDRs == nil {
+		key.AllowedCIDRs = []string{}
+	}
+	for _, cidr := range key.AllowedCIDRs {
+		if net.ParseIP(cidr) == nil {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return errors.New("invalid IP or CIDR " + cidr)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateKey create a new API key with the given settings and return the plain key
+func (m *ProxyAPIKeyManager) CreateKey(settings *ProxyAPIKey) (string, *ProxyAPIKey, error) {
+	if err := validateProxyAPIKey(settings); err != nil {
+		return "", nil, err
+	}
+	plainKey, err := generateProxyAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &ProxyAPIKey{
+		ID:               uuid.New().String(),
+		Name:             settings.Name,
+		Owner:            settings.Owner,
+		KeyHash:          hashProxyAPIKey(plainKey),
+		KeyPrefix:        plainKey[:len(ProxyAPIKeyPrefix)+6],
+		AllowedEndpoints: settings.AllowedEndpoints,
+		AllowedCIDRs:     settings.AllowedCIDRs,
+		RateLimit:        settings.RateLimit,
+		CreatedAt:        time.Now(),
+		ExpiresAt:        settings.ExpiresAt,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.save(key); err != nil {
+		return "", nil, err
+	}
+	m.keys[key.ID] = key
+	m.indexKey(key)
+	return plainKey, key, nil
+}
+
+// UpdateKey update the settings of a key, the secret is not changed
+func (m *ProxyAPIKeyManager) UpdateKey(id string, settings *ProxyAPIKey) error {
+	if err := validateProxyAPIKey(settings); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	updated := *key
+	updated.Name = settings.Name
+	updated.Owner = settings.Owner
+	updated.AllowedEndpoints = settings.AllowedEndpoints
+	updated.AllowedCIDRs = settings.AllowedCIDRs
+	updated.RateLimit = settings.RateLimit
+	updated.ExpiresAt = settings.ExpiresAt
+	updated.Disabled = settings.Disabled
+	if err := m.save(&updated); err != nil {
+		return err
+	}
+	*key = updated
+	return nil
+}
+
+// RotateKey replace the secret of a key and keep the old secret valid for the grace period
+func (m *ProxyAPIKeyManager) RotateKey(id string, gracePeriod time.Duration) (string, error) {
+	if gracePeriod < 0 {
+		return "", ErrAPIKeyInvalidConfig
+	}
+	plainKey, err := generateProxyAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return "", ErrAPIKeyNotFound
+	}
+
+	updated := *key
+	updated.PreviousKeyHash = ""
+	updated.PreviousKeyExpiry = time.Time{}
+	if gracePeriod > 0 {
+		updated.PreviousKeyHash = key.KeyHash
+		updated.PreviousKeyExpiry = time.Now().Add(gracePeriod)
+	}
+	updated.KeyHash = hashProxyAPIKey(plainKey)
+	updated.KeyPrefix = plainKey[:len(ProxyAPIKeyPrefix)+6]
+	updated.RotatedAt = time.Now()
+	if err := m.save(&updated); err != nil {
+		return "", err
+	}
+
+	m.unindexKey(key)
+	*key = updated
+	m.indexKey(key)
+	return plainKey, nil
+}
+
+// DeleteKey remove a key
+func (m *ProxyAPIKeyManager) DeleteKey(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	if err := m.database.Delete(proxyAPIKeyTable, id); err != nil {
+		return err
+	}
+	m.unindexKey(key)
+	delete(m.keys, id)
+	delete(m.usage, id)
+	delete(m.persist, id)
+	return nil
+}
+
+// ListKeys return a copy of all keys without the hashes
+func (m *ProxyAPIKeyManager) ListKeys() []*ProxyAPIKey {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	results := []*ProxyAPIKey{}
+	for _, key := range m.keys {
+		copied := *key
+		copied.KeyHash = ""
+		copied.PreviousKeyHash = ""
+		results = append(results, &copied)
+	}
+	return results
+}
+
+// matchCIDRs check if the client IP is in any of the allowed IP or CIDR
+func matchCIDRs(clientIP string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if allowedIP := net.ParseIP(entry); allowedIP != nil {
+			if allowedIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate validate the plain key for the endpoint and client IP, counting
+// the request against the key rate limit
+func (m *ProxyAPIKeyManager) Authenticate(plainKey string, endpoint string, clientIP string) (*ProxyAPIKey, error) {
+	if !strings.HasPrefix(plainKey, ProxyAPIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	keyHash := hashProxyAPIKey(plainKey)
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id, ok := m.hashes[keyHash]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	key := m.keys[id]
+	if key.Disabled {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.KeyHash != keyHash {
+		//Previous key during the rotation grace period
+		if key.PreviousKeyHash != keyHash || now.After(key.PreviousKeyExpiry) {
+			return nil, ErrAPIKeyExpired
+		}
+	}
+	if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if len(key.AllowedEndpoints) > 0 {
+		permitted := false
+		for _, allowed := range key.AllowedEndpoints {
+			if strings.EqualFold(allowed, endpoint) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return nil, ErrAPIKeyNotPermitted
+		}
+	}
+	if !matchCIDRs(clientIP, key.AllowedCIDRs) {
+		return nil, ErrAPIKeyNotPermitted
+	}
+
+	if key.RateLimit > 0 {
+		usage, ok := m.usage[id]
+		if !ok || usage.window != now.Unix() {
+			usage = &proxyAPIKeyUsage{window: now.Unix()}
+			m.usage[id] = usage
+		}
+		usage.count++
+		if usage.count > key.RateLimit {
+			return nil, ErrAPIKeyRateLimited
+		}
+	}
+
+	key.LastUsed = now
+	if now.Sub(m.persist[id]) > proxyAPIKeyLastUsedPersist {
+		m.persist[id] = now
+		m.save(key)
+	}
+
+	copied := *key
+	return &copied, nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	db "imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+)
+
+func newTestProxyAPIKeyManager(t *testing.T, dbfile string) (*ProxyAPIKeyManager, *db.Database) {
+	sysdb, err := db.NewDatabase(dbfile, dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewProxyAPIKeyManager(sysdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, sysdb
+}
+
+func TestProxyAPIKeyPersistedHashed(t *testing.T) {
+	dbfile := filepath.Join(t.TempDir(), "sys.db")
+	manager, sysdb := newTestProxyAPIKeyManager(t, dbfile)
+
+	plainKey, key, err := manager.CreateKey(&ProxyAPIKey{Name: "billing", Owner: "billing-team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plainKey, ProxyAPIKeyPrefix) || key.KeyHash == plainKey {
+		t.Fatal("expected plain key to be prefixed and stored hashed")
+	}
+	stored := ProxyAPIKey{}
+	sysdb.Read(proxyAPIKeyTable, key.ID, &stored)
+	if stored.KeyHash != hashProxyAPIKey(plainKey) || strings.Contains(stored.KeyHash, plainKey) {
+		t.Error("expected only the key hash to be persisted")
+	}
+	sysdb.Close()
+
+	//Reload from database
+	manager, sysdb = newTestProxyAPIKeyManager(t, dbfile)
+	defer sysdb.Close()
+	authenticated, err := manager.Authenticate(plainKey, "api.example.com", "10.0.0.1")
+	if err != nil || authenticated.Owner != "billing-team" {
+		t.Fatalf("expected reloaded key to be valid: %v", err)
+	}
+	if _, err := manager.Authenticate(ProxyAPIKeyPrefix+"invalid", "api.example.com", "10.0.0.1"); err != ErrInvalidAPIKey {
+		t.Errorf("expected invalid key, got %v", err)
+	}
+	for _, listed := range manager.ListKeys() {
+		if listed.KeyHash != "" || listed.LastUsed.IsZero() {
+			t.Error("expected listed keys to hide hashes and record last used time")
+		}
+	}
+}
+
+func TestProxyAPIKeyRestrictions(t *testing.T) {
+	manager, sysdb := newTestProxyAPIKeyManager(t, filepath.Join(t.TempDir(), "sys.db"))
+	defer sysdb.Close()
+
+	plainKey, key, err := manager.CreateKey(&ProxyAPIKey{
+		Name:             "restricted",
+		AllowedEndpoints: []string{"api.example.com"},
+		AllowedCIDRs:     []string{"10.0.0.0/8", "192.168.1.5"},
+		RateLimit:        2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		endpoint string
+		ip       string
+		expected error
+	}{
+		{"api.example.com", "10.1.2.3", nil},
+		{"api.example.com", "192.168.1.5", nil},
+		{"other.example.com", "10.1.2.3", ErrAPIKeyNotPermitted},
+		{"api.example.com", "172.16.0.1", ErrAPIKeyNotPermitted},
+	}
+	for _, tc := range testcases {
+		//Reset the rate limit window between cases
+		manager.usage = map[string]*proxyAPIKeyUsage{}
+		if _, err := manager.Authenticate(plainKey, tc.endpoint, tc.ip); err != tc.expected {
+			t.Errorf("%s from %s: expected %v, got %v", tc.endpoint, tc.ip, tc.expected, err)
+		}
+	}
+
+	manager.usage = map[string]*proxyAPIKeyUsage{}
+	var lastErr error
+	for i := 0; i < 3; i++ {
+		_, lastErr = manager.Authenticate(plainKey, "api.example.com", "10.0.0.1")
+	}
+	if lastErr != ErrAPIKeyRateLimited {
+		t.Errorf("expected third request in the same second to be rate limited, got %v", lastErr)
+	}
+
+	if err := manager.UpdateKey(key.ID, &ProxyAPIKey{Name: "restricted", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate(plainKey, "api.example.com", "10.0.0.1"); err != ErrAPIKeyExpired {
+		t.Errorf("expected expired key, got %v", err)
+	}
+
+	if err := manager.UpdateKey(key.ID, &ProxyAPIKey{Name: "restricted", AllowedCIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Error("expected invalid CIDR to be rejected")
+	}
+}
+
+func TestProxyAPIKeyRotation(t *testing.T) {
+	manager, sysdb := newTestProxyAPIKeyManager(t, filepath.Join(t.TempDir(), "sys.db"))
+	defer sysdb.Close()
+
+	oldKey, key, _ := manager.CreateKey(&ProxyAPIKey{Name: "rotating"})
+	newKey, err := manager.RotateKey(key.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate(newKey, "api.example.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected new key to be valid: %v", err)
+	}
+	if _, err := manager.Authenticate(oldKey, "api.example.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected old key to be valid during grace period: %v", err)
+	}
+
+	//Rotate again without grace period, both previous keys are revoked
+	latestKey, _ := manager.RotateKey(key.ID, 0)
+	if _, err := manager.Authenticate(oldKey, "api.example.com", "10.0.0.1"); err == nil {
+		t.Error("expected first key to be revoked")
+	}
+	if _, err := manager.Authenticate(newKey, "api.example.com", "10.0.0.1"); err == nil {
+		t.Error("expected second key to be revoked")
+	}
+	if _, err := manager.Authenticate(latestKey, "api.example.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected latest key to be valid: %v", err)
+	}
+
+	//Grace period ended
+	previousKey := latestKey
+	latestKey, _ = manager.RotateKey(key.ID, time.Hour)
+	manager.keys[key.ID].PreviousKeyExpiry = time.Now().Add(-time.Second)
+	if _, err := manager.Authenticate(previousKey, "api.example.com", "10.0.0.1"); err != ErrAPIKeyExpired {
+		t.Errorf("expected previous key to expire after grace period, got %v", err)
+	}
+
+	if err := manager.DeleteKey(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate(latestKey, "api.example.com", "10.0.0.1"); err != ErrInvalidAPIKey {
+		t.Errorf("expected deleted key to be invalid, got %v", err)
+	}
+}
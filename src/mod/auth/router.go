@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/auditlog"
 )
 
 type RouterOption struct {
@@ -11,21 +14,52 @@ type RouterOption struct {
 	RequireAuth   bool                                     //This router require authentication
 	DeniedHandler func(http.ResponseWriter, *http.Request) //Things to do when request is rejected
 	TargetMux     *http.ServeMux
+
+	//Resolve the hostname and tags of the proxy endpoint targeted by a scoped
+	//route, exists is false if the proxy endpoint is not created yet
+	EndpointResolver func(name string) (hostname string, tags []string, exists bool)
+
+	//Record mutating calls to the audit log, leave nil to disable
+	AuditLogger *auditlog.Logger
+}
+
+type RouterEndpoint struct {
+	Permission  Permission //Permission required to call this endpoint
+	ScopeParams []string   //Request parameters holding the targeted proxy endpoint, empty if the endpoint is not scoped
+	handler     func(http.ResponseWriter, *http.Request)
 }
 
 type RouterDef struct {
 	option    RouterOption
-	endpoints map[string]func(http.ResponseWriter, *http.Request)
+	endpoints map[string]*RouterEndpoint
 }
 
 func NewManagedHTTPRouter(option RouterOption) *RouterDef {
 	return &RouterDef{
 		option:    option,
-		endpoints: map[string]func(http.ResponseWriter, *http.Request){},
+		endpoints: map[string]*RouterEndpoint{},
 	}
 }
 
-func (router *RouterDef) HandleFunc(endpoint string, handler func(http.ResponseWriter, *http.Request)) error {
+// HandleFunc registers a handler that requires the given permission
+func (router *RouterDef) HandleFunc(endpoint string, handler func(http.ResponseWriter, *http.Request), permission Permission) error {
+	return router.registerEndpoint(endpoint, &RouterEndpoint{
+		Permission: permission,
+		handler:    handler,
+	})
+}
+
+// HandleScopedFunc registers a handler that requires the given permission and targets
+// the proxy endpoints named by scopeParams, so it is also available to scoped users
+func (router *RouterDef) HandleScopedFunc(endpoint string, handler func(http.ResponseWriter, *http.Request), permission Permission, scopeParams ...string) error {
+	return router.registerEndpoint(endpoint, &RouterEndpoint{
+		Permission:  permission,
+		ScopeParams: scopeParams,
+		handler:     handler,
+	})
+}
+
+func (router *RouterDef) registerEndpoint(endpoint string, routerEndpoint *RouterEndpoint) error {
 	//Check if the endpoint already registered
 	if _, exist := router.endpoints[endpoint]; exist {
 		fmt.Println("WARNING! Duplicated registering of web endpoint: " + endpoint)
@@ -33,35 +67,119 @@ func (router *RouterDef) HandleFunc(endpoint string, handler func(http.ResponseW
 	}
 
 	authAgent := router.option.AuthAgent
+	wrappedHandler := func(w http.ResponseWriter, r *http.Request) {
+		//Check authentication and permission of the user
+		if router.option.RequireAuth {
+			authAgent.HandleCheckAuth(w, r, func(w http.ResponseWriter, r *http.Request) {
+				if !router.checkPermission(r, routerEndpoint) {
+					http.Error(w, "403 - Forbidden", http.StatusForbidden)
+					return
+				}
+				router.serveEndpoint(w, r, endpoint, routerEndpoint)
+			})
+		} else {
+			router.serveEndpoint(w, r, endpoint, routerEndpoint)
+		}
+	}
 
 	//OK. Register handler
 	if router.option.TargetMux == nil {
-		http.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
-			//Check authentication of the user
-			if router.option.RequireAuth {
-				authAgent.HandleCheckAuth(w, r, func(w http.ResponseWriter, r *http.Request) {
-					handler(w, r)
-				})
-			} else {
-				handler(w, r)
-			}
-
-		})
+		http.HandleFunc(endpoint, wrappedHandler)
 	} else {
-		router.option.TargetMux.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
-			//Check authentication of the user
-			if router.option.RequireAuth {
-				authAgent.HandleCheckAuth(w, r, func(w http.ResponseWriter, r *http.Request) {
-					handler(w, r)
-				})
-			} else {
-				handler(w, r)
+		router.option.TargetMux.HandleFunc(endpoint, wrappedHandler)
+	}
+
+	router.endpoints[endpoint] = routerEndpoint
+
+	return nil
+}
+
+// serveEndpoint executes the handler, recording the call to the audit log if it changes the configuration
+func (router *RouterDef) serveEndpoint(w http.ResponseWriter, r *http.Request, endpoint string, routerEndpoint *RouterEndpoint) {
+	if router.option.AuditLogger == nil || !isMutatingCall(r, routerEndpoint) {
+		routerEndpoint.handler(w, r)
+		return
+	}
+
+	username := ""
+	if router.option.RequireAuth {
+		username, _ = router.option.AuthAgent.GetUserName(w, r)
+	}
+	router.option.AuditLogger.Audit(w, r, endpoint, username, getScopeTargets(r, routerEndpoint.ScopeParams), routerEndpoint.handler)
+}
+
+// isMutatingCall checks if the call might change the configuration. Read only
+// routes only require view permission and reads are done with GET requests
+func isMutatingCall(r *http.Request, routerEndpoint *RouterEndpoint) bool {
+	if routerEndpoint.Permission == PermissionView {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// getScopeTargets returns the proxy endpoints named in the request
+func getScopeTargets(r *http.Request, scopeParams []string) []string {
+	if len(scopeParams) == 0 {
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+	targets := []string{}
+	for _, key := range scopeParams {
+		for _, name := range r.Form[key] {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				targets = append(targets, name)
 			}
+		}
+	}
+	return targets
+}
+
+// checkPermission checks if the logged in user can call the endpoint
+func (router *RouterDef) checkPermission(r *http.Request, routerEndpoint *RouterEndpoint) bool {
+	user, err := router.option.AuthAgent.GetRequestUser(r)
+	if err != nil {
+		return false
+	}
+	if !user.Role.HasPermission(routerEndpoint.Permission) {
+		return false
+	}
+	if !user.Scope.IsRestricted() {
+		return true
+	}
 
-		})
+	//Scoped users can view global information, but can only touch their own endpoints
+	if len(routerEndpoint.ScopeParams) > 0 {
+		return router.checkScope(r, user.Scope, routerEndpoint.ScopeParams)
 	}
+	return routerEndpoint.Permission == PermissionView
+}
 
-	router.endpoints[endpoint] = handler
+// checkScope checks that every proxy endpoint named in the request is within the scope
+func (router *RouterDef) checkScope(r *http.Request, scope *AccessScope, scopeParams []string) bool {
+	if router.option.EndpointResolver == nil {
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		return false
+	}
 
-	return nil
+	targets := getScopeTargets(r, scopeParams)
+	for _, name := range targets {
+		hostname, tags, exists := router.option.EndpointResolver(name)
+		if exists && !scope.AllowsEndpoint(hostname, tags) {
+			return false
+		}
+		//New endpoints can only be created under the allowed hostnames
+		if !exists && !scope.AllowsHostname(name) {
+			return false
+		}
+	}
+	return len(targets) > 0
 }
@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
 	"golang.org/x/oauth2"
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
 	"imuslab.com/zoraxy/mod/database"
 	"imuslab.com/zoraxy/mod/info/logger"
 	"imuslab.com/zoraxy/mod/utils"
@@ -22,6 +24,9 @@ const (
 	DefaultOAuth2ConfigCacheTime = 60 * time.Second
 )
 
+// ErrForbidden is returned when the user is authenticated but rejected by the endpoint claim rules
+var ErrForbidden = errors.New("forbidden")
+
 type OAuth2RouterOptions struct {
 	OAuth2ServerURL              string //The URL of the OAuth 2.0 server server
 	OAuth2TokenURL               string //The URL of the OAuth 2.0 token server
@@ -55,6 +60,9 @@ type OIDCDiscoveryDocument struct {
 
 type OAuth2Router struct {
 	options *OAuth2RouterOptions
+
+	verifierMutex sync.RWMutex
+	verifier      *oidc.Verifier //ID token verifier, nil if the provider has no discovery document
 }
 
 // NewOAuth2Router creates a new OAuth2Router object
@@ -66,7 +74,7 @@ func NewOAuth2Router(options *OAuth2RouterOptions) *OAuth2Router {
 	options.Database.Read("oauth2", "oauth2ServerUrl", &options.OAuth2ServerURL)
 	options.Database.Read("oauth2", "oauth2TokenUrl", &options.OAuth2TokenURL)
 	options.Database.Read("oauth2", "oauth2ClientId", &options.OAuth2ClientId)
-	options.Database.Read("oauth2", "oauth2ClientSecret", &options.OAuth2ClientSecret)
+	options.Database.ReadSecret("oauth2", "oauth2ClientSecret", &options.OAuth2ClientSecret)
 	options.Database.Read("oauth2", "oauth2UserInfoUrl", &options.OAuth2UserInfoUrl)
 	options.Database.Read("oauth2", "oauth2CodeChallengeMethod", &options.OAuth2CodeChallengeMethod)
 	options.Database.Read("oauth2", "oauth2Scopes", &options.OAuth2Scopes)
@@ -196,13 +204,14 @@ func (ar *OAuth2Router) handleSetOAuthSettingsPOST(w http.ResponseWriter, r *htt
 	ar.options.Database.Write("oauth2", "oauth2TokenUrl", oauth2TokenURL)
 	ar.options.Database.Write("oauth2", "oauth2UserInfoUrl", oauth2UserInfoUrl)
 	ar.options.Database.Write("oauth2", "oauth2ClientId", oauth2ClientId)
-	ar.options.Database.Write("oauth2", "oauth2ClientSecret", oauth2ClientSecret)
+	ar.options.Database.WriteSecret("oauth2", "oauth2ClientSecret", oauth2ClientSecret)
 	ar.options.Database.Write("oauth2", "oauth2Scopes", oauth2Scopes)
 	ar.options.Database.Write("oauth2", "oauth2CodeChallengeMethod", oauth2CodeChallengeMethod)
 	ar.options.Database.Write("oauth2", "oauth2ConfigurationCacheTime", oauth2ConfigurationCacheTime)
 
 	// Flush caches
 	ar.options.OAuth2ConfigCache.DeleteAll()
+	ar.setVerifier(nil)
 
 	utils.SendOK(w)
 }
@@ -227,6 +236,9 @@ func (ar *OAuth2Router) handleSetOAuthSettingsDELETE(w http.ResponseWriter, r *h
 	ar.options.Database.Delete("oauth2", "oauth2CodeChallengeMethod")
 	ar.options.Database.Delete("oauth2", "oauth2ConfigurationCacheTime")
 
+	ar.options.OAuth2ConfigCache.DeleteAll()
+	ar.setVerifier(nil)
+
 	utils.SendOK(w)
 }
 
@@ -261,10 +273,66 @@ func (ar *OAuth2Router) fetchOAuth2Configuration(config *oauth2.Config) (*oauth2
 			ar.options.OAuth2UserInfoUrl = oidcDiscoveryDocument.UserinfoEndpoint
 		}
 
+		if oidcDiscoveryDocument.JwksURI != "" {
+			ar.updateVerifier(oidcDiscoveryDocument.Issuer, oidcDiscoveryDocument.JwksURI)
+		}
 	}
 	return config, nil
 }
 
+// updateVerifier create the ID token verifier from the discovery document, the
+// cached key set is kept if the JWKS url did not change
+func (ar *OAuth2Router) updateVerifier(issuer string, jwksUrl string) {
+	ar.verifierMutex.Lock()
+	defer ar.verifierMutex.Unlock()
+	if ar.verifier != nil && ar.verifier.KeySet.URL == jwksUrl {
+		ar.verifier.Issuer = issuer
+		ar.verifier.ClientID = ar.options.OAuth2ClientId
+		return
+	}
+	ar.verifier = oidc.NewVerifier(issuer, ar.options.OAuth2ClientId, oidc.NewKeySet(jwksUrl))
+}
+
+func (ar *OAuth2Router) setVerifier(verifier *oidc.Verifier) {
+	ar.verifierMutex.Lock()
+	defer ar.verifierMutex.Unlock()
+	ar.verifier = verifier
+}
+
+func (ar *OAuth2Router) getVerifier() *oidc.Verifier {
+	ar.verifierMutex.RLock()
+	defer ar.verifierMutex.RUnlock()
+	return ar.verifier
+}
+
+// getUserClaims return the claims of the user from the verified ID token,
+// or from the user info endpoint if no valid ID token is available
+func (ar *OAuth2Router) getUserClaims(oauthConfig *oauth2.Config, accessToken string, idToken string) (map[string]interface{}, error) {
+	if verifier := ar.getVerifier(); verifier != nil && idToken != "" {
+		claims, err := verifier.VerifyIDToken(idToken)
+		if err == nil {
+			return claims, nil
+		}
+		//ID token expired or invalid, the access token might still be valid
+	}
+
+	client := oauthConfig.Client(context.Background(), &oauth2.Token{AccessToken: accessToken})
+	resp, err := client.Get(ar.options.OAuth2UserInfoUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user info endpoint returned %s", resp.Status)
+	}
+	claims := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		//Non OIDC providers might not return JSON, the token is still valid
+		return map[string]interface{}{}, nil
+	}
+	return claims, nil
+}
+
 func (ar *OAuth2Router) newOAuth2Conf(redirectUrl string) (*oauth2.Config, error) {
 	config := &oauth2.Config{
 		ClientID:     ar.options.OAuth2ClientId,
@@ -288,9 +356,12 @@ func (ar *OAuth2Router) newOAuth2Conf(redirectUrl string) (*oauth2.Config, error
 // HandleOAuth2Auth is the internal handler for OAuth authentication
 // Set useHTTPS to true if your OAuth server is using HTTPS
 // Set OAuthURL to the URL of the OAuth server, e.g. OAuth.example.com
-func (ar *OAuth2Router) HandleOAuth2Auth(w http.ResponseWriter, r *http.Request) error {
+// claimRules are checked against the user claims after login, and the claims
+// selected in forwarding are passed to the upstream
+func (ar *OAuth2Router) HandleOAuth2Auth(w http.ResponseWriter, r *http.Request, claimRules []*oidc.ClaimRule, forwarding *oidc.ClaimForwarding) error {
 	const callbackPrefix = "/internal/oauth2"
 	const tokenCookie = "z-token"
+	const idTokenCookie = "z-id-token"
 	const verifierCookie = "z-verifier"
 	scheme := "http"
 	if r.TLS != nil {
@@ -356,6 +427,28 @@ func (ar *OAuth2Router) HandleOAuth2Auth(w http.ResponseWriter, r *http.Request)
 		}
 		w.Header().Add("Set-Cookie", cookie.String())
 
+		//Verify the ID token returned by OIDC providers and keep it for claim based access rules
+		idTokenValue := ""
+		if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+			if verifier := ar.getVerifier(); verifier != nil {
+				if _, err := verifier.VerifyIDToken(rawIDToken); err != nil {
+					ar.options.Logger.PrintAndLog("OAuth2", "ID token verification failed", err)
+					w.WriteHeader(401)
+					return errors.New("unauthorized")
+				}
+				idTokenValue = rawIDToken
+			}
+		}
+		idCookie := http.Cookie{Name: idTokenCookie, Value: idTokenValue, Path: "/", Expires: cookieExpiry, HttpOnly: true}
+		if idTokenValue == "" {
+			idCookie.Expires = time.Now().Add(-time.Hour * 1)
+		}
+		if scheme == "https" {
+			idCookie.Secure = true
+			idCookie.SameSite = http.SameSiteLaxMode
+		}
+		w.Header().Add("Set-Cookie", idCookie.String())
+
 		if ar.options.OAuth2CodeChallengeMethod == "PKCE" || ar.options.OAuth2CodeChallengeMethod == "PKCE_S256" {
 			cookie := http.Cookie{Name: verifierCookie, Value: "", Path: "/", Expires: time.Now().Add(-time.Hour * 1)}
 			if scheme == "https" {
@@ -380,20 +473,18 @@ func (ar *OAuth2Router) HandleOAuth2Auth(w http.ResponseWriter, r *http.Request)
 		return errors.New("authorized")
 	}
 	unauthorized := false
+	var claims map[string]interface{}
 	cookie, err := r.Cookie(tokenCookie)
 	if err == nil {
 		if cookie.Value == "" {
 			unauthorized = true
 		} else {
-			ctx := context.Background()
-			client := oauthConfig.Client(ctx, &oauth2.Token{AccessToken: cookie.Value})
-			req, err := client.Get(ar.options.OAuth2UserInfoUrl)
-			if err != nil {
-				ar.options.Logger.PrintAndLog("OAuth2", "Failed to get user info", err)
-				unauthorized = true
+			idToken := ""
+			if idCookie, err := r.Cookie(idTokenCookie); err == nil {
+				idToken = idCookie.Value
 			}
-			defer req.Body.Close()
-			if req.StatusCode != http.StatusOK {
+			claims, err = ar.getUserClaims(oauthConfig, cookie.Value, idToken)
+			if err != nil {
 				ar.options.Logger.PrintAndLog("OAuth2", "Failed to get user info", err)
 				unauthorized = true
 			}
@@ -426,5 +517,21 @@ func (ar *OAuth2Router) HandleOAuth2Auth(w http.ResponseWriter, r *http.Request)
 
 		return errors.New("unauthorized")
 	}
+
+	if ok, failedRule := oidc.EvaluateClaimRules(claims, claimRules); !ok {
+		ar.options.Logger.PrintAndLog("OAuth2", "Access denied by claim rule on "+failedRule.Claim+" for "+r.Host, nil)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Forbidden"))
+		return ErrForbidden
+	}
+
+	//Pass the selected claims to the upstream
+	if forwarding != nil {
+		if err := forwarding.Apply(r, claims); err != nil {
+			ar.options.Logger.PrintAndLog("OAuth2", "Failed to forward claims", err)
+			w.WriteHeader(500)
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,215 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+	bearer.go
+
+	JWT bearer token validation for machine clients. Tokens are
+	read from the Authorization header and verified with a shared
+	HMAC secret, a PEM encoded public key or the key set of a
+	JWKS url
+*/
+
+type BearerKeySource string
+
+const (
+	BearerKeySourceSecret    BearerKeySource = "secret"    //HS256 / HS384 / HS512 shared secret
+	BearerKeySourcePublicKey BearerKeySource = "publickey" //PEM encoded RSA, EC or Ed25519 public key or certificate
+	BearerKeySourceJWKS      BearerKeySource = "jwks"      //Keys fetched from a JWKS url
+)
+
+var hmacSigningMethods = []string{"HS256", "HS384", "HS512"}
+
+type BearerConfig struct {
+	KeySource      BearerKeySource
+	Secret         string //Shared secret for HMAC signed tokens
+	PublicKey      string //PEM encoded public key
+	JwksURL        string //JWKS url of the token issuer
+	Issuer         string //Expected iss claim, empty to skip
+	Audience       string //Expected aud claim, empty to skip
+	RequiredScopes []string
+	ForwardHeaders []*ClaimHeader //Claims forwarded to the upstream as headers
+}
+
+// Shared key sets of JWKS urls so endpoints using the same issuer share the cache
+var bearerKeySets sync.Map //map[string]*KeySet
+
+// Parsed public keys, keyed by PEM content
+var bearerPublicKeys sync.Map //map[string]crypto.PublicKey
+
+func getSharedKeySet(url string) *KeySet {
+	keySet, _ := bearerKeySets.LoadOrStore(url, NewKeySet(url))
+	return keySet.(*KeySet)
+}
+
+// ParsePublicKeyPEM parse a PEM encoded PKIX public key or certificate
+func ParsePublicKeyPEM(content string) (crypto.PublicKey, error) {
+	if cached, ok := bearerPublicKeys.Load(content); ok {
+		return cached, nil
+	}
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("invalid PEM encoded public key")
+	}
+
+	var publicKey crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = key
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = key
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = cert.PublicKey
+	default:
+		return nil, errors.New("unsupported PEM block " + block.Type)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+	bearerPublicKeys.Store(content, publicKey)
+	return publicKey, nil
+}
+
+// Validate check if the bearer config is usable
+func (c *BearerConfig) Validate() error {
+	switch c.KeySource {
+	case BearerKeySourceSecret:
+		if len(c.Secret) < 32 {
+			return errors.New("secret must be at least 32 characters")
+		}
+	case BearerKeySourcePublicKey:
+		if _, err := ParsePublicKeyPEM(c.PublicKey); err != nil {
+			return err
+		}
+	case BearerKeySourceJWKS:
+		if !strings.HasPrefix(c.JwksURL, "https://") && !strings.HasPrefix(c.JwksURL, "http://") {
+			return errors.New("invalid JWKS url")
+		}
+	default:
+		return errors.New("invalid key source")
+	}
+	for _, header := range c.ForwardHeaders {
+		if strings.TrimSpace(header.Claim) == "" || strings.TrimSpace(header.Header) == "" || strings.ContainsAny(header.Header, " \t\r\n:") {
+			return errors.New("invalid forwarded claim header")
+		}
+	}
+	return nil
+}
+
+// VerifyBearerToken verify the signature, issuer, audience and lifetime of the token
+func (c *BearerConfig) VerifyBearerToken(rawToken string) (jwt.MapClaims, error) {
+	var keyfunc jwt.Keyfunc
+	validMethods := AsymmetricSigningMethods
+	switch c.KeySource {
+	case BearerKeySourceSecret:
+		if c.Secret == "" {
+			return nil, errors.New("secret not set")
+		}
+		validMethods = hmacSigningMethods
+		keyfunc = func(token *jwt.Token) (interface{}, error) {
+			return []byte(c.Secret), nil
+		}
+	case BearerKeySourcePublicKey:
+		publicKey, err := ParsePublicKeyPEM(c.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keyfunc = func(token *jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		}
+	case BearerKeySourceJWKS:
+		keyfunc = getSharedKeySet(c.JwksURL).Keyfunc
+	default:
+		return nil, errors.New("invalid key source")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(DefaultClockSkew),
+	}
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// GrantedScopes return the scopes of the token from the space separated scope claim or the scp array
+func GrantedScopes(claims map[string]interface{}) []string {
+	scopes := []string{}
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	if scp, ok := claims["scp"]; ok {
+		for _, value := range stringifyClaim(scp) {
+			scopes = append(scopes, strings.Fields(value)...)
+		}
+	}
+	return scopes
+}
+
+// MissingScopes return the required scopes that are not granted to the token
+func (c *BearerConfig) MissingScopes(claims map[string]interface{}) []string {
+	granted := GrantedScopes(claims)
+	missing := []string{}
+	for _, scope := range c.RequiredScopes {
+		if !containsString(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// ForwardClaims replace the forwarded headers of the request with the values from the claims
+func (c *BearerConfig) ForwardClaims(r *http.Request, claims map[string]interface{}) {
+	forwarding := &ClaimForwarding{Headers: c.ForwardHeaders}
+	forwarding.Apply(r, claims)
+}
+
+// ExtractBearerToken read the token from the Authorization header
+func ExtractBearerToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(authHeader[7:])
+	return token, token != ""
+}
@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"iss":   "https://issuer.example.com",
+		"aud":   "api.example.com",
+		"sub":   "service-a",
+		"scope": "read:orders write:orders",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+	signed, err := jwt.NewWithClaims(method, base).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestBearerSecret(t *testing.T) {
+	config := &BearerConfig{
+		KeySource:      BearerKeySourceSecret,
+		Secret:         "0123456789abcdef0123456789abcdef",
+		Issuer:         "https://issuer.example.com",
+		Audience:       "api.example.com",
+		RequiredScopes: []string{"read:orders"},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"Valid", signToken(t, jwt.SigningMethodHS256, []byte(config.Secret), nil), true},
+		{"HS512", signToken(t, jwt.SigningMethodHS512, []byte(config.Secret), nil), true},
+		{"WrongSecret", signToken(t, jwt.SigningMethodHS256, []byte("another-secret-another-secret-xx"), nil), false},
+		{"WrongIssuer", signToken(t, jwt.SigningMethodHS256, []byte(config.Secret), jwt.MapClaims{"iss": "https://other.example.com"}), false},
+		{"WrongAudience", signToken(t, jwt.SigningMethodHS256, []byte(config.Secret), jwt.MapClaims{"aud": "other.example.com"}), false},
+		{"Expired", signToken(t, jwt.SigningMethodHS256, []byte(config.Secret), jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"None", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil), false},
+	}
+	for _, tc := range testcases {
+		_, err := config.VerifyBearerToken(tc.token)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid=%v, got error %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestBearerPublicKeyAndScopes(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	config := &BearerConfig{
+		KeySource:      BearerKeySourcePublicKey,
+		PublicKey:      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		RequiredScopes: []string{"read:orders", "admin"},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := config.VerifyBearerToken(signToken(t, jwt.SigningMethodES256, ecKey, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := config.MissingScopes(claims); len(missing) != 1 || missing[0] != "admin" {
+		t.Errorf("expected admin scope to be missing, got %v", missing)
+	}
+
+	claims, _ = config.VerifyBearerToken(signToken(t, jwt.SigningMethodES256, ecKey, jwt.MapClaims{"scope": nil, "scp": []string{"read:orders", "admin"}}))
+	if missing := config.MissingScopes(claims); len(missing) != 0 {
+		t.Errorf("expected scp array to grant all scopes, missing %v", missing)
+	}
+
+	//Public key must not be usable as a HMAC secret
+	if _, err := config.VerifyBearerToken(signToken(t, jwt.SigningMethodHS256, []byte(config.PublicKey), nil)); err == nil {
+		t.Error("expected HMAC token to be rejected for public key source")
+	}
+}
+
+func TestBearerJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := newMockIssuer(t)
+	issuer.setKeys(map[string]crypto.PublicKey{"k1": &ecKey.PublicKey})
+
+	config := &BearerConfig{KeySource: BearerKeySourceJWKS, JwksURL: issuer.server.URL}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "service-a", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "k1"
+	signed, _ := token.SignedString(ecKey)
+	if _, err := config.VerifyBearerToken(signed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractBearerToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := ExtractBearerToken(r); ok {
+		t.Error("expected no token")
+	}
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if _, ok := ExtractBearerToken(r); ok {
+		t.Error("expected basic auth to be ignored")
+	}
+	r.Header.Set("Authorization", "bearer abc.def.ghi")
+	if token, ok := ExtractBearerToken(r); !ok || token != "abc.def.ghi" {
+		t.Errorf("unexpected token %q", token)
+	}
+}
@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+	claims.go
+
+	Claim based access rules. All rules of an endpoint must pass
+	for the request to be authorized. Claims are addressed by name
+	or by dotted path for nested objects (e.g. realm_access.roles)
+*/
+
+type ClaimOperator string
+
+const (
+	ClaimOperatorAnyOf  ClaimOperator = "any_of"  //At least one value of the claim is in the rule values
+	ClaimOperatorAllOf  ClaimOperator = "all_of"  //All rule values are present in the claim
+	ClaimOperatorNoneOf ClaimOperator = "none_of" //No value of the claim is in the rule values
+	ClaimOperatorExists ClaimOperator = "exists"  //The claim is present and not empty
+)
+
+type ClaimRule struct {
+	Claim    string        //Claim name or dotted path, e.g. groups, hd, realm_access.roles
+	Operator ClaimOperator //Comparison operator
+	Values   []string      //Values to compare against, booleans and numbers are compared as strings
+}
+
+// IsValidOperator check if the operator is supported
+func IsValidOperator(operator ClaimOperator) bool {
+	switch operator {
+	case ClaimOperatorAnyOf, ClaimOperatorAllOf, ClaimOperatorNoneOf, ClaimOperatorExists:
+		return true
+	}
+	return false
+}
+
+// LookupClaim resolve a claim by name or dotted path
+func LookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	//Claims containing dots (e.g. URL style custom claims) take priority over nested lookup
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+	var current interface{} = claims
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// ClaimValues return the claim as a list of strings, arrays are flattened
+func ClaimValues(claims map[string]interface{}, path string) []string {
+	value, ok := LookupClaim(claims, path)
+	if !ok {
+		return []string{}
+	}
+	return stringifyClaim(value)
+}
+
+func stringifyClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{}
+	case string:
+		return []string{v}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case int:
+		return []string{strconv.Itoa(v)}
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}
+	case []string:
+		return v
+	case []interface{}:
+		results := []string{}
+		for _, entry := range v {
+			results = append(results, stringifyClaim(entry)...)
+		}
+		return results
+	}
+	return []string{}
+}
+
+// Match check if the claims satisfy the rule
+func (rule *ClaimRule) Match(claims map[string]interface{}) bool {
+	values := ClaimValues(claims, rule.Claim)
+	switch rule.Operator {
+	case ClaimOperatorExists:
+		return len(values) > 0
+	case ClaimOperatorAnyOf:
+		for _, value := range values {
+			if containsString(rule.Values, value) {
+				return true
+			}
+		}
+		return false
+	case ClaimOperatorAllOf:
+		if len(values) == 0 {
+			return false
+		}
+		for _, expected := range rule.Values {
+			if !containsString(values, expected) {
+				return false
+			}
+		}
+		return true
+	case ClaimOperatorNoneOf:
+		for _, value := range values {
+			if containsString(rule.Values, value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	//Unknown operator, deny
+	return false
+}
+
+// EvaluateClaimRules check the claims against all rules and return the first failed rule
+func EvaluateClaimRules(claims map[string]interface{}, rules []*ClaimRule) (bool, *ClaimRule) {
+	for _, rule := range rules {
+		if !rule.Match(claims) {
+			return false, rule
+		}
+	}
+	return true, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+	forward.go
+
+	Forward selected claims of an authorized user to the upstream,
+	either as plain headers or as a short lived HS256 signed JWT
+	that the upstream can verify with the shared secret
+*/
+
+const (
+	SignedJWTIssuer   = "zoraxy"
+	SignedJWTLifetime = 5 * time.Minute
+)
+
+type ClaimHeader struct {
+	Claim  string //Claim name or dotted path
+	Header string //Upstream request header name, e.g. X-Remote-Groups
+}
+
+type ClaimForwarding struct {
+	Headers         []*ClaimHeader //Claims forwarded as plain headers, multiple values are joined with comma
+	SignedJWTHeader string         //Header for the signed JWT, empty to disable
+	SignedJWTSecret string         //HS256 secret shared with the upstream
+	SignedJWTClaims []string       //Claims included in the signed JWT, sub is always included
+}
+
+// Validate check if the forwarding config is usable
+func (f *ClaimForwarding) Validate() error {
+	for _, header := range f.Headers {
+		if strings.TrimSpace(header.Claim) == "" || strings.TrimSpace(header.Header) == "" {
+			return errors.New("claim and header name cannot be empty")
+		}
+		if strings.ContainsAny(header.Header, " \t\r\n:") {
+			return errors.New("invalid header name " + header.Header)
+		}
+	}
+	if f.SignedJWTHeader != "" {
+		if strings.ContainsAny(f.SignedJWTHeader, " \t\r\n:") {
+			return errors.New("invalid header name " + f.SignedJWTHeader)
+		}
+		if len(f.SignedJWTSecret) < 32 {
+			return errors.New("signed JWT secret must be at least 32 characters")
+		}
+	}
+	return nil
+}
+
+// StripHeaders remove the forwarded headers from the request so they cannot be spoofed by the client
+func (f *ClaimForwarding) StripHeaders(r *http.Request) {
+	for _, header := range f.Headers {
+		r.Header.Del(header.Header)
+	}
+	if f.SignedJWTHeader != "" {
+		r.Header.Del(f.SignedJWTHeader)
+	}
+}
+
+// Apply replace the forwarded headers of the request with the values from the claims
+func (f *ClaimForwarding) Apply(r *http.Request, claims map[string]interface{}) error {
+	f.StripHeaders(r)
+	for _, header := range f.Headers {
+		values := ClaimValues(claims, header.Claim)
+		if len(values) > 0 {
+			r.Header.Set(header.Header, strings.Join(values, ","))
+		}
+	}
+
+	if f.SignedJWTHeader == "" {
+		return nil
+	}
+	signedToken, err := f.SignClaims(claims, r.Host)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(f.SignedJWTHeader, signedToken)
+	return nil
+}
+
+// SignClaims create a short lived JWT containing the selected claims for the given audience
+func (f *ClaimForwarding) SignClaims(claims map[string]interface{}, audience string) (string, error) {
+	if f.SignedJWTSecret == "" {
+		return "", errors.New("signed JWT secret not set")
+	}
+	now := time.Now()
+	forwarded := jwt.MapClaims{
+		"iss": SignedJWTIssuer,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(SignedJWTLifetime).Unix(),
+	}
+	if sub, ok := claims["sub"]; ok {
+		forwarded["sub"] = sub
+	}
+	for _, claim := range f.SignedJWTClaims {
+		switch claim {
+		case "iss", "aud", "iat", "exp", "nbf":
+			//Registered claims are owned by the forwarded token
+			continue
+		}
+		if value, ok := LookupClaim(claims, claim); ok {
+			forwarded[claim] = value
+		}
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, forwarded).SignedString([]byte(f.SignedJWTSecret))
+}
@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+	idtoken.go
+
+	ID token verification against the issuer key set
+*/
+
+const DefaultClockSkew = 60 * time.Second
+
+// Signing algorithms accepted for ID tokens, HMAC is not accepted as the
+// client secret would be the verification key
+var AsymmetricSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type Verifier struct {
+	Issuer   string  //Expected iss claim
+	ClientID string  //Expected to be included in the aud claim
+	KeySet   *KeySet //Key set of the issuer
+	Leeway   time.Duration
+}
+
+// NewVerifier create an ID token verifier for the given issuer and client id
+func NewVerifier(issuer string, clientID string, keySet *KeySet) *Verifier {
+	return &Verifier{
+		Issuer:   issuer,
+		ClientID: clientID,
+		KeySet:   keySet,
+		Leeway:   DefaultClockSkew,
+	}
+}
+
+// VerifyIDToken verify the signature, issuer, audience and lifetime of a raw ID token
+// and return its claims
+func (v *Verifier) VerifyIDToken(rawToken string) (jwt.MapClaims, error) {
+	if v.KeySet == nil {
+		return nil, errors.New("no key set for ID token verification")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(AsymmetricSigningMethods),
+		jwt.WithAudience(v.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.Leeway),
+	}
+	if v.Issuer != "" {
+		options = append(options, jwt.WithIssuer(v.Issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, v.KeySet.Keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	//The authorized party must be this client when the token is issued to multiple audiences
+	if azp, ok := claims["azp"].(string); ok && azp != v.ClientID {
+		return nil, errors.New("ID token is issued to another party")
+	}
+	return claims, nil
+}
@@ -0,0 +1,249 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+	jwks.go
+
+	JSON Web Key Set fetching and caching. Keys are cached for
+	CacheTime and refetched early when a token is signed by an
+	unknown key id, so key rotation on the issuer side is picked
+	up without waiting for the cache to expire
+*/
+
+const (
+	DefaultKeySetCacheTime   = time.Hour
+	MinKeySetRefreshInterval = 10 * time.Second //Rate limit of refetch triggered by unknown key ids
+)
+
+type JSONWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	Use string   `json:"use"`
+	Alg string   `json:"alg"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+type KeySet struct {
+	URL                string
+	CacheTime          time.Duration
+	MinRefreshInterval time.Duration
+
+	client      *http.Client
+	mutex       sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// NewKeySet create a new key set that load keys from the given JWKS url
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		URL:                url,
+		CacheTime:          DefaultKeySetCacheTime,
+		MinRefreshInterval: MinKeySetRefreshInterval,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		keys:               map[string]crypto.PublicKey{},
+	}
+}
+
+// NewStaticKeySet create a key set from a fixed list of public keys, used when
+// the issuer does not publish a JWKS endpoint
+func NewStaticKeySet(keys map[string]crypto.PublicKey) *KeySet {
+	return &KeySet{
+		keys:      keys,
+		fetchedAt: time.Now(),
+	}
+}
+
+// refresh fetch the key set from the JWKS url
+func (k *KeySet) refresh(force bool) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.URL == "" {
+		return nil
+	}
+	if !force && time.Since(k.fetchedAt) < k.CacheTime {
+		//Refreshed by another request
+		return nil
+	}
+	if force && time.Since(k.lastAttempt) < k.MinRefreshInterval {
+		return errors.New("key set refreshed too frequently")
+	}
+	k.lastAttempt = time.Now()
+
+	resp, err := k.client.Get(k.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch key set: %s", resp.Status)
+	}
+
+	jwks := struct {
+		Keys []*JSONWebKey `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		publicKey, err := ParseJSONWebKey(jwk)
+		if err != nil {
+			//Skip unsupported keys instead of failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable signing key in key set")
+	}
+
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	return nil
+}
+
+// getKey return the key with the given key id, refetching the key set if it is expired or the key id is unknown
+func (k *KeySet) getKey(kid string) (crypto.PublicKey, error) {
+	k.mutex.RLock()
+	expired := k.URL != "" && time.Since(k.fetchedAt) >= k.CacheTime
+	k.mutex.RUnlock()
+	if expired {
+		//Keep using the cached keys if the issuer is unreachable
+		k.refresh(false)
+	}
+
+	k.mutex.RLock()
+	key, ok := k.keys[kid]
+	k.mutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	//Unknown key id, the issuer might have rotated its keys
+	if err := k.refresh(true); err != nil {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok = k.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+	return key, nil
+}
+
+// Keyfunc resolve the verification key of a token for jwt.Parse
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid != "" {
+		return k.getKey(kid)
+	}
+
+	//No key id in token, try all known keys
+	k.mutex.RLock()
+	empty := len(k.keys) == 0
+	k.mutex.RUnlock()
+	if empty {
+		k.refresh(false)
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	keySet := jwt.VerificationKeySet{}
+	for _, key := range k.keys {
+		keySet.Keys = append(keySet.Keys, key)
+	}
+	return keySet, nil
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+// ParseJSONWebKey convert a RSA, EC or OKP (Ed25519) JSON web key into a public key
+func ParseJSONWebKey(jwk *JSONWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBase64URL(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URL(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URL(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("invalid EC public key")
+		}
+		return publicKey, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type " + jwk.Kty)
+}
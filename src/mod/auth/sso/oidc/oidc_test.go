@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "zoraxy-client"
+)
+
+// mockIssuer is a local OIDC issuer serving a rotatable JWKS
+type mockIssuer struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	jwks     []map[string]string
+	requests int
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	issuer := &mockIssuer{}
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+		issuer.requests++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": issuer.jwks})
+	}))
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) setKeys(keys map[string]crypto.PublicKey) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.jwks = []map[string]string{}
+	for kid, key := range keys {
+		m.jwks = append(m.jwks, toJWK(kid, key))
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func toJWK(kid string, key crypto.PublicKey) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(k.N.Bytes()), "e": encode(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		ecdhKey, _ := k.ECDH()
+		raw := ecdhKey.Bytes()[1:]
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(raw[:32]), "y": encode(raw[32:])}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encode(k)}
+	}
+	return nil
+}
+
+func (m *mockIssuer) issue(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"iss": m.server.URL,
+		"aud": testClientID,
+		"sub": "alice",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+	token := jwt.NewWithClaims(method, base)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	issuer := newMockIssuer(t)
+	issuer.setKeys(map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey, "ed": edPublic})
+	verifier := NewVerifier(issuer.server.URL, testClientID, NewKeySet(issuer.server.URL))
+
+	testcases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, nil), true},
+		{"ES256", issuer.issue(t, jwt.SigningMethodES256, "ec", ecKey, nil), true},
+		{"EdDSA", issuer.issue(t, jwt.SigningMethodEdDSA, "ed", edKey, nil), true},
+		{"NoKeyID", issuer.issue(t, jwt.SigningMethodRS256, "", rsaKey, nil), true},
+		{"WrongAudience", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"aud": "other"}), false},
+		{"WrongIssuer", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"iss": "https://evil.example.com"}), false},
+		{"OtherAuthorizedParty", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"aud": []string{testClientID, "other"}, "azp": "other"}), false},
+		{"Expired", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"NoExpiry", issuer.issue(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"exp": nil}), false},
+		{"HMAC", issuer.issue(t, jwt.SigningMethodHS256, "rsa", []byte("client-secret-used-as-hmac-key!!"), nil), false},
+		{"UnknownKey", issuer.issue(t, jwt.SigningMethodRS256, "unknown", rsaKey, nil), false},
+	}
+	for _, tc := range testcases {
+		_, err := verifier.VerifyIDToken(tc.token)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid=%v, got error %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuer := newMockIssuer(t)
+	issuer.setKeys(map[string]crypto.PublicKey{"old": &oldKey.PublicKey})
+
+	keySet := NewKeySet(issuer.server.URL)
+	keySet.MinRefreshInterval = 0
+	verifier := NewVerifier(issuer.server.URL, testClientID, keySet)
+
+	if _, err := verifier.VerifyIDToken(issuer.issue(t, jwt.SigningMethodRS256, "old", oldKey, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.VerifyIDToken(issuer.issue(t, jwt.SigningMethodRS256, "old", oldKey, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.requests != 1 {
+		t.Errorf("expected key set to be cached, got %d requests", issuer.requests)
+	}
+
+	//Issuer rotate its signing key, the unknown key id trigger a refetch
+	issuer.setKeys(map[string]crypto.PublicKey{"new": &newKey.PublicKey})
+	if _, err := verifier.VerifyIDToken(issuer.issue(t, jwt.SigningMethodRS256, "new", newKey, nil)); err != nil {
+		t.Fatalf("expected rotated key to be accepted: %v", err)
+	}
+	if _, err := verifier.VerifyIDToken(issuer.issue(t, jwt.SigningMethodRS256, "old", oldKey, nil)); err == nil {
+		t.Error("expected retired key to be rejected")
+	}
+
+	//Refetch is rate limited
+	keySet.MinRefreshInterval = time.Hour
+	requests := issuer.requests
+	verifier.VerifyIDToken(issuer.issue(t, jwt.SigningMethodRS256, "unknown", newKey, nil))
+	if issuer.requests != requests {
+		t.Error("expected unknown key refetch to be rate limited")
+	}
+}
+
+func TestClaimRules(t *testing.T) {
+	claims := map[string]interface{}{
+		"email_verified": true,
+		"hd":             "example.com",
+		"groups":         []interface{}{"developers", "admin"},
+		"realm_access":   map[string]interface{}{"roles": []interface{}{"editor"}},
+		"level":          float64(3),
+	}
+
+	testcases := []struct {
+		rule     ClaimRule
+		expected bool
+	}{
+		{ClaimRule{"email_verified", ClaimOperatorAnyOf, []string{"true"}}, true},
+		{ClaimRule{"hd", ClaimOperatorAnyOf, []string{"example.org"}}, false},
+		{ClaimRule{"groups", ClaimOperatorAnyOf, []string{"admin", "ops"}}, true},
+		{ClaimRule{"groups", ClaimOperatorAllOf, []string{"admin", "developers"}}, true},
+		{ClaimRule{"groups", ClaimOperatorAllOf, []string{"admin", "ops"}}, false},
+		{ClaimRule{"groups", ClaimOperatorNoneOf, []string{"banned"}}, true},
+		{ClaimRule{"groups", ClaimOperatorNoneOf, []string{"admin"}}, false},
+		{ClaimRule{"realm_access.roles", ClaimOperatorAnyOf, []string{"editor"}}, true},
+		{ClaimRule{"level", ClaimOperatorAnyOf, []string{"3"}}, true},
+		{ClaimRule{"hd", ClaimOperatorExists, nil}, true},
+		{ClaimRule{"missing", ClaimOperatorExists, nil}, false},
+		{ClaimRule{"missing", ClaimOperatorAllOf, nil}, false},
+		{ClaimRule{"hd", "unknown", []string{"example.com"}}, false},
+	}
+	for _, tc := range testcases {
+		if tc.rule.Match(claims) != tc.expected {
+			t.Errorf("rule %v: expected %v", tc.rule, tc.expected)
+		}
+	}
+
+	ok, failed := EvaluateClaimRules(claims, []*ClaimRule{&testcases[0].rule, &testcases[1].rule})
+	if ok || failed.Claim != "hd" {
+		t.Error("expected hd rule to fail")
+	}
+	if ok, _ := EvaluateClaimRules(claims, nil); !ok {
+		t.Error("expected empty rules to pass")
+	}
+}
+
+func TestClaimForwarding(t *testing.T) {
+	forwarding := &ClaimForwarding{
+		Headers: []*ClaimHeader{
+			{Claim: "email", Header: "X-Remote-Email"},
+			{Claim: "groups", Header: "X-Remote-Groups"},
+			{Claim: "missing", Header: "X-Remote-Missing"},
+		},
+		SignedJWTHeader: "X-Zoraxy-Identity",
+		SignedJWTSecret: "0123456789abcdef0123456789abcdef",
+		SignedJWTClaims: []string{"email", "exp"},
+	}
+	if err := forwarding.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	r.Header.Set("X-Remote-Missing", "spoofed")
+	r.Header.Set("X-Zoraxy-Identity", "spoofed")
+	claims := map[string]interface{}{
+		"sub":    "alice",
+		"email":  "alice@example.com",
+		"groups": []interface{}{"admin", "developers"},
+		"exp":    float64(time.Now().Add(24 * time.Hour).Unix()),
+	}
+	if err := forwarding.Apply(r, claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Header.Get("X-Remote-Email") != "alice@example.com" || r.Header.Get("X-Remote-Groups") != "admin,developers" {
+		t.Errorf("unexpected forwarded headers %v", r.Header)
+	}
+	if r.Header.Get("X-Remote-Missing") != "" {
+		t.Error("expected spoofed header to be removed")
+	}
+
+	forwarded := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(r.Header.Get("X-Zoraxy-Identity"), forwarded, func(token *jwt.Token) (interface{}, error) {
+		return []byte(forwarding.SignedJWTSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience("app.example.com"), jwt.WithIssuer(SignedJWTIssuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forwarded["sub"] != "alice" || forwarded["email"] != "alice@example.com" {
+		t.Errorf("unexpected signed claims %v", forwarded)
+	}
+	if exp, _ := forwarded.GetExpirationTime(); exp.After(time.Now().Add(SignedJWTLifetime + time.Minute)) {
+		t.Error("expected signed JWT expiry not to be overridden by user claims")
+	}
+
+	if err := (&ClaimForwarding{SignedJWTHeader: "X-Identity", SignedJWTSecret: "short"}).Validate(); err == nil {
+		t.Error("expected short secret to be rejected")
+	}
+}
@@ -0,0 +1,888 @@
+package zorxauth
+
+/*
+	email.go
+
+	This file handle the email features of ZorxAuth, including self-service
+	password reset, invitation based onboarding and security notifications.
+
+	Reset and invitation links carry a token in the form of <id>.<signature>,
+	where the signature is a HMAC of the token purpose and ID. Only the hash
+	of the ID is stored in database, and the record is removed on first use
+	or when it expires, so every link can only be used once.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"imuslab.com/zoraxy/mod/email"
+	"imuslab.com/zoraxy/mod/netutils"
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+const (
+	DB_EMAIL_OPTIONS_KEY      = "email_options"
+	DB_EMAIL_SIGNING_KEY      = "email_signing_key"
+	DB_EMAIL_TOKENS_TABLE     = "zorxauth_email_tokens"
+	DB_EMAIL_TOKEN_KEY_PREFIX = "token_"
+
+	EMAIL_TOKEN_PURPOSE_RESET  = "reset"
+	EMAIL_TOKEN_PURPOSE_INVITE = "invite"
+)
+
+var (
+	ErrEmailDisabled     = errors.New("email is not configured")
+	ErrEmailNoGatewayURL = errors.New("SSO redirect URL must be set to generate email links")
+	ErrEmailTokenInvalid = errors.New("this link is invalid or has already been used")
+	ErrEmailTokenExpired = errors.New("this link has expired")
+)
+
+// EmailOptions contains the SMTP configuration used by ZorxAuth
+type EmailOptions struct {
+	Enabled                   bool   `json:"enabled"`
+	Hostname                  string `json:"hostname"`                  //SMTP server hostname, e.g. mail.example.com
+	Port                      int    `json:"port"`                      //SMTP server port, e.g. 587
+	Username                  string `json:"username"`                  //SMTP login username
+	Password                  string `json:"password"`                  //SMTP login password, anonymous if empty
+	SenderAddr                string `json:"senderAddr"`                //From address of the emails
+	ResetTokenTTL             int    `json:"resetTokenTTL"`             //Seconds a password reset link is valid
+	InviteTokenTTL            int    `json:"inviteTokenTTL"`            //Seconds an invitation link is valid
+	NotifyPasskeyRegistration bool   `json:"notifyPasskeyRegistration"` //Email users when a new passkey is added to their account
+}
+
+// EmailToken is the database record of a reset or invitation link
+type EmailToken struct {
+	ID        string `json:"id"` //Hash of the token ID, used as the public ID
+	Purpose   string `json:"purpose"`
+	Username  string `json:"username"` //Target user of a password reset
+	Email     string `json:"email"`    //Address the link is sent to
+	GroupID   string `json:"groupId"`  //Group policy assigned to invited users
+	CreatedAt int64  `json:"createdAt"`
+	Expiry    int64  `json:"expiry"`
+}
+
+func getDefaultEmailOptions() *EmailOptions {
+	return &EmailOptions{
+		Enabled:                   false,
+		Port:                      587,
+		ResetTokenTTL:             3600,   // 1 hour
+		InviteTokenTTL:            604800, // 7 days
+		NotifyPasskeyRegistration: true,
+	}
+}
+
+// Validate checks the email options for missing or invalid fields
+func (o *EmailOptions) Validate() error {
+	if o.Hostname == "" {
+		return errors.New("SMTP hostname is required")
+	}
+	if o.Port <= 0 || o.Port > 65535 {
+		return errors.New("invalid SMTP port")
+	}
+	if _, err := mail.ParseAddress(o.SenderAddr); err != nil {
+		return errors.New("invalid sender address")
+	}
+	if o.ResetTokenTTL <= 0 || o.InviteTokenTTL <= 0 {
+		return errors.New("link validity must be greater than 0")
+	}
+	return nil
+}
+
+// getEmailOptions returns the current email options
+func (ar *AuthRouter) getEmailOptions() *EmailOptions {
+	ar.emailMutex.RLock()
+	defer ar.emailMutex.RUnlock()
+	if ar.emailOptions == nil {
+		return getDefaultEmailOptions()
+	}
+	return ar.emailOptions
+}
+
+// loadEmailOptions loads the email options from database
+func (ar *AuthRouter) loadEmailOptions() {
+	options := getDefaultEmailOptions()
+	if ar.Database.KeyExists(DB_NAME, DB_EMAIL_OPTIONS_KEY) {
+		if err := ar.Database.Read(DB_NAME, DB_EMAIL_OPTIONS_KEY, options); err != nil && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Failed to load email options: "+err.Error(), err)
+		}
+		password, err := secrets.OpenString(options.Password)
+		if err != nil && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Failed to decrypt SMTP password: "+err.Error(), err)
+		}
+		options.Password = password
+	}
+	ar.emailMutex.Lock()
+	ar.emailOptions = options
+	ar.emailMutex.Unlock()
+}
+
+// EmailEnabled returns whether emails can be sent to users
+func (ar *AuthRouter) EmailEnabled() bool {
+	return ar.getEmailOptions().Enabled && ar.OIDCIssuerURL() != ""
+}
+
+// sendEmail sends a HTML email with the given options
+func sendEmail(options *EmailOptions, to string, subject string, content string) error {
+	sender := email.NewEmailSender(options.Hostname, options.Port, options.Username, options.Password, options.SenderAddr)
+	return sender.SendEmail(to, subject, content)
+}
+
+// renderEmail wraps the paragraphs of an email in a simple HTML layout. Paragraphs are
+// escaped, the link is rendered as a button if given
+func renderEmail(title string, paragraphs []string, linkText string, link string) string {
+	var sb strings.Builder
+	sb.WriteString(`<div style="font-family:-apple-system,'Segoe UI',Roboto,sans-serif;max-width:520px;margin:auto;color:#2d3748;">`)
+	sb.WriteString(`<h2>` + html.EscapeString(title) + `</h2>`)
+	for _, p := range paragraphs {
+		sb.WriteString(`<p>` + html.EscapeString(p) + `</p>`)
+	}
+	if link != "" {
+		sb.WriteString(`<p><a href="` + html.EscapeString(link) + `" style="display:inline-block;padding:10px 20px;background:#319795;color:#fff;border-radius:6px;text-decoration:none;">` + html.EscapeString(linkText) + `</a></p>`)
+		sb.WriteString(`<p style="font-size:12px;color:#718096;">If the button does not work, copy this link into your browser:<br>` + html.EscapeString(link) + `</p>`)
+	}
+	sb.WriteString(`<p style="font-size:12px;color:#a0aec0;">Sent by Zoraxy Auth</p></div>`)
+	return sb.String()
+}
+
+/* ===================== Email Tokens ===================== */
+
+// getEmailSigningKey returns the key used to sign email links, the key is created on first use
+func (ar *AuthRouter) getEmailSigningKey() ([]byte, error) {
+	ar.emailMutex.Lock()
+	defer ar.emailMutex.Unlock()
+	if ar.emailSigningKey != nil {
+		return ar.emailSigningKey, nil
+	}
+
+	encodedKey := ""
+	if ar.Database.KeyExists(DB_NAME, DB_EMAIL_SIGNING_KEY) {
+		ar.Database.ReadSecret(DB_NAME, DB_EMAIL_SIGNING_KEY, &encodedKey)
+	}
+	key, err := hex.DecodeString(encodedKey)
+	if err != nil || len(key) < 32 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := ar.Database.WriteSecret(DB_NAME, DB_EMAIL_SIGNING_KEY, hex.EncodeToString(key)); err != nil {
+			return nil, err
+		}
+	}
+	ar.emailSigningKey = key
+	return key, nil
+}
+
+// signEmailToken returns the signature of a token ID for the given purpose
+func (ar *AuthRouter) signEmailToken(purpose string, id string) (string, error) {
+	key, err := ar.getEmailSigningKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose + ":" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func emailTokenRecordID(id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(hash[:])
+}
+
+// issueEmailToken creates a signed single-use token and stores its record in database
+func (ar *AuthRouter) issueEmailToken(record *EmailToken, ttl int) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+	signature, err := ar.signEmailToken(record.Purpose, id)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	record.ID = emailTokenRecordID(id)
+	record.CreatedAt = now.Unix()
+	record.Expiry = now.Add(time.Duration(ttl) * time.Second).Unix()
+	if err := ar.Database.Write(DB_EMAIL_TOKENS_TABLE, DB_EMAIL_TOKEN_KEY_PREFIX+record.ID, record); err != nil {
+		return "", err
+	}
+	return id + "." + signature, nil
+}
+
+// verifyEmailToken checks the signature and expiry of a token and returns its record
+func (ar *AuthRouter) verifyEmailToken(token string, purpose string) (*EmailToken, error) {
+	id, signature, found := strings.Cut(token, ".")
+	if !found || id == "" {
+		return nil, ErrEmailTokenInvalid
+	}
+	expected, err := ar.signEmailToken(purpose, id)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrEmailTokenInvalid
+	}
+
+	key := DB_EMAIL_TOKEN_KEY_PREFIX + emailTokenRecordID(id)
+	record := &EmailToken{}
+	if !ar.Database.KeyExists(DB_EMAIL_TOKENS_TABLE, key) {
+		return nil, ErrEmailTokenInvalid
+	}
+	if err := ar.Database.Read(DB_EMAIL_TOKENS_TABLE, key, record); err != nil || record.Purpose != purpose {
+		return nil, ErrEmailTokenInvalid
+	}
+	if time.Now().Unix() > record.Expiry {
+		ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, key)
+		return nil, ErrEmailTokenExpired
+	}
+	return record, nil
+}
+
+// consumeEmailToken verifies a token and removes its record so it cannot be used again
+func (ar *AuthRouter) consumeEmailToken(token string, purpose string) (*EmailToken, error) {
+	ar.emailTokenMutex.Lock()
+	defer ar.emailTokenMutex.Unlock()
+	record, err := ar.verifyEmailToken(token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, DB_EMAIL_TOKEN_KEY_PREFIX+record.ID); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// listEmailTokens returns the valid token records of the given purpose, newest first.
+// Expired records are removed
+func (ar *AuthRouter) listEmailTokens(purpose string) []*EmailToken {
+	results := []*EmailToken{}
+	entries, err := ar.Database.ListTable(DB_EMAIL_TOKENS_TABLE)
+	if err != nil {
+		return results
+	}
+	now := time.Now().Unix()
+	for _, entry := range entries {
+		record := &EmailToken{}
+		if err := json.Unmarshal(entry[1], record); err != nil {
+			continue
+		}
+		if now > record.Expiry {
+			ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, string(entry[0]))
+			continue
+		}
+		if purpose == "" || record.Purpose == purpose {
+			results = append(results, record)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt > results[j].CreatedAt
+	})
+	return results
+}
+
+// revokeUserResetTokens removes the pending password reset links of the user
+func (ar *AuthRouter) revokeUserResetTokens(username string) {
+	for _, record := range ar.listEmailTokens(EMAIL_TOKEN_PURPOSE_RESET) {
+		if strings.EqualFold(record.Username, username) {
+			ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, DB_EMAIL_TOKEN_KEY_PREFIX+record.ID)
+		}
+	}
+}
+
+/* ===================== Password Reset & Invitation ===================== */
+
+// SendPasswordReset emails a password reset link to the user. Any previous reset links of the user are revoked
+func (ar *AuthRouter) SendPasswordReset(user *User) error {
+	if !ar.getEmailOptions().Enabled {
+		return ErrEmailDisabled
+	}
+	baseURL := ar.OIDCIssuerURL()
+	if baseURL == "" {
+		return ErrEmailNoGatewayURL
+	}
+	if user.Source == USER_SOURCE_LDAP {
+		return errors.New("password of directory users is managed by the directory")
+	}
+	if user.Email == "" {
+		return errors.New("user has no email address")
+	}
+
+	options := ar.getEmailOptions()
+	ar.revokeUserResetTokens(user.Username)
+	token, err := ar.issueEmailToken(&EmailToken{
+		Purpose:  EMAIL_TOKEN_PURPOSE_RESET,
+		Username: user.Username,
+		Email:    user.Email,
+	}, options.ResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := baseURL + "/reset?token=" + token
+	content := renderEmail("Reset your password", []string{
+		"Hi " + user.Username + ",",
+		"We received a request to reset the password of your account. Use the link below to choose a new password. The link can only be used once and expires in " + formatTTL(options.ResetTokenTTL) + ".",
+		"If you did not request a password reset, you can ignore this email.",
+	}, "Reset Password", link)
+	return sendEmail(options, user.Email, "Reset your password", content)
+}
+
+// SendInvitation emails an invitation link to the given address. Users accepting the
+// invitation choose their own username and password and are assigned the given group policy
+func (ar *AuthRouter) SendInvitation(emailAddr string, groupID string) (*EmailToken, error) {
+	if !ar.getEmailOptions().Enabled {
+		return nil, ErrEmailDisabled
+	}
+	baseURL := ar.OIDCIssuerURL()
+	if baseURL == "" {
+		return nil, ErrEmailNoGatewayURL
+	}
+	if _, err := ar.getUserByEmail(emailAddr); err == nil {
+		return nil, errors.New("a user with this email already exists")
+	}
+	groupName := ""
+	if groupID != "" {
+		gp, err := ar.GetGroupPolicyByID(groupID)
+		if err != nil {
+			return nil, errors.New("group policy not found")
+		}
+		groupName = gp.Name
+	}
+
+	options := ar.getEmailOptions()
+	record := &EmailToken{
+		Purpose: EMAIL_TOKEN_PURPOSE_INVITE,
+		Email:   emailAddr,
+		GroupID: groupID,
+	}
+	token, err := ar.issueEmailToken(record, options.InviteTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	siteName := baseURL
+	if parsed, err := url.Parse(baseURL); err == nil {
+		siteName = parsed.Host
+	}
+	paragraphs := []string{"You have been invited to create an account on " + siteName + "."}
+	if groupName != "" {
+		paragraphs = append(paragraphs, "Your account will be a member of the group "+groupName+".")
+	}
+	paragraphs = append(paragraphs, "Use the link below to choose your username and password. The invitation can only be used once and expires in "+formatTTL(options.InviteTokenTTL)+".")
+	content := renderEmail("You are invited", paragraphs, "Accept Invitation", baseURL+"/invite?token="+token)
+	if err := sendEmail(options, emailAddr, "You are invited to Zoraxy Auth", content); err != nil {
+		//The link was never delivered, do not leave it pending
+		ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, DB_EMAIL_TOKEN_KEY_PREFIX+record.ID)
+		return nil, err
+	}
+	return record, nil
+}
+
+// notifyPasskeyRegistered emails the user about a new passkey on their account
+func (ar *AuthRouter) notifyPasskeyRegistered(user *User, passkeyName string, r *http.Request) {
+	options := ar.getEmailOptions()
+	if !options.Enabled || !options.NotifyPasskeyRegistration || user.Email == "" {
+		return
+	}
+	content := renderEmail("New passkey added", []string{
+		"Hi " + user.Username + ",",
+		"A new passkey \"" + passkeyName + "\" was added to your account on " + time.Now().Format(time.RFC1123) + ".",
+		"Device: " + describeUserAgent(r.UserAgent()) + ", IP address: " + netutils.GetRequesterIP(r),
+		"If this was not you, sign in and remove the passkey, then change your password and contact your administrator.",
+	}, "", "")
+	to := user.Email
+	go func() {
+		if err := sendEmail(options, to, "New passkey added to your account", content); err != nil && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Failed to send passkey notification to "+to+": "+err.Error(), err)
+		}
+	}()
+}
+
+// formatTTL returns a human readable duration for email texts
+func formatTTL(seconds int) string {
+	switch {
+	case seconds%86400 == 0 && seconds >= 86400:
+		return pluralize(seconds/86400, "day")
+	case seconds%3600 == 0 && seconds >= 3600:
+		return pluralize(seconds/3600, "hour")
+	default:
+		return pluralize((seconds+59)/60, "minute")
+	}
+}
+
+func pluralize(n int, unit string) string {
+	s := strconv.Itoa(n) + " " + unit
+	if n != 1 {
+		s += "s"
+	}
+	return s
+}
+
+/* ===================== Gateway Handlers ===================== */
+
+//go:embed reset.html
+var resetPageHTML []byte
+
+//go:embed invite.html
+var invitePageHTML []byte
+
+// writeGatewayJSON writes a JSON response of the public gateway pages
+func writeGatewayJSON(w http.ResponseWriter, status int, payload map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// gatewayRateLimited counts an attempt of the client and returns true if the
+// per-minute attempt ceiling of the login form is reached
+func (gs *GatewayServer) gatewayRateLimited(w http.ResponseWriter, r *http.Request) bool {
+	clientIP := netutils.GetRequesterIPUntrusted(r)
+	if gs.router.Options.EnableRateLimit && gs.router.Options.RateLimitPerIp > 0 {
+		if gs.router.getLoginAttemptCount(clientIP) >= int64(gs.router.Options.RateLimitPerIp) {
+			writeGatewayJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"success": false,
+				"error":   "Too many attempts. Please try again later.",
+			})
+			return true
+		}
+	}
+	gs.router.incrementLoginAttempt(clientIP)
+	return false
+}
+
+// handleResetPage serves the password reset page, GET /reset
+func (gs *GatewayServer) handleResetPage(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resetPageHTML)
+}
+
+// handleResetStatus returns whether self-service password reset is available
+func (gs *GatewayServer) handleResetStatus(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	writeGatewayJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": gs.router.EmailEnabled(),
+	})
+}
+
+// handleResetRequest emails a reset link to the account of the given username or email.
+// The response is the same whether the account exists or not
+func (gs *GatewayServer) handleResetRequest(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeGatewayJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if !gs.router.EmailEnabled() {
+		writeGatewayJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"success": false, "error": "Password reset is not available"})
+		return
+	}
+	if gs.gatewayRateLimited(w, r) {
+		return
+	}
+
+	identifier := strings.TrimSpace(r.FormValue("username"))
+	if identifier == "" {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Username or email is required"})
+		return
+	}
+
+	//Send in background so the response time does not reveal if the account exists
+	go func() {
+		u, err := gs.router.getUserByUsernameOrEmail(identifier)
+		if err != nil {
+			return
+		}
+		if err := gs.router.SendPasswordReset(u); err != nil && gs.router.Logger != nil {
+			gs.router.Logger.PrintAndLog("zorxauth", "Password reset email not sent for "+u.Username+": "+err.Error(), nil)
+		}
+	}()
+
+	writeGatewayJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// handleResetComplete sets a new password with a reset link token and ends all sessions of the user
+func (gs *GatewayServer) handleResetComplete(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeGatewayJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if gs.gatewayRateLimited(w, r) {
+		return
+	}
+
+	newPassword := r.FormValue("new_password")
+	if len(newPassword) < 8 {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "New password must be at least 8 characters"})
+		return
+	}
+
+	record, err := gs.router.consumeEmailToken(r.FormValue("token"), EMAIL_TOKEN_PURPOSE_RESET)
+	if err != nil {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	u, err := gs.router.getUserByUsername(record.Username)
+	if err != nil || u.Source == USER_SOURCE_LDAP || !strings.EqualFold(u.Email, record.Email) {
+		//The account was removed or changed after the link was sent
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": ErrEmailTokenInvalid.Error()})
+		return
+	}
+
+	u.PasswordHash = hashPassword(newPassword)
+	if err := gs.router.saveUser(u, u.Username); err != nil {
+		writeGatewayJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "error": "Failed to save new password"})
+		return
+	}
+	gs.router.RevokeUserSessions(u.Username)
+	if gs.router.Logger != nil {
+		gs.router.Logger.PrintAndLog("zorxauth", "Password of "+u.Username+" reset via email link", nil)
+	}
+
+	writeGatewayJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// handleInvitePage serves the invitation page, GET /invite
+func (gs *GatewayServer) handleInvitePage(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(invitePageHTML)
+}
+
+// handleInviteInfo returns the invited email and group of a valid invitation token
+func (gs *GatewayServer) handleInviteInfo(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	record, err := gs.router.verifyEmailToken(r.URL.Query().Get("token"), EMAIL_TOKEN_PURPOSE_INVITE)
+	if err != nil {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	groupName := ""
+	if gp, err := gs.router.GetGroupPolicyByID(record.GroupID); err == nil {
+		groupName = gp.Name
+	}
+	writeGatewayJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"email":   record.Email,
+		"group":   groupName,
+	})
+}
+
+// handleInviteAccept creates the account of an invited user and signs the user in,
+// so 2FA and passkeys can be set up in the user portal right away
+func (gs *GatewayServer) handleInviteAccept(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeGatewayJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if gs.gatewayRateLimited(w, r) {
+		return
+	}
+
+	token := r.FormValue("token")
+	username := normalizeUsername(r.FormValue("username"))
+	password := r.FormValue("password")
+	if username == "" || strings.ContainsAny(username, "/\\@") {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Invalid username"})
+		return
+	}
+	if len(password) < 8 {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Password must be at least 8 characters"})
+		return
+	}
+
+	//Check the token before the username so invalid links do not reveal existing usernames
+	invite, err := gs.router.verifyEmailToken(token, EMAIL_TOKEN_PURPOSE_INVITE)
+	if err != nil {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	if _, err := gs.router.getUserByUsername(username); err == nil {
+		writeGatewayJSON(w, http.StatusConflict, map[string]interface{}{"success": false, "error": "Username is already taken"})
+		return
+	}
+	if _, err := gs.router.getUserByEmail(invite.Email); err == nil {
+		writeGatewayJSON(w, http.StatusConflict, map[string]interface{}{"success": false, "error": "An account with this email already exists"})
+		return
+	}
+
+	//Only use up the invite once the account can be created
+	record, err := gs.router.consumeEmailToken(token, EMAIL_TOKEN_PURPOSE_INVITE)
+	if err != nil {
+		writeGatewayJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	user := &User{
+		ID:             uuid.NewString(),
+		Username:       username,
+		Email:          record.Email,
+		PasswordHash:   hashPassword(password),
+		UseGroupPolicy: record.GroupID != "",
+		GroupID:        record.GroupID,
+		AllowedHosts:   []string{},
+	}
+	if err := gs.router.saveUser(user, ""); err != nil {
+		writeGatewayJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "error": "Failed to create account"})
+		return
+	}
+	if gs.router.Logger != nil {
+		gs.router.Logger.PrintAndLog("zorxauth", "Invited user "+username+" ("+record.Email+") created their account", nil)
+	}
+
+	cookieDuration := gs.router.Options.CookieDuration
+	sessionToken := gs.router.newGatewaySession(user.Username, r, cookieDuration)
+	http.SetCookie(w, &http.Cookie{
+		Name:     gs.router.Options.CookieName,
+		Value:    sessionToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   cookieDuration,
+	})
+
+	writeGatewayJSON(w, http.StatusOK, map[string]interface{}{
+		"success":        true,
+		"redirectTarget": "/user",
+	})
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleEmailSettings handles the email settings API endpoints
+func (ar *AuthRouter) HandleEmailSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ar.handleEmailSettingsGET(w, r)
+	case http.MethodPost:
+		ar.handleEmailSettingsPOST(w, r)
+	case http.MethodDelete:
+		ar.handleEmailSettingsDELETE(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleEmailSettingsGET returns the email options, the SMTP password is never returned
+func (ar *AuthRouter) handleEmailSettingsGET(w http.ResponseWriter, r *http.Request) {
+	options := *ar.getEmailOptions()
+	passwordSet := options.Password != ""
+	options.Password = ""
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"options":     options,
+		"passwordSet": passwordSet,
+		"linkBaseURL": ar.OIDCIssuerURL(),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// parseEmailOptions reads the email options from the POST form, the stored SMTP
+// password is kept if no new password is given
+func (ar *AuthRouter) parseEmailOptions(r *http.Request) (*EmailOptions, error) {
+	r.ParseForm()
+	options := getDefaultEmailOptions()
+	options.Enabled, _ = utils.PostBool(r, "enabled")
+	options.Hostname = strings.TrimSpace(r.PostForm.Get("hostname"))
+	if port, err := utils.PostInt(r, "port"); err == nil {
+		options.Port = port
+	}
+	options.Username = strings.TrimSpace(r.PostForm.Get("username"))
+	options.Password = r.PostForm.Get("password")
+	if options.Password == "" && options.Username != "" {
+		options.Password = ar.getEmailOptions().Password
+	}
+	options.SenderAddr = strings.TrimSpace(r.PostForm.Get("senderAddr"))
+	if ttl, err := utils.PostInt(r, "resetTokenTTL"); err == nil {
+		options.ResetTokenTTL = ttl
+	}
+	if ttl, err := utils.PostInt(r, "inviteTokenTTL"); err == nil {
+		options.InviteTokenTTL = ttl
+	}
+	if r.PostForm.Get("notifyPasskeyRegistration") != "" {
+		options.NotifyPasskeyRegistration, _ = utils.PostBool(r, "notifyPasskeyRegistration")
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// handleEmailSettingsPOST updates the email options
+func (ar *AuthRouter) handleEmailSettingsPOST(w http.ResponseWriter, r *http.Request) {
+	options, err := ar.parseEmailOptions(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	//The SMTP password is sealed if secrets encryption is enabled
+	stored := *options
+	if stored.Password, err = secrets.SealString(options.Password); err != nil {
+		utils.SendErrorResponse(w, "failed to encrypt SMTP password")
+		return
+	}
+	if err := ar.Database.Write(DB_NAME, DB_EMAIL_OPTIONS_KEY, &stored); err != nil {
+		utils.SendErrorResponse(w, "failed to save email settings")
+		return
+	}
+	ar.emailMutex.Lock()
+	ar.emailOptions = options
+	ar.emailMutex.Unlock()
+
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Email settings updated", nil)
+	}
+	utils.SendOK(w)
+}
+
+// handleEmailSettingsDELETE resets the email options to default, pending links stay
+// valid but no new emails are sent
+func (ar *AuthRouter) handleEmailSettingsDELETE(w http.ResponseWriter, r *http.Request) {
+	ar.Database.Delete(DB_NAME, DB_EMAIL_OPTIONS_KEY)
+	ar.emailMutex.Lock()
+	ar.emailOptions = getDefaultEmailOptions()
+	ar.emailMutex.Unlock()
+	utils.SendOK(w)
+}
+
+// HandleEmailTest sends a test email with the posted settings, require POST to
+func (ar *AuthRouter) HandleEmailTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	options, err := ar.parseEmailOptions(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	to := strings.TrimSpace(r.PostForm.Get("to"))
+	if _, err := mail.ParseAddress(to); err != nil {
+		utils.SendErrorResponse(w, "invalid recipient address")
+		return
+	}
+
+	content := renderEmail("Test email", []string{"Your Zoraxy Auth email settings are working."}, "", "")
+	if err := sendEmail(options, to, "Zoraxy Auth test email", content); err != nil {
+		utils.SendErrorResponse(w, "failed to send email: "+err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleInviteList returns the pending invitations (GET)
+func (ar *AuthRouter) HandleInviteList(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(ar.listEmailTokens(EMAIL_TOKEN_PURPOSE_INVITE))
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleInviteCreate emails an invitation to a new user, require POST email and optional groupId
+func (ar *AuthRouter) HandleInviteCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	emailAddr, err := utils.PostPara(r, "email")
+	if err != nil {
+		utils.SendErrorResponse(w, "missing email")
+		return
+	}
+	parsedAddr, err := mail.ParseAddress(strings.TrimSpace(emailAddr))
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid email format")
+		return
+	}
+
+	record, err := ar.SendInvitation(parsedAddr.Address, strings.TrimSpace(r.FormValue("groupId")))
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Invitation sent to "+record.Email, nil)
+	}
+	utils.SendOK(w)
+}
+
+// HandleInviteRevoke removes a pending invitation, require POST id
+func (ar *AuthRouter) HandleInviteRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "missing id")
+		return
+	}
+	key := DB_EMAIL_TOKEN_KEY_PREFIX + id
+	if strings.ContainsAny(id, "/\\") || !ar.Database.KeyExists(DB_EMAIL_TOKENS_TABLE, key) {
+		utils.SendErrorResponse(w, "invitation not found")
+		return
+	}
+	ar.Database.Delete(DB_EMAIL_TOKENS_TABLE, key)
+	utils.SendOK(w)
+}
+
+// HandleUserSendPasswordReset emails a password reset link to a user, require POST username
+func (ar *AuthRouter) HandleUserSendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		utils.SendErrorResponse(w, "missing username")
+		return
+	}
+	u, err := ar.getUserByUsername(username)
+	if err != nil {
+		utils.SendErrorResponse(w, "user not found")
+		return
+	}
+	if err := ar.SendPasswordReset(u); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
@@ -0,0 +1,260 @@
+package zorxauth
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEmail struct {
+	To   string
+	Data string
+}
+
+// startTestSMTPServer starts a local SMTP stand-in that accepts all mails and
+// sends them to the returned channel
+func startTestSMTPServer(t *testing.T) (string, int, chan *testEmail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mails := make(chan *testEmail, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+				mail := &testEmail{}
+				reply("220 localhost ESMTP")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					command := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+						reply("250 localhost")
+					case strings.HasPrefix(command, "RCPT TO:"):
+						mail.To = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+						reply("250 OK")
+					case command == "DATA":
+						reply("354 End data with <CR><LF>.<CR><LF>")
+						var sb strings.Builder
+						for {
+							dataLine, err := reader.ReadString('\n')
+							if err != nil || dataLine == ".\r\n" {
+								break
+							}
+							sb.WriteString(dataLine)
+						}
+						mail.Data = sb.String()
+						mails <- mail
+						mail = &testEmail{}
+						reply("250 OK")
+					case command == "QUIT":
+						reply("221 Bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, mails
+}
+
+// newTestEmailRouter creates a gateway with email enabled, a group "staff" and a user alice
+func newTestEmailRouter(t *testing.T) (*AuthRouter, http.Handler, chan *testEmail) {
+	ar := newTestAuthRouter(t)
+	ar.Options.SSORedirectURL = "https://auth.example.com"
+	host, port, mails := startTestSMTPServer(t)
+	options := getDefaultEmailOptions()
+	options.Enabled = true
+	options.Hostname = host
+	options.Port = port
+	options.SenderAddr = "noreply@example.com"
+	ar.emailOptions = options
+
+	ar.groupPolicies.Store("gp1", &GroupPolicy{ID: "gp1", Name: "staff"})
+	ar.saveUser(&User{Username: "alice", Email: "alice@example.com", PasswordHash: hashPassword("password")}, "")
+	return ar, NewGatewayServer(ar).mux, mails
+}
+
+func waitForEmail(t *testing.T, mails chan *testEmail) *testEmail {
+	select {
+	case mail := <-mails:
+		return mail
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an email to be sent")
+		return nil
+	}
+}
+
+var testLinkTokenRegexp = regexp.MustCompile(`token=([A-Za-z0-9_\-.]+)`)
+
+func extractLinkToken(t *testing.T, mail *testEmail) string {
+	matches := testLinkTokenRegexp.FindStringSubmatch(mail.Data)
+	if matches == nil {
+		t.Fatalf("expected a link in the email: %s", mail.Data)
+	}
+	return matches[1]
+}
+
+func postGatewayForm(handler http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPasswordReset(t *testing.T) {
+	ar, handler, mails := newTestEmailRouter(t)
+	session := ar.newGatewaySession("alice", httptest.NewRequest(http.MethodGet, "/", nil), 3600)
+
+	//Unknown accounts get the same response and no email
+	rec := postGatewayForm(handler, "/reset/request", url.Values{"username": {"nobody"}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"success":true`) {
+		t.Fatalf("unexpected response for unknown account: %s", rec.Body.String())
+	}
+	select {
+	case <-mails:
+		t.Fatal("expected no email for unknown account")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	postGatewayForm(handler, "/reset/request", url.Values{"username": {"alice@example.com"}})
+	mail := waitForEmail(t, mails)
+	if mail.To != "alice@example.com" || !strings.Contains(mail.Data, "https://auth.example.com/reset?token=") {
+		t.Fatalf("unexpected reset email to %s: %s", mail.To, mail.Data)
+	}
+	token := extractLinkToken(t, mail)
+
+	//Tampered links are rejected
+	tampered := token[:len(token)-2] + "xx"
+	if rec := postGatewayForm(handler, "/reset/complete", url.Values{"token": {tampered}, "new_password": {"new-password"}}); rec.Code == http.StatusOK {
+		t.Error("expected tampered link to be rejected")
+	}
+
+	rec = postGatewayForm(handler, "/reset/complete", url.Values{"token": {token}, "new_password": {"new-password"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected password reset, got %s", rec.Body.String())
+	}
+	if !ar.ValidateUsername("alice", "new-password") || ar.ValidateUsername("alice", "password") {
+		t.Error("expected password to be changed")
+	}
+	if _, ok := ar.getGatewaySession(session); ok {
+		t.Error("expected sessions to be ended after password reset")
+	}
+
+	//Links are single-use
+	if rec := postGatewayForm(handler, "/reset/complete", url.Values{"token": {token}, "new_password": {"other-password"}}); rec.Code == http.StatusOK {
+		t.Error("expected used link to be rejected")
+	}
+}
+
+func TestInvitation(t *testing.T) {
+	ar, handler, mails := newTestEmailRouter(t)
+
+	if _, err := ar.SendInvitation("alice@example.com", ""); err == nil {
+		t.Error("expected invitation of existing email to be rejected")
+	}
+	if _, err := ar.SendInvitation("bob@example.com", "gp1"); err != nil {
+		t.Fatal(err)
+	}
+	mail := waitForEmail(t, mails)
+	token := extractLinkToken(t, mail)
+	if len(ar.listEmailTokens(EMAIL_TOKEN_PURPOSE_INVITE)) != 1 {
+		t.Error("expected a pending invitation")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invite/info?token="+url.QueryEscape(token), nil))
+	if !strings.Contains(rec.Body.String(), "bob@example.com") || !strings.Contains(rec.Body.String(), "staff") {
+		t.Errorf("unexpected invitation info: %s", rec.Body.String())
+	}
+
+	//Invitation links cannot be used as reset links
+	if rec := postGatewayForm(handler, "/reset/complete", url.Values{"token": {token}, "new_password": {"new-password"}}); rec.Code == http.StatusOK {
+		t.Error("expected invitation link to be rejected for password reset")
+	}
+	if rec := postGatewayForm(handler, "/invite/accept", url.Values{"token": {token}, "username": {"alice"}, "password": {"password123"}}); rec.Code != http.StatusConflict {
+		t.Errorf("expected taken username to be rejected, got %d", rec.Code)
+	}
+
+	rec = postGatewayForm(handler, "/invite/accept", url.Values{"token": {token}, "username": {"bob"}, "password": {"password123"}})
+	if rec.Code != http.StatusOK || len(rec.Result().Cookies()) == 0 {
+		t.Fatalf("expected account to be created and signed in, got %s", rec.Body.String())
+	}
+	bob, err := ar.getUserByUsername("bob")
+	if err != nil || bob.Email != "bob@example.com" || !bob.UseGroupPolicy || bob.GroupID != "gp1" || !ar.ValidateUsername("bob", "password123") {
+		t.Fatalf("unexpected invited user: %+v", bob)
+	}
+
+	if rec := postGatewayForm(handler, "/invite/accept", url.Values{"token": {token}, "username": {"bob2"}, "password": {"password123"}}); rec.Code == http.StatusOK {
+		t.Error("expected used invitation to be rejected")
+	}
+
+	//Expired links are rejected
+	expired, _ := ar.issueEmailToken(&EmailToken{Purpose: EMAIL_TOKEN_PURPOSE_INVITE, Email: "carol@example.com"}, -1)
+	if _, err := ar.verifyEmailToken(expired, EMAIL_TOKEN_PURPOSE_INVITE); err != ErrEmailTokenExpired {
+		t.Errorf("expected expired link to be rejected, got %v", err)
+	}
+}
+
+func TestInvitationKeptOnEmailConflict(t *testing.T) {
+	ar, handler, mails := newTestEmailRouter(t)
+	if _, err := ar.SendInvitation("dave@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	token := extractLinkToken(t, waitForEmail(t, mails))
+
+	//An account with the invited email is created before the invite is accepted
+	conflict := &User{ID: "conflict", Username: "dave-old", Email: "dave@example.com", PasswordHash: hashPassword("password")}
+	if err := ar.saveUser(conflict, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postGatewayForm(handler, "/invite/accept", url.Values{"token": {token}, "username": {"dave"}, "password": {"password123"}}); rec.Code != http.StatusConflict {
+		t.Fatalf("expected email conflict to be rejected, got %d", rec.Code)
+	}
+
+	//The invite can still be used once the conflict is resolved
+	conflict.Email = ""
+	if err := ar.saveUser(conflict, conflict.Username); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postGatewayForm(handler, "/invite/accept", url.Values{"token": {token}, "username": {"dave"}, "password": {"password123"}}); rec.Code != http.StatusOK {
+		t.Fatalf("expected invite to be accepted after the conflict is resolved, got %s", rec.Body.String())
+	}
+	if dave, err := ar.getUserByUsername("dave"); err != nil || dave.Email != "dave@example.com" {
+		t.Errorf("unexpected invited user: %+v", dave)
+	}
+}
+
+func TestPasskeyRegistrationNotification(t *testing.T) {
+	ar, _, mails := newTestEmailRouter(t)
+	alice, _ := ar.getUserByUsername("alice")
+
+	ar.notifyPasskeyRegistered(alice, "YubiKey", httptest.NewRequest(http.MethodPost, "/user/api/passkey/register/complete", nil))
+	mail := waitForEmail(t, mails)
+	if mail.To != "alice@example.com" || !strings.Contains(mail.Data, "YubiKey") {
+		t.Errorf("unexpected notification to %s: %s", mail.To, mail.Data)
+	}
+}
@@ -65,6 +65,22 @@ func NewGatewayServer(router *AuthRouter) *GatewayServer {
 	mux.HandleFunc("/user", gs.handleUserPortal)
 	mux.HandleFunc("/user/api/", gs.handleUserPortalAPI)
 
+	// Password reset and invitation endpoints
+	mux.HandleFunc("/reset", gs.handleResetPage)
+	mux.HandleFunc("/reset/status", gs.handleResetStatus)
+	mux.HandleFunc("/reset/request", gs.handleResetRequest)
+	mux.HandleFunc("/reset/complete", gs.handleResetComplete)
+	mux.HandleFunc("/invite", gs.handleInvitePage)
+	mux.HandleFunc("/invite/info", gs.handleInviteInfo)
+	mux.HandleFunc("/invite/accept", gs.handleInviteAccept)
+
+	// OpenID Connect provider endpoints
+	mux.HandleFunc(OIDC_DISCOVERY_PATH, gs.handleOIDCDiscovery)
+	mux.HandleFunc(OIDC_AUTHORIZE_PATH, gs.handleOIDCAuthorize)
+	mux.HandleFunc(OIDC_TOKEN_PATH, gs.handleOIDCToken)
+	mux.HandleFunc(OIDC_USERINFO_PATH, gs.handleOIDCUserInfo)
+	mux.HandleFunc(OIDC_JWKS_PATH, gs.handleOIDCJWKS)
+
 	return gs
 }
 
@@ -199,20 +215,14 @@ func (gs *GatewayServer) handleAuthPage(w http.ResponseWriter, r *http.Request)
 
 		if gs.router.ValidateUserAccessToHost(username, host) {
 			// Renew the gateway session expiry time since the user is actively using it
+			gatewaySessionToken := ""
 			cookie, cookieErr := r.Cookie(gs.router.Options.CookieName)
 			if cookieErr == nil && cookie.Value != "" {
-				// Load the existing gateway session
-				if sessionData, exists := gs.router.gatewaySessionStore.Load(cookie.Value); exists {
-					if gatewaySession, ok := sessionData.(*GatewaySession); ok {
-						// Extend the session expiry time
-						newExpiry := time.Now().Add(time.Duration(gs.router.Options.CookieDuration) * time.Second)
-						gatewaySession.Expiry = newExpiry
-						gs.router.gatewaySessionStore.Store(cookie.Value, gatewaySession)
-					}
-				}
+				gatewaySessionToken = cookie.Value
+				gs.router.renewGatewaySession(gatewaySessionToken)
 			}
 
-			sessionId := gs.router.generateValidationCodeForSession(username)
+			sessionId := gs.router.generateValidationCodeForSession(username, gatewaySessionToken)
 			// Parse the redirect target so we can build the session-set URL on the target host.
 			parsedTarget, parseErr := url.Parse(redirectURL)
 			if parseErr == nil && parsedTarget.Host != "" {
@@ -311,13 +321,13 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	rawRedirect := r.FormValue("redirect")
 	redirectTarget := rawRedirect
-	isDirectLogin := redirectTarget == "/"
-	if redirectTarget == "/" {
+	directTarget, isDirectLogin := gatewayDirectRedirect(rawRedirect)
+	if isDirectLogin {
 		protocolScheme := "http"
 		if r.URL.Scheme != "" {
 			protocolScheme = r.URL.Scheme
 		}
-		redirectTarget = protocolScheme + "://" + r.Host + "/user" // default to user portal if redirect URL is missing
+		redirectTarget = protocolScheme + "://" + r.Host + directTarget // user portal or OIDC authorization on the gateway itself
 	}
 
 	parsedTarget, err := url.Parse(redirectTarget)
@@ -351,7 +361,7 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !gs.router.ValidateUserAccessToHost(u.Username, host) && r.Host != host {
+	if !gs.router.ValidateUserAccessToHost(u.Username, host) && hostnameOnly(r.Host) != host {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -381,6 +391,7 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 			Protocol:       targetProtocol,
 			RedirectTarget: redirectTarget,
 			IsDirectLogin:  isDirectLogin,
+			DirectTarget:   directTarget,
 			RememberMe:     rememberMe,
 			Expiry:         time.Now().Add(5 * time.Minute),
 		})
@@ -407,13 +418,7 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	isSecure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
 
 	// Generate a session token and store it in the gateway session store
-	sessionToken := gs.router.generateSessionToken()
-	expiryTime := time.Now().Add(time.Duration(cookieDuration) * time.Second)
-	gatewaySession := &GatewaySession{
-		Username: u.Username,
-		Expiry:   expiryTime,
-	}
-	gs.router.gatewaySessionStore.Store(sessionToken, gatewaySession)
+	sessionToken := gs.router.newGatewaySession(u.Username, r, cookieDuration)
 
 	// Set the gateway cookie
 	http.SetCookie(w, &http.Cookie{
@@ -426,11 +431,6 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   cookieDuration,
 	})
 
-	// Schedule automatic removal from the store when the cookie expires
-	time.AfterFunc(time.Duration(cookieDuration)*time.Second, func() {
-		gs.router.gatewaySessionStore.Delete(sessionToken)
-	})
-
 	// Direct login: user accessed the gateway domain directly with no redirect target.
 	// Issue the session cookie and send them to the user-management panel.
 	if isDirectLogin {
@@ -438,12 +438,12 @@ func (gs *GatewayServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":        true,
-			"redirectTarget": "/user",
+			"redirectTarget": directTarget,
 		})
 		return
 	}
 
-	sessionId := gs.router.generateValidationCodeForSession(u.Username)
+	sessionId := gs.router.generateValidationCodeForSession(u.Username, sessionToken)
 	hostWithPort := host
 	if port != "" {
 		hostWithPort = host + ":" + port
@@ -524,29 +524,12 @@ func (gs *GatewayServer) handleLogout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie(gs.router.Options.CookieName)
 	if err == nil && cookie.Value != "" {
 		// Remove from gateway session store
-		gs.router.gatewaySessionStore.Delete(cookie.Value)
+		gs.router.revokeGatewaySession(sessionKey(cookie.Value))
 	}
 
-	// Iterate through all browser sessions and remove all sessions for this username
+	// Remove all browser sessions for this username
 	// This ensures the user is logged out from ALL devices/browsers
-	gs.router.cookieIdStore.Range(func(key, value interface{}) bool {
-		sessionID, ok := key.(string)
-		if !ok {
-			return true // continue iteration
-		}
-
-		browserSession, ok := value.(*BrowserSession)
-		if !ok {
-			return true // continue iteration
-		}
-
-		// If this session belongs to the current user, delete it
-		if browserSession.Username == username {
-			gs.router.cookieIdStore.Delete(sessionID)
-		}
-
-		return true // continue iteration
-	})
+	gs.router.revokeUserBrowserSessions(username)
 
 	// Expire the cookie in the current browser
 	isSecure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
@@ -663,15 +646,7 @@ func (gs *GatewayServer) handleTOTPVerify(w http.ResponseWriter, r *http.Request
 	}
 	isSecure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
 
-	sessionToken := gs.router.generateSessionToken()
-	expiryTime := time.Now().Add(time.Duration(cookieDuration) * time.Second)
-	gs.router.gatewaySessionStore.Store(sessionToken, &GatewaySession{
-		Username: u.Username,
-		Expiry:   expiryTime,
-	})
-	time.AfterFunc(time.Duration(cookieDuration)*time.Second, func() {
-		gs.router.gatewaySessionStore.Delete(sessionToken)
-	})
+	sessionToken := gs.router.newGatewaySession(u.Username, r, cookieDuration)
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     gs.router.Options.CookieName,
@@ -690,12 +665,12 @@ func (gs *GatewayServer) handleTOTPVerify(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":        true,
-			"redirectTarget": "/user",
+			"redirectTarget": pending.DirectTarget,
 		})
 		return
 	}
 
-	sessionId := gs.router.generateValidationCodeForSession(u.Username)
+	sessionId := gs.router.generateValidationCodeForSession(u.Username, sessionToken)
 	hostWithPort := pending.Host
 	if pending.Port != "" {
 		hostWithPort = pending.Host + ":" + pending.Port
@@ -36,6 +36,7 @@ type GroupPolicy struct {
 	Name         string   `json:"name"`         //Group name, used for display and reference
 	Description  string   `json:"description"`  //Group description, used for display and reference
 	AllowedHosts []string `json:"allowedHosts"` //List of allowed hosts for this group, if empty, allow all hosts
+	MaxSessions  int      `json:"maxSessions"`  //Maximum concurrent logins per user, the oldest session is ended when exceeded. 0 for unlimited
 }
 
 // initGroupPolicyStore ensures the storage folder exists and loads policies from disk into sync.Map
@@ -223,12 +224,18 @@ func (ar *AuthRouter) HandleGroupPolicyCreate(w http.ResponseWriter, r *http.Req
 
 	description := strings.TrimSpace(r.FormValue("description"))
 	allowedHosts := normalizeAllowedHosts(r.FormValue("allowedHosts"))
+	maxSessions, _ := utils.PostInt(r, "maxSessions")
+	if maxSessions < 0 {
+		utils.SendErrorResponse(w, "max sessions cannot be negative")
+		return
+	}
 
 	gp := &GroupPolicy{
 		ID:           uuid.NewString(),
 		Name:         strings.TrimSpace(name),
 		Description:  description,
 		AllowedHosts: allowedHosts,
+		MaxSessions:  maxSessions,
 	}
 
 	ar.groupPolicies.Store(gp.ID, gp)
@@ -286,6 +293,18 @@ func (ar *AuthRouter) HandleGroupPolicyUpdate(w http.ResponseWriter, r *http.Req
 		updated.AllowedHosts = normalizeAllowedHosts(r.FormValue("allowedHosts"))
 	}
 
+	if _, ok := r.Form["maxSessions"]; ok {
+		maxSessions := 0
+		if raw := strings.TrimSpace(r.FormValue("maxSessions")); raw != "" {
+			maxSessions, err = strconv.Atoi(raw)
+			if err != nil || maxSessions < 0 {
+				utils.SendErrorResponse(w, "invalid max sessions")
+				return
+			}
+		}
+		updated.MaxSessions = maxSessions
+	}
+
 	ar.groupPolicies.Store(id, &updated)
 
 	if err := ar.saveGroupPolicyToDisk(&updated); err != nil {
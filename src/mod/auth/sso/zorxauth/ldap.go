@@ -0,0 +1,559 @@
+package zorxauth
+
+/*
+	ldap.go
+
+	This file handle the LDAP / Active Directory user backend of ZorxAuth.
+
+	When enabled, users that do not exist locally are authenticated against
+	the directory and provisioned just-in-time as ZorxAuth users with source
+	"ldap". Their group policy is updated from the directory group membership
+	on every login, while TOTP and passkeys are kept in the local user record.
+
+	Local users always take precedence over directory users with the same name.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+const (
+	DB_LDAP_OPTIONS_KEY = "ldap_options"
+	USER_SOURCE_LOCAL   = ""
+	USER_SOURCE_LDAP    = "ldap"
+)
+
+var (
+	ErrLDAPDisabled         = errors.New("ldap backend is disabled")
+	ErrLDAPUserNotFound     = errors.New("user not found in directory")
+	ErrLDAPInvalidPassword  = errors.New("invalid directory credentials")
+	ErrLDAPNoMappedGroup    = errors.New("user is not a member of any mapped group")
+	ErrLDAPInvalidConfig    = errors.New("invalid ldap configuration")
+	ErrLDAPMultipleAccounts = errors.New("user search returned multiple entries")
+)
+
+// LDAPGroupMapping maps a directory group onto a ZorxAuth group policy
+type LDAPGroupMapping struct {
+	GroupDN       string `json:"groupDN"`       //DN of the directory group, e.g. CN=Admins,OU=Groups,DC=example,DC=com
+	GroupPolicyID string `json:"groupPolicyID"` //ID of the group policy assigned to members of the group
+}
+
+// LDAPOptions contains the configuration of the LDAP / Active Directory backend
+type LDAPOptions struct {
+	Enabled              bool                `json:"enabled"`
+	URL                  string              `json:"url"`                  //ldap://dc.example.com:389 or ldaps://dc.example.com:636
+	StartTLS             bool                `json:"startTLS"`             //Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify   bool                `json:"insecureSkipVerify"`   //Skip verification of the directory server certificate
+	RootCA               string              `json:"rootCA"`               //PEM encoded CA certificate of the directory server, system roots are used if empty
+	BindDN               string              `json:"bindDN"`               //Service account used to search users, anonymous search if empty
+	BindPassword         string              `json:"bindPassword"`         //Password of the service account
+	BaseDN               string              `json:"baseDN"`               //Search base for users
+	UserFilter           string              `json:"userFilter"`           //User search filter, {username} is replaced with the escaped login name
+	UsernameAttribute    string              `json:"usernameAttribute"`    //Attribute used as ZorxAuth username, e.g. uid or sAMAccountName
+	EmailAttribute       string              `json:"emailAttribute"`       //Attribute used as email, e.g. mail
+	GroupAttribute       string              `json:"groupAttribute"`       //Attribute listing group DNs of the user, e.g. memberOf
+	GroupMappings        []*LDAPGroupMapping `json:"groupMappings"`        //First matching mapping wins
+	DefaultGroupPolicyID string              `json:"defaultGroupPolicyID"` //Group policy for users without a mapped group, login is denied if empty
+	Timeout              int                 `json:"timeout"`              //Connection timeout in seconds
+}
+
+// LDAPDirectoryUser is a user entry found in the directory
+type LDAPDirectoryUser struct {
+	DN       string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+func getDefaultLDAPOptions() *LDAPOptions {
+	return &LDAPOptions{
+		Enabled:           false,
+		UserFilter:        "(&(objectClass=person)(uid={username}))",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		GroupMappings:     []*LDAPGroupMapping{},
+		Timeout:           10,
+	}
+}
+
+// Validate checks the LDAP options for missing or invalid fields
+func (o *LDAPOptions) Validate() error {
+	parsed, err := url.Parse(o.URL)
+	if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Host == "" {
+		return errors.New("directory URL must start with ldap:// or ldaps://")
+	}
+	if o.StartTLS && parsed.Scheme == "ldaps" {
+		return errors.New("StartTLS cannot be used with ldaps://")
+	}
+	if o.BaseDN == "" {
+		return errors.New("base DN is required")
+	}
+	if !strings.Contains(o.UserFilter, "{username}") {
+		return errors.New("user filter must contain {username}")
+	}
+	if _, err := ldap.CompileFilter(strings.ReplaceAll(o.UserFilter, "{username}", "test")); err != nil {
+		return errors.New("invalid user filter: " + err.Error())
+	}
+	if o.UsernameAttribute == "" {
+		return errors.New("username attribute is required")
+	}
+	if o.RootCA != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(o.RootCA)) {
+		return errors.New("invalid root CA certificate")
+	}
+	for _, mapping := range o.GroupMappings {
+		if mapping.GroupDN == "" || mapping.GroupPolicyID == "" {
+			return errors.New("group mappings require a group DN and a group policy")
+		}
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config for connecting to the directory server
+func (o *LDAPOptions) tlsConfig(serverName string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.RootCA != "" {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(o.RootCA))
+		config.RootCAs = pool
+	}
+	return config
+}
+
+/* ===================== Directory Operations ===================== */
+
+// getLDAPOptions returns the current LDAP options
+func (ar *AuthRouter) getLDAPOptions() *LDAPOptions {
+	ar.ldapMutex.RLock()
+	defer ar.ldapMutex.RUnlock()
+	if ar.ldapOptions == nil {
+		return getDefaultLDAPOptions()
+	}
+	return ar.ldapOptions
+}
+
+// loadLDAPOptions loads the LDAP options from database
+func (ar *AuthRouter) loadLDAPOptions() {
+	options := getDefaultLDAPOptions()
+	if ar.Database.KeyExists(DB_NAME, DB_LDAP_OPTIONS_KEY) {
+		if err := ar.Database.Read(DB_NAME, DB_LDAP_OPTIONS_KEY, options); err != nil && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Failed to load LDAP options: "+err.Error(), err)
+		}
+		bindPassword, err := secrets.OpenString(options.BindPassword)
+		if err != nil && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Failed to decrypt LDAP bind password: "+err.Error(), err)
+		}
+		options.BindPassword = bindPassword
+	}
+	ar.ldapMutex.Lock()
+	ar.ldapOptions = options
+	ar.ldapMutex.Unlock()
+}
+
+// connectLDAP opens a connection to the directory and binds with the service account
+func connectLDAP(options *LDAPOptions) (*ldap.Conn, error) {
+	parsed, err := url.Parse(options.URL)
+	if err != nil {
+		return nil, ErrLDAPInvalidConfig
+	}
+	timeout := time.Duration(options.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	conn, err := ldap.DialURL(options.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(options.tlsConfig(parsed.Hostname())),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if options.StartTLS {
+		if err := conn.StartTLS(options.tlsConfig(parsed.Hostname())); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if options.BindDN != "" {
+		if err := conn.Bind(options.BindDN, options.BindPassword); err != nil {
+			conn.Close()
+			return nil, errors.New("service account bind failed: " + err.Error())
+		}
+	}
+	return conn, nil
+}
+
+// searchLDAPUser finds the directory entry of the login name
+func searchLDAPUser(conn *ldap.Conn, options *LDAPOptions, username string) (*LDAPDirectoryUser, error) {
+	attributes := []string{options.UsernameAttribute}
+	if options.EmailAttribute != "" {
+		attributes = append(attributes, options.EmailAttribute)
+	}
+	if options.GroupAttribute != "" {
+		attributes = append(attributes, options.GroupAttribute)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		options.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, 0, false,
+		strings.ReplaceAll(options.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrLDAPUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		return nil, ErrLDAPMultipleAccounts
+	}
+
+	entry := result.Entries[0]
+	directoryUser := &LDAPDirectoryUser{
+		DN:       entry.DN,
+		Username: normalizeUsername(entry.GetAttributeValue(options.UsernameAttribute)),
+		Groups:   []string{},
+	}
+	if options.EmailAttribute != "" {
+		directoryUser.Email = strings.TrimSpace(entry.GetAttributeValue(options.EmailAttribute))
+	}
+	if options.GroupAttribute != "" {
+		directoryUser.Groups = entry.GetAttributeValues(options.GroupAttribute)
+	}
+	if directoryUser.Username == "" {
+		return nil, errors.New("directory entry has no " + options.UsernameAttribute + " attribute")
+	}
+	return directoryUser, nil
+}
+
+// authenticateLDAPUser verifies the password of the login name against the directory
+func authenticateLDAPUser(options *LDAPOptions, username string, password string) (*LDAPDirectoryUser, error) {
+	//Empty passwords would be an unauthenticated bind that always succeeds
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidPassword
+	}
+
+	conn, err := connectLDAP(options)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	directoryUser, err := searchLDAPUser(conn, options, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(directoryUser.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidPassword
+		}
+		return nil, err
+	}
+	return directoryUser, nil
+}
+
+// lookupLDAPUser finds the login name in the directory without verifying the password
+func lookupLDAPUser(options *LDAPOptions, username string) (*LDAPDirectoryUser, error) {
+	conn, err := connectLDAP(options)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return searchLDAPUser(conn, options, username)
+}
+
+// resolveGroupPolicy returns the group policy ID for the directory groups of a user
+func resolveGroupPolicy(options *LDAPOptions, groups []string) (string, error) {
+	for _, mapping := range options.GroupMappings {
+		mappedDN, err := ldap.ParseDN(mapping.GroupDN)
+		for _, group := range groups {
+			if err == nil {
+				if groupDN, err := ldap.ParseDN(group); err == nil && mappedDN.EqualFold(groupDN) {
+					return mapping.GroupPolicyID, nil
+				}
+			}
+			if strings.EqualFold(strings.TrimSpace(mapping.GroupDN), strings.TrimSpace(group)) {
+				return mapping.GroupPolicyID, nil
+			}
+		}
+	}
+	if options.DefaultGroupPolicyID != "" {
+		return options.DefaultGroupPolicyID, nil
+	}
+	return "", ErrLDAPNoMappedGroup
+}
+
+// provisionLDAPUser creates or updates the local record of a directory user.
+// TOTP and passkeys of existing users are kept.
+func (ar *AuthRouter) provisionLDAPUser(options *LDAPOptions, directoryUser *LDAPDirectoryUser) (*User, error) {
+	groupPolicyID, err := resolveGroupPolicy(options, directoryUser.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ar.getUserByUsername(directoryUser.Username)
+	if err != nil {
+		user = &User{
+			ID:       uuid.NewString(),
+			Username: directoryUser.Username,
+			Source:   USER_SOURCE_LDAP,
+		}
+		if ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Provisioned directory user "+directoryUser.Username, nil)
+		}
+	} else if user.Source != USER_SOURCE_LDAP {
+		return nil, errors.New("a local user with the same username already exists")
+	}
+
+	user.Email = directoryUser.Email
+	user.DirectoryDN = directoryUser.DN
+	user.PasswordHash = ""
+	user.UseGroupPolicy = true
+	user.GroupID = groupPolicyID
+	if err := ar.saveUser(user, ""); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// validateLDAPCredentials authenticates the login name against the directory and
+// provisions the user on success
+func (ar *AuthRouter) validateLDAPCredentials(username string, password string) bool {
+	options := ar.getLDAPOptions()
+	if !options.Enabled {
+		return false
+	}
+
+	directoryUser, err := authenticateLDAPUser(options, username, password)
+	if err != nil {
+		if err != ErrLDAPInvalidPassword && err != ErrLDAPUserNotFound && ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "LDAP authentication failed for "+username+": "+err.Error(), err)
+		}
+		return false
+	}
+
+	if _, err := ar.provisionLDAPUser(options, directoryUser); err != nil {
+		if ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "LDAP user "+directoryUser.Username+" rejected: "+err.Error(), err)
+		}
+		return false
+	}
+	return true
+}
+
+// refreshDirectoryUser checks that a directory user still exists and updates its group policy.
+// It is used for logins that do not verify the directory password, e.g. passkeys.
+func (ar *AuthRouter) refreshDirectoryUser(user *User) (*User, error) {
+	if user.Source != USER_SOURCE_LDAP {
+		return user, nil
+	}
+	options := ar.getLDAPOptions()
+	if !options.Enabled {
+		return nil, ErrLDAPDisabled
+	}
+	directoryUser, err := lookupLDAPUser(options, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	return ar.provisionLDAPUser(options, directoryUser)
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleLDAPSettings handles the LDAP backend settings API endpoints
+func (ar *AuthRouter) HandleLDAPSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ar.handleLDAPSettingsGET(w, r)
+	case http.MethodPost:
+		ar.handleLDAPSettingsPOST(w, r)
+	case http.MethodDelete:
+		ar.handleLDAPSettingsDELETE(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLDAPSettingsGET returns the LDAP options, the bind password is never returned
+func (ar *AuthRouter) handleLDAPSettingsGET(w http.ResponseWriter, r *http.Request) {
+	options := *ar.getLDAPOptions()
+	passwordSet := options.BindPassword != ""
+	options.BindPassword = ""
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"options":         options,
+		"bindPasswordSet": passwordSet,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// parseLDAPOptions reads the LDAP options from the POST form, the stored bind
+// password is kept if no new password is given
+func (ar *AuthRouter) parseLDAPOptions(r *http.Request) (*LDAPOptions, error) {
+	r.ParseForm()
+	options := getDefaultLDAPOptions()
+	options.Enabled, _ = utils.PostBool(r, "enabled")
+	options.URL = strings.TrimSpace(r.PostForm.Get("url"))
+	options.StartTLS, _ = utils.PostBool(r, "startTLS")
+	options.InsecureSkipVerify, _ = utils.PostBool(r, "insecureSkipVerify")
+	options.RootCA = strings.TrimSpace(r.PostForm.Get("rootCA"))
+	options.BindDN = strings.TrimSpace(r.PostForm.Get("bindDN"))
+	options.BindPassword = r.PostForm.Get("bindPassword")
+	if options.BindPassword == "" && options.BindDN != "" {
+		options.BindPassword = ar.getLDAPOptions().BindPassword
+	}
+	options.BaseDN = strings.TrimSpace(r.PostForm.Get("baseDN"))
+	if filter := strings.TrimSpace(r.PostForm.Get("userFilter")); filter != "" {
+		options.UserFilter = filter
+	}
+	if attribute := strings.TrimSpace(r.PostForm.Get("usernameAttribute")); attribute != "" {
+		options.UsernameAttribute = attribute
+	}
+	if attribute := strings.TrimSpace(r.PostForm.Get("emailAttribute")); attribute != "" {
+		options.EmailAttribute = attribute
+	}
+	if attribute := strings.TrimSpace(r.PostForm.Get("groupAttribute")); attribute != "" {
+		options.GroupAttribute = attribute
+	}
+	options.DefaultGroupPolicyID = strings.TrimSpace(r.PostForm.Get("defaultGroupPolicyID"))
+	if timeout, err := utils.PostInt(r, "timeout"); err == nil && timeout > 0 {
+		options.Timeout = timeout
+	}
+
+	if groupMappings := r.PostForm.Get("groupMappings"); groupMappings != "" {
+		if err := json.Unmarshal([]byte(groupMappings), &options.GroupMappings); err != nil {
+			return nil, errors.New("invalid group mappings")
+		}
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	//Group policies must exist so users are not provisioned into a missing group
+	policyIDs := []string{}
+	for _, mapping := range options.GroupMappings {
+		policyIDs = append(policyIDs, mapping.GroupPolicyID)
+	}
+	if options.DefaultGroupPolicyID != "" {
+		policyIDs = append(policyIDs, options.DefaultGroupPolicyID)
+	}
+	for _, id := range policyIDs {
+		if _, err := ar.GetGroupPolicyByID(id); err != nil {
+			return nil, errors.New("group policy " + id + " not found")
+		}
+	}
+	return options, nil
+}
+
+// handleLDAPSettingsPOST updates the LDAP options
+func (ar *AuthRouter) handleLDAPSettingsPOST(w http.ResponseWriter, r *http.Request) {
+	options, err := ar.parseLDAPOptions(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	//The bind password is sealed if secrets encryption is enabled
+	stored := *options
+	if stored.BindPassword, err = secrets.SealString(options.BindPassword); err != nil {
+		utils.SendErrorResponse(w, "failed to encrypt LDAP bind password")
+		return
+	}
+	if err := ar.Database.Write(DB_NAME, DB_LDAP_OPTIONS_KEY, &stored); err != nil {
+		utils.SendErrorResponse(w, "failed to save LDAP settings")
+		return
+	}
+	ar.ldapMutex.Lock()
+	ar.ldapOptions = options
+	ar.ldapMutex.Unlock()
+
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "LDAP settings updated", nil)
+	}
+	utils.SendOK(w)
+}
+
+// handleLDAPSettingsDELETE resets the LDAP options to default, directory users
+// can no longer login until the backend is configured again
+func (ar *AuthRouter) handleLDAPSettingsDELETE(w http.ResponseWriter, r *http.Request) {
+	ar.Database.Delete(DB_NAME, DB_LDAP_OPTIONS_KEY)
+	ar.ldapMutex.Lock()
+	ar.ldapOptions = getDefaultLDAPOptions()
+	ar.ldapMutex.Unlock()
+	utils.SendOK(w)
+}
+
+// HandleLDAPTest tests the connection with the posted settings, and if a username
+// is given, looks up the user and the group policy it would be assigned to (POST)
+func (ar *AuthRouter) HandleLDAPTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	options, err := ar.parseLDAPOptions(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	conn, err := connectLDAP(options)
+	if err != nil {
+		utils.SendErrorResponse(w, "connection failed: "+err.Error())
+		return
+	}
+	defer conn.Close()
+
+	username := strings.TrimSpace(r.PostForm.Get("testUsername"))
+	if username == "" {
+		utils.SendOK(w)
+		return
+	}
+
+	directoryUser, err := searchLDAPUser(conn, options, username)
+	if err != nil {
+		utils.SendErrorResponse(w, "user lookup failed: "+err.Error())
+		return
+	}
+	groupPolicyID, err := resolveGroupPolicy(options, directoryUser.Groups)
+	groupPolicyName := ""
+	if err == nil {
+		if gp, err := ar.GetGroupPolicyByID(groupPolicyID); err == nil {
+			groupPolicyName = gp.Name
+		}
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"dn":          directoryUser.DN,
+		"username":    directoryUser.Username,
+		"email":       directoryUser.Email,
+		"groups":      directoryUser.Groups,
+		"groupPolicy": groupPolicyName,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
@@ -0,0 +1,326 @@
+package zorxauth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testLDAPServiceDN       = "cn=zoraxy,ou=services,dc=example,dc=com"
+	testLDAPServicePassword = "service-secret"
+	testLDAPAdminsGroup     = "cn=Admins,ou=groups,dc=example,dc=com"
+	testLDAPStaffGroup      = "cn=Staff,ou=groups,dc=example,dc=com"
+)
+
+type testLDAPEntry struct {
+	DN       string
+	UID      string
+	Mail     string
+	Password string
+	Groups   []string
+}
+
+// testLDAPServer is a minimal in-process directory that answers simple binds and
+// equality searches on uid, enough to exercise the LDAP backend
+type testLDAPServer struct {
+	listener net.Listener
+	entries  sync.Map //uid -> *testLDAPEntry
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testLDAPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) addEntry(entry *testLDAPEntry) {
+	s.entries.Store(entry.UID, entry)
+}
+
+func (s *testLDAPServer) checkBind(dn string, password string) bool {
+	if dn == testLDAPServiceDN {
+		return password == testLDAPServicePassword
+	}
+	found := false
+	s.entries.Range(func(key, value interface{}) bool {
+		entry := value.(*testLDAPEntry)
+		if strings.EqualFold(entry.DN, dn) && entry.Password == password {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			resultCode := ldap.LDAPResultInvalidCredentials
+			if s.checkBind(dn, password) {
+				resultCode = ldap.LDAPResultSuccess
+			}
+			writeTestLDAPResult(conn, messageID, ldap.ApplicationBindResponse, resultCode)
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			s.entries.Range(func(key, value interface{}) bool {
+				entry := value.(*testLDAPEntry)
+				if strings.Contains(filter, "(uid="+entry.UID+")") {
+					writeTestLDAPEntry(conn, messageID, entry)
+				}
+				return true
+			})
+			writeTestLDAPResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		default:
+			return
+		}
+	}
+}
+
+func newTestLDAPEnvelope(messageID int64) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	return envelope
+}
+
+func writeTestLDAPResult(conn net.Conn, messageID int64, tag ber.Tag, resultCode int) {
+	envelope := newTestLDAPEnvelope(messageID)
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	envelope.AppendChild(result)
+	conn.Write(envelope.Bytes())
+}
+
+func writeTestLDAPEntry(conn net.Conn, messageID int64, entry *testLDAPEntry) {
+	envelope := newTestLDAPEnvelope(messageID)
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range map[string][]string{"uid": {entry.UID}, "mail": {entry.Mail}, "memberOf": entry.Groups} {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+	envelope.AppendChild(result)
+	conn.Write(envelope.Bytes())
+}
+
+// newTestLDAPRouter creates an auth router backed by a directory with alice in
+// Admins and bob in Staff, only Admins is mapped to a group policy
+func newTestLDAPRouter(t *testing.T) (*AuthRouter, *testLDAPServer) {
+	directory := newTestLDAPServer(t)
+	directory.addEntry(&testLDAPEntry{DN: "uid=alice,ou=people,dc=example,dc=com", UID: "alice", Mail: "alice@example.com", Password: "alice-password", Groups: []string{testLDAPAdminsGroup}})
+	directory.addEntry(&testLDAPEntry{DN: "uid=bob,ou=people,dc=example,dc=com", UID: "bob", Mail: "bob@example.com", Password: "bob-password", Groups: []string{testLDAPStaffGroup}})
+
+	ar := newTestAuthRouter(t)
+	ar.groupPolicies.Store("gp-admins", &GroupPolicy{ID: "gp-admins", Name: "admins"})
+	ar.groupPolicies.Store("gp-users", &GroupPolicy{ID: "gp-users", Name: "users"})
+
+	options := getDefaultLDAPOptions()
+	options.Enabled = true
+	options.URL = directory.URL()
+	options.BindDN = testLDAPServiceDN
+	options.BindPassword = testLDAPServicePassword
+	options.BaseDN = "dc=example,dc=com"
+	//Mapping DN differs in case and spacing from the memberOf value
+	options.GroupMappings = []*LDAPGroupMapping{{GroupDN: "CN=admins, OU=Groups, DC=example, DC=com", GroupPolicyID: "gp-admins"}}
+	ar.ldapOptions = options
+	return ar, directory
+}
+
+func TestLDAPLoginProvisionsUser(t *testing.T) {
+	ar, _ := newTestLDAPRouter(t)
+
+	if ar.ValidateUsername("alice", "wrong-password") {
+		t.Fatal("expected wrong directory password to be rejected")
+	}
+	if _, err := ar.getUserByUsername("alice"); err == nil {
+		t.Fatal("user must not be provisioned on failed login")
+	}
+
+	if !ar.ValidateUsername("alice", "alice-password") {
+		t.Fatal("expected directory login to succeed")
+	}
+	user, err := ar.getUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Source != USER_SOURCE_LDAP || user.PasswordHash != "" || user.GroupID != "gp-admins" || !user.UseGroupPolicy || user.Email != "alice@example.com" || user.DirectoryDN != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected provisioned user: %+v", user)
+	}
+
+	//Empty password must never result in an unauthenticated bind
+	if ar.ValidateUsername("alice", "") {
+		t.Error("expected empty password to be rejected")
+	}
+
+	//Filter injection must not match other entries
+	if ar.ValidateUsername("*", "alice-password") {
+		t.Error("expected wildcard username to be rejected")
+	}
+}
+
+func TestLDAPGroupMapping(t *testing.T) {
+	ar, directory := newTestLDAPRouter(t)
+
+	//bob is not in a mapped group and there is no default group policy
+	if ar.ValidateUsername("bob", "bob-password") {
+		t.Fatal("expected unmapped directory user to be rejected")
+	}
+
+	ar.ldapOptions.DefaultGroupPolicyID = "gp-users"
+	if !ar.ValidateUsername("bob", "bob-password") {
+		t.Fatal("expected unmapped directory user to login with default group policy")
+	}
+	if user, _ := ar.getUserByUsername("bob"); user == nil || user.GroupID != "gp-users" {
+		t.Fatalf("expected bob in default group policy, got %+v", user)
+	}
+
+	//Group policy and TOTP survive re-login, group membership is refreshed
+	user, _ := ar.getUserByUsername("bob")
+	user.Enable2FA = true
+	user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	user.PasskeyCredentials = []PasskeyCredential{{ID: []byte("credential"), Name: "laptop"}}
+	ar.saveUser(user, "")
+	directory.addEntry(&testLDAPEntry{DN: "uid=bob,ou=people,dc=example,dc=com", UID: "bob", Mail: "bob@example.com", Password: "bob-password", Groups: []string{testLDAPStaffGroup, testLDAPAdminsGroup}})
+
+	if !ar.ValidateUsername("bob", "bob-password") {
+		t.Fatal("expected directory login to succeed")
+	}
+	user, _ = ar.getUserByUsername("bob")
+	if user.GroupID != "gp-admins" || !user.Enable2FA || user.TOTPSecret != "JBSWY3DPEHPK3PXP" || len(user.PasskeyCredentials) != 1 {
+		t.Errorf("expected refreshed group and kept 2FA, got %+v", user)
+	}
+}
+
+func TestLDAPLocalUserTakesPrecedence(t *testing.T) {
+	ar, _ := newTestLDAPRouter(t)
+	ar.saveUser(&User{Username: "alice", PasswordHash: hashPassword("local-password")}, "")
+
+	if ar.ValidateUsername("alice", "alice-password") {
+		t.Error("expected directory password to be rejected for a local user")
+	}
+	if !ar.ValidateUsername("alice", "local-password") {
+		t.Error("expected local password to be accepted")
+	}
+	if user, _ := ar.getUserByUsername("alice"); user.Source != USER_SOURCE_LOCAL {
+		t.Errorf("local user must not be converted to a directory user, got %+v", user)
+	}
+}
+
+func TestLDAPRefreshDirectoryUser(t *testing.T) {
+	ar, directory := newTestLDAPRouter(t)
+	if !ar.ValidateUsername("alice", "alice-password") {
+		t.Fatal("expected directory login to succeed")
+	}
+	user, _ := ar.getUserByUsername("alice")
+	if _, err := ar.refreshDirectoryUser(user); err != nil {
+		t.Fatalf("expected directory user to be refreshed, got %v", err)
+	}
+
+	directory.entries.Delete("alice")
+	if _, err := ar.refreshDirectoryUser(user); err != ErrLDAPUserNotFound {
+		t.Errorf("expected removed directory user to be rejected, got %v", err)
+	}
+
+	ar.ldapOptions.Enabled = false
+	if _, err := ar.refreshDirectoryUser(user); err != ErrLDAPDisabled {
+		t.Errorf("expected disabled backend to reject directory users, got %v", err)
+	}
+}
+
+func TestLDAPSettingsAPI(t *testing.T) {
+	ar, directory := newTestLDAPRouter(t)
+
+	post := func(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	form := url.Values{
+		"enabled":       {"true"},
+		"url":           {directory.URL()},
+		"bindDN":        {testLDAPServiceDN},
+		"baseDN":        {"dc=example,dc=com"},
+		"groupMappings": {`[{"groupDN":"` + testLDAPAdminsGroup + `","groupPolicyID":"gp-admins"}]`},
+	}
+
+	//Stored bind password is kept when the field is left empty
+	if rec := post(ar.HandleLDAPSettings, form); strings.Contains(rec.Body.String(), "error") {
+		t.Fatalf("expected settings to be saved, got %s", rec.Body.String())
+	}
+	if ar.getLDAPOptions().BindPassword != testLDAPServicePassword {
+		t.Error("expected stored bind password to be kept")
+	}
+
+	rec := httptest.NewRecorder()
+	ar.HandleLDAPSettings(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), testLDAPServicePassword) || !strings.Contains(rec.Body.String(), `"bindPasswordSet":true`) {
+		t.Errorf("bind password must not be returned, got %s", rec.Body.String())
+	}
+
+	testForm := url.Values{}
+	for k, v := range form {
+		testForm[k] = v
+	}
+	testForm.Set("testUsername", "alice")
+	if rec := post(ar.HandleLDAPTest, testForm); !strings.Contains(rec.Body.String(), `"groupPolicy":"admins"`) {
+		t.Errorf("expected test lookup to resolve group policy, got %s", rec.Body.String())
+	}
+
+	invalid := []url.Values{
+		{"url": {"http://dc.example.com"}, "baseDN": {"dc=example,dc=com"}},
+		{"url": {directory.URL()}, "baseDN": {"dc=example,dc=com"}, "userFilter": {"(uid=admin)"}},
+		{"url": {directory.URL()}, "baseDN": {"dc=example,dc=com"}, "defaultGroupPolicyID": {"missing"}},
+	}
+	for _, form := range invalid {
+		if rec := post(ar.HandleLDAPSettings, form); !strings.Contains(rec.Body.String(), "error") {
+			t.Errorf("expected invalid settings %v to be rejected", form)
+		}
+	}
+}
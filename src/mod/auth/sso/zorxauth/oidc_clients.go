@@ -0,0 +1,340 @@
+package zorxauth
+
+/*
+	oidc_clients.go
+
+	This file handle the OpenID Connect clients (relying parties)
+	that are registered to login users with ZorxAuth as the
+	identity provider, e.g. Grafana or Gitea.
+
+	Client secrets are only returned once on creation or reset,
+	only the hash of the secret is stored in the database.
+*/
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+// OIDCClient is a relying party registered to use ZorxAuth as OpenID Connect provider
+type OIDCClient struct {
+	ID           string   `json:"id"`           //The client_id, generated by uuid
+	Name         string   `json:"name"`         //Display name of the application
+	SecretHash   string   `json:"secretHash"`   //SHA256 hash of the client_secret, empty for public clients
+	Public       bool     `json:"public"`       //Public clients (SPA / native apps) have no secret and must use PKCE
+	RedirectURIs []string `json:"redirectURIs"` //Exact redirect URIs allowed for this client
+	CreatedAt    int64    `json:"createdAt"`    //Unix timestamp
+}
+
+// OIDCClientResponse is the client info returned to the management UI, without the secret hash
+type OIDCClientResponse struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirectURIs"`
+	CreatedAt    int64    `json:"createdAt"`
+}
+
+func hashClientSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// VerifySecret checks the given client secret against the stored hash
+func (c *OIDCClient) VerifySecret(secret string) bool {
+	if c.Public || c.SecretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(hashClientSecret(secret))) == 1
+}
+
+// HasRedirectURI checks if the redirect URI is registered for this client, only exact match is allowed
+func (c *OIDCClient) HasRedirectURI(redirectURI string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRedirectURIs parse a comma or newline separated list of redirect URIs
+func parseRedirectURIs(raw string) ([]string, error) {
+	raw = strings.ReplaceAll(raw, "\n", ",")
+	redirectURIs := []string{}
+	for _, redirectURI := range strings.Split(raw, ",") {
+		redirectURI = strings.TrimSpace(redirectURI)
+		if redirectURI == "" || utils.StringInArray(redirectURIs, redirectURI) {
+			continue
+		}
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+			return nil, errors.New("invalid redirect URI: " + redirectURI)
+		}
+		if (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host == "" {
+			return nil, errors.New("invalid redirect URI: " + redirectURI)
+		}
+		redirectURIs = append(redirectURIs, redirectURI)
+	}
+	if len(redirectURIs) == 0 {
+		return nil, errors.New("at least one redirect URI is required")
+	}
+	return redirectURIs, nil
+}
+
+func oidcClientToResponse(client *OIDCClient) *OIDCClientResponse {
+	return &OIDCClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		Public:       client.Public,
+		RedirectURIs: client.RedirectURIs,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func (ar *AuthRouter) getOIDCClient(clientID string) (*OIDCClient, error) {
+	if ar.Database == nil {
+		return nil, errors.New("database not available")
+	}
+	clientID = strings.TrimSpace(clientID)
+	if clientID == "" || !ar.Database.KeyExists(DB_OIDC_CLIENTS_TABLE, clientID) {
+		return nil, errors.New("client not found")
+	}
+	client := &OIDCClient{}
+	if err := ar.Database.Read(DB_OIDC_CLIENTS_TABLE, clientID, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (ar *AuthRouter) listOIDCClients() ([]*OIDCClient, error) {
+	if ar.Database == nil {
+		return nil, errors.New("database not available")
+	}
+	entries, err := ar.Database.ListTable(DB_OIDC_CLIENTS_TABLE)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []*OIDCClient{}
+	for _, keypairs := range entries {
+		if len(keypairs) < 2 {
+			continue
+		}
+		client := &OIDCClient{}
+		if err := json.Unmarshal(keypairs[1], client); err != nil {
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return strings.ToLower(clients[i].Name) < strings.ToLower(clients[j].Name)
+	})
+	return clients, nil
+}
+
+func (ar *AuthRouter) saveOIDCClient(client *OIDCClient) error {
+	if ar.Database == nil {
+		return errors.New("database not available")
+	}
+	return ar.Database.Write(DB_OIDC_CLIENTS_TABLE, client.ID, client)
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleOIDCClientList returns all registered OIDC clients as JSON (GET)
+func (ar *AuthRouter) HandleOIDCClientList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	clients, err := ar.listOIDCClients()
+	if err != nil {
+		utils.SendErrorResponse(w, "failed to list clients")
+		return
+	}
+
+	results := []*OIDCClientResponse{}
+	for _, client := range clients {
+		results = append(results, oidcClientToResponse(client))
+	}
+	js, _ := json.Marshal(results)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleOIDCClientCreate registers a new OIDC client and returns the client secret once (POST)
+func (ar *AuthRouter) HandleOIDCClientCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	name, err := utils.PostPara(r, "name")
+	if err != nil || strings.TrimSpace(name) == "" {
+		utils.SendErrorResponse(w, "client name is required")
+		return
+	}
+
+	redirectURIs, err := parseRedirectURIs(r.FormValue("redirectURIs"))
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	isPublic, _ := utils.PostBool(r, "public")
+	client := &OIDCClient{
+		ID:           uuid.NewString(),
+		Name:         strings.TrimSpace(name),
+		Public:       isPublic,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now().Unix(),
+	}
+
+	secret := ""
+	if !isPublic {
+		secret = ar.generateSessionToken()
+		client.SecretHash = hashClientSecret(secret)
+	}
+
+	if err := ar.saveOIDCClient(client); err != nil {
+		utils.SendErrorResponse(w, "failed to save client")
+		return
+	}
+
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "OIDC client registered: "+client.Name+" ("+client.ID+")", nil)
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"id":     client.ID,
+		"secret": secret,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleOIDCClientUpdate updates the name or redirect URIs of an OIDC client (POST)
+func (ar *AuthRouter) HandleOIDCClientUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "client ID is required")
+		return
+	}
+
+	client, err := ar.getOIDCClient(id)
+	if err != nil {
+		utils.SendErrorResponse(w, "client not found")
+		return
+	}
+
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		client.Name = name
+	}
+
+	if _, ok := r.Form["redirectURIs"]; ok {
+		redirectURIs, err := parseRedirectURIs(r.FormValue("redirectURIs"))
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+		client.RedirectURIs = redirectURIs
+	}
+
+	if err := ar.saveOIDCClient(client); err != nil {
+		utils.SendErrorResponse(w, "failed to save client")
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleOIDCClientResetSecret generates a new secret for a confidential OIDC client (POST)
+func (ar *AuthRouter) HandleOIDCClientResetSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "client ID is required")
+		return
+	}
+
+	client, err := ar.getOIDCClient(id)
+	if err != nil {
+		utils.SendErrorResponse(w, "client not found")
+		return
+	}
+
+	if client.Public {
+		utils.SendErrorResponse(w, "public clients do not have a secret")
+		return
+	}
+
+	secret := ar.generateSessionToken()
+	client.SecretHash = hashClientSecret(secret)
+	if err := ar.saveOIDCClient(client); err != nil {
+		utils.SendErrorResponse(w, "failed to save client")
+		return
+	}
+
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "OIDC client secret reset: "+client.Name+" ("+client.ID+")", nil)
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"id":     client.ID,
+		"secret": secret,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleOIDCClientDelete removes an OIDC client, pending authorization codes of the client are revoked (POST)
+func (ar *AuthRouter) HandleOIDCClientDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		utils.SendErrorResponse(w, "client ID is required")
+		return
+	}
+
+	if _, err := ar.getOIDCClient(id); err != nil {
+		utils.SendErrorResponse(w, "client not found")
+		return
+	}
+
+	if err := ar.Database.Delete(DB_OIDC_CLIENTS_TABLE, id); err != nil {
+		utils.SendErrorResponse(w, "failed to delete client")
+		return
+	}
+
+	ar.oidcAuthCodes.Range(func(key, value interface{}) bool {
+		if authCode, ok := value.(*OIDCAuthCode); ok && authCode.ClientID == id {
+			ar.oidcAuthCodes.Delete(key)
+		}
+		return true
+	})
+
+	utils.SendOK(w)
+}
@@ -0,0 +1,579 @@
+package zorxauth
+
+/*
+	oidc_provider.go
+
+	This file implements the OpenID Connect provider of ZorxAuth
+	so applications like Grafana or Gitea can login users directly
+	against the ZorxAuth user base. The endpoints are served by the
+	authentication gateway.
+
+	Only the authorization code flow is supported. Public clients
+	must use PKCE (S256), confidential clients authenticate to the
+	token endpoint with client_secret_basic or client_secret_post.
+
+	Users are only issued codes for clients whose redirect URI host
+	is allowed by their user or group policy allowed hosts.
+*/
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DB_OIDC_CLIENTS_TABLE  = "zorxauth_oidc_clients"
+	DB_OIDC_SIGNING_KEY    = "oidc_signing_key"
+	OIDC_DISCOVERY_PATH    = "/.well-known/openid-configuration"
+	OIDC_AUTHORIZE_PATH    = "/oidc/authorize"
+	OIDC_TOKEN_PATH        = "/oidc/token"
+	OIDC_USERINFO_PATH     = "/oidc/userinfo"
+	OIDC_JWKS_PATH         = "/oidc/jwks"
+	OIDC_AUTH_CODE_TTL     = 60 * time.Second
+	OIDC_TOKEN_TTL         = time.Hour
+	OIDC_ACCESS_TOKEN_TYPE = "at+jwt"
+)
+
+// OIDCSupportedScopes are the scopes that can be granted to clients
+var OIDCSupportedScopes = []string{"openid", "profile", "email", "groups"}
+
+// OIDCAuthCode is a one-time authorization code pending exchange at the token endpoint
+type OIDCAuthCode struct {
+	ClientID      string
+	Username      string
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	Expiry        time.Time
+}
+
+// oidcError is an OAuth 2.0 error response body
+type oidcError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+/* ===================== Signing Key ===================== */
+
+// getOIDCSigningKey loads the RSA key for signing tokens, a new key is generated on first use
+func (ar *AuthRouter) getOIDCSigningKey() (*rsa.PrivateKey, string, error) {
+	ar.oidcKeyMutex.Lock()
+	defer ar.oidcKeyMutex.Unlock()
+
+	if ar.oidcSigningKey != nil {
+		return ar.oidcSigningKey, ar.oidcKeyID, nil
+	}
+
+	var key *rsa.PrivateKey
+	encodedKey := ""
+	if ar.Database.KeyExists(DB_NAME, DB_OIDC_SIGNING_KEY) {
+		ar.Database.ReadSecret(DB_NAME, DB_OIDC_SIGNING_KEY, &encodedKey)
+	}
+
+	if block, _ := pem.Decode([]byte(encodedKey)); block != nil {
+		parsedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", err
+		}
+		key = parsedKey
+	} else {
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, "", err
+		}
+		encodedKey = string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(newKey),
+		}))
+		if err := ar.Database.WriteSecret(DB_NAME, DB_OIDC_SIGNING_KEY, encodedKey); err != nil {
+			return nil, "", err
+		}
+		key = newKey
+		if ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Generated new OIDC token signing key", nil)
+		}
+	}
+
+	//Key ID is derived from the public key so it changes together with the key
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	keyHash := sha256.Sum256(publicKeyDER)
+
+	ar.oidcSigningKey = key
+	ar.oidcKeyID = base64.RawURLEncoding.EncodeToString(keyHash[:16])
+	return ar.oidcSigningKey, ar.oidcKeyID, nil
+}
+
+// signOIDCToken signs the claims with the provider signing key using RS256
+func (ar *AuthRouter) signOIDCToken(claims jwt.MapClaims, tokenType string) (string, error) {
+	key, kid, err := ar.getOIDCSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	if tokenType != "" {
+		token.Header["typ"] = tokenType
+	}
+	return token.SignedString(key)
+}
+
+// oidcIssuer returns the issuer identifier, which is the SSO redirect URL if set
+func (ar *AuthRouter) oidcIssuer(r *http.Request) string {
+	if ar.Options.SSORedirectURL != "" {
+		return strings.TrimRight(ar.Options.SSORedirectURL, "/")
+	}
+	return requestScheme(r) + "://" + r.Host
+}
+
+// OIDCIssuerURL returns the absolute URL of the OIDC provider for clients within
+// Zoraxy, e.g. the management interface SSO login. Empty if the SSO redirect URL is not set
+func (ar *AuthRouter) OIDCIssuerURL() string {
+	if ar.Options.SSORedirectURL == "" {
+		return ""
+	}
+	issuer, err := ensureAbsoluteHTTPURL(ar.Options.SSORedirectURL, "https")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(issuer, "/")
+}
+
+/* ===================== Claims ===================== */
+
+// parseOIDCScopes returns the supported scopes in the requested scope string
+func parseOIDCScopes(scope string) []string {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		for _, supported := range OIDCSupportedScopes {
+			if s == supported && !oidcHasScope(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+func oidcHasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcUserClaims returns the user claims released for the granted scopes
+func (ar *AuthRouter) oidcUserClaims(u *User, scopes []string) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub": u.ID,
+	}
+	if oidcHasScope(scopes, "profile") {
+		claims["preferred_username"] = u.Username
+		claims["name"] = u.Username
+	}
+	if oidcHasScope(scopes, "email") && u.Email != "" {
+		claims["email"] = u.Email
+	}
+	if oidcHasScope(scopes, "groups") {
+		groups := []string{}
+		if u.UseGroupPolicy && u.GroupID != "" {
+			if gp, err := ar.GetGroupPolicyByID(u.GroupID); err == nil {
+				groups = append(groups, gp.Name)
+			}
+		}
+		claims["groups"] = groups
+	}
+	return claims
+}
+
+/* ===================== Gateway Handlers ===================== */
+
+// handleOIDCDiscovery serves the OpenID Provider configuration document
+func (gs *GatewayServer) handleOIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+
+	issuer := gs.router.oidcIssuer(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + OIDC_AUTHORIZE_PATH,
+		"token_endpoint":                        issuer + OIDC_TOKEN_PATH,
+		"userinfo_endpoint":                     issuer + OIDC_USERINFO_PATH,
+		"jwks_uri":                              issuer + OIDC_JWKS_PATH,
+		"end_session_endpoint":                  issuer + "/logout",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      OIDCSupportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "email", "groups"},
+	})
+}
+
+// handleOIDCJWKS serves the public key used for signing ID and access tokens
+func (gs *GatewayServer) handleOIDCJWKS(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+
+	key, kid, err := gs.router.getOIDCSigningKey()
+	if err != nil {
+		http.Error(w, "signing key not available", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// handleOIDCAuthorize handles the authorization request. Users without a gateway session
+// are sent to the login page and come back here after login.
+func (gs *GatewayServer) handleOIDCAuthorize(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	params := url.Values{}
+	for _, key := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method", "prompt"} {
+		if value := r.Form.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	//Errors before the redirect URI is validated must not redirect back to the client
+	client, err := gs.router.getOIDCClient(params.Get("client_id"))
+	if err != nil {
+		http.Error(w, "invalid_client: unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := params.Get("redirect_uri")
+	if !client.HasRedirectURI(redirectURI) {
+		http.Error(w, "invalid_request: redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	state := params.Get("state")
+	if params.Get("response_type") != "code" {
+		redirectOIDCError(w, r, redirectURI, state, "unsupported_response_type", "only the authorization code flow is supported")
+		return
+	}
+
+	scopes := parseOIDCScopes(params.Get("scope"))
+	if !oidcHasScope(scopes, "openid") {
+		redirectOIDCError(w, r, redirectURI, state, "invalid_scope", "the openid scope is required")
+		return
+	}
+
+	codeChallenge := params.Get("code_challenge")
+	if codeChallenge != "" && params.Get("code_challenge_method") != "S256" {
+		redirectOIDCError(w, r, redirectURI, state, "invalid_request", "only the S256 code challenge method is supported")
+		return
+	}
+	if codeChallenge == "" && client.Public {
+		redirectOIDCError(w, r, redirectURI, state, "invalid_request", "PKCE is required for public clients")
+		return
+	}
+
+	authenticated, username := gs.router.RequestIsAuthenticatedInSSO(w, r)
+	if !authenticated {
+		if params.Get("prompt") == "none" {
+			redirectOIDCError(w, r, redirectURI, state, "login_required", "")
+			return
+		}
+		//Login on the gateway and continue the authorization afterward
+		continueURL := OIDC_AUTHORIZE_PATH + "?" + params.Encode()
+		http.Redirect(w, r, "/?redirect="+url.QueryEscape(continueURL), http.StatusFound)
+		return
+	}
+
+	parsedRedirectURI, _ := url.Parse(redirectURI)
+	if !gs.router.ValidateUserAccessToHost(username, parsedRedirectURI.Hostname()) {
+		redirectOIDCError(w, r, redirectURI, state, "access_denied", "user is not allowed to access this application")
+		return
+	}
+
+	authCode := &OIDCAuthCode{
+		ClientID:      client.ID,
+		Username:      username,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		Nonce:         params.Get("nonce"),
+		CodeChallenge: codeChallenge,
+		AuthTime:      time.Now(),
+		Expiry:        time.Now().Add(OIDC_AUTH_CODE_TTL),
+	}
+	code := gs.router.generateSessionToken()
+	gs.router.oidcAuthCodes.Store(code, authCode)
+	time.AfterFunc(OIDC_AUTH_CODE_TTL, func() {
+		gs.router.oidcAuthCodes.Delete(code)
+	})
+
+	response := url.Values{}
+	response.Set("code", code)
+	if state != "" {
+		response.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, response), http.StatusFound)
+}
+
+// handleOIDCToken exchanges an authorization code for ID and access tokens
+func (gs *GatewayServer) handleOIDCToken(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		sendOIDCError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	//Client authentication with client_secret_basic, client_secret_post or none for public clients
+	clientID, clientSecret, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := gs.router.getOIDCClient(clientID)
+	if err != nil || (!client.Public && !client.VerifySecret(clientSecret)) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="zorxauth"`)
+		sendOIDCError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	//Codes are one-time use, remove it before any other checks
+	value, exists := gs.router.oidcAuthCodes.LoadAndDelete(r.PostForm.Get("code"))
+	if !exists {
+		sendOIDCError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+	authCode := value.(*OIDCAuthCode)
+	if time.Now().After(authCode.Expiry) || authCode.ClientID != client.ID || authCode.RedirectURI != r.PostForm.Get("redirect_uri") {
+		sendOIDCError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+
+	if authCode.CodeChallenge != "" {
+		verifierHash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		expected := base64.RawURLEncoding.EncodeToString(verifierHash[:])
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(authCode.CodeChallenge)) != 1 {
+			sendOIDCError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+			return
+		}
+	}
+
+	u, err := gs.router.getUserByUsername(authCode.Username)
+	if err != nil {
+		sendOIDCError(w, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+		return
+	}
+
+	now := time.Now()
+	issuer := gs.router.oidcIssuer(r)
+	scope := strings.Join(authCode.Scopes, " ")
+	accessToken, err := gs.router.signOIDCToken(jwt.MapClaims{
+		"iss":       issuer,
+		"sub":       u.ID,
+		"aud":       client.ID,
+		"client_id": client.ID,
+		"scope":     scope,
+		"iat":       now.Unix(),
+		"exp":       now.Add(OIDC_TOKEN_TTL).Unix(),
+		"jti":       gs.router.generateSessionToken(),
+	}, OIDC_ACCESS_TOKEN_TYPE)
+	if err != nil {
+		sendOIDCError(w, http.StatusInternalServerError, "server_error", "failed to sign token")
+		return
+	}
+
+	//at_hash is the left half of the SHA256 hash of the access token
+	accessTokenHash := sha256.Sum256([]byte(accessToken))
+	idTokenClaims := gs.router.oidcUserClaims(u, authCode.Scopes)
+	idTokenClaims["iss"] = issuer
+	idTokenClaims["aud"] = client.ID
+	idTokenClaims["azp"] = client.ID
+	idTokenClaims["iat"] = now.Unix()
+	idTokenClaims["exp"] = now.Add(OIDC_TOKEN_TTL).Unix()
+	idTokenClaims["auth_time"] = authCode.AuthTime.Unix()
+	idTokenClaims["at_hash"] = base64.RawURLEncoding.EncodeToString(accessTokenHash[:16])
+	if authCode.Nonce != "" {
+		idTokenClaims["nonce"] = authCode.Nonce
+	}
+	idToken, err := gs.router.signOIDCToken(idTokenClaims, "")
+	if err != nil {
+		sendOIDCError(w, http.StatusInternalServerError, "server_error", "failed to sign token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(OIDC_TOKEN_TTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        scope,
+	})
+}
+
+// handleOIDCUserInfo returns the claims of the user the access token is issued for
+func (gs *GatewayServer) handleOIDCUserInfo(w http.ResponseWriter, r *http.Request) {
+	if gs.ServeGatewayDisabled(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := gs.router.verifyOIDCAccessToken(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+err.Error()+`"`)
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	u, err := gs.router.getUserByID(sub)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="user no longer exists"`)
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	scope, _ := claims["scope"].(string)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(gs.router.oidcUserClaims(u, parseOIDCScopes(scope)))
+}
+
+// verifyOIDCAccessToken verifies the bearer access token issued by the token endpoint
+func (ar *AuthRouter) verifyOIDCAccessToken(r *http.Request) (jwt.MapClaims, error) {
+	rawToken := ""
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+		rawToken = strings.TrimSpace(authHeader[7:])
+	} else if r.Method == http.MethodPost {
+		rawToken = r.PostFormValue("access_token")
+	}
+	if rawToken == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	key, _, err := ar.getOIDCSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errors.New("invalid or expired access token")
+	}
+
+	//ID tokens are signed with the same key, only accept tokens issued as access token
+	if typ, _ := token.Header["typ"].(string); typ != OIDC_ACCESS_TOKEN_TYPE {
+		return nil, errors.New("token is not an access token")
+	}
+	return claims, nil
+}
+
+/* ===================== Helpers ===================== */
+
+// sendOIDCError writes an OAuth 2.0 error response as JSON
+func sendOIDCError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oidcError{
+		Error:       code,
+		Description: description,
+	})
+}
+
+// redirectOIDCError sends the authorization error back to the validated client redirect URI
+func redirectOIDCError(w http.ResponseWriter, r *http.Request, redirectURI string, state string, code string, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, params), http.StatusFound)
+}
+
+// appendQuery adds the parameters to the redirect URI, keeping its existing query
+func appendQuery(redirectURI string, params url.Values) string {
+	if strings.Contains(redirectURI, "?") {
+		return redirectURI + "&" + params.Encode()
+	}
+	return redirectURI + "?" + params.Encode()
+}
+
+// gatewayDirectRedirect returns the gateway local path to redirect to after a direct
+// login on the gateway. "/" goes to the user portal and OIDC authorization requests
+// continue the authorization after login.
+func gatewayDirectRedirect(rawRedirect string) (string, bool) {
+	if rawRedirect == "/" {
+		return "/user", true
+	}
+	if strings.HasPrefix(rawRedirect, OIDC_AUTHORIZE_PATH+"?") {
+		return rawRedirect, true
+	}
+	return "", false
+}
@@ -0,0 +1,257 @@
+package zorxauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+)
+
+// newTestAuthRouter creates an auth router with an empty database and the gateway enabled
+func newTestAuthRouter(t *testing.T) *AuthRouter {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "sys.db"), dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sysdb.Close() })
+	for _, table := range []string{DB_NAME, DB_USERS_TABLE, DB_OIDC_CLIENTS_TABLE, DB_BROWSER_SESSIONS_TABLE, DB_GATEWAY_SESSIONS_TABLE, DB_EMAIL_TOKENS_TABLE} {
+		sysdb.NewTable(table)
+	}
+
+	options := getDefaultOptions()
+	options.EnableAuthGateway = true
+	return &AuthRouter{Database: sysdb, Options: options}
+}
+
+// newTestOIDCProvider starts a gateway with a user alice in group "admins",
+// a confidential client "grafana", a public client "spa" and a client "other"
+// on a host alice cannot access
+func newTestOIDCProvider(t *testing.T) (*AuthRouter, *httptest.Server) {
+	ar := newTestAuthRouter(t)
+	server := httptest.NewServer(NewGatewayServer(ar).mux)
+	t.Cleanup(server.Close)
+	ar.Options.SSORedirectURL = server.URL
+
+	ar.groupPolicies.Store("gp1", &GroupPolicy{ID: "gp1", Name: "admins", AllowedHosts: []string{"app.example.com"}})
+	ar.saveUser(&User{
+		Username:       "alice",
+		Email:          "alice@example.com",
+		PasswordHash:   hashPassword("password"),
+		UseGroupPolicy: true,
+		GroupID:        "gp1",
+	}, "")
+	ar.saveOIDCClient(&OIDCClient{ID: "grafana", SecretHash: hashClientSecret("client-secret"), RedirectURIs: []string{"https://app.example.com/login/generic_oauth"}})
+	ar.saveOIDCClient(&OIDCClient{ID: "spa", Public: true, RedirectURIs: []string{"https://app.example.com/callback?tenant=1"}})
+	ar.saveOIDCClient(&OIDCClient{ID: "other", SecretHash: hashClientSecret("client-secret"), RedirectURIs: []string{"https://other.example.com/callback"}})
+	ar.gatewaySessionStore.Store(sessionKey("alice-session"), &GatewaySession{Username: "alice", Expiry: time.Now().Add(time.Hour)})
+	return ar, server
+}
+
+// authorize sends an authorization request and returns the redirect location
+func authorize(t *testing.T, server *httptest.Server, cookieName string, params url.Values) (int, *url.URL) {
+	req, _ := http.NewRequest(http.MethodGet, server.URL+OIDC_AUTHORIZE_PATH+"?"+params.Encode(), nil)
+	if cookieName != "" {
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: "alice-session"})
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, _ := resp.Location()
+	return resp.StatusCode, location
+}
+
+func exchangeCode(t *testing.T, server *httptest.Server, form url.Values, clientID string, clientSecret string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(http.MethodPost, server.URL+OIDC_TOKEN_PATH, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestOIDCDiscoveryAndLoginRedirect(t *testing.T) {
+	ar, server := newTestOIDCProvider(t)
+
+	resp, err := http.Get(server.URL + OIDC_DISCOVERY_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovery := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&discovery)
+	resp.Body.Close()
+	if discovery["issuer"] != server.URL || discovery["token_endpoint"] != server.URL+OIDC_TOKEN_PATH || discovery["jwks_uri"] != server.URL+OIDC_JWKS_PATH {
+		t.Fatalf("unexpected discovery document: %v", discovery)
+	}
+
+	//Unauthenticated users are sent to the login page and come back to the authorize endpoint
+	params := url.Values{"client_id": {"grafana"}, "redirect_uri": {"https://app.example.com/login/generic_oauth"}, "response_type": {"code"}, "scope": {"openid"}, "state": {"xyz"}}
+	status, location := authorize(t, server, "", params)
+	continueURL := location.Query().Get("redirect")
+	if status != http.StatusFound || location.Path != "/" || !strings.HasPrefix(continueURL, OIDC_AUTHORIZE_PATH+"?") {
+		t.Fatalf("expected redirect to login page, got %d %v", status, location)
+	}
+
+	resp, err = http.PostForm(server.URL+"/login", url.Values{"username": {"alice"}, "password": {"password"}, "redirect": {continueURL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginResult := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&loginResult)
+	resp.Body.Close()
+	if loginResult["success"] != true || loginResult["redirectTarget"] != continueURL || len(resp.Cookies()) == 0 || resp.Cookies()[0].Name != ar.Options.CookieName {
+		t.Errorf("expected login to continue the authorization, got %v", loginResult)
+	}
+
+	//Invalid redirect URI must not be redirected to
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	if status, _ := authorize(t, server, ar.Options.CookieName, params); status != http.StatusBadRequest {
+		t.Errorf("expected unregistered redirect URI to be rejected, got %d", status)
+	}
+}
+
+func TestOIDCAuthorizationErrors(t *testing.T) {
+	ar, server := newTestOIDCProvider(t)
+
+	testcases := []struct {
+		name     string
+		params   url.Values
+		expected string
+	}{
+		{"MissingOpenIDScope", url.Values{"client_id": {"grafana"}, "redirect_uri": {"https://app.example.com/login/generic_oauth"}, "response_type": {"code"}, "scope": {"profile"}}, "invalid_scope"},
+		{"ImplicitFlow", url.Values{"client_id": {"grafana"}, "redirect_uri": {"https://app.example.com/login/generic_oauth"}, "response_type": {"token"}, "scope": {"openid"}}, "unsupported_response_type"},
+		{"PublicClientWithoutPKCE", url.Values{"client_id": {"spa"}, "redirect_uri": {"https://app.example.com/callback?tenant=1"}, "response_type": {"code"}, "scope": {"openid"}}, "invalid_request"},
+		{"PlainPKCE", url.Values{"client_id": {"spa"}, "redirect_uri": {"https://app.example.com/callback?tenant=1"}, "response_type": {"code"}, "scope": {"openid"}, "code_challenge": {"abc"}, "code_challenge_method": {"plain"}}, "invalid_request"},
+		{"HostNotAllowed", url.Values{"client_id": {"other"}, "redirect_uri": {"https://other.example.com/callback"}, "response_type": {"code"}, "scope": {"openid"}}, "access_denied"},
+	}
+	for _, tc := range testcases {
+		tc.params.Set("state", "xyz")
+		status, location := authorize(t, server, ar.Options.CookieName, tc.params)
+		if status != http.StatusFound || location.Query().Get("error") != tc.expected || location.Query().Get("state") != "xyz" {
+			t.Errorf("%s: expected error %s, got %d %v", tc.name, tc.expected, status, location)
+		}
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	ar, server := newTestOIDCProvider(t)
+	redirectURI := "https://app.example.com/login/generic_oauth"
+
+	status, location := authorize(t, server, ar.Options.CookieName, url.Values{
+		"client_id":     {"grafana"},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {"openid profile email groups offline_access"},
+		"state":         {"xyz"},
+		"nonce":         {"n-0S6"},
+	})
+	code := location.Query().Get("code")
+	if status != http.StatusFound || code == "" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("expected authorization code, got %d %v", status, location)
+	}
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}}
+	if status, _ := exchangeCode(t, server, form, "grafana", "wrong-secret"); status != http.StatusUnauthorized {
+		t.Errorf("expected invalid client secret to be rejected, got %d", status)
+	}
+	status, tokens := exchangeCode(t, server, form, "grafana", "client-secret")
+	if status != http.StatusOK || tokens["scope"] != "openid profile email groups" {
+		t.Fatalf("expected token response, got %d %v", status, tokens)
+	}
+
+	//The ID token must be verifiable by a standard relying party using the JWKS endpoint
+	verifier := oidc.NewVerifier(server.URL, "grafana", oidc.NewKeySet(server.URL+OIDC_JWKS_PATH))
+	claims, err := verifier.VerifyIDToken(tokens["id_token"].(string))
+	if err != nil {
+		t.Fatalf("expected valid ID token: %v", err)
+	}
+	user, _ := ar.getUserByUsername("alice")
+	groups, _ := claims["groups"].([]interface{})
+	if claims["sub"] != user.ID || claims["nonce"] != "n-0S6" || claims["email"] != "alice@example.com" || len(groups) != 1 || groups[0] != "admins" {
+		t.Errorf("unexpected ID token claims: %v", claims)
+	}
+
+	//Userinfo accepts the access token but not the ID token
+	for _, tc := range []struct {
+		token    string
+		expected int
+	}{
+		{tokens["access_token"].(string), http.StatusOK},
+		{tokens["id_token"].(string), http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+OIDC_USERINFO_PATH, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userinfo := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&userinfo)
+		resp.Body.Close()
+		if resp.StatusCode != tc.expected {
+			t.Errorf("expected userinfo status %d, got %d", tc.expected, resp.StatusCode)
+		}
+		if tc.expected == http.StatusOK && (userinfo["preferred_username"] != "alice" || userinfo["sub"] != user.ID) {
+			t.Errorf("unexpected userinfo: %v", userinfo)
+		}
+	}
+
+	//Codes are one-time use
+	if status, result := exchangeCode(t, server, form, "grafana", "client-secret"); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+		t.Errorf("expected reused code to be rejected, got %d %v", status, result)
+	}
+}
+
+func TestOIDCPublicClientPKCE(t *testing.T) {
+	ar, server := newTestOIDCProvider(t)
+	redirectURI := "https://app.example.com/callback?tenant=1"
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+
+	requestCode := func() string {
+		status, location := authorize(t, server, ar.Options.CookieName, url.Values{
+			"client_id":             {"spa"},
+			"redirect_uri":          {redirectURI},
+			"response_type":         {"code"},
+			"scope":                 {"openid"},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		})
+		if status != http.StatusFound || location.Query().Get("tenant") != "1" {
+			t.Fatalf("expected redirect with existing query kept, got %d %v", status, location)
+		}
+		return location.Query().Get("code")
+	}
+
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {"spa"}, "code": {requestCode()}, "redirect_uri": {redirectURI}, "code_verifier": {"wrong-verifier"}}
+	if status, result := exchangeCode(t, server, form, "", ""); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+		t.Errorf("expected wrong code verifier to be rejected, got %d %v", status, result)
+	}
+
+	form.Set("code", requestCode())
+	form.Set("code_verifier", verifier)
+	if status, result := exchangeCode(t, server, form, "", ""); status != http.StatusOK || result["id_token"] == nil {
+		t.Errorf("expected token response for valid code verifier, got %d %v", status, result)
+	}
+}
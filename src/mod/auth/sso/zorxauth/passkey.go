@@ -48,7 +48,8 @@ type PendingPasskeyRegistration struct {
 type PendingPasskeyAuth struct {
 	SessionData    *webauthnlib.SessionData
 	RedirectTarget string
-	IsDirectLogin  bool // true when no redirect target was provided (direct gateway access)
+	IsDirectLogin  bool   // true when no redirect target was provided (direct gateway access)
+	DirectTarget   string // gateway local path to redirect to after a direct login
 	Expiry         time.Time
 }
 
@@ -212,6 +213,7 @@ func (gs *GatewayServer) handlePasskeyRegisterComplete(w http.ResponseWriter, r
 		utils.SendErrorResponse(w, "failed to save credential: "+err.Error())
 		return
 	}
+	gs.router.notifyPasskeyRegistered(u, credName, r)
 
 	utils.SendOK(w)
 }
@@ -319,13 +321,13 @@ func (gs *GatewayServer) handlePasskeyAuthBegin(w http.ResponseWriter, r *http.R
 
 	rawRedirect := r.FormValue("redirect")
 	redirectTarget := rawRedirect
-	isDirectLogin := redirectTarget == "/"
-	if redirectTarget == "/" {
+	directTarget, isDirectLogin := gatewayDirectRedirect(rawRedirect)
+	if isDirectLogin {
 		protocolScheme := "http"
 		if r.URL.Scheme != "" {
 			protocolScheme = r.URL.Scheme
 		}
-		redirectTarget = protocolScheme + "://" + r.Host + "/user"
+		redirectTarget = protocolScheme + "://" + r.Host + directTarget
 	}
 
 	wa, err := newWebAuthnFromRequest(r)
@@ -345,6 +347,7 @@ func (gs *GatewayServer) handlePasskeyAuthBegin(w http.ResponseWriter, r *http.R
 		SessionData:    sessionData,
 		RedirectTarget: redirectTarget,
 		IsDirectLogin:  isDirectLogin,
+		DirectTarget:   directTarget,
 		Expiry:         time.Now().Add(5 * time.Minute),
 	})
 	time.AfterFunc(5*time.Minute, func() { gs.router.pendingPasskeyAuth.Delete(token) })
@@ -434,6 +437,18 @@ func (gs *GatewayServer) handlePasskeyAuthComplete(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// Directory users must still exist in the directory, this also refreshes their group policy
+	foundUser, err = gs.router.refreshDirectoryUser(foundUser)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Account is not active in the directory",
+		})
+		return
+	}
+
 	// Update sign count and last-used timestamp in storage.
 	for i, c := range foundUser.PasskeyCredentials {
 		if bytes.Equal(c.ID, credential.ID) {
@@ -465,7 +480,7 @@ func (gs *GatewayServer) handlePasskeyAuthComplete(w http.ResponseWriter, r *htt
 		targetProtocol = "http"
 	}
 
-	if !gs.router.ValidateUserAccessToHost(foundUser.Username, host) && r.Host != host {
+	if !gs.router.ValidateUserAccessToHost(foundUser.Username, host) && hostnameOnly(r.Host) != host {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -479,15 +494,7 @@ func (gs *GatewayServer) handlePasskeyAuthComplete(w http.ResponseWriter, r *htt
 	cookieDuration := gs.router.Options.CookieDuration
 	isSecure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
 
-	sessionToken := gs.router.generateSessionToken()
-	expiryTime := time.Now().Add(time.Duration(cookieDuration) * time.Second)
-	gs.router.gatewaySessionStore.Store(sessionToken, &GatewaySession{
-		Username: foundUser.Username,
-		Expiry:   expiryTime,
-	})
-	time.AfterFunc(time.Duration(cookieDuration)*time.Second, func() {
-		gs.router.gatewaySessionStore.Delete(sessionToken)
-	})
+	sessionToken := gs.router.newGatewaySession(foundUser.Username, r, cookieDuration)
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     gs.router.Options.CookieName,
@@ -506,12 +513,12 @@ func (gs *GatewayServer) handlePasskeyAuthComplete(w http.ResponseWriter, r *htt
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":        true,
-			"redirectTarget": "/user",
+			"redirectTarget": pending.DirectTarget,
 		})
 		return
 	}
 
-	sessionId := gs.router.generateValidationCodeForSession(foundUser.Username)
+	sessionId := gs.router.generateValidationCodeForSession(foundUser.Username, sessionToken)
 	hostWithPort := host
 	if port != "" {
 		hostWithPort = host + ":" + port
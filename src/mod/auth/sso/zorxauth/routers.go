@@ -21,24 +21,9 @@ func (ar *AuthRouter) RequestIsAuthenticatedInHost(w http.ResponseWriter, r *htt
 		return false
 	}
 
-	// Check if session exists in store
-	sessionData, exists := ar.cookieIdStore.Load(cookie.Value)
-	if !exists {
-		return false
-	}
-
-	// Check if session has expired
-	browserSession, ok := sessionData.(*BrowserSession)
-	if !ok {
-		return false
-	}
-
-	if time.Now().After(browserSession.Expiry) {
-		ar.cookieIdStore.Delete(cookie.Value)
-		return false
-	}
-
-	return true
+	// Check if session exists in store and has not expired
+	_, exists := ar.getBrowserSession(cookie.Value)
+	return exists
 }
 
 func (ar *AuthRouter) RequestIsAuthenticatedInSSO(w http.ResponseWriter, r *http.Request) (bool, string) {
@@ -48,47 +33,12 @@ func (ar *AuthRouter) RequestIsAuthenticatedInSSO(w http.ResponseWriter, r *http
 		return false, ""
 	}
 
-	sessionData, exists := ar.gatewaySessionStore.Load(cookie.Value)
+	// Expired gateway sessions are revoked together with the site sessions issued from them
+	gatewaySession, exists := ar.getGatewaySession(cookie.Value)
 	if !exists {
 		return false, ""
 	}
 
-	gatewaySession, ok := sessionData.(*GatewaySession)
-	if !ok {
-		return false, ""
-	}
-
-	// Check if session has expired
-	if time.Now().After(gatewaySession.Expiry) {
-		username := gatewaySession.Username
-
-		// Delete the expired gateway session
-		ar.gatewaySessionStore.Delete(cookie.Value)
-
-		// Clear all browser sessions (cookieIdStore) for this user
-		// When the SSO portal session expires, all site-specific sessions should also be invalidated
-		ar.cookieIdStore.Range(func(key, value interface{}) bool {
-			sessionID, ok := key.(string)
-			if !ok {
-				return true // continue iteration
-			}
-
-			browserSession, ok := value.(*BrowserSession)
-			if !ok {
-				return true // continue iteration
-			}
-
-			// If this browser session belongs to the expired user, delete it
-			if browserSession.Username == username {
-				ar.cookieIdStore.Delete(sessionID)
-			}
-
-			return true // continue iteration
-		})
-
-		return false, ""
-	}
-
 	return true, gatewaySession.Username
 }
 
@@ -120,7 +70,7 @@ func (ar *AuthRouter) handleSessionSetCallback(w http.ResponseWriter, r *http.Re
 		redirectURL = "/" // default to home page if redirect URL is missing
 	}
 
-	usernameObj, exists := ar.sessionIdStore.Load(sessionID)
+	codeObj, exists := ar.sessionIdStore.Load(sessionID)
 	if !exists {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusUnauthorized)
@@ -128,8 +78,8 @@ func (ar *AuthRouter) handleSessionSetCallback(w http.ResponseWriter, r *http.Re
 		return errors.New("validation session not found or expired")
 	}
 
-	username, ok := usernameObj.(string)
-	if !ok || username == "" {
+	code, ok := codeObj.(*validationCode)
+	if !ok || code.Username == "" {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write(invalidHTML)
@@ -140,7 +90,6 @@ func (ar *AuthRouter) handleSessionSetCallback(w http.ResponseWriter, r *http.Re
 	ar.sessionIdStore.Delete(sessionID)
 
 	// Generate long living session cookie for browser with the username and expiry time
-	browserSessionID := ar.generateSessionToken()
 	rememberMe := strings.EqualFold(r.URL.Query().Get("remember_me"), "true") || r.URL.Query().Get("remember_me") == "on"
 	cookieDuration := ar.Options.CookieDuration
 	if rememberMe {
@@ -154,20 +103,8 @@ func (ar *AuthRouter) handleSessionSetCallback(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	// Create browser session with expiry time
-	expiryTime := time.Now().Add(time.Duration(cookieDuration) * time.Second)
-	browserSession := &BrowserSession{
-		Username: username,
-		Expiry:   expiryTime,
-	}
-	ar.cookieIdStore.Store(browserSessionID, browserSession)
-
-	// Set up cleanup timer for when session expires
-	if cookieDuration > 0 {
-		time.AfterFunc(time.Duration(cookieDuration)*time.Second, func() {
-			ar.cookieIdStore.Delete(browserSessionID)
-		})
-	}
+	// Create browser session with expiry time, linked to the gateway session that issued it
+	browserSessionID := ar.newBrowserSession(code.Username, code.GatewaySession, r, cookieDuration)
 
 	isSecure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
 	http.SetCookie(w, &http.Cookie{
@@ -0,0 +1,495 @@
+package zorxauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/netutils"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	sessions.go
+
+	Session store of ZorxAuth. Gateway sessions are the logins of
+	the SSO portal and browser sessions are the per-site sessions
+	issued from them. Sessions are written through to the database
+	so they survive restarts, and can be listed and revoked one by one.
+
+	Sessions are keyed by the SHA-256 of their token, in memory and in
+	the database, so the token itself is never stored.
+*/
+
+const (
+	SESSION_ACTIVITY_UPDATE_INTERVAL = time.Minute //Minimum interval between last activity updates of a session
+	SESSION_TYPE_GATEWAY             = "gateway"
+	SESSION_TYPE_BROWSER             = "browser"
+)
+
+// SessionInfo is the session details returned by the session list API, the
+// session token is never returned
+type SessionInfo struct {
+	ID             string `json:"id"` //Public ID of the session
+	Type           string `json:"type"`
+	Username       string `json:"username"`
+	GatewaySession string `json:"gatewaySession,omitempty"` //Public ID of the issuing gateway session, browser sessions only
+	Host           string `json:"host,omitempty"`
+	IP             string `json:"ip"`
+	Device         string `json:"device"`
+	UserAgent      string `json:"userAgent"`
+	CreatedAt      int64  `json:"createdAt"`
+	LastActivity   int64  `json:"lastActivity"`
+	Expiry         int64  `json:"expiry"`
+}
+
+// sessionKey returns the key the session of the token is stored under
+func sessionKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// sessionPublicID returns the ID of a session that can be shown to admins without leaking the token
+func sessionPublicID(token string) string {
+	return sessionKeyPublicID(sessionKey(token))
+}
+
+// sessionKeyPublicID returns the public ID of the session stored under key
+func sessionKeyPublicID(key string) string {
+	if len(key) < 16 {
+		return key
+	}
+	return key[:16]
+}
+
+// sessionExpired checks the expiry, absolute lifetime and idle timeout of a session
+func (ar *AuthRouter) sessionExpired(createdAt time.Time, lastActivity time.Time, expiry time.Time) bool {
+	now := time.Now()
+	if now.After(expiry) {
+		return true
+	}
+	if ar.Options.SessionMaxLifetime > 0 && !createdAt.IsZero() && now.After(createdAt.Add(time.Duration(ar.Options.SessionMaxLifetime)*time.Second)) {
+		return true
+	}
+	if ar.Options.SessionIdleTimeout > 0 && !lastActivity.IsZero() && now.After(lastActivity.Add(time.Duration(ar.Options.SessionIdleTimeout)*time.Second)) {
+		return true
+	}
+	return false
+}
+
+/* ===================== Session Storage ===================== */
+
+func (ar *AuthRouter) storeBrowserSession(key string, session *BrowserSession) {
+	ar.cookieIdStore.Store(key, session)
+	if ar.Database == nil {
+		return
+	}
+	err := ar.Database.Write(DB_BROWSER_SESSIONS_TABLE, DB_BROWSER_SESSION_KEY_PREFIX+key, session)
+	if err != nil && ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Failed to save browser session: "+err.Error(), err)
+	}
+}
+
+func (ar *AuthRouter) deleteBrowserSession(key string) {
+	ar.cookieIdStore.Delete(key)
+	if ar.Database != nil {
+		ar.Database.Delete(DB_BROWSER_SESSIONS_TABLE, DB_BROWSER_SESSION_KEY_PREFIX+key)
+	}
+}
+
+func (ar *AuthRouter) storeGatewaySession(key string, session *GatewaySession) {
+	ar.gatewaySessionStore.Store(key, session)
+	if ar.Database == nil {
+		return
+	}
+	err := ar.Database.Write(DB_GATEWAY_SESSIONS_TABLE, DB_GATEWAY_SESSION_KEY_PREFIX+key, session)
+	if err != nil && ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Failed to save gateway session: "+err.Error(), err)
+	}
+}
+
+func (ar *AuthRouter) deleteGatewaySession(key string) {
+	ar.gatewaySessionStore.Delete(key)
+	if ar.Database != nil {
+		ar.Database.Delete(DB_GATEWAY_SESSIONS_TABLE, DB_GATEWAY_SESSION_KEY_PREFIX+key)
+	}
+}
+
+// newGatewaySession creates a login session of the SSO portal and returns its token.
+// The oldest sessions of the user are ended if the group policy session limit is exceeded
+func (ar *AuthRouter) newGatewaySession(username string, r *http.Request, duration int) string {
+	token := ar.generateSessionToken()
+	key := sessionKey(token)
+	now := time.Now()
+	ar.storeGatewaySession(key, &GatewaySession{
+		Username:     username,
+		Expiry:       now.Add(time.Duration(duration) * time.Second),
+		IP:           netutils.GetRequesterIP(r),
+		UserAgent:    r.UserAgent(),
+		CreatedAt:    now,
+		LastActivity: now,
+	})
+	ar.enforceSessionLimit(username, key)
+	return token
+}
+
+// newBrowserSession creates a session of a protected site issued from a gateway session and returns its token
+func (ar *AuthRouter) newBrowserSession(username string, gatewaySessionID string, r *http.Request, duration int) string {
+	token := ar.generateSessionToken()
+	now := time.Now()
+	ar.storeBrowserSession(sessionKey(token), &BrowserSession{
+		Username:       username,
+		Expiry:         now.Add(time.Duration(duration) * time.Second),
+		GatewaySession: gatewaySessionID,
+		Host:           hostnameOnly(r.Host),
+		IP:             netutils.GetRequesterIP(r),
+		UserAgent:      r.UserAgent(),
+		CreatedAt:      now,
+		LastActivity:   now,
+	})
+	return token
+}
+
+// getBrowserSession returns a valid browser session and updates its last activity,
+// expired sessions are removed
+func (ar *AuthRouter) getBrowserSession(token string) (*BrowserSession, bool) {
+	key := sessionKey(token)
+	sessionData, exists := ar.cookieIdStore.Load(key)
+	if !exists {
+		return nil, false
+	}
+	session, ok := sessionData.(*BrowserSession)
+	if !ok || session == nil {
+		return nil, false
+	}
+	if ar.sessionExpired(session.CreatedAt, session.LastActivity, session.Expiry) {
+		ar.deleteBrowserSession(key)
+		return nil, false
+	}
+	if time.Since(session.LastActivity) >= SESSION_ACTIVITY_UPDATE_INTERVAL {
+		//Sessions are shared between requests, store an updated copy instead of editing in place
+		updated := *session
+		updated.LastActivity = time.Now()
+		ar.storeBrowserSession(key, &updated)
+		session = &updated
+	}
+	return session, true
+}
+
+// getGatewaySession returns a valid gateway session and updates its last activity,
+// expired sessions are revoked together with the browser sessions issued from them
+func (ar *AuthRouter) getGatewaySession(token string) (*GatewaySession, bool) {
+	key := sessionKey(token)
+	sessionData, exists := ar.gatewaySessionStore.Load(key)
+	if !exists {
+		return nil, false
+	}
+	session, ok := sessionData.(*GatewaySession)
+	if !ok || session == nil {
+		return nil, false
+	}
+	if ar.sessionExpired(session.CreatedAt, session.LastActivity, session.Expiry) {
+		ar.revokeGatewaySession(key)
+		return nil, false
+	}
+	if time.Since(session.LastActivity) >= SESSION_ACTIVITY_UPDATE_INTERVAL {
+		updated := *session
+		updated.LastActivity = time.Now()
+		ar.storeGatewaySession(key, &updated)
+		session = &updated
+	}
+	return session, true
+}
+
+// renewGatewaySession extends the expiry of an actively used gateway session.
+// The absolute lifetime still applies to renewed sessions
+func (ar *AuthRouter) renewGatewaySession(token string) {
+	session, ok := ar.getGatewaySession(token)
+	if !ok {
+		return
+	}
+	newExpiry := time.Now().Add(time.Duration(ar.Options.CookieDuration) * time.Second)
+	if !newExpiry.After(session.Expiry) {
+		//Do not shorten remember me sessions
+		return
+	}
+	updated := *session
+	updated.Expiry = newExpiry
+	updated.LastActivity = time.Now()
+	ar.storeGatewaySession(sessionKey(token), &updated)
+}
+
+// revokeGatewaySession ends the gateway session stored under key and the browser sessions issued from it
+func (ar *AuthRouter) revokeGatewaySession(key string) {
+	ar.deleteGatewaySession(key)
+	publicID := sessionKeyPublicID(key)
+	ar.cookieIdStore.Range(func(key, value interface{}) bool {
+		browserSession, ok := value.(*BrowserSession)
+		if ok && browserSession.GatewaySession == publicID {
+			ar.deleteBrowserSession(key.(string))
+		}
+		return true
+	})
+}
+
+// revokeUserBrowserSessions ends all browser sessions of the user
+func (ar *AuthRouter) revokeUserBrowserSessions(username string) int {
+	count := 0
+	ar.cookieIdStore.Range(func(key, value interface{}) bool {
+		browserSession, ok := value.(*BrowserSession)
+		if ok && strings.EqualFold(browserSession.Username, username) {
+			ar.deleteBrowserSession(key.(string))
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// RevokeUserSessions ends all gateway and browser sessions of the user, return the number of sessions ended
+func (ar *AuthRouter) RevokeUserSessions(username string) int {
+	count := 0
+	ar.gatewaySessionStore.Range(func(key, value interface{}) bool {
+		gatewaySession, ok := value.(*GatewaySession)
+		if ok && strings.EqualFold(gatewaySession.Username, username) {
+			ar.deleteGatewaySession(key.(string))
+			count++
+		}
+		return true
+	})
+	return count + ar.revokeUserBrowserSessions(username)
+}
+
+// RevokeSession ends the session with the given public ID. Revoking a gateway
+// session also ends the browser sessions issued from it
+func (ar *AuthRouter) RevokeSession(id string) bool {
+	found := false
+	ar.gatewaySessionStore.Range(func(key, value interface{}) bool {
+		if sessionKeyPublicID(key.(string)) == id {
+			ar.revokeGatewaySession(key.(string))
+			found = true
+			return false
+		}
+		return true
+	})
+	if found {
+		return true
+	}
+	ar.cookieIdStore.Range(func(key, value interface{}) bool {
+		if sessionKeyPublicID(key.(string)) == id {
+			ar.deleteBrowserSession(key.(string))
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// enforceSessionLimit ends the least recently used gateway sessions of the user
+// when the group policy session limit is exceeded, the session stored under keepKey is kept
+func (ar *AuthRouter) enforceSessionLimit(username string, keepKey string) {
+	user, err := ar.getUserByUsername(username)
+	if err != nil || !user.UseGroupPolicy || user.GroupID == "" {
+		return
+	}
+	gp, err := ar.GetGroupPolicyByID(user.GroupID)
+	if err != nil || gp.MaxSessions <= 0 {
+		return
+	}
+
+	type userSession struct {
+		key          string
+		lastActivity time.Time
+	}
+	otherSessions := []*userSession{}
+	ar.gatewaySessionStore.Range(func(key, value interface{}) bool {
+		gatewaySession, ok := value.(*GatewaySession)
+		if ok && key.(string) != keepKey && strings.EqualFold(gatewaySession.Username, username) &&
+			!ar.sessionExpired(gatewaySession.CreatedAt, gatewaySession.LastActivity, gatewaySession.Expiry) {
+			otherSessions = append(otherSessions, &userSession{key: key.(string), lastActivity: gatewaySession.LastActivity})
+		}
+		return true
+	})
+
+	exceeded := len(otherSessions) + 1 - gp.MaxSessions
+	if exceeded <= 0 {
+		return
+	}
+	sort.Slice(otherSessions, func(i, j int) bool {
+		return otherSessions[i].lastActivity.Before(otherSessions[j].lastActivity)
+	})
+	for i := 0; i < exceeded; i++ {
+		ar.revokeGatewaySession(otherSessions[i].key)
+	}
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Ended "+strconv.Itoa(exceeded)+" oldest session(s) of "+username+" due to the session limit of group "+gp.Name, nil)
+	}
+}
+
+// ListSessions returns the active sessions of the user, or all users if username is empty, most recently used first
+func (ar *AuthRouter) ListSessions(username string) []*SessionInfo {
+	results := []*SessionInfo{}
+	ar.gatewaySessionStore.Range(func(key, value interface{}) bool {
+		s, ok := value.(*GatewaySession)
+		if !ok || (username != "" && !strings.EqualFold(s.Username, username)) || ar.sessionExpired(s.CreatedAt, s.LastActivity, s.Expiry) {
+			return true
+		}
+		results = append(results, &SessionInfo{
+			ID:           sessionKeyPublicID(key.(string)),
+			Type:         SESSION_TYPE_GATEWAY,
+			Username:     s.Username,
+			IP:           s.IP,
+			Device:       describeUserAgent(s.UserAgent),
+			UserAgent:    s.UserAgent,
+			CreatedAt:    s.CreatedAt.Unix(),
+			LastActivity: s.LastActivity.Unix(),
+			Expiry:       s.Expiry.Unix(),
+		})
+		return true
+	})
+	ar.cookieIdStore.Range(func(key, value interface{}) bool {
+		s, ok := value.(*BrowserSession)
+		if !ok || (username != "" && !strings.EqualFold(s.Username, username)) || ar.sessionExpired(s.CreatedAt, s.LastActivity, s.Expiry) {
+			return true
+		}
+		results = append(results, &SessionInfo{
+			ID:             sessionKeyPublicID(key.(string)),
+			Type:           SESSION_TYPE_BROWSER,
+			Username:       s.Username,
+			GatewaySession: s.GatewaySession,
+			Host:           s.Host,
+			IP:             s.IP,
+			Device:         describeUserAgent(s.UserAgent),
+			UserAgent:      s.UserAgent,
+			CreatedAt:      s.CreatedAt.Unix(),
+			LastActivity:   s.LastActivity.Unix(),
+			Expiry:         s.Expiry.Unix(),
+		})
+		return true
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].LastActivity == results[j].LastActivity {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].LastActivity > results[j].LastActivity
+	})
+	return results
+}
+
+// cleanupExpiredSessions removes expired sessions from memory and database
+func (ar *AuthRouter) cleanupExpiredSessions() {
+	ar.gatewaySessionStore.Range(func(key, value interface{}) bool {
+		s, ok := value.(*GatewaySession)
+		if ok && ar.sessionExpired(s.CreatedAt, s.LastActivity, s.Expiry) {
+			ar.revokeGatewaySession(key.(string))
+		}
+		return true
+	})
+	ar.cookieIdStore.Range(func(key, value interface{}) bool {
+		s, ok := value.(*BrowserSession)
+		if ok && ar.sessionExpired(s.CreatedAt, s.LastActivity, s.Expiry) {
+			ar.deleteBrowserSession(key.(string))
+		}
+		return true
+	})
+}
+
+// startSessionCleanupTicker removes expired sessions every minute
+func (ar *AuthRouter) startSessionCleanupTicker() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ar.sessionCleanupStop:
+			return
+		case <-ticker.C:
+			ar.cleanupExpiredSessions()
+		}
+	}
+}
+
+// describeUserAgent returns a short browser and OS description of the user agent, e.g. Firefox on Windows
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleSessionList returns the active sessions, optionally filtered by GET username
+func (ar *AuthRouter) HandleSessionList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+	username, _ := utils.GetPara(r, "username")
+	js, _ := json.Marshal(ar.ListSessions(normalizeUsername(username)))
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleSessionRevoke ends a single session by POST id, or all sessions of POST username
+func (ar *AuthRouter) HandleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "method not allowed")
+		return
+	}
+
+	if id, err := utils.PostPara(r, "id"); err == nil {
+		if !ar.RevokeSession(strings.TrimSpace(id)) {
+			utils.SendErrorResponse(w, "session not found")
+			return
+		}
+		if ar.Logger != nil {
+			ar.Logger.PrintAndLog("zorxauth", "Session "+id+" revoked", nil)
+		}
+		utils.SendOK(w)
+		return
+	}
+
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		utils.SendErrorResponse(w, "session id or username is required")
+		return
+	}
+	count := ar.RevokeUserSessions(normalizeUsername(username))
+	if ar.Logger != nil {
+		ar.Logger.PrintAndLog("zorxauth", "Revoked "+strconv.Itoa(count)+" session(s) of "+username, nil)
+	}
+	utils.SendOK(w)
+}
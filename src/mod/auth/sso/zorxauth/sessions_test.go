@@ -0,0 +1,146 @@
+package zorxauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSessionRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	return req
+}
+
+func TestSessionPersistence(t *testing.T) {
+	ar := newTestAuthRouter(t)
+	gatewayToken := ar.newGatewaySession("alice", newTestSessionRequest(), 3600)
+	browserToken := ar.newBrowserSession("alice", sessionPublicID(gatewayToken), newTestSessionRequest(), 3600)
+
+	//Sessions survive a restart of the router
+	restarted := &AuthRouter{Database: ar.Database, Options: ar.Options}
+	restarted.loadGatewaySessions()
+	restarted.loadBrowserSessions()
+	if _, ok := restarted.getGatewaySession(gatewayToken); !ok {
+		t.Fatal("expected gateway session to be loaded from database")
+	}
+	session, ok := restarted.getBrowserSession(browserToken)
+	if !ok || session.Host != "app.example.com" || session.UserAgent == "" {
+		t.Fatalf("expected browser session to be loaded from database: %+v", session)
+	}
+
+	sessions := restarted.ListSessions("alice")
+	if len(sessions) != 2 || sessions[0].Device == "" {
+		t.Fatalf("expected 2 listed sessions, got %+v", sessions)
+	}
+	for _, info := range sessions {
+		if info.ID == gatewayToken || info.ID == browserToken {
+			t.Error("session list must not expose session tokens")
+		}
+	}
+
+	//Revoking the gateway session ends the site sessions issued from it
+	if !restarted.RevokeSession(sessionPublicID(gatewayToken)) {
+		t.Fatal("expected session to be revoked")
+	}
+	if _, ok := restarted.getBrowserSession(browserToken); ok {
+		t.Error("expected browser session to be revoked with its gateway session")
+	}
+	if ar.Database.KeyExists(DB_GATEWAY_SESSIONS_TABLE, DB_GATEWAY_SESSION_KEY_PREFIX+sessionKey(gatewayToken)) {
+		t.Error("expected revoked session to be removed from database")
+	}
+}
+
+func TestSessionTokensNotStored(t *testing.T) {
+	ar := newTestAuthRouter(t)
+	gatewayToken := ar.newGatewaySession("alice", newTestSessionRequest(), 3600)
+	browserToken := ar.newBrowserSession("alice", sessionPublicID(gatewayToken), newTestSessionRequest(), 3600)
+
+	for table, token := range map[string]string{DB_GATEWAY_SESSIONS_TABLE: gatewayToken, DB_BROWSER_SESSIONS_TABLE: browserToken} {
+		entries, _ := ar.Database.ListTable(table)
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 session in %s, got %d", table, len(entries))
+		}
+		if strings.Contains(string(entries[0][0]), token) {
+			t.Errorf("session token stored as database key in %s", table)
+		}
+	}
+
+	//Sessions saved under the raw token by older versions are moved to the hashed key
+	legacyToken := ar.generateSessionToken()
+	ar.Database.Write(DB_GATEWAY_SESSIONS_TABLE, DB_LEGACY_GATEWAY_SESSION_KEY_PREFIX+legacyToken, &GatewaySession{
+		Username: "bob",
+		Expiry:   time.Now().Add(time.Hour),
+	})
+	restarted := &AuthRouter{Database: ar.Database, Options: ar.Options}
+	restarted.loadGatewaySessions()
+	if session, ok := restarted.getGatewaySession(legacyToken); !ok || session.Username != "bob" {
+		t.Fatal("expected legacy session to be loaded")
+	}
+	if ar.Database.KeyExists(DB_GATEWAY_SESSIONS_TABLE, DB_LEGACY_GATEWAY_SESSION_KEY_PREFIX+legacyToken) ||
+		!ar.Database.KeyExists(DB_GATEWAY_SESSIONS_TABLE, DB_GATEWAY_SESSION_KEY_PREFIX+sessionKey(legacyToken)) {
+		t.Error("expected legacy session to be moved to the hashed key")
+	}
+}
+
+func TestSessionIdleTimeoutAndLifetime(t *testing.T) {
+	ar := newTestAuthRouter(t)
+	ar.Options.SessionIdleTimeout = 600
+	ar.Options.SessionMaxLifetime = 3600
+	now := time.Now()
+
+	testcases := []struct {
+		name         string
+		createdAt    time.Time
+		lastActivity time.Time
+		expired      bool
+	}{
+		{"Active", now.Add(-30 * time.Minute), now.Add(-time.Minute), false},
+		{"Idle", now.Add(-30 * time.Minute), now.Add(-15 * time.Minute), true},
+		{"LifetimeExceeded", now.Add(-2 * time.Hour), now.Add(-time.Minute), true},
+	}
+	for _, tc := range testcases {
+		token := ar.generateSessionToken()
+		ar.storeGatewaySession(sessionKey(token), &GatewaySession{
+			Username:     "alice",
+			Expiry:       now.Add(24 * time.Hour),
+			CreatedAt:    tc.createdAt,
+			LastActivity: tc.lastActivity,
+		})
+		if _, ok := ar.getGatewaySession(token); ok == tc.expired {
+			t.Errorf("%s: expected expired = %v", tc.name, tc.expired)
+		}
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	ar := newTestAuthRouter(t)
+	ar.groupPolicies.Store("gp1", &GroupPolicy{ID: "gp1", Name: "staff", MaxSessions: 2})
+	ar.saveUser(&User{Username: "alice", PasswordHash: hashPassword("password"), UseGroupPolicy: true, GroupID: "gp1"}, "")
+
+	oldest := ar.newGatewaySession("alice", newTestSessionRequest(), 3600)
+	oldestBrowser := ar.newBrowserSession("alice", sessionPublicID(oldest), newTestSessionRequest(), 3600)
+	//Mark the first session as least recently used
+	session, _ := ar.gatewaySessionStore.Load(sessionKey(oldest))
+	session.(*GatewaySession).LastActivity = time.Now().Add(-10 * time.Minute)
+	second := ar.newGatewaySession("alice", newTestSessionRequest(), 3600)
+	third := ar.newGatewaySession("alice", newTestSessionRequest(), 3600)
+
+	if _, ok := ar.getGatewaySession(oldest); ok {
+		t.Error("expected least recently used session to be ended")
+	}
+	if _, ok := ar.getBrowserSession(oldestBrowser); ok {
+		t.Error("expected site sessions of the ended login to be revoked")
+	}
+	for _, token := range []string{second, third} {
+		if _, ok := ar.getGatewaySession(token); !ok {
+			t.Error("expected newer sessions to be kept")
+		}
+	}
+
+	if count := ar.RevokeUserSessions("alice"); count != 2 || len(ar.ListSessions("alice")) != 0 {
+		t.Errorf("expected all sessions of user to be revoked, got %d", count)
+	}
+}
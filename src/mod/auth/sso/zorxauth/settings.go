@@ -34,6 +34,8 @@ func (ar *AuthRouter) handleSettingsGET(w http.ResponseWriter, r *http.Request)
 		"cookieName":               ar.Options.CookieName,
 		"cookieDuration":           ar.Options.CookieDuration,
 		"cookieDurationRememberMe": cookieDurationRememberMe,
+		"sessionIdleTimeout":       ar.Options.SessionIdleTimeout,
+		"sessionMaxLifetime":       ar.Options.SessionMaxLifetime,
 		"enableRateLimit":          ar.Options.EnableRateLimit,
 		"rateLimitPerIp":           ar.Options.RateLimitPerIp,
 		"useExpotentialBackoff":    ar.Options.UseExpotentialBackoff,
@@ -83,6 +85,14 @@ func (ar *AuthRouter) handleSettingsPOST(w http.ResponseWriter, r *http.Request)
 		cookieDurationRememberMe = getDefaultOptions().CookieDurationRememberMe
 	}
 
+	// Session timeouts, 0 to disable
+	sessionIdleTimeout, _ := utils.PostInt(r, "sessionIdleTimeout")
+	sessionMaxLifetime, _ := utils.PostInt(r, "sessionMaxLifetime")
+	if sessionIdleTimeout < 0 || sessionMaxLifetime < 0 {
+		utils.SendErrorResponse(w, "Session timeouts cannot be negative")
+		return
+	}
+
 	// Rate limiting settings
 	enableRateLimit, _ := utils.PostBool(r, "enableRateLimit")
 	rateLimitPerIp, _ := utils.PostInt(r, "rateLimitPerIp")
@@ -97,6 +107,8 @@ func (ar *AuthRouter) handleSettingsPOST(w http.ResponseWriter, r *http.Request)
 	ar.Options.CookieName = cookieName
 	ar.Options.CookieDuration = cookieDuration
 	ar.Options.CookieDurationRememberMe = cookieDurationRememberMe
+	ar.Options.SessionIdleTimeout = sessionIdleTimeout
+	ar.Options.SessionMaxLifetime = sessionMaxLifetime
 	ar.Options.EnableRateLimit = enableRateLimit
 	ar.Options.RateLimitPerIp = rateLimitPerIp
 	ar.Options.UseExpotentialBackoff = useExpotentialBackoff
@@ -120,6 +132,8 @@ func (ar *AuthRouter) handleSettingsDELETE(w http.ResponseWriter, r *http.Reques
 	ar.Options.CookieName = defaultOpts.CookieName
 	ar.Options.CookieDuration = defaultOpts.CookieDuration
 	ar.Options.CookieDurationRememberMe = defaultOpts.CookieDurationRememberMe
+	ar.Options.SessionIdleTimeout = defaultOpts.SessionIdleTimeout
+	ar.Options.SessionMaxLifetime = defaultOpts.SessionMaxLifetime
 	ar.Options.EnableRateLimit = defaultOpts.EnableRateLimit
 	ar.Options.RateLimitPerIp = defaultOpts.RateLimitPerIp
 	ar.Options.UseExpotentialBackoff = defaultOpts.UseExpotentialBackoff
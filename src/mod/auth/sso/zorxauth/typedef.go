@@ -1,6 +1,7 @@
 package zorxauth
 
 import (
+	"crypto/rsa"
 	"sync"
 	"time"
 
@@ -13,9 +14,13 @@ const (
 	DB_USERS_TABLE                = "zorxauth_users"
 	DB_USERS_KEY_PREFIX           = "user_"
 	DB_BROWSER_SESSIONS_TABLE     = "zorxauth_browser_sessions"
-	DB_BROWSER_SESSION_KEY_PREFIX = "session_"
+	DB_BROWSER_SESSION_KEY_PREFIX = "sessionhash_" //Followed by the SHA-256 of the session token
 	DB_GATEWAY_SESSIONS_TABLE     = "zorxauth_gateway_sessions"
-	DB_GATEWAY_SESSION_KEY_PREFIX = "gateway_"
+	DB_GATEWAY_SESSION_KEY_PREFIX = "gatewayhash_" //Followed by the SHA-256 of the session token
+
+	//Sessions saved by older versions are keyed by the raw token and are moved to the hashed key on load
+	DB_LEGACY_BROWSER_SESSION_KEY_PREFIX = "session_"
+	DB_LEGACY_GATEWAY_SESSION_KEY_PREFIX = "gateway_"
 )
 
 type User struct {
@@ -34,6 +39,10 @@ type User struct {
 	/* Passkeys (WebAuthn) */
 	PasskeyCredentials []PasskeyCredential `json:"passkeyCredentials"` //Registered WebAuthn/passkey credentials
 
+	/* Directory */
+	Source      string `json:"source"`      //Where the user is from, empty for local users or "ldap" for directory users
+	DirectoryDN string `json:"directoryDN"` //DN of the directory entry, only for directory users
+
 }
 
 // PasskeyCredential stores a single WebAuthn credential for a user.
@@ -66,18 +75,38 @@ type AuthRouterOptions struct {
 	CookieName               string `json:"cookie_name"`                 //Name of the session cookie
 	CookieDuration           int    `json:"cookie_duration"`             //Duration in seconds for the session cookie
 	CookieDurationRememberMe int    `json:"cookie_duration_remember_me"` //Duration in seconds for the session cookie when "Remember Me" is selected
+	SessionIdleTimeout       int    `json:"session_idle_timeout"`        //Seconds of inactivity before a session is ended, 0 to disable
+	SessionMaxLifetime       int    `json:"session_max_lifetime"`        //Seconds since login before a session is ended even if renewed, 0 to disable
 	/* Storage Options */
 	ConfigFolderPath string `json:"config_folder_path"` //Path to the config folder for storing group policy files. Default: ./conf/sso/zorxauth
 }
 
+// BrowserSession is the session of a protected site, issued from a gateway session
 type BrowserSession struct {
-	Username string
-	Expiry   time.Time
+	Username       string
+	Expiry         time.Time
+	GatewaySession string    //Public ID of the gateway session that issued this session
+	Host           string    //Host the session cookie is set on
+	IP             string    //IP address of the client when the session is created
+	UserAgent      string    //User agent of the client when the session is created
+	CreatedAt      time.Time //Login time
+	LastActivity   time.Time //Last time the session is used, updated at most once per minute
 }
 
+// GatewaySession is the login session of the SSO portal
 type GatewaySession struct {
-	Username string
-	Expiry   time.Time
+	Username     string
+	Expiry       time.Time
+	IP           string
+	UserAgent    string
+	CreatedAt    time.Time
+	LastActivity time.Time
+}
+
+// validationCode is a one-time code for setting a browser session on a protected site
+type validationCode struct {
+	Username       string
+	GatewaySession string //Public ID of the gateway session that issued the code
 }
 
 // PendingTOTPSession holds login context while waiting for 2FA verification
@@ -88,7 +117,8 @@ type PendingTOTPSession struct {
 	Protocol       string
 	RedirectTarget string
 	RememberMe     bool
-	IsDirectLogin  bool // true when no redirect target was provided (direct gateway access)
+	IsDirectLogin  bool   // true when no redirect target was provided (direct gateway access)
+	DirectTarget   string // gateway local path to redirect to after a direct login
 	Expiry         time.Time
 }
 
@@ -105,9 +135,9 @@ type AuthRouter struct {
 	Options  *AuthRouterOptions
 
 	/* Internal */
-	sessionIdStore      sync.Map //sessionId -> userID
-	gatewaySessionStore sync.Map //sessionId -> *GatewaySession
-	cookieIdStore       sync.Map //browserSessionID (cookie value) -> *BrowserSession
+	sessionIdStore      sync.Map //validation code -> *validationCode
+	gatewaySessionStore sync.Map //sessionKey(token) -> *GatewaySession
+	cookieIdStore       sync.Map //sessionKey(cookie value) -> *BrowserSession
 	gatewayServer       *GatewayServer
 
 	/* Group Policies */
@@ -118,6 +148,9 @@ type AuthRouter struct {
 	loginFailureCounter sync.Map  // IP -> *int64, consecutive failures used for exponential backoff
 	rateLimitResetStop  chan bool // stop channel for the per-minute counter reset ticker
 
+	/* Sessions */
+	sessionCleanupStop chan bool // stop channel for the expired session cleanup ticker
+
 	/* 2FA */
 	pendingTOTPSessions sync.Map // totp_token (string) -> *PendingTOTPSession (pending login 2FA)
 	pendingTOTPSetup    sync.Map // username (string) -> *PendingTOTPSetup (pending 2FA enrollment)
@@ -125,6 +158,22 @@ type AuthRouter struct {
 	/* Passkeys (WebAuthn) */
 	pendingPasskeyReg  sync.Map // token (string) -> *PendingPasskeyRegistration
 	pendingPasskeyAuth sync.Map // token (string) -> *PendingPasskeyAuth
+
+	/* OpenID Connect Provider */
+	oidcAuthCodes  sync.Map        // code (string) -> *OIDCAuthCode
+	oidcSigningKey *rsa.PrivateKey // RSA key for signing ID and access tokens, loaded on first use
+	oidcKeyID      string          // kid of the signing key
+	oidcKeyMutex   sync.Mutex
+
+	/* LDAP / Active Directory Backend */
+	ldapOptions *LDAPOptions
+	ldapMutex   sync.RWMutex
+
+	/* Email (password reset, invitations and notifications) */
+	emailOptions    *EmailOptions
+	emailSigningKey []byte // HMAC key for signing email links, loaded on first use
+	emailMutex      sync.RWMutex
+	emailTokenMutex sync.Mutex // serialize token consumption so links are single-use
 }
 
 func getDefaultOptions() *AuthRouterOptions {
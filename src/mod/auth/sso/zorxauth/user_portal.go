@@ -123,17 +123,22 @@ func (gs *GatewayServer) handleChangePassword(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if !gs.router.ValidateUsername(username, currentPassword) {
-		utils.SendErrorResponse(w, "current password is incorrect")
-		return
-	}
-
 	u, err := gs.router.getUserByUsername(username)
 	if err != nil {
 		utils.SendErrorResponse(w, "user not found")
 		return
 	}
 
+	if u.Source == USER_SOURCE_LDAP {
+		utils.SendErrorResponse(w, "password is managed by your organization directory")
+		return
+	}
+
+	if !gs.router.ValidateUsername(username, currentPassword) {
+		utils.SendErrorResponse(w, "current password is incorrect")
+		return
+	}
+
 	u.PasswordHash = hashPassword(newPassword)
 	if err := gs.router.saveUser(u, u.Username); err != nil {
 		utils.SendErrorResponse(w, "failed to save new password: "+err.Error())
@@ -10,7 +10,6 @@ import (
 	"net/mail"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 	"imuslab.com/zoraxy/mod/utils"
@@ -24,6 +23,7 @@ type UserResponse struct {
 	GroupID        string   `json:"groupId"`        //GroupID is the group policy ID that the user belongs to
 	AllowedHosts   []string `json:"allowedHosts"`
 	Enable2FA      bool     `json:"enable2FA"`
+	Source         string   `json:"source"` //Empty for local users or "ldap" for directory users
 }
 
 func normalizeUsername(username string) string {
@@ -54,6 +54,7 @@ func userToResponse(user *User) *UserResponse {
 		GroupID:        user.GroupID,
 		AllowedHosts:   user.AllowedHosts,
 		Enable2FA:      user.Enable2FA,
+		Source:         user.Source,
 	}
 }
 
@@ -91,7 +92,9 @@ func (ar *AuthRouter) getUserByUsername(username string) (*User, error) {
 		user.Username = normalizedUsername
 	}
 	if user.ID == "" {
+		//Persist the generated ID so it stays stable, it is used as the OIDC subject
 		user.ID = uuid.NewString()
+		ar.Database.Write(DB_USERS_TABLE, ar.userDbKey(normalizedUsername), user)
 	}
 
 	return user, nil
@@ -229,9 +232,9 @@ func (ar *AuthRouter) saveUser(user *User, previousUsername string) error {
 		ar.Database.Delete(DB_USERS_TABLE, ar.userDbKey(previousUsername))
 
 		ar.sessionIdStore.Range(func(key, value interface{}) bool {
-			name, ok := value.(string)
-			if ok && strings.EqualFold(name, previousUsername) {
-				ar.sessionIdStore.Store(key, user.Username)
+			code, ok := value.(*validationCode)
+			if ok && strings.EqualFold(code.Username, previousUsername) {
+				ar.sessionIdStore.Store(key, &validationCode{Username: user.Username, GatewaySession: code.GatewaySession})
 			}
 			return true
 		})
@@ -371,6 +374,10 @@ func (ar *AuthRouter) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if password := strings.TrimSpace(r.FormValue("password")); password != "" {
+		if user.Source == USER_SOURCE_LDAP {
+			utils.SendErrorResponse(w, "password of directory users is managed by the directory")
+			return
+		}
 		user.PasswordHash = hashPassword(password)
 	}
 
@@ -428,13 +435,16 @@ func (ar *AuthRouter) HandleUserDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ar.sessionIdStore.Range(func(key, value interface{}) bool {
-		name, ok := value.(string)
-		if ok && strings.EqualFold(name, user.Username) {
+		code, ok := value.(*validationCode)
+		if ok && strings.EqualFold(code.Username, user.Username) {
 			ar.sessionIdStore.Delete(key)
 		}
 		return true
 	})
 
+	// End the active sessions of the deleted user
+	ar.RevokeUserSessions(user.Username)
+
 	utils.SendOK(w)
 }
 
@@ -444,20 +454,9 @@ func (ar *AuthRouter) GetUserFromRequest(w http.ResponseWriter, r *http.Request)
 		return nil, err
 	}
 
-	sessionObj, exists := ar.cookieIdStore.Load(cookie.Value)
+	browserSession, exists := ar.getBrowserSession(cookie.Value)
 	if !exists {
-		return nil, errors.New("session not found")
-	}
-
-	browserSession, ok := sessionObj.(*BrowserSession)
-	if !ok || browserSession == nil {
-		return nil, errors.New("invalid session mapping")
-	}
-
-	// Check if session has expired
-	if time.Now().After(browserSession.Expiry) {
-		ar.cookieIdStore.Delete(cookie.Value)
-		return nil, errors.New("session expired")
+		return nil, errors.New("session not found or expired")
 	}
 
 	user, err := ar.getUserByUsername(browserSession.Username)
@@ -531,12 +530,17 @@ func (ar *AuthRouter) ValidateUsername(username, password string) bool {
 		}
 
 		if matchedUser == nil {
-			return false
+			//Not a local user, try the directory if enabled
+			return ar.validateLDAPCredentials(username, password)
 		}
 
 		user = matchedUser
 	}
 
+	if user.Source == USER_SOURCE_LDAP {
+		return ar.validateLDAPCredentials(user.Username, password)
+	}
+
 	return user.PasswordHash != "" && user.PasswordHash == hashPassword(password)
 }
 
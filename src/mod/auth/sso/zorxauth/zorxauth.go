@@ -44,6 +44,8 @@ func NewAuthRouter(db *database.Database, log *logger.Logger) *AuthRouter {
 	db.NewTable(DB_USERS_TABLE)
 	db.NewTable(DB_BROWSER_SESSIONS_TABLE)
 	db.NewTable(DB_GATEWAY_SESSIONS_TABLE)
+	db.NewTable(DB_OIDC_CLIENTS_TABLE)
+	db.NewTable(DB_EMAIL_TOKENS_TABLE)
 
 	if !db.KeyExists(DB_NAME, "options") {
 		//Write default options to database
@@ -60,6 +62,7 @@ func NewAuthRouter(db *database.Database, log *logger.Logger) *AuthRouter {
 		Database:           db,
 		Options:            &options,
 		rateLimitResetStop: make(chan bool, 1),
+		sessionCleanupStop: make(chan bool, 1),
 	}
 
 	// Load browser sessions from database
@@ -71,9 +74,18 @@ func NewAuthRouter(db *database.Database, log *logger.Logger) *AuthRouter {
 	// Initialize group policy store (load from disk)
 	authRouter.initGroupPolicyStore()
 
+	// Load LDAP backend settings from database
+	authRouter.loadLDAPOptions()
+
+	// Load email settings from database
+	authRouter.loadEmailOptions()
+
 	// Start the per-minute login attempt counter reset ticker
 	go authRouter.startLoginRateLimitTicker()
 
+	// Start the expired session cleanup ticker
+	go authRouter.startSessionCleanupTicker()
+
 	//Start the authentication gateway if enabled
 	if options.EnableAuthGateway {
 		gatewayServer := NewGatewayServer(authRouter)
@@ -89,24 +101,27 @@ func NewAuthRouter(db *database.Database, log *logger.Logger) *AuthRouter {
 	return authRouter
 }
 
-// GenerateValidationCodeForSession generates a one-time validation code for the given session ID and stores the mapping in sessionIdStore.
-// this is not the cookie session ID, for cookie session ID, see generateSessionToken() instead.
-func (ar *AuthRouter) generateValidationCodeForSession(sessionId string) string {
+// GenerateValidationCodeForSession generates a one-time validation code for the user logged in with the given gateway session
+// and stores the mapping in sessionIdStore. this is not the cookie session ID, for cookie session ID, see generateSessionToken() instead.
+func (ar *AuthRouter) generateValidationCodeForSession(username string, gatewaySessionToken string) string {
 	buf := make([]byte, 24)
 	if _, err := rand.Read(buf); err != nil {
 		// Fallback to time-derived code when entropy source fails
 		buf = []byte(time.Now().Format("20060102150405.000000000"))
 	}
 
-	validationCode := hex.EncodeToString(buf)
-	ar.sessionIdStore.Store(validationCode, sessionId)
+	code := hex.EncodeToString(buf)
+	ar.sessionIdStore.Store(code, &validationCode{
+		Username:       username,
+		GatewaySession: sessionPublicID(gatewaySessionToken),
+	})
 
 	// Validation code is short-lived and one-time use.
 	time.AfterFunc(30*time.Second, func() {
-		ar.sessionIdStore.Delete(validationCode)
+		ar.sessionIdStore.Delete(code)
 	})
 
-	return validationCode
+	return code
 }
 
 // startLoginRateLimitTicker resets per-IP login attempt counters every minute.
@@ -161,6 +176,7 @@ func (ar *AuthRouter) loadBrowserSessions() {
 	now := time.Now()
 	loadedCount := 0
 	expiredCount := 0
+	legacyKeys := map[string]string{} //legacy database key -> session ID
 
 	for _, entry := range entries {
 		var session BrowserSession
@@ -173,8 +189,14 @@ func (ar *AuthRouter) loadBrowserSessions() {
 			continue
 		}
 
+		// Sessions created before session tracking have no login time
+		if session.CreatedAt.IsZero() {
+			session.CreatedAt = now
+			session.LastActivity = now
+		}
+
 		// Skip expired sessions
-		if now.After(session.Expiry) {
+		if ar.sessionExpired(session.CreatedAt, session.LastActivity, session.Expiry) {
 			expiredCount++
 			ar.Database.Delete(DB_BROWSER_SESSIONS_TABLE, key)
 			continue
@@ -182,19 +204,21 @@ func (ar *AuthRouter) loadBrowserSessions() {
 
 		// Extract session ID from key (remove prefix)
 		sessionID := strings.TrimPrefix(key, DB_BROWSER_SESSION_KEY_PREFIX)
+		if !strings.HasPrefix(key, DB_BROWSER_SESSION_KEY_PREFIX) {
+			// Session saved under the raw token, move it to the hashed key after listing
+			sessionID = sessionKey(strings.TrimPrefix(key, DB_LEGACY_BROWSER_SESSION_KEY_PREFIX))
+			legacyKeys[key] = sessionID
+		}
 
 		// Store in memory
 		ar.cookieIdStore.Store(sessionID, &session)
 		loadedCount++
+	}
 
-		// Set up cleanup timer for when session expires
-		timeUntilExpiry := time.Until(session.Expiry)
-		if timeUntilExpiry > 0 {
-			sessionIDCopy := sessionID
-			time.AfterFunc(timeUntilExpiry, func() {
-				ar.cookieIdStore.Delete(sessionIDCopy)
-			})
-		}
+	for legacyKey, sessionID := range legacyKeys {
+		session, _ := ar.cookieIdStore.Load(sessionID)
+		ar.Database.Write(DB_BROWSER_SESSIONS_TABLE, DB_BROWSER_SESSION_KEY_PREFIX+sessionID, session)
+		ar.Database.Delete(DB_BROWSER_SESSIONS_TABLE, legacyKey)
 	}
 
 	if ar.Logger != nil {
@@ -216,6 +240,7 @@ func (ar *AuthRouter) loadGatewaySessions() {
 	now := time.Now()
 	loadedCount := 0
 	expiredCount := 0
+	legacyKeys := map[string]string{} //legacy database key -> session ID
 
 	for _, entry := range entries {
 		var session GatewaySession
@@ -228,8 +253,14 @@ func (ar *AuthRouter) loadGatewaySessions() {
 			continue
 		}
 
+		// Sessions created before session tracking have no login time
+		if session.CreatedAt.IsZero() {
+			session.CreatedAt = now
+			session.LastActivity = now
+		}
+
 		// Skip expired sessions
-		if now.After(session.Expiry) {
+		if ar.sessionExpired(session.CreatedAt, session.LastActivity, session.Expiry) {
 			expiredCount++
 			ar.Database.Delete(DB_GATEWAY_SESSIONS_TABLE, key)
 			continue
@@ -237,19 +268,21 @@ func (ar *AuthRouter) loadGatewaySessions() {
 
 		// Extract session ID from key (remove prefix)
 		sessionID := strings.TrimPrefix(key, DB_GATEWAY_SESSION_KEY_PREFIX)
+		if !strings.HasPrefix(key, DB_GATEWAY_SESSION_KEY_PREFIX) {
+			// Session saved under the raw token, move it to the hashed key after listing
+			sessionID = sessionKey(strings.TrimPrefix(key, DB_LEGACY_GATEWAY_SESSION_KEY_PREFIX))
+			legacyKeys[key] = sessionID
+		}
 
 		// Store in memory
 		ar.gatewaySessionStore.Store(sessionID, &session)
 		loadedCount++
+	}
 
-		// Set up cleanup timer for when session expires
-		timeUntilExpiry := time.Until(session.Expiry)
-		if timeUntilExpiry > 0 {
-			sessionIDCopy := sessionID
-			time.AfterFunc(timeUntilExpiry, func() {
-				ar.gatewaySessionStore.Delete(sessionIDCopy)
-			})
-		}
+	for legacyKey, sessionID := range legacyKeys {
+		session, _ := ar.gatewaySessionStore.Load(sessionID)
+		ar.Database.Write(DB_GATEWAY_SESSIONS_TABLE, DB_GATEWAY_SESSION_KEY_PREFIX+sessionID, session)
+		ar.Database.Delete(DB_GATEWAY_SESSIONS_TABLE, legacyKey)
 	}
 
 	if ar.Logger != nil {
@@ -267,6 +300,14 @@ func (ar *AuthRouter) Close() {
 		}
 	}
 
+	// Stop the expired session cleanup ticker
+	if ar.sessionCleanupStop != nil {
+		select {
+		case ar.sessionCleanupStop <- true:
+		default:
+		}
+	}
+
 	// Stop the gateway server if running
 	if ar.gatewayServer != nil {
 		ar.gatewayServer.Stop()
@@ -0,0 +1,278 @@
+package auth
+
+/*
+	totp.go
+
+	Optional TOTP two factor authentication for local management accounts.
+	SSO accounts rely on the multi-factor authentication of the identity provider.
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+const (
+	DB_TOTP_KEY_PREFIX = "totp/"
+	TOTP_SETUP_TIMEOUT = 10 * time.Minute
+
+	TOTP_PERIOD            = 30               //Seconds per TOTP time step
+	TOTP_FREE_ATTEMPTS     = 3                //Failed codes allowed before the account is backed off
+	TOTP_BACKOFF_BASE      = 30 * time.Second //Backoff after the first failure over the free attempts, doubled per failure
+	TOTP_BACKOFF_MAX       = 15 * time.Minute
+	TOTP_ATTEMPT_STATE_TTL = time.Hour //Forget the failure counter after this period without attempts
+)
+
+var (
+	ErrTOTPInvalid  = errors.New("invalid authenticator code")
+	ErrTOTPReused   = errors.New("authenticator code already used")
+	ErrTOTPBackoff  = errors.New("too many invalid authenticator codes, try again later")
+	errTOTPNotFound = errors.New("TOTP is not enabled")
+)
+
+type pendingTOTPSetup struct {
+	Secret string
+	Expiry time.Time
+}
+
+// totpAttemptState tracks the TOTP attempts of an account
+type totpAttemptState struct {
+	sync.Mutex
+	Failures     int       //Consecutive invalid codes
+	LastAttempt  time.Time //Time of the last invalid code
+	BlockedUntil time.Time //No code is checked before this time
+	LastStep     uint64    //Time step of the last accepted code, codes of this or earlier steps are rejected
+}
+
+// IsTOTPEnabled checks if the account requires a TOTP code to sign in
+func (a *AuthAgent) IsTOTPEnabled(username string) bool {
+	return a.Database.KeyExists("auth", DB_TOTP_KEY_PREFIX+username)
+}
+
+// ValidateTOTP checks the TOTP code of the account. Invalid codes back off the
+// account exponentially and a code is only accepted once.
+func (a *AuthAgent) ValidateTOTP(username string, code string) error {
+	secret := ""
+	if err := a.Database.ReadSecret("auth", DB_TOTP_KEY_PREFIX+username, &secret); err != nil || secret == "" {
+		return errTOTPNotFound
+	}
+
+	stateObj, _ := a.totpAttempts.LoadOrStore(username, &totpAttemptState{})
+	state := stateObj.(*totpAttemptState)
+	state.Lock()
+	defer state.Unlock()
+
+	now := time.Now()
+	if state.Failures > 0 && now.Sub(state.LastAttempt) > TOTP_ATTEMPT_STATE_TTL {
+		state.Failures = 0
+	}
+	if now.Before(state.BlockedUntil) {
+		return ErrTOTPBackoff
+	}
+
+	step, ok := matchTOTPStep(code, secret, now)
+	if !ok || step <= state.LastStep {
+		state.Failures++
+		state.LastAttempt = now
+		if state.Failures >= TOTP_FREE_ATTEMPTS {
+			state.BlockedUntil = now.Add(totpBackoff(state.Failures - TOTP_FREE_ATTEMPTS))
+		}
+		if ok {
+			return ErrTOTPReused
+		}
+		return ErrTOTPInvalid
+	}
+
+	state.Failures = 0
+	state.BlockedUntil = time.Time{}
+	state.LastStep = step
+	return nil
+}
+
+// matchTOTPStep returns the time step the code was generated for, allowing
+// one step of clock skew like totp.Validate
+func matchTOTPStep(code string, secret string, t time.Time) (uint64, bool) {
+	current := uint64(t.Unix()) / TOTP_PERIOD
+	for _, step := range []uint64{current - 1, current, current + 1} {
+		if hotp.Validate(code, step, secret) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpBackoff returns the backoff duration after the given number of failures over the free attempts
+func totpBackoff(failures int) time.Duration {
+	backoff := TOTP_BACKOFF_BASE
+	for i := 0; i < failures; i++ {
+		backoff *= 2
+		if backoff >= TOTP_BACKOFF_MAX {
+			return TOTP_BACKOFF_MAX
+		}
+	}
+	return backoff
+}
+
+// DisableTOTP removes the TOTP secret of the account
+func (a *AuthAgent) DisableTOTP(username string) error {
+	if !a.IsTOTPEnabled(username) {
+		return errTOTPNotFound
+	}
+	a.totpAttempts.Delete(username)
+	return a.Database.Delete("auth", DB_TOTP_KEY_PREFIX+username)
+}
+
+// getRequestLocalUsername returns the logged in local account of the request
+func (a *AuthAgent) getRequestLocalUsername(w http.ResponseWriter, r *http.Request) (string, error) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		return "", err
+	}
+	if a.IsSSOUser(username) {
+		return "", errors.New("two factor authentication of SSO accounts is managed by the identity provider")
+	}
+	return username, nil
+}
+
+/* ===================== HTTP API Handlers ===================== */
+
+// HandleTOTPStatus returns if TOTP is enabled for the current user
+func (a *AuthAgent) HandleTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	js, _ := json.Marshal(map[string]interface{}{
+		"enabled": a.IsTOTPEnabled(username),
+		"sso":     a.IsSSOUser(username),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleTOTPSetup generates a new TOTP secret for the current user, the secret
+// is only saved after a valid code is given to HandleTOTPEnable
+func (a *AuthAgent) HandleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getRequestLocalUsername(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	if a.IsTOTPEnabled(username) {
+		utils.SendErrorResponse(w, "TOTP is already enabled, disable it first to re-enroll")
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "Zoraxy",
+		AccountName: username,
+	})
+	if err != nil {
+		utils.SendErrorResponse(w, "failed to generate TOTP secret")
+		return
+	}
+	a.pendingTOTPSetup.Store(username, &pendingTOTPSetup{
+		Secret: key.Secret(),
+		Expiry: time.Now().Add(TOTP_SETUP_TIMEOUT),
+	})
+
+	// Render QR code PNG and base64-encode it for inline display
+	var qrDataURL string
+	if img, imgErr := key.Image(200, 200); imgErr == nil {
+		var buf bytes.Buffer
+		if encErr := png.Encode(&buf, img); encErr == nil {
+			qrDataURL = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"secret":           key.Secret(),
+		"provisioning_uri": key.URL(),
+		"qr_image":         qrDataURL,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleTOTPEnable validates the code against the pending secret and enables TOTP, require POST code
+func (a *AuthAgent) HandleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getRequestLocalUsername(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	code, err := utils.PostPara(r, "code")
+	if err != nil {
+		utils.SendErrorResponse(w, "code is required")
+		return
+	}
+
+	pendingObj, ok := a.pendingTOTPSetup.Load(username)
+	if !ok || time.Now().After(pendingObj.(*pendingTOTPSetup).Expiry) {
+		a.pendingTOTPSetup.Delete(username)
+		utils.SendErrorResponse(w, "TOTP setup expired, please start again")
+		return
+	}
+	pending := pendingObj.(*pendingTOTPSetup)
+	if !totp.Validate(code, pending.Secret) {
+		utils.SendErrorResponse(w, "Invalid authenticator code")
+		return
+	}
+
+	err = a.Database.WriteSecret("auth", DB_TOTP_KEY_PREFIX+username, pending.Secret)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.pendingTOTPSetup.Delete(username)
+	a.Logger.PrintAndLog("auth", "TOTP enabled for "+username, nil)
+	utils.SendOK(w)
+}
+
+// HandleTOTPDisable disables TOTP of the current user, require POST password
+func (a *AuthAgent) HandleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getRequestLocalUsername(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	password, err := utils.PostPara(r, "password")
+	if err != nil || !a.ValidateUsernameAndPassword(username, password) {
+		utils.SendErrorResponse(w, "Invalid current password given")
+		return
+	}
+
+	err = a.DisableTOTP(username)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.Logger.PrintAndLog("auth", "TOTP disabled for "+username, nil)
+	utils.SendOK(w)
+}
+
+// HandleResetUserTOTP disables TOTP of another management account, e.g. after losing
+// the authenticator device, require POST username
+func (a *AuthAgent) HandleResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil || !a.UserExists(username) {
+		utils.SendErrorResponse(w, "User not found")
+		return
+	}
+	err = a.DisableTOTP(username)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	a.Logger.PrintAndLog("auth", "TOTP of "+username+" reset by admin", nil)
+	utils.SendOK(w)
+}
@@ -13,6 +13,7 @@ import (
 	"runtime"
 
 	"imuslab.com/zoraxy/mod/database/dbinc"
+	"imuslab.com/zoraxy/mod/secrets"
 )
 
 type Database struct {
@@ -97,6 +98,40 @@ func (d *Database) Read(tableName string, key string, assignee interface{}) erro
 	return d.read(tableName, key, assignee)
 }
 
+/*
+Write a string secret, e.g. a client secret or signing key, to the database.
+The value is sealed with the master key if secrets encryption is enabled.
+
+err := sysdb.WriteSecret("oauth2", "oauth2ClientSecret", clientSecret)
+*/
+func (d *Database) WriteSecret(tableName string, key string, value string) error {
+	sealed, err := secrets.SealString(value)
+	if err != nil {
+		return err
+	}
+	return d.write(tableName, key, sealed)
+}
+
+/*
+Read a string secret written by WriteSecret. Secrets written before
+encryption is enabled are returned as is.
+
+	clientSecret := ""
+	err := sysdb.ReadSecret("oauth2", "oauth2ClientSecret", &clientSecret)
+*/
+func (d *Database) ReadSecret(tableName string, key string, value *string) error {
+	sealed := ""
+	if err := d.read(tableName, key, &sealed); err != nil {
+		return err
+	}
+	opened, err := secrets.OpenString(sealed)
+	if err != nil {
+		return err
+	}
+	*value = opened
+	return nil
+}
+
 /*
 Check if a key exists in the database table given tablename and key
 
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"imuslab.com/zoraxy/mod/dynamicproxy/captcha"
+	"imuslab.com/zoraxy/mod/dynamicproxy/exploits"
 )
 
 /*
@@ -19,10 +20,12 @@ import (
 	- Special Routing Rule (e.g. acme)
 	- Redirectable
 	- Subdomain Routing
+		- Client Certificate (mTLS)
 		- Access Router
 			- Blacklist
 			- Whitelist
 			- Exploit Detection
+			- Web Application Firewall
 		- Rate Limitor
 		- SSO Auth
 		- Basic Auth
@@ -67,6 +70,11 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sep := h.Parent.GetProxyEndpointFromHostname(domainOnly)
 	if sep != nil && !sep.Disabled {
 		//Matching proxy rule found
+		//Client certificate (mTLS) Check
+		if h.Parent.handleMutualTLS(w, r, sep, domainOnly) {
+			return
+		}
+
 		//Access Check (blacklist / whitelist)
 		ruleID := sep.AccessFilterUUID
 		if sep.AccessFilterUUID == "" {
@@ -80,7 +88,11 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		/* Exploit Detection */
 		if sep.detector != nil {
-			if sep.detector.CheckIsAttack(w, r) {
+			if sep.MitigationAction == int(exploits.ExploitRequestResponseTypeCaptcha) {
+				if h.Parent.handleCaptchaMitigation(w, r, sep, domainOnly) {
+					return
+				}
+			} else if sep.detector.CheckIsAttack(w, r) {
 				statusCode := 403
 				if sep.detector != nil {
 					statusCode = sep.detector.GetResponseStatusCode()
@@ -90,6 +102,11 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		/* Web Application Firewall */
+		if h.Parent.handleWAFRouting(w, r, sep, domainOnly) {
+			return
+		}
+
 		// Rate Limit
 		if sep.RequireRateLimit {
 			err := h.handleRateLimitRouting(w, r, sep)
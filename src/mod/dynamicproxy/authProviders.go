@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"imuslab.com/zoraxy/mod/auth"
+	"imuslab.com/zoraxy/mod/auth/sso/oauth2"
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
 	"imuslab.com/zoraxy/mod/netutils"
 )
 
@@ -49,8 +51,11 @@ func handleAuthProviderRouting(sep *ProxyEndpoint, w http.ResponseWriter, r *htt
 			return true
 		}
 	case AuthMethodOauth2:
-		err := h.handleOAuth2Auth(w, r)
-		if err != nil {
+		err := h.handleOAuth2Auth(w, r, sep)
+		if errors.Is(err, oauth2.ErrForbidden) {
+			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", 403, requestHostname, "")
+			return true
+		} else if err != nil {
 			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", 401, requestHostname, "")
 			return true
 		}
@@ -60,6 +65,24 @@ func handleAuthProviderRouting(sep *ProxyEndpoint, w http.ResponseWriter, r *htt
 			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", 401, requestHostname, "")
 			return true
 		}
+	case AuthMethodClientCert:
+		err := handleClientCertAuth(w, r, sep)
+		if err != nil {
+			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", 401, requestHostname, "")
+			return true
+		}
+	case AuthMethodJWT:
+		statusCode, err := handleJWTAuth(w, r, sep)
+		if err != nil {
+			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", statusCode, requestHostname, "")
+			return true
+		}
+	case AuthMethodAPIKey:
+		statusCode, err := handleAPIKeyAuth(w, r, sep, h.Parent.Option.ProxyAPIKeyManager)
+		if err != nil {
+			h.Parent.Option.Logger.LogHTTPRequest(r, "host-http", statusCode, requestHostname, "")
+			return true
+		}
 	}
 
 	//No authentication provider, do not need to handle
@@ -164,8 +187,122 @@ func (h *ProxyHandler) handleForwardAuth(w http.ResponseWriter, r *http.Request)
 	return h.Parent.Option.ForwardAuthRouter.HandleAuthProviderRouting(w, r)
 }
 
-func (h *ProxyHandler) handleOAuth2Auth(w http.ResponseWriter, r *http.Request) error {
-	return h.Parent.Option.OAuth2Router.HandleOAuth2Auth(w, r)
+func (h *ProxyHandler) handleOAuth2Auth(w http.ResponseWriter, r *http.Request, sep *ProxyEndpoint) error {
+	return h.Parent.Option.OAuth2Router.HandleOAuth2Auth(w, r, sep.AuthenticationProvider.OAuth2ClaimRules, sep.AuthenticationProvider.OAuth2ClaimForwarding)
+}
+
+/* JWT Bearer Auth */
+
+// bearerChallenge build the RFC 6750 WWW-Authenticate header value
+func bearerChallenge(realm string, errorCode string, description string, scope string) string {
+	challenge := `Bearer realm="` + realm + `"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `"`
+	}
+	if description != "" {
+		challenge += `, error_description="` + strings.ReplaceAll(description, `"`, `'`) + `"`
+	}
+	if scope != "" {
+		challenge += `, scope="` + scope + `"`
+	}
+	return challenge
+}
+
+// Handle JWT bearer token auth, machine clients get a 401 challenge instead of a login redirect
+// do not write to http.ResponseWriter if err return is not nil (already handled by this function)
+func handleJWTAuth(w http.ResponseWriter, r *http.Request, pe *ProxyEndpoint) (int, error) {
+	realm := pe.RootOrMatchingDomain
+	config := pe.AuthenticationProvider.JWTAuthConfig
+	if config == nil {
+		//Fail closed if the endpoint has not been configured
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "", "", ""))
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return http.StatusUnauthorized, errors.New("jwt auth not configured")
+	}
+
+	rawToken, ok := oidc.ExtractBearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "", "", ""))
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+
+	claims, err := config.VerifyBearerToken(rawToken)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "invalid_token", "The access token is invalid or expired", ""))
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return http.StatusUnauthorized, err
+	}
+
+	if missing := config.MissingScopes(claims); len(missing) > 0 {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, "insufficient_scope", "The access token does not have the required scope", strings.Join(config.RequiredScopes, " ")))
+		http.Error(w, "403 - Forbidden", http.StatusForbidden)
+		return http.StatusForbidden, errors.New("insufficient scope")
+	}
+
+	config.ForwardClaims(r, claims)
+	return http.StatusOK, nil
+}
+
+/* API Key Auth */
+
+// Handle API key auth, the key is removed from the request before it is forwarded to the upstream
+// do not write to http.ResponseWriter if err return is not nil (already handled by this function)
+func handleAPIKeyAuth(w http.ResponseWriter, r *http.Request, pe *ProxyEndpoint, manager *auth.ProxyAPIKeyManager) (int, error) {
+	headerName := pe.AuthenticationProvider.APIKeyHeader
+	if headerName == "" {
+		headerName = DefaultAPIKeyHeader
+	}
+	queryParam := pe.AuthenticationProvider.APIKeyQueryParam
+
+	apiKey := r.Header.Get(headerName)
+	r.Header.Del(headerName)
+	if queryParam != "" {
+		query := r.URL.Query()
+		if apiKey == "" {
+			apiKey = query.Get(queryParam)
+		}
+		if query.Has(queryParam) {
+			query.Del(queryParam)
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+	}
+
+	if apiKey == "" || manager == nil {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return http.StatusUnauthorized, errors.New("missing API key")
+	}
+
+	var clientIP string
+	if pe.AuthenticationProvider.APIKeyUseTrustedProxy {
+		clientIP = netutils.GetRequesterIP(r)
+	} else {
+		clientIP = netutils.GetRequesterIPUntrusted(r)
+	}
+
+	key, err := manager.Authenticate(apiKey, pe.RootOrMatchingDomain, clientIP)
+	switch err {
+	case nil:
+	case auth.ErrAPIKeyNotPermitted:
+		http.Error(w, "403 - Forbidden", http.StatusForbidden)
+		return http.StatusForbidden, err
+	case auth.ErrAPIKeyRateLimited:
+		http.Error(w, "429 - Too Many Requests", http.StatusTooManyRequests)
+		return http.StatusTooManyRequests, err
+	default:
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return http.StatusUnauthorized, err
+	}
+
+	//Identify the key owner to the upstream, same as basic auth
+	owner := key.Owner
+	if owner == "" {
+		owner = key.Name
+	}
+	r.Header.Set("X-Remote-User", owner)
+	r.Header.Set("X-API-Key-Id", key.ID)
+	return http.StatusOK, nil
 }
 
 func (h *ProxyHandler) handleZorxAuth(w http.ResponseWriter, r *http.Request, sep *ProxyEndpoint) error {
@@ -0,0 +1,121 @@
+package dynamicproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"imuslab.com/zoraxy/mod/auth"
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+)
+
+func TestHandleJWTAuth(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	pe := &ProxyEndpoint{
+		RootOrMatchingDomain: "api.example.com",
+		AuthenticationProvider: &AuthenticationProvider{
+			AuthMethod: AuthMethodJWT,
+			JWTAuthConfig: &oidc.BearerConfig{
+				KeySource:      oidc.BearerKeySourceSecret,
+				Secret:         secret,
+				RequiredScopes: []string{"read"},
+				ForwardHeaders: []*oidc.ClaimHeader{{Claim: "sub", Header: "X-Client-Id"}},
+			},
+		},
+	}
+	sign := func(scope string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   "service-a",
+			"scope": scope,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(secret))
+		return token
+	}
+
+	testcases := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"MissingToken", "", http.StatusUnauthorized, `Bearer realm="api.example.com"`},
+		{"InvalidToken", "Bearer invalid", http.StatusUnauthorized, `error="invalid_token"`},
+		{"InsufficientScope", "Bearer " + sign("write"), http.StatusForbidden, `error="insufficient_scope"`},
+		{"Valid", "Bearer " + sign("read write"), http.StatusOK, ""},
+	}
+	for _, tc := range testcases {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/orders", nil)
+		r.Header.Set("X-Client-Id", "spoofed")
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		status, err := handleJWTAuth(w, r, pe)
+		if status != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, status)
+		}
+		if tc.status == http.StatusOK {
+			if err != nil || r.Header.Get("X-Client-Id") != "service-a" {
+				t.Errorf("%s: expected claim to be forwarded, got %v %q", tc.name, err, r.Header.Get("X-Client-Id"))
+			}
+			continue
+		}
+		if w.Code != tc.status || !strings.Contains(w.Header().Get("WWW-Authenticate"), tc.challenge) {
+			t.Errorf("%s: unexpected response %d %q", tc.name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestHandleAPIKeyAuth(t *testing.T) {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "sys.db"), dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sysdb.Close()
+	manager, _ := auth.NewProxyAPIKeyManager(sysdb)
+	plainKey, _, _ := manager.CreateKey(&auth.ProxyAPIKey{Name: "client", Owner: "billing-team", AllowedEndpoints: []string{"api.example.com"}})
+
+	pe := &ProxyEndpoint{
+		RootOrMatchingDomain: "api.example.com",
+		AuthenticationProvider: &AuthenticationProvider{
+			AuthMethod:       AuthMethodAPIKey,
+			APIKeyQueryParam: "api_key",
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/orders", nil)
+	if status, err := handleAPIKeyAuth(httptest.NewRecorder(), r, pe, manager); err == nil || status != http.StatusUnauthorized {
+		t.Errorf("expected missing key to be rejected, got %d", status)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/orders?page=2&api_key="+plainKey, nil)
+	r.Header.Set("X-Remote-User", "spoofed")
+	if _, err := handleAPIKeyAuth(httptest.NewRecorder(), r, pe, manager); err != nil {
+		t.Fatalf("expected query parameter key to be accepted: %v", err)
+	}
+	if r.URL.Query().Has("api_key") || strings.Contains(r.RequestURI, plainKey) || r.URL.Query().Get("page") != "2" {
+		t.Errorf("expected key to be removed from the forwarded URL, got %s", r.RequestURI)
+	}
+	if r.Header.Get("X-Remote-User") != "billing-team" {
+		t.Errorf("unexpected X-Remote-User %q", r.Header.Get("X-Remote-User"))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/orders", nil)
+	r.Header.Set(DefaultAPIKeyHeader, plainKey)
+	if _, err := handleAPIKeyAuth(httptest.NewRecorder(), r, pe, manager); err != nil || r.Header.Get(DefaultAPIKeyHeader) != "" {
+		t.Errorf("expected header key to be accepted and removed: %v", err)
+	}
+
+	pe.RootOrMatchingDomain = "other.example.com"
+	r = httptest.NewRequest(http.MethodGet, "http://other.example.com/", nil)
+	r.Header.Set(DefaultAPIKeyHeader, plainKey)
+	if status, _ := handleAPIKeyAuth(httptest.NewRecorder(), r, pe, manager); status != http.StatusForbidden {
+		t.Errorf("expected key to be rejected on other endpoint, got %d", status)
+	}
+}
@@ -24,7 +24,8 @@ import (
 	captcha.go
 
 	CAPTCHA verification and session management for gating access to endpoints.
-	Supports Cloudflare Turnstile and Google reCAPTCHA (v2 and v3).
+	Supports Cloudflare Turnstile, Google reCAPTCHA (v2 and v3) and a
+	self-hosted proof-of-work challenge (see pow.go).
 */
 
 //go:embed cloudflare_turnstile.html
@@ -36,6 +37,9 @@ var googleRecaptchaV2Template string
 //go:embed google_recaptcha_v3.html
 var googleRecaptchaV3Template string
 
+//go:embed proof_of_work.html
+var proofOfWorkTemplate string
+
 const (
 	CookieName             = "zoraxy_captcha_session"
 	VerifyPath             = "/.zoraxy/captcha/verify"
@@ -48,8 +52,9 @@ const (
 type Provider int
 
 const (
-	ProviderCloudflare Provider = 0
-	ProviderGoogle     Provider = 1
+	ProviderCloudflare  Provider = 0
+	ProviderGoogle      Provider = 1
+	ProviderProofOfWork Provider = 2
 )
 
 // ExceptionType defines the type of CAPTCHA exception rule
@@ -69,6 +74,7 @@ type Config struct {
 	RecaptchaVersion string           `json:"RecaptchaVersion"` // v2 or v3
 	RecaptchaScore   float64          `json:"RecaptchaScore"`   // v3 only, 0.0-1.0
 	ProtectedPathPrefixes []string    `json:"ProtectedPathPrefixes"` // Empty means protect all paths on this endpoint
+	PowDifficulty    int              `json:"PowDifficulty"`    // Proof-of-work only, leading zero bits
 	ExceptionRules   []*ExceptionRule `json:"ExceptionRules"`
 }
 
@@ -81,8 +87,9 @@ type ExceptionRule struct {
 
 // SessionStore manages active CAPTCHA sessions
 type SessionStore struct {
-	sessions sync.Map // map[sessionID]expiryTime
-	stopChan chan struct{}
+	sessions       sync.Map // map[sessionID]expiryTime
+	usedChallenges sync.Map // map[powNonce]expiryTime, for replay protection
+	stopChan       chan struct{}
 }
 
 // NewSessionStore creates a new CAPTCHA session store
@@ -127,6 +134,12 @@ func (s *SessionStore) RemoveSession(sessionID string) {
 	s.sessions.Delete(sessionID)
 }
 
+// redeemChallenge marks a proof-of-work challenge as used, return false if it was used before
+func (s *SessionStore) redeemChallenge(nonce string, expiryTime time.Time) bool {
+	_, used := s.usedChallenges.LoadOrStore(nonce, expiryTime)
+	return !used
+}
+
 // Close stops the cleanup goroutine and releases resources
 func (s *SessionStore) Close() {
 	close(s.stopChan)
@@ -148,6 +161,13 @@ func (s *SessionStore) cleanupExpiredSessions() {
 				}
 				return true
 			})
+			s.usedChallenges.Range(func(key, value interface{}) bool {
+				expiryTime, ok := value.(time.Time)
+				if !ok || now.After(expiryTime) {
+					s.usedChallenges.Delete(key)
+				}
+				return true
+			})
 		case <-s.stopChan:
 			return
 		}
@@ -382,6 +402,11 @@ type TemplateData struct {
 	Domain     string
 	SiteKey    string
 	VerifyPath string
+
+	// Proof-of-work only
+	Challenge  string
+	Signature  string
+	Difficulty int
 }
 
 // loadExternalTemplate tries to load an external template from the filesystem
@@ -422,7 +447,28 @@ func RenderChallenge(w http.ResponseWriter, r *http.Request, config *Config, dom
 	var templateName string
 
 	// Determine which template to use based on provider
-	if config.Provider == ProviderCloudflare {
+	if config.Provider == ProviderProofOfWork {
+		templateName = "captcha_pow"
+		challenge, signature, err := NewPowChallenge(config, GetClientIP(r))
+		if err != nil {
+			http.Error(w, "Failed to create challenge", http.StatusInternalServerError)
+			return
+		}
+		data.Challenge = challenge
+		data.Signature = signature
+		data.Difficulty = config.GetPowDifficulty()
+		// Try to load external template first
+		if webDir != "" {
+			external, err := loadExternalTemplate(webDir, templateName)
+			if err == nil {
+				templateContent = external
+			}
+		}
+		// Fall back to embedded template
+		if templateContent == "" {
+			templateContent = proofOfWorkTemplate
+		}
+	} else if config.Provider == ProviderCloudflare {
 		templateName = "captcha_cloudflare"
 		// Try to load external template first
 		if webDir != "" {
@@ -507,6 +553,25 @@ func HandleVerification(w http.ResponseWriter, r *http.Request, config *Config,
 		}
 	}
 
+	clientIP := GetClientIP(r)
+	if config.Provider == ProviderProofOfWork {
+		verifyErr := VerifyPowSolution(config,
+			strings.TrimSpace(r.PostFormValue("pow-challenge")),
+			strings.TrimSpace(r.PostFormValue("pow-signature")),
+			strings.TrimSpace(r.PostFormValue("pow-solution")),
+			clientIP, sessionStore)
+		if verifyErr != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   verifyErr.Error(),
+			})
+			return fmt.Errorf("verification failed: %v", verifyErr)
+		}
+		return createSession(w, r, config, sessionStore)
+	}
+
 	// Try to get token from POST form first, then from regular form
 	token := r.PostFormValue("cf-turnstile-response")
 	token = strings.TrimSpace(token)
@@ -533,7 +598,6 @@ func HandleVerification(w http.ResponseWriter, r *http.Request, config *Config,
 		return errors.New("token missing")
 	}
 
-	clientIP := GetClientIP(r)
 	var verified bool
 	var verifyErr error
 
@@ -571,6 +635,11 @@ func HandleVerification(w http.ResponseWriter, r *http.Request, config *Config,
 		return fmt.Errorf("verification failed: %v", errorMsg)
 	}
 
+	return createSession(w, r, config, sessionStore)
+}
+
+// createSession issues a session cookie after a successful verification
+func createSession(w http.ResponseWriter, r *http.Request, config *Config, sessionStore *SessionStore) error {
 	// Create session
 	sessionID, err := generateSessionID()
 	if err != nil {
@@ -621,6 +690,10 @@ func (config *Config) IsConfigured() bool {
 	if config == nil {
 		return false
 	}
+	if config.Provider == ProviderProofOfWork {
+		// Verified locally, no keys required
+		return true
+	}
 	if config.SiteKey == "" || config.SecretKey == "" {
 		return false
 	}
@@ -0,0 +1,153 @@
+package captcha
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	pow.go
+
+	Self-hosted proof-of-work challenge provider. The browser has to find
+	a counter such that SHA-256(challenge + ":" + counter) starts with the
+	configured number of zero bits. Challenges are signed with HMAC-SHA256
+	and bound to the client IP, so they can be verified locally without
+	keeping state until the solution is submitted. Each challenge can only
+	be redeemed once.
+*/
+
+const (
+	DefaultPowDifficulty   = 18  //Leading zero bits, ~260k hashes on average
+	MinPowDifficulty       = 8   //Anything lower is solved instantly by bots
+	MaxPowDifficulty       = 28  //Anything higher takes minutes on mobile devices
+	DefaultPowChallengeTTL = 300 //Challenge validity in seconds
+)
+
+// powFallbackSecret is used to sign challenges when no secret key is configured
+var powFallbackSecret = func() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("unable to generate proof-of-work secret: " + err.Error())
+	}
+	return secret
+}()
+
+// DefaultProofOfWorkConfig returns a proof-of-work CAPTCHA config with default settings
+func DefaultProofOfWorkConfig() *Config {
+	return &Config{
+		Provider:        ProviderProofOfWork,
+		SessionDuration: DefaultSessionDuration,
+		PowDifficulty:   DefaultPowDifficulty,
+	}
+}
+
+// GetPowDifficulty returns the difficulty of the config, clamped to the supported range
+func (config *Config) GetPowDifficulty() int {
+	difficulty := config.PowDifficulty
+	if difficulty == 0 {
+		return DefaultPowDifficulty
+	}
+	if difficulty < MinPowDifficulty {
+		return MinPowDifficulty
+	}
+	if difficulty > MaxPowDifficulty {
+		return MaxPowDifficulty
+	}
+	return difficulty
+}
+
+func (config *Config) powSecret() []byte {
+	if config.SecretKey != "" {
+		return []byte(config.SecretKey)
+	}
+	return powFallbackSecret
+}
+
+func signPowChallenge(secret []byte, challenge string, clientIP string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(challenge + "|" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewPowChallenge creates a signed challenge in the format of nonce:expiry:difficulty
+func NewPowChallenge(config *Config, clientIP string) (challenge string, signature string, err error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	expiry := time.Now().Add(DefaultPowChallengeTTL * time.Second).Unix()
+	challenge = hex.EncodeToString(nonce) + ":" + strconv.FormatInt(expiry, 10) + ":" + strconv.Itoa(config.GetPowDifficulty())
+	signature = signPowChallenge(config.powSecret(), challenge, clientIP)
+	return challenge, signature, nil
+}
+
+// powLeadingZeroBits counts the leading zero bits of SHA-256(challenge:solution)
+func powLeadingZeroBits(challenge string, solution string) int {
+	hash := sha256.Sum256([]byte(challenge + ":" + solution))
+	count := 0
+	for _, b := range hash {
+		if b == 0 {
+			count += 8
+			continue
+		}
+		count += bits.LeadingZeros8(b)
+		break
+	}
+	return count
+}
+
+// VerifyPowSolution checks the signature, expiry and work of a submitted solution
+// and marks the challenge as used on success
+func VerifyPowSolution(config *Config, challenge string, signature string, solution string, clientIP string, sessionStore *SessionStore) error {
+	if challenge == "" || signature == "" || solution == "" {
+		return errors.New("challenge, signature and solution are required")
+	}
+
+	expectedSignature := signPowChallenge(config.powSecret(), challenge, clientIP)
+	if !hmac.Equal([]byte(expectedSignature), []byte(signature)) {
+		return errors.New("invalid challenge signature")
+	}
+
+	parts := strings.Split(challenge, ":")
+	if len(parts) != 3 {
+		return errors.New("malformed challenge")
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return errors.New("malformed challenge expiry")
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return errors.New("malformed challenge difficulty")
+	}
+
+	expiryTime := time.Unix(expiry, 0)
+	if time.Now().After(expiryTime) {
+		return errors.New("challenge expired")
+	}
+
+	//Do not accept challenges issued before the difficulty was raised
+	if difficulty < config.GetPowDifficulty() {
+		return errors.New("challenge difficulty too low")
+	}
+
+	if _, err := strconv.ParseUint(solution, 10, 64); err != nil {
+		return errors.New("invalid solution")
+	}
+	if powLeadingZeroBits(challenge, solution) < difficulty {
+		return errors.New("insufficient proof of work")
+	}
+
+	if !sessionStore.redeemChallenge(parts[0], expiryTime) {
+		return errors.New("challenge already used")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package captcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solvePowChallenge(t *testing.T, challenge string) string {
+	parts := strings.Split(challenge, ":")
+	difficulty, _ := strconv.Atoi(parts[2])
+	for counter := 0; counter < 1<<24; counter++ {
+		solution := strconv.Itoa(counter)
+		if powLeadingZeroBits(challenge, solution) >= difficulty {
+			return solution
+		}
+	}
+	t.Fatal("unable to solve challenge")
+	return ""
+}
+
+func TestPowChallengeVerify(t *testing.T) {
+	store := NewSessionStore()
+	defer store.Close()
+	config := &Config{Provider: ProviderProofOfWork, PowDifficulty: MinPowDifficulty}
+
+	challenge, signature, err := NewPowChallenge(config, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	solution := solvePowChallenge(t, challenge)
+
+	if err := VerifyPowSolution(config, challenge, signature, solution, "10.0.0.2", store); err == nil {
+		t.Error("expected challenge to be bound to the client IP")
+	}
+	if err := VerifyPowSolution(config, challenge+"0", signature, solution, "10.0.0.1", store); err == nil {
+		t.Error("expected tampered challenge to be rejected")
+	}
+	if err := VerifyPowSolution(config, challenge, signature, solution, "10.0.0.1", store); err != nil {
+		t.Fatalf("expected valid solution to pass: %v", err)
+	}
+	if err := VerifyPowSolution(config, challenge, signature, solution, "10.0.0.1", store); err == nil {
+		t.Error("expected replayed challenge to be rejected")
+	}
+}
+
+func TestPowChallengeRejectWeakOrExpired(t *testing.T) {
+	store := NewSessionStore()
+	defer store.Close()
+	config := &Config{Provider: ProviderProofOfWork, PowDifficulty: MinPowDifficulty}
+
+	//Solution with insufficient work
+	challenge, signature, _ := NewPowChallenge(config, "10.0.0.1")
+	for counter := 0; ; counter++ {
+		solution := strconv.Itoa(counter)
+		if powLeadingZeroBits(challenge, solution) < MinPowDifficulty {
+			if err := VerifyPowSolution(config, challenge, signature, solution, "10.0.0.1", store); err == nil {
+				t.Error("expected insufficient work to be rejected")
+			}
+			break
+		}
+	}
+
+	//Expired challenge with a valid signature
+	expired := "00112233445566778899aabbccddeeff:" + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + ":8"
+	expiredSignature := signPowChallenge(config.powSecret(), expired, "10.0.0.1")
+	if err := VerifyPowSolution(config, expired, expiredSignature, solvePowChallenge(t, expired), "10.0.0.1", store); err == nil {
+		t.Error("expected expired challenge to be rejected")
+	}
+
+	//Challenge issued before the difficulty was raised
+	config.PowDifficulty = 12
+	if err := VerifyPowSolution(config, challenge, signature, solvePowChallenge(t, challenge), "10.0.0.1", store); err == nil {
+		t.Error("expected challenge below the current difficulty to be rejected")
+	}
+}
+
+func TestPowRenderAndHandleVerification(t *testing.T) {
+	store := NewSessionStore()
+	defer store.Close()
+	config := DefaultProofOfWorkConfig()
+	config.PowDifficulty = MinPowDifficulty
+	if !config.IsConfigured() {
+		t.Fatal("expected proof-of-work config to be configured without keys")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	RenderChallenge(w, r, config, "example.com", "")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "pow-solution") {
+		t.Fatalf("unexpected challenge page, status %d", w.Code)
+	}
+
+	challenge, signature, _ := NewPowChallenge(config, "10.0.0.1")
+	form := url.Values{
+		"pow-challenge": {challenge},
+		"pow-signature": {signature},
+		"pow-solution":  {solvePowChallenge(t, challenge)},
+	}
+	r = httptest.NewRequest(http.MethodPost, VerifyPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.0.0.1:1234"
+	w = httptest.NewRecorder()
+	HandleVerification(w, r, config, store)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected verification to pass, got %d: %s", w.Code, w.Body.String())
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !store.IsValidSession(cookies[0].Value) {
+		t.Error("expected a valid session cookie to be issued")
+	}
+}
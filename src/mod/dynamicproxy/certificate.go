@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"imuslab.com/zoraxy/mod/tlscert"
 )
@@ -57,3 +58,55 @@ func (router *Router) SetPreferredCertificateForDomain(ept *ProxyEndpoint, domai
 
 	return nil
 }
+
+// IsAutoHTTPSHostname checks if the hostname is the exact root or alias name of
+// an enabled endpoint with auto HTTPS on. Wildcard rules never match so random
+// SNI values cannot trigger certificate issuance.
+func (router *Router) IsAutoHTTPSHostname(hostname string) bool {
+	return router.matchEndpointHostname(hostname, func(ept *ProxyEndpoint) bool {
+		if ept.TlsOptions == nil {
+			return false
+		}
+		router.tlsBehaviorMutex.RLock()
+		defer router.tlsBehaviorMutex.RUnlock()
+		return ept.TlsOptions.EnableAutoHTTPS
+	})
+}
+
+// IsEndpointHostname checks if the hostname is the exact root or alias name
+// of an enabled proxy endpoint
+func (router *Router) IsEndpointHostname(hostname string) bool {
+	return router.matchEndpointHostname(hostname, func(ept *ProxyEndpoint) bool {
+		return true
+	})
+}
+
+// matchEndpointHostname checks if the hostname is the exact, non-wildcard
+// root or alias name of an enabled endpoint accepted by the filter
+func (router *Router) matchEndpointHostname(hostname string, filter func(ept *ProxyEndpoint) bool) bool {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if hostname == "" {
+		return false
+	}
+
+	allowed := false
+	router.ProxyEndpoints.Range(func(k, v interface{}) bool {
+		ept := v.(*ProxyEndpoint)
+		if ept.Disabled || !filter(ept) {
+			return true
+		}
+
+		names := append([]string{ept.RootOrMatchingDomain}, ept.MatchingDomainAlias...)
+		for _, name := range names {
+			if strings.ContainsAny(name, "*?[") {
+				continue
+			}
+			if strings.EqualFold(name, hostname) {
+				allowed = true
+				return false
+			}
+		}
+		return true
+	})
+	return allowed
+}
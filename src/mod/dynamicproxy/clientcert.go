@@ -0,0 +1,102 @@
+package dynamicproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
+)
+
+/*
+	clientcert.go
+
+	This script handle the mutual TLS client certificate verification
+	of proxy endpoints. The TLS listener request a client certificate
+	only for server names that matched an endpoint with mTLS enabled
+*/
+
+// getTlsConfigForClient return a function that select the TLS config by the server name of the client hello
+func (router *Router) getTlsConfigForClient(baseConfig *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(helloInfo *tls.ClientHelloInfo) (*tls.Config, error) {
+		//Answer TLS-ALPN-01 validations with the pending challenge certificate
+		if router.Option.TlsManager != nil {
+			if challengeConfig := router.Option.TlsManager.GetACMEChallengeConfig(helloInfo); challengeConfig != nil {
+				return challengeConfig, nil
+			}
+		}
+
+		store := router.Option.ClientCertStore
+		if store == nil || helloInfo.ServerName == "" {
+			return nil, nil
+		}
+
+		sep := router.GetProxyEndpointFromHostname(helloInfo.ServerName)
+		if sep == nil || sep.Disabled || sep.MutualTLS == nil || !sep.MutualTLS.Enabled {
+			//Use the default TLS config
+			return nil, nil
+		}
+
+		config := baseConfig.Clone()
+		config.GetConfigForClient = nil
+		//ServeTLS only set the ALPN protocols on its own copy of the base config
+		config.NextProtos = []string{"http/1.1"}
+		if !router.Option.DisableHttp2 {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+
+		err := store.ApplyToTLSConfig(config, sep.MutualTLS)
+		if err != nil {
+			router.Option.Logger.PrintAndLog("mtls", "Unable to load client CA for "+helloInfo.ServerName, err)
+			if sep.MutualTLS.VerifyMode == mtls.VerifyModeRequired {
+				//Fail closed when client certificate is required
+				return nil, errors.New("client certificate verification unavailable")
+			}
+			return nil, nil
+		}
+		return config, nil
+	}
+}
+
+// handleMutualTLS check the client certificate of the request, return true if the request is handled
+func (router *Router) handleMutualTLS(w http.ResponseWriter, r *http.Request, sep *ProxyEndpoint, domainOnly string) bool {
+	if sep.MutualTLS == nil || !sep.MutualTLS.Enabled {
+		return false
+	}
+
+	if r.TLS != nil && router.GetProxyEndpointFromHostname(r.TLS.ServerName) != sep {
+		//The connection was set up for another server name (e.g. HTTP/2 connection reuse)
+		//and the client certificate was not verified for this endpoint
+		http.Error(w, "421 - Misdirected Request", http.StatusMisdirectedRequest)
+		router.logRequest(r, false, http.StatusMisdirectedRequest, "mtls-misdirected", domainOnly, "", sep)
+		return true
+	}
+
+	if sep.MutualTLS.VerifyMode == mtls.VerifyModeRequired && mtls.GetVerifiedClientCert(r) == nil {
+		serveProxyRequestError(w, http.StatusForbidden, router, ErrorTemplateForbidden)
+		router.logRequest(r, false, http.StatusForbidden, "mtls-required", domainOnly, "", sep)
+		return true
+	}
+
+	if sep.MutualTLS.ForwardCertHeaders {
+		mtls.SetUpstreamHeaders(r)
+	}
+	return false
+}
+
+/* Client Certificate Auth */
+// handleClientCertAuth check the verified client certificate against the endpoint allowlists
+// do not write to http.ResponseWriter if err return is not nil (already handled by this function)
+func handleClientCertAuth(w http.ResponseWriter, r *http.Request, pe *ProxyEndpoint) error {
+	cert := mtls.GetVerifiedClientCert(r)
+	if cert == nil {
+		http.Error(w, "401 - Client certificate required", http.StatusUnauthorized)
+		return errors.New("client certificate required")
+	}
+
+	if !mtls.MatchAllowlist(cert, pe.AuthenticationProvider.ClientCertSubjectAllowlist, pe.AuthenticationProvider.ClientCertSANAllowlist) {
+		http.Error(w, "403 - Client certificate not allowed", http.StatusForbidden)
+		return errors.New("client certificate not in allowlist")
+	}
+	return nil
+}
@@ -97,6 +97,8 @@ type DpcoreOptions struct {
 	MaxConcurrentConnection int           //Maxmium concurrent requests to this server
 	ResponseHeaderTimeout   int64         //Timeout for response header, set to 0 for default
 	DevelopmentMode         bool          //Enable development mode for this proxy core
+
+	UpstreamTLS *UpstreamTLSConfig //Trust and client certificate for HTTPS upstreams, nil for defaults
 }
 
 func NewDynamicProxyCore(target *url.URL, prepender string, dpcOptions *DpcoreOptions) *ReverseProxy {
@@ -120,10 +122,8 @@ func NewDynamicProxyCore(target *url.URL, prepender string, dpcOptions *DpcoreOp
 	useRequestHostAsSNI := false
 	if strings.EqualFold(target.Scheme, "https") {
 		serverName := target.Hostname()
-		cfg := &tls.Config{ServerName: serverName}
-		if dpcOptions.IgnoreTLSVerification {
-			cfg.InsecureSkipVerify = true
-		}
+		cfg := NewUpstreamTLSClientConfig(serverName, dpcOptions.IgnoreTLSVerification, dpcOptions.UpstreamTLS)
+		cfg.ServerName = serverName
 		// A ServerName that is an IP literal is dropped from the TLS ClientHello
 		// by Go (crypto/tls hostnameInSNI, per RFC 6066), leaving the handshake
 		// with no SNI. That breaks SNI-routed HTTPS upstreams addressed by IP
@@ -278,8 +278,8 @@ func newBaseTransport(opts *DpcoreOptions) *http.Transport {
 		tr.ResponseHeaderTimeout = time.Duration(opts.ResponseHeaderTimeout) * time.Millisecond
 	}
 
-	if opts.IgnoreTLSVerification {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if opts.IgnoreTLSVerification || opts.UpstreamTLS != nil {
+		tr.TLSClientConfig = NewUpstreamTLSClientConfig("", opts.IgnoreTLSVerification, opts.UpstreamTLS)
 	}
 
 	return tr
@@ -0,0 +1,109 @@
+package dpcore
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+)
+
+/*
+	upstreamtls.go
+
+	TLS trust and client certificate of HTTPS upstreams. Allow backends
+	signed by a private CA to be verified instead of skipping the check,
+	pin their public keys and present a client certificate for mTLS.
+*/
+
+// UpstreamTLSConfig is the trust and client certificate used for an upstream
+type UpstreamTLSConfig struct {
+	RootCAs              *x509.CertPool                                              //CAs trusted for the upstream certificate, nil for the system roots
+	ServerName           string                                                      //Expected name in the upstream certificate, empty for the SNI or origin hostname
+	PinnedSPKI           []string                                                    //Base64 SHA-256 of accepted public keys, any certificate in the chain may match
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error) //Client certificate presented to the upstream, nil for none
+}
+
+// customVerification checks if the default verification of crypto/tls has to be replaced
+func (c *UpstreamTLSConfig) customVerification() bool {
+	return c.RootCAs != nil || c.ServerName != "" || len(c.PinnedSPKI) > 0
+}
+
+// NewUpstreamTLSClientConfig creates the TLS client config for an upstream.
+// originHostname is verified against when neither an expected server name
+// nor an SNI is set, e.g. for upstreams addressed by IP.
+func NewUpstreamTLSClientConfig(originHostname string, skipVerify bool, upstreamTLS *UpstreamTLSConfig) *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: skipVerify}
+	if upstreamTLS == nil {
+		return cfg
+	}
+	cfg.GetClientCertificate = upstreamTLS.GetClientCertificate
+	if !upstreamTLS.customVerification() || (skipVerify && len(upstreamTLS.PinnedSPKI) == 0) {
+		return cfg
+	}
+
+	//Verify in VerifyConnection so the expected name can differ from the SNI
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("upstream presented no certificate")
+		}
+		chains := [][]*x509.Certificate{cs.PeerCertificates}
+		if !skipVerify {
+			serverName := upstreamTLS.ServerName
+			if serverName == "" {
+				serverName = cs.ServerName
+			}
+			if serverName == "" {
+				serverName = originHostname
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			verifiedChains, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         upstreamTLS.RootCAs,
+				Intermediates: intermediates,
+			})
+			if err != nil {
+				return err
+			}
+			chains = verifiedChains
+		}
+		if len(upstreamTLS.PinnedSPKI) > 0 && !matchPinnedSPKI(chains, upstreamTLS.PinnedSPKI) {
+			return errors.New("upstream certificate does not match any pinned public key")
+		}
+		return nil
+	}
+	return cfg
+}
+
+// SPKIFingerprint returns the base64 SHA-256 of the certificate public key, the format used for pins
+func SPKIFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ValidateSPKIPin checks if the pin is a base64 encoded SHA-256 hash
+func ValidateSPKIPin(pin string) error {
+	hash, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(hash) != sha256.Size {
+		return errors.New("invalid SPKI pin " + pin + ", expecting base64 encoded SHA-256")
+	}
+	return nil
+}
+
+func matchPinnedSPKI(chains [][]*x509.Certificate, pins []string) bool {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			fingerprint := SPKIFingerprint(cert)
+			for _, pin := range pins {
+				if pin == fingerprint {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
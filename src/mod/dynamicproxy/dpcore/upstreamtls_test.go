@@ -0,0 +1,106 @@
+package dpcore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/dpcore"
+)
+
+// newTestClientCertificate creates a self signed client certificate
+func newTestClientCertificate(t *testing.T) *tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "zoraxy-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create client certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestUpstreamTLSClientConfig(t *testing.T) {
+	var presentedClientCert string
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presentedClientCert = ""
+		if len(r.TLS.PeerCertificates) > 0 {
+			presentedClientCert = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	privateCA := x509.NewCertPool()
+	privateCA.AddCert(upstream.Certificate())
+	upstreamPin := dpcore.SPKIFingerprint(upstream.Certificate())
+	otherPin := dpcore.SPKIFingerprint(&x509.Certificate{RawSubjectPublicKeyInfo: []byte("other")})
+	clientCert := newTestClientCertificate(t)
+
+	tests := []struct {
+		name           string
+		skipVerify     bool
+		upstreamTLS    *dpcore.UpstreamTLSConfig
+		wantErr        bool
+		wantClientCert string
+	}{
+		{"system roots reject private CA", false, nil, true, ""},
+		{"private CA bundle", false, &dpcore.UpstreamTLSConfig{RootCAs: privateCA}, false, ""},
+		{"expected server name", false, &dpcore.UpstreamTLSConfig{RootCAs: privateCA, ServerName: "example.com"}, false, ""},
+		{"mismatched server name", false, &dpcore.UpstreamTLSConfig{RootCAs: privateCA, ServerName: "backend.lan"}, true, ""},
+		{"pin without chain verification", true, &dpcore.UpstreamTLSConfig{PinnedSPKI: []string{upstreamPin}}, false, ""},
+		{"pin mismatch", true, &dpcore.UpstreamTLSConfig{PinnedSPKI: []string{otherPin}}, true, ""},
+		{"pin mismatch with valid chain", false, &dpcore.UpstreamTLSConfig{RootCAs: privateCA, PinnedSPKI: []string{otherPin}}, true, ""},
+		{"client certificate", false, &dpcore.UpstreamTLSConfig{
+			RootCAs: privateCA,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return clientCert, nil
+			},
+		}, false, "zoraxy-client"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: dpcore.NewUpstreamTLSClientConfig("127.0.0.1", test.skipVerify, test.upstreamTLS),
+			}}
+			resp, err := client.Get(upstream.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && presentedClientCert != test.wantClientCert {
+				t.Fatalf("upstream saw client certificate %q, want %q", presentedClientCert, test.wantClientCert)
+			}
+		})
+	}
+}
+
+func TestValidateSPKIPin(t *testing.T) {
+	valid := dpcore.SPKIFingerprint(&x509.Certificate{RawSubjectPublicKeyInfo: []byte("key")})
+	if err := dpcore.ValidateSPKIPin(valid); err != nil {
+		t.Errorf("expected %s to be valid: %v", valid, err)
+	}
+	for _, pin := range []string{"", "not base64!", "c2hvcnQ="} {
+		if err := dpcore.ValidateSPKIPin(pin); err == nil {
+			t.Errorf("expected %q to be rejected", pin)
+		}
+	}
+}
@@ -132,6 +132,7 @@ func (router *Router) StartProxyService() error {
 		GetCertificate: router.Option.TlsManager.GetCert,
 		MinVersion:     uint16(minVersion),
 	}
+	config.GetConfigForClient = router.getTlsConfigForClient(config)
 
 	//Start rate limitor
 	err := router.startRateLimterCounterResetTicker()
@@ -310,6 +311,11 @@ func (router *Router) handleNonTLSRequest(w http.ResponseWriter, r *http.Request
 		r.URL, _ = url.Parse(originalHostHeader)
 	}
 
+	//Client certificate (mTLS) Check
+	if router.handleMutualTLS(w, r, sep, originalHostHeader) {
+		return
+	}
+
 	//Access Check (blacklist / whitelist)
 	ruleID := sep.AccessFilterUUID
 	if sep.AccessFilterUUID == "" {
@@ -324,6 +330,11 @@ func (router *Router) handleNonTLSRequest(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// Web Application Firewall
+	if router.handleWAFRouting(w, r, sep, originalHostHeader) {
+		return
+	}
+
 	// Rate Limit
 	if sep.RequireRateLimit {
 		if err := router.handleRateLimit(w, r, sep); err != nil {
@@ -380,7 +391,7 @@ func (router *Router) handleNonTLSRequest(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	selectedUpstream, err := router.loadBalancer.GetRequestUpstreamTarget(w, r, sep.ActiveOrigins, sep.UseStickySession, sep.DisableAutoFallback)
+	selectedUpstream, err := router.loadBalancer.GetRequestUpstreamTarget(w, r, sep.ActiveOrigins, sep.UseStickySession, sep.DisableAutoFallback, sep.UseGeoProximity)
 	if err != nil {
 		serveProxyRequestError(w, 404, router, ErrorTemplateHostError)
 		router.Option.Logger.PrintAndLog("dprouter", "failed to get upstream for hostname", err)
@@ -7,6 +7,7 @@ import (
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	"imuslab.com/zoraxy/mod/dynamicproxy/dpcore"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
 	"imuslab.com/zoraxy/mod/dynamicproxy/rewrite"
 )
@@ -237,7 +238,15 @@ func (ep *ProxyEndpoint) AddUpstreamOrigin(newOrigin *loadbalance.Upstream, acti
 
 	if activate {
 		//Add it to the active origin list
-		err := newOrigin.StartProxy()
+		var upstreamTLS *dpcore.UpstreamTLSConfig
+		if ep.parent != nil {
+			var err error
+			upstreamTLS, err = ep.parent.resolveUpstreamTLS(newOrigin)
+			if err != nil {
+				return err
+			}
+		}
+		err := newOrigin.StartProxy(upstreamTLS)
 		if err != nil {
 			return err
 		}
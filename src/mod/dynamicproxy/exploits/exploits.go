@@ -29,7 +29,8 @@ const (
 	ExploitRequestResponseTypeForbidden                                         //Respond with 403 Forbidden
 	ExploitRequestResponseTypeBadRequest                                        //Respond with 400 Bad Request
 	ExploitRequestResponseTypeDropConnection                                    //Drop the connection without responding
-	ExploitRequestResponseTypeCaptcha                                           //Present a captcha challenge
+	ExploitRequestResponseTypeDelay                                             //Delay the response (reserved, respond with 403 Forbidden)
+	ExploitRequestResponseTypeCaptcha                                           //Present a captcha challenge, handled by the proxy router
 )
 
 type Detector struct {
@@ -49,19 +50,33 @@ func NewExploitDetector(CheckCommonExploits bool, CheckAiBots bool, ExploitRespT
 // CheckIsAttack checks if the request is an attack based on common exploits
 // return true if the request is handled
 func (d *Detector) CheckIsAttack(w http.ResponseWriter, r *http.Request) bool {
-	path := r.URL.Path
-	if strings.HasPrefix(path, "/.well-known/") {
-		return false
-	}
-	if d.CheckCommonExploits && d.RequestContainCommonExploits(r) {
-		return d.handleExploitResponse(w, r, d.ExploitRespType)
-	}
-	if d.CheckAiBots && d.RequestIsMadeByAiCrawlerOrBots(r) {
+	if d.IsAttack(r) {
 		return d.handleExploitResponse(w, r, d.ExploitRespType)
 	}
 	return false
 }
 
+// IsAttack checks if the request is an attack without writing any response
+func (d *Detector) IsAttack(r *http.Request) bool {
+	return d.IsExploit(r) || d.IsCrawler(r)
+}
+
+// IsExploit checks if the request matches the enabled common exploit patterns
+func (d *Detector) IsExploit(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/.well-known/") {
+		return false
+	}
+	return d.CheckCommonExploits && d.RequestContainCommonExploits(r)
+}
+
+// IsCrawler checks if the request is made by an AI crawler or bot and crawler blocking is enabled
+func (d *Detector) IsCrawler(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/.well-known/") {
+		return false
+	}
+	return d.CheckAiBots && d.RequestIsMadeByAiCrawlerOrBots(r)
+}
+
 // GetResponseStatusCodeFromResponseType converts the response type to HTTP status code
 func (d *Detector) GetResponseStatusCode() int {
 	respType := d.ExploitRespType
@@ -82,7 +97,9 @@ func (d *Detector) handleExploitResponse(w http.ResponseWriter, r *http.Request,
 	switch respType {
 	case ExploitRequestResponseTypeNotFound:
 		http.NotFound(w, r)
-	case ExploitRequestResponseTypeForbidden:
+	case ExploitRequestResponseTypeForbidden, ExploitRequestResponseTypeDelay, ExploitRequestResponseTypeCaptcha:
+		//Captcha challenges need the endpoint CAPTCHA settings and are served by the
+		//proxy router, fallback to 403 if the detector is used directly
 		http.Error(w, "Forbidden", http.StatusForbidden)
 	case ExploitRequestResponseTypeBadRequest:
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -95,9 +112,6 @@ func (d *Detector) handleExploitResponse(w http.ResponseWriter, r *http.Request,
 				conn.Close()
 			}
 		}
-
-	case ExploitRequestResponseTypeCaptcha:
-		// Present a captcha challenge
 	}
 	return isHandled
 }
@@ -0,0 +1,43 @@
+package dynamicproxy
+
+import (
+	"net/http"
+)
+
+/*
+	firewall.go
+
+	This script handle the web application firewall (WAF) inspection
+	of requests matched to a proxy endpoint. See mod/dynamicproxy/waf
+	for the rule engine
+*/
+
+// handleWAFRouting inspect the request with the WAF engine, return true if the request is blocked
+func (router *Router) handleWAFRouting(w http.ResponseWriter, r *http.Request, sep *ProxyEndpoint, domainOnly string) bool {
+	wafEngine := router.Option.WAFEngine
+	if wafEngine == nil || sep.WAFConfig == nil || !sep.WAFConfig.Enabled {
+		return false
+	}
+
+	result := wafEngine.Inspect(r, sep.WAFConfig, sep.RootOrMatchingDomain)
+	if result == nil || !result.Blocked {
+		return false
+	}
+
+	if result.Drop {
+		//Drop the connection without responding
+		if hj, ok := w.(http.Hijacker); ok {
+			conn, _, err := hj.Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	} else {
+		w.Header().Set("X-Zoraxy-Waf-Transaction", result.TransactionID)
+		serveProxyRequestError(w, http.StatusForbidden, router, ErrorTemplateForbidden)
+	}
+
+	//Details of the matched rules are written to the WAF audit log
+	router.logRequest(r, false, http.StatusForbidden, "waf-blocked", domainOnly, "blocked", sep)
+	return true
+}
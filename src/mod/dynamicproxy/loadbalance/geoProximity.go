@@ -0,0 +1,126 @@
+package loadbalance
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/geodb"
+)
+
+/*
+	Geo Proximity
+
+	This script contains the code to pick the upstream that is
+	geographically closest to the requester. Require a city level
+	MMDB to be loaded in geodb, otherwise the location of the
+	requester is unknown and the default weighted random picker is used
+*/
+
+const earthRadiusKm = 6371.0
+
+// getNearestUpstream return the upstream closest to the requester, the index value and any error
+func (m *RouteManager) getNearestUpstream(r *http.Request, upstreams []*Upstream) (*Upstream, int, error) {
+	if m.Options.Geodb == nil || !m.Options.Geodb.HasMMDB() {
+		return nil, -1, errors.New("geo proximity require a MMDB to be loaded")
+	}
+
+	requesterLocation := m.Options.Geodb.GetRequesterLocation(r)
+	if !requesterLocation.HasCoordinates {
+		return nil, -1, errors.New("unable to resolve requester location")
+	}
+
+	nearestIndex := -1
+	nearestDistance := math.MaxFloat64
+	for index, upstream := range upstreams {
+		if upstream.Weight <= 0 {
+			//Fallback only upstreams are not considered
+			continue
+		}
+
+		lat, lon, ok := m.getUpstreamCoordinates(upstream)
+		if !ok {
+			continue
+		}
+
+		distance := haversineDistance(requesterLocation.Latitude, requesterLocation.Longitude, lat, lon)
+		if distance < nearestDistance {
+			nearestDistance = distance
+			nearestIndex = index
+		}
+	}
+
+	if nearestIndex < 0 {
+		return nil, -1, errors.New("no upstream with known location")
+	}
+	return upstreams[nearestIndex], nearestIndex, nil
+}
+
+// getUpstreamCoordinates return the coordinates of the upstream. Manually configured
+// coordinates take priority, otherwise it is resolved from the upstream IP and cached
+func (m *RouteManager) getUpstreamCoordinates(upstream *Upstream) (float64, float64, bool) {
+	if upstream.Latitude != 0 || upstream.Longitude != 0 {
+		return upstream.Latitude, upstream.Longitude, true
+	}
+
+	if cached, ok := m.upstreamLocationCache.Load(upstream.OriginIpOrDomain); ok {
+		location := cached.(*geodb.LocationInfo)
+		return location.Latitude, location.Longitude, location.HasCoordinates
+	}
+
+	location := &geodb.LocationInfo{}
+	upstreamIP := resolveUpstreamIP(upstream.OriginIpOrDomain)
+	if upstreamIP != "" {
+		resolvedLocation, err := m.Options.Geodb.ResolveLocationFromIP(upstreamIP)
+		if err == nil {
+			location = resolvedLocation
+		}
+	}
+
+	//Cache the result even if not found to prevent resolving on every request
+	m.upstreamLocationCache.Store(upstream.OriginIpOrDomain, location)
+	return location.Latitude, location.Longitude, location.HasCoordinates
+}
+
+// resolveUpstreamIP return the first IP address of the upstream host
+func resolveUpstreamIP(originIpOrDomain string) string {
+	host := originIpOrDomain
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return ""
+		}
+		host = u.Host
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return ""
+	}
+	return ips[0].String()
+}
+
+// haversineDistance return the great circle distance in km between two coordinates
+func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,34 @@
+package loadbalance
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineDistance(t *testing.T) {
+	//London to Paris is about 344 km
+	distance := haversineDistance(51.5074, -0.1278, 48.8566, 2.3522)
+	if math.Abs(distance-344) > 5 {
+		t.Errorf("expected distance around 344km, got %.2f", distance)
+	}
+
+	if haversineDistance(22.3193, 114.1694, 22.3193, 114.1694) != 0 {
+		t.Errorf("expected zero distance for identical coordinates")
+	}
+}
+
+func TestResolveUpstreamIP(t *testing.T) {
+	testcases := map[string]string{
+		"192.168.1.100:8080":         "192.168.1.100",
+		"10.0.0.1":                   "10.0.0.1",
+		"https://172.16.0.5:8443":    "172.16.0.5",
+		"[2001:db8::1]:443":          "2001:db8::1",
+		"http://[2001:db8::2]:8080/": "2001:db8::2",
+	}
+
+	for origin, expected := range testcases {
+		if ip := resolveUpstreamIP(origin); ip != expected {
+			t.Errorf("expected %s for %s, got %s", expected, origin, ip)
+		}
+	}
+}
@@ -30,8 +30,9 @@ type RouteManager struct {
 	OnlineStatus sync.Map //Store the online status notify by uptime monitor
 	Options      Options  //Options for the load balancer
 
-	cacheTicker     *time.Ticker //Ticker for cache cleanup
-	cacheTickerStop chan bool    //Stop the cache cleanup
+	upstreamLocationCache sync.Map     //Resolved upstream location for geo proximity, OriginIpOrDomain -> *geodb.LocationInfo
+	cacheTicker           *time.Ticker //Ticker for cache cleanup
+	cacheTickerStop       chan bool    //Stop the cache cleanup
 }
 
 /* Upstream or Origin Server */
@@ -42,15 +43,24 @@ type Upstream struct {
 	SkipCertValidations      bool   //Set to true to accept self signed certs
 	SkipWebSocketOriginCheck bool   //Skip origin check on websocket upgrade connections
 
+	//Upstream TLS Configs
+	TLSCABundle   string   //CA bundle in the mTLS store trusted for the upstream certificate, empty for system roots
+	TLSServerName string   //Expected name in the upstream certificate, empty for the origin hostname
+	TLSPinnedSPKI []string //Base64 SHA-256 of accepted upstream public keys
+	TLSClientCert string   //Certificate name in the cert store presented to the upstream for mTLS
+
 	//Load balancing configs
-	Weight int //Random weight for round robin, 0 for fallback only
+	Weight    int     //Random weight for round robin, 0 for fallback only
+	Latitude  float64 //Location of the upstream for geo proximity load balancing, 0 to resolve from upstream IP
+	Longitude float64
 
 	//HTTP Transport Config
 	MaxConn     int   //Maxmium concurrent requests to this upstream dpcore instance
 	RespTimeout int64 //Response header timeout in milliseconds
 
 	//currentConnectionCounts atomic.Uint64 //Counter for number of client currently connected
-	proxy *dpcore.ReverseProxy
+	proxy       *dpcore.ReverseProxy
+	upstreamTLS *dpcore.UpstreamTLSConfig
 }
 
 // Create a new load balancer
@@ -63,6 +73,14 @@ func NewLoadBalancer(options *Options) *RouteManager {
 	//Create a ticker for cache cleanup every 12 hours
 	cacheTicker := time.NewTicker(12 * time.Hour)
 	cacheTickerStop := make(chan bool)
+	thisRouteManager := &RouteManager{
+		OnlineStatus: sync.Map{},
+		Options:      *options,
+
+		cacheTicker:     cacheTicker,
+		cacheTickerStop: cacheTickerStop,
+	}
+
 	go func() {
 		options.Logger.PrintAndLog("LoadBalancer", "Upstream state cache ticker started", nil)
 		for {
@@ -72,20 +90,14 @@ func NewLoadBalancer(options *Options) *RouteManager {
 			case <-cacheTicker.C:
 				//Clean up the cache
 				options.Logger.PrintAndLog("LoadBalancer", "Cleaning up upstream state cache", nil)
+				thisRouteManager.upstreamLocationCache.Clear()
 			}
 		}
 	}()
 
 	//Generate a session store for stickySession
-	store := sessions.NewCookieStore([]byte(options.SystemUUID))
-	return &RouteManager{
-		SessionStore: store,
-		OnlineStatus: sync.Map{},
-		Options:      *options,
-
-		cacheTicker:     cacheTicker,
-		cacheTickerStop: cacheTickerStop,
-	}
+	thisRouteManager.SessionStore = sessions.NewCookieStore([]byte(options.SystemUUID))
+	return thisRouteManager
 }
 
 // UpstreamsReady checks if the group of upstreams contains at least one
@@ -19,11 +19,18 @@ const (
 
 // GetRequestUpstreamTarget return the upstream target where this
 // request should be routed
-func (m *RouteManager) GetRequestUpstreamTarget(w http.ResponseWriter, r *http.Request, origins []*Upstream, useStickySession bool, disableAutoFallback bool) (*Upstream, error) {
+// Set useGeoProximity to pick the upstream closest to the requester when a city level MMDB is loaded
+func (m *RouteManager) GetRequestUpstreamTarget(w http.ResponseWriter, r *http.Request, origins []*Upstream, useStickySession bool, disableAutoFallback bool, useGeoProximity bool) (*Upstream, error) {
 	if len(origins) == 0 {
 		return nil, errors.New("no upstream is defined for this host")
 	}
 
+	//Skip the upstreams that failed to start, e.g. when their TLS options cannot be loaded
+	origins = filterReadyOrigins(origins)
+	if len(origins) == 0 {
+		return nil, errors.New("no upstream is ready for origin: " + r.Host)
+	}
+
 	//Pick the origin
 	if useStickySession {
 		//Use stick session, check which origins this request previously used
@@ -37,8 +44,8 @@ func (m *RouteManager) GetRequestUpstreamTarget(w http.ResponseWriter, r *http.R
 				return nil, errors.New("no online upstream is available for origin: " + r.Host)
 			}
 
-			//Get a random origin
-			targetOrigin, index, err := getRandomUpstreamByWeight(origins)
+			//Get the nearest origin if geo proximity is enabled, otherwise a random origin
+			targetOrigin, index, err := m.pickUpstream(r, origins, useGeoProximity)
 			if err != nil {
 				m.println("Unable to get random upstream", err)
 				targetOrigin = origins[0]
@@ -63,8 +70,8 @@ func (m *RouteManager) GetRequestUpstreamTarget(w http.ResponseWriter, r *http.R
 		return nil, errors.New("no online upstream is available for origin: " + r.Host)
 	}
 
-	//Get a random origin
-	targetOrigin, _, err := getRandomUpstreamByWeight(origins)
+	//Get the nearest origin if geo proximity is enabled, otherwise a random origin
+	targetOrigin, _, err := m.pickUpstream(r, origins, useGeoProximity)
 	if err != nil {
 		m.println("Failed to get next origin", err)
 		targetOrigin = origins[0]
@@ -76,11 +83,23 @@ func (m *RouteManager) GetRequestUpstreamTarget(w http.ResponseWriter, r *http.R
 
 // GetUsableUpstreamCounts return the number of usable upstreams
 func (m *RouteManager) GetUsableUpstreamCounts(origins []*Upstream, disableAutoFallback bool) int {
+	origins = filterReadyOrigins(origins)
 	originalUpstreamCount := len(origins)
 	origins = m.FilterOfflineOrigins(origins, originalUpstreamCount, disableAutoFallback)
 	return len(origins)
 }
 
+// filterReadyOrigins returns the upstreams with a running proxy
+func filterReadyOrigins(origins []*Upstream) []*Upstream {
+	readyOrigins := []*Upstream{}
+	for _, origin := range origins {
+		if origin.IsReady() {
+			readyOrigins = append(readyOrigins, origin)
+		}
+	}
+	return readyOrigins
+}
+
 /* Features related to session access */
 //Set a new origin for this connection by session
 func (m *RouteManager) setSessionHandler(w http.ResponseWriter, r *http.Request, originIpOrDomain string, index int) error {
@@ -133,6 +152,18 @@ func (m *RouteManager) getSessionHandler(r *http.Request, upstreams []*Upstream)
 	return -1, errors.New("origin is no longer exists")
 }
 
+// pickUpstream pick the nearest upstream if geo proximity is enabled and the location
+// of the requester is known, otherwise fallback to random upstream by weight
+func (m *RouteManager) pickUpstream(r *http.Request, upstreams []*Upstream, useGeoProximity bool) (*Upstream, int, error) {
+	if useGeoProximity && len(upstreams) > 1 {
+		targetOrigin, index, err := m.getNearestUpstream(r, upstreams)
+		if err == nil {
+			return targetOrigin, index, nil
+		}
+	}
+	return getRandomUpstreamByWeight(upstreams)
+}
+
 /* Functions related to random upstream picking */
 // Get a random upstream by the weights defined in Upstream struct, return the upstream, index value and any error
 func getRandomUpstreamByWeight(upstreams []*Upstream) (*Upstream, int, error) {
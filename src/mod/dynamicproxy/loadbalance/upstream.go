@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -13,7 +14,8 @@ import (
 
 // StartProxy create and start a HTTP proxy using dpcore
 // Example of webProxyEndpoint: https://example.com:443 or http://192.168.1.100:8080
-func (u *Upstream) StartProxy() error {
+// upstreamTLS is the resolved trust and client certificate, nil for defaults
+func (u *Upstream) StartProxy(upstreamTLS *dpcore.UpstreamTLSConfig) error {
 	//Filter the tailing slash if any
 	domain := u.OriginIpOrDomain
 	if len(domain) == 0 {
@@ -43,12 +45,39 @@ func (u *Upstream) StartProxy() error {
 		FlushInterval:           100 * time.Millisecond,
 		ResponseHeaderTimeout:   u.RespTimeout,
 		MaxConcurrentConnection: u.MaxConn,
+		UpstreamTLS:             upstreamTLS,
 	})
 
 	u.proxy = proxy
+	u.upstreamTLS = upstreamTLS
 	return nil
 }
 
+// StopProxy removes the proxy of the upstream so the load balancer stops routing to it,
+// e.g. when its TLS options can no longer be loaded
+func (u *Upstream) StopProxy() {
+	u.proxy = nil
+	u.upstreamTLS = nil
+}
+
+// HasCustomTLS check if the upstream has any TLS trust or client certificate option set
+func (u *Upstream) HasCustomTLS() bool {
+	return u.TLSCABundle != "" || u.TLSServerName != "" || len(u.TLSPinnedSPKI) > 0 || u.TLSClientCert != ""
+}
+
+// TLSClientConfig return the TLS client config for connections to this
+// upstream outside of the proxy core, e.g. websocket, nil for defaults
+func (u *Upstream) TLSClientConfig() *tls.Config {
+	if u.upstreamTLS == nil {
+		return nil
+	}
+	originHostname := u.OriginIpOrDomain
+	if parsed, err := url.Parse("//" + strings.TrimPrefix(strings.TrimPrefix(originHostname, "https://"), "http://")); err == nil {
+		originHostname = parsed.Hostname()
+	}
+	return dpcore.NewUpstreamTLSClientConfig(originHostname, u.SkipCertValidations, u.upstreamTLS)
+}
+
 // IsReady return the proxy ready state of the upstream server
 // Return false if StartProxy() is not called on this upstream before
 func (u *Upstream) IsReady() bool {
@@ -0,0 +1,54 @@
+package dynamicproxy
+
+import (
+	"net/http"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/captcha"
+)
+
+/*
+	mitigation.go
+
+	This script handle the CAPTCHA mitigation action of the exploit
+	and AI crawler detector. Instead of rejecting crawler requests, the
+	client is asked to solve a CAPTCHA and can continue once verified.
+	A solved CAPTCHA only proves a human is behind the browser, requests
+	matching the exploit patterns are still blocked
+*/
+
+// getMitigationCaptchaConfig return the CAPTCHA settings of the endpoint if configured,
+// otherwise the self-hosted proof-of-work challenge is used
+func (ep *ProxyEndpoint) getMitigationCaptchaConfig() *captcha.Config {
+	if ep.RequireCaptcha && ep.CaptchaConfig.IsConfigured() {
+		return ep.CaptchaConfig
+	}
+	return captcha.DefaultProofOfWorkConfig()
+}
+
+// handleCaptchaMitigation block exploit requests and serve a CAPTCHA challenge to detected
+// crawler requests without a verified session, return true if the request is handled
+func (router *Router) handleCaptchaMitigation(w http.ResponseWriter, r *http.Request, sep *ProxyEndpoint, domainOnly string) bool {
+	config := sep.getMitigationCaptchaConfig()
+	if r.URL.Path == captcha.VerifyPath {
+		captcha.HandleVerification(w, r, config, router.captchaSessionStore)
+		return true
+	}
+
+	if sep.detector.IsExploit(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		router.logRequest(r, false, http.StatusForbidden, "exploit-blocked", domainOnly, "blocked", sep)
+		return true
+	}
+
+	if !sep.detector.IsCrawler(r) || captcha.CheckSession(r, router.captchaSessionStore) {
+		return false
+	}
+
+	domain := r.Host
+	if domain == "" {
+		domain = sep.RootOrMatchingDomain
+	}
+	captcha.RenderChallenge(w, r, config, domain, router.Option.WebDirectory)
+	router.logRequest(r, false, http.StatusForbidden, "crawler-captcha", domainOnly, "captcha", sep)
+	return true
+}
@@ -0,0 +1,46 @@
+package dynamicproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/captcha"
+	"imuslab.com/zoraxy/mod/dynamicproxy/exploits"
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+func TestCaptchaMitigationKeepsBlockingExploits(t *testing.T) {
+	fmtLogger, _ := logger.NewFmtLogger()
+	router := &Router{
+		Option:              &RouterOption{Logger: fmtLogger},
+		captchaSessionStore: captcha.NewSessionStore(),
+	}
+	t.Cleanup(router.captchaSessionStore.Close)
+	router.captchaSessionStore.AddSession("solved", 3600)
+
+	sep := &ProxyEndpoint{
+		RootOrMatchingDomain: "a.example.com",
+		DisableLogging:       true,
+		detector:             exploits.NewExploitDetector(true, true, exploits.ExploitRequestResponseTypeCaptcha),
+	}
+
+	request := func(target string, userAgent string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("User-Agent", userAgent)
+		r.AddCookie(&http.Cookie{Name: captcha.CookieName, Value: "solved"})
+		return r
+	}
+
+	//A solved captcha lets crawlers through
+	w := httptest.NewRecorder()
+	if router.handleCaptchaMitigation(w, request("/", "GPTBot/1.0"), sep, "a.example.com") {
+		t.Fatalf("expected verified crawler request to be proxied, got %d", w.Code)
+	}
+
+	//But exploit requests are still blocked
+	w = httptest.NewRecorder()
+	if !router.handleCaptchaMitigation(w, request("/?id=1+union+select+password(", "Mozilla/5.0"), sep, "a.example.com") || w.Code != http.StatusForbidden {
+		t.Fatalf("expected exploit request to be blocked, got %d", w.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+	clientcert.go
+
+	Helper functions for the verified client certificate of a request,
+	including the TLS handshake settings, the upstream headers and the
+	subject / SAN allowlist used by the client certificate auth method
+*/
+
+const (
+	HeaderSubject     = "X-Client-Cert-Subject"
+	HeaderIssuer      = "X-Client-Cert-Issuer"
+	HeaderSerial      = "X-Client-Cert-Serial"
+	HeaderFingerprint = "X-Client-Cert-Fingerprint" //SHA-256 of the DER encoded certificate
+	HeaderSAN         = "X-Client-Cert-San"
+	HeaderNotAfter    = "X-Client-Cert-Not-After"
+	HeaderVerify      = "X-Client-Cert-Verify" //SUCCESS or NONE
+)
+
+var forwardedHeaders = []string{HeaderSubject, HeaderIssuer, HeaderSerial, HeaderFingerprint, HeaderSAN, HeaderNotAfter, HeaderVerify}
+
+// ApplyToTLSConfig set the client certificate verification of the TLS config
+func (s *Store) ApplyToTLSConfig(tlsConfig *tls.Config, config *Config) error {
+	trust, err := s.LoadTrust(config)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig.ClientCAs = trust.ClientCAs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if config.VerifyMode == VerifyModeRequired {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		//Fail closed, revocation of a given certificate cannot be checked against an outdated CRL
+		if (len(cs.VerifiedChains) > 0 || config.VerifyMode == VerifyModeRequired) && trust.CRLExpired() {
+			return ErrCRLExpired
+		}
+		return trust.CheckRevocation(cs.VerifiedChains)
+	}
+	return nil
+}
+
+// GetVerifiedClientCert return the verified client certificate of the request, nil if not exists
+func GetVerifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// GetFingerprint return the hex encoded SHA-256 fingerprint of the certificate
+func GetFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// GetSANs return all subject alternative names of the certificate
+func GetSANs(cert *x509.Certificate) []string {
+	sans := []string{}
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// SetUpstreamHeaders replace any client supplied X-Client-Cert-* headers with
+// the details of the verified client certificate
+func SetUpstreamHeaders(r *http.Request) {
+	for _, header := range forwardedHeaders {
+		r.Header.Del(header)
+	}
+
+	cert := GetVerifiedClientCert(r)
+	if cert == nil {
+		r.Header.Set(HeaderVerify, "NONE")
+		return
+	}
+
+	r.Header.Set(HeaderVerify, "SUCCESS")
+	r.Header.Set(HeaderSubject, cert.Subject.String())
+	r.Header.Set(HeaderIssuer, cert.Issuer.String())
+	r.Header.Set(HeaderSerial, cert.SerialNumber.String())
+	r.Header.Set(HeaderFingerprint, GetFingerprint(cert))
+	r.Header.Set(HeaderNotAfter, cert.NotAfter.UTC().Format(time.RFC3339))
+	if sans := GetSANs(cert); len(sans) > 0 {
+		r.Header.Set(HeaderSAN, strings.Join(sans, ","))
+	}
+}
+
+// wildcardMatch match the value against a case insensitive pattern where * match any characters
+func wildcardMatch(pattern string, value string) bool {
+	if !strings.Contains(pattern, "*") {
+		return strings.EqualFold(pattern, value)
+	}
+	expr := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(expr, value)
+	return err == nil && matched
+}
+
+// MatchAllowlist check if the certificate subject DN, subject CN or any SAN matches
+// the allowlists. Empty allowlists accept any verified certificate
+func MatchAllowlist(cert *x509.Certificate, subjectAllowlist []string, sanAllowlist []string) bool {
+	if len(subjectAllowlist) == 0 && len(sanAllowlist) == 0 {
+		return true
+	}
+
+	subject := cert.Subject.String()
+	for _, pattern := range subjectAllowlist {
+		if wildcardMatch(pattern, subject) || wildcardMatch(pattern, cert.Subject.CommonName) {
+			return true
+		}
+	}
+
+	for _, san := range GetSANs(cert) {
+		for _, pattern := range sanAllowlist {
+			if wildcardMatch(pattern, san) {
+				return true
+			}
+		}
+	}
+	return false
+}
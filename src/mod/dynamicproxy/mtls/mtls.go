@@ -0,0 +1,299 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	mtls.go
+
+	Mutual TLS client certificate verification for proxy endpoints.
+	CA bundles (.pem / .crt) and certificate revocation lists (.crl)
+	are uploaded into the store folder and referenced by file name
+	from the endpoint config
+*/
+
+var ErrCRLExpired = errors.New("certificate revocation list has passed its next update time")
+
+type VerifyMode int
+
+const (
+	VerifyModeOptional VerifyMode = iota //Request a client certificate and verify it if given
+	VerifyModeRequired                   //Reject the connection if no valid client certificate is given
+)
+
+type Config struct {
+	Enabled            bool
+	VerifyMode         VerifyMode
+	CABundle           string //File name of the CA bundle in the store
+	CRLFile            string //File name of the CRL in the store, optional
+	ForwardCertHeaders bool   //Forward the verified certificate details to upstream in X-Client-Cert-* headers
+}
+
+// Trust is the parsed CA bundle and revocation list of an endpoint
+type Trust struct {
+	ClientCAs *x509.CertPool
+	revoked   map[string]bool //issuer raw subject + serial number
+	crlExpiry time.Time
+}
+
+type loadedFile struct {
+	modTime time.Time
+	trust   *Trust
+}
+
+type Store struct {
+	Folder string
+
+	cache sync.Map //map[CABundle|CRLFile]*loadedFile
+}
+
+// NewStore create a new mTLS store in the given folder
+func NewStore(folder string) (*Store, error) {
+	err := os.MkdirAll(folder, 0775)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Folder: folder,
+	}, nil
+}
+
+func isBundleFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".pem" || ext == ".crt"
+}
+
+func isCRLFile(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == ".crl"
+}
+
+// resolveFilename validate the file name and return its path in the store
+func (s *Store) resolveFilename(filename string) (string, error) {
+	if filename == "" || filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+		return "", errors.New("invalid file name")
+	}
+	if !isBundleFile(filename) && !isCRLFile(filename) {
+		return "", errors.New("file must be a .pem, .crt or .crl file")
+	}
+	return filepath.Join(s.Folder, filename), nil
+}
+
+// List return the CA bundles and CRLs in the store
+func (s *Store) List() (bundles []string, crls []string, err error) {
+	bundles = []string{}
+	crls = []string{}
+	entries, err := os.ReadDir(s.Folder)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if isBundleFile(entry.Name()) {
+			bundles = append(bundles, entry.Name())
+		} else if isCRLFile(entry.Name()) {
+			crls = append(crls, entry.Name())
+		}
+	}
+	sort.Strings(bundles)
+	sort.Strings(crls)
+	return bundles, crls, nil
+}
+
+// Save validate and write a CA bundle or CRL into the store
+func (s *Store) Save(filename string, content []byte) error {
+	filePath, err := s.resolveFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	if isBundleFile(filename) {
+		certs, err := parseCertificates(content)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			if !cert.IsCA {
+				return errors.New("certificate " + cert.Subject.String() + " is not a CA certificate")
+			}
+		}
+	} else {
+		if _, err := parseRevocationList(content); err != nil {
+			return err
+		}
+	}
+
+	s.invalidate()
+	return os.WriteFile(filePath, content, 0644)
+}
+
+// Remove delete a CA bundle or CRL from the store
+func (s *Store) Remove(filename string) error {
+	filePath, err := s.resolveFilename(filename)
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	return os.Remove(filePath)
+}
+
+func (s *Store) invalidate() {
+	s.cache.Range(func(key, value interface{}) bool {
+		s.cache.Delete(key)
+		return true
+	})
+}
+
+// LoadTrust load the CA bundle and CRL of the config, cached until the files change
+func (s *Store) LoadTrust(config *Config) (*Trust, error) {
+	bundlePath, err := s.resolveFilename(config.CABundle)
+	if err != nil || !isBundleFile(config.CABundle) {
+		return nil, errors.New("invalid CA bundle")
+	}
+	bundleInfo, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	modTime := bundleInfo.ModTime()
+
+	crlPath := ""
+	if config.CRLFile != "" {
+		crlPath, err = s.resolveFilename(config.CRLFile)
+		if err != nil || !isCRLFile(config.CRLFile) {
+			return nil, errors.New("invalid CRL file")
+		}
+		crlInfo, err := os.Stat(crlPath)
+		if err != nil {
+			return nil, err
+		}
+		if crlInfo.ModTime().After(modTime) {
+			modTime = crlInfo.ModTime()
+		}
+	}
+
+	cacheKey := config.CABundle + "|" + config.CRLFile
+	if cached, ok := s.cache.Load(cacheKey); ok && cached.(*loadedFile).modTime.Equal(modTime) {
+		return cached.(*loadedFile).trust, nil
+	}
+
+	bundleContent, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := parseCertificates(bundleContent)
+	if err != nil {
+		return nil, err
+	}
+
+	trust := &Trust{
+		ClientCAs: x509.NewCertPool(),
+		revoked:   map[string]bool{},
+	}
+	for _, cert := range caCerts {
+		trust.ClientCAs.AddCert(cert)
+	}
+
+	if crlPath != "" {
+		crlContent, err := os.ReadFile(crlPath)
+		if err != nil {
+			return nil, err
+		}
+		crl, err := parseRevocationList(crlContent)
+		if err != nil {
+			return nil, err
+		}
+
+		//Only accept CRLs signed by one of the trusted CAs
+		signed := false
+		for _, cert := range caCerts {
+			if crl.CheckSignatureFrom(cert) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, errors.New("CRL is not signed by any CA in the bundle")
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			trust.revoked[string(crl.RawIssuer)+entry.SerialNumber.String()] = true
+		}
+		trust.crlExpiry = crl.NextUpdate
+	}
+
+	s.cache.Store(cacheKey, &loadedFile{
+		modTime: modTime,
+		trust:   trust,
+	})
+	return trust, nil
+}
+
+// CheckRevocation return an error if any certificate in the verified chains is revoked
+func (t *Trust) CheckRevocation(verifiedChains [][]*x509.Certificate) error {
+	if len(t.revoked) == 0 {
+		return nil
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if t.revoked[string(cert.RawIssuer)+cert.SerialNumber.String()] {
+				return errors.New("client certificate " + cert.SerialNumber.String() + " has been revoked")
+			}
+		}
+	}
+	return nil
+}
+
+// CRLExpired return true if the loaded CRL has passed its next update time
+func (t *Trust) CRLExpired() bool {
+	return !t.crlExpiry.IsZero() && time.Now().After(t.crlExpiry)
+}
+
+// CRLNextUpdate return the next update time of the loaded CRL, zero if no CRL is loaded
+func (t *Trust) CRLNextUpdate() time.Time {
+	return t.crlExpiry
+}
+
+// parseCertificates parse all PEM encoded certificates in the content
+func parseCertificates(content []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// parseRevocationList parse a PEM or DER encoded CRL
+func parseRevocationList(content []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(content); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, errors.New("PEM block is not a X509 CRL")
+		}
+		content = block.Bytes
+	}
+	return x509.ParseRevocationList(content)
+}
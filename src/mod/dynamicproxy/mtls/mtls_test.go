@@ -0,0 +1,255 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) issueClientCert(t *testing.T, serial int64, commonName string, email string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(serial),
+		Subject:        pkix.Name{CommonName: commonName, Organization: []string{"Zoraxy"}},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (ca *testCA) createCRL(t *testing.T, revokedSerials ...int64) []byte {
+	return ca.createCRLWithNextUpdate(t, time.Now().Add(time.Hour), revokedSerials...)
+}
+
+func (ca *testCA) createCRLWithNextUpdate(t *testing.T, nextUpdate time.Time, revokedSerials ...int64) []byte {
+	entries := []x509.RevocationListEntry{}
+	for _, serial := range revokedSerials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                nextUpdate.Add(-2 * time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// newTestServer start a TLS server that verify client certificates with the given config
+func newTestServer(t *testing.T, store *Store, config *Config) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUpstreamHeaders(r)
+		w.Header().Set(HeaderVerify, r.Header.Get(HeaderVerify))
+		w.Header().Set(HeaderSubject, r.Header.Get(HeaderSubject))
+	}))
+	server.TLS = &tls.Config{}
+	if err := store.ApplyToTLSConfig(server.TLS, config); err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(server *httptest.Server, clientCert *tls.Certificate) (*http.Response, error) {
+	transport := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		transport.Certificates = []tls.Certificate{*clientCert}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: transport}}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set(HeaderSubject, "CN=spoofed")
+	return client.Do(req)
+}
+
+func TestStoreSaveAndList(t *testing.T) {
+	ca := newTestCA(t)
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save("ca.pem", ca.pem); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("ca.crl", ca.createCRL(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("../ca.pem", ca.pem); err == nil {
+		t.Error("expected path traversal file name to be rejected")
+	}
+	if err := store.Save("bad.pem", []byte("not a certificate")); err == nil {
+		t.Error("expected invalid bundle to be rejected")
+	}
+	leaf := ca.issueClientCert(t, 2, "client", "client@example.com")
+	if err := store.Save("leaf.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Certificate[0]})); err == nil {
+		t.Error("expected non CA certificate to be rejected")
+	}
+
+	bundles, crls, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles) != 1 || bundles[0] != "ca.pem" || len(crls) != 1 || crls[0] != "ca.crl" {
+		t.Errorf("unexpected store content %v %v", bundles, crls)
+	}
+}
+
+func TestRequiredClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	store, _ := NewStore(t.TempDir())
+	store.Save("ca.pem", ca.pem)
+	store.Save("ca.crl", ca.createCRL(t, 3))
+
+	server := newTestServer(t, store, &Config{Enabled: true, VerifyMode: VerifyModeRequired, CABundle: "ca.pem", CRLFile: "ca.crl"})
+
+	if _, err := doRequest(server, nil); err == nil {
+		t.Error("expected handshake without client certificate to fail")
+	}
+
+	untrusted := otherCA.issueClientCert(t, 2, "intruder", "intruder@example.com")
+	if _, err := doRequest(server, &untrusted); err == nil {
+		t.Error("expected handshake with untrusted client certificate to fail")
+	}
+
+	revoked := ca.issueClientCert(t, 3, "revoked", "revoked@example.com")
+	if _, err := doRequest(server, &revoked); err == nil {
+		t.Error("expected handshake with revoked client certificate to fail")
+	}
+
+	valid := ca.issueClientCert(t, 2, "client", "client@example.com")
+	resp, err := doRequest(server, &valid)
+	if err != nil {
+		t.Fatalf("expected handshake with valid client certificate to pass: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(HeaderVerify) != "SUCCESS" || resp.Header.Get(HeaderSubject) != "CN=client,O=Zoraxy" {
+		t.Errorf("unexpected forwarded headers %v", resp.Header)
+	}
+}
+
+func TestExpiredCRLFailsClosed(t *testing.T) {
+	ca := newTestCA(t)
+	store, _ := NewStore(t.TempDir())
+	store.Save("ca.pem", ca.pem)
+	store.Save("ca.crl", ca.createCRLWithNextUpdate(t, time.Now().Add(-time.Minute)))
+
+	trust, err := store.LoadTrust(&Config{CABundle: "ca.pem", CRLFile: "ca.crl"})
+	if err != nil || !trust.CRLExpired() {
+		t.Fatalf("expected the CRL to be expired, got %v", err)
+	}
+
+	server := newTestServer(t, store, &Config{Enabled: true, VerifyMode: VerifyModeRequired, CABundle: "ca.pem", CRLFile: "ca.crl"})
+	valid := ca.issueClientCert(t, 2, "client", "client@example.com")
+	if _, err := doRequest(server, &valid); err == nil {
+		t.Error("expected handshake to fail while the CRL is expired")
+	}
+}
+
+func TestOptionalClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	store, _ := NewStore(t.TempDir())
+	store.Save("ca.pem", ca.pem)
+
+	server := newTestServer(t, store, &Config{Enabled: true, VerifyMode: VerifyModeOptional, CABundle: "ca.pem"})
+	resp, err := doRequest(server, nil)
+	if err != nil {
+		t.Fatalf("expected handshake without client certificate to pass: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(HeaderVerify) != "NONE" || resp.Header.Get(HeaderSubject) != "" {
+		t.Error("expected spoofed client certificate header to be removed")
+	}
+}
+
+func TestLoadTrustRejectForeignCRL(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	folder := t.TempDir()
+	store, _ := NewStore(folder)
+	store.Save("ca.pem", ca.pem)
+	os.WriteFile(filepath.Join(folder, "other.crl"), otherCA.createCRL(t), 0644)
+
+	if _, err := store.LoadTrust(&Config{CABundle: "ca.pem", CRLFile: "other.crl"}); err == nil {
+		t.Error("expected CRL signed by another CA to be rejected")
+	}
+}
+
+func TestMatchAllowlist(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issueClientCert(t, 2, "alice", "alice@example.com").Leaf
+	cert.URIs = []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/service/api"}}
+
+	testcases := []struct {
+		subjects []string
+		sans     []string
+		expected bool
+	}{
+		{nil, nil, true},
+		{[]string{"CN=alice,O=Zoraxy"}, nil, true},
+		{[]string{"alice"}, nil, true},
+		{[]string{"CN=*,O=Zoraxy"}, nil, true},
+		{[]string{"CN=bob,O=Zoraxy"}, nil, false},
+		{nil, []string{"*@example.com"}, true},
+		{nil, []string{"spiffe://example.com/service/*"}, true},
+		{[]string{"bob"}, []string{"bob@example.com"}, false},
+	}
+	for _, tc := range testcases {
+		if MatchAllowlist(cert, tc.subjects, tc.sans) != tc.expected {
+			t.Errorf("MatchAllowlist(%v, %v): expected %v", tc.subjects, tc.sans, tc.expected)
+		}
+	}
+}
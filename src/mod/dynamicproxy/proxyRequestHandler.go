@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"imuslab.com/zoraxy/mod/dynamicproxy/dpcore"
@@ -161,7 +162,7 @@ func (h *ProxyHandler) hostRequest(w http.ResponseWriter, r *http.Request, targe
 	reqHostname := r.Host
 
 	/* Load balancing */
-	selectedUpstream, err := h.Parent.loadBalancer.GetRequestUpstreamTarget(w, r, target.ActiveOrigins, target.UseStickySession, target.DisableAutoFallback)
+	selectedUpstream, err := h.Parent.loadBalancer.GetRequestUpstreamTarget(w, r, target.ActiveOrigins, target.UseStickySession, target.DisableAutoFallback, target.UseGeoProximity)
 	if err != nil {
 		serveProxyRequestError(w, 521, h.Parent, ErrorTemplateRPError)
 		h.Parent.Option.Logger.PrintAndLog("proxy", "Failed to assign an upstream for this request", err)
@@ -205,6 +206,7 @@ func (h *ProxyHandler) hostRequest(w http.ResponseWriter, r *http.Request, targe
 
 		wspHandler := websocketproxy.NewProxy(u, websocketproxy.Options{
 			SkipTLSValidation:              selectedUpstream.SkipCertValidations,
+			TLSClientConfig:                selectedUpstream.TLSClientConfig(),
 			SkipOriginCheck:                selectedUpstream.SkipWebSocketOriginCheck,
 			CopyAllHeaders:                 target.EnableWebsocketCustomHeaders,
 			UserDefinedHeaders:             target.HeaderRewriteRules.UserDefinedHeaders,
@@ -396,9 +398,16 @@ func (router *Router) logRequest(r *http.Request, succ bool, statusCode int, for
 		// Collect statistic from request
 
 		go func() {
+			requestASN := ""
+			if asn := router.Option.GeodbStore.GetRequesterASN(r); asn != 0 {
+				requestASN = strconv.FormatUint(uint64(asn), 10)
+			}
+			requestLocation := router.Option.GeodbStore.GetRequesterLocation(r)
 			requestInfo := statistic.RequestInfo{
 				IpAddr:                        netutils.GetRequesterIP(r),
-				RequestOriginalCountryISOCode: router.Option.GeodbStore.GetRequesterCountryISOCode(r),
+				RequestOriginalCountryISOCode: requestLocation.CountryIsoCode,
+				RequestASN:                    requestASN,
+				RequestCity:                   requestLocation.City,
 				Succ:                          succ,
 				StatusCode:                    statusCode,
 				ForwardType:                   forwardType,
@@ -2,7 +2,6 @@ package dynamicproxy
 
 import (
 	"errors"
-	"log"
 	"net/url"
 	"strings"
 	"time"
@@ -23,9 +22,16 @@ import (
 func (router *Router) PrepareProxyRoute(endpoint *ProxyEndpoint) (*ProxyEndpoint, error) {
 	for _, thisOrigin := range endpoint.ActiveOrigins {
 		//Create the proxy routing handler
-		err := thisOrigin.StartProxy()
+		//Origins that fail to start are left without a proxy and skipped by the load balancer
+		upstreamTLS, err := router.resolveUpstreamTLS(thisOrigin)
 		if err != nil {
-			log.Println("Unable to setup upstream " + thisOrigin.OriginIpOrDomain + ": " + err.Error())
+			router.Option.Logger.PrintAndLog("dprouter", "Unable to setup TLS of upstream "+thisOrigin.OriginIpOrDomain+" for "+endpoint.RootOrMatchingDomain+", upstream disabled", err)
+			thisOrigin.StopProxy()
+			continue
+		}
+		err = thisOrigin.StartProxy(upstreamTLS)
+		if err != nil {
+			router.Option.Logger.PrintAndLog("dprouter", "Unable to setup upstream "+thisOrigin.OriginIpOrDomain+" for "+endpoint.RootOrMatchingDomain, err)
 			continue
 		}
 	}
@@ -15,17 +15,21 @@ import (
 	"sync"
 
 	"imuslab.com/zoraxy/mod/auth/sso/oauth2"
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
 	"imuslab.com/zoraxy/mod/auth/sso/zorxauth"
 
 	"imuslab.com/zoraxy/mod/access"
+	"imuslab.com/zoraxy/mod/auth"
 	"imuslab.com/zoraxy/mod/auth/sso/forward"
 	"imuslab.com/zoraxy/mod/dynamicproxy/captcha"
 	"imuslab.com/zoraxy/mod/dynamicproxy/dpcore"
 	"imuslab.com/zoraxy/mod/dynamicproxy/exploits"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
 	"imuslab.com/zoraxy/mod/dynamicproxy/permissionpolicy"
 	"imuslab.com/zoraxy/mod/dynamicproxy/redirection"
 	"imuslab.com/zoraxy/mod/dynamicproxy/rewrite"
+	"imuslab.com/zoraxy/mod/dynamicproxy/waf"
 	"imuslab.com/zoraxy/mod/geodb"
 	"imuslab.com/zoraxy/mod/info/logger"
 	"imuslab.com/zoraxy/mod/plugins"
@@ -68,6 +72,8 @@ type RouterOption struct {
 	WebDirectory       string                    //The static web server directory containing the templates folder
 	LoadBalancer       *loadbalance.RouteManager //Load balancer that handle load balancing of proxy target
 	PluginManager      *plugins.Manager          //Plugin manager for handling plugin routing
+	WAFEngine          *waf.Engine               //Web application firewall rule engine
+	ClientCertStore    *mtls.Store               //CA bundles and CRLs for mutual TLS client certificate verification
 
 	/* Timeouts */
 	ReadHeaderTimeout int64 //HTTP server read timeout in seconds
@@ -82,8 +88,9 @@ type RouterOption struct {
 
 	/* Authentication Providers */
 	ForwardAuthRouter   *forward.AuthRouter
-	OAuth2Router        *oauth2.OAuth2Router //OAuth2Router router for OAuth2Router authentication
-	ZorxAuthAgentRouter *zorxauth.AuthRouter //ZorxAuthAgent for handling zorxauth SSO authentication
+	OAuth2Router        *oauth2.OAuth2Router     //OAuth2Router router for OAuth2Router authentication
+	ZorxAuthAgentRouter *zorxauth.AuthRouter     //ZorxAuthAgent for handling zorxauth SSO authentication
+	ProxyAPIKeyManager  *auth.ProxyAPIKeyManager //API keys for endpoints using API key authentication
 
 	/* Utilities */
 	DevelopmentMode bool           //Enable development mode, provide more debug information in headers
@@ -193,13 +200,18 @@ type HeaderRewriteRules struct {
 type AuthMethod int
 
 const (
-	AuthMethodNone     AuthMethod = iota //No authentication required
-	AuthMethodBasic                      //Basic Auth
-	AuthMethodForward                    //Forward
-	AuthMethodOauth2                     //Oauth2
-	AuthMethodZorxAuth                   //ZorxAuth SSO
+	AuthMethodNone       AuthMethod = iota //No authentication required
+	AuthMethodBasic                        //Basic Auth
+	AuthMethodForward                      //Forward
+	AuthMethodOauth2                       //Oauth2
+	AuthMethodZorxAuth                     //ZorxAuth SSO
+	AuthMethodClientCert                   //mTLS client certificate with subject / SAN allowlist
+	AuthMethodJWT                          //JWT bearer token in Authorization header
+	AuthMethodAPIKey                       //API key in header or query parameter
 )
 
+const DefaultAPIKeyHeader = "X-API-Key"
+
 type AuthenticationProvider struct {
 	AuthMethod AuthMethod //The authentication method to use
 	/* Basic Auth Settings */
@@ -214,8 +226,24 @@ type AuthenticationProvider struct {
 	ForwardAuthRequestHeaders         []string // List of headers to copy from the original request to the auth server. If empty all are copied.
 	ForwardAuthRequestExcludedCookies []string // List of cookies to exclude from the request after sending it to the forward auth server.
 
+	/* OAuth2 / OIDC Settings */
+	OAuth2ClaimRules      []*oidc.ClaimRule     //Claim rules that must all pass to access this endpoint
+	OAuth2ClaimForwarding *oidc.ClaimForwarding //Claims forwarded to the upstream as headers or a signed JWT
+
+	/* JWT Bearer Auth Settings */
+	JWTAuthConfig *oidc.BearerConfig //Key, issuer, audience and scope requirements of bearer tokens
+
+	/* API Key Auth Settings */
+	APIKeyHeader          string //Header carrying the API key, default X-API-Key
+	APIKeyQueryParam      string //Query parameter carrying the API key, empty to disable
+	APIKeyUseTrustedProxy bool   //Read the client IP for the key CIDR list from proxy headers
+
 	/* ZorxAuth SSO Settings */
 	ZorxAuthExceptionRules []*ZorxAuthExceptionRule //Rules to bypass ZorxAuth SSO authentication (path prefix/regex or IP/CIDR)
+
+	/* Client Certificate Auth Settings */
+	ClientCertSubjectAllowlist []string //Allowed subject DN or CN, * as wildcard. Empty allow any verified certificate
+	ClientCertSANAllowlist     []string //Allowed DNS, email, IP or URI SAN, * as wildcard
 }
 
 /* CAPTCHA Provider Configuration */
@@ -226,8 +254,9 @@ type CaptchaExceptionType = captcha.ExceptionType
 type CaptchaExceptionRule = captcha.ExceptionRule
 
 const (
-	CaptchaProviderCloudflare = captcha.ProviderCloudflare
-	CaptchaProviderGoogle     = captcha.ProviderGoogle
+	CaptchaProviderCloudflare  = captcha.ProviderCloudflare
+	CaptchaProviderGoogle      = captcha.ProviderGoogle
+	CaptchaProviderProofOfWork = captcha.ProviderProofOfWork
 
 	CaptchaExceptionType_Paths = captcha.ExceptionTypePaths
 	CaptchaExceptionType_CIDR  = captcha.ExceptionTypeCIDR
@@ -241,6 +270,7 @@ type ProxyEndpoint struct {
 	ActiveOrigins        []*loadbalance.Upstream //Activated Upstream or origin servers IP or domain to proxy to
 	InactiveOrigins      []*loadbalance.Upstream //Disabled Upstream or origin servers IP or domain to proxy to
 	UseStickySession     bool                    //Use stick session for load balancing
+	UseGeoProximity      bool                    //Route to the upstream closest to the requester, require a city level MMDB
 	UseActiveLoadBalance bool                    //Use active loadbalancing, default passive
 	Disabled             bool                    //If the rule is disabled
 	ListeningPorts       []string                //Alternative listening ports in format "ip:port" or ":port" (e.g., ":8080", "192.168.1.1:8080")
@@ -249,6 +279,7 @@ type ProxyEndpoint struct {
 	BypassGlobalTLS      bool                             //Bypass global TLS setting options if TLS Listener enabled (parent.tlsListener != nil)
 	EnableConnectSupport bool                             //Allow HTTP CONNECT tunneling to the configured upstream (disabled by default to prevent open-proxy abuse)
 	TlsOptions           *tlscert.HostSpecificTlsBehavior //TLS options for this endpoint, if nil, use global TLS options
+	MutualTLS            *mtls.Config                     //Client certificate verification for this endpoint, nil if mTLS is not configured
 
 	//Virtual Directories
 	VirtualDirectories []*VirtualDirectoryEndpoint
@@ -285,6 +316,9 @@ type ProxyEndpoint struct {
 	BlockAICrawlers     bool //Enable blocking of AI crawlers and bots
 	MitigationAction    int  //Action to take when exploit/crawler detected (0=404, 1=403, 2=400, 3=Drop, 4=Delay, 5=Captcha)
 
+	//Web Application Firewall
+	WAFConfig *waf.Config //WAF settings for this endpoint, nil if WAF is not configured
+
 	// Chunked Transfer Encoding
 	DisableChunkedTransferEncoding bool //Disable chunked transfer encoding for this endpoint
 	ForceHTTP11                    bool //Force use HTTP/1.1 for upstream connection
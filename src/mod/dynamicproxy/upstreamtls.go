@@ -0,0 +1,74 @@
+package dynamicproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/dpcore"
+	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
+)
+
+/*
+	upstreamtls.go
+
+	This script resolve the TLS trust and client certificate options of
+	upstreams. CA bundles are loaded from the mTLS store and client
+	certificates from the cert store, so renewed certificates are picked
+	up on the next handshake without restarting the proxy
+*/
+
+// resolveUpstreamTLS build the upstream TLS config of an origin, return nil if no option is set
+func (router *Router) resolveUpstreamTLS(origin *loadbalance.Upstream) (*dpcore.UpstreamTLSConfig, error) {
+	if !origin.HasCustomTLS() {
+		return nil, nil
+	}
+
+	upstreamTLS := &dpcore.UpstreamTLSConfig{
+		ServerName: strings.TrimSpace(origin.TLSServerName),
+	}
+	for _, pin := range origin.TLSPinnedSPKI {
+		if err := dpcore.ValidateSPKIPin(pin); err != nil {
+			return nil, err
+		}
+		upstreamTLS.PinnedSPKI = append(upstreamTLS.PinnedSPKI, pin)
+	}
+
+	if origin.TLSCABundle != "" {
+		if router.Option.ClientCertStore == nil {
+			return nil, errors.New("CA bundle store not available")
+		}
+		trust, err := router.Option.ClientCertStore.LoadTrust(&mtls.Config{CABundle: origin.TLSCABundle})
+		if err != nil {
+			return nil, errors.New("unable to load CA bundle " + origin.TLSCABundle + ": " + err.Error())
+		}
+		upstreamTLS.RootCAs = trust.ClientCAs
+	}
+
+	if origin.TLSClientCert != "" {
+		tlsManager := router.Option.TlsManager
+		if tlsManager == nil {
+			return nil, errors.New("certificate store not available")
+		}
+		certName := origin.TLSClientCert
+		if _, err := tlsManager.GetCertificateByName(certName); err != nil {
+			return nil, err
+		}
+		upstreamTLS.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tlsManager.GetCertificateByName(certName)
+			if err != nil {
+				//Continue the handshake without a certificate and let the upstream decide
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		}
+	}
+	return upstreamTLS, nil
+}
+
+// ValidateUpstreamTLS check if the TLS options of an upstream can be resolved
+func (router *Router) ValidateUpstreamTLS(origin *loadbalance.Upstream) error {
+	_, err := router.resolveUpstreamTLS(origin)
+	return err
+}
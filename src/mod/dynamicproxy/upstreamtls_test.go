@@ -0,0 +1,46 @@
+package dynamicproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+func TestUpstreamWithBrokenTLSIsSkipped(t *testing.T) {
+	fmtLogger, _ := logger.NewFmtLogger()
+	router := &Router{Option: &RouterOption{Logger: fmtLogger}}
+
+	healthy := &loadbalance.Upstream{OriginIpOrDomain: "127.0.0.1:8080", Weight: 1}
+	broken := &loadbalance.Upstream{OriginIpOrDomain: "127.0.0.1:8443", RequireTLS: true, TLSCABundle: "deleted-bundle", Weight: 1}
+	//The broken upstream was working before its CA bundle went missing
+	if err := broken.StartProxy(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := &ProxyEndpoint{
+		RootOrMatchingDomain: "a.example.com",
+		ActiveOrigins:        []*loadbalance.Upstream{healthy, broken},
+	}
+	if _, err := router.PrepareProxyRoute(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if !healthy.IsReady() || broken.IsReady() {
+		t.Fatalf("expected only the healthy upstream to be ready, got healthy=%v broken=%v", healthy.IsReady(), broken.IsReady())
+	}
+
+	lb := loadbalance.NewLoadBalancer(&loadbalance.Options{Logger: fmtLogger})
+	r := httptest.NewRequest(http.MethodGet, "http://a.example.com/", nil)
+	for i := 0; i < 20; i++ {
+		origin, err := lb.GetRequestUpstreamTarget(httptest.NewRecorder(), r, endpoint.ActiveOrigins, false, false, false)
+		if err != nil || origin != healthy {
+			t.Fatalf("expected the healthy upstream to be picked, got %v (%v)", origin, err)
+		}
+	}
+
+	if _, err := lb.GetRequestUpstreamTarget(httptest.NewRecorder(), r, []*loadbalance.Upstream{broken}, false, false, false); err == nil {
+		t.Error("expected an error when no upstream is ready")
+	}
+}
@@ -0,0 +1,162 @@
+package waf
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"imuslab.com/zoraxy/mod/netutils"
+)
+
+/*
+	audit.go
+
+	The audit logger write one JSON object per line for every request
+	that matched at least one WAF rule. Log files are split by month
+	like other Zoraxy logs, e.g. waf_audit_2025-1.log
+*/
+
+type AuditEntry struct {
+	Timestamp     int64  //Unix timestamp of the request
+	TransactionID string //Unique ID of the inspection
+	ClientIP      string
+	Host          string
+	Method        string
+	URI           string
+	Endpoint      string //Root domain of the matched proxy endpoint
+	Score         int
+	Threshold     int
+	ParanoiaLevel int
+	Action        string //blocked, detected or dropped
+	MatchedRules  []*MatchedRule
+}
+
+type AuditLogger struct {
+	LogFolder string
+
+	currentFile string
+	file        *os.File
+	mutex       sync.Mutex
+}
+
+// NewAuditLogger create a new audit logger. If the log folder is empty, audit log is disabled
+func NewAuditLogger(logFolder string) (*AuditLogger, error) {
+	if logFolder != "" {
+		err := os.MkdirAll(logFolder, 0775)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &AuditLogger{
+		LogFolder: logFolder,
+	}, nil
+}
+
+func (l *AuditLogger) getLogFilepath(t time.Time) string {
+	year, month, _ := t.Date()
+	return filepath.Join(l.LogFolder, "waf_audit_"+strconv.Itoa(year)+"-"+strconv.Itoa(int(month))+".log")
+}
+
+// Log write the inspection result of the request to the audit log
+func (l *AuditLogger) Log(r *http.Request, endpointName string, config *Config, result *Result) {
+	if l == nil || l.LogFolder == "" {
+		return
+	}
+
+	action := "detected"
+	if result.Blocked {
+		action = "blocked"
+		if result.Drop {
+			action = "dropped"
+		}
+	}
+
+	entry := AuditEntry{
+		Timestamp:     time.Now().Unix(),
+		TransactionID: result.TransactionID,
+		ClientIP:      netutils.GetRequesterIP(r),
+		Host:          r.Host,
+		Method:        r.Method,
+		URI:           truncateString(r.RequestURI, 1024),
+		Endpoint:      endpointName,
+		Score:         result.Score,
+		Threshold:     result.Threshold,
+		ParanoiaLevel: result.ParanoiaLevel,
+		Action:        action,
+		MatchedRules:  result.Matches,
+	}
+
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	logFilePath := l.getLogFilepath(time.Now())
+	if l.file == nil || l.currentFile != logFilePath {
+		//First write or change of month
+		if l.file != nil {
+			l.file.Close()
+		}
+		f, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			l.file = nil
+			return
+		}
+		l.file = f
+		l.currentFile = logFilePath
+	}
+	l.file.Write(append(js, '\n'))
+}
+
+// ReadRecent return the most recent audit entries of the current month, newest first
+func (l *AuditLogger) ReadRecent(limit int) ([]*AuditEntry, error) {
+	results := []*AuditEntry{}
+	if l == nil || l.LogFolder == "" {
+		return results, nil
+	}
+
+	f, err := os.Open(l.getLogFilepath(time.Now()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return results, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		results = append(results, &entry)
+		if limit > 0 && len(results) > limit {
+			results = results[1:]
+		}
+	}
+
+	//Reverse to newest first
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results, scanner.Err()
+}
+
+// Close the audit log file
+func (l *AuditLogger) Close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
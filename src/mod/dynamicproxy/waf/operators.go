@@ -0,0 +1,299 @@
+package waf
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	operators.go
+
+	This script contains the rule operators. Operators that
+	require external libraries (e.g. @detectSQLi which require
+	libinjection) are not supported and the rule will be skipped
+*/
+
+type ruleOperator struct {
+	Name     string //Operator name without the @ prefix, e.g. rx
+	Argument string //Raw operator argument
+	Negate   bool   //If the operator is prefixed with !
+
+	//Internal
+	match func(tx *transaction, rule *Rule, value string) bool
+}
+
+// parseOperator parse the operator string, e.g. "@rx ^GET$" or "!@streq POST"
+func parseOperator(operator string, loadDataFile dataFileLoader) (*ruleOperator, error) {
+	op := ruleOperator{}
+	operator = strings.TrimSpace(operator)
+	if strings.HasPrefix(operator, "!") {
+		op.Negate = true
+		operator = strings.TrimSpace(operator[1:])
+	}
+
+	if strings.HasPrefix(operator, "@") {
+		name, argument, _ := strings.Cut(operator[1:], " ")
+		op.Name = name
+		op.Argument = strings.TrimSpace(argument)
+	} else {
+		//Operator default to @rx if not specified
+		op.Name = "rx"
+		op.Argument = operator
+	}
+
+	argument := op.Argument
+	switch op.Name {
+	case "rx":
+		re, err := regexp.Compile(argument)
+		if err != nil {
+			return nil, errors.New("unsupported regex: " + err.Error())
+		}
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return re.MatchString(value)
+		}
+	case "pm":
+		phrases := strings.Fields(strings.ToLower(argument))
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return matchPhrases(phrases, value)
+		}
+	case "pmFromFile", "pmf":
+		if loadDataFile == nil {
+			return nil, errors.New("data file loading is not available")
+		}
+		phrases := []string{}
+		for _, filename := range strings.Fields(argument) {
+			content, err := loadDataFile(filename)
+			if err != nil {
+				return nil, errors.New("unable to load data file " + filename)
+			}
+			for _, line := range strings.Split(string(content), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				phrases = append(phrases, strings.ToLower(line))
+			}
+		}
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return matchPhrases(phrases, value)
+		}
+	case "contains":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return strings.Contains(value, tx.expandMacros(argument, rule))
+		}
+	case "containsWord":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return containsWord(value, tx.expandMacros(argument, rule))
+		}
+	case "streq":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return value == tx.expandMacros(argument, rule)
+		}
+	case "beginsWith":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return strings.HasPrefix(value, tx.expandMacros(argument, rule))
+		}
+	case "endsWith":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return strings.HasSuffix(value, tx.expandMacros(argument, rule))
+		}
+	case "within":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return value != "" && strings.Contains(tx.expandMacros(argument, rule), value)
+		}
+	case "eq", "ge", "gt", "le", "lt":
+		compare := op.Name
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return compareNumbers(compare, value, tx.expandMacros(argument, rule))
+		}
+	case "ipMatch":
+		networks, err := parseIPMatchList(argument)
+		if err != nil {
+			return nil, err
+		}
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			ip := net.ParseIP(strings.TrimSpace(value))
+			if ip == nil {
+				return false
+			}
+			for _, network := range networks {
+				if network.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}
+	case "validateByteRange":
+		allowed, err := parseByteRanges(argument)
+		if err != nil {
+			return nil, err
+		}
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			//Match if any byte is outside the allowed ranges
+			for i := 0; i < len(value); i++ {
+				if !allowed[value[i]] {
+					return true
+				}
+			}
+			return false
+		}
+	case "validateUrlEncoding":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return !isValidURLEncoding(value)
+		}
+	case "validateUtf8Encoding":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return !utf8.ValidString(value)
+		}
+	case "unconditionalMatch":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return true
+		}
+	case "noMatch":
+		op.match = func(tx *transaction, rule *Rule, value string) bool {
+			return false
+		}
+	default:
+		return nil, errors.New("unsupported operator @" + op.Name)
+	}
+
+	return &op, nil
+}
+
+// evaluate run the operator against the value, taking negation into account
+func (op *ruleOperator) evaluate(tx *transaction, rule *Rule, value string) bool {
+	return op.match(tx, rule, value) != op.Negate
+}
+
+// matchPhrases check if the value contains any of the lower case phrases, case insensitive
+func matchPhrases(phrases []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, phrase := range phrases {
+		if strings.Contains(value, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWord check if the word appears in the value with non word characters around it
+func containsWord(value string, word string) bool {
+	if word == "" {
+		return false
+	}
+	isWordChar := func(c byte) bool {
+		return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+
+	offset := 0
+	for {
+		idx := strings.Index(value[offset:], word)
+		if idx < 0 {
+			return false
+		}
+		start := offset + idx
+		end := start + len(word)
+		if (start == 0 || !isWordChar(value[start-1])) && (end == len(value) || !isWordChar(value[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// compareNumbers compare the value with the argument as integers. Invalid numbers are treated as 0
+func compareNumbers(operator string, value string, argument string) bool {
+	a, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		a = 0
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(argument))
+	if err != nil {
+		b = 0
+	}
+
+	switch operator {
+	case "eq":
+		return a == b
+	case "ge":
+		return a >= b
+	case "gt":
+		return a > b
+	case "le":
+		return a <= b
+	case "lt":
+		return a < b
+	}
+	return false
+}
+
+// parseIPMatchList parse the comma separated list of IP and CIDR
+func parseIPMatchList(argument string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(argument, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("invalid @ipMatch entry " + entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseByteRanges parse byte range list like "1-8,10,32-126"
+func parseByteRanges(argument string) ([256]bool, error) {
+	allowed := [256]bool{}
+	for _, entry := range strings.Split(argument, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		startStr, endStr, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			endStr = startStr
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(startStr))
+		if err != nil || start < 0 || start > 255 {
+			return allowed, errors.New("invalid byte range " + entry)
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(endStr))
+		if err != nil || end < start || end > 255 {
+			return allowed, errors.New("invalid byte range " + entry)
+		}
+		for i := start; i <= end; i++ {
+			allowed[i] = true
+		}
+	}
+	return allowed, nil
+}
+
+// isValidURLEncoding check if all % in the value are followed by two hex digits
+func isValidURLEncoding(value string) bool {
+	isHex := func(c byte) bool {
+		return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			continue
+		}
+		if i+2 >= len(value) || !isHex(value[i+1]) || !isHex(value[i+2]) {
+			return false
+		}
+		i += 2
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package waf
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/*
+	rule.go
+
+	This script contains the rule definition and the action
+	parser of SecRule / SecAction directives
+*/
+
+type Rule struct {
+	ID            int      //Rule ID, 0 for chained rules and markers
+	Phase         int      //Processing phase, only phase 1 (headers) and 2 (body) are evaluated
+	Msg           string   //Rule message
+	LogData       string   //Extra log data, macro expanded on match
+	Severity      string   //Severity name, e.g. CRITICAL
+	Tags          []string //Rule tags
+	ParanoiaLevel int      //Paranoia level from the paranoia-level/N tag, 0 if not tagged
+	Marker        string   //Non empty if this is a SecMarker entry
+	Chain         *Rule    //Next rule in the chain, nil if not chained
+	File          string   //Rule file this rule is loaded from
+	Line          int      //Line number in the rule file
+
+	//Internal
+	variables    []*ruleVariable
+	operator     *ruleOperator
+	transforms   []transformFunc
+	disruptive   string //block, deny, drop, pass, allow or empty
+	setVars      []string
+	skipAfter    string
+	skip         int
+	noLog        bool
+	isChainStart bool
+}
+
+// IsMarker return true if the rule is a SecMarker entry
+func (r *Rule) IsMarker() bool {
+	return r.Marker != ""
+}
+
+// newRule create a rule from the variables, operator and actions of a SecRule directive
+func newRule(variables string, operator string, actions string, isChained bool, loadDataFile dataFileLoader) (*Rule, error) {
+	rule := Rule{
+		Phase:      2,
+		Tags:       []string{},
+		transforms: []transformFunc{},
+		setVars:    []string{},
+	}
+
+	//Actions are parsed first so the rule ID and chain state is known even if
+	//the operator or variables are not supported
+	err := rule.parseActions(actions)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isChained && rule.ID == 0 {
+		return &rule, errors.New("rule ID is required")
+	}
+
+	if variables != "" {
+		rule.variables, err = parseVariables(variables)
+		if err != nil {
+			return &rule, err
+		}
+	}
+
+	rule.operator, err = parseOperator(operator, loadDataFile)
+	if err != nil {
+		return &rule, err
+	}
+
+	return &rule, nil
+}
+
+// parseActions parse the comma separated action list into the rule
+func (r *Rule) parseActions(actions string) error {
+	for _, action := range splitActions(actions) {
+		name, value, _ := strings.Cut(action, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		switch name {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				return errors.New("invalid rule id " + value)
+			}
+			r.ID = id
+		case "phase":
+			switch value {
+			case "request":
+				r.Phase = 2
+			case "response":
+				r.Phase = 4
+			case "logging":
+				r.Phase = 5
+			default:
+				phase, err := strconv.Atoi(value)
+				if err != nil {
+					return errors.New("invalid phase " + value)
+				}
+				r.Phase = phase
+			}
+		case "msg":
+			r.Msg = value
+		case "logdata":
+			r.LogData = value
+		case "severity":
+			r.Severity = severityFromString(value)
+		case "tag":
+			r.Tags = append(r.Tags, value)
+			if strings.HasPrefix(value, "paranoia-level/") {
+				pl, err := strconv.Atoi(strings.TrimPrefix(value, "paranoia-level/"))
+				if err == nil {
+					r.ParanoiaLevel = pl
+				}
+			}
+		case "t":
+			if strings.EqualFold(value, "none") {
+				r.transforms = []transformFunc{}
+				continue
+			}
+			transform, ok := getTransform(value)
+			if !ok {
+				return errors.New("unsupported transformation " + value)
+			}
+			r.transforms = append(r.transforms, transform)
+		case "setvar":
+			r.setVars = append(r.setVars, value)
+		case "skipafter":
+			r.skipAfter = value
+		case "skip":
+			skip, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("invalid skip value " + value)
+			}
+			r.skip = skip
+		case "chain":
+			r.isChainStart = true
+		case "block", "deny", "drop", "pass", "allow":
+			r.disruptive = name
+		case "nolog":
+			r.noLog = true
+		default:
+			//Actions like ver, rev, status, capture, ctl and auditlog
+			//do not change how the rule is evaluated by this engine
+		}
+	}
+	return nil
+}
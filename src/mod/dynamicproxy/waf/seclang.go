@@ -0,0 +1,288 @@
+package waf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	seclang.go
+
+	Parser for a practical subset of the SecLang (ModSecurity) rule language.
+	Supported directives are SecRule, SecAction, SecMarker and SecRuleRemoveById.
+	Other directives (e.g. SecRuleEngine, SecDefaultAction) are accepted and ignored,
+	as the engine behavior is controlled by the per-endpoint Config instead.
+*/
+
+// parseResult is the output of parsing a rule file
+type parseResult struct {
+	Rules      []*Rule  //Parsed rules in file order, including SecMarker entries
+	RemovedIDs []string //Rule ID or ID ranges removed by SecRuleRemoveById
+	Warnings   []string //Rules skipped due to unsupported features
+}
+
+// dataFileLoader load the data file referenced by operators like @pmFromFile
+type dataFileLoader func(filename string) ([]byte, error)
+
+// parseSecLang parse the rule file content from the reader
+func parseSecLang(reader io.Reader, filename string, loadDataFile dataFileLoader) (*parseResult, error) {
+	result := parseResult{
+		Rules:      []*Rule{},
+		RemovedIDs: []string{},
+		Warnings:   []string{},
+	}
+
+	directives, err := readDirectives(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var chainParent *Rule
+	var chainSkipped bool
+	for _, d := range directives {
+		tokens, err := tokenizeDirective(d.text)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d %s", filename, d.line, err.Error()))
+			continue
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(tokens[0]) {
+		case "secrule":
+			if len(tokens) < 3 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d SecRule require variables and operator", filename, d.line))
+				continue
+			}
+			actions := ""
+			if len(tokens) > 3 {
+				actions = tokens[3]
+			}
+			rule, err := newRule(tokens[1], tokens[2], actions, chainParent != nil, loadDataFile)
+			if rule != nil {
+				rule.File = filename
+				rule.Line = d.line
+			}
+
+			if chainParent != nil {
+				//This rule is a chained child of the previous rule
+				if err != nil || chainSkipped {
+					chainSkipped = true
+				} else {
+					lastLink := chainParent
+					for lastLink.Chain != nil {
+						lastLink = lastLink.Chain
+					}
+					lastLink.Chain = rule
+				}
+
+				if err != nil {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d rule %d skipped: %s", filename, d.line, chainParent.ID, err.Error()))
+				}
+
+				if rule == nil || !rule.isChainStart {
+					//End of chain
+					if !chainSkipped {
+						result.Rules = append(result.Rules, chainParent)
+					}
+					chainParent = nil
+					chainSkipped = false
+				}
+				continue
+			}
+
+			if err != nil {
+				id := 0
+				if rule != nil {
+					id = rule.ID
+				}
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d rule %d skipped: %s", filename, d.line, id, err.Error()))
+				if rule != nil && rule.isChainStart {
+					//Skip the whole chain
+					chainParent = rule
+					chainSkipped = true
+				}
+				continue
+			}
+
+			if rule.isChainStart {
+				chainParent = rule
+				continue
+			}
+			result.Rules = append(result.Rules, rule)
+		case "secaction":
+			if len(tokens) < 2 {
+				continue
+			}
+			rule, err := newRule("", "@unconditionalMatch", tokens[1], false, loadDataFile)
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d SecAction skipped: %s", filename, d.line, err.Error()))
+				continue
+			}
+			rule.File = filename
+			rule.Line = d.line
+			result.Rules = append(result.Rules, rule)
+		case "secmarker":
+			if len(tokens) < 2 {
+				continue
+			}
+			result.Rules = append(result.Rules, &Rule{
+				Marker: strings.Trim(tokens[1], "'"),
+				File:   filename,
+				Line:   d.line,
+			})
+		case "secruleremovebyid":
+			result.RemovedIDs = append(result.RemovedIDs, tokens[1:]...)
+		default:
+			//Other directives are not used by the engine
+		}
+	}
+
+	if chainParent != nil && !chainSkipped {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%s rule %d has an unterminated chain", filename, chainParent.ID))
+	}
+
+	return &result, nil
+}
+
+type directive struct {
+	text string
+	line int
+}
+
+// readDirectives read the rule file into directives, joining lines ending with backslash
+func readDirectives(reader io.Reader) ([]*directive, error) {
+	results := []*directive{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	current := ""
+	startLine := 0
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if current == "" && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+
+		if current == "" {
+			startLine = lineNo
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		current += line
+		results = append(results, &directive{text: current, line: startLine})
+		current = ""
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if current != "" {
+		results = append(results, &directive{text: current, line: startLine})
+	}
+	return results, nil
+}
+
+// tokenizeDirective split the directive into whitespace separated tokens.
+// Double quoted tokens are unquoted, only the \" escape is unescaped so
+// regex escape sequences are preserved
+func tokenizeDirective(text string) ([]string, error) {
+	tokens := []string{}
+	i := 0
+	for i < len(text) {
+		//Skip whitespaces
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+		if i >= len(text) {
+			break
+		}
+
+		if text[i] == '"' {
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(text) {
+				if text[i] == '\\' && i+1 < len(text) && text[i+1] == '"' {
+					sb.WriteByte('"')
+					i += 2
+					continue
+				}
+				if text[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(text[i])
+				i++
+			}
+			if !closed {
+				return nil, errors.New("unterminated quoted string")
+			}
+			tokens = append(tokens, sb.String())
+			continue
+		}
+
+		start := i
+		for i < len(text) && text[i] != ' ' && text[i] != '\t' {
+			i++
+		}
+		tokens = append(tokens, text[start:i])
+	}
+	return tokens, nil
+}
+
+// splitActions split the action list by commas that are not inside single quotes
+func splitActions(actions string) []string {
+	results := []string{}
+	inQuote := false
+	var sb strings.Builder
+	for i := 0; i < len(actions); i++ {
+		c := actions[i]
+		if c == '\\' && i+1 < len(actions) && actions[i+1] == '\'' {
+			sb.WriteByte('\'')
+			i++
+			continue
+		}
+		if c == '\'' {
+			inQuote = !inQuote
+			continue
+		}
+		if c == ',' && !inQuote {
+			if s := strings.TrimSpace(sb.String()); s != "" {
+				results = append(results, s)
+			}
+			sb.Reset()
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if s := strings.TrimSpace(sb.String()); s != "" {
+		results = append(results, s)
+	}
+	return results
+}
+
+// severityFromString convert severity name or number into the severity name
+func severityFromString(severity string) string {
+	severity = strings.ToUpper(strings.TrimSpace(severity))
+	if n, err := strconv.Atoi(severity); err == nil {
+		names := []string{"EMERGENCY", "ALERT", "CRITICAL", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+		if n >= 0 && n < len(names) {
+			return names[n]
+		}
+	}
+	return severity
+}
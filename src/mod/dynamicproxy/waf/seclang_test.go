@@ -0,0 +1,81 @@
+package waf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeDirective(t *testing.T) {
+	tokens, err := tokenizeDirective(`SecRule ARGS "@rx \d+\"x" "id:1,msg:'a b'"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"SecRule", "ARGS", `@rx \d+"x`, "id:1,msg:'a b'"}
+	if strings.Join(tokens, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected tokens %q", tokens)
+	}
+
+	if _, err := tokenizeDirective(`SecRule ARGS "@rx abc`); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestSplitActions(t *testing.T) {
+	actions := splitActions("id:1,phase:2,msg:'Hello, world',tag:'a',setvar:'tx.score=+%{tx.critical_anomaly_score}'")
+	expected := []string{"id:1", "phase:2", "msg:Hello, world", "tag:a", "setvar:tx.score=+%{tx.critical_anomaly_score}"}
+	if strings.Join(actions, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected actions %q", actions)
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	variables, err := parseVariables("REQUEST_COOKIES|!REQUEST_COOKIES:/__utm|_ga/|&ARGS|ARGS_NAMES:id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variables) != 4 {
+		t.Fatalf("expected 4 variables, got %d", len(variables))
+	}
+	if !variables[1].Exclude || variables[1].KeyRegex == nil || !variables[1].matchKey("_ga") {
+		t.Error("expected regex exclusion on REQUEST_COOKIES")
+	}
+	if !variables[2].Count || variables[2].Name != "ARGS" {
+		t.Error("expected count variable on ARGS")
+	}
+	if variables[3].Key != "id" {
+		t.Error("expected key selector on ARGS_NAMES")
+	}
+
+	if _, err := parseVariables("RESPONSE_BODY"); err == nil {
+		t.Error("expected error for unsupported variable")
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	testcases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"urlDecodeUni", "%u003cscript%3e", "<script>"},
+		{"urlDecode", "100%zz+a", "100%zz a"},
+		{"htmlEntityDecode", "&lt;b&gt;", "<b>"},
+		{"compressWhitespace", "a  \t b", "a b"},
+		{"replaceComments", "SEL/**/ECT", "SEL ECT"},
+		{"normalizePath", "/a//b/./c/", "/a/b/c/"},
+		{"base64Decode", "aGVsbG8=", "hello"},
+		{"length", "abcd", "4"},
+		{"cmdLine", "C^aT /Etc/'pass'wd", "cat/etc/passwd"},
+	}
+
+	for _, tc := range testcases {
+		transform, ok := getTransform(tc.name)
+		if !ok {
+			t.Errorf("transform %s not found", tc.name)
+			continue
+		}
+		if output := transform(tc.input); output != tc.expected {
+			t.Errorf("%s(%q): expected %q, got %q", tc.name, tc.input, tc.expected, output)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"imuslab.com/zoraxy/mod/netutils"
+)
+
+/*
+	transaction.go
+
+	A transaction holds the parsed request data and the TX
+	variables of a single inspection
+*/
+
+type keyValue struct {
+	Key   string
+	Value string
+}
+
+type transaction struct {
+	id             string
+	request        *http.Request
+	remoteAddr     string
+	phase          int
+	argsGet        []*keyValue
+	argsPost       []*keyValue
+	headers        []*keyValue
+	cookies        []*keyValue
+	body           string
+	bodyProcessor  string
+	bodyError      bool
+	txVars         map[string]string
+	matchedVar     string
+	matchedVarName string
+}
+
+// newTransaction parse the request into a transaction. Up to maxBodySize bytes of
+// the request body is read for inspection, and the body is restored so it can
+// still be forwarded to the upstream
+func newTransaction(r *http.Request, maxBodySize int64) *transaction {
+	tx := transaction{
+		id:         uuid.New().String(),
+		request:    r,
+		remoteAddr: netutils.GetRequesterIP(r),
+		phase:      1,
+		argsGet:    []*keyValue{},
+		argsPost:   []*keyValue{},
+		headers:    []*keyValue{},
+		cookies:    []*keyValue{},
+		txVars:     map[string]string{},
+	}
+
+	//Query string arguments
+	//Malformed pairs are dropped, the raw QUERY_STRING is still inspected
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	tx.argsGet = sortedKeyValues(query)
+
+	//Headers, sorted for consistent rule evaluation order
+	headerNames := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		for _, value := range r.Header[name] {
+			tx.headers = append(tx.headers, &keyValue{Key: name, Value: value})
+		}
+	}
+	if r.Host != "" && r.Header.Get("Host") == "" {
+		tx.headers = append(tx.headers, &keyValue{Key: "Host", Value: r.Host})
+	}
+
+	//Cookies
+	for _, cookie := range r.Cookies() {
+		tx.cookies = append(tx.cookies, &keyValue{Key: cookie.Name, Value: cookie.Value})
+	}
+
+	//Request body
+	if r.Body != nil && r.Body != http.NoBody && maxBodySize > 0 {
+		tx.readBody(r, maxBodySize)
+	}
+
+	return &tx
+}
+
+// readBody read a bounded amount of the request body and parse it into ARGS_POST
+func (tx *transaction) readBody(r *http.Request, maxBodySize int64) {
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		tx.bodyError = true
+	}
+
+	//Restore the body for the upstream, including the part not inspected
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+
+	tx.body = string(buf)
+	truncated := int64(len(buf)) >= maxBodySize
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		tx.bodyProcessor = "URLENCODED"
+		values, err := url.ParseQuery(tx.body)
+		if err != nil && !truncated {
+			tx.bodyError = true
+		}
+		tx.argsPost = sortedKeyValues(values)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		tx.bodyProcessor = "JSON"
+		if truncated {
+			//Truncated JSON cannot be parsed, the raw body is still inspected
+			return
+		}
+		var data interface{}
+		if err := json.Unmarshal(buf, &data); err != nil {
+			tx.bodyError = true
+			return
+		}
+		tx.argsPost = flattenJSON("json", data, []*keyValue{})
+	case strings.HasPrefix(mediaType, "multipart/"):
+		tx.bodyProcessor = "MULTIPART"
+	}
+}
+
+// flattenJSON flatten the json value into key value pairs like json.user.name
+func flattenJSON(prefix string, data interface{}, results []*keyValue) []*keyValue {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			results = flattenJSON(prefix+"."+key, value[key], results)
+		}
+	case []interface{}:
+		for i, item := range value {
+			results = flattenJSON(prefix+"."+strconv.Itoa(i), item, results)
+		}
+	case string:
+		results = append(results, &keyValue{Key: prefix, Value: value})
+	case nil:
+		results = append(results, &keyValue{Key: prefix, Value: ""})
+	default:
+		encoded, _ := json.Marshal(value)
+		results = append(results, &keyValue{Key: prefix, Value: string(encoded)})
+	}
+	return results
+}
+
+// sortedKeyValues convert url values into key value pairs sorted by key
+func sortedKeyValues(values url.Values) []*keyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := []*keyValue{}
+	for _, key := range keys {
+		for _, value := range values[key] {
+			results = append(results, &keyValue{Key: key, Value: value})
+		}
+	}
+	return results
+}
+
+// txVarList return the TX variables as key value pairs
+func (tx *transaction) txVarList() []*keyValue {
+	keys := make([]string, 0, len(tx.txVars))
+	for key := range tx.txVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := []*keyValue{}
+	for _, key := range keys {
+		results = append(results, &keyValue{Key: key, Value: tx.txVars[key]})
+	}
+	return results
+}
+
+// getTxInt return the TX variable as integer, 0 if not set or invalid
+func (tx *transaction) getTxInt(name string) int {
+	value, err := strconv.Atoi(tx.txVars[strings.ToLower(name)])
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// expandMacros replace %{VAR} macros in the string with the transaction values
+func (tx *transaction) expandMacros(input string, rule *Rule) string {
+	if !strings.Contains(input, "%{") {
+		return input
+	}
+
+	var sb strings.Builder
+	for {
+		start := strings.Index(input, "%{")
+		if start < 0 {
+			sb.WriteString(input)
+			break
+		}
+		end := strings.Index(input[start:], "}")
+		if end < 0 {
+			sb.WriteString(input)
+			break
+		}
+		end += start
+
+		sb.WriteString(input[:start])
+		sb.WriteString(tx.resolveMacro(input[start+2:end], rule))
+		input = input[end+1:]
+	}
+	return sb.String()
+}
+
+// resolveMacro return the value of a single macro, e.g. tx.anomaly_score
+func (tx *transaction) resolveMacro(name string, rule *Rule) string {
+	collection, key, _ := strings.Cut(name, ".")
+	switch strings.ToUpper(collection) {
+	case "TX":
+		return tx.txVars[strings.ToLower(key)]
+	case "RULE":
+		switch strings.ToLower(key) {
+		case "id":
+			return strconv.Itoa(rule.ID)
+		case "msg":
+			return rule.Msg
+		case "severity":
+			return rule.Severity
+		}
+		return ""
+	}
+
+	//Fallback to the variable resolver, e.g. MATCHED_VAR or REQUEST_HEADERS.host
+	v := &ruleVariable{Name: strings.ToUpper(collection), Key: strings.ToLower(key)}
+	if !supportedVariables[v.Name] {
+		return ""
+	}
+	values := tx.resolveVariable(v)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0].Value
+}
+
+// setVar execute a setvar action, e.g. tx.anomaly_score=+5
+func (tx *transaction) setVar(expression string, rule *Rule) {
+	expression = strings.TrimSpace(expression)
+	if strings.HasPrefix(expression, "!") {
+		collection, key, _ := strings.Cut(expression[1:], ".")
+		if strings.EqualFold(collection, "tx") {
+			delete(tx.txVars, strings.ToLower(key))
+		}
+		return
+	}
+
+	target, value, hasValue := strings.Cut(expression, "=")
+	collection, key, _ := strings.Cut(target, ".")
+	if !strings.EqualFold(collection, "tx") {
+		//Persistent collections like ip or global are not supported
+		return
+	}
+
+	key = strings.ToLower(tx.expandMacros(key, rule))
+	if !hasValue {
+		tx.txVars[key] = "1"
+		return
+	}
+
+	value = tx.expandMacros(value, rule)
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		delta, err := strconv.Atoi(value)
+		if err == nil {
+			current, _ := strconv.Atoi(tx.txVars[key])
+			tx.txVars[key] = strconv.Itoa(current + delta)
+			return
+		}
+	}
+	tx.txVars[key] = value
+}
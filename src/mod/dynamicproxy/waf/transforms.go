@@ -0,0 +1,166 @@
+package waf
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	transforms.go
+
+	This script contains the transformation functions (t:xxx)
+	that normalize the value before it is passed to the operator
+*/
+
+type transformFunc func(string) string
+
+var (
+	whitespaceRegex  = regexp.MustCompile(`\s+`)
+	sqlCommentsRegex = regexp.MustCompile(`(?s)/\*.*?(\*/|$)`)
+)
+
+var transforms = map[string]transformFunc{
+	"lowercase":          strings.ToLower,
+	"uppercase":          strings.ToUpper,
+	"urldecode":          urlDecode,
+	"urldecodeuni":       urlDecodeUni,
+	"htmlentitydecode":   html.UnescapeString,
+	"removenulls":        func(s string) string { return strings.ReplaceAll(s, "\x00", "") },
+	"replacenulls":       func(s string) string { return strings.ReplaceAll(s, "\x00", " ") },
+	"compresswhitespace": func(s string) string { return whitespaceRegex.ReplaceAllString(s, " ") },
+	"removewhitespace":   func(s string) string { return whitespaceRegex.ReplaceAllString(s, "") },
+	"trim":               strings.TrimSpace,
+	"trimleft":           func(s string) string { return strings.TrimLeft(s, " \t\r\n\f\v") },
+	"trimright":          func(s string) string { return strings.TrimRight(s, " \t\r\n\f\v") },
+	"base64decode":       base64Decode,
+	"base64decodeext":    base64Decode,
+	"hexdecode":          hexDecode,
+	"hexencode":          func(s string) string { return hex.EncodeToString([]byte(s)) },
+	"normalizepath":      normalizePath,
+	"normalisepath":      normalizePath,
+	"normalizepathwin":   func(s string) string { return normalizePath(strings.ReplaceAll(s, "\\", "/")) },
+	"normalisepathwin":   func(s string) string { return normalizePath(strings.ReplaceAll(s, "\\", "/")) },
+	"replacecomments":    func(s string) string { return sqlCommentsRegex.ReplaceAllString(s, " ") },
+	"removecomments":     func(s string) string { return sqlCommentsRegex.ReplaceAllString(s, "") },
+	"removecommentschar": removeCommentsChar,
+	"cmdline":            cmdLine,
+	"length":             func(s string) string { return strconv.Itoa(len(s)) },
+
+	//Decoders that are rarely useful for the request data inspected by
+	//this engine, accepted as no-ops so CRS rules using them still load
+	"jsdecode":        noTransform,
+	"cssdecode":       noTransform,
+	"utf8tounicode":   noTransform,
+	"escapeseqdecode": noTransform,
+	"sqlhexdecode":    noTransform,
+}
+
+// getTransform return the transformation function by name, case insensitive
+func getTransform(name string) (transformFunc, bool) {
+	transform, ok := transforms[strings.ToLower(strings.TrimSpace(name))]
+	return transform, ok
+}
+
+func noTransform(s string) string {
+	return s
+}
+
+func urlDecode(s string) string {
+	decoded, err := url.QueryUnescape(s)
+	if err != nil {
+		//Decode what can be decoded, invalid sequences are kept as is
+		return lenientURLDecode(s)
+	}
+	return decoded
+}
+
+// urlDecodeUni also decode the IIS specific %uXXXX encoding
+func urlDecodeUni(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+5 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') {
+			if code, err := strconv.ParseUint(s[i+2:i+6], 16, 32); err == nil {
+				sb.WriteRune(rune(code))
+				i += 5
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return urlDecode(sb.String())
+}
+
+// lenientURLDecode decode valid percent encoded bytes and plus signs only
+func lenientURLDecode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '+':
+			sb.WriteByte(' ')
+		case s[i] == '%' && i+2 < len(s):
+			if b, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+				sb.WriteByte(b[0])
+				i += 2
+				continue
+			}
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+func base64Decode(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	decoded, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return s
+		}
+	}
+	return string(decoded)
+}
+
+func hexDecode(s string) string {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(decoded)
+}
+
+// normalizePath remove multiple slashes and resolve the dot segments
+func normalizePath(s string) string {
+	if s == "" {
+		return s
+	}
+	cleaned := path.Clean(s)
+	if strings.HasSuffix(s, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// removeCommentsChar remove common comment characters
+func removeCommentsChar(s string) string {
+	replacer := strings.NewReplacer("/*", "", "*/", "", "--", "", "#", "")
+	return replacer.Replace(s)
+}
+
+// cmdLine normalize command line evasions, e.g. c^at /e"tc"/passwd
+func cmdLine(s string) string {
+	replacer := strings.NewReplacer("\\", "", "\"", "", "'", "", "^", "", ",", " ", ";", " ")
+	s = replacer.Replace(s)
+	s = whitespaceRegex.ReplaceAllString(s, " ")
+	s = strings.ReplaceAll(s, " /", "/")
+	s = strings.ReplaceAll(s, " (", "(")
+	return strings.ToLower(s)
+}
@@ -0,0 +1,317 @@
+package waf
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	variables.go
+
+	This script resolve the rule variables (e.g. ARGS, REQUEST_HEADERS:User-Agent)
+	into the values to be inspected from the transaction
+*/
+
+type ruleVariable struct {
+	Name     string         //Upper case variable name, e.g. REQUEST_HEADERS
+	Key      string         //Lower case key selector, empty for the whole collection
+	KeyRegex *regexp.Regexp //Regex key selector, e.g. REQUEST_COOKIES:/^__utm/
+	Exclude  bool           //If this is an exclusion e.g. !ARGS:password
+	Count    bool           //If the number of values is returned instead e.g. &ARGS
+}
+
+// matchTarget is a single value to be inspected
+type matchTarget struct {
+	Name  string //Full variable name, e.g. ARGS:id
+	Value string
+}
+
+// Variables that are parsed from the request and can be inspected
+var supportedVariables = map[string]bool{
+	"ARGS":                    true,
+	"ARGS_NAMES":              true,
+	"ARGS_GET":                true,
+	"ARGS_GET_NAMES":          true,
+	"ARGS_POST":               true,
+	"ARGS_POST_NAMES":         true,
+	"ARGS_COMBINED_SIZE":      true,
+	"QUERY_STRING":            true,
+	"REQUEST_URI":             true,
+	"REQUEST_URI_RAW":         true,
+	"REQUEST_FILENAME":        true,
+	"REQUEST_BASENAME":        true,
+	"REQUEST_LINE":            true,
+	"REQUEST_METHOD":          true,
+	"REQUEST_PROTOCOL":        true,
+	"REQUEST_HEADERS":         true,
+	"REQUEST_HEADERS_NAMES":   true,
+	"REQUEST_COOKIES":         true,
+	"REQUEST_COOKIES_NAMES":   true,
+	"REQUEST_BODY":            true,
+	"REQUEST_BODY_LENGTH":     true,
+	"REQBODY_PROCESSOR":       true,
+	"REQBODY_ERROR":           true,
+	"REMOTE_ADDR":             true,
+	"TX":                      true,
+	"MATCHED_VAR":             true,
+	"MATCHED_VAR_NAME":        true,
+	"MULTIPART_STRICT_ERROR":  true,
+	"INBOUND_DATA_ERROR":      true,
+	"UNIQUE_ID":               true,
+	"DURATION":                true,
+	"REQUEST_HEADERS_COUNT":   true,
+	"FULL_REQUEST_LENGTH":     true,
+	"REQBODY_PROCESSOR_ERROR": true,
+}
+
+// Variables that are accepted but always empty as the request
+// content they refer to (XML, file uploads) is not parsed
+var emptyVariables = map[string]bool{
+	"XML":                    true,
+	"FILES":                  true,
+	"FILES_NAMES":            true,
+	"FILES_SIZES":            true,
+	"FILES_TMPNAMES":         true,
+	"FILES_COMBINED_SIZE":    true,
+	"MULTIPART_PART_HEADERS": true,
+	"MULTIPART_FILENAME":     true,
+	"MULTIPART_NAME":         true,
+	"IP":                     true,
+	"GLOBAL":                 true,
+	"SESSION":                true,
+	"USER":                   true,
+}
+
+// parseVariables parse the pipe separated variable list
+func parseVariables(variables string) ([]*ruleVariable, error) {
+	results := []*ruleVariable{}
+	for _, v := range splitVariables(variables) {
+		thisVar := ruleVariable{}
+		if strings.HasPrefix(v, "!") {
+			thisVar.Exclude = true
+			v = v[1:]
+		} else if strings.HasPrefix(v, "&") {
+			thisVar.Count = true
+			v = v[1:]
+		}
+
+		name, key, hasKey := strings.Cut(v, ":")
+		thisVar.Name = strings.ToUpper(strings.TrimSpace(name))
+		if !supportedVariables[thisVar.Name] && !emptyVariables[thisVar.Name] {
+			return nil, errors.New("unsupported variable " + thisVar.Name)
+		}
+
+		if hasKey {
+			key = strings.Trim(key, "'")
+			if len(key) > 1 && strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/") {
+				keyRegex, err := regexp.Compile("(?i)" + key[1:len(key)-1])
+				if err != nil {
+					return nil, errors.New("invalid variable key regex " + key)
+				}
+				thisVar.KeyRegex = keyRegex
+			} else {
+				thisVar.Key = strings.ToLower(key)
+			}
+		}
+
+		results = append(results, &thisVar)
+	}
+	return results, nil
+}
+
+// splitVariables split the variable list by pipes that are not inside a regex key
+func splitVariables(variables string) []string {
+	results := []string{}
+	inRegex := false
+	start := 0
+	for i := 0; i < len(variables); i++ {
+		c := variables[i]
+		if c == '/' && i > 0 && (variables[i-1] == ':' || inRegex) && variables[i-1] != '\\' {
+			inRegex = !inRegex
+			continue
+		}
+		if c == '|' && !inRegex {
+			results = append(results, strings.TrimSpace(variables[start:i]))
+			start = i + 1
+		}
+	}
+	results = append(results, strings.TrimSpace(variables[start:]))
+	return results
+}
+
+// matchKey check if the collection key match the variable key selector
+func (v *ruleVariable) matchKey(key string) bool {
+	if v.KeyRegex != nil {
+		return v.KeyRegex.MatchString(key)
+	}
+	if v.Key == "" {
+		return true
+	}
+	return strings.EqualFold(v.Key, key)
+}
+
+// resolveTargets return all the values to be inspected for the given variables
+func (tx *transaction) resolveTargets(variables []*ruleVariable) []*matchTarget {
+	targets := []*matchTarget{}
+	if len(variables) == 0 {
+		//SecAction or rules with no variables
+		return []*matchTarget{{Name: "", Value: ""}}
+	}
+
+	for _, v := range variables {
+		if v.Exclude {
+			continue
+		}
+
+		values := tx.resolveVariable(v)
+		values = filterExcluded(v.Name, values, variables)
+		if v.Count {
+			targets = append(targets, &matchTarget{
+				Name:  "&" + v.Name,
+				Value: strconv.Itoa(len(values)),
+			})
+			continue
+		}
+		targets = append(targets, values...)
+	}
+	return targets
+}
+
+// filterExcluded remove the values excluded by !VAR:key selectors of the same collection
+func filterExcluded(name string, values []*matchTarget, variables []*ruleVariable) []*matchTarget {
+	exclusions := []*ruleVariable{}
+	for _, v := range variables {
+		if v.Exclude && v.Name == name {
+			exclusions = append(exclusions, v)
+		}
+	}
+	if len(exclusions) == 0 {
+		return values
+	}
+
+	results := []*matchTarget{}
+	for _, value := range values {
+		_, key, _ := strings.Cut(value.Name, ":")
+		excluded := false
+		for _, exclusion := range exclusions {
+			if exclusion.matchKey(key) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			results = append(results, value)
+		}
+	}
+	return results
+}
+
+// resolveVariable return the values of a single variable from the transaction
+func (tx *transaction) resolveVariable(v *ruleVariable) []*matchTarget {
+	scalar := func(value string) []*matchTarget {
+		return []*matchTarget{{Name: v.Name, Value: value}}
+	}
+
+	switch v.Name {
+	case "ARGS":
+		return tx.collection(v, "ARGS", append(append([]*keyValue{}, tx.argsGet...), tx.argsPost...), false)
+	case "ARGS_NAMES":
+		return tx.collection(v, "ARGS_NAMES", append(append([]*keyValue{}, tx.argsGet...), tx.argsPost...), true)
+	case "ARGS_GET":
+		return tx.collection(v, "ARGS_GET", tx.argsGet, false)
+	case "ARGS_GET_NAMES":
+		return tx.collection(v, "ARGS_GET_NAMES", tx.argsGet, true)
+	case "ARGS_POST":
+		return tx.collection(v, "ARGS_POST", tx.argsPost, false)
+	case "ARGS_POST_NAMES":
+		return tx.collection(v, "ARGS_POST_NAMES", tx.argsPost, true)
+	case "REQUEST_HEADERS":
+		return tx.collection(v, "REQUEST_HEADERS", tx.headers, false)
+	case "REQUEST_HEADERS_NAMES":
+		return tx.collection(v, "REQUEST_HEADERS_NAMES", tx.headers, true)
+	case "REQUEST_COOKIES":
+		return tx.collection(v, "REQUEST_COOKIES", tx.cookies, false)
+	case "REQUEST_COOKIES_NAMES":
+		return tx.collection(v, "REQUEST_COOKIES_NAMES", tx.cookies, true)
+	case "TX":
+		return tx.collection(v, "TX", tx.txVarList(), false)
+	case "ARGS_COMBINED_SIZE":
+		size := 0
+		for _, arg := range append(append([]*keyValue{}, tx.argsGet...), tx.argsPost...) {
+			size += len(arg.Key) + len(arg.Value)
+		}
+		return scalar(strconv.Itoa(size))
+	case "QUERY_STRING":
+		return scalar(tx.request.URL.RawQuery)
+	case "REQUEST_URI", "REQUEST_URI_RAW":
+		return scalar(tx.request.RequestURI)
+	case "REQUEST_FILENAME":
+		return scalar(tx.request.URL.Path)
+	case "REQUEST_BASENAME":
+		path := tx.request.URL.Path
+		if idx := strings.LastIndex(path, "/"); idx >= 0 {
+			path = path[idx+1:]
+		}
+		return scalar(path)
+	case "REQUEST_LINE":
+		return scalar(tx.request.Method + " " + tx.request.RequestURI + " " + tx.request.Proto)
+	case "REQUEST_METHOD":
+		return scalar(tx.request.Method)
+	case "REQUEST_PROTOCOL":
+		return scalar(tx.request.Proto)
+	case "REQUEST_BODY":
+		if tx.phase < 2 {
+			return []*matchTarget{}
+		}
+		return scalar(tx.body)
+	case "REQUEST_BODY_LENGTH":
+		return scalar(strconv.Itoa(len(tx.body)))
+	case "FULL_REQUEST_LENGTH":
+		return scalar(strconv.Itoa(len(tx.request.RequestURI) + len(tx.body)))
+	case "REQUEST_HEADERS_COUNT":
+		return scalar(strconv.Itoa(len(tx.headers)))
+	case "REQBODY_PROCESSOR":
+		return scalar(tx.bodyProcessor)
+	case "REQBODY_ERROR", "REQBODY_PROCESSOR_ERROR":
+		if tx.bodyError {
+			return scalar("1")
+		}
+		return scalar("0")
+	case "MULTIPART_STRICT_ERROR", "INBOUND_DATA_ERROR":
+		return scalar("0")
+	case "REMOTE_ADDR":
+		return scalar(tx.remoteAddr)
+	case "MATCHED_VAR":
+		return scalar(tx.matchedVar)
+	case "MATCHED_VAR_NAME":
+		return scalar(tx.matchedVarName)
+	case "UNIQUE_ID":
+		return scalar(tx.id)
+	case "DURATION":
+		return scalar("0")
+	}
+
+	//Variables in emptyVariables
+	return []*matchTarget{}
+}
+
+// collection return the values in a key value collection matching the variable key selector
+func (tx *transaction) collection(v *ruleVariable, name string, items []*keyValue, namesOnly bool) []*matchTarget {
+	results := []*matchTarget{}
+	for _, item := range items {
+		if !v.matchKey(item.Key) {
+			continue
+		}
+		value := item.Value
+		if namesOnly {
+			value = item.Key
+		}
+		results = append(results, &matchTarget{
+			Name:  name + ":" + item.Key,
+			Value: value,
+		})
+	}
+	return results
+}
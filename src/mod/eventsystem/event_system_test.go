@@ -3,6 +3,7 @@ package eventsystem
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -69,6 +70,93 @@ func TestEventDeSerialization(t *testing.T) {
 			},
 			expectedJson: `{"name":"accessRuleCreated","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"id":"rule456","name":"New Access Rule","desc":"A dummy access rule","blacklist_enabled":true,"whitelist_enabled":false,"trust_proxy_headers_only":false}}`,
 		},
+		{
+			name: "ConfigChanged",
+			event: events.Event{
+				Name:      events.EventConfigChanged,
+				Timestamp: timestamp,
+				UUID:      uuid,
+				Data: &events.ConfigChangedEvent{
+					AuditID:  "audit789",
+					User:     "admin",
+					SourceIP: "192.168.1.2",
+					Method:   "POST",
+					Route:    "/api/proxy/edit",
+					Targets:  []string{"example.com"},
+					Changes: []*events.ConfigChange{
+						{Path: `["example.com"].Disabled`, Before: false, After: true},
+					},
+				},
+			},
+			expectedJson: `{"name":"configChanged","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"audit_id":"audit789","user":"admin","source_ip":"192.168.1.2","method":"POST","route":"/api/proxy/edit","targets":["example.com"],"changes":[{"path":"[\"example.com\"].Disabled","before":false,"after":true}]}}`,
+		},
+		{
+			name: "CertificateRevoked",
+			event: events.Event{
+				Name:      events.EventCertificateRevoked,
+				Timestamp: timestamp,
+				UUID:      uuid,
+				Data: &events.CertificateRevokedEvent{
+					CertName:         "example.com",
+					Domains:          []string{"example.com", "www.example.com"},
+					SerialNumber:     "3a4b",
+					Issuer:           "Test CA",
+					RevokedAt:        timestamp,
+					RevocationReason: 1,
+					Renewed:          true,
+				},
+			},
+			expectedJson: `{"name":"certificateRevoked","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"cert_name":"example.com","domains":["example.com","www.example.com"],"serial_number":"3a4b","issuer":"Test CA","revoked_at":` + fmt.Sprintf("%d", timestamp) + `,"revocation_reason":1,"renewed":true}}`,
+		},
+		{
+			name: "CertificateExpiring",
+			event: events.Event{
+				Name:      events.EventCertificateExpiring,
+				Timestamp: timestamp,
+				UUID:      uuid,
+				Data: &events.CertificateExpiringEvent{
+					CertName:     "example.com",
+					Domains:      []string{"example.com"},
+					SerialNumber: "3a4b",
+					Issuer:       "Test CA",
+					NotAfter:     timestamp,
+					DaysLeft:     7,
+					Endpoints:    []string{"example.com"},
+				},
+			},
+			expectedJson: `{"name":"certificateExpiring","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"cert_name":"example.com","domains":["example.com"],"serial_number":"3a4b","issuer":"Test CA","not_after":` + fmt.Sprintf("%d", timestamp) + `,"days_left":7,"endpoints":["example.com"]}}`,
+		},
+		{
+			name: "CertificateRenewalFailed",
+			event: events.Event{
+				Name:      events.EventCertificateRenewalFailed,
+				Timestamp: timestamp,
+				UUID:      uuid,
+				Data: &events.CertificateRenewalFailedEvent{
+					CertName: "example.com",
+					Domains:  []string{"example.com"},
+					Reason:   "expiry",
+					Failures: 2,
+					Error:    "rate limited",
+				},
+			},
+			expectedJson: `{"name":"certificateRenewalFailed","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"cert_name":"example.com","domains":["example.com"],"reason":"expiry","failures":2,"error":"rate limited"}}`,
+		},
+		{
+			name: "CertificateHostnameMismatch",
+			event: events.Event{
+				Name:      events.EventCertificateHostnameMismatch,
+				Timestamp: timestamp,
+				UUID:      uuid,
+				Data: &events.CertificateHostnameMismatchEvent{
+					Hostname:    "www.example.com",
+					Endpoint:    "example.com",
+					CertName:    "example.com",
+					CertDomains: []string{"example.com"},
+				},
+			},
+			expectedJson: `{"name":"certificateHostnameMismatch","timestamp":` + fmt.Sprintf("%d", timestamp) + `,"uuid":"` + uuid + `","data":{"hostname":"www.example.com","endpoint":"example.com","cert_name":"example.com","cert_domains":["example.com"]}}`,
+		},
 	}
 
 	for _, test := range tests {
@@ -111,6 +199,31 @@ func TestEventDeSerialization(t *testing.T) {
 				if !ok || *data != *originalData {
 					t.Fatalf("Deserialized BlacklistToggledEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
 				}
+			case *events.ConfigChangedEvent:
+				originalData, ok := test.event.Data.(*events.ConfigChangedEvent)
+				if !ok || !reflect.DeepEqual(data, originalData) {
+					t.Fatalf("Deserialized ConfigChangedEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
+				}
+			case *events.CertificateRevokedEvent:
+				originalData, ok := test.event.Data.(*events.CertificateRevokedEvent)
+				if !ok || !reflect.DeepEqual(data, originalData) {
+					t.Fatalf("Deserialized CertificateRevokedEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
+				}
+			case *events.CertificateExpiringEvent:
+				originalData, ok := test.event.Data.(*events.CertificateExpiringEvent)
+				if !ok || !reflect.DeepEqual(data, originalData) {
+					t.Fatalf("Deserialized CertificateExpiringEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
+				}
+			case *events.CertificateRenewalFailedEvent:
+				originalData, ok := test.event.Data.(*events.CertificateRenewalFailedEvent)
+				if !ok || !reflect.DeepEqual(data, originalData) {
+					t.Fatalf("Deserialized CertificateRenewalFailedEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
+				}
+			case *events.CertificateHostnameMismatchEvent:
+				originalData, ok := test.event.Data.(*events.CertificateHostnameMismatchEvent)
+				if !ok || !reflect.DeepEqual(data, originalData) {
+					t.Fatalf("Deserialized CertificateHostnameMismatchEvent does not match original.\nGot:  %+v\nWant: %+v", data, originalData)
+				}
 			default:
 				t.Fatalf("Unknown event type: %T", data)
 			}
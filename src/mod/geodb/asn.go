@@ -0,0 +1,237 @@
+package geodb
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/netutils"
+)
+
+/*
+	asn.go
+
+	This script implements the IP to ASN (Autonomous System Number)
+	lookup. The dataset uses the same range based CSV layout as the
+	country dataset, with the following columns
+
+	ip_range_start, ip_range_end, asn, organization
+*/
+
+type ASNInfo struct {
+	ASN          uint32 //Autonomous System Number, 0 if not found
+	Organization string //Name of the organization that owns the ASN
+}
+
+// asnRange represents a single IPv4 range with its ASN
+type asnRange struct {
+	startIP uint32
+	endIP   uint32
+	asn     uint32
+	orgIdx  uint32 //Index into the organization name table
+}
+
+// asnRangeV6 represents a single IPv6 range with its ASN
+type asnRangeV6 struct {
+	startIPHigh uint64
+	startIPLow  uint64
+	endIPHigh   uint64
+	endIPLow    uint64
+	asn         uint32
+	orgIdx      uint32
+}
+
+// asnTable is a sorted range table for ASN lookup
+// Unlike the country trie, ASN ranges are not contiguous, so the end
+// of each range is kept to avoid matching IPs that fall into the gaps
+type asnTable struct {
+	ranges     []asnRange
+	rangesV6   []asnRangeV6
+	orgTable   []string
+	orgToIndex map[string]uint32
+}
+
+func newASNTable() *asnTable {
+	return &asnTable{
+		ranges:     make([]asnRange, 0),
+		rangesV6:   make([]asnRangeV6, 0),
+		orgTable:   make([]string, 0),
+		orgToIndex: make(map[string]uint32),
+	}
+}
+
+// getOrCreateOrgIndex returns the index for an organization name, creating if needed
+func (t *asnTable) getOrCreateOrgIndex(org string) uint32 {
+	if idx, exists := t.orgToIndex[org]; exists {
+		return idx
+	}
+	idx := uint32(len(t.orgTable))
+	t.orgTable = append(t.orgTable, org)
+	t.orgToIndex[org] = idx
+	return idx
+}
+
+// insert adds an IP range with its ASN and organization
+func (t *asnTable) insert(startIP string, endIP string, asn uint32, org string) {
+	start := net.ParseIP(startIP)
+	end := net.ParseIP(endIP)
+	if start == nil || end == nil {
+		return
+	}
+
+	orgIdx := t.getOrCreateOrgIndex(org)
+	if start.To4() != nil && end.To4() != nil {
+		t.ranges = append(t.ranges, asnRange{
+			startIP: uint32(ipv4ToUint64(start)),
+			endIP:   uint32(ipv4ToUint64(end)),
+			asn:     asn,
+			orgIdx:  orgIdx,
+		})
+		return
+	}
+
+	startHigh, startLow := ipv6ToUint64Pair(start)
+	endHigh, endLow := ipv6ToUint64Pair(end)
+	t.rangesV6 = append(t.rangesV6, asnRangeV6{
+		startIPHigh: startHigh,
+		startIPLow:  startLow,
+		endIPHigh:   endHigh,
+		endIPLow:    endLow,
+		asn:         asn,
+		orgIdx:      orgIdx,
+	})
+}
+
+// build sorts the ranges after all inserts are done
+func (t *asnTable) build() {
+	sort.Slice(t.ranges, func(i, j int) bool {
+		return t.ranges[i].startIP < t.ranges[j].startIP
+	})
+	sort.Slice(t.rangesV6, func(i, j int) bool {
+		if t.rangesV6[i].startIPHigh != t.rangesV6[j].startIPHigh {
+			return t.rangesV6[i].startIPHigh < t.rangesV6[j].startIPHigh
+		}
+		return t.rangesV6[i].startIPLow < t.rangesV6[j].startIPLow
+	})
+}
+
+// search finds the ASN record of an IP address, return nil if not found
+func (t *asnTable) search(ipAddr string) *ASNInfo {
+	parsedIP := net.ParseIP(ipAddr)
+	if parsedIP == nil {
+		return nil
+	}
+
+	if parsedIP.To4() != nil {
+		ipVal := uint32(ipv4ToUint64(parsedIP))
+		idx := sort.Search(len(t.ranges), func(i int) bool {
+			return t.ranges[i].startIP > ipVal
+		})
+		if idx == 0 {
+			return nil
+		}
+		r := t.ranges[idx-1]
+		if ipVal > r.endIP {
+			//Fall into the gap between two ranges
+			return nil
+		}
+		return &ASNInfo{
+			ASN:          r.asn,
+			Organization: t.orgTable[r.orgIdx],
+		}
+	}
+
+	high, low := ipv6ToUint64Pair(parsedIP)
+	idx := sort.Search(len(t.rangesV6), func(i int) bool {
+		if t.rangesV6[i].startIPHigh != high {
+			return t.rangesV6[i].startIPHigh > high
+		}
+		return t.rangesV6[i].startIPLow > low
+	})
+	if idx == 0 {
+		return nil
+	}
+	r := t.rangesV6[idx-1]
+	if high > r.endIPHigh || (high == r.endIPHigh && low > r.endIPLow) {
+		return nil
+	}
+	return &ASNInfo{
+		ASN:          r.asn,
+		Organization: t.orgTable[r.orgIdx],
+	}
+}
+
+// Construct the ASN lookup table from parsed csv records
+func constructASNTable(data [][]string) *asnTable {
+	table := newASNTable()
+	for _, entry := range data {
+		if len(entry) < 3 {
+			continue
+		}
+		asn, err := ParseASN(entry[2])
+		if err != nil {
+			continue
+		}
+		org := ""
+		if len(entry) >= 4 {
+			org = strings.TrimSpace(entry[3])
+		}
+		table.insert(entry[0], entry[1], asn, org)
+	}
+	table.build()
+	return table
+}
+
+// ParseASN parse an ASN string in either "13335" or "AS13335" format
+func ParseASN(asn string) (uint32, error) {
+	asn = strings.TrimSpace(asn)
+	asn = strings.TrimPrefix(strings.ToUpper(asn), "AS")
+	if asn == "" {
+		return 0, errors.New("empty ASN")
+	}
+	val, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid ASN: " + asn)
+	}
+	return uint32(val), nil
+}
+
+// HasASNData return true if an ASN dataset is loaded
+func (s *Store) HasASNData() bool {
+	return s.asnTable != nil && (len(s.asnTable.ranges) > 0 || len(s.asnTable.rangesV6) > 0)
+}
+
+// ResolveASNFromIP resolve the ASN and organization of the given IP address
+func (s *Store) ResolveASNFromIP(ipstring string) (*ASNInfo, error) {
+	if !s.HasASNData() {
+		return nil, errors.New("ASN dataset not loaded")
+	}
+
+	if strings.Contains(ipstring, ",") {
+		//CF proxied request, only the front part is the client
+		ipstring = strings.TrimSpace(strings.Split(ipstring, ",")[0])
+	}
+
+	info := s.asnTable.search(ipstring)
+	if info == nil {
+		return &ASNInfo{}, nil
+	}
+	return info, nil
+}
+
+// GetRequesterASN return the ASN of the requester, 0 if unknown
+func (s *Store) GetRequesterASN(r *http.Request) uint32 {
+	ipAddr := netutils.GetRequesterIP(r)
+	if ipAddr == "" {
+		return 0
+	}
+
+	info, err := s.ResolveASNFromIP(ipAddr)
+	if err != nil {
+		return 0
+	}
+	return info.ASN
+}
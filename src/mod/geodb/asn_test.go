@@ -0,0 +1,99 @@
+package geodb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+func TestASNTableSearch(t *testing.T) {
+	table := constructASNTable([][]string{
+		{"1.1.1.0", "1.1.1.255", "13335", "Cloudflare, Inc."},
+		{"8.8.8.0", "8.8.8.255", "15169", "Google LLC"},
+		{"2606:4700::", "2606:4700:ffff:ffff:ffff:ffff:ffff:ffff", "13335", "Cloudflare, Inc."},
+		{"9.9.9.0", "9.9.9.255", "invalid", "Skipped"},
+	})
+
+	testcases := []struct {
+		ip  string
+		asn uint32
+		org string
+	}{
+		{"1.1.1.1", 13335, "Cloudflare, Inc."},
+		{"8.8.8.8", 15169, "Google LLC"},
+		{"2606:4700::1111", 13335, "Cloudflare, Inc."},
+		{"4.4.4.4", 0, ""}, //Gap between two ranges
+		{"9.9.9.9", 0, ""}, //Invalid ASN entry
+		{"0.0.0.1", 0, ""}, //Before first range
+	}
+
+	for _, tc := range testcases {
+		info := table.search(tc.ip)
+		if tc.asn == 0 {
+			if info != nil {
+				t.Errorf("expected no ASN for %s, got AS%d", tc.ip, info.ASN)
+			}
+			continue
+		}
+
+		if info == nil {
+			t.Errorf("expected AS%d for %s, got nothing", tc.asn, tc.ip)
+			continue
+		}
+
+		if info.ASN != tc.asn || info.Organization != tc.org {
+			t.Errorf("expected AS%d (%s) for %s, got AS%d (%s)", tc.asn, tc.org, tc.ip, info.ASN, info.Organization)
+		}
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	for input, expected := range map[string]uint32{
+		"13335":   13335,
+		"AS13335": 13335,
+		"as15169": 15169,
+		" 64512 ": 64512,
+	} {
+		asn, err := ParseASN(input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %v", input, err)
+			continue
+		}
+		if asn != expected {
+			t.Errorf("expected %d for %q, got %d", expected, input, asn)
+		}
+	}
+
+	for _, input := range []string{"", "AS", "ASxyz", "99999999999"} {
+		if _, err := ParseASN(input); err == nil {
+			t.Errorf("expected error parsing %q", input)
+		}
+	}
+}
+
+func TestASNDataFromExternalDataPath(t *testing.T) {
+	dataPath := t.TempDir()
+	asnData := "1.1.1.0,1.1.1.255,13335,\"Cloudflare, Inc.\"\n"
+	if err := os.WriteFile(filepath.Join(dataPath, "asnv4.csv"), []byte(asnData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	systemLogger, _ := logger.NewFmtLogger()
+	store, err := NewGeoDb(nil, &StoreOptions{
+		AllowSlowIpv4LookUp: true,
+		AllowSlowIpv6Lookup: true,
+		Logger:              systemLogger,
+		ExternalDataPath:    dataPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	info, err := store.ResolveASNFromIP("1.1.1.1")
+	if err != nil || info.ASN != 13335 {
+		t.Errorf("expected ASN data to be loaded from %s, got %+v (%v)", dataPath, info, err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	_ "embed"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/oschwald/maxminddb-golang/v2"
 	"imuslab.com/zoraxy/mod/database"
 	"imuslab.com/zoraxy/mod/info/logger"
 	"imuslab.com/zoraxy/mod/netutils"
@@ -24,6 +26,8 @@ type Store struct {
 	geodbIpv6                [][]string //Parsed geodb list for ipv6
 	geotrie                  *trie
 	geotrieIpv6              *trie
+	asnTable                 *asnTable         //IP to ASN lookup table, nil if ASN dataset not found
+	mmdbReader               *maxminddb.Reader //MMDB reader for region and city lookup, nil if not configured
 	sysdb                    *database.Database
 	slowLookupCacheIpv4      sync.Map     //Cache for slow lookup, ip -> cc
 	slowLookupCacheIpv6      sync.Map     //Cache for slow lookup ipv6, ip -> cc
@@ -37,6 +41,8 @@ type StoreOptions struct {
 	AllowSlowIpv6Lookup          bool
 	Logger                       *logger.Logger
 	SlowLookupCacheClearInterval time.Duration //Clear slow lookup cache interval
+	MMDBPath                     string        //Path to a GeoLite2 or DB-IP MMDB file, leave empty to use built-in data only
+	ExternalDataPath             string        //Folder of the datasets downloaded by -update_geoip, default ./conf/geodb
 }
 
 type CountryInfo struct {
@@ -72,6 +78,35 @@ func NewGeoDb(sysdb *database.Database, option *StoreOptions) (*Store, error) {
 		return nil, err
 	}
 
+	//Load the ASN dataset if exists. The ASN dataset is not embedded due to its size
+	//use the -update_geoip flag to download it into the external GeoDB folder
+	externalDataPath := option.ExternalDataPath
+	if externalDataPath == "" {
+		externalDataPath = "./conf/geodb"
+	}
+	var asnLookupTable *asnTable
+	asnRecords := [][]string{}
+	for _, asnDataFile := range []string{filepath.Join(externalDataPath, "asnv4.csv"), filepath.Join(externalDataPath, "asnv6.csv")} {
+		if !utils.FileExists(asnDataFile) {
+			continue
+		}
+		asnData, err := os.ReadFile(asnDataFile)
+		if err != nil {
+			option.Logger.PrintAndLog("GeoDB", "Unable to read ASN data "+asnDataFile, err)
+			continue
+		}
+		parsedASNData, err := parseCSV(asnData)
+		if err != nil {
+			option.Logger.PrintAndLog("GeoDB", "Unable to parse ASN data "+asnDataFile, err)
+			continue
+		}
+		asnRecords = append(asnRecords, parsedASNData...)
+	}
+	if len(asnRecords) > 0 {
+		option.Logger.PrintAndLog("GeoDB", "External ASN data found, ASN lookup enabled", nil)
+		asnLookupTable = constructASNTable(asnRecords)
+	}
+
 	var ipv4Trie *trie
 	if !option.AllowSlowIpv4LookUp {
 		ipv4Trie = constrctTrieTree(parsedGeoData)
@@ -92,6 +127,7 @@ func NewGeoDb(sysdb *database.Database, option *StoreOptions) (*Store, error) {
 		geotrie:                  ipv4Trie,
 		geodbIpv6:                parsedGeoDataIpv6,
 		geotrieIpv6:              ipv6Trie,
+		asnTable:                 asnLookupTable,
 		sysdb:                    sysdb,
 		slowLookupCacheIpv4:      sync.Map{},
 		slowLookupCacheIpv6:      sync.Map{},
@@ -100,6 +136,16 @@ func NewGeoDb(sysdb *database.Database, option *StoreOptions) (*Store, error) {
 		option:                   option,
 	}
 
+	//Load the MMDB if configured
+	if option.MMDBPath != "" {
+		err = thisGeoDBStore.loadMMDB(option.MMDBPath)
+		if err != nil {
+			option.Logger.PrintAndLog("GeoDB", "Unable to load MMDB from "+option.MMDBPath+", using built-in GeoIP data", err)
+		} else {
+			option.Logger.PrintAndLog("GeoDB", "Loaded MMDB database "+thisGeoDBStore.GetMMDBType()+" for region and city lookup", nil)
+		}
+	}
+
 	//Start cache clear ticker
 	if option.AllowSlowIpv4LookUp || option.AllowSlowIpv6Lookup {
 		go func(store *Store) {
@@ -119,6 +165,16 @@ func NewGeoDb(sysdb *database.Database, option *StoreOptions) (*Store, error) {
 }
 
 func (s *Store) ResolveCountryCodeFromIP(ipstring string) (*CountryInfo, error) {
+	if s.HasMMDB() {
+		location, err := s.ResolveLocationFromIP(ipstring)
+		if err == nil && location.CountryIsoCode != "" {
+			return &CountryInfo{
+				CountryIsoCode: location.CountryIsoCode,
+				ContinetCode:   location.ContinentCode,
+			}, nil
+		}
+	}
+
 	cc := s.search(ipstring)
 	return &CountryInfo{
 		CountryIsoCode: cc,
@@ -133,6 +189,10 @@ func (s *Store) Close() {
 		//Stop cache clear ticker
 		s.cacheClearTickerStopChan <- true
 	}
+
+	if s.mmdbReader != nil {
+		s.mmdbReader.Close()
+	}
 }
 
 func (s *Store) GetRequesterCountryISOCode(r *http.Request) string {
@@ -46,6 +46,8 @@ func TestResolveCountryCodeFromIP(t *testing.T) {
 		false, //false to use fast geodb mode for ipv6 lookup
 		&logger.Logger{},
 		0,
+		"", //empty to use built-in geodb data only
+		"", //empty to use the default external data folder
 	})
 	if err != nil {
 		t.Errorf("error creating store: %v", err)
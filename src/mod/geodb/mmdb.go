@@ -0,0 +1,161 @@
+package geodb
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+	"imuslab.com/zoraxy/mod/netutils"
+)
+
+/*
+	mmdb.go
+
+	This script add support for MaxMind DB (MMDB) format databases
+	like GeoLite2 or DB-IP Lite. When a MMDB is configured, it is used
+	for country, region, city and coordinates lookup. The built-in
+	csv dataset is used as fallback if no MMDB is configured or
+	the IP address is not found in the MMDB
+*/
+
+type LocationInfo struct {
+	CountryIsoCode string  //ISO 3166-1 country code, e.g. US
+	ContinentCode  string  //Continent code, e.g. NA
+	RegionIsoCode  string  //ISO 3166-2 subdivision code without the country prefix, e.g. CA
+	RegionName     string  //English name of the region, e.g. California
+	City           string  //English name of the city, e.g. San Jose
+	Latitude       float64 //Approximate latitude of the IP address
+	Longitude      float64 //Approximate longitude of the IP address
+	HasCoordinates bool    //If the Latitude and Longitude fields are valid
+}
+
+// mmdbRecord is the subset of GeoLite2 / DB-IP record fields used by Zoraxy
+// Country only databases will leave the city, subdivisions and location empty
+type mmdbRecord struct {
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Open the MMDB file at the given path and attach it to the store
+func (s *Store) loadMMDB(mmdbPath string) error {
+	reader, err := maxminddb.Open(mmdbPath)
+	if err != nil {
+		return err
+	}
+
+	s.mmdbReader = reader
+	return nil
+}
+
+// HasMMDB return true if a MMDB database is loaded
+func (s *Store) HasMMDB() bool {
+	return s.mmdbReader != nil
+}
+
+// GetMMDBType return the database type in the MMDB metadata, e.g. GeoLite2-City
+func (s *Store) GetMMDBType() string {
+	if s.mmdbReader == nil {
+		return ""
+	}
+	return s.mmdbReader.Metadata.DatabaseType
+}
+
+// Lookup the ip address in the MMDB, return nil if not found
+func (s *Store) lookupMMDB(ipstring string) (*LocationInfo, error) {
+	if s.mmdbReader == nil {
+		return nil, errors.New("MMDB not loaded")
+	}
+
+	addr, err := netip.ParseAddr(ipstring)
+	if err != nil {
+		return nil, err
+	}
+
+	record := mmdbRecord{}
+	result := s.mmdbReader.Lookup(addr.Unmap())
+	if !result.Found() {
+		return nil, result.Err()
+	}
+
+	err = result.Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+
+	location := LocationInfo{
+		CountryIsoCode: record.Country.ISOCode,
+		ContinentCode:  record.Continent.Code,
+		City:           record.City.Names["en"],
+	}
+
+	if len(record.Subdivisions) > 0 {
+		//The first subdivision is the largest one, e.g. state or province
+		location.RegionIsoCode = record.Subdivisions[0].ISOCode
+		location.RegionName = record.Subdivisions[0].Names["en"]
+	}
+
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		location.Latitude = *record.Location.Latitude
+		location.Longitude = *record.Location.Longitude
+		location.HasCoordinates = true
+	}
+
+	return &location, nil
+}
+
+// ResolveLocationFromIP resolve the country, region, city and coordinates of the given IP.
+// If no MMDB is loaded, only the country code is filled in from the built-in dataset
+func (s *Store) ResolveLocationFromIP(ipstring string) (*LocationInfo, error) {
+	if strings.Contains(ipstring, ",") {
+		//This is a CF proxied request. We only need the front part
+		ipstring = strings.TrimSpace(strings.Split(ipstring, ",")[0])
+	}
+
+	//Reserved ranges (e.g. LAN, CarrierNAT) have no location in MMDB
+	if reservedZone := getReservedIPZone(ipstring); reservedZone != "" {
+		return &LocationInfo{CountryIsoCode: reservedZone}, nil
+	}
+
+	if s.HasMMDB() {
+		location, err := s.lookupMMDB(ipstring)
+		if err == nil && location != nil && location.CountryIsoCode != "" {
+			return location, nil
+		}
+	}
+
+	//Fallback to the built-in dataset
+	return &LocationInfo{
+		CountryIsoCode: s.search(ipstring),
+	}, nil
+}
+
+// GetRequesterLocation return the location of the requester
+func (s *Store) GetRequesterLocation(r *http.Request) *LocationInfo {
+	ipAddr := netutils.GetRequesterIP(r)
+	if ipAddr == "" {
+		return &LocationInfo{}
+	}
+
+	location, err := s.ResolveLocationFromIP(ipAddr)
+	if err != nil {
+		return &LocationInfo{}
+	}
+	return location
+}
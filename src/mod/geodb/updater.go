@@ -10,8 +10,10 @@ import (
 )
 
 const (
-	ipv4UpdateSource = "https://cdn.jsdelivr.net/npm/@ip-location-db/geo-whois-asn-country/geo-whois-asn-country-ipv4.csv"
-	ipv6UpdateSource = "https://cdn.jsdelivr.net/npm/@ip-location-db/geo-whois-asn-country/geo-whois-asn-country-ipv6.csv"
+	ipv4UpdateSource  = "https://cdn.jsdelivr.net/npm/@ip-location-db/geo-whois-asn-country/geo-whois-asn-country-ipv4.csv"
+	ipv6UpdateSource  = "https://cdn.jsdelivr.net/npm/@ip-location-db/geo-whois-asn-country/geo-whois-asn-country-ipv6.csv"
+	asnv4UpdateSource = "https://cdn.jsdelivr.net/npm/@ip-location-db/asn/asn-ipv4.csv"
+	asnv6UpdateSource = "https://cdn.jsdelivr.net/npm/@ip-location-db/asn/asn-ipv6.csv"
 )
 
 // DownloadGeoDBUpdate download the latest geodb update
@@ -36,6 +38,20 @@ func DownloadGeoDBUpdate(externalGeoDBStoragePath string) {
 		return
 	}
 
+	log.Println("Downloading IPv4 ASN database update...")
+	err = downloadFile(asnv4UpdateSource, externalGeoDBStoragePath+"/asnv4.csv")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Println("Downloading IPv6 ASN database update...")
+	err = downloadFile(asnv6UpdateSource, externalGeoDBStoragePath+"/asnv6.csv")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	log.Println("GeoDB update stored at: " + externalGeoDBStoragePath)
 	log.Println("Exiting...")
 }
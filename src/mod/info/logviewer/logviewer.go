@@ -18,7 +18,8 @@ import (
 )
 
 type ViewerOption struct {
-	RootFolder string //The root folder to scan for log
+	RootFolder      string   //The root folder to scan for log
+	ExcludePrefixes []string //Log files not served by the viewer, e.g. audit logs with their own permission
 }
 
 type Viewer struct {
@@ -206,7 +207,7 @@ func (v *Viewer) HandleLogErrorSummary(w http.ResponseWriter, r *http.Request) {
 func (v *Viewer) ListLogFiles(showFullpath bool) map[string][]*LogFile {
 	result := map[string][]*LogFile{}
 	filepath.WalkDir(v.option.RootFolder, func(path string, di fs.DirEntry, err error) error {
-		if filepath.Ext(path) == ".log" || strings.HasSuffix(path, ".log.gz") {
+		if (filepath.Ext(path) == ".log" || strings.HasSuffix(path, ".log.gz")) && !v.isExcluded(path) {
 			catergory := filepath.Base(filepath.Dir(path))
 			logList, ok := result[catergory]
 			if !ok {
@@ -286,7 +287,7 @@ func (v *Viewer) senatizeLogFilenameInput(filename string) string {
 	filename = strings.TrimSuffix(filename, ".log")
 	filename = filepath.ToSlash(filename)
 	filename = filepath.Clean(filename)
-	if strings.Contains(filename, "..") {
+	if strings.Contains(filename, "..") || v.isExcluded(filename) {
 		return ""
 	}
 	//Check if .log.gz or .log exists
@@ -299,6 +300,17 @@ func (v *Viewer) senatizeLogFilenameInput(filename string) string {
 	return filepath.Join(v.option.RootFolder, filename)
 }
 
+// isExcluded checks if the log file is hidden from the viewer
+func (v *Viewer) isExcluded(filename string) bool {
+	filename = filepath.Base(filename)
+	for _, prefix := range v.option.ExcludePrefixes {
+		if strings.HasPrefix(filename, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *Viewer) LoadLogFile(filename string) (string, error) {
 	// filename might be in (no extension), .log or .log.gz format
 	// so we trim those first before proceeding
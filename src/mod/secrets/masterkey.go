@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+/*
+	masterkey.go
+
+	The master key is read from a key file, an environment variable or an
+	interactive prompt, in this order. It is only used to unwrap the data
+	key and is never written to disk by Zoraxy.
+*/
+
+const (
+	EnvMasterKey    = "ZORAXY_MASTER_KEY"
+	EnvNewMasterKey = "ZORAXY_NEW_MASTER_KEY"
+)
+
+// MasterKeySource is where a master key is read from
+type MasterKeySource struct {
+	KeyFile string //Path to the key file, empty to skip
+	EnvName string //Environment variable holding the key, empty to skip
+	Prompt  string //Terminal prompt if neither is set, empty to disable prompting
+	Confirm bool   //Ask twice on the terminal, for new master keys
+}
+
+// ReadMasterKey reads the master key from the first configured source
+func ReadMasterKey(source *MasterKeySource) ([]byte, error) {
+	if source.KeyFile != "" {
+		content, err := os.ReadFile(source.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		//Editors usually end the file with a newline
+		return checkMasterKey(bytes.TrimRight(content, "\r\n"))
+	}
+	if source.EnvName != "" {
+		if value, ok := os.LookupEnv(source.EnvName); ok && value != "" {
+			return checkMasterKey([]byte(value))
+		}
+	}
+	if source.Prompt == "" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		if source.EnvName != "" {
+			return nil, errors.New("no master key given, use a key file or set " + source.EnvName)
+		}
+		return nil, errors.New("no master key given")
+	}
+
+	key, err := promptMasterKey(source.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	if source.Confirm {
+		confirm, err := promptMasterKey("Confirm " + source.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(key, confirm) {
+			return nil, errors.New("master keys do not match")
+		}
+	}
+	return checkMasterKey(key)
+}
+
+func promptMasterKey(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt+": ")
+	key, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return key, err
+}
+
+func checkMasterKey(key []byte) ([]byte, error) {
+	if len(key) < MinMasterKeyLen {
+		return nil, fmt.Errorf("master key must be at least %d characters", MinMasterKeyLen)
+	}
+	return key, nil
+}
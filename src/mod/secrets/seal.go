@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+/*
+	seal.go
+
+	Package level helpers used by the modules storing secrets. They seal
+	with the default keyring and pass data through unchanged while
+	encryption is disabled, so callers do not need to check if it is on.
+*/
+
+// IsSealed checks if the data was sealed by a keyring
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(SealedPrefix))
+}
+
+// Seal encrypts the data with the default keyring, returns it as is if encryption is disabled
+func Seal(data []byte) ([]byte, error) {
+	k := Default()
+	if k == nil || IsSealed(data) {
+		return data, nil
+	}
+	return k.Seal(data)
+}
+
+// Open decrypts data sealed by the default keyring, plaintext data is returned as is
+func Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	k := Default()
+	if k == nil {
+		return nil, ErrLocked
+	}
+	return k.Open(data)
+}
+
+// SealString encrypts a string secret, empty strings are kept empty
+func SealString(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	sealed, err := Seal([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return string(sealed), nil
+}
+
+// OpenString decrypts a string secret sealed by SealString
+func OpenString(value string) (string, error) {
+	if !strings.HasPrefix(value, SealedPrefix) {
+		return value, nil
+	}
+	opened, err := Open([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return string(opened), nil
+}
+
+// ReadFile reads a file that might be sealed, e.g. a private key in the cert store
+func ReadFile(filename string) ([]byte, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Open(content)
+}
+
+// WriteFile seals the data if encryption is enabled and writes it with the given mode
+func WriteFile(filename string, data []byte, mode os.FileMode) error {
+	sealed, err := Seal(data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, sealed, mode); err != nil {
+		return err
+	}
+	//WriteFile only applies the mode on create
+	return os.Chmod(filename, mode)
+}
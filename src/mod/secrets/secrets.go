@@ -0,0 +1,283 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+	secrets.go
+
+	Envelope encryption of private keys and secrets at rest. A random data
+	key encrypts the secrets and is itself stored in the keyring file,
+	wrapped by a key derived from the master key. Re-keying only rewraps
+	the data key, so the sealed secrets do not need to be rewritten.
+
+	Sealed values are text and start with SealedPrefix, values without the
+	prefix are plaintext from before encryption was enabled and are
+	returned as is.
+*/
+
+const (
+	SealedPrefix    = "zxenc:v1:"
+	MinMasterKeyLen = 8
+
+	keyringVersion = 1
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	dataKeyLen     = 32
+)
+
+var (
+	ErrLocked          = errors.New("secret is encrypted but no master key is loaded")
+	ErrWrongMasterKey  = errors.New("invalid master key")
+	ErrMalformedSecret = errors.New("malformed encrypted secret")
+)
+
+// Keyring holds the unwrapped data key of a keyring file
+type Keyring struct {
+	path    string
+	file    *keyringFile
+	dataKey []byte
+	aead    cipher.AEAD
+	mu      sync.Mutex //Serialize keyring file writes
+}
+
+// keyringFile is the keyring file content, the data key is never written unwrapped
+type keyringFile struct {
+	Version    int
+	KDF        string //Only scrypt is supported
+	Salt       string //Base64 scrypt salt of the master key
+	N          int
+	R          int
+	P          int
+	WrappedKey string //Base64 nonce and AES-GCM encrypted data key
+	Created    int64
+	Rekeyed    int64
+}
+
+var (
+	defaultKeyring *Keyring
+	defaultMutex   sync.RWMutex
+)
+
+// SetDefault sets the keyring used by the package level functions, nil to disable encryption
+func SetDefault(k *Keyring) {
+	defaultMutex.Lock()
+	defaultKeyring = k
+	defaultMutex.Unlock()
+}
+
+// Default returns the keyring used by the package level functions, nil if encryption is disabled
+func Default() *Keyring {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+	return defaultKeyring
+}
+
+// Enabled returns if secrets are encrypted when written
+func Enabled() bool {
+	return Default() != nil
+}
+
+// KeyringExists checks if a keyring file was created at the given path
+func KeyringExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CreateKeyring generates a new data key and writes it wrapped by the master key
+func CreateKeyring(path string, masterKey []byte) (*Keyring, error) {
+	if KeyringExists(path) {
+		return nil, errors.New("keyring already exists at " + path)
+	}
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	file := &keyringFile{
+		Version: keyringVersion,
+		Created: time.Now().Unix(),
+	}
+	if err := file.wrap(dataKey, masterKey); err != nil {
+		return nil, err
+	}
+	k, err := newKeyring(path, file, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := k.save(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// OpenKeyring unwraps the data key of the keyring file with the master key
+func OpenKeyring(path string, masterKey []byte) (*Keyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &keyringFile{}
+	if err := json.Unmarshal(content, file); err != nil {
+		return nil, errors.New("malformed keyring: " + err.Error())
+	}
+	if file.Version != keyringVersion || file.KDF != "scrypt" {
+		return nil, errors.New("unsupported keyring version")
+	}
+	dataKey, err := file.unwrap(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyring(path, file, dataKey)
+}
+
+func newKeyring(path string, file *keyringFile, dataKey []byte) (*Keyring, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{path: path, file: file, dataKey: dataKey, aead: aead}, nil
+}
+
+// Rekey rewraps the data key with a new master key, the old master key
+// no longer opens the keyring afterward
+func (k *Keyring) Rekey(newMasterKey []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	file := *k.file
+	if err := file.wrap(k.dataKey, newMasterKey); err != nil {
+		return err
+	}
+	file.Rekeyed = time.Now().Unix()
+	previous := k.file
+	k.file = &file
+	if err := k.save(); err != nil {
+		k.file = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the keyring file atomically, the caller must hold the mutex
+func (k *Keyring) save() error {
+	js, err := json.MarshalIndent(k.file, "", " ")
+	if err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, js, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.path)
+}
+
+// Seal encrypts the data with the data key
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := k.aead.Seal(nonce, nonce, data, nil)
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	return []byte(SealedPrefix + encoded), nil
+}
+
+// Open decrypts sealed data, data without the sealed prefix is returned as is
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(SealedPrefix):])))
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return nil, ErrMalformedSecret
+	}
+	nonceSize := k.aead.NonceSize()
+	plaintext, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt secret, was it sealed with another keyring?")
+	}
+	return plaintext, nil
+}
+
+/* Key wrapping */
+
+// wrap derives a key encryption key from the master key with a new salt and encrypts the data key
+func (f *keyringFile) wrap(dataKey []byte, masterKey []byte) error {
+	if len(masterKey) < MinMasterKeyLen {
+		return fmt.Errorf("master key must be at least %d characters", MinMasterKeyLen)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	f.KDF = "scrypt"
+	f.N, f.R, f.P = scryptN, scryptR, scryptP
+	f.Salt = base64.StdEncoding.EncodeToString(salt)
+	kek, err := scrypt.Key(masterKey, salt, f.N, f.R, f.P, dataKeyLen)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	f.WrappedKey = base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, []byte(SealedPrefix)))
+	return nil
+}
+
+// unwrap decrypts the data key, the GCM tag doubles as the master key check
+func (f *keyringFile) unwrap(masterKey []byte) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(f.Salt)
+	if err != nil {
+		return nil, errors.New("malformed keyring salt")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(f.WrappedKey)
+	if err != nil {
+		return nil, errors.New("malformed keyring data key")
+	}
+	kek, err := scrypt.Key(masterKey, salt, f.N, f.R, f.P, dataKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("malformed keyring data key")
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(SealedPrefix))
+	if err != nil {
+		return nil, ErrWrongMasterKey
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
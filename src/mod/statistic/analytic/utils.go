@@ -32,6 +32,8 @@ func mergeDailySummaryExports(exports []*statistic.DailySummaryExport) *statisti
 	mergedExport := &statistic.DailySummaryExport{
 		ForwardTypes:    make(map[string]int),
 		RequestOrigin:   make(map[string]int),
+		RequestASN:      make(map[string]int),
+		RequestCity:     make(map[string]int),
 		RequestClientIp: make(map[string]int),
 		Referer:         make(map[string]int),
 		UserAgent:       make(map[string]int),
@@ -54,6 +56,14 @@ func mergeDailySummaryExports(exports []*statistic.DailySummaryExport) *statisti
 			mergedExport.RequestOrigin[key] += value
 		}
 
+		for key, value := range export.RequestASN {
+			mergedExport.RequestASN[key] += value
+		}
+
+		for key, value := range export.RequestCity {
+			mergedExport.RequestCity[key] += value
+		}
+
 		for key, value := range export.RequestClientIp {
 			mergedExport.RequestClientIp[key] += value
 		}
@@ -37,6 +37,8 @@ func newBoundedCounter(initialSize int) *boundedCounter {
 type boundedCounters struct {
 	ForwardTypes        *boundedCounter
 	RequestOrigin       *boundedCounter
+	RequestASN          *boundedCounter
+	RequestCity         *boundedCounter
 	RequestClientIp     *boundedCounter
 	Referer             *boundedCounter
 	UserAgent           *boundedCounter
@@ -50,6 +52,8 @@ func newBoundedCounters() boundedCounters {
 	return boundedCounters{
 		ForwardTypes:        newBoundedCounter(0),
 		RequestOrigin:       newBoundedCounter(0),
+		RequestASN:          newBoundedCounter(0),
+		RequestCity:         newBoundedCounter(0),
 		RequestClientIp:     newBoundedCounter(0),
 		Referer:             newBoundedCounter(0),
 		UserAgent:           newBoundedCounter(0),
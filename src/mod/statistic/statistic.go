@@ -26,6 +26,8 @@ type DailySummary struct {
 	//Type counters
 	ForwardTypes        *sync.Map //Map that hold the forward types
 	RequestOrigin       *sync.Map //Map that hold [country ISO code]: visitor counter
+	RequestASN          *sync.Map //Map that hold [ASN]: visitor counter
+	RequestCity         *sync.Map //Map that hold [country ISO code/city name]: visitor counter
 	RequestClientIp     *sync.Map //Map that hold all unique request IPs
 	Referer             *sync.Map //Map that store where the user was refered from
 	UserAgent           *sync.Map //Map that store the useragent of the request
@@ -44,6 +46,8 @@ type DailySummary struct {
 type RequestInfo struct {
 	IpAddr                        string //IP address of the downstream request
 	RequestOriginalCountryISOCode string //ISO code of the country where the request originated
+	RequestASN                    string //ASN of the network where the request originated, empty if unknown
+	RequestCity                   string //City where the request originated, empty if unknown or no MMDB loaded
 	Succ                          bool   //If the request is successful and resp generated by upstream instead of Zoraxy (except static web server)
 	StatusCode                    int    //HTTP status code of the request
 	ForwardType                   string //Forward type of the request, usually the proxy type (e.g. host-http, subdomain-websocket or vdir-http or any of the combination)
@@ -232,6 +236,16 @@ func (c *Collector) RecordRequest(ri RequestInfo) {
 		originISO := strings.ToLower(ri.RequestOriginalCountryISOCode)
 		c.incr(c.DailySummary.RequestOrigin, c.DailySummary.bounded.RequestOrigin, originISO)
 
+		//Record the city of the request origin, if MMDB is loaded
+		if ri.RequestCity != "" {
+			c.incr(c.DailySummary.RequestCity, c.DailySummary.bounded.RequestCity, originISO+"/"+ri.RequestCity)
+		}
+
+		//Record the ASN of the request origin, if ASN data is available
+		if ri.RequestASN != "" {
+			c.incr(c.DailySummary.RequestASN, c.DailySummary.bounded.RequestASN, ri.RequestASN)
+		}
+
 		//Filter out CF forwarded requests
 		if strings.Contains(ri.IpAddr, ",") {
 			ips := strings.Split(strings.TrimSpace(ri.IpAddr), ",")
@@ -313,6 +327,8 @@ func NewDailySummary() *DailySummary {
 		ValidRequest:        0,
 		ForwardTypes:        &sync.Map{},
 		RequestOrigin:       &sync.Map{},
+		RequestASN:          &sync.Map{},
+		RequestCity:         &sync.Map{},
 		RequestClientIp:     &sync.Map{},
 		Referer:             &sync.Map{},
 		UserAgent:           &sync.Map{},
@@ -9,6 +9,8 @@ type DailySummaryExport struct {
 
 	ForwardTypes    map[string]int
 	RequestOrigin   map[string]int
+	RequestASN      map[string]int
+	RequestCity     map[string]int
 	RequestClientIp map[string]int
 	Referer         map[string]int
 	UserAgent       map[string]int
@@ -38,6 +40,8 @@ func DailySummaryToExport(summary DailySummary) DailySummaryExport {
 		ValidRequest:    summary.ValidRequest,
 		ForwardTypes:    make(map[string]int),
 		RequestOrigin:   make(map[string]int),
+		RequestASN:      make(map[string]int),
+		RequestCity:     make(map[string]int),
 		RequestClientIp: make(map[string]int),
 		Referer:         make(map[string]int),
 		UserAgent:       make(map[string]int),
@@ -49,6 +53,8 @@ func DailySummaryToExport(summary DailySummary) DailySummaryExport {
 
 	export.ForwardTypes = SyncMapToMapStringInt(summary.ForwardTypes)
 	export.RequestOrigin = SyncMapToMapStringInt(summary.RequestOrigin)
+	export.RequestASN = SyncMapToMapStringInt(summary.RequestASN)
+	export.RequestCity = SyncMapToMapStringInt(summary.RequestCity)
 	export.RequestClientIp = SyncMapToMapStringInt(summary.RequestClientIp)
 	export.Referer = SyncMapToMapStringInt(summary.Referer)
 	export.UserAgent = SyncMapToMapStringInt(summary.UserAgent)
@@ -75,6 +81,8 @@ func DailySummaryExportToSummary(export DailySummaryExport) DailySummary {
 		ValidRequest:        export.ValidRequest,
 		ForwardTypes:        MapStringIntToSyncMap(export.ForwardTypes),
 		RequestOrigin:       MapStringIntToSyncMap(export.RequestOrigin),
+		RequestASN:          MapStringIntToSyncMap(export.RequestASN),
+		RequestCity:         MapStringIntToSyncMap(export.RequestCity),
 		RequestClientIp:     MapStringIntToSyncMap(export.RequestClientIp),
 		Referer:             MapStringIntToSyncMap(export.Referer),
 		UserAgent:           MapStringIntToSyncMap(export.UserAgent),
@@ -85,6 +93,8 @@ func DailySummaryExportToSummary(export DailySummaryExport) DailySummary {
 		bounded: boundedCounters{
 			ForwardTypes:        newBoundedCounter(len(export.ForwardTypes)),
 			RequestOrigin:       newBoundedCounter(len(export.RequestOrigin)),
+			RequestASN:          newBoundedCounter(len(export.RequestASN)),
+			RequestCity:         newBoundedCounter(len(export.RequestCity)),
 			RequestClientIp:     newBoundedCounter(len(export.RequestClientIp)),
 			Referer:             newBoundedCounter(len(export.Referer)),
 			UserAgent:           newBoundedCounter(len(export.UserAgent)),
@@ -0,0 +1,62 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"slices"
+)
+
+/*
+	acmechallenge.go
+
+	Answer TLS-ALPN-01 validations from the main TLS listener. The ACME
+	server connects with only the acme-tls/1 ALPN offered and expects the
+	challenge certificate of the pending validation.
+*/
+
+// ACMETLS1Protocol is the ALPN protocol ID of TLS-ALPN-01 validations
+const ACMETLS1Protocol = "acme-tls/1"
+
+// ACMEChallengeResolver returns the challenge certificate of a pending
+// TLS-ALPN-01 validation for the domain
+type ACMEChallengeResolver func(domain string) (*tls.Certificate, bool)
+
+// SetACMEChallengeResolver sets the resolver of TLS-ALPN-01 challenge certificates
+func (m *Manager) SetACMEChallengeResolver(fn ACMEChallengeResolver) {
+	m.acmeChallengeResolver.Store(&fn)
+}
+
+// isACMEChallengeHello checks if the client hello is from a TLS-ALPN-01 validation
+func isACMEChallengeHello(helloInfo *tls.ClientHelloInfo) bool {
+	return len(helloInfo.SupportedProtos) == 1 && helloInfo.SupportedProtos[0] == ACMETLS1Protocol
+}
+
+// getACMEChallengeCert returns the challenge certificate if the client hello
+// is a TLS-ALPN-01 validation for a pending challenge
+func (m *Manager) getACMEChallengeCert(helloInfo *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	if !slices.Contains(helloInfo.SupportedProtos, ACMETLS1Protocol) {
+		return nil, false
+	}
+	resolver := m.acmeChallengeResolver.Load()
+	if resolver == nil || *resolver == nil {
+		return nil, false
+	}
+	return (*resolver)(helloInfo.ServerName)
+}
+
+// GetACMEChallengeConfig returns the TLS config answering a TLS-ALPN-01
+// validation, or nil if the client hello is not one. Use it in
+// GetConfigForClient as the acme-tls/1 protocol must be negotiated.
+func (m *Manager) GetACMEChallengeConfig(helloInfo *tls.ClientHelloInfo) *tls.Config {
+	if !isACMEChallengeHello(helloInfo) {
+		return nil
+	}
+	cert, ok := m.getACMEChallengeCert(helloInfo)
+	if !ok {
+		return nil
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{ACMETLS1Protocol},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
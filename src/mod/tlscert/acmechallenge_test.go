@@ -0,0 +1,99 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v5/challenge/tlsalpn01"
+)
+
+var testACMEIdentifierOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func TestACMEChallengeHandshake(t *testing.T) {
+	m := newTestCacheManager(t)
+	writeTestCert(t, m.CertStore, "app.example.com", []string{"app.example.com"}, time.Now().Add(24*time.Hour))
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+	challengeCert, err := tlsalpn01.ChallengeCert("app.example.com", "test-key-authorization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetACMEChallengeResolver(func(domain string) (*tls.Certificate, bool) {
+		return challengeCert, domain == "app.example.com"
+	})
+
+	baseConfig := &tls.Config{
+		GetCertificate: m.GetCert,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	baseConfig.GetConfigForClient = func(helloInfo *tls.ClientHelloInfo) (*tls.Config, error) {
+		return m.GetACMEChallengeConfig(helloInfo), nil
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", baseConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+
+	handshake := func(serverName string, protos []string) (*x509.Certificate, string, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			NextProtos:         protos,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		return state.PeerCertificates[0], state.NegotiatedProtocol, nil
+	}
+
+	hasACMEIdentifier := func(cert *x509.Certificate) bool {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(testACMEIdentifierOID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cert, proto, err := handshake("app.example.com", []string{ACMETLS1Protocol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto != ACMETLS1Protocol || !hasACMEIdentifier(cert) {
+		t.Errorf("expected challenge certificate over %s, got %s", ACMETLS1Protocol, proto)
+	}
+
+	//Regular clients get the normal certificate
+	cert, _, err = handshake("app.example.com", []string{"h2", "http/1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasACMEIdentifier(cert) || cert.Subject.CommonName != "app.example.com" {
+		t.Error("expected regular certificate for regular clients")
+	}
+
+	//Validations without a pending challenge are not answered with a challenge certificate
+	if cert, _, err := handshake("other.example.com", []string{ACMETLS1Protocol}); err == nil && hasACMEIdentifier(cert) {
+		t.Error("expected no challenge certificate without a pending challenge")
+	}
+}
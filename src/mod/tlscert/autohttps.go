@@ -0,0 +1,274 @@
+package tlscert
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	autohttps.go
+
+	On-demand certificate issuance for hostnames with EnableAutoHTTPS
+	set. When a ClientHello arrives for an allowed hostname without a
+	certificate, the issuance is started in the background and the
+	fallback certificate is served until the new one is ready.
+*/
+
+const (
+	autoHTTPSMinBackoff       = 1 * time.Minute //Backoff after the first failed issuance
+	autoHTTPSRateLimitBackoff = 1 * time.Hour   //Minimum backoff when the CA reported a rate limit
+	autoHTTPSMaxBackoff       = 24 * time.Hour  //Upper bound of the backoff between retries
+)
+
+// Time the handshakes wait for a single issuance. The domain stays in progress
+// after the timeout until the obtainer returns.
+var autoHTTPSIssueTimeout = 10 * time.Minute
+
+var (
+	ErrAutoHTTPSNotConfigured = errors.New("auto HTTPS certificate obtainer not configured")
+	ErrAutoHTTPSNotAllowed    = errors.New("hostname is not allowed for auto HTTPS")
+	ErrAutoHTTPSInProgress    = errors.New("certificate issuance already in progress")
+	ErrAutoHTTPSBackoff       = errors.New("certificate issuance is backing off after a failure")
+)
+
+// AutoHTTPSObtainer obtains a certificate for the domain and writes it into
+// the cert store as <domain>.pem and <domain>.key
+type AutoHTTPSObtainer func(domain string) error
+
+// AutoHTTPSAllowlist reports if issuance may be triggered for the domain
+type AutoHTTPSAllowlist func(domain string) bool
+
+// AutoHTTPSStatus is the issuance state of a single domain
+type AutoHTTPSStatus struct {
+	Domain      string
+	InProgress  bool
+	Failures    int
+	LastError   string
+	LastAttempt int64
+	NextAttempt int64 //Unix time before which no new issuance is started
+}
+
+type autoHTTPSBackoff struct {
+	failures    int
+	lastError   string
+	lastAttempt time.Time
+	nextAttempt time.Time
+}
+
+type autoHTTPSState struct {
+	obtainer  AutoHTTPSObtainer
+	allowlist AutoHTTPSAllowlist
+	inflight  map[string]chan struct{} //Per-domain issuance locks, closed when the issuance ends
+	backoff   map[string]*autoHTTPSBackoff
+	mutex     sync.Mutex
+}
+
+// SetAutoHTTPSHandler sets the certificate obtainer and the hostname
+// allowlist used for on-demand issuance. Passing a nil obtainer disables it.
+func (m *Manager) SetAutoHTTPSHandler(allowlist AutoHTTPSAllowlist, obtainer AutoHTTPSObtainer) {
+	m.autoHTTPS.mutex.Lock()
+	defer m.autoHTTPS.mutex.Unlock()
+	m.autoHTTPS.allowlist = allowlist
+	m.autoHTTPS.obtainer = obtainer
+	if m.autoHTTPS.inflight == nil {
+		m.autoHTTPS.inflight = map[string]chan struct{}{}
+	}
+	if m.autoHTTPS.backoff == nil {
+		m.autoHTTPS.backoff = map[string]*autoHTTPSBackoff{}
+	}
+}
+
+// isAutoHTTPSCandidate checks if the hostname looks like a name a public CA
+// would issue a certificate for
+func isAutoHTTPSCandidate(hostname string) bool {
+	if hostname == "" || len(hostname) > 253 || !strings.Contains(hostname, ".") {
+		return false
+	}
+	if strings.ContainsAny(hostname, "*/\\ ") || strings.HasSuffix(hostname, ".") {
+		return false
+	}
+	if net.ParseIP(hostname) != nil || hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		return false
+	}
+	return true
+}
+
+// RequestAutoHTTPSCertificate starts a background issuance for the hostname.
+// The returned channel is closed when the issuance ends.
+func (m *Manager) RequestAutoHTTPSCertificate(hostname string) (<-chan struct{}, error) {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if !isAutoHTTPSCandidate(hostname) {
+		return nil, ErrAutoHTTPSNotAllowed
+	}
+
+	m.autoHTTPS.mutex.Lock()
+	obtainer := m.autoHTTPS.obtainer
+	allowlist := m.autoHTTPS.allowlist
+	m.autoHTTPS.mutex.Unlock()
+	if obtainer == nil {
+		return nil, ErrAutoHTTPSNotConfigured
+	}
+
+	//The allowlist is resolved outside of the lock as it calls into the proxy router
+	if allowlist == nil || !allowlist(hostname) {
+		return nil, ErrAutoHTTPSNotAllowed
+	}
+
+	m.autoHTTPS.mutex.Lock()
+	if done, ok := m.autoHTTPS.inflight[hostname]; ok {
+		m.autoHTTPS.mutex.Unlock()
+		return done, ErrAutoHTTPSInProgress
+	}
+	if backoff, ok := m.autoHTTPS.backoff[hostname]; ok && time.Now().Before(backoff.nextAttempt) {
+		m.autoHTTPS.mutex.Unlock()
+		return nil, ErrAutoHTTPSBackoff
+	}
+	done := make(chan struct{})
+	m.autoHTTPS.inflight[hostname] = done
+	m.autoHTTPS.mutex.Unlock()
+
+	go m.runAutoHTTPSIssuance(hostname, obtainer, done)
+	return done, nil
+}
+
+func (m *Manager) runAutoHTTPSIssuance(hostname string, obtainer AutoHTTPSObtainer, done chan struct{}) {
+	timedOut := false
+	defer func() {
+		m.autoHTTPS.mutex.Lock()
+		delete(m.autoHTTPS.inflight, hostname)
+		m.autoHTTPS.mutex.Unlock()
+		if !timedOut {
+			close(done)
+		}
+	}()
+
+	m.logAutoHTTPS("Obtaining certificate for "+hostname, nil)
+	startTime := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- obtainer(hostname)
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(autoHTTPSIssueTimeout):
+		//Stop the waiting handshakes but keep the domain in progress until the
+		//obtainer returns, so a hanging obtainer is never started twice
+		timedOut = true
+		m.recordAutoHTTPSFailure(hostname, startTime, errors.New("certificate issuance timed out"))
+		close(done)
+		err = <-result
+	}
+
+	if err == nil {
+		//Hot swap the new certificate into the certificate cache
+		err = m.UpdateLoadedCertList()
+		if _, ok := m.currentCertIndex().byName[hostname]; err == nil && !ok {
+			err = errors.New("obtained certificate not found in cert store")
+		}
+	}
+
+	if err != nil {
+		if timedOut {
+			//The timeout is already counted as a failure
+			m.logAutoHTTPS("Timed out certificate issuance for "+hostname+" failed", err)
+			return
+		}
+		m.recordAutoHTTPSFailure(hostname, startTime, err)
+		return
+	}
+
+	m.autoHTTPS.mutex.Lock()
+	delete(m.autoHTTPS.backoff, hostname)
+	m.autoHTTPS.mutex.Unlock()
+
+	m.logAutoHTTPS("Certificate for "+hostname+" obtained in "+time.Since(startTime).Round(time.Second).String(), nil)
+}
+
+// recordAutoHTTPSFailure backs off the next issuance of the hostname
+func (m *Manager) recordAutoHTTPSFailure(hostname string, startTime time.Time, err error) {
+	m.autoHTTPS.mutex.Lock()
+	backoff, ok := m.autoHTTPS.backoff[hostname]
+	if !ok {
+		backoff = &autoHTTPSBackoff{}
+		m.autoHTTPS.backoff[hostname] = backoff
+	}
+	backoff.failures++
+	backoff.lastError = err.Error()
+	backoff.lastAttempt = startTime
+	backoff.nextAttempt = time.Now().Add(autoHTTPSBackoffDuration(backoff.failures, err))
+	nextAttempt := backoff.nextAttempt
+	m.autoHTTPS.mutex.Unlock()
+	m.logAutoHTTPS("Certificate issuance for "+hostname+" failed, retry after "+nextAttempt.Format(time.RFC3339), err)
+}
+
+// autoHTTPSBackoffDuration doubles the backoff on each failure, starting
+// higher if the CA reported a rate limit
+func autoHTTPSBackoffDuration(failures int, err error) time.Duration {
+	backoff := autoHTTPSMinBackoff
+	if isRateLimitError(err) {
+		backoff = autoHTTPSRateLimitBackoff
+	}
+	for i := 1; i < failures && backoff < autoHTTPSMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > autoHTTPSMaxBackoff {
+		backoff = autoHTTPSMaxBackoff
+	}
+	return backoff
+}
+
+// isRateLimitError checks for the ACME rateLimited problem type or a 429 response
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "ratelimited") || strings.Contains(msg, "rate limit") || strings.Contains(msg, "429")
+}
+
+// GetAutoHTTPSStatus returns the issuance state of every domain that is
+// in progress or backing off
+func (m *Manager) GetAutoHTTPSStatus() []*AutoHTTPSStatus {
+	m.autoHTTPS.mutex.Lock()
+	defer m.autoHTTPS.mutex.Unlock()
+	results := []*AutoHTTPSStatus{}
+	for domain := range m.autoHTTPS.inflight {
+		status := &AutoHTTPSStatus{Domain: domain, InProgress: true}
+		if backoff, ok := m.autoHTTPS.backoff[domain]; ok {
+			status.Failures = backoff.failures
+			status.LastError = backoff.lastError
+			status.LastAttempt = backoff.lastAttempt.Unix()
+		}
+		results = append(results, status)
+	}
+	for domain, backoff := range m.autoHTTPS.backoff {
+		if _, ok := m.autoHTTPS.inflight[domain]; ok {
+			continue
+		}
+		results = append(results, &AutoHTTPSStatus{
+			Domain:      domain,
+			Failures:    backoff.failures,
+			LastError:   backoff.lastError,
+			LastAttempt: backoff.lastAttempt.Unix(),
+			NextAttempt: backoff.nextAttempt.Unix(),
+		})
+	}
+	return results
+}
+
+// ResetAutoHTTPSBackoff clears the backoff of the domain so the next
+// handshake retries the issuance immediately
+func (m *Manager) ResetAutoHTTPSBackoff(domain string) {
+	m.autoHTTPS.mutex.Lock()
+	defer m.autoHTTPS.mutex.Unlock()
+	delete(m.autoHTTPS.backoff, strings.ToLower(strings.TrimSpace(domain)))
+}
+
+func (m *Manager) logAutoHTTPS(message string, err error) {
+	m.Logger.PrintAndLog("auto-https", message, err)
+}
@@ -0,0 +1,189 @@
+package tlscert
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+// newTestAutoHTTPSManager creates a manager where every hostname has auto HTTPS
+// enabled and only allowed.example.com passes the allowlist
+func newTestAutoHTTPSManager(t *testing.T, obtainer AutoHTTPSObtainer) *Manager {
+	systemLogger, _ := logger.NewFmtLogger()
+	m := &Manager{
+		Logger:       systemLogger,
+		CertStore:    t.TempDir(),
+		LoadedCerts:  []*CertCache{},
+		FallbackCert: "fallback",
+		hostSpecificTlsBehavior: func(serverName string) (*HostSpecificTlsBehavior, error) {
+			behavior := GetDefaultHostSpecificTlsBehavior()
+			behavior.EnableAutoHTTPS = true
+			return behavior, nil
+		},
+	}
+	if err := m.GenerateSelfSignedCertificate("fallback", []string{"fallback"}, "fallback.pem", "fallback.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+	m.SetAutoHTTPSHandler(func(domain string) bool {
+		return domain == "allowed.example.com"
+	}, obtainer)
+	return m
+}
+
+func waitForIssuance(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected issuance to finish")
+	}
+}
+
+func TestAutoHTTPSIssuance(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	var m *Manager
+	m = newTestAutoHTTPSManager(t, func(domain string) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return m.GenerateSelfSignedCertificate(domain, []string{domain}, domain+".pem", domain+".key")
+	})
+
+	//Fallback certificate is served while the issuance is running
+	pubKey, _, _ := m.GetCertificateByHostname("allowed.example.com")
+	if pubKey != filepath.Join(m.CertStore, "fallback.pem") {
+		t.Errorf("expected fallback certificate while waiting, got %s", pubKey)
+	}
+
+	//Concurrent handshakes share the same issuance
+	done, err := m.RequestAutoHTTPSCertificate("allowed.example.com")
+	if err != ErrAutoHTTPSInProgress {
+		t.Errorf("expected issuance to be in progress, got %v", err)
+	}
+	close(release)
+	waitForIssuance(t, done)
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected a single issuance, got %d", calls)
+	}
+
+	//New certificate is swapped in
+	pubKey, _, _ = m.GetCertificateByHostname("allowed.example.com")
+	if pubKey != filepath.Join(m.CertStore, "allowed.example.com.pem") {
+		t.Errorf("expected obtained certificate, got %s", pubKey)
+	}
+	if !m.CertMatchExists("allowed.example.com") {
+		t.Error("expected obtained certificate to be loaded")
+	}
+}
+
+func TestAutoHTTPSAllowlist(t *testing.T) {
+	var calls int32
+	m := newTestAutoHTTPSManager(t, func(domain string) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	for _, hostname := range []string{"random.example.com", "127.0.0.1", "localhost", "*.example.com", ""} {
+		if _, err := m.RequestAutoHTTPSCertificate(hostname); err != ErrAutoHTTPSNotAllowed {
+			t.Errorf("expected %q to be rejected, got %v", hostname, err)
+		}
+		pubKey, _, _ := m.GetCertificateByHostname(hostname)
+		if pubKey != filepath.Join(m.CertStore, "fallback.pem") {
+			t.Errorf("expected fallback certificate for %q, got %s", hostname, pubKey)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("expected no issuance, got %d", calls)
+	}
+}
+
+func TestAutoHTTPSBackoff(t *testing.T) {
+	var calls int32
+	m := newTestAutoHTTPSManager(t, func(domain string) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("urn:ietf:params:acme:error:rateLimited: too many certificates")
+	})
+
+	done, err := m.RequestAutoHTTPSCertificate("allowed.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForIssuance(t, done)
+
+	if _, err := m.RequestAutoHTTPSCertificate("allowed.example.com"); err != ErrAutoHTTPSBackoff {
+		t.Errorf("expected issuance to back off, got %v", err)
+	}
+	status := m.GetAutoHTTPSStatus()
+	if len(status) != 1 || status[0].Failures != 1 || status[0].NextAttempt < time.Now().Add(autoHTTPSRateLimitBackoff-time.Minute).Unix() {
+		t.Errorf("unexpected backoff status: %+v", status)
+	}
+
+	m.ResetAutoHTTPSBackoff("allowed.example.com")
+	done, err = m.RequestAutoHTTPSCertificate("allowed.example.com")
+	if err != nil {
+		t.Fatalf("expected retry after reset, got %v", err)
+	}
+	waitForIssuance(t, done)
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected two issuances, got %d", calls)
+	}
+
+	if d := autoHTTPSBackoffDuration(20, errors.New("connection refused")); d != autoHTTPSMaxBackoff {
+		t.Errorf("expected backoff to be capped, got %s", d)
+	}
+}
+
+func TestAutoHTTPSTimeoutKeepsDomainInProgress(t *testing.T) {
+	defaultTimeout := autoHTTPSIssueTimeout
+	autoHTTPSIssueTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { autoHTTPSIssueTimeout = defaultTimeout })
+
+	var calls int32
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	var m *Manager
+	m = newTestAutoHTTPSManager(t, func(domain string) error {
+		atomic.AddInt32(&calls, 1)
+		defer close(returned)
+		<-release
+		return m.GenerateSelfSignedCertificate(domain, []string{domain}, domain+".pem", domain+".key")
+	})
+
+	done, err := m.RequestAutoHTTPSCertificate("allowed.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForIssuance(t, done)
+
+	//The obtainer is still running, so no second issuance is started
+	if _, err := m.RequestAutoHTTPSCertificate("allowed.example.com"); err != ErrAutoHTTPSInProgress {
+		t.Errorf("expected issuance to stay in progress after the timeout, got %v", err)
+	}
+	status := m.GetAutoHTTPSStatus()
+	if len(status) != 1 || !status[0].InProgress || status[0].Failures != 1 {
+		t.Errorf("unexpected status after the timeout: %+v", status)
+	}
+
+	//A late certificate is still swapped in once the obtainer returns
+	close(release)
+	<-returned
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.GetAutoHTTPSStatus()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := m.GetAutoHTTPSStatus(); len(status) != 0 {
+		t.Errorf("expected issuance to end after the obtainer returned, got %+v", status)
+	}
+	if !m.CertMatchExists("allowed.example.com") {
+		t.Error("expected late certificate to be loaded")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected a single issuance, got %d", calls)
+	}
+}
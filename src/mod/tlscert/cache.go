@@ -0,0 +1,388 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"imuslab.com/zoraxy/mod/secrets"
+)
+
+/*
+	cache.go
+
+	Parsed certificates are kept in memory and indexed by file name,
+	exact hostname and wildcard suffix so TLS handshakes never touch
+	the disk. The index is rebuilt and swapped atomically whenever the
+	cert store changes.
+*/
+
+const certStoreReloadDelay = 500 * time.Millisecond //Debounce delay for cert store changes
+
+// Upper bounds of the handshake certificate lookup latency buckets
+var certLookupLatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	1 * time.Millisecond,
+	10 * time.Millisecond,
+}
+
+// loadedCertificate is a parsed key pair ready to be served
+type loadedCertificate struct {
+	Name        string               //Name of the cert in the cert store, without extension
+	PubKey      string               //Path of the public key
+	PriKey      string               //Path of the private key
+	Certificate *tls.Certificate     //Parsed key pair with Leaf populated
+	alternates  []*loadedCertificate //Certificates for the same names with a different key algorithm
+}
+
+// certIndex is an immutable snapshot of the cert store
+type certIndex struct {
+	builtin  *loadedCertificate            //Build-in localhost certificate
+	byName   map[string]*loadedCertificate //Cert store name to certificate
+	exact    map[string]*loadedCertificate //Exact DNS name or IP to certificate
+	wildcard map[string]*loadedCertificate //Parent domain of a wildcard name to certificate
+	issuerCN map[string]*loadedCertificate //Issuer common name to certificate, for legacy matching
+	entries  []*CertCache
+	loadedAt time.Time
+}
+
+// CertLookupStats is the handshake certificate lookup statistic
+type CertLookupStats struct {
+	Handshakes      int64   //Number of certificate lookups
+	Failures        int64   //Number of lookups without a certificate to serve
+	AverageLatency  float64 //Average lookup latency in microseconds
+	MaxLatency      float64 //Maximum lookup latency in microseconds
+	LatencyBuckets  []int64 //Lookup count per bucket of certLookupLatencyBuckets, the last entry holds the slower ones
+	BucketBounds    []string
+	LoadedCerts     int   //Number of certificates in the cache
+	Reloads         int64 //Number of cache rebuilds
+	LastReload      int64 //Unix time of the last cache rebuild
+	WatcherRunning  bool  //If the cert store is watched for changes
+	ReloadErrors    int64
+	LastReloadError string
+}
+
+type certLookupMetrics struct {
+	handshakes   atomic.Int64
+	failures     atomic.Int64
+	totalLatency atomic.Int64 //Nanoseconds
+	maxLatency   atomic.Int64 //Nanoseconds
+	buckets      [5]atomic.Int64
+	reloads      atomic.Int64
+	reloadErrors atomic.Int64
+	lastError    atomic.Value //string
+}
+
+func (c *certLookupMetrics) observe(latency time.Duration, ok bool) {
+	c.handshakes.Add(1)
+	if !ok {
+		c.failures.Add(1)
+	}
+	c.totalLatency.Add(int64(latency))
+	for {
+		current := c.maxLatency.Load()
+		if int64(latency) <= current || c.maxLatency.CompareAndSwap(current, int64(latency)) {
+			break
+		}
+	}
+	bucket := len(certLookupLatencyBuckets)
+	for i, bound := range certLookupLatencyBuckets {
+		if latency < bound {
+			bucket = i
+			break
+		}
+	}
+	c.buckets[bucket].Add(1)
+}
+
+// loadCertificate parses a key pair from disk, the private key might be sealed
+func loadCertificate(name string, pubKey string, priKey string) (*loadedCertificate, error) {
+	certPEM, err := os.ReadFile(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := secrets.ReadFile(priKey)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.Leaf == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		certificate.Leaf = leaf
+	}
+	return &loadedCertificate{
+		Name:        name,
+		PubKey:      pubKey,
+		PriKey:      priKey,
+		Certificate: &certificate,
+	}, nil
+}
+
+// isExpired checks if the certificate is outside of its validity period
+func (c *loadedCertificate) isExpired(now time.Time) bool {
+	leaf := c.Certificate.Leaf
+	return now.Before(leaf.NotBefore) || now.After(leaf.NotAfter)
+}
+
+// addTo adds the certificate to the index map under key. The first loaded
+// certificate wins, unless it has expired and the new one has not.
+func (c *loadedCertificate) addTo(index map[string]*loadedCertificate, key string, now time.Time) {
+	key = strings.ToLower(key)
+	if key == "" {
+		return
+	}
+	existing, ok := index[key]
+	if !ok || (existing.isExpired(now) && !c.isExpired(now)) {
+		index[key] = c
+	}
+}
+
+// buildCertIndex loads every key pair in the cert store into a new index
+func (m *Manager) buildCertIndex() (*certIndex, error) {
+	domainList, err := m.ListCertDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	index := &certIndex{
+		byName:   map[string]*loadedCertificate{},
+		exact:    map[string]*loadedCertificate{},
+		wildcard: map[string]*loadedCertificate{},
+		issuerCN: map[string]*loadedCertificate{},
+		entries:  []*CertCache{},
+		loadedAt: now,
+	}
+
+	builtin, err := loadCertificate("localhost", "./tmp/localhost.pem", "./tmp/localhost.key")
+	if err == nil {
+		index.builtin = builtin
+	}
+
+	for _, certname := range domainList {
+		pubKey := filepath.Join(m.CertStore, certname+".pem")
+		priKey := filepath.Join(m.CertStore, certname+".key")
+		loaded, err := loadCertificate(certname, pubKey, priKey)
+		if err != nil {
+			m.Logger.PrintAndLog("tls-router", "Certificate load failed: "+certname, err)
+			continue
+		}
+
+		index.byName[certname] = loaded
+		leaf := loaded.Certificate.Leaf
+		for _, dnsName := range leaf.DNSNames {
+			if parent, ok := strings.CutPrefix(dnsName, "*."); ok {
+				loaded.addTo(index.wildcard, parent, now)
+			} else {
+				loaded.addTo(index.exact, dnsName, now)
+			}
+		}
+		for _, ip := range leaf.IPAddresses {
+			loaded.addTo(index.exact, ip.String(), now)
+		}
+		loaded.addTo(index.issuerCN, leaf.Issuer.CommonName, now)
+
+		index.entries = append(index.entries, &CertCache{
+			Cert:   leaf,
+			PubKey: pubKey,
+			PriKey: priKey,
+		})
+	}
+	linkAlternateCertificates(index.byName)
+	return index, nil
+}
+
+// linkAlternateCertificates links certificates covering the same names with
+// different key algorithms, so an ECDSA and an RSA certificate can be served
+// for the same hostname
+func linkAlternateCertificates(certs map[string]*loadedCertificate) {
+	groups := map[string][]*loadedCertificate{}
+	for _, cert := range certs {
+		key := certNameSetKey(cert.Certificate.Leaf)
+		groups[key] = append(groups[key], cert)
+	}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		//Keep the alternate order stable across reloads
+		sort.Slice(group, func(i, j int) bool { return group[i].Name < group[j].Name })
+		for _, cert := range group {
+			for _, other := range group {
+				if other != cert && other.Certificate.Leaf.PublicKeyAlgorithm != cert.Certificate.Leaf.PublicKeyAlgorithm {
+					cert.alternates = append(cert.alternates, other)
+				}
+			}
+		}
+	}
+}
+
+// certNameSetKey returns a key identifying the set of names covered by the certificate
+func certNameSetKey(leaf *x509.Certificate) string {
+	names := []string{}
+	for _, dnsName := range leaf.DNSNames {
+		names = append(names, strings.ToLower(dnsName))
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// selectForClient picks between the certificate and its alternates based on
+// the signature schemes and curves in the client hello, preferring ECDSA
+func (c *loadedCertificate) selectForClient(helloInfo *tls.ClientHelloInfo) *loadedCertificate {
+	if len(c.alternates) == 0 || helloInfo == nil {
+		return c
+	}
+
+	now := time.Now()
+	candidates := append([]*loadedCertificate{c}, c.alternates...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Certificate.Leaf.PublicKeyAlgorithm == x509.ECDSA && candidates[j].Certificate.Leaf.PublicKeyAlgorithm != x509.ECDSA
+	})
+	for _, candidate := range candidates {
+		if candidate.isExpired(now) && !c.isExpired(now) {
+			continue
+		}
+		if helloInfo.SupportsCertificate(candidate.Certificate) == nil {
+			return candidate
+		}
+	}
+	return c
+}
+
+// currentCertIndex returns the current snapshot, never nil
+func (m *Manager) currentCertIndex() *certIndex {
+	index := m.certIndex.Load()
+	if index == nil {
+		return &certIndex{}
+	}
+	return index
+}
+
+// matchHostname returns the certificate covering the hostname by SAN,
+// wildcard or issuer common name
+func (index *certIndex) matchHostname(hostname string) *loadedCertificate {
+	hostname = strings.ToLower(hostname)
+	if cert, ok := index.exact[hostname]; ok {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(hostname, "."); ok {
+		if cert, ok := index.wildcard[parent]; ok {
+			return cert
+		}
+	}
+	if cert, ok := index.issuerCN[hostname]; ok {
+		return cert
+	}
+	return nil
+}
+
+// startCertStoreWatcher reloads the cache when files in the cert store change
+func (m *Manager) startCertStoreWatcher() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(m.CertStore); err != nil {
+		watcher.Close()
+		return err
+	}
+	m.certWatcher = watcher
+
+	go func() {
+		var reloadTimer *time.Timer
+		var timerMutex sync.Mutex
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				ext := filepath.Ext(event.Name)
+				if ext != ".pem" && ext != ".key" {
+					continue
+				}
+				//Batch the events of a key pair being written into a single reload
+				timerMutex.Lock()
+				if reloadTimer != nil {
+					reloadTimer.Stop()
+				}
+				reloadTimer = time.AfterFunc(certStoreReloadDelay, func() {
+					if err := m.UpdateLoadedCertList(); err != nil {
+						m.Logger.PrintAndLog("tls-router", "Failed to reload certificates after cert store change", err)
+					}
+				})
+				timerMutex.Unlock()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				m.Logger.PrintAndLog("tls-router", "Cert store watcher error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the cert store watcher and the OCSP updater
+func (m *Manager) Close() {
+	m.stopOCSPUpdater()
+	if m.certWatcher != nil {
+		m.certWatcher.Close()
+		m.certWatcher = nil
+	}
+}
+
+// GetCertLookupStats returns the handshake certificate lookup statistic
+func (m *Manager) GetCertLookupStats() *CertLookupStats {
+	index := m.currentCertIndex()
+	metrics := &m.lookupMetrics
+	stats := &CertLookupStats{
+		Handshakes:     metrics.handshakes.Load(),
+		Failures:       metrics.failures.Load(),
+		MaxLatency:     float64(metrics.maxLatency.Load()) / float64(time.Microsecond),
+		LatencyBuckets: make([]int64, len(metrics.buckets)),
+		BucketBounds:   []string{},
+		LoadedCerts:    len(index.entries),
+		Reloads:        metrics.reloads.Load(),
+		WatcherRunning: m.certWatcher != nil,
+		ReloadErrors:   metrics.reloadErrors.Load(),
+	}
+	if stats.Handshakes > 0 {
+		stats.AverageLatency = float64(metrics.totalLatency.Load()) / float64(stats.Handshakes) / float64(time.Microsecond)
+	}
+	for i := range metrics.buckets {
+		stats.LatencyBuckets[i] = metrics.buckets[i].Load()
+	}
+	for _, bound := range certLookupLatencyBuckets {
+		stats.BucketBounds = append(stats.BucketBounds, "<"+bound.String())
+	}
+	stats.BucketBounds = append(stats.BucketBounds, ">="+certLookupLatencyBuckets[len(certLookupLatencyBuckets)-1].String())
+	if !index.loadedAt.IsZero() {
+		stats.LastReload = index.loadedAt.Unix()
+	}
+	if lastError, ok := metrics.lastError.Load().(string); ok {
+		stats.LastReloadError = lastError
+	}
+	return stats
+}
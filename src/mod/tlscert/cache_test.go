@@ -0,0 +1,194 @@
+package tlscert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/secrets"
+)
+
+// writeTestCert writes a self-signed ECDSA key pair named name into the cert store
+func writeTestCert(t *testing.T, certStore string, name string, dnsNames []string, notAfter time.Time) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestCertWithKey(t, certStore, name, dnsNames, notAfter, privKey)
+}
+
+// writeTestCertWithKey writes a self-signed key pair named name into the cert store
+func writeTestCertWithKey(t *testing.T, certStore string, name string, dnsNames []string, notAfter time.Time, privKey crypto.Signer) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     dnsNames,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, privKey.Public(), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(privKey)
+	os.WriteFile(filepath.Join(certStore, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.WriteFile(filepath.Join(certStore, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644)
+}
+
+func newTestCacheManager(t *testing.T) *Manager {
+	systemLogger, _ := logger.NewFmtLogger()
+	return &Manager{
+		Logger:                  systemLogger,
+		CertStore:               t.TempDir(),
+		LoadedCerts:             []*CertCache{},
+		hostSpecificTlsBehavior: defaultHostSpecificTlsBehavior,
+	}
+}
+
+func servedCertName(t *testing.T, m *Manager, serverName string) string {
+	cert, err := m.GetCert(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("expected a certificate for %q, got %v", serverName, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertCacheMatching(t *testing.T) {
+	m := newTestCacheManager(t)
+	validUntil := time.Now().Add(30 * 24 * time.Hour)
+	writeTestCert(t, m.CertStore, "a_expired", []string{"app.example.com"}, time.Now().Add(-24*time.Hour))
+	writeTestCert(t, m.CertStore, "b_valid", []string{"app.example.com"}, validUntil)
+	writeTestCert(t, m.CertStore, "wildcard", []string{"*.example.com"}, validUntil)
+	writeTestCert(t, m.CertStore, "legacy.example.org", []string{"other.example.org"}, validUntil)
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"app.example.com":    "b_valid",
+		"APP.example.com":    "b_valid",
+		"api.example.com":    "wildcard",
+		"legacy.example.org": "legacy.example.org",
+		"other.example.org":  "legacy.example.org",
+	}
+	for serverName, expected := range tests {
+		if name := servedCertName(t, m, serverName); name != expected {
+			t.Errorf("expected %s for %s, got %s", expected, serverName, name)
+		}
+	}
+
+	//Wildcards only cover a single label
+	if m.CertMatchExists("a.b.example.com") {
+		t.Error("expected wildcard to not match nested subdomain")
+	}
+
+	stats := m.GetCertLookupStats()
+	if stats.Handshakes != int64(len(tests)) || stats.LoadedCerts != 4 || stats.Reloads != 1 {
+		t.Errorf("unexpected lookup stats: %+v", stats)
+	}
+}
+
+func TestCertCacheHotReload(t *testing.T) {
+	m := newTestCacheManager(t)
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.startCertStoreWatcher(); err != nil {
+		t.Skip("cert store watcher not supported: " + err.Error())
+	}
+	defer m.Close()
+
+	writeTestCert(t, m.CertStore, "new", []string{"new.example.com"}, time.Now().Add(24*time.Hour))
+	deadline := time.Now().Add(5 * time.Second)
+	for !m.CertMatchExists("new.example.com") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected certificate to be loaded after it is written to the cert store")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	os.Remove(filepath.Join(m.CertStore, "new.pem"))
+	deadline = time.Now().Add(5 * time.Second)
+	for m.CertMatchExists("new.example.com") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected certificate to be unloaded after it is removed from the cert store")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCertCacheDualCertificate(t *testing.T) {
+	m := newTestCacheManager(t)
+	validUntil := time.Now().Add(30 * 24 * time.Hour)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestCertWithKey(t, m.CertStore, "app.example.com", []string{"app.example.com"}, validUntil, rsaKey)
+	writeTestCert(t, m.CertStore, "app.example.com_ecdsa", []string{"app.example.com"}, validUntil)
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaClient := &tls.ClientHelloInfo{
+		ServerName:        "app.example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+	rsaOnlyClient := &tls.ClientHelloInfo{
+		ServerName:        "app.example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+
+	//The legacy filename match resolves the RSA certificate, ECDSA is preferred when supported
+	cert, err := m.GetCert(ecdsaClient)
+	if err != nil || cert.Leaf.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("expected ECDSA certificate for ECDSA capable client, got %v", err)
+	}
+	cert, err = m.GetCert(rsaOnlyClient)
+	if err != nil || cert.Leaf.PublicKeyAlgorithm != x509.RSA {
+		t.Errorf("expected RSA certificate for RSA only client, got %v", err)
+	}
+}
+
+func TestCertCacheSealedPrivateKey(t *testing.T) {
+	keyring, err := secrets.CreateKeyring(filepath.Join(t.TempDir(), "keyring.json"), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetDefault(keyring)
+	t.Cleanup(func() { secrets.SetDefault(nil) })
+
+	m := newTestCacheManager(t)
+	writeTestCert(t, m.CertStore, "sealed", []string{"sealed.example.com"}, time.Now().Add(30*24*time.Hour))
+	keyFile := filepath.Join(m.CertStore, "sealed.key")
+	keyPEM, _ := os.ReadFile(keyFile)
+	if err := writePrivateKeyWithMode(m.CertStore, "sealed.key", keyPEM, defaultPrivateKeyFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(keyFile); !secrets.IsSealed(raw) {
+		t.Fatalf("expected the private key to be sealed on disk")
+	}
+
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedCertName(t, m, "sealed.example.com"); name != "sealed" {
+		t.Errorf("expected sealed certificate to be served, got %s", name)
+	}
+}
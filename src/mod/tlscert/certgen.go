@@ -82,7 +82,7 @@ func (m *Manager) GenerateSelfSignedCertificate(cn string, sans []string, certFi
 		m.Logger.PrintAndLog("tls-router", "Unable to marshal ECDSA private key", err)
 		return err
 	}
-	err = writeFileWithMode(m.CertStore, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}), defaultPrivateKeyFileMode)
+	err = writePrivateKeyWithMode(m.CertStore, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}), defaultPrivateKeyFileMode)
 	if err != nil {
 		m.Logger.PrintAndLog("tls-router", "Failed to write private key to file: "+keyFile, err)
 		return err
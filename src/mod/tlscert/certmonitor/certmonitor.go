@@ -0,0 +1,302 @@
+package certmonitor
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	certmonitor.go
+
+	Background monitor of the cert store. Certificates are scanned on a
+	schedule instead of when someone opens the UI, and expiring, expired,
+	failed renewals and endpoints served with a certificate that does not
+	cover their hostname are reported as events and notifications.
+*/
+
+const (
+	DefaultExpiringDays = 14
+	DefaultScanInterval = 6 * 60 * 60 //6 hours, in seconds
+	MinScanInterval     = 5 * 60
+	alertHistoryLength  = 100
+)
+
+// Config is the user configuration of the certificate monitor
+type Config struct {
+	Enabled        bool           //Emit events and send notifications for certificate problems
+	ExpiringDays   int            //Alert certificates expiring within this number of days
+	ScanInterval   int64          //Seconds between cert store scans
+	CheckHostnames bool           //Alert proxy endpoints served a certificate not valid for their hostname
+	Email          *EmailConfig   //Email notification, see notify.go
+	Webhook        *WebhookConfig //Webhook notification, see notify.go
+}
+
+// Endpoint is a proxy endpoint and the hostnames it is served under
+type Endpoint struct {
+	Name      string
+	Hostnames []string
+}
+
+type Options struct {
+	StoreFolder       string                                                          //Folder of the monitor config and alert state
+	CertStore         string                                                          //Cert store of the TLS manager
+	ListEndpoints     func() []*Endpoint                                              //Proxy endpoints served over TLS, nil to skip endpoint checks
+	LookupCertificate func(hostname string) (certName string, leaf *x509.Certificate) //Certificate served for the hostname, empty name for the build-in certificate
+	Logger            *logger.Logger                                                  //System wide logger
+}
+
+type Monitor struct {
+	Options *Options
+	Config  *Config
+
+	state      *monitorState //Raised alerts and alert history
+	inventory  *Inventory    //Result of the last scan
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	scanMutex  sync.Mutex //Serialize scans
+	rescan     chan struct{}
+	stopChan   chan bool
+	started    bool
+}
+
+// monitorState is the persisted alert state, so restarts do not alert twice
+type monitorState struct {
+	Raised  map[string]int64 //Key of the active alert conditions to the time they are raised
+	History []*Alert
+}
+
+// NewCertMonitor loads the monitor config, call Start to begin scanning
+func NewCertMonitor(options *Options) (*Monitor, error) {
+	if err := os.MkdirAll(options.StoreFolder, 0775); err != nil {
+		return nil, err
+	}
+
+	m := &Monitor{
+		Options: options,
+		Config: &Config{
+			Enabled:        true,
+			ExpiringDays:   DefaultExpiringDays,
+			ScanInterval:   DefaultScanInterval,
+			CheckHostnames: true,
+			Email:          &EmailConfig{Recipients: []string{}},
+			Webhook:        &WebhookConfig{},
+		},
+		state: &monitorState{
+			Raised:  map[string]int64{},
+			History: []*Alert{},
+		},
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		rescan:     make(chan struct{}, 1),
+		stopChan:   make(chan bool),
+	}
+	if err := m.loadConfig(); err != nil {
+		return nil, err
+	}
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Start begins the background scan. Start after the proxy endpoints are
+// loaded, a scan without them would clear the hostname mismatch alerts
+func (m *Monitor) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.started || m.stopChan == nil {
+		return
+	}
+	m.started = true
+	go m.scanLoop(m.stopChan)
+}
+
+// Close stops the background scan
+func (m *Monitor) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stopChan != nil {
+		close(m.stopChan)
+		m.stopChan = nil
+	}
+}
+
+func (m *Monitor) Logf(message string, err error) {
+	m.Options.Logger.PrintAndLog("cert-monitor", message, err)
+}
+
+// scanLoop scans the cert store on start and on every scan interval
+func (m *Monitor) scanLoop(stop chan bool) {
+	for {
+		m.Scan()
+		interval := time.Duration(m.GetConfig().ScanInterval) * time.Second
+		select {
+		case <-stop:
+			return
+		case <-m.rescan:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RequestScan wakes the background scan, e.g. after the config changed
+func (m *Monitor) RequestScan() {
+	select {
+	case m.rescan <- struct{}{}:
+	default:
+	}
+}
+
+/* Config */
+
+func (m *Monitor) configPath() string {
+	return filepath.Join(m.Options.StoreFolder, "config.json")
+}
+
+func (m *Monitor) statePath() string {
+	return filepath.Join(m.Options.StoreFolder, "state.json")
+}
+
+func (m *Monitor) loadConfig() error {
+	if !utils.FileExists(m.configPath()) {
+		return m.saveConfig()
+	}
+	content, err := os.ReadFile(m.configPath())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, m.Config); err != nil {
+		return errors.New("malformed certificate monitor config: " + err.Error())
+	}
+	normalizeConfig(m.Config)
+	if m.Config.Email.Password, err = secrets.OpenString(m.Config.Email.Password); err != nil {
+		return errors.New("unable to decrypt SMTP password: " + err.Error())
+	}
+	if m.Config.Webhook.Secret, err = secrets.OpenString(m.Config.Webhook.Secret); err != nil {
+		return errors.New("unable to decrypt webhook secret: " + err.Error())
+	}
+	return nil
+}
+
+// saveConfig writes the config, the SMTP password and webhook secret are
+// sealed if secrets encryption is enabled
+func (m *Monitor) saveConfig() error {
+	config := *m.Config
+	email := *m.Config.Email
+	webhook := *m.Config.Webhook
+	var err error
+	if email.Password, err = secrets.SealString(email.Password); err != nil {
+		return err
+	}
+	if webhook.Secret, err = secrets.SealString(webhook.Secret); err != nil {
+		return err
+	}
+	config.Email = &email
+	config.Webhook = &webhook
+	js, _ := json.MarshalIndent(config, "", " ")
+	return os.WriteFile(m.configPath(), js, 0600)
+}
+
+// normalizeConfig fills in defaults of missing or out of range values
+func normalizeConfig(config *Config) {
+	if config.ExpiringDays <= 0 {
+		config.ExpiringDays = DefaultExpiringDays
+	}
+	if config.ScanInterval <= 0 {
+		config.ScanInterval = DefaultScanInterval
+	} else if config.ScanInterval < MinScanInterval {
+		config.ScanInterval = MinScanInterval
+	}
+	if config.Email == nil {
+		config.Email = &EmailConfig{}
+	}
+	if config.Email.Recipients == nil {
+		config.Email.Recipients = []string{}
+	}
+	if config.Webhook == nil {
+		config.Webhook = &WebhookConfig{}
+	}
+}
+
+// UpdateConfig validates and saves a new config, then rescans the cert store
+func (m *Monitor) UpdateConfig(config *Config) error {
+	normalizeConfig(config)
+	if err := config.Email.validate(); err != nil {
+		return err
+	}
+	if err := config.Webhook.validate(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.Config = config
+	err := m.saveConfig()
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	m.RequestScan()
+	return nil
+}
+
+// GetConfig returns a copy of the current config
+func (m *Monitor) GetConfig() *Config {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	config := *m.Config
+	email := *m.Config.Email
+	email.Recipients = append([]string{}, m.Config.Email.Recipients...)
+	webhook := *m.Config.Webhook
+	config.Email = &email
+	config.Webhook = &webhook
+	return &config
+}
+
+/* Alert state */
+
+func (m *Monitor) loadState() error {
+	if !utils.FileExists(m.statePath()) {
+		return nil
+	}
+	content, err := os.ReadFile(m.statePath())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, m.state); err != nil {
+		return errors.New("malformed certificate monitor state: " + err.Error())
+	}
+	if m.state.Raised == nil {
+		m.state.Raised = map[string]int64{}
+	}
+	if m.state.History == nil {
+		m.state.History = []*Alert{}
+	}
+	return nil
+}
+
+// saveState writes the alert state, the caller must hold the mutex
+func (m *Monitor) saveState() {
+	js, _ := json.MarshalIndent(m.state, "", " ")
+	if err := os.WriteFile(m.statePath(), js, 0644); err != nil {
+		m.Logf("Failed to save certificate monitor state", err)
+	}
+}
+
+// GetAlertHistory returns the recent alerts, newest first
+func (m *Monitor) GetAlertHistory() []*Alert {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	history := make([]*Alert, 0, len(m.state.History))
+	for i := len(m.state.History) - 1; i >= 0; i-- {
+		history = append(history, m.state.History[i])
+	}
+	return history
+}
@@ -0,0 +1,231 @@
+package certmonitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+// writeTestCert writes a self signed certificate into the cert store
+func writeTestCert(t *testing.T, certStore string, name string, domains []string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domains[0]},
+		Issuer:       pkix.Name{CommonName: "Test CA"},
+		DNSNames:     domains,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(certStore, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(filepath.Join(certStore, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	leaf, _ := x509.ParseCertificate(der)
+	return leaf
+}
+
+func newTestMonitor(t *testing.T, endpoints []*Endpoint, served map[string]*x509.Certificate) *Monitor {
+	t.Helper()
+	log, _ := logger.NewFmtLogger()
+	certStore := t.TempDir()
+	m := &Monitor{
+		Options: &Options{
+			StoreFolder: t.TempDir(),
+			CertStore:   certStore,
+			ListEndpoints: func() []*Endpoint {
+				return endpoints
+			},
+			LookupCertificate: func(hostname string) (string, *x509.Certificate) {
+				for name, leaf := range served {
+					if leaf.VerifyHostname(hostname) == nil {
+						return name, leaf
+					}
+				}
+				return "", nil
+			},
+			Logger: log,
+		},
+		Config: &Config{
+			Enabled:        true,
+			ExpiringDays:   DefaultExpiringDays,
+			ScanInterval:   DefaultScanInterval,
+			CheckHostnames: true,
+		},
+		state:      &monitorState{Raised: map[string]int64{}, History: []*Alert{}},
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		rescan:     make(chan struct{}, 1),
+	}
+	normalizeConfig(m.Config)
+	return m
+}
+
+func alertTypes(alerts []*Alert) map[string]int {
+	types := map[string]int{}
+	for _, alert := range alerts {
+		types[alert.Type]++
+	}
+	return types
+}
+
+func TestScanInventoryAndAlerts(t *testing.T) {
+	served := map[string]*x509.Certificate{}
+	endpoints := []*Endpoint{
+		{Name: "app.example.com", Hostnames: []string{"app.example.com", "www.example.com"}},
+		{Name: "old.example.com", Hostnames: []string{"old.example.com"}},
+	}
+	m := newTestMonitor(t, endpoints, served)
+	certStore := m.Options.CertStore
+	now := time.Now()
+	served["app"] = writeTestCert(t, certStore, "app", []string{"app.example.com"}, now.Add(60*24*time.Hour))
+	served["old"] = writeTestCert(t, certStore, "old", []string{"old.example.com"}, now.Add(5*24*time.Hour))
+	writeTestCert(t, certStore, "expired", []string{"expired.example.com"}, now.Add(-24*time.Hour))
+	os.WriteFile(filepath.Join(certStore, "broken.pem"), []byte("not a certificate"), 0644)
+
+	inventory := m.Scan()
+	statuses := map[string]*CertificateInfo{}
+	for _, cert := range inventory.Certificates {
+		statuses[cert.Name] = cert
+	}
+	if statuses["app"].Status != StatusValid || statuses["old"].Status != StatusExpiring || statuses["expired"].Status != StatusExpired || statuses["broken"].Status != StatusInvalid {
+		t.Fatalf("unexpected certificate statuses %+v", inventory.Certificates)
+	}
+	if len(statuses["app"].Endpoints) != 1 || statuses["app"].Endpoints[0] != "app.example.com" {
+		t.Fatalf("expected app certificate to serve app.example.com, got %v", statuses["app"].Endpoints)
+	}
+	if len(statuses["expired"].Endpoints) != 0 {
+		t.Fatalf("expected unused certificate to serve no endpoint, got %v", statuses["expired"].Endpoints)
+	}
+
+	mismatches := 0
+	for _, hostname := range inventory.Hostnames {
+		if hostname.Mismatch {
+			mismatches++
+			if hostname.Hostname != "www.example.com" || hostname.Endpoint != "app.example.com" {
+				t.Fatalf("unexpected mismatch %+v", hostname)
+			}
+		}
+	}
+	if mismatches != 1 {
+		t.Fatalf("expected 1 hostname mismatch, got %d", mismatches)
+	}
+
+	types := alertTypes(m.GetAlertHistory())
+	if types[AlertExpiring] != 1 || types[AlertExpired] != 1 || types[AlertHostnameMismatch] != 1 {
+		t.Fatalf("unexpected alerts %v", types)
+	}
+
+	//Conditions are alerted once
+	m.Scan()
+	if len(m.GetAlertHistory()) != 3 {
+		t.Fatalf("expected no new alerts on rescan, got %d alerts", len(m.GetAlertHistory()))
+	}
+
+	//Renewing the certificate clears the condition, expiring again alerts again
+	served["old"] = writeTestCert(t, certStore, "old", []string{"old.example.com"}, now.Add(60*24*time.Hour))
+	m.Scan()
+	served["old"] = writeTestCert(t, certStore, "old", []string{"old.example.com"}, now.Add(2*24*time.Hour))
+	m.Scan()
+	if types := alertTypes(m.GetAlertHistory()); types[AlertExpiring] != 2 {
+		t.Fatalf("expected renewed certificate to alert again, got %v", types)
+	}
+}
+
+func TestDisabledMonitorDoesNotAlert(t *testing.T) {
+	m := newTestMonitor(t, nil, nil)
+	m.Config.Enabled = false
+	writeTestCert(t, m.Options.CertStore, "expired", []string{"expired.example.com"}, time.Now().Add(-time.Hour))
+	m.Scan()
+	m.ReportRenewalFailure("expired", []string{"expired.example.com"}, "expiry", 1, errors.New("rate limited"))
+	if len(m.GetAlertHistory()) != 0 {
+		t.Fatalf("expected no alerts while disabled, got %+v", m.GetAlertHistory())
+	}
+
+	//Enabling alerts the current conditions
+	m.Config.Enabled = true
+	m.Scan()
+	if types := alertTypes(m.GetAlertHistory()); types[AlertExpired] != 1 {
+		t.Fatalf("expected expired alert after enabling, got %v", types)
+	}
+}
+
+func TestWebhookNotification(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer webhook.Close()
+
+	m := newTestMonitor(t, nil, nil)
+	m.Config.Webhook = &WebhookConfig{Enabled: true, URL: webhook.URL, Secret: "s3cret"}
+	m.ReportRenewalFailure("app", []string{"app.example.com"}, "expiry", 2, errors.New("rate limited"))
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not called")
+	}
+	body := <-bodies
+	if r.Header.Get("X-Zoraxy-Signature") != "sha256="+signWebhookBody("s3cret", body) {
+		t.Fatalf("invalid webhook signature %q", r.Header.Get("X-Zoraxy-Signature"))
+	}
+	payload := struct {
+		Event string `json:"event"`
+		Alert *Alert `json:"alert"`
+		Data  struct {
+			Failures int    `json:"failures"`
+			Error    string `json:"error"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("parse webhook body: %v", err)
+	}
+	if payload.Event != "certificateRenewalFailed" || payload.Alert.Type != AlertRenewalFailed || payload.Data.Failures != 2 || payload.Data.Error != "rate limited" {
+		t.Fatalf("unexpected webhook payload %s", body)
+	}
+}
+
+func TestHostnameToCheck(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     string
+		ok       bool
+	}{
+		{"App.Example.com", "app.example.com", true},
+		{"example.com:8443", "example.com", true},
+		{"*.example.com", "wildcard-check.example.com", true},
+		{"192.168.1.10", "192.168.1.10", true},
+		{"test.*.com", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		got, ok := hostnameToCheck(test.hostname)
+		if got != test.want || ok != test.ok {
+			t.Errorf("hostnameToCheck(%q) = %q, %v, want %q, %v", test.hostname, got, ok, test.want, test.ok)
+		}
+	}
+}
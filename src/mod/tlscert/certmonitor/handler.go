@@ -0,0 +1,139 @@
+package certmonitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	handler.go
+
+	API handlers of the certificate monitor
+*/
+
+// HandleInventory returns the certificates in the cert store and the
+// endpoints they serve, set refresh=true to scan before returning
+func (m *Monitor) HandleInventory(w http.ResponseWriter, r *http.Request) {
+	inventory := m.GetInventory()
+	if refresh, _ := utils.GetBool(r, "refresh"); refresh {
+		inventory = m.Scan()
+	}
+	js, _ := json.Marshal(inventory)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleAlerts returns the recent alerts
+func (m *Monitor) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(m.GetAlertHistory())
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleConfig reads or updates the monitor config. Passwords and secrets
+// are never returned, posting them empty keeps the saved value
+func (m *Monitor) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		config := m.GetConfig()
+		type redactedConfig struct {
+			*Config
+			EmailPasswordSet bool
+			WebhookSecretSet bool
+		}
+		result := redactedConfig{
+			Config:           config,
+			EmailPasswordSet: config.Email.Password != "",
+			WebhookSecretSet: config.Webhook.Secret != "",
+		}
+		config.Email.Password = ""
+		config.Webhook.Secret = ""
+		js, _ := json.Marshal(result)
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := m.GetConfig()
+	if enabled, err := utils.PostBool(r, "enabled"); err == nil {
+		config.Enabled = enabled
+	}
+	if expiringDays, err := utils.PostInt(r, "expiringDays"); err == nil {
+		if expiringDays <= 0 {
+			utils.SendErrorResponse(w, "expiring days must be larger than 0")
+			return
+		}
+		config.ExpiringDays = expiringDays
+	}
+	if scanInterval, err := utils.PostInt(r, "scanInterval"); err == nil {
+		if scanInterval < MinScanInterval {
+			utils.SendErrorResponse(w, "scan interval must be at least 300 seconds")
+			return
+		}
+		config.ScanInterval = int64(scanInterval)
+	}
+	if checkHostnames, err := utils.PostBool(r, "checkHostnames"); err == nil {
+		config.CheckHostnames = checkHostnames
+	}
+
+	//Email notification
+	if emailEnabled, err := utils.PostBool(r, "emailEnabled"); err == nil {
+		config.Email.Enabled = emailEnabled
+	}
+	if hostname, err := utils.PostPara(r, "smtpHost"); err == nil {
+		config.Email.Hostname = strings.TrimSpace(hostname)
+	}
+	if port, err := utils.PostInt(r, "smtpPort"); err == nil {
+		config.Email.Port = port
+	}
+	if username, err := utils.PostPara(r, "smtpUsername"); err == nil {
+		config.Email.Username = strings.TrimSpace(username)
+	}
+	if password, err := utils.PostPara(r, "smtpPassword"); err == nil && password != "" {
+		config.Email.Password = password
+	}
+	if senderAddr, err := utils.PostPara(r, "senderAddr"); err == nil {
+		config.Email.SenderAddr = strings.TrimSpace(senderAddr)
+	}
+	if recipients, err := utils.PostPara(r, "recipients"); err == nil {
+		config.Email.Recipients = []string{}
+		for _, recipient := range strings.Split(recipients, ",") {
+			recipient = strings.TrimSpace(recipient)
+			if recipient != "" {
+				config.Email.Recipients = append(config.Email.Recipients, recipient)
+			}
+		}
+	}
+
+	//Webhook notification
+	if webhookEnabled, err := utils.PostBool(r, "webhookEnabled"); err == nil {
+		config.Webhook.Enabled = webhookEnabled
+	}
+	if webhookURL, err := utils.PostPara(r, "webhookUrl"); err == nil {
+		config.Webhook.URL = strings.TrimSpace(webhookURL)
+	}
+	if secret, err := utils.PostPara(r, "webhookSecret"); err == nil && secret != "" {
+		config.Webhook.Secret = secret
+	}
+
+	if err := m.UpdateConfig(config); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleTestNotification sends a test alert through the enabled channels
+func (m *Monitor) HandleTestNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.SendTestNotification(); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
@@ -0,0 +1,220 @@
+package certmonitor
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	inventory.go
+
+	Scan the cert store and resolve which proxy endpoints each
+	certificate is actually served for
+*/
+
+// Certificate status in the inventory
+const (
+	StatusValid    = "valid"
+	StatusExpiring = "expiring"
+	StatusExpired  = "expired"
+	StatusInvalid  = "invalid" //Unable to parse or missing its private key
+)
+
+// CertificateInfo is a certificate in the cert store
+type CertificateInfo struct {
+	Name         string   `json:"name"`
+	Domains      []string `json:"domains"`
+	Issuer       string   `json:"issuer"`
+	SerialNumber string   `json:"serial_number"`
+	NotBefore    int64    `json:"not_before"`
+	NotAfter     int64    `json:"not_after"`
+	DaysLeft     int      `json:"days_left"`
+	Status       string   `json:"status"`
+	Error        string   `json:"error,omitempty"`
+	Endpoints    []string `json:"endpoints"` //Proxy endpoints served with this certificate
+}
+
+// HostnameStatus is the certificate served for a proxy endpoint hostname
+type HostnameStatus struct {
+	Hostname string `json:"hostname"`
+	Endpoint string `json:"endpoint"`
+	CertName string `json:"cert_name"` //Empty if the build-in certificate is served
+	Mismatch bool   `json:"mismatch"`  //The served certificate does not cover the hostname
+}
+
+// Inventory is the result of a cert store scan
+type Inventory struct {
+	Certificates []*CertificateInfo `json:"certificates"`
+	Hostnames    []*HostnameStatus  `json:"hostnames"`
+	ScannedAt    int64              `json:"scanned_at"`
+}
+
+// GetInventory returns the result of the last scan
+func (m *Monitor) GetInventory() *Inventory {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.inventory == nil {
+		return &Inventory{Certificates: []*CertificateInfo{}, Hostnames: []*HostnameStatus{}}
+	}
+	return m.inventory
+}
+
+// Scan scans the cert store and proxy endpoints, then raises alerts for new problems
+func (m *Monitor) Scan() *Inventory {
+	m.scanMutex.Lock()
+	defer m.scanMutex.Unlock()
+
+	config := m.GetConfig()
+	now := time.Now()
+	inventory := &Inventory{
+		Certificates: m.scanCertStore(now, config.ExpiringDays),
+		Hostnames:    []*HostnameStatus{},
+		ScannedAt:    now.Unix(),
+	}
+	inventory.Hostnames = m.resolveEndpoints(inventory.Certificates)
+
+	m.mutex.Lock()
+	m.inventory = inventory
+	m.mutex.Unlock()
+
+	m.raiseAlerts(inventory, config)
+	return inventory
+}
+
+// scanCertStore parses every certificate in the cert store
+func (m *Monitor) scanCertStore(now time.Time, expiringDays int) []*CertificateInfo {
+	certs := []*CertificateInfo{}
+	files, err := os.ReadDir(m.Options.CertStore)
+	if err != nil {
+		m.Logf("Failed to read cert store", err)
+		return certs
+	}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".pem")
+		if file.IsDir() || !ok {
+			continue
+		}
+		certs = append(certs, m.inspectCertificate(name, now, expiringDays))
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotAfter < certs[j].NotAfter
+	})
+	return certs
+}
+
+func (m *Monitor) inspectCertificate(name string, now time.Time, expiringDays int) *CertificateInfo {
+	info := &CertificateInfo{
+		Name:      name,
+		Domains:   []string{},
+		Status:    StatusInvalid,
+		Endpoints: []string{},
+	}
+	content, err := os.ReadFile(filepath.Join(m.Options.CertStore, name+".pem"))
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		info.Error = "no PEM encoded certificate found"
+		return info
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	info.Domains = certificateNames(leaf)
+	info.Issuer = leaf.Issuer.CommonName
+	info.SerialNumber = fmt.Sprintf("%x", leaf.SerialNumber)
+	info.NotBefore = leaf.NotBefore.Unix()
+	info.NotAfter = leaf.NotAfter.Unix()
+	info.DaysLeft = int(leaf.NotAfter.Sub(now).Hours() / 24)
+	if !utils.FileExists(filepath.Join(m.Options.CertStore, name+".key")) {
+		info.Error = "private key not found"
+		return info
+	}
+
+	switch {
+	case now.After(leaf.NotAfter):
+		info.Status = StatusExpired
+	case leaf.NotAfter.Sub(now) < time.Duration(expiringDays)*24*time.Hour:
+		info.Status = StatusExpiring
+	default:
+		info.Status = StatusValid
+	}
+	return info
+}
+
+// resolveEndpoints looks up the certificate served for every endpoint hostname
+func (m *Monitor) resolveEndpoints(certs []*CertificateInfo) []*HostnameStatus {
+	hostnames := []*HostnameStatus{}
+	if m.Options.ListEndpoints == nil || m.Options.LookupCertificate == nil {
+		return hostnames
+	}
+
+	certsByName := map[string]*CertificateInfo{}
+	for _, cert := range certs {
+		certsByName[cert.Name] = cert
+	}
+	for _, endpoint := range m.Options.ListEndpoints() {
+		for _, hostname := range endpoint.Hostnames {
+			checkName, ok := hostnameToCheck(hostname)
+			if !ok {
+				continue
+			}
+			certName, leaf := m.Options.LookupCertificate(checkName)
+			status := &HostnameStatus{
+				Hostname: hostname,
+				Endpoint: endpoint.Name,
+				CertName: certName,
+				Mismatch: leaf == nil || leaf.VerifyHostname(checkName) != nil,
+			}
+			hostnames = append(hostnames, status)
+
+			if cert, ok := certsByName[certName]; ok && !utils.StringInArray(cert.Endpoints, endpoint.Name) {
+				cert.Endpoints = append(cert.Endpoints, endpoint.Name)
+			}
+		}
+	}
+	return hostnames
+}
+
+// hostnameToCheck returns the name a TLS client would connect with for the
+// matching hostname of an endpoint. Wildcards are checked with a sample
+// subdomain, other patterns cannot be checked
+func hostnameToCheck(hostname string) (string, bool) {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	if parent, ok := strings.CutPrefix(hostname, "*."); ok {
+		hostname = "wildcard-check." + parent
+	}
+	if hostname == "" || strings.ContainsAny(hostname, "*/") {
+		return "", false
+	}
+	return hostname, true
+}
+
+// certificateNames returns the DNS names and IP addresses of a certificate
+func certificateNames(leaf *x509.Certificate) []string {
+	names := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, leaf.Subject.CommonName)
+	}
+	return names
+}
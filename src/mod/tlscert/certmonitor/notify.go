@@ -0,0 +1,362 @@
+package certmonitor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/email"
+	"imuslab.com/zoraxy/mod/eventsystem"
+	"imuslab.com/zoraxy/mod/plugins/zoraxy_plugin/events"
+)
+
+/*
+	notify.go
+
+	Raise alerts for certificate problems found by the scan. Each condition
+	is alerted once, e.g. once per certificate serial entering the expiring
+	window, and alerted again only after it cleared and came back. Alerts
+	are emitted as events and sent through email and webhook if enabled.
+*/
+
+// Alert types
+const (
+	AlertExpiring         = "expiring"
+	AlertExpired          = "expired"
+	AlertRenewalFailed    = "renewal_failed"
+	AlertHostnameMismatch = "hostname_mismatch"
+	AlertTest             = "test"
+)
+
+// Alert is a certificate problem reported by the monitor
+type Alert struct {
+	Type     string   `json:"type"`
+	CertName string   `json:"cert_name"`
+	Domains  []string `json:"domains"`
+	Hostname string   `json:"hostname,omitempty"`
+	Endpoint string   `json:"endpoint,omitempty"`
+	Message  string   `json:"message"`
+	Time     int64    `json:"time"`
+}
+
+// EmailConfig is the SMTP server and recipients of alert emails
+type EmailConfig struct {
+	Enabled    bool
+	Hostname   string //E.g. mail.gandi.net
+	Port       int    //E.g. 587
+	Username   string
+	Password   string
+	SenderAddr string
+	Recipients []string
+}
+
+// WebhookConfig is the URL alerts are posted to as JSON
+type WebhookConfig struct {
+	Enabled bool
+	URL     string
+	Secret  string //Key of the HMAC-SHA256 X-Zoraxy-Signature header, optional
+}
+
+// WebhookPayload is the JSON body posted to the webhook
+type WebhookPayload struct {
+	Event events.EventName    `json:"event"`
+	Alert *Alert              `json:"alert"`
+	Data  events.EventPayload `json:"data,omitempty"`
+}
+
+func (c *EmailConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Hostname == "" || c.Port <= 0 || c.Port > 65535 {
+		return errors.New("invalid SMTP server")
+	}
+	if _, err := mail.ParseAddress(c.SenderAddr); err != nil {
+		return errors.New("invalid sender address")
+	}
+	if len(c.Recipients) == 0 {
+		return errors.New("no email recipient given")
+	}
+	for _, recipient := range c.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return errors.New("invalid recipient " + recipient)
+		}
+	}
+	return nil
+}
+
+func (c *WebhookConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return errors.New("webhook URL must start with http:// or https://")
+	}
+	return nil
+}
+
+// raiseAlerts alerts the conditions of the inventory not raised before
+func (m *Monitor) raiseAlerts(inventory *Inventory, config *Config) {
+	type pendingAlert struct {
+		alert   *Alert
+		payload events.EventPayload
+	}
+	now := time.Now()
+	active := map[string]bool{}
+	pending := []*pendingAlert{}
+	raise := func(key string, alert *Alert, payload events.EventPayload) {
+		active[key] = true
+		m.mutex.RLock()
+		_, raised := m.state.Raised[key]
+		m.mutex.RUnlock()
+		if !raised {
+			alert.Time = now.Unix()
+			pending = append(pending, &pendingAlert{alert, payload})
+		}
+	}
+
+	for _, cert := range inventory.Certificates {
+		switch cert.Status {
+		case StatusExpiring:
+			raise(AlertExpiring+"/"+cert.Name+"/"+cert.SerialNumber, &Alert{
+				Type:     AlertExpiring,
+				CertName: cert.Name,
+				Domains:  cert.Domains,
+				Message:  fmt.Sprintf("Certificate %s expires in %d days", cert.Name, cert.DaysLeft),
+			}, &events.CertificateExpiringEvent{
+				CertName:     cert.Name,
+				Domains:      cert.Domains,
+				SerialNumber: cert.SerialNumber,
+				Issuer:       cert.Issuer,
+				NotAfter:     cert.NotAfter,
+				DaysLeft:     cert.DaysLeft,
+				Endpoints:    cert.Endpoints,
+			})
+		case StatusExpired:
+			raise(AlertExpired+"/"+cert.Name+"/"+cert.SerialNumber, &Alert{
+				Type:     AlertExpired,
+				CertName: cert.Name,
+				Domains:  cert.Domains,
+				Message:  "Certificate " + cert.Name + " has expired",
+			}, &events.CertificateExpiredEvent{
+				CertName:     cert.Name,
+				Domains:      cert.Domains,
+				SerialNumber: cert.SerialNumber,
+				Issuer:       cert.Issuer,
+				NotAfter:     cert.NotAfter,
+				Endpoints:    cert.Endpoints,
+			})
+		}
+	}
+
+	if config.CheckHostnames {
+		certsByName := map[string]*CertificateInfo{}
+		for _, cert := range inventory.Certificates {
+			certsByName[cert.Name] = cert
+		}
+		for _, hostname := range inventory.Hostnames {
+			if !hostname.Mismatch {
+				continue
+			}
+			certDomains := []string{}
+			if cert, ok := certsByName[hostname.CertName]; ok {
+				certDomains = cert.Domains
+			}
+			served := hostname.CertName
+			if served == "" {
+				served = "the build-in certificate"
+			}
+			raise(AlertHostnameMismatch+"/"+hostname.Hostname+"/"+hostname.CertName, &Alert{
+				Type:     AlertHostnameMismatch,
+				CertName: hostname.CertName,
+				Domains:  certDomains,
+				Hostname: hostname.Hostname,
+				Endpoint: hostname.Endpoint,
+				Message:  "Endpoint " + hostname.Hostname + " is served with " + served + " which does not cover its hostname",
+			}, &events.CertificateHostnameMismatchEvent{
+				Hostname:    hostname.Hostname,
+				Endpoint:    hostname.Endpoint,
+				CertName:    hostname.CertName,
+				CertDomains: certDomains,
+			})
+		}
+	}
+
+	//Forget cleared conditions so they are alerted again if they come back
+	m.mutex.Lock()
+	changed := false
+	for key := range m.state.Raised {
+		if !active[key] {
+			delete(m.state.Raised, key)
+			changed = true
+		}
+	}
+	if config.Enabled {
+		for key := range active {
+			if _, ok := m.state.Raised[key]; !ok {
+				m.state.Raised[key] = now.Unix()
+				changed = true
+			}
+		}
+	}
+	if changed {
+		m.saveState()
+	}
+	m.mutex.Unlock()
+
+	if !config.Enabled {
+		return
+	}
+	for _, p := range pending {
+		m.dispatch(p.alert, p.payload, config)
+	}
+}
+
+// ReportRenewalFailure alerts a failed ACME issuance or renewal. Renewals
+// back off on failure, so every failed attempt is alerted
+func (m *Monitor) ReportRenewalFailure(certName string, domains []string, reason string, failures int, err error) {
+	config := m.GetConfig()
+	if !config.Enabled {
+		return
+	}
+	errMessage := ""
+	if err != nil {
+		errMessage = err.Error()
+	}
+	if domains == nil {
+		domains = []string{}
+	}
+	alert := &Alert{
+		Type:     AlertRenewalFailed,
+		CertName: certName,
+		Domains:  domains,
+		Message:  "Unable to obtain certificate " + certName + ": " + errMessage,
+		Time:     time.Now().Unix(),
+	}
+	m.dispatch(alert, &events.CertificateRenewalFailedEvent{
+		CertName: certName,
+		Domains:  domains,
+		Reason:   reason,
+		Failures: failures,
+		Error:    errMessage,
+	}, config)
+}
+
+// dispatch records the alert, emits its event and sends the notifications in the background
+func (m *Monitor) dispatch(alert *Alert, payload events.EventPayload, config *Config) {
+	m.Logf(alert.Message, nil)
+	m.mutex.Lock()
+	m.state.History = append(m.state.History, alert)
+	if len(m.state.History) > alertHistoryLength {
+		m.state.History = m.state.History[len(m.state.History)-alertHistoryLength:]
+	}
+	m.saveState()
+	m.mutex.Unlock()
+
+	if eventsystem.Publisher != nil {
+		eventsystem.Publisher.Emit(payload)
+	}
+	go func() {
+		if err := m.sendNotifications(alert, payload, config); err != nil {
+			m.Logf("Failed to send certificate alert notification", err)
+		}
+	}()
+}
+
+// SendTestNotification sends a test alert through the enabled channels
+func (m *Monitor) SendTestNotification() error {
+	config := m.GetConfig()
+	if !config.Email.Enabled && !config.Webhook.Enabled {
+		return errors.New("no notification channel enabled")
+	}
+	return m.sendNotifications(&Alert{
+		Type:    AlertTest,
+		Domains: []string{},
+		Message: "Test notification from the Zoraxy certificate monitor",
+		Time:    time.Now().Unix(),
+	}, nil, config)
+}
+
+// sendNotifications sends the alert through email and webhook, returns the last error if any
+func (m *Monitor) sendNotifications(alert *Alert, payload events.EventPayload, config *Config) error {
+	var lastErr error
+	if config.Email.Enabled {
+		sender := email.NewEmailSender(config.Email.Hostname, config.Email.Port, config.Email.Username, config.Email.Password, config.Email.SenderAddr)
+		content := renderAlertEmail(alert)
+		for _, recipient := range config.Email.Recipients {
+			if err := sender.SendEmail(recipient, "[Zoraxy] "+alert.Message, content); err != nil {
+				lastErr = errors.New("email to " + recipient + ": " + err.Error())
+			}
+		}
+	}
+	if config.Webhook.Enabled {
+		if err := m.postWebhook(config.Webhook, alert, payload); err != nil {
+			lastErr = errors.New("webhook: " + err.Error())
+		}
+	}
+	return lastErr
+}
+
+func (m *Monitor) postWebhook(webhook *WebhookConfig, alert *Alert, payload events.EventPayload) error {
+	body := &WebhookPayload{Alert: alert, Data: payload}
+	if payload != nil {
+		body.Event = payload.GetName()
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Zoraxy-CertMonitor")
+	if webhook.Secret != "" {
+		req.Header.Set("X-Zoraxy-Signature", "sha256="+signWebhookBody(webhook.Secret, js))
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of the body, so receivers can verify the sender
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func renderAlertEmail(alert *Alert) string {
+	var sb strings.Builder
+	sb.WriteString(`<div style="font-family:-apple-system,'Segoe UI',Roboto,sans-serif;max-width:520px;margin:auto;color:#2d3748;">`)
+	sb.WriteString(`<h2>Certificate Alert</h2>`)
+	sb.WriteString(`<p>` + html.EscapeString(alert.Message) + `</p>`)
+	if alert.CertName != "" {
+		sb.WriteString(`<p>Certificate: ` + html.EscapeString(alert.CertName) + `</p>`)
+	}
+	if len(alert.Domains) > 0 {
+		sb.WriteString(`<p>Domains: ` + html.EscapeString(strings.Join(alert.Domains, ", ")) + `</p>`)
+	}
+	if alert.Endpoint != "" {
+		sb.WriteString(`<p>Proxy endpoint: ` + html.EscapeString(alert.Endpoint) + `</p>`)
+	}
+	sb.WriteString(`<p style="color:#718096;font-size:0.9em;">` + time.Unix(alert.Time, 0).Format(time.RFC1123) + `</p>`)
+	sb.WriteString(`</div>`)
+	return sb.String()
+}
@@ -1,6 +1,7 @@
 package tlscert
 
 import (
+	"archive/zip"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"imuslab.com/zoraxy/mod/acme"
+	"imuslab.com/zoraxy/mod/secrets"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -46,8 +48,8 @@ func (m *Manager) HandleCertDownload(w http.ResponseWriter, r *http.Request) {
 	certname = filepath.Base(certname) // prevent path escape
 
 	// check if the cert exists
-	pubKey := filepath.Join(filepath.Join(m.CertStore), certname+".key")
-	priKey := filepath.Join(filepath.Join(m.CertStore), certname+".pem")
+	priKey := filepath.Join(filepath.Join(m.CertStore), certname+".key")
+	pubKey := filepath.Join(filepath.Join(m.CertStore), certname+".pem")
 
 	if utils.FileExists(pubKey) && utils.FileExists(priKey) {
 		// Zip them and serve them via http download
@@ -58,21 +60,33 @@ func (m *Manager) HandleCertDownload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Serve both file in zip
-		zipTmpFolder := "./tmp/download"
-		os.MkdirAll(zipTmpFolder, 0o775)
-		zipFileName := filepath.Join(zipTmpFolder, certname+".zip")
-		err := utils.ZipFiles(zipFileName, pubKey, priKey)
+		// The private key might be sealed, export it decrypted
+		certPEM, err := os.ReadFile(pubKey)
 		if err != nil {
-			http.Error(w, "Failed to create zip file", http.StatusInternalServerError)
+			http.Error(w, "Failed to read certificate", http.StatusInternalServerError)
+			return
+		}
+		keyPEM, err := secrets.ReadFile(priKey)
+		if err != nil {
+			http.Error(w, "Failed to read private key: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer os.Remove(zipFileName) // Clean up the zip file after serving
 
-		// Serve the zip file
+		// Serve both file in zip
 		w.Header().Set("Content-Disposition", "attachment; filename=\""+certname+"_export.zip\"")
 		w.Header().Set("Content-Type", "application/zip")
-		http.ServeFile(w, r, zipFileName)
+		zipWriter := zip.NewWriter(w)
+		for _, entry := range []struct {
+			name    string
+			content []byte
+		}{{certname + ".key", keyPEM}, {certname + ".pem", certPEM}} {
+			writer, err := zipWriter.Create(entry.name)
+			if err != nil {
+				return
+			}
+			writer.Write(entry.content)
+		}
+		zipWriter.Close()
 	} else {
 		// Not both key exists
 		utils.SendErrorResponse(w, "invalid key-pairs: private key or public key not found in key store")
@@ -179,7 +193,11 @@ func (m *Manager) HandleCertUpload(w http.ResponseWriter, r *http.Request) {
 		fileMode = defaultPrivateKeyFileMode
 	}
 
-	err = writeFileWithMode(m.CertStore, overWriteFilename, fileBytes, fileMode)
+	if keytype == "pri" {
+		err = writePrivateKeyWithMode(m.CertStore, overWriteFilename, fileBytes, fileMode)
+	} else {
+		err = writeFileWithMode(m.CertStore, overWriteFilename, fileBytes, fileMode)
+	}
 	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
@@ -265,8 +283,10 @@ func (m *Manager) HandleListCertificate(w http.ResponseWriter, r *http.Request)
 			LastModifiedDate string
 			ExpireDate       string
 			RemainingDays    int
-			UseDNS           bool // Whether this cert is obtained via DNS challenge
-			IsFallback       bool // Whether this cert is the fallback/default cert
+			UseDNS           bool   // Whether this cert is obtained via DNS challenge
+			ChallengeType    string // ACME challenge type used for this cert, empty if not obtained via ACME
+			KeyType          string // Key type of the cert, e.g. EC256 or RSA2048
+			IsFallback       bool   // Whether this cert is the fallback/default cert
 		}
 
 		results := []*CertInfo{}
@@ -282,6 +302,7 @@ func (m *Manager) HandleListCertificate(w http.ResponseWriter, r *http.Request)
 			modifiedTime := fileInfo.ModTime().Format("2006-01-02 15:04:05")
 
 			certExpireTime := "Unknown"
+			certKeyType := ""
 			certBtyes, err := os.ReadFile(certFilepath)
 			expiredIn := 0
 			if err != nil {
@@ -294,6 +315,7 @@ func (m *Manager) HandleListCertificate(w http.ResponseWriter, r *http.Request)
 					cert, err := x509.ParseCertificate(block.Bytes)
 					if err == nil {
 						certExpireTime = cert.NotAfter.Format("2006-01-02 15:04:05")
+						certKeyType = getCertKeyType(cert)
 
 						duration := time.Until(cert.NotAfter)
 
@@ -305,8 +327,10 @@ func (m *Manager) HandleListCertificate(w http.ResponseWriter, r *http.Request)
 			certInfoFilename := filepath.Join(m.CertStore, filename+".json")
 			useDNSValidation := false                                // Default to false for HTTP TLS certificates
 			certInfo, err := acme.LoadCertInfoJSON(certInfoFilename) // Note: Not all certs have info json
+			challengeType := ""
 			if err == nil {
 				useDNSValidation = certInfo.UseDNS
+				challengeType = certInfo.GetChallengeType()
 			}
 
 			certDomain := ""
@@ -325,6 +349,8 @@ func (m *Manager) HandleListCertificate(w http.ResponseWriter, r *http.Request)
 				ExpireDate:       certExpireTime,
 				RemainingDays:    expiredIn,
 				UseDNS:           useDNSValidation,
+				ChallengeType:    challengeType,
+				KeyType:          certKeyType,
 				IsFallback:       (filename == m.FallbackCert), // TODO: figure out a better implementation
 			}
 
@@ -370,6 +396,21 @@ func (m *Manager) HandleDefaultCertCheck(w http.ResponseWriter, r *http.Request)
 	utils.SendJSONResponse(w, string(js))
 }
 
+// Return the certificate cache and handshake lookup latency statistic
+func (m *Manager) HandleCertLookupStats(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(m.GetCertLookupStats())
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Return the OCSP state of the loaded certificates, POST to refetch all responses
+func (m *Manager) HandleOCSPStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		m.RefreshOCSP(true)
+	}
+	js, _ := json.Marshal(m.GetOCSPStatus())
+	utils.SendJSONResponse(w, string(js))
+}
+
 func (m *Manager) HandleSelfSignCertGenerate(w http.ResponseWriter, r *http.Request) {
 	// Get the common name from the request
 	cn, err := utils.GetPara(r, "cn")
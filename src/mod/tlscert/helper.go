@@ -1,7 +1,11 @@
 package tlscert
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -46,3 +50,14 @@ func domainToFilename(domain string, ext string) string {
 	// Add .pem extension
 	return domain + "." + ext
 }
+
+// Get the key type of a certificate in the same notation as the ACME key types
+func getCertKeyType(cert *x509.Certificate) string {
+	switch publicKey := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "EC" + strconv.Itoa(publicKey.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		return "RSA" + strconv.Itoa(publicKey.N.BitLen())
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
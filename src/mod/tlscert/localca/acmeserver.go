@@ -0,0 +1,1011 @@
+package localca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	acmeserver.go
+
+	A minimal RFC 8555 ACME server backed by the local CA, so other
+	internal machines can request certificates with any ACME client.
+
+	Only http-01 validation is supported and identifiers are restricted
+	to internal names. Accounts are persisted, orders only live in memory
+	as clients complete them within a few seconds.
+*/
+
+const (
+	acmePathPrefix        = PublicPathPrefix + "acme/"
+	acmeOrderLifetime     = 24 * time.Hour
+	acmeNonceLifetime     = 1 * time.Hour
+	acmeMaxNonces         = 10000
+	acmeMaxBodySize       = 64 * 1024
+	acmeMaxIdentifiers    = 100
+	acmeChallengeTimeout  = 10 * time.Second
+	acmeMaxChallengeBytes = 1024
+)
+
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusReady       = "ready"
+	acmeStatusProcessing  = "processing"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+)
+
+var acmeSignatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.PS256, jose.ES256, jose.ES384, jose.ES512, jose.EdDSA,
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+type acmeAccount struct {
+	ID        string           //Thumbprint of the account key
+	Key       *jose.JSONWebKey //Public key of the account
+	Contact   []string
+	Status    string
+	CreatedAt int64
+}
+
+type acmeAuthz struct {
+	ID         string
+	AccountID  string
+	Identifier acmeIdentifier
+	Status     string
+	Expires    time.Time
+	Token      string       //Token of the http-01 challenge, the challenge shares the authz ID
+	Validated  time.Time    //Time the challenge passed
+	Error      *acmeProblem //Reason the challenge failed
+}
+
+type acmeOrder struct {
+	ID          string
+	AccountID   string
+	Status      string //Pending and ready are derived from the authorizations on read
+	Expires     time.Time
+	Identifiers []acmeIdentifier
+	AuthzIDs    []string
+	CertID      string
+	Error       *acmeProblem
+}
+
+type acmeCertificate struct {
+	AccountID string
+	Chain     []byte
+	Expires   time.Time
+}
+
+type acmeServer struct {
+	ca            *CA
+	accounts      map[string]*acmeAccount
+	orders        map[string]*acmeOrder
+	authzs        map[string]*acmeAuthz
+	certs         map[string]*acmeCertificate
+	nonces        map[string]time.Time
+	httpClient    *http.Client //Client used for http-01 validation
+	challengePort int          //Port the http-01 challenge is fetched from
+	mutex         sync.Mutex
+}
+
+// acmeRequest is a verified JWS request
+type acmeRequest struct {
+	payload []byte
+	account *acmeAccount     //Account of the kid, nil if the request is signed with a jwk
+	jwk     *jose.JSONWebKey //Key the request is signed with
+}
+
+func newACMEServer(ca *CA) (*acmeServer, error) {
+	s := &acmeServer{
+		ca:       ca,
+		accounts: map[string]*acmeAccount{},
+		orders:   map[string]*acmeOrder{},
+		authzs:   map[string]*acmeAuthz{},
+		certs:    map[string]*acmeCertificate{},
+		nonces:   map[string]time.Time{},
+		httpClient: &http.Client{
+			Timeout: acmeChallengeTimeout,
+		},
+		challengePort: 80,
+	}
+	if err := s.loadAccounts(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/* Persistence */
+
+func (s *acmeServer) accountsPath() string {
+	return filepath.Join(s.ca.Options.StoreFolder, "acme_accounts.json")
+}
+
+func (s *acmeServer) loadAccounts() error {
+	if !utils.FileExists(s.accountsPath()) {
+		return nil
+	}
+	content, err := os.ReadFile(s.accountsPath())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &s.accounts); err != nil {
+		return errors.New("malformed ACME account file: " + err.Error())
+	}
+	return nil
+}
+
+// saveAccounts writes the accounts to file, must be called with the mutex held
+func (s *acmeServer) saveAccounts() error {
+	js, _ := json.MarshalIndent(s.accounts, "", " ")
+	return os.WriteFile(s.accountsPath(), js, 0644)
+}
+
+// pruneExpired drops expired orders, authorizations and nonces
+func (s *acmeServer) pruneExpired() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for id, order := range s.orders {
+		if now.After(order.Expires) {
+			delete(s.orders, id)
+		}
+	}
+	for id, authz := range s.authzs {
+		if now.After(authz.Expires) {
+			delete(s.authzs, id)
+		}
+	}
+	for id, cert := range s.certs {
+		if now.After(cert.Expires) {
+			delete(s.certs, id)
+		}
+	}
+	for nonce, created := range s.nonces {
+		if now.Sub(created) > acmeNonceLifetime {
+			delete(s.nonces, nonce)
+		}
+	}
+}
+
+/* HTTP handling */
+
+func (s *acmeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, acmePathPrefix)
+	switch path {
+	case "directory":
+		s.handleDirectory(w, r)
+		return
+	case "new-nonce":
+		s.handleNewNonce(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.writeProblem(w, r, http.StatusMethodNotAllowed, "malformed", "method not allowed")
+		return
+	}
+
+	resource, id, _ := strings.Cut(path, "/")
+	switch resource {
+	case "new-account":
+		s.handleNewAccount(w, r)
+	case "acct":
+		s.handleAccount(w, r, id)
+	case "new-order":
+		s.handleNewOrder(w, r)
+	case "order":
+		s.handleOrder(w, r, id)
+	case "authz":
+		s.handleAuthz(w, r, id)
+	case "chall":
+		s.handleChallenge(w, r, id)
+	case "finalize":
+		s.handleFinalize(w, r, id)
+	case "cert":
+		s.handleCertificate(w, r, id)
+	case "revoke-cert":
+		s.handleRevoke(w, r)
+	default:
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "resource not found")
+	}
+}
+
+func (s *acmeServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"newNonce":   base + "new-nonce",
+		"newAccount": base + "new-account",
+		"newOrder":   base + "new-order",
+		"revokeCert": base + "revoke-cert",
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *acmeServer) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Link", "<"+s.baseURL(r)+"directory>;rel=\"index\"")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *acmeServer) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	if req.account != nil || req.jwk == nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "new account requests must be signed with a jwk")
+		return
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid account payload")
+		return
+	}
+	accountID, err := jwkThumbprint(req.jwk)
+	if err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid account key")
+		return
+	}
+
+	s.mutex.Lock()
+	account, exists := s.accounts[accountID]
+	if !exists && !payload.OnlyReturnExisting {
+		account = &acmeAccount{
+			ID:        accountID,
+			Key:       req.jwk,
+			Contact:   payload.Contact,
+			Status:    acmeStatusValid,
+			CreatedAt: time.Now().Unix(),
+		}
+		s.accounts[accountID] = account
+		if err := s.saveAccounts(); err != nil {
+			s.ca.Logf("Failed to save ACME accounts", err)
+		}
+	}
+	var response map[string]interface{}
+	if account != nil {
+		response = accountJSON(account)
+	}
+	s.mutex.Unlock()
+
+	if account == nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "accountDoesNotExist", "no account exists with the given key")
+		return
+	}
+	w.Header().Set("Location", s.baseURL(r)+"acct/"+accountID)
+	if exists {
+		s.writeJSON(w, r, http.StatusOK, response)
+		return
+	}
+	s.ca.Logf("Registered ACME account "+accountID, nil)
+	s.writeJSON(w, r, http.StatusCreated, response)
+}
+
+func (s *acmeServer) handleAccount(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	if req.account.ID != id {
+		s.writeProblem(w, r, http.StatusForbidden, "unauthorized", "account does not match the request key")
+		return
+	}
+	var payload struct {
+		Contact []string `json:"contact"`
+		Status  string   `json:"status"`
+	}
+	if len(req.payload) > 0 {
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid account payload")
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	if payload.Contact != nil {
+		req.account.Contact = payload.Contact
+	}
+	if payload.Status == acmeStatusDeactivated {
+		req.account.Status = acmeStatusDeactivated
+	}
+	if payload.Contact != nil || payload.Status != "" {
+		if err := s.saveAccounts(); err != nil {
+			s.ca.Logf("Failed to save ACME accounts", err)
+		}
+	}
+	response := accountJSON(req.account)
+	s.mutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, response)
+}
+
+func (s *acmeServer) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid order payload")
+		return
+	}
+	if len(payload.Identifiers) == 0 || len(payload.Identifiers) > acmeMaxIdentifiers {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "an order must contain 1 to "+strconv.Itoa(acmeMaxIdentifiers)+" identifiers")
+		return
+	}
+
+	identifiers := []acmeIdentifier{}
+	seen := map[string]bool{}
+	for _, identifier := range payload.Identifiers {
+		value := strings.ToLower(strings.TrimSpace(identifier.Value))
+		isIP := net.ParseIP(value) != nil
+		if (identifier.Type != "dns" || isIP) && (identifier.Type != "ip" || !isIP) {
+			s.writeProblem(w, r, http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier "+identifier.Type+":"+identifier.Value)
+			return
+		}
+		if !s.ca.IsAllowedHostname(value) {
+			s.writeProblem(w, r, http.StatusBadRequest, "rejectedIdentifier", value+" is not an internal name")
+			return
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		identifiers = append(identifiers, acmeIdentifier{Type: identifier.Type, Value: value})
+	}
+
+	expires := time.Now().Add(acmeOrderLifetime)
+	order := &acmeOrder{
+		ID:          randomID(),
+		AccountID:   req.account.ID,
+		Status:      acmeStatusPending,
+		Expires:     expires,
+		Identifiers: identifiers,
+		AuthzIDs:    []string{},
+	}
+	s.mutex.Lock()
+	for _, identifier := range identifiers {
+		authz := &acmeAuthz{
+			ID:         randomID(),
+			AccountID:  req.account.ID,
+			Identifier: identifier,
+			Status:     acmeStatusPending,
+			Expires:    expires,
+			Token:      randomID(),
+		}
+		s.authzs[authz.ID] = authz
+		order.AuthzIDs = append(order.AuthzIDs, authz.ID)
+	}
+	s.orders[order.ID] = order
+	response := s.orderJSON(r, order)
+	s.mutex.Unlock()
+
+	w.Header().Set("Location", s.baseURL(r)+"order/"+order.ID)
+	s.writeJSON(w, r, http.StatusCreated, response)
+}
+
+func (s *acmeServer) handleOrder(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	s.mutex.Lock()
+	order, ok := s.orders[id]
+	if !ok || order.AccountID != req.account.ID {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	response := s.orderJSON(r, order)
+	s.mutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, response)
+}
+
+func (s *acmeServer) handleAuthz(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if len(req.payload) > 0 {
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid authorization payload")
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	authz, ok := s.authzs[id]
+	if !ok || authz.AccountID != req.account.ID {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "authorization not found")
+		return
+	}
+	if payload.Status == acmeStatusDeactivated {
+		authz.Status = acmeStatusDeactivated
+	}
+	response := s.authzJSON(r, authz)
+	s.mutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, response)
+}
+
+func (s *acmeServer) handleChallenge(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+
+	s.mutex.Lock()
+	authz, ok := s.authzs[id]
+	if !ok || authz.AccountID != req.account.ID {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "challenge not found")
+		return
+	}
+	//A POST with a payload starts the validation, POST-as-GET only reads the state
+	startValidation := len(req.payload) > 0 && authz.Status == acmeStatusPending
+	if startValidation {
+		authz.Status = acmeStatusProcessing
+	}
+	identifier := authz.Identifier.Value
+	token := authz.Token
+	s.mutex.Unlock()
+
+	if startValidation {
+		keyAuthorization := token + "." + req.account.ID
+		err := s.validateHTTP01(identifier, token, keyAuthorization)
+		s.mutex.Lock()
+		if err != nil {
+			authz.Status = acmeStatusInvalid
+			authz.Error = &acmeProblem{
+				Type:   "urn:ietf:params:acme:error:incorrectResponse",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			s.ca.Logf("ACME http-01 challenge for "+identifier+" failed", err)
+		} else {
+			authz.Status = acmeStatusValid
+			authz.Validated = time.Now()
+		}
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	response := s.challengeJSON(r, authz)
+	s.mutex.Unlock()
+	w.Header().Add("Link", "<"+s.baseURL(r)+"authz/"+id+">;rel=\"up\"")
+	s.writeJSON(w, r, http.StatusOK, response)
+}
+
+// validateHTTP01 fetches the key authorization from the identifier over plain HTTP
+func (s *acmeServer) validateHTTP01(identifier string, token string, keyAuthorization string) error {
+	host := identifier
+	if s.challengePort != 80 {
+		host = net.JoinHostPort(identifier, strconv.Itoa(s.challengePort))
+	} else if strings.Contains(identifier, ":") {
+		host = "[" + identifier + "]"
+	}
+	resp, err := s.httpClient.Get("http://" + host + "/.well-known/acme-challenge/" + token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge responder returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, acmeMaxChallengeBytes))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return errors.New("key authorization does not match")
+	}
+	return nil
+}
+
+func (s *acmeServer) handleFinalize(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid finalize payload")
+		return
+	}
+	csrDER, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "badCSR", "invalid CSR encoding")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "badCSR", "invalid CSR: "+err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	order, ok := s.orders[id]
+	if !ok || order.AccountID != req.account.ID {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	if s.currentOrderStatus(order) != acmeStatusReady {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusForbidden, "orderNotReady", "order is not ready for finalization")
+		return
+	}
+	names := []string{}
+	for _, identifier := range order.Identifiers {
+		names = append(names, identifier.Value)
+	}
+	if !csrMatchesNames(csr, names) {
+		s.mutex.Unlock()
+		s.writeProblem(w, r, http.StatusBadRequest, "badCSR", "CSR names do not match the order identifiers")
+		return
+	}
+	order.Status = acmeStatusProcessing
+	s.mutex.Unlock()
+
+	cert, err := s.ca.signCertificate(csr.PublicKey, names, &IssuedCertificate{
+		Source:    SourceACME,
+		AccountID: req.account.ID,
+	})
+
+	s.mutex.Lock()
+	if err != nil {
+		order.Status = acmeStatusInvalid
+		order.Error = &acmeProblem{
+			Type:   "urn:ietf:params:acme:error:serverInternal",
+			Detail: "failed to sign certificate",
+			Status: http.StatusInternalServerError,
+		}
+		s.ca.Logf("Failed to sign certificate for ACME order "+order.ID, err)
+	} else {
+		order.Status = acmeStatusValid
+		order.CertID = serialString(cert.SerialNumber)
+		s.certs[order.CertID] = &acmeCertificate{
+			AccountID: req.account.ID,
+			Chain:     s.ca.chainPEM(cert),
+			Expires:   order.Expires,
+		}
+		s.ca.Logf("Issued certificate for ACME order "+order.ID+" ("+strings.Join(names, ", ")+")", nil)
+	}
+	response := s.orderJSON(r, order)
+	s.mutex.Unlock()
+
+	w.Header().Set("Location", s.baseURL(r)+"order/"+order.ID)
+	s.writeJSON(w, r, http.StatusOK, response)
+}
+
+func (s *acmeServer) handleCertificate(w http.ResponseWriter, r *http.Request, id string) {
+	req, problem := s.parseAccountRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	s.mutex.Lock()
+	cert, ok := s.certs[id]
+	s.mutex.Unlock()
+	if !ok || cert.AccountID != req.account.ID {
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "certificate not found")
+		return
+	}
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cert.Chain)
+}
+
+func (s *acmeServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r)
+	if problem != nil {
+		s.sendProblem(w, r, problem)
+		return
+	}
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid revocation payload")
+		return
+	}
+	certDER, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid certificate encoding")
+		return
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "malformed", "invalid certificate")
+		return
+	}
+
+	serial := serialString(cert.SerialNumber)
+	s.ca.mutex.RLock()
+	record, issued := s.ca.state.Issued[serial]
+	var accountID string
+	var revoked bool
+	if issued {
+		accountID, revoked = record.AccountID, record.Revoked
+	}
+	signatureErr := cert.CheckSignatureFrom(s.ca.intermediate)
+	s.ca.mutex.RUnlock()
+	if !issued || signatureErr != nil {
+		s.writeProblem(w, r, http.StatusNotFound, "malformed", "certificate not issued by this CA")
+		return
+	}
+
+	//Either the ordering account or the holder of the certificate key may revoke
+	authorized := false
+	if req.account != nil {
+		authorized = req.account.ID == accountID
+	} else if req.jwk != nil {
+		certKey, err1 := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		requestKey, err2 := x509.MarshalPKIXPublicKey(req.jwk.Key)
+		authorized = err1 == nil && err2 == nil && bytes.Equal(certKey, requestKey)
+	}
+	if !authorized {
+		s.writeProblem(w, r, http.StatusForbidden, "unauthorized", "not authorized to revoke this certificate")
+		return
+	}
+	if revoked {
+		s.writeProblem(w, r, http.StatusBadRequest, "alreadyRevoked", "certificate already revoked")
+		return
+	}
+	if err := s.ca.Revoke(serial, payload.Reason); err != nil {
+		s.writeProblem(w, r, http.StatusBadRequest, "badRevocationReason", err.Error())
+		return
+	}
+	s.setCommonHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+/* JWS verification */
+
+// parseAccountRequest verifies a request signed by the key of an existing account
+func (s *acmeServer) parseAccountRequest(r *http.Request) (*acmeRequest, *acmeProblem) {
+	req, problem := s.parseRequest(r)
+	if problem != nil {
+		return nil, problem
+	}
+	if req.account == nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be signed with the account kid")
+	}
+	return req, nil
+}
+
+// parseRequest verifies the JWS body, nonce and url of a POST request
+func (s *acmeServer) parseRequest(r *http.Request) (*acmeRequest, *acmeProblem) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/jose+json") {
+		return nil, newACMEProblem(http.StatusUnsupportedMediaType, "malformed", "content type must be application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, acmeMaxBodySize+1))
+	if err != nil || len(body) > acmeMaxBodySize {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request body too large")
+	}
+	jws, err := jose.ParseSignedJSON(string(body), acmeSignatureAlgorithms)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "invalid JWS: "+err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "JWS must have exactly one signature")
+	}
+	header := jws.Signatures[0].Protected
+
+	if !s.consumeNonce(header.Nonce) {
+		return nil, newACMEProblem(http.StatusBadRequest, "badNonce", "invalid or reused nonce")
+	}
+	url, _ := header.ExtraHeaders["url"].(string)
+	if url != s.baseURL(r)+strings.TrimPrefix(r.URL.Path, acmePathPrefix) {
+		return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "url header does not match the request")
+	}
+
+	req := &acmeRequest{}
+	switch {
+	case header.KeyID != "" && header.JSONWebKey == nil:
+		accountID := strings.TrimPrefix(header.KeyID, s.baseURL(r)+"acct/")
+		s.mutex.Lock()
+		account, ok := s.accounts[accountID]
+		s.mutex.Unlock()
+		if !ok || accountID == header.KeyID {
+			return nil, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+		}
+		if account.Status != acmeStatusValid {
+			return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "account is "+account.Status)
+		}
+		req.account = account
+		req.jwk = account.Key
+	case header.KeyID == "" && header.JSONWebKey != nil:
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "invalid jwk")
+		}
+		req.jwk = header.JSONWebKey
+	default:
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "exactly one of kid and jwk must be set")
+	}
+
+	payload, err := jws.Verify(req.jwk)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "JWS signature is invalid")
+	}
+	req.payload = payload
+	return req, nil
+}
+
+func (s *acmeServer) newNonce() string {
+	nonce := randomID()
+	s.mutex.Lock()
+	if len(s.nonces) >= acmeMaxNonces {
+		//Under a flood of nonce requests drop all outstanding ones, clients retry on badNonce
+		s.nonces = map[string]time.Time{}
+	}
+	s.nonces[nonce] = time.Now()
+	s.mutex.Unlock()
+	return nonce
+}
+
+func (s *acmeServer) consumeNonce(nonce string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	created, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return time.Since(created) <= acmeNonceLifetime
+}
+
+/* Responses */
+
+// baseURL returns the URL of the ACME server as seen by the client
+func (s *acmeServer) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + acmePathPrefix
+}
+
+func (s *acmeServer) setCommonHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", "<"+s.baseURL(r)+"directory>;rel=\"index\"")
+}
+
+func (s *acmeServer) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	js, _ := json.Marshal(v)
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+func (s *acmeServer) writeProblem(w http.ResponseWriter, r *http.Request, status int, errorType string, detail string) {
+	s.sendProblem(w, r, newACMEProblem(status, errorType, detail))
+}
+
+func (s *acmeServer) sendProblem(w http.ResponseWriter, r *http.Request, problem *acmeProblem) {
+	js, _ := json.Marshal(problem)
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(js)
+}
+
+func newACMEProblem(status int, errorType string, detail string) *acmeProblem {
+	return &acmeProblem{
+		Type:   "urn:ietf:params:acme:error:" + errorType,
+		Detail: detail,
+		Status: status,
+	}
+}
+
+func accountJSON(account *acmeAccount) map[string]interface{} {
+	contact := account.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+	return map[string]interface{}{
+		"status":  account.Status,
+		"contact": contact,
+	}
+}
+
+// currentOrderStatus derives the status of a pending order from its
+// authorizations, must be called with the mutex held
+func (s *acmeServer) currentOrderStatus(order *acmeOrder) string {
+	if order.Status != acmeStatusPending && order.Status != acmeStatusReady {
+		return order.Status
+	}
+	if time.Now().After(order.Expires) {
+		return acmeStatusInvalid
+	}
+	status := acmeStatusReady
+	for _, authzID := range order.AuthzIDs {
+		authz, ok := s.authzs[authzID]
+		if !ok || authz.Status == acmeStatusInvalid || authz.Status == acmeStatusDeactivated {
+			return acmeStatusInvalid
+		}
+		if authz.Status != acmeStatusValid {
+			status = acmeStatusPending
+		}
+	}
+	order.Status = status
+	return status
+}
+
+func (s *acmeServer) orderJSON(r *http.Request, order *acmeOrder) map[string]interface{} {
+	base := s.baseURL(r)
+	authorizations := []string{}
+	for _, authzID := range order.AuthzIDs {
+		authorizations = append(authorizations, base+"authz/"+authzID)
+	}
+	response := map[string]interface{}{
+		"status":         s.currentOrderStatus(order),
+		"expires":        order.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       base + "finalize/" + order.ID,
+	}
+	if order.CertID != "" {
+		response["certificate"] = base + "cert/" + order.CertID
+	}
+	if order.Error != nil {
+		response["error"] = order.Error
+	}
+	return response
+}
+
+func (s *acmeServer) authzJSON(r *http.Request, authz *acmeAuthz) map[string]interface{} {
+	status := authz.Status
+	if status == acmeStatusProcessing {
+		//Authorizations have no processing state, only their challenge does
+		status = acmeStatusPending
+	}
+	return map[string]interface{}{
+		"status":     status,
+		"expires":    authz.Expires.UTC().Format(time.RFC3339),
+		"identifier": authz.Identifier,
+		"challenges": []interface{}{s.challengeJSON(r, authz)},
+	}
+}
+
+func (s *acmeServer) challengeJSON(r *http.Request, authz *acmeAuthz) map[string]interface{} {
+	status := authz.Status
+	if status == acmeStatusDeactivated {
+		status = acmeStatusInvalid
+	}
+	challenge := map[string]interface{}{
+		"type":   "http-01",
+		"url":    s.baseURL(r) + "chall/" + authz.ID,
+		"token":  authz.Token,
+		"status": status,
+	}
+	if !authz.Validated.IsZero() {
+		challenge["validated"] = authz.Validated.UTC().Format(time.RFC3339)
+	}
+	if authz.Error != nil {
+		challenge["error"] = authz.Error
+	}
+	return challenge
+}
+
+/* Helpers */
+
+func randomID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func jwkThumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// csrMatchesNames checks the CSR requests exactly the given names
+func csrMatchesNames(csr *x509.CertificateRequest, names []string) bool {
+	requested := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		requested[ip.String()] = true
+	}
+	if csr.Subject.CommonName != "" {
+		requested[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+	expected := map[string]bool{}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			name = ip.String()
+		}
+		expected[name] = true
+	}
+	if len(requested) != len(expected) {
+		return false
+	}
+	for name := range requested {
+		if !expected[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// listAccounts returns the registered ACME accounts for the management UI
+func (s *acmeServer) listAccounts() []*acmeAccount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	results := []*acmeAccount{}
+	for _, account := range s.accounts {
+		copied := *account
+		results = append(results, &copied)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt < results[j].CreatedAt
+	})
+	return results
+}
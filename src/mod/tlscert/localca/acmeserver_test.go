@@ -0,0 +1,142 @@
+package localca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-acme/lego/v5/acme"
+	"github.com/go-acme/lego/v5/certcrypto"
+	"github.com/go-acme/lego/v5/certificate"
+	"github.com/go-acme/lego/v5/lego"
+	"github.com/go-acme/lego/v5/registration"
+)
+
+type testACMEUser struct {
+	key          crypto.Signer
+	registration *acme.ExtendedAccount
+}
+
+func (u *testACMEUser) GetEmail() string                       { return "" }
+func (u *testACMEUser) GetRegistration() *acme.ExtendedAccount { return u.registration }
+func (u *testACMEUser) GetPrivateKey() crypto.Signer           { return u.key }
+
+// testHTTP01Provider serves key authorizations from a local HTTP server
+type testHTTP01Provider struct {
+	tokens sync.Map
+}
+
+func (p *testHTTP01Provider) Present(ctx context.Context, domain, token, keyAuth string) error {
+	p.tokens.Store(token, keyAuth)
+	return nil
+}
+
+func (p *testHTTP01Provider) CleanUp(ctx context.Context, domain, token, keyAuth string) error {
+	p.tokens.Delete(token)
+	return nil
+}
+
+func (p *testHTTP01Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keyAuth, ok := p.tokens.Load(strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(keyAuth.(string)))
+}
+
+func TestACMEServerIssueAndRevoke(t *testing.T) {
+	ca := newTestCA(t)
+	config := ca.GetConfig()
+	config.ACMEEnabled = true
+	if err := ca.UpdateConfig(config); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	//Route every http-01 validation to the test challenge responder
+	provider := &testHTTP01Provider{}
+	challengeServer := httptest.NewServer(provider)
+	defer challengeServer.Close()
+	ca.acme.httpClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, challengeServer.Listener.Addr().String())
+			},
+		},
+	}
+
+	acmeServer := httptest.NewTLSServer(ca)
+	defer acmeServer.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	user := &testACMEUser{key: key}
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = acmeServer.URL + acmePathPrefix + "directory"
+	legoConfig.HTTPClient = acmeServer.Client()
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		t.Fatalf("lego.NewClient: %v", err)
+	}
+	if err := client.Challenge.SetHTTP01Provider(provider); err != nil {
+		t.Fatalf("SetHTTP01Provider: %v", err)
+	}
+	ctx := context.Background()
+	user.registration, err = client.Registration.Register(ctx, registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	//Public names are rejected
+	if _, err := client.Certificate.Obtain(ctx, certificate.ObtainRequest{Domains: []string{"example.com"}, KeyType: certcrypto.EC256}); err == nil {
+		t.Fatalf("expected order for a public name to be rejected")
+	}
+
+	resource, err := client.Certificate.Obtain(ctx, certificate.ObtainRequest{
+		Domains: []string{"grafana.lan", "grafana"},
+		Bundle:  true,
+		KeyType: certcrypto.EC256,
+	})
+	if err != nil {
+		t.Fatalf("Obtain: %v", err)
+	}
+
+	block, rest := pem.Decode(resource.Certificate)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse issued certificate: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(rest)
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       "grafana",
+		Roots:         ca.GetRootPool(),
+		Intermediates: intermediates,
+	}); err != nil {
+		t.Fatalf("issued certificate does not chain to the root: %v", err)
+	}
+
+	issued := ca.ListIssued()
+	if len(issued) != 1 || issued[0].Source != SourceACME || issued[0].AccountID == "" || issued[0].CertName != "" {
+		t.Fatalf("unexpected issued record %+v", issued)
+	}
+
+	if err := client.Certificate.Revoke(ctx, resource.Certificate); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if !ca.IsRevoked(serialString(leaf.SerialNumber)) {
+		t.Fatalf("certificate not revoked")
+	}
+	if err := client.Certificate.Revoke(ctx, resource.Certificate); err == nil {
+		t.Fatalf("expected second revocation to fail")
+	}
+}
@@ -0,0 +1,123 @@
+package localca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+/*
+	crl.go
+
+	Certificate revocation. The CRL is signed by the intermediate and
+	published under PublicPathPrefix, issued certificates point to it
+	when a public URL is configured.
+*/
+
+const (
+	crlValidity       = 7 * 24 * time.Hour
+	crlRefreshAfter   = crlValidity / 2
+	crlPublishedPath  = "crl"
+	maxRevokeReasonID = 10
+)
+
+// crlURL returns the CRL distribution point, must be called with the mutex held
+func (ca *CA) crlURL() string {
+	if ca.Config.PublicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(ca.Config.PublicURL, "/") + PublicPathPrefix + crlPublishedPath
+}
+
+// Revoke revokes an issued certificate by serial number (hex) with an RFC 5280 reason code
+func (ca *CA) Revoke(serial string, reason int) error {
+	if reason < 0 || reason > maxRevokeReasonID || reason == 7 {
+		return errors.New("invalid revocation reason")
+	}
+	serial = strings.ToLower(strings.TrimSpace(serial))
+	ca.mutex.Lock()
+	record, ok := ca.state.Issued[serial]
+	if !ok {
+		ca.mutex.Unlock()
+		return errors.New("certificate not issued by this CA")
+	}
+	if record.Revoked {
+		ca.mutex.Unlock()
+		return errors.New("certificate already revoked")
+	}
+	record.Revoked = true
+	record.RevokedAt = time.Now().Unix()
+	record.RevocationReason = reason
+	err := ca.saveState()
+	ca.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	ca.Logf("Revoked certificate "+serial+" ("+record.CommonName+")", nil)
+	return ca.regenerateCRL()
+}
+
+// IsRevoked checks if a certificate issued by the CA has been revoked
+func (ca *CA) IsRevoked(serial string) bool {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	record, ok := ca.state.Issued[serial]
+	return ok && record.Revoked
+}
+
+// regenerateCRL signs a new CRL with all revoked certificates that are not expired yet
+func (ca *CA) regenerateCRL() error {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	now := time.Now()
+	entries := []x509.RevocationListEntry{}
+	for serial, record := range ca.state.Issued {
+		if !record.Revoked || now.Unix() > record.NotAfter {
+			continue
+		}
+		serialNumber, ok := new(big.Int).SetString(serial, 16)
+		if !ok {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serialNumber,
+			RevocationTime: time.Unix(record.RevokedAt, 0),
+			ReasonCode:     record.RevocationReason,
+		})
+	}
+
+	ca.state.CRLNumber++
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(ca.state.CRLNumber),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, ca.intermediate, ca.intermediateKey)
+	if err != nil {
+		return err
+	}
+	ca.crl = crl
+	ca.crlThisUpdate = now
+	return ca.saveState()
+}
+
+// GetCRL returns the DER encoded CRL, signing a new one when the current is half way to expiry
+func (ca *CA) GetCRL() ([]byte, error) {
+	ca.mutex.RLock()
+	crl := ca.crl
+	stale := time.Since(ca.crlThisUpdate) > crlRefreshAfter
+	ca.mutex.RUnlock()
+	if stale {
+		if err := ca.regenerateCRL(); err != nil {
+			return crl, err
+		}
+		ca.mutex.RLock()
+		crl = ca.crl
+		ca.mutex.RUnlock()
+	}
+	return crl, nil
+}
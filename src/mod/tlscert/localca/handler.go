@@ -0,0 +1,276 @@
+package localca
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	handler.go
+
+	Public endpoints (root export, CRL and ACME server) and the
+	management API of the local CA
+*/
+
+// ShouldServe checks if the request is for the public endpoints of the CA
+func (ca *CA) ShouldServe(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, PublicPathPrefix)
+}
+
+// ServeHTTP serves the public endpoints under PublicPathPrefix without authentication
+func (ca *CA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, PublicPathPrefix)
+	if strings.HasPrefix(path, "acme/") {
+		if !ca.GetConfig().ACMEEnabled {
+			http.NotFound(w, r)
+			return
+		}
+		ca.acme.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch path {
+	case "root.pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"zoraxy-root-ca.pem\"")
+		w.Write(ca.GetRootPEM())
+	case "root.crt":
+		//DER encoding for Windows and Android certificate installers
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"zoraxy-root-ca.crt\"")
+		w.Write(ca.GetRootDER())
+	case "intermediate.pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(ca.GetIntermediatePEM())
+	case crlPublishedPath:
+		crl, err := ca.GetCRL()
+		if crl == nil {
+			ca.Logf("Failed to serve CRL", err)
+			http.Error(w, "CRL not available", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write(crl)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+/* Management API */
+
+// HandleStatus returns the root, intermediate and issuance summary of the CA
+func (ca *CA) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	type certSummary struct {
+		Subject     string
+		Fingerprint string
+		NotBefore   int64
+		NotAfter    int64
+	}
+	type status struct {
+		Root             certSummary
+		Intermediate     certSummary
+		Config           *Config
+		IssuedCount      int
+		RevokedCount     int
+		ACMEAccountCount int
+		ACMEDirectory    string //Path of the ACME directory, relative to the public URL
+		RootDownloadPath string
+		CRLPath          string
+	}
+
+	ca.mutex.RLock()
+	results := status{
+		Root: certSummary{
+			Subject:     ca.root.Subject.CommonName,
+			Fingerprint: Fingerprint(ca.root),
+			NotBefore:   ca.root.NotBefore.Unix(),
+			NotAfter:    ca.root.NotAfter.Unix(),
+		},
+		Intermediate: certSummary{
+			Subject:     ca.intermediate.Subject.CommonName,
+			Fingerprint: Fingerprint(ca.intermediate),
+			NotBefore:   ca.intermediate.NotBefore.Unix(),
+			NotAfter:    ca.intermediate.NotAfter.Unix(),
+		},
+		ACMEDirectory:    acmePathPrefix + "directory",
+		RootDownloadPath: PublicPathPrefix + "root.pem",
+		CRLPath:          PublicPathPrefix + crlPublishedPath,
+	}
+	for _, record := range ca.state.Issued {
+		results.IssuedCount++
+		if record.Revoked {
+			results.RevokedCount++
+		}
+	}
+	ca.mutex.RUnlock()
+	results.Config = ca.GetConfig()
+	results.ACMEAccountCount = len(ca.acme.listAccounts())
+
+	js, _ := json.Marshal(results)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleConfig gets (GET) or updates (POST) the CA config
+func (ca *CA) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		js, _ := json.Marshal(ca.GetConfig())
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := ca.GetConfig()
+	if autoIssue, err := utils.PostBool(r, "autoIssue"); err == nil {
+		config.AutoIssue = autoIssue
+	}
+	if acmeEnabled, err := utils.PostBool(r, "acme"); err == nil {
+		config.ACMEEnabled = acmeEnabled
+	}
+	if publicURL, err := utils.PostPara(r, "publicUrl"); err == nil {
+		publicURL = strings.TrimSuffix(strings.TrimSpace(publicURL), "/")
+		if publicURL != "" && !strings.HasPrefix(publicURL, "http://") && !strings.HasPrefix(publicURL, "https://") {
+			utils.SendErrorResponse(w, "public URL must start with http:// or https://")
+			return
+		}
+		config.PublicURL = publicURL
+	}
+	if validityDays, err := utils.PostPara(r, "validityDays"); err == nil {
+		days, err := strconv.Atoi(validityDays)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid validity days given")
+			return
+		}
+		config.ValidityDays = days
+	}
+	if suffixes, err := utils.PostPara(r, "suffixes"); err == nil {
+		config.InternalSuffixes = []string{}
+		for _, suffix := range strings.Split(suffixes, ",") {
+			suffix = strings.ToLower(strings.TrimSpace(suffix))
+			if suffix == "" {
+				continue
+			}
+			if !strings.HasPrefix(suffix, ".") || strings.Count(suffix, ".") < 2 {
+				//Suffixes must be below a domain, a bare TLD would allow issuing for public names
+				utils.SendErrorResponse(w, "invalid suffix "+suffix+", use a domain like .example.com")
+				return
+			}
+			config.InternalSuffixes = append(config.InternalSuffixes, suffix)
+		}
+	}
+
+	if err := ca.UpdateConfig(config); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleListIssued lists the certificates issued by the CA
+func (ca *CA) HandleListIssued(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(ca.ListIssued())
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleRevoke revokes an issued certificate by serial number
+func (ca *CA) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	serial, err := utils.PostPara(r, "serial")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid serial given")
+		return
+	}
+	reason := 0
+	if reasonString, err := utils.PostPara(r, "reason"); err == nil {
+		reason, err = strconv.Atoi(reasonString)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid reason given")
+			return
+		}
+	}
+	if err := ca.Revoke(serial, reason); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleIssue issues a certificate for internal names into the cert store
+func (ca *CA) HandleIssue(w http.ResponseWriter, r *http.Request) {
+	domains, err := utils.PostPara(r, "domains")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid domains given")
+		return
+	}
+	names := []string{}
+	for _, domain := range strings.Split(domains, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if !ca.IsAllowedHostname(domain) {
+			utils.SendErrorResponse(w, domain+" is not an internal name")
+			return
+		}
+		names = append(names, domain)
+	}
+	if len(names) == 0 {
+		utils.SendErrorResponse(w, "invalid domains given")
+		return
+	}
+
+	certName, err := utils.PostPara(r, "name")
+	if err != nil {
+		certName = names[0]
+	}
+	if err := ca.IssueToCertStore(names, certName, SourceManual); err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// HandleListACMEAccounts lists the accounts registered on the ACME server
+func (ca *CA) HandleListACMEAccounts(w http.ResponseWriter, r *http.Request) {
+	type accountSummary struct {
+		ID        string
+		Contact   []string
+		Status    string
+		CreatedAt int64
+	}
+	results := []accountSummary{}
+	for _, account := range ca.acme.listAccounts() {
+		results = append(results, accountSummary{
+			ID:        account.ID,
+			Contact:   account.Contact,
+			Status:    account.Status,
+			CreatedAt: account.CreatedAt,
+		})
+	}
+	js, _ := json.Marshal(results)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// HandleRootDownload exports the root certificate for client trust stores
+func (ca *CA) HandleRootDownload(w http.ResponseWriter, r *http.Request) {
+	format, _ := utils.GetPara(r, "format")
+	if format == "der" {
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"zoraxy-root-ca.crt\"")
+		w.Write(ca.GetRootDER())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"zoraxy-root-ca-"+time.Now().Format("20060102")+".pem\"")
+	w.Write(ca.GetRootPEM())
+}
@@ -0,0 +1,57 @@
+package localca
+
+import (
+	"net"
+	"strings"
+)
+
+/*
+	internal.go
+
+	Decide which names the local CA issues certificates for. Public
+	names are left to public CAs, so a leaked CA key can not be used to
+	impersonate public sites trusted by the same clients.
+*/
+
+// Suffixes reserved or commonly used for private networks
+var defaultInternalSuffixes = []string{
+	".internal",
+	".local",
+	".lan",
+	".home.arpa",
+	".home",
+	".intranet",
+	".corp",
+	".private",
+	".localdomain",
+	".test",
+}
+
+// IsInternalHostname checks if the hostname is a single label name, a
+// private IP address or ends with one of the internal suffixes
+func IsInternalHostname(hostname string, extraSuffixes []string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+	if hostname == "" || len(hostname) > 253 || strings.ContainsAny(hostname, "*/\\ ?[") {
+		return false
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+	}
+	if !strings.Contains(hostname, ".") {
+		//Single label names like "nas" only resolve on the local network
+		return hostname != "localhost"
+	}
+	for _, suffix := range append(defaultInternalSuffixes, extraSuffixes...) {
+		suffix = strings.ToLower(strings.TrimSpace(suffix))
+		if suffix == "" {
+			continue
+		}
+		if !strings.HasPrefix(suffix, ".") {
+			suffix = "." + suffix
+		}
+		if strings.HasSuffix(hostname, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package localca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	issue.go
+
+	Leaf certificate issuance. Every certificate signed by the CA is
+	recorded so it can be revoked and, if it was written into the cert
+	store, renewed before it expires.
+*/
+
+// Sources of issued certificates
+const (
+	SourceAuto   = "auto"   //Issued on first handshake of an internal proxy endpoint
+	SourceManual = "manual" //Issued through the management API
+	SourceACME   = "acme"   //Issued through the ACME server
+)
+
+const issuedRecordRetention = 30 * 24 * time.Hour //Keep records of expired certificates for this long
+
+// IssuedCertificate is the record of a certificate signed by the CA
+type IssuedCertificate struct {
+	SerialNumber     string
+	CommonName       string
+	DNSNames         []string
+	IPAddresses      []string
+	NotBefore        int64
+	NotAfter         int64
+	Source           string
+	CertName         string //Name in the cert store, empty if the certificate is not stored by Zoraxy
+	AccountID        string //ACME account that ordered the certificate
+	Revoked          bool
+	RevokedAt        int64
+	RevocationReason int
+}
+
+type caState struct {
+	CRLNumber int64
+	Issued    map[string]*IssuedCertificate //Serial number in hex to record
+}
+
+func (ca *CA) statePath() string {
+	return filepath.Join(ca.Options.StoreFolder, "issued.json")
+}
+
+func (ca *CA) loadState() error {
+	ca.state = &caState{Issued: map[string]*IssuedCertificate{}}
+	if !utils.FileExists(ca.statePath()) {
+		return nil
+	}
+	content, err := os.ReadFile(ca.statePath())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, ca.state); err != nil {
+		return errors.New("malformed local CA state: " + err.Error())
+	}
+	if ca.state.Issued == nil {
+		ca.state.Issued = map[string]*IssuedCertificate{}
+	}
+	return nil
+}
+
+// saveState writes the issued certificates to file, must be called with the mutex held
+func (ca *CA) saveState() error {
+	//Drop the records of long expired certificates
+	for serial, record := range ca.state.Issued {
+		if time.Since(time.Unix(record.NotAfter, 0)) > issuedRecordRetention {
+			delete(ca.state.Issued, serial)
+		}
+	}
+	js, _ := json.MarshalIndent(ca.state, "", " ")
+	return os.WriteFile(ca.statePath(), js, 0644)
+}
+
+// signCertificate signs a server certificate for the public key with the intermediate
+func (ca *CA) signCertificate(publicKey crypto.PublicKey, names []string, record *IssuedCertificate) (*x509.Certificate, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no name given")
+	}
+
+	dnsNames := []string{}
+	ipAddresses := []net.IP{}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			dnsNames = append(dnsNames, strings.ToLower(name))
+		}
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	now := time.Now()
+	notAfter := now.Add(time.Duration(ca.Config.ValidityDays) * 24 * time.Hour)
+	if notAfter.After(ca.intermediate.NotAfter) {
+		notAfter = ca.intermediate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: names[0]},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+	}
+	if _, ok := publicKey.(*ecdsa.PublicKey); !ok {
+		//RSA key exchange needs key encipherment
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if crlURL := ca.crlURL(); crlURL != "" {
+		template.CRLDistributionPoints = []string{crlURL}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca.intermediate, publicKey, ca.intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	record.SerialNumber = serialString(cert.SerialNumber)
+	record.CommonName = cert.Subject.CommonName
+	record.DNSNames = cert.DNSNames
+	record.IPAddresses = []string{}
+	for _, ip := range cert.IPAddresses {
+		record.IPAddresses = append(record.IPAddresses, ip.String())
+	}
+	record.NotBefore = cert.NotBefore.Unix()
+	record.NotAfter = cert.NotAfter.Unix()
+	ca.state.Issued[record.SerialNumber] = record
+	if err := ca.saveState(); err != nil {
+		ca.Logf("Failed to save issued certificate record", err)
+	}
+	return cert, nil
+}
+
+// chainPEM returns the PEM encoded certificate followed by the intermediate
+func (ca *CA) chainPEM(cert *x509.Certificate) []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.intermediate.Raw})...)
+}
+
+// IssueToCertStore issues a certificate for the names and writes it into the
+// cert store as <certName>.pem and <certName>.key
+func (ca *CA) IssueToCertStore(names []string, certName string, source string) error {
+	if certName == "" || certName != filepath.Base(certName) || strings.HasPrefix(certName, ".") {
+		return errors.New("invalid certificate name")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	cert, err := ca.signCertificate(key.Public(), names, &IssuedCertificate{Source: source, CertName: certName})
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	//Write the key first so the cert store watcher never loads a new certificate with the old key
+	keyPath := filepath.Join(ca.Options.CertStore, certName+".key")
+	if err := secrets.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ca.Options.CertStore, certName+".pem"), ca.chainPEM(cert), 0644); err != nil {
+		return err
+	}
+	ca.Logf("Issued certificate "+certName+" for "+strings.Join(names, ", "), nil)
+	return nil
+}
+
+// IssueForHostname issues a certificate for an internal proxy endpoint
+// hostname into the cert store
+func (ca *CA) IssueForHostname(hostname string) error {
+	hostname = strings.ToLower(hostname)
+	if !ca.IsAllowedHostname(hostname) {
+		return errors.New("hostname is not an internal name")
+	}
+	return ca.IssueToCertStore([]string{hostname}, hostname, SourceAuto)
+}
+
+// IsAllowedHostname checks if the CA may issue a certificate for the hostname
+func (ca *CA) IsAllowedHostname(hostname string) bool {
+	ca.mutex.RLock()
+	suffixes := ca.Config.InternalSuffixes
+	ca.mutex.RUnlock()
+	return IsInternalHostname(hostname, suffixes)
+}
+
+// AutoIssueEnabled checks if certificates are issued for internal proxy endpoints
+func (ca *CA) AutoIssueEnabled() bool {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	return ca.Config.AutoIssue
+}
+
+// renewIssuedCertificates reissues certificates in the cert store that
+// passed two thirds of their validity
+func (ca *CA) renewIssuedCertificates() {
+	now := time.Now()
+	due := []*IssuedCertificate{}
+	ca.mutex.RLock()
+	for _, record := range ca.state.Issued {
+		if record.CertName == "" || record.Revoked {
+			continue
+		}
+		lifetime := record.NotAfter - record.NotBefore
+		if now.Unix() < record.NotBefore+lifetime*2/3 {
+			continue
+		}
+		due = append(due, record)
+	}
+	ca.mutex.RUnlock()
+
+	for _, record := range due {
+		//Skip certificates that got replaced or removed from the cert store
+		if !ca.certStoreHasSerial(record.CertName, record.SerialNumber) {
+			ca.mutex.Lock()
+			record.CertName = ""
+			ca.saveState()
+			ca.mutex.Unlock()
+			continue
+		}
+		names := append(append([]string{}, record.DNSNames...), record.IPAddresses...)
+		if err := ca.IssueToCertStore(names, record.CertName, record.Source); err != nil {
+			ca.Logf("Failed to renew certificate "+record.CertName, err)
+			continue
+		}
+		ca.mutex.Lock()
+		record.CertName = ""
+		ca.saveState()
+		ca.mutex.Unlock()
+	}
+}
+
+// certStoreHasSerial checks if the certificate in the cert store is the issued one
+func (ca *CA) certStoreHasSerial(certName string, serial string) bool {
+	content, err := os.ReadFile(filepath.Join(ca.Options.CertStore, certName+".pem"))
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return serialString(cert.SerialNumber) == serial
+}
+
+// ListIssued returns the records of the certificates issued by the CA, newest first
+func (ca *CA) ListIssued() []*IssuedCertificate {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	results := []*IssuedCertificate{}
+	for _, record := range ca.state.Issued {
+		copied := *record
+		results = append(results, &copied)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].NotBefore > results[j].NotBefore
+	})
+	return results
+}
@@ -0,0 +1,396 @@
+package localca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	localca.go
+
+	Built-in private certificate authority for internal services. A root
+	and an intermediate are generated in the store folder on first start.
+	Leaf certificates are signed by the intermediate, so clients only need
+	to trust the root once to accept every certificate issued by Zoraxy.
+*/
+
+const (
+	rootValidity            = 10 * 365 * 24 * time.Hour
+	intermediateValidity    = 3 * 365 * 24 * time.Hour
+	intermediateRenewBefore = 90 * 24 * time.Hour //Replace the intermediate when it expires within this period
+	maintenanceInterval     = 1 * time.Hour
+	DefaultValidityDays     = 90
+	MaxValidityDays         = 825
+
+	// PublicPathPrefix is the path the root, CRL and ACME server are published under
+	PublicPathPrefix = "/.well-known/zoraxy-ca/"
+)
+
+// Config is the user configuration of the local CA
+type Config struct {
+	AutoIssue        bool     //Issue certificates for internal proxy endpoints on first handshake
+	ACMEEnabled      bool     //Serve the ACME server for other internal machines
+	PublicURL        string   //Base URL the CA is reachable at, e.g. https://zoraxy.lan, used for CRL distribution points
+	ValidityDays     int      //Validity of issued certificates in days
+	InternalSuffixes []string //Extra domain suffixes treated as internal, e.g. .example.lan
+}
+
+type Options struct {
+	StoreFolder string         //Folder of the CA keys and state
+	CertStore   string         //Cert store of the TLS manager, certificates issued for proxy endpoints are written here
+	CommonName  string         //Common name prefix of the root and intermediate
+	Logger      *logger.Logger //System wide logger
+}
+
+type CA struct {
+	Options *Options
+	Config  *Config
+
+	root            *x509.Certificate
+	rootKey         crypto.Signer
+	intermediate    *x509.Certificate
+	intermediateKey crypto.Signer
+	state           *caState //Issued certificates and CRL number
+	crl             []byte   //DER encoded CRL signed by the intermediate
+	crlThisUpdate   time.Time
+	acme            *acmeServer
+	mutex           sync.RWMutex
+	stopChan        chan bool
+}
+
+// NewLocalCA loads the CA from the store folder, creating the root and
+// intermediate if they do not exist yet
+func NewLocalCA(options *Options) (*CA, error) {
+	if err := os.MkdirAll(options.StoreFolder, 0775); err != nil {
+		return nil, err
+	}
+	if options.CommonName == "" {
+		options.CommonName = "Zoraxy Local CA"
+	}
+
+	ca := &CA{
+		Options: options,
+		Config: &Config{
+			ValidityDays:     DefaultValidityDays,
+			InternalSuffixes: []string{},
+		},
+		stopChan: make(chan bool),
+	}
+	if err := ca.loadConfig(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	ca.root, ca.rootKey, err = ca.loadOrCreateRoot()
+	if err != nil {
+		return nil, err
+	}
+	ca.intermediate, ca.intermediateKey, err = ca.loadOrCreateIntermediate(false)
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.loadState(); err != nil {
+		return nil, err
+	}
+	if err := ca.regenerateCRL(); err != nil {
+		return nil, err
+	}
+	ca.acme, err = newACMEServer(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	go ca.maintenanceLoop()
+	return ca, nil
+}
+
+// Close stops the background maintenance of the CA
+func (ca *CA) Close() {
+	if ca.stopChan != nil {
+		close(ca.stopChan)
+		ca.stopChan = nil
+	}
+}
+
+func (ca *CA) Logf(message string, err error) {
+	ca.Options.Logger.PrintAndLog("local-ca", message, err)
+}
+
+// maintenanceLoop replaces an expiring intermediate, renews certificates
+// issued into the cert store and keeps the CRL fresh
+func (ca *CA) maintenanceLoop() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	stop := ca.stopChan
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ca.runMaintenance()
+		}
+	}
+}
+
+func (ca *CA) runMaintenance() {
+	ca.mutex.Lock()
+	if time.Until(ca.intermediate.NotAfter) < intermediateRenewBefore {
+		intermediate, key, err := ca.loadOrCreateIntermediate(true)
+		if err != nil {
+			ca.Logf("Failed to replace expiring intermediate certificate", err)
+		} else {
+			ca.intermediate, ca.intermediateKey = intermediate, key
+			ca.Logf("Intermediate certificate replaced", nil)
+		}
+	}
+	ca.mutex.Unlock()
+
+	ca.renewIssuedCertificates()
+	if _, err := ca.GetCRL(); err != nil {
+		ca.Logf("Failed to refresh CRL", err)
+	}
+	ca.acme.pruneExpired()
+}
+
+/* Config */
+
+func (ca *CA) configPath() string {
+	return filepath.Join(ca.Options.StoreFolder, "config.json")
+}
+
+func (ca *CA) loadConfig() error {
+	if !utils.FileExists(ca.configPath()) {
+		return ca.saveConfig()
+	}
+	content, err := os.ReadFile(ca.configPath())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, ca.Config); err != nil {
+		return errors.New("malformed local CA config: " + err.Error())
+	}
+	if ca.Config.ValidityDays <= 0 {
+		ca.Config.ValidityDays = DefaultValidityDays
+	}
+	return nil
+}
+
+func (ca *CA) saveConfig() error {
+	js, _ := json.MarshalIndent(ca.Config, "", " ")
+	return os.WriteFile(ca.configPath(), js, 0644)
+}
+
+// UpdateConfig validates and saves a new config
+func (ca *CA) UpdateConfig(config *Config) error {
+	if config.ValidityDays <= 0 || config.ValidityDays > MaxValidityDays {
+		return errors.New("validity must be between 1 and 825 days")
+	}
+	if config.InternalSuffixes == nil {
+		config.InternalSuffixes = []string{}
+	}
+	ca.mutex.Lock()
+	ca.Config = config
+	err := ca.saveConfig()
+	ca.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	//CRL distribution point might have changed
+	return ca.regenerateCRL()
+}
+
+// GetConfig returns a copy of the current config
+func (ca *CA) GetConfig() *Config {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	config := *ca.Config
+	config.InternalSuffixes = append([]string{}, ca.Config.InternalSuffixes...)
+	return &config
+}
+
+/* Root and intermediate */
+
+func (ca *CA) loadOrCreateRoot() (*x509.Certificate, crypto.Signer, error) {
+	certPath := filepath.Join(ca.Options.StoreFolder, "root.pem")
+	keyPath := filepath.Join(ca.Options.StoreFolder, "root.key")
+	if utils.FileExists(certPath) && utils.FileExists(keyPath) {
+		return loadKeyPair(certPath, keyPath)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: ca.Options.CommonName + " Root", Organization: []string{"Zoraxy"}},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPair(certPath, keyPath, certDER, key); err != nil {
+		return nil, nil, err
+	}
+	ca.Logf("Generated new root certificate", nil)
+	cert, err := x509.ParseCertificate(certDER)
+	return cert, key, err
+}
+
+// loadOrCreateIntermediate loads the intermediate signed by the root, or
+// creates a new one if it does not exist or replace is set
+func (ca *CA) loadOrCreateIntermediate(replace bool) (*x509.Certificate, crypto.Signer, error) {
+	certPath := filepath.Join(ca.Options.StoreFolder, "intermediate.pem")
+	keyPath := filepath.Join(ca.Options.StoreFolder, "intermediate.key")
+	if !replace && utils.FileExists(certPath) && utils.FileExists(keyPath) {
+		cert, key, err := loadKeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cert.CheckSignatureFrom(ca.root); err != nil {
+			return nil, nil, errors.New("intermediate certificate is not signed by the root")
+		}
+		return cert, key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	notAfter := time.Now().Add(intermediateValidity)
+	if notAfter.After(ca.root.NotAfter) {
+		notAfter = ca.root.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: ca.Options.CommonName + " Intermediate", Organization: []string{"Zoraxy"}},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca.root, key.Public(), ca.rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPair(certPath, keyPath, certDER, key); err != nil {
+		return nil, nil, err
+	}
+	ca.Logf("Generated new intermediate certificate", nil)
+	cert, err := x509.ParseCertificate(certDER)
+	return cert, key, err
+}
+
+// GetRootPEM returns the PEM encoded root certificate for client trust stores
+func (ca *CA) GetRootPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})
+}
+
+// GetRootDER returns the DER encoded root certificate
+func (ca *CA) GetRootDER() []byte {
+	return ca.root.Raw
+}
+
+// GetIntermediatePEM returns the PEM encoded intermediate certificate
+func (ca *CA) GetIntermediatePEM() []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.intermediate.Raw})
+}
+
+// GetRootPool returns a cert pool trusting the root, for verifying issued certificates
+func (ca *CA) GetRootPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.root)
+	return pool
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+/* Helpers */
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+func serialString(serial *big.Int) string {
+	return hex.EncodeToString(serial.Bytes())
+}
+
+func loadKeyPair(certPath string, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, errors.New("invalid certificate file " + filepath.Base(certPath))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := secrets.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, errors.New("invalid key file " + filepath.Base(keyPath))
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported key type in " + filepath.Base(keyPath))
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(certPath string, keyPath string, certDER []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := secrets.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644)
+}
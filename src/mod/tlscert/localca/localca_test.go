@@ -0,0 +1,181 @@
+package localca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+func newTestCA(t *testing.T) *CA {
+	t.Helper()
+	log, _ := logger.NewFmtLogger()
+	ca, err := NewLocalCA(&Options{
+		StoreFolder: filepath.Join(t.TempDir(), "localca"),
+		CertStore:   t.TempDir(),
+		Logger:      log,
+	})
+	if err != nil {
+		t.Fatalf("NewLocalCA: %v", err)
+	}
+	t.Cleanup(ca.Close)
+	return ca
+}
+
+// readStoreCert parses the leaf and chain written into the cert store
+func readStoreCert(t *testing.T, ca *CA, certName string) (*x509.Certificate, *x509.CertPool) {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(ca.Options.CertStore, certName+".pem"))
+	if err != nil {
+		t.Fatalf("read issued certificate: %v", err)
+	}
+	var leaf *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("parse certificate: %v", err)
+		}
+		if leaf == nil {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if leaf == nil {
+		t.Fatalf("no certificate in %s.pem", certName)
+	}
+	return leaf, intermediates
+}
+
+func TestLocalCAIssueAndRevoke(t *testing.T) {
+	ca := newTestCA(t)
+	config := ca.GetConfig()
+	config.PublicURL = "https://zoraxy.lan"
+	if err := ca.UpdateConfig(config); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	if err := ca.IssueForHostname("example.com"); err == nil {
+		t.Fatalf("expected public hostname to be rejected")
+	}
+	if err := ca.IssueForHostname("NAS.home.arpa"); err != nil {
+		t.Fatalf("IssueForHostname: %v", err)
+	}
+
+	leaf, intermediates := readStoreCert(t, ca, "nas.home.arpa")
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       "nas.home.arpa",
+		Roots:         ca.GetRootPool(),
+		Intermediates: intermediates,
+	}); err != nil {
+		t.Fatalf("issued certificate does not chain to the root: %v", err)
+	}
+	if len(leaf.CRLDistributionPoints) != 1 || leaf.CRLDistributionPoints[0] != "https://zoraxy.lan"+PublicPathPrefix+"crl" {
+		t.Fatalf("unexpected CRL distribution points %v", leaf.CRLDistributionPoints)
+	}
+	if _, err := os.Stat(filepath.Join(ca.Options.CertStore, "nas.home.arpa.key")); err != nil {
+		t.Fatalf("issued key not written: %v", err)
+	}
+
+	//Revoke and check the published CRL
+	serial := serialString(leaf.SerialNumber)
+	if err := ca.Revoke(serial, 1); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if err := ca.Revoke(serial, 1); err == nil {
+		t.Fatalf("expected revoking twice to fail")
+	}
+
+	server := httptest.NewServer(ca)
+	defer server.Close()
+	resp, err := http.Get(server.URL + PublicPathPrefix + "crl")
+	if err != nil {
+		t.Fatalf("fetch CRL: %v", err)
+	}
+	defer resp.Body.Close()
+	crlDER, _ := io.ReadAll(resp.Body)
+	crl, err := x509.ParseRevocationList(crlDER)
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(ca.intermediate); err != nil {
+		t.Fatalf("CRL not signed by the intermediate: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Fatalf("revoked certificate missing from CRL")
+	}
+
+	//Root export
+	resp, err = http.Get(server.URL + PublicPathPrefix + "root.crt")
+	if err != nil {
+		t.Fatalf("fetch root: %v", err)
+	}
+	defer resp.Body.Close()
+	rootDER, _ := io.ReadAll(resp.Body)
+	if root, err := x509.ParseCertificate(rootDER); err != nil || !root.Equal(ca.root) {
+		t.Fatalf("exported root does not match: %v", err)
+	}
+
+	//ACME server is hidden until enabled
+	resp, err = http.Get(server.URL + acmePathPrefix + "directory")
+	if err != nil {
+		t.Fatalf("fetch ACME directory: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected ACME server to be disabled, got status %d", resp.StatusCode)
+	}
+}
+
+func TestLocalCAReload(t *testing.T) {
+	ca := newTestCA(t)
+	if err := ca.IssueToCertStore([]string{"printer", "10.0.0.5"}, "printer", SourceManual); err != nil {
+		t.Fatalf("IssueToCertStore: %v", err)
+	}
+	ca.Close()
+
+	reloaded, err := NewLocalCA(ca.Options)
+	if err != nil {
+		t.Fatalf("reload CA: %v", err)
+	}
+	defer reloaded.Close()
+	if Fingerprint(reloaded.root) != Fingerprint(ca.root) || Fingerprint(reloaded.intermediate) != Fingerprint(ca.intermediate) {
+		t.Fatalf("root or intermediate regenerated on reload")
+	}
+	issued := reloaded.ListIssued()
+	if len(issued) != 1 || issued[0].CertName != "printer" || len(issued[0].IPAddresses) != 1 {
+		t.Fatalf("issued records not restored: %+v", issued)
+	}
+}
+
+func TestIsInternalHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		extra    []string
+		want     bool
+	}{
+		{"nas", nil, true},
+		{"grafana.lan", nil, true},
+		{"router.home.arpa", nil, true},
+		{"app.internal.", nil, true},
+		{"192.168.1.10", nil, true},
+		{"localhost", nil, false},
+		{"example.com", nil, false},
+		{"*.lan", nil, false},
+		{"8.8.8.8", nil, false},
+		{"wiki.corp.example.com", []string{".corp.example.com"}, true},
+		{"wiki.corp.example.com", nil, false},
+	}
+	for _, test := range tests {
+		if got := IsInternalHostname(test.hostname, test.extra); got != test.want {
+			t.Errorf("IsInternalHostname(%q, %v) = %v, want %v", test.hostname, test.extra, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tlscert
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	localissuer.go
+
+	On-demand issuance from the built-in local CA. Unlike auto HTTPS,
+	signing locally takes milliseconds, so the certificate is issued
+	during the handshake and served right away.
+*/
+
+const localIssueBackoff = 1 * time.Minute //Backoff after a failed local issuance
+
+// LocalCertIssuer issues a certificate for the hostname into the cert
+// store as <hostname>.pem and <hostname>.key
+type LocalCertIssuer func(hostname string) error
+
+// LocalCertAllowlist reports if the local CA may issue for the hostname
+type LocalCertAllowlist func(hostname string) bool
+
+type localIssuerState struct {
+	issuer      LocalCertIssuer
+	allowlist   LocalCertAllowlist
+	nextAttempt map[string]time.Time //Hostnames backing off after a failure
+	issueMutex  sync.Mutex           //Serialize issuance so concurrent handshakes issue once
+	mutex       sync.Mutex
+}
+
+// SetLocalCertIssuer sets the local CA issuer and the hostname allowlist.
+// Passing a nil issuer disables local issuance.
+func (m *Manager) SetLocalCertIssuer(allowlist LocalCertAllowlist, issuer LocalCertIssuer) {
+	m.localIssuer.mutex.Lock()
+	defer m.localIssuer.mutex.Unlock()
+	m.localIssuer.allowlist = allowlist
+	m.localIssuer.issuer = issuer
+	m.localIssuer.nextAttempt = map[string]time.Time{}
+}
+
+// issueLocalCertificate issues a certificate for the hostname from the
+// local CA, returns nil if the hostname is not handled by it
+func (m *Manager) issueLocalCertificate(hostname string) *loadedCertificate {
+	hostname = strings.ToLower(hostname)
+	m.localIssuer.mutex.Lock()
+	issuer := m.localIssuer.issuer
+	allowlist := m.localIssuer.allowlist
+	nextAttempt := m.localIssuer.nextAttempt[hostname]
+	m.localIssuer.mutex.Unlock()
+	if issuer == nil || allowlist == nil || time.Now().Before(nextAttempt) {
+		return nil
+	}
+	if !allowlist(hostname) {
+		return nil
+	}
+
+	m.localIssuer.issueMutex.Lock()
+	defer m.localIssuer.issueMutex.Unlock()
+	if cert, ok := m.currentCertIndex().byName[hostname]; ok {
+		//Issued by a concurrent handshake while waiting for the lock
+		return cert
+	}
+
+	err := issuer(hostname)
+	if err == nil {
+		err = m.UpdateLoadedCertList()
+	}
+	cert, ok := m.currentCertIndex().byName[hostname]
+	if err != nil || !ok {
+		m.localIssuer.mutex.Lock()
+		m.localIssuer.nextAttempt[hostname] = time.Now().Add(localIssueBackoff)
+		m.localIssuer.mutex.Unlock()
+		m.Logger.PrintAndLog("local-ca", "Certificate issuance for "+hostname+" failed", err)
+		return nil
+	}
+	return cert
+}
@@ -0,0 +1,58 @@
+package tlscert
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLocalCertIssuer(t *testing.T) {
+	var calls int32
+	var fail atomic.Bool
+	m := newTestAutoHTTPSManager(t, nil)
+	m.SetLocalCertIssuer(func(hostname string) bool {
+		return hostname == "nas.lan" || hostname == "broken.lan"
+	}, func(hostname string) error {
+		atomic.AddInt32(&calls, 1)
+		if fail.Load() {
+			return errors.New("signing failed")
+		}
+		return m.GenerateSelfSignedCertificate(hostname, []string{hostname}, hostname+".pem", hostname+".key")
+	})
+
+	//Lookups outside of handshakes never issue
+	if certName, _ := m.LookupServedCertificate("nas.lan"); certName != "fallback" || atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("expected lookup to return the fallback certificate without issuing, got %q", certName)
+	}
+
+	//Certificate is issued during the handshake and served right away
+	pubKey, _, _ := m.GetCertificateByHostname("nas.lan")
+	if pubKey != filepath.Join(m.CertStore, "nas.lan.pem") {
+		t.Errorf("expected locally issued certificate, got %s", pubKey)
+	}
+	m.GetCertificateByHostname("nas.lan")
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected a single issuance, got %d", calls)
+	}
+	if certName, leaf := m.LookupServedCertificate("nas.lan"); certName != "nas.lan" || leaf == nil {
+		t.Errorf("expected lookup to return the issued certificate, got %q", certName)
+	}
+
+	//Hostnames outside of the allowlist get the fallback certificate
+	pubKey, _, _ = m.GetCertificateByHostname("other.lan")
+	if pubKey != filepath.Join(m.CertStore, "fallback.pem") {
+		t.Errorf("expected fallback certificate, got %s", pubKey)
+	}
+
+	//Failures back off instead of signing on every handshake
+	fail.Store(true)
+	m.GetCertificateByHostname("broken.lan")
+	pubKey, _, _ = m.GetCertificateByHostname("broken.lan")
+	if pubKey != filepath.Join(m.CertStore, "fallback.pem") {
+		t.Errorf("expected fallback certificate after failure, got %s", pubKey)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected failed issuance to back off, got %d calls", calls)
+	}
+}
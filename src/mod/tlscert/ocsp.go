@@ -0,0 +1,367 @@
+package tlscert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+/*
+	ocsp.go
+
+	OCSP stapling and revocation monitoring. The OCSP response of each
+	loaded certificate is fetched from the responder listed in the leaf,
+	cached in memory and refreshed half way to its NextUpdate. Good
+	responses are stapled in GetCert, revoked ones are reported to the
+	revoked handler so the certificate can be renewed.
+*/
+
+const (
+	ocspCheckInterval    = 1 * time.Minute  //Interval between checks for due refreshes
+	ocspMinRefresh       = 1 * time.Hour    //Lower bound between two fetches of a good response
+	ocspMaxRefresh       = 24 * time.Hour   //Upper bound between two fetches of a good response
+	ocspRetryInterval    = 10 * time.Minute //Retry interval after a failed fetch
+	ocspRequestTimeout   = 10 * time.Second
+	ocspMaxResponseBytes = 1024 * 1024
+)
+
+// OCSP status of a loaded certificate
+const (
+	OCSPStatusGood    = "good"
+	OCSPStatusRevoked = "revoked"
+	OCSPStatusUnknown = "unknown"
+	OCSPStatusError   = "error"
+)
+
+// OCSPRevokedHandler is called once per certificate serial when the
+// OCSP responder reports the certificate as revoked
+type OCSPRevokedHandler func(certName string, leaf *x509.Certificate, revokedAt time.Time, reason int)
+
+// OCSPStatus is the OCSP state of a loaded certificate
+type OCSPStatus struct {
+	CertName     string
+	SerialNumber string
+	Responder    string
+	Status       string
+	Stapled      bool  //If the response is stapled in handshakes
+	ThisUpdate   int64 //Unix time
+	NextUpdate   int64 //Unix time
+	RevokedAt    int64 //Unix time
+	LastFetch    int64 //Unix time
+	NextFetch    int64 //Unix time
+	LastError    string
+}
+
+type ocspEntry struct {
+	serial          string
+	responder       string
+	status          string
+	response        *ocsp.Response
+	staple          []byte //Raw good response stapled in handshakes
+	lastFetch       time.Time
+	nextFetch       time.Time
+	lastError       string
+	revokedNotified bool
+}
+
+type ocspState struct {
+	entries        map[string]*ocspEntry //Cert name to OCSP state
+	revokedHandler OCSPRevokedHandler
+	httpClient     *http.Client
+	wake           chan struct{}
+	stop           chan struct{}
+	mutex          sync.RWMutex
+	refreshMutex   sync.Mutex //Serialize refresh rounds
+}
+
+// SetOCSPRevokedHandler sets the handler called when a loaded certificate is revoked
+func (m *Manager) SetOCSPRevokedHandler(handler OCSPRevokedHandler) {
+	m.ocsp.mutex.Lock()
+	defer m.ocsp.mutex.Unlock()
+	m.ocsp.revokedHandler = handler
+}
+
+// startOCSPUpdater starts the background refresh of OCSP responses
+func (m *Manager) startOCSPUpdater() {
+	m.ocsp.mutex.Lock()
+	if m.ocsp.stop != nil {
+		m.ocsp.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	wake := make(chan struct{}, 1)
+	m.ocsp.stop = stop
+	m.ocsp.wake = wake
+	m.ocsp.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ocspCheckInterval)
+		defer ticker.Stop()
+		m.RefreshOCSP(false)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+			m.RefreshOCSP(false)
+		}
+	}()
+}
+
+// stopOCSPUpdater stops the background refresh of OCSP responses
+func (m *Manager) stopOCSPUpdater() {
+	m.ocsp.mutex.Lock()
+	defer m.ocsp.mutex.Unlock()
+	if m.ocsp.stop != nil {
+		close(m.ocsp.stop)
+		m.ocsp.stop = nil
+		m.ocsp.wake = nil
+	}
+}
+
+// notifyOCSPUpdater wakes the updater so newly loaded certificates are fetched
+func (m *Manager) notifyOCSPUpdater() {
+	m.ocsp.mutex.RLock()
+	defer m.ocsp.mutex.RUnlock()
+	if m.ocsp.wake == nil {
+		return
+	}
+	select {
+	case m.ocsp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshOCSP fetches the OCSP responses of the loaded certificates that are
+// due for a refresh, or of all of them if force is set
+func (m *Manager) RefreshOCSP(force bool) {
+	m.ocsp.refreshMutex.Lock()
+	defer m.ocsp.refreshMutex.Unlock()
+
+	index := m.currentCertIndex()
+	now := time.Now()
+
+	//Drop the state of certificates that are no longer loaded or got replaced
+	due := []*loadedCertificate{}
+	m.ocsp.mutex.Lock()
+	if m.ocsp.entries == nil {
+		m.ocsp.entries = map[string]*ocspEntry{}
+	}
+	for name, entry := range m.ocsp.entries {
+		loaded, ok := index.byName[name]
+		if !ok || certSerial(loaded.Certificate.Leaf) != entry.serial {
+			delete(m.ocsp.entries, name)
+		}
+	}
+	for name, loaded := range index.byName {
+		if !isOCSPEligible(loaded.Certificate) {
+			continue
+		}
+		entry, ok := m.ocsp.entries[name]
+		if !ok || force || !now.Before(entry.nextFetch) {
+			due = append(due, loaded)
+		}
+	}
+	m.ocsp.mutex.Unlock()
+
+	for _, loaded := range due {
+		m.refreshOCSPEntry(loaded)
+	}
+}
+
+// refreshOCSPEntry fetches and caches the OCSP response of a single certificate
+func (m *Manager) refreshOCSPEntry(loaded *loadedCertificate) {
+	leaf := loaded.Certificate.Leaf
+	entry := &ocspEntry{
+		serial:    certSerial(leaf),
+		responder: leaf.OCSPServer[0],
+		lastFetch: time.Now(),
+	}
+
+	m.ocsp.mutex.RLock()
+	previous := m.ocsp.entries[loaded.Name]
+	m.ocsp.mutex.RUnlock()
+	if previous != nil && previous.serial == entry.serial {
+		entry.revokedNotified = previous.revokedNotified
+	}
+
+	response, raw, err := m.fetchOCSPResponse(loaded.Certificate)
+	if err != nil {
+		entry.status = OCSPStatusError
+		entry.lastError = err.Error()
+		entry.nextFetch = entry.lastFetch.Add(ocspRetryInterval)
+		//Keep stapling the previous response until it expires
+		if previous != nil && previous.serial == entry.serial && previous.response != nil && time.Now().Before(previous.response.NextUpdate) {
+			entry.response = previous.response
+			entry.staple = previous.staple
+		}
+		m.storeOCSPEntry(loaded.Name, entry)
+		m.Logger.PrintAndLog("tls-router", "OCSP fetch failed for "+loaded.Name, err)
+		return
+	}
+
+	entry.response = response
+	entry.nextFetch = nextOCSPFetch(response, entry.lastFetch)
+	switch response.Status {
+	case ocsp.Good:
+		entry.status = OCSPStatusGood
+		entry.staple = raw
+	case ocsp.Revoked:
+		entry.status = OCSPStatusRevoked
+	default:
+		entry.status = OCSPStatusUnknown
+	}
+
+	notifyRevoked := response.Status == ocsp.Revoked && !entry.revokedNotified
+	if notifyRevoked {
+		entry.revokedNotified = true
+	}
+	m.storeOCSPEntry(loaded.Name, entry)
+
+	if notifyRevoked {
+		m.Logger.PrintAndLog("tls-router", "Certificate "+loaded.Name+" has been revoked by its issuer", nil)
+		m.ocsp.mutex.RLock()
+		handler := m.ocsp.revokedHandler
+		m.ocsp.mutex.RUnlock()
+		if handler != nil {
+			go handler(loaded.Name, leaf, response.RevokedAt, response.RevocationReason)
+		}
+	}
+}
+
+func (m *Manager) storeOCSPEntry(name string, entry *ocspEntry) {
+	m.ocsp.mutex.Lock()
+	defer m.ocsp.mutex.Unlock()
+	m.ocsp.entries[name] = entry
+}
+
+// fetchOCSPResponse requests the OCSP response of the certificate from the
+// responder listed in its leaf
+func (m *Manager) fetchOCSPResponse(certificate *tls.Certificate) (*ocsp.Response, []byte, error) {
+	leaf := certificate.Leaf
+	issuer, err := x509.ParseCertificate(certificate.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse issuer certificate: %w", err)
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := m.ocsp.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: ocspRequestTimeout}
+	}
+	resp, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.NextUpdate.IsZero() {
+		return nil, nil, errors.New("OCSP response has no NextUpdate")
+	}
+	if time.Now().After(response.NextUpdate) {
+		return nil, nil, errors.New("OCSP response has expired")
+	}
+	return response, raw, nil
+}
+
+// nextOCSPFetch schedules the refresh half way between ThisUpdate and
+// NextUpdate, so a new response is cached well before the current expires
+func nextOCSPFetch(response *ocsp.Response, fetchedAt time.Time) time.Time {
+	refresh := response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+	if refresh.Before(fetchedAt.Add(ocspMinRefresh)) {
+		refresh = fetchedAt.Add(ocspMinRefresh)
+	}
+	if refresh.After(fetchedAt.Add(ocspMaxRefresh)) {
+		refresh = fetchedAt.Add(ocspMaxRefresh)
+	}
+	//Never wait past the expiry of the cached response
+	if refresh.After(response.NextUpdate) {
+		refresh = response.NextUpdate
+	}
+	return refresh
+}
+
+// isOCSPEligible checks if the certificate lists an OCSP responder and
+// carries its issuer in the chain
+func isOCSPEligible(certificate *tls.Certificate) bool {
+	return certificate.Leaf != nil && len(certificate.Leaf.OCSPServer) > 0 && len(certificate.Certificate) > 1
+}
+
+func certSerial(leaf *x509.Certificate) string {
+	return fmt.Sprintf("%x", leaf.SerialNumber)
+}
+
+// stapleOCSP returns the certificate with its cached OCSP response stapled,
+// or the certificate itself if there is no valid good response
+func (m *Manager) stapleOCSP(loaded *loadedCertificate) *tls.Certificate {
+	m.ocsp.mutex.RLock()
+	entry, ok := m.ocsp.entries[loaded.Name]
+	m.ocsp.mutex.RUnlock()
+	if !ok || entry.staple == nil || entry.serial != certSerial(loaded.Certificate.Leaf) {
+		return loaded.Certificate
+	}
+	if !time.Now().Before(entry.response.NextUpdate) {
+		return loaded.Certificate
+	}
+	stapled := *loaded.Certificate
+	stapled.OCSPStaple = entry.staple
+	return &stapled
+}
+
+// GetOCSPStatus returns the OCSP state of every loaded certificate with an OCSP responder
+func (m *Manager) GetOCSPStatus() []*OCSPStatus {
+	m.ocsp.mutex.RLock()
+	defer m.ocsp.mutex.RUnlock()
+	now := time.Now()
+	results := []*OCSPStatus{}
+	for name, entry := range m.ocsp.entries {
+		status := &OCSPStatus{
+			CertName:     name,
+			SerialNumber: entry.serial,
+			Responder:    entry.responder,
+			Status:       entry.status,
+			Stapled:      entry.staple != nil && now.Before(entry.response.NextUpdate),
+			LastFetch:    entry.lastFetch.Unix(),
+			NextFetch:    entry.nextFetch.Unix(),
+			LastError:    entry.lastError,
+		}
+		if entry.response != nil {
+			status.ThisUpdate = entry.response.ThisUpdate.Unix()
+			status.NextUpdate = entry.response.NextUpdate.Unix()
+			if entry.response.Status == ocsp.Revoked {
+				status.RevokedAt = entry.response.RevokedAt.Unix()
+			}
+		}
+		results = append(results, status)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CertName < results[j].CertName
+	})
+	return results
+}
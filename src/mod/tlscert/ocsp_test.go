@@ -0,0 +1,165 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPResponder is a local OCSP responder signing with the test CA
+type testOCSPResponder struct {
+	caCert   *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func (r *testOCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.requests.Add(1)
+	body, _ := io.ReadAll(req.Body)
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().Truncate(time.Minute)
+	template := ocsp.Response{
+		Status:       int(r.status.Load()),
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now.Add(-time.Hour),
+		NextUpdate:   now.Add(24 * time.Hour),
+	}
+	if template.Status == ocsp.Revoked {
+		template.RevokedAt = now.Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	resp, err := ocsp.CreateResponse(r.caCert, r.caCert, template, r.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+// writeTestCertWithOCSP writes a CA signed certificate chain pointing to the OCSP responder
+func writeTestCertWithOCSP(t *testing.T, certStore string, name string, responderURL string) *testOCSPResponder {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test OCSP CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{name},
+		OCSPServer:   []string{responderURL},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &leafTemplate, caCert, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(leafKey)
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	os.WriteFile(filepath.Join(certStore, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.WriteFile(filepath.Join(certStore, name+".pem"), chain, 0644)
+	return &testOCSPResponder{caCert: caCert, caKey: caKey}
+}
+
+func TestOCSPStaplingAndRevocation(t *testing.T) {
+	m := newTestCacheManager(t)
+
+	//The responder URL is needed in the certificate before the CA exists
+	var responder *testOCSPResponder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	responder = writeTestCertWithOCSP(t, m.CertStore, "ocsp.example.com", server.URL)
+	responder.status.Store(ocsp.Good)
+	if err := m.UpdateLoadedCertList(); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := make(chan string, 1)
+	m.SetOCSPRevokedHandler(func(certName string, leaf *x509.Certificate, revokedAt time.Time, reason int) {
+		revoked <- certName
+	})
+
+	//Good responses are stapled
+	m.RefreshOCSP(false)
+	cert, err := m.GetCert(&tls.ClientHelloInfo{ServerName: "ocsp.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.OCSPStaple) == 0 {
+		t.Fatal("expected an OCSP staple for a good response")
+	}
+	staple, err := ocsp.ParseResponse(cert.OCSPStaple, responder.caCert)
+	if err != nil || staple.Status != ocsp.Good {
+		t.Errorf("expected a valid good staple, got %v", err)
+	}
+
+	//Responses are cached until they are due for a refresh
+	m.RefreshOCSP(false)
+	if requests := responder.requests.Load(); requests != 1 {
+		t.Errorf("expected the cached response to be reused, got %d requests", requests)
+	}
+
+	//Revocation removes the staple and calls the revoked handler once
+	responder.status.Store(ocsp.Revoked)
+	m.RefreshOCSP(true)
+	select {
+	case certName := <-revoked:
+		if certName != "ocsp.example.com" {
+			t.Errorf("unexpected revoked certificate %s", certName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the revoked handler to be called")
+	}
+	cert, _ = m.GetCert(&tls.ClientHelloInfo{ServerName: "ocsp.example.com"})
+	if len(cert.OCSPStaple) != 0 {
+		t.Error("expected no OCSP staple for a revoked certificate")
+	}
+	m.RefreshOCSP(true)
+	select {
+	case <-revoked:
+		t.Error("expected the revoked handler to be called once per certificate")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	status := m.GetOCSPStatus()
+	if len(status) != 1 || status[0].Status != OCSPStatusRevoked || status[0].Stapled {
+		t.Errorf("unexpected OCSP status: %+v", status)
+	}
+}
@@ -6,13 +6,20 @@ import (
 	"embed"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 
 	"imuslab.com/zoraxy/mod/info/logger"
+	"imuslab.com/zoraxy/mod/secrets"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -33,10 +40,20 @@ type Manager struct {
 	CertStore    string         //Path where all the certs are stored
 	LoadedCerts  []*CertCache   //A list of loaded certs
 	Logger       *logger.Logger //System wide logger for debug mesage
-	FallbackCert string        //Name of the fallback/default certificate (no file renaming)
+	FallbackCert string         //Name of the fallback/default certificate (no file renaming)
 
 	/* External handlers */
 	hostSpecificTlsBehavior func(serverName string) (*HostSpecificTlsBehavior, error) // Function to get host specific TLS behavior, if nil, use global TLS options
+	autoHTTPS               autoHTTPSState                                            // On-demand certificate issuance state, see autohttps.go
+	acmeChallengeResolver   atomic.Pointer[ACMEChallengeResolver]                     // TLS-ALPN-01 challenge certificate resolver, see acmechallenge.go
+	ocsp                    ocspState                                                 // OCSP stapling and revocation monitoring, see ocsp.go
+	localIssuer             localIssuerState                                          // On-demand issuance from the local CA, see localissuer.go
+
+	/* Certificate cache, see cache.go */
+	certIndex     atomic.Pointer[certIndex] //Parsed certificates used for handshakes
+	certWatcher   *fsnotify.Watcher         //Cert store watcher for hot reload
+	reloadMutex   sync.Mutex                //Serialize cache rebuilds
+	lookupMetrics certLookupMetrics         //Handshake certificate lookup statistic
 }
 
 //go:embed localhost.pem localhost.key
@@ -65,6 +82,16 @@ func writeFileWithMode(baseDir, relativeName string, data []byte, mode os.FileMo
 	return os.Chmod(resolvedPath, mode)
 }
 
+// writePrivateKeyWithMode is writeFileWithMode for private keys, the key
+// is sealed if secrets encryption is enabled
+func writePrivateKeyWithMode(baseDir, relativeName string, data []byte, mode os.FileMode) error {
+	resolvedPath, err := safeJoin(baseDir, relativeName)
+	if err != nil {
+		return err
+	}
+	return secrets.WriteFile(resolvedPath, data, mode)
+}
+
 // safeJoin joins name onto baseDir and verifies the resulting absolute path
 // is still located inside baseDir, rejecting any ../
 func safeJoin(baseDir, name string) (string, error) {
@@ -123,6 +150,15 @@ func NewManager(certStore string, logger *logger.Logger) (*Manager, error) {
 		return nil, err
 	}
 
+	//Reload the certificate cache when files are changed outside of Zoraxy
+	err = thisManager.startCertStoreWatcher()
+	if err != nil {
+		logger.PrintAndLog("tls-router", "Unable to watch cert store for changes, certificates are reloaded on update only", err)
+	}
+
+	//Keep the OCSP staples of the loaded certificates up to date
+	thisManager.startOCSPUpdater()
+
 	return &thisManager, nil
 }
 
@@ -147,67 +183,40 @@ func (m *Manager) SetHostSpecificTlsBehavior(fn func(serverName string) (*HostSp
 
 // Update domain mapping from file
 func (m *Manager) UpdateLoadedCertList() error {
-	//Get a list of certificates from file
-	domainList, err := m.ListCertDomains()
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+
+	//Load each of the certificates into a new index
+	index, err := m.buildCertIndex()
 	if err != nil {
+		m.lookupMetrics.reloadErrors.Add(1)
+		m.lookupMetrics.lastError.Store(err.Error())
 		return err
 	}
 
-	//Load each of the certificates into memory
-	certList := []*CertCache{}
-	for _, certname := range domainList {
-		//Read their certificate into memory
-		pubKey := filepath.Join(m.CertStore, certname+".pem")
-		priKey := filepath.Join(m.CertStore, certname+".key")
-		certificate, err := tls.LoadX509KeyPair(pubKey, priKey)
-		if err != nil {
-			m.Logger.PrintAndLog("tls-router", "Certificate load failed: "+certname, err)
-			continue
-		}
-
-		for _, thisCert := range certificate.Certificate {
-			loadedCert, err := x509.ParseCertificate(thisCert)
-			if err != nil {
-				//Error pasring cert, skip this byte segment
-				m.Logger.PrintAndLog("tls-router", "Certificate parse failed: "+certname, err)
-				continue
-			}
-
-			thisCacheEntry := CertCache{
-				Cert:   loadedCert,
-				PubKey: pubKey,
-				PriKey: priKey,
-			}
-			certList = append(certList, &thisCacheEntry)
-		}
-	}
-
-	//Replace runtime cert array
-	m.LoadedCerts = certList
+	//Replace runtime cert index
+	m.certIndex.Store(index)
+	m.LoadedCerts = index.entries
+	m.lookupMetrics.reloads.Add(1)
 
+	//Fetch the OCSP responses of newly loaded certificates
+	m.notifyOCSPUpdater()
 	return nil
 }
 
 // Match cert by CN
 func (m *Manager) CertMatchExists(serverName string) bool {
-	for _, certCacheEntry := range m.LoadedCerts {
-		if certCacheEntry.Cert.VerifyHostname(serverName) == nil || certCacheEntry.Cert.Issuer.CommonName == serverName {
-			return true
-		}
-	}
-	return false
+	return m.currentCertIndex().matchHostname(serverName) != nil
 }
 
 // Get cert entry by matching server name, return pubKey and priKey if found
 // check with CertMatchExists before calling to the load function
 func (m *Manager) GetCertByX509CNHostname(serverName string) (string, string) {
-	for _, certCacheEntry := range m.LoadedCerts {
-		if certCacheEntry.Cert.VerifyHostname(serverName) == nil || certCacheEntry.Cert.Issuer.CommonName == serverName {
-			return certCacheEntry.PubKey, certCacheEntry.PriKey
-		}
+	cert := m.currentCertIndex().matchHostname(serverName)
+	if cert == nil {
+		return "", ""
 	}
-
-	return "", ""
+	return cert.PubKey, cert.PriKey
 }
 
 // Return a list of domains by filename
@@ -240,30 +249,70 @@ func (m *Manager) ListCerts() ([]string, error) {
 	return filenames, nil
 }
 
-// Get a certificate from disk where its certificate matches with the helloinfo
+// Get a certificate from the cache where its certificate matches with the helloinfo
 func (m *Manager) GetCert(helloInfo *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	//Look for the certificate by hostname
-	pubKey, priKey, err := m.GetCertificateByHostname(helloInfo.ServerName)
-	if err != nil {
-		m.Logger.PrintAndLog("tls-router", "Failed to get certificate for "+helloInfo.ServerName, err)
-		return nil, err
+	if isACMEChallengeHello(helloInfo) {
+		//TLS-ALPN-01 validation from the ACME server
+		if cert, ok := m.getACMEChallengeCert(helloInfo); ok {
+			return cert, nil
+		}
 	}
 
-	//Load the cert and serve it
-	cer, err := tls.LoadX509KeyPair(pubKey, priKey)
-	if err != nil {
-		return nil, nil
+	startTime := time.Now()
+	cert := m.resolveCertificate(helloInfo.ServerName)
+	m.lookupMetrics.observe(time.Since(startTime), cert != nil)
+	if cert == nil {
+		err := errors.New("no certificate available")
+		m.Logger.PrintAndLog("tls-router", "Failed to get certificate for "+helloInfo.ServerName, err)
+		return nil, err
 	}
 
-	return &cer, nil
+	//Pick between ECDSA and RSA certificates for the same names
+	return m.stapleOCSP(cert.selectForClient(helloInfo)), nil
 }
 
 // GetCertificateByHostname returns the certificate and private key for a given hostname
 func (m *Manager) GetCertificateByHostname(hostname string) (string, string, error) {
-	//Check if the domain corrisponding cert exists
-	pubKey := "./tmp/localhost.pem"
-	priKey := "./tmp/localhost.key"
+	cert := m.resolveCertificate(hostname)
+	if cert == nil {
+		//Build-in certificate failed to load, return its paths anyway
+		return "./tmp/localhost.pem", "./tmp/localhost.key", nil
+	}
+	return cert.PubKey, cert.PriKey, nil
+}
+
+// GetCertificateByName returns the parsed key pair of a certificate in the cert store
+func (m *Manager) GetCertificateByName(name string) (*tls.Certificate, error) {
+	cert, ok := m.currentCertIndex().byName[name]
+	if !ok || cert.Certificate == nil {
+		return nil, errors.New("certificate " + name + " not found in cert store")
+	}
+	return cert.Certificate, nil
+}
+
+// LookupServedCertificate returns the name and leaf of the certificate a handshake
+// for the hostname would be served, without issuing new certificates. The name is
+// empty if the build-in certificate would be served
+func (m *Manager) LookupServedCertificate(hostname string) (string, *x509.Certificate) {
+	cert := m.selectCertificate(hostname, false)
+	if cert == nil || cert.Certificate == nil {
+		return "", nil
+	}
+	if cert == m.currentCertIndex().builtin {
+		return "", cert.Certificate.Leaf
+	}
+	return cert.Name, cert.Certificate.Leaf
+}
+
+// resolveCertificate picks the certificate to serve for a hostname from the cache
+func (m *Manager) resolveCertificate(hostname string) *loadedCertificate {
+	return m.selectCertificate(hostname, true)
+}
 
+// selectCertificate picks the certificate for a hostname, issue set to false
+// skips the local CA and auto HTTPS issuance for lookups outside of handshakes
+func (m *Manager) selectCertificate(hostname string, issue bool) *loadedCertificate {
+	index := m.currentCertIndex()
 	tlsBehavior, err := m.hostSpecificTlsBehavior(hostname)
 	if err != nil {
 		tlsBehavior, _ = defaultHostSpecificTlsBehavior(hostname)
@@ -273,37 +322,51 @@ func (m *Manager) GetCertificateByHostname(hostname string) (string, string, err
 		preferredCertificate = ""
 	}
 
-	if tlsBehavior.DisableSNI && preferredCertificate != "" &&
-		utils.FileExists(filepath.Join(m.CertStore, preferredCertificate+".pem")) &&
-		utils.FileExists(filepath.Join(m.CertStore, preferredCertificate+".key")) {
+	if cert, ok := index.byName[preferredCertificate]; tlsBehavior.DisableSNI && preferredCertificate != "" && ok {
 		//User setup a Preferred certificate, use the preferred certificate directly
-		pubKey = filepath.Join(m.CertStore, preferredCertificate+".pem")
-		priKey = filepath.Join(m.CertStore, preferredCertificate+".key")
-	} else {
-		if !tlsBehavior.DisableLegacyCertificateMatching &&
-			utils.FileExists(filepath.Join(m.CertStore, hostname+".pem")) &&
-			utils.FileExists(filepath.Join(m.CertStore, hostname+".key")) {
-			//Legacy filename matching, use the file names directly
-			//This is the legacy method of matching certificates, it will match the file names directly
-			//This is used for compatibility with Zoraxy v2 setups
-			pubKey = filepath.Join(m.CertStore, hostname+".pem")
-			priKey = filepath.Join(m.CertStore, hostname+".key")
-		} else if !tlsBehavior.DisableSNI &&
-			m.CertMatchExists(hostname) {
-			//SNI scan match, find the first matching certificate
-			pubKey, priKey = m.GetCertByX509CNHostname(hostname)
-		} else if tlsBehavior.EnableAutoHTTPS {
-			//Get certificate from CA, WIP
-			//TODO: Implement AutoHTTPS
-		} else {
-			//Fallback to the configured fallback certificate
-			if m.DefaultCertExists() {
-				pubKey = filepath.Join(m.CertStore, m.FallbackCert+".pem")
-				priKey = filepath.Join(m.CertStore, m.FallbackCert+".key")
+		return cert
+	}
+
+	if cert, ok := index.byName[hostname]; !tlsBehavior.DisableLegacyCertificateMatching && ok {
+		//Legacy filename matching, use the file names directly
+		//This is the legacy method of matching certificates, it will match the file names directly
+		//This is used for compatibility with Zoraxy v2 setups
+		return cert
+	}
+
+	if !tlsBehavior.DisableSNI {
+		//SNI scan match, find the first matching certificate
+		if cert := index.matchHostname(hostname); cert != nil {
+			return cert
+		}
+	}
+
+	if issue {
+		if cert := m.issueLocalCertificate(hostname); cert != nil {
+			//Internal hostname signed by the built-in local CA
+			return cert
+		}
+	}
+
+	if tlsBehavior.EnableAutoHTTPS {
+		if cert, ok := index.byName[hostname]; ok {
+			//Certificate previously obtained by auto HTTPS
+			return cert
+		}
+
+		if issue {
+			//Get certificate from CA in the background and serve the fallback certificate while waiting
+			if _, err := m.RequestAutoHTTPSCertificate(hostname); err != nil && err != ErrAutoHTTPSInProgress && err != ErrAutoHTTPSBackoff {
+				m.logAutoHTTPS("Auto HTTPS skipped for "+hostname, err)
 			}
 		}
 	}
-	return pubKey, priKey, nil
+
+	//Fallback to the configured fallback certificate
+	if cert, ok := index.byName[m.FallbackCert]; m.FallbackCert != "" && ok {
+		return cert
+	}
+	return index.builtin
 }
 
 // Check if both the default cert public key and private key exists
@@ -0,0 +1,301 @@
+// Package secretsmigration encrypts the plaintext secrets of an existing
+// install after secrets encryption is enabled.
+//
+// Modules seal secrets when they write them, but secrets written before
+// encryption was enabled, or restored from a backup, stay in plaintext
+// until they are changed. This migration seals them in place. Secrets
+// already sealed are skipped, so it is safe to run on every startup.
+package secretsmigration
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/secrets"
+)
+
+// EncryptExistingSecrets seals the plaintext private keys and secrets in the
+// conf folder and the database, returns the number of secrets sealed
+func EncryptExistingSecrets(confFolder string, sysdb *database.Database) (int, error) {
+	if !secrets.Enabled() {
+		return 0, errors.New("secrets encryption is not enabled")
+	}
+
+	sealed := 0
+	var lastErr error
+	record := func(n int, err error) {
+		sealed += n
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	//Private keys of the cert store and the local CA
+	for _, pattern := range []string{"certs/*.key", "localca/*.key"} {
+		keyFiles, err := filepath.Glob(filepath.Join(confFolder, pattern))
+		if err != nil {
+			return sealed, err
+		}
+		for _, keyFile := range keyFiles {
+			record(sealFile(keyFile))
+		}
+	}
+
+	//CAPTCHA secret keys, basic auth credentials and JWT secrets of the proxy endpoints
+	proxyConfigs, err := filepath.Glob(filepath.Join(confFolder, "proxy", "*.config"))
+	if err != nil {
+		return sealed, err
+	}
+	for _, proxyConfig := range proxyConfigs {
+		record(sealJSONFile(proxyConfig, func(config map[string]interface{}) (int, error) {
+			n, err := sealField(child(config, "CaptchaConfig"), "SecretKey")
+			if err != nil {
+				return n, err
+			}
+			authProvider := child(config, "AuthenticationProvider")
+			if creds, ok := authProvider["BasicAuthCredentials"].([]interface{}); ok {
+				for _, cred := range creds {
+					credMap, _ := cred.(map[string]interface{})
+					m, err := sealField(credMap, "PasswordHash")
+					n += m
+					if err != nil {
+						return n, err
+					}
+				}
+			}
+			m, err := sealField(child(authProvider, "JWTAuthConfig"), "Secret")
+			n += m
+			if err != nil {
+				return n, err
+			}
+			m, err = sealField(child(authProvider, "OAuth2ClaimForwarding"), "SignedJWTSecret")
+			return n + m, err
+		}))
+	}
+
+	//Certificate monitor notification credentials
+	monitorConfig := filepath.Join(confFolder, "certmonitor", "config.json")
+	if _, err := os.Stat(monitorConfig); err == nil {
+		record(sealJSONFile(monitorConfig, func(config map[string]interface{}) (int, error) {
+			n, err := sealField(child(config, "Email"), "Password")
+			if err != nil {
+				return n, err
+			}
+			m, err := sealField(child(config, "Webhook"), "Secret")
+			return n + m, err
+		}))
+	}
+
+	if sysdb != nil {
+		record(sealDatabaseSecrets(sysdb))
+	}
+	return sealed, lastErr
+}
+
+// sealDatabaseSecrets seals the SSO secrets, TOTP secrets, ACME account and EAB keys in the database
+func sealDatabaseSecrets(sysdb *database.Database) (int, error) {
+	sealed := 0
+	stringSecrets := [][2]string{
+		{"oauth2", "oauth2ClientSecret"},
+		{"zorxauth", "oidc_signing_key"},
+		{"zorxauth", "email_signing_key"},
+	}
+	for _, entry := range stringSecrets {
+		if !sysdb.TableExists(entry[0]) || !sysdb.KeyExists(entry[0], entry[1]) {
+			continue
+		}
+		value := ""
+		if err := sysdb.Read(entry[0], entry[1], &value); err != nil || value == "" || secrets.IsSealed([]byte(value)) {
+			continue
+		}
+		if err := sysdb.WriteSecret(entry[0], entry[1], value); err != nil {
+			return sealed, err
+		}
+		sealed++
+	}
+
+	//ACME external account binding HMAC keys, keyed by CA directory URL
+	if sysdb.TableExists("acme") {
+		entries, err := sysdb.ListTable("acme")
+		if err != nil {
+			return sealed, err
+		}
+		for _, entry := range entries {
+			key := string(entry[0])
+			value := ""
+			if !strings.HasSuffix(key, "_hmacEncoded") || json.Unmarshal(entry[1], &value) != nil {
+				continue
+			}
+			if value == "" || secrets.IsSealed([]byte(value)) {
+				continue
+			}
+			if err := sysdb.WriteSecret("acme", key, value); err != nil {
+				return sealed, err
+			}
+			sealed++
+		}
+	}
+
+	//TOTP secrets of the management accounts
+	if sysdb.TableExists("auth") {
+		entries, err := sysdb.ListTable("auth")
+		if err != nil {
+			return sealed, err
+		}
+		for _, entry := range entries {
+			key := string(entry[0])
+			value := ""
+			if !strings.HasPrefix(key, "totp/") || json.Unmarshal(entry[1], &value) != nil {
+				continue
+			}
+			if value == "" || secrets.IsSealed([]byte(value)) {
+				continue
+			}
+			if err := sysdb.WriteSecret("auth", key, value); err != nil {
+				return sealed, err
+			}
+			sealed++
+		}
+	}
+
+	//Struct records with a secret field
+	recordSecrets := [][3]string{
+		{"zorxauth", "email_options", "password"},
+		{"zorxauth", "ldap_options", "bindPassword"},
+		{"auth", "admin_sso/settings", "clientSecret"},
+	}
+	for _, entry := range recordSecrets {
+		if !sysdb.TableExists(entry[0]) || !sysdb.KeyExists(entry[0], entry[1]) {
+			continue
+		}
+		record := map[string]interface{}{}
+		if err := sysdb.Read(entry[0], entry[1], &record); err != nil {
+			continue
+		}
+		n, err := sealField(record, entry[2])
+		if err != nil {
+			return sealed, err
+		}
+		if n > 0 {
+			if err := sysdb.Write(entry[0], entry[1], record); err != nil {
+				return sealed, err
+			}
+			sealed += n
+		}
+	}
+
+	//ACME account keys
+	if sysdb.TableExists("acmepref") {
+		entries, err := sysdb.ListTable("acmepref")
+		if err != nil {
+			return sealed, err
+		}
+		for _, entry := range entries {
+			account := map[string]interface{}{}
+			if err := json.Unmarshal(entry[1], &account); err != nil {
+				//Not an account record, e.g. the preferred CA
+				continue
+			}
+			if _, ok := account["registration"]; !ok {
+				continue
+			}
+			n, err := sealField(account, "key")
+			if err != nil {
+				return sealed, err
+			}
+			if n > 0 {
+				if err := sysdb.Write("acmepref", string(entry[0]), account); err != nil {
+					return sealed, err
+				}
+				sealed += n
+			}
+		}
+	}
+	return sealed, nil
+}
+
+// sealFile seals a plaintext file in place, keeping its file mode
+func sealFile(filename string) (int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	if len(content) == 0 || secrets.IsSealed(content) {
+		return 0, nil
+	}
+	sealed, err := secrets.Seal(content)
+	if err != nil {
+		return 0, err
+	}
+	if err := replaceFile(filename, sealed); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// sealJSONFile seals the fields of a JSON file selected by seal, the file is
+// only rewritten if a field was sealed
+func sealJSONFile(filename string, seal func(config map[string]interface{}) (int, error)) (int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return 0, errors.New("unable to parse " + filepath.Base(filename) + ": " + err.Error())
+	}
+	n, err := seal(config)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	js, err := json.MarshalIndent(config, "", " ")
+	if err != nil {
+		return 0, err
+	}
+	if err := replaceFile(filename, js); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// replaceFile writes the content next to the file and renames it over the
+// original, so an interrupted migration never leaves a truncated key
+func replaceFile(filename string, content []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := filename + ".sealing"
+	if err := os.WriteFile(tmp, content, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// sealField seals a non empty string field of a JSON object
+func sealField(object map[string]interface{}, field string) (int, error) {
+	value, ok := object[field].(string)
+	if !ok || value == "" || secrets.IsSealed([]byte(value)) {
+		return 0, nil
+	}
+	sealed, err := secrets.SealString(value)
+	if err != nil {
+		return 0, err
+	}
+	object[field] = sealed
+	return 1, nil
+}
+
+// child returns the nested JSON object of the field, nil if it is not an object
+func child(object map[string]interface{}, field string) map[string]interface{} {
+	nested, _ := object[field].(map[string]interface{})
+	return nested
+}
@@ -13,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/update/secretsmigration"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
@@ -105,3 +107,10 @@ func isFirstTimeInitialize(path string) (bool, error) {
 	// The folder exists and contains files
 	return false, nil
 }
+
+// EncryptSecretsAtRest seals the plaintext secrets of an existing install
+// after secrets encryption is enabled. Unlike the version updates it is
+// safe to run on every startup, sealed secrets are skipped
+func EncryptSecretsAtRest(confFolder string, sysdb *database.Database) (int, error) {
+	return secretsmigration.EncryptExistingSecrets(confFolder, sysdb)
+}
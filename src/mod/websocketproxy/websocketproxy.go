@@ -61,6 +61,7 @@ type WebsocketProxy struct {
 // Additional options for websocket proxy runtime
 type Options struct {
 	SkipTLSValidation              bool                         //Skip backend TLS validation
+	TLSClientConfig                *tls.Config                  //Backend TLS trust and client certificate, overrides SkipTLSValidation if set
 	SkipOriginCheck                bool                         //Skip origin check
 	CopyAllHeaders                 bool                         //Copy all headers from incoming request to backend request
 	UserDefinedHeaders             []*rewrite.UserDefinedHeader //User defined headers
@@ -151,11 +152,16 @@ func (w *WebsocketProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	dialer := w.Dialer
 	if w.Dialer == nil {
-		if w.Options.SkipTLSValidation {
+		if w.Options.TLSClientConfig != nil {
+			//Use the upstream TLS trust and client certificate on a copy of the default dialer
+			upstreamDialer := *DefaultDialer
+			upstreamDialer.TLSClientConfig = w.Options.TLSClientConfig
+			dialer = &upstreamDialer
+		} else if w.Options.SkipTLSValidation {
 			//Disable TLS secure check if target allow skip verification
-			bypassDialer := websocket.DefaultDialer
+			bypassDialer := *DefaultDialer
 			bypassDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-			dialer = bypassDialer
+			dialer = &bypassDialer
 		} else {
 			//Just use the default dialer come with gorilla websocket
 			dialer = DefaultDialer
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	mtls.go
+
+	This script handle the API for mutual TLS client certificate
+	verification, including the CA bundle / CRL store and the
+	per endpoint mTLS settings
+*/
+
+// handleMTLSList return the CA bundles and CRLs in the store
+func handleMTLSList(w http.ResponseWriter, r *http.Request) {
+	bundles, crls, err := clientCertStore.List()
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(struct {
+		Bundles []string
+		CRLs    []string
+	}{
+		Bundles: bundles,
+		CRLs:    crls,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleMTLSUpload upload a CA bundle (.pem / .crt) or CRL (.crl) into the store
+func handleMTLSUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseMultipartForm(10 << 20) // 10 MB
+	if err != nil {
+		utils.SendErrorResponse(w, "failed to parse form data")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		utils.SendErrorResponse(w, "failed to get file")
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		utils.SendErrorResponse(w, "failed to read file")
+		return
+	}
+
+	err = clientCertStore.Save(header.Filename, content)
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid CA bundle or CRL: "+err.Error())
+		return
+	}
+	SystemWideLogger.PrintAndLog("mtls", "Client CA file uploaded: "+header.Filename, nil)
+	utils.SendOK(w)
+}
+
+// handleMTLSRemove remove a CA bundle or CRL that is not used by any endpoint
+func handleMTLSRemove(w http.ResponseWriter, r *http.Request) {
+	filename, err := utils.PostPara(r, "filename")
+	if err != nil {
+		utils.SendErrorResponse(w, "filename not defined")
+		return
+	}
+
+	for _, endpoint := range dynamicProxyRouter.GetProxyEndpointsAsMap() {
+		if endpoint.MutualTLS != nil && (endpoint.MutualTLS.CABundle == filename || endpoint.MutualTLS.CRLFile == filename) {
+			utils.SendErrorResponse(w, "file is in use by "+endpoint.RootOrMatchingDomain)
+			return
+		}
+	}
+
+	err = clientCertStore.Remove(filename)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
+
+// splitAllowlist split a newline or comma separated allowlist
+func splitAllowlist(value string) []string {
+	results := []string{}
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	}) {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			results = append(results, entry)
+		}
+	}
+	return results
+}
+
+// handleMTLSEndpointConfig get or set the mTLS settings and client certificate allowlists of a proxy endpoint
+func handleMTLSEndpointConfig(w http.ResponseWriter, r *http.Request) {
+	domain, err := utils.PostPara(r, "domain")
+	if err != nil {
+		domain, err = utils.GetPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain or matching rule not defined")
+			return
+		}
+	}
+
+	targetProxyEndpoint, err := dynamicProxyRouter.LoadProxy(domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "target endpoint not exists")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		config := targetProxyEndpoint.MutualTLS
+		if config == nil {
+			config = &mtls.Config{}
+		}
+		subjectAllowlist := []string{}
+		sanAllowlist := []string{}
+		if targetProxyEndpoint.AuthenticationProvider != nil {
+			if targetProxyEndpoint.AuthenticationProvider.ClientCertSubjectAllowlist != nil {
+				subjectAllowlist = targetProxyEndpoint.AuthenticationProvider.ClientCertSubjectAllowlist
+			}
+			if targetProxyEndpoint.AuthenticationProvider.ClientCertSANAllowlist != nil {
+				sanAllowlist = targetProxyEndpoint.AuthenticationProvider.ClientCertSANAllowlist
+			}
+		}
+
+		//Client certificates are rejected while the CRL is outdated
+		crlNextUpdate := int64(0)
+		crlExpired := false
+		if config.Enabled && config.CRLFile != "" {
+			if trust, err := clientCertStore.LoadTrust(config); err == nil && !trust.CRLNextUpdate().IsZero() {
+				crlNextUpdate = trust.CRLNextUpdate().Unix()
+				crlExpired = trust.CRLExpired()
+			}
+		}
+
+		js, _ := json.Marshal(struct {
+			Config           *mtls.Config
+			SubjectAllowlist []string
+			SANAllowlist     []string
+			CRLNextUpdate    int64 //Unix timestamp, 0 if no CRL is used
+			CRLExpired       bool
+		}{
+			Config:           config,
+			SubjectAllowlist: subjectAllowlist,
+			SANAllowlist:     sanAllowlist,
+			CRLNextUpdate:    crlNextUpdate,
+			CRLExpired:       crlExpired,
+		})
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method == http.MethodPost {
+		newConfig := &mtls.Config{}
+		newConfig.Enabled, _ = utils.PostBool(r, "enabled")
+		newConfig.ForwardCertHeaders, _ = utils.PostBool(r, "forwardHeaders")
+		newConfig.CABundle, _ = utils.PostPara(r, "caBundle")
+		newConfig.CRLFile, _ = utils.PostPara(r, "crlFile")
+		verifyMode, _ := utils.PostInt(r, "verifyMode")
+		switch mtls.VerifyMode(verifyMode) {
+		case mtls.VerifyModeOptional, mtls.VerifyModeRequired:
+			newConfig.VerifyMode = mtls.VerifyMode(verifyMode)
+		default:
+			utils.SendErrorResponse(w, "invalid verify mode")
+			return
+		}
+
+		if newConfig.Enabled {
+			if newConfig.CABundle == "" {
+				utils.SendErrorResponse(w, "CA bundle is required to enable mTLS")
+				return
+			}
+			if _, err := clientCertStore.LoadTrust(newConfig); err != nil {
+				utils.SendErrorResponse(w, "unable to load CA bundle or CRL: "+err.Error())
+				return
+			}
+		}
+
+		if targetProxyEndpoint.AuthenticationProvider == nil {
+			targetProxyEndpoint.AuthenticationProvider = &dynamicproxy.AuthenticationProvider{
+				AuthMethod:              dynamicproxy.AuthMethodNone,
+				BasicAuthCredentials:    []*dynamicproxy.BasicAuthCredentials{},
+				BasicAuthExceptionRules: []*dynamicproxy.BasicAuthExceptionRule{},
+			}
+		}
+		//Allowlists are kept if not provided, an empty value clear the allowlist
+		if r.PostForm.Has("subjectAllowlist") {
+			targetProxyEndpoint.AuthenticationProvider.ClientCertSubjectAllowlist = splitAllowlist(r.PostForm.Get("subjectAllowlist"))
+		}
+		if r.PostForm.Has("sanAllowlist") {
+			targetProxyEndpoint.AuthenticationProvider.ClientCertSANAllowlist = splitAllowlist(r.PostForm.Get("sanAllowlist"))
+		}
+
+		targetProxyEndpoint.MutualTLS = newConfig
+		err = SaveReverseProxyConfig(targetProxyEndpoint)
+		if err != nil {
+			utils.SendErrorResponse(w, "save mTLS config failed: "+err.Error())
+			return
+		}
+		targetProxyEndpoint.UpdateToRuntime()
+		utils.SendOK(w)
+		return
+	}
+
+	http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+}
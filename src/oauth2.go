@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"imuslab.com/zoraxy/mod/auth/sso/oidc"
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	oauth2.go
+
+	This script handle the per endpoint OAuth2 / OIDC settings,
+	including the claim based access rules and claim forwarding
+*/
+
+// handleOAuth2EndpointConfig get or set the claim rules and claim forwarding of a proxy endpoint
+func handleOAuth2EndpointConfig(w http.ResponseWriter, r *http.Request) {
+	domain, err := utils.PostPara(r, "domain")
+	if err != nil {
+		domain, err = utils.GetPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain or matching rule not defined")
+			return
+		}
+	}
+
+	targetProxyEndpoint, err := dynamicProxyRouter.LoadProxy(domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "target endpoint not exists")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rules := []*oidc.ClaimRule{}
+		forwarding := &oidc.ClaimForwarding{Headers: []*oidc.ClaimHeader{}, SignedJWTClaims: []string{}}
+		if targetProxyEndpoint.AuthenticationProvider != nil {
+			if targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimRules != nil {
+				rules = targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimRules
+			}
+			if targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimForwarding != nil {
+				//Do not send the signed JWT secret back to the UI
+				copied := *targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimForwarding
+				copied.SignedJWTSecret = ""
+				forwarding = &copied
+			}
+		}
+
+		js, _ := json.Marshal(struct {
+			ClaimRules      []*oidc.ClaimRule
+			ClaimForwarding *oidc.ClaimForwarding
+		}{
+			ClaimRules:      rules,
+			ClaimForwarding: forwarding,
+		})
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method == http.MethodPost {
+		rulesJSON, err := utils.PostPara(r, "rules")
+		if err != nil {
+			rulesJSON = "[]"
+		}
+		newRules := []*oidc.ClaimRule{}
+		err = json.Unmarshal([]byte(rulesJSON), &newRules)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid claim rules")
+			return
+		}
+		for _, rule := range newRules {
+			rule.Claim = strings.TrimSpace(rule.Claim)
+			if rule.Claim == "" {
+				utils.SendErrorResponse(w, "claim name cannot be empty")
+				return
+			}
+			if !oidc.IsValidOperator(rule.Operator) {
+				utils.SendErrorResponse(w, "invalid operator for claim "+rule.Claim)
+				return
+			}
+			if rule.Operator != oidc.ClaimOperatorExists && len(rule.Values) == 0 {
+				utils.SendErrorResponse(w, "values required for claim "+rule.Claim)
+				return
+			}
+		}
+
+		var newForwarding *oidc.ClaimForwarding
+		forwardingJSON, err := utils.PostPara(r, "forwarding")
+		if err == nil {
+			newForwarding = &oidc.ClaimForwarding{}
+			err = json.Unmarshal([]byte(forwardingJSON), newForwarding)
+			if err != nil {
+				utils.SendErrorResponse(w, "invalid claim forwarding settings")
+				return
+			}
+			//Keep the existing secret if it is not changed in the UI
+			if newForwarding.SignedJWTSecret == "" && targetProxyEndpoint.AuthenticationProvider != nil &&
+				targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimForwarding != nil {
+				newForwarding.SignedJWTSecret = targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimForwarding.SignedJWTSecret
+			}
+			err = newForwarding.Validate()
+			if err != nil {
+				utils.SendErrorResponse(w, err.Error())
+				return
+			}
+		}
+
+		if targetProxyEndpoint.AuthenticationProvider == nil {
+			targetProxyEndpoint.AuthenticationProvider = &dynamicproxy.AuthenticationProvider{
+				AuthMethod:              dynamicproxy.AuthMethodNone,
+				BasicAuthCredentials:    []*dynamicproxy.BasicAuthCredentials{},
+				BasicAuthExceptionRules: []*dynamicproxy.BasicAuthExceptionRule{},
+			}
+		}
+		targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimRules = newRules
+		targetProxyEndpoint.AuthenticationProvider.OAuth2ClaimForwarding = newForwarding
+
+		err = SaveReverseProxyConfig(targetProxyEndpoint)
+		if err != nil {
+			utils.SendErrorResponse(w, "save OAuth2 endpoint config failed: "+err.Error())
+			return
+		}
+		targetProxyEndpoint.UpdateToRuntime()
+		utils.SendOK(w)
+		return
+	}
+
+	http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"imuslab.com/zoraxy/mod/auth"
+)
+
+/*
+	permission.go
+
+	This script handle the proxy endpoint scoping of management
+	accounts that are restricted to specific hostnames or tags
+*/
+
+// resolveProxyEndpointScope returns the hostname and tags of a proxy endpoint for scope checking
+func resolveProxyEndpointScope(name string) (string, []string, bool) {
+	endpoint, err := dynamicProxyRouter.LoadProxy(name)
+	if err != nil {
+		return "", nil, false
+	}
+	return endpoint.RootOrMatchingDomain, endpoint.Tags, true
+}
+
+// getRequestUserScope returns the endpoint scope of the current user, nil if unrestricted
+func getRequestUserScope(r *http.Request) *auth.AccessScope {
+	if !requireAuth {
+		return nil
+	}
+	user, err := authAgent.GetRequestUser(r)
+	if err != nil {
+		return nil
+	}
+	return user.Scope
+}
+
+// requestScopeAllowsHostnames checks if the current user can route the given hostnames,
+// e.g. when adding aliases to an endpoint within the scope of the user
+func requestScopeAllowsHostnames(r *http.Request, hostnames []string) bool {
+	scope := getRequestUserScope(r)
+	for _, hostname := range hostnames {
+		if !scope.AllowsHostname(hostname) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestScopeAllowsEndpoint checks if the current user can create or overwrite the
+// proxy endpoint, existing endpoints are also allowed by their tags
+func requestScopeAllowsEndpoint(r *http.Request, name string) bool {
+	scope := getRequestUserScope(r)
+	if !scope.IsRestricted() {
+		return true
+	}
+	if hostname, tags, exists := resolveProxyEndpointScope(name); exists {
+		return scope.AllowsEndpoint(hostname, tags)
+	}
+	return scope.AllowsHostname(name)
+}
+
+// requestHasGlobalPermission checks if the current user has the permission
+// without an endpoint scope, e.g. for the plugin UIs and web SSH sessions
+// that are not tied to a proxy endpoint
+func requestHasGlobalPermission(r *http.Request, permission auth.Permission) bool {
+	if !requireAuth {
+		return true
+	}
+	return authAgent.CheckPermission(r, permission) && !getRequestUserScope(r).IsRestricted()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 
 	"imuslab.com/zoraxy/mod/auth"
 	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/dynamicproxy/captcha"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
 	"imuslab.com/zoraxy/mod/dynamicproxy/permissionpolicy"
 	"imuslab.com/zoraxy/mod/dynamicproxy/rewrite"
@@ -53,6 +55,7 @@ func parseCaptchaConfigFromRequest(r *http.Request) (*dynamicproxy.CaptchaConfig
 	captchaRecaptchaVersion, _ := utils.PostPara(r, "captchaRecaptchaVersion")
 	captchaRecaptchaScoreStr, _ := utils.PostPara(r, "captchaRecaptchaScore")
 	captchaPathPrefixesStr, _ := utils.PostPara(r, "captchaPathPrefixes")
+	captchaPowDifficultyStr, _ := utils.PostPara(r, "captchaPowDifficulty")
 
 	captchaProvider := 0
 	if captchaProviderStr != "" {
@@ -69,6 +72,14 @@ func parseCaptchaConfigFromRequest(r *http.Request) (*dynamicproxy.CaptchaConfig
 		captchaRecaptchaScore, _ = strconv.ParseFloat(captchaRecaptchaScoreStr, 64)
 	}
 
+	captchaPowDifficulty := captcha.DefaultPowDifficulty
+	if captchaPowDifficultyStr != "" {
+		captchaPowDifficulty, _ = strconv.Atoi(captchaPowDifficultyStr)
+		if captchaPowDifficulty < captcha.MinPowDifficulty || captchaPowDifficulty > captcha.MaxPowDifficulty {
+			return nil, fmt.Errorf("proof of work difficulty must be between %d and %d", captcha.MinPowDifficulty, captcha.MaxPowDifficulty)
+		}
+	}
+
 	if captchaRecaptchaVersion == "" {
 		captchaRecaptchaVersion = "v2"
 	}
@@ -101,6 +112,7 @@ func parseCaptchaConfigFromRequest(r *http.Request) (*dynamicproxy.CaptchaConfig
 		RecaptchaVersion:      captchaRecaptchaVersion,
 		RecaptchaScore:        captchaRecaptchaScore,
 		ProtectedPathPrefixes: protectedPathPrefixes,
+		PowDifficulty:         captchaPowDifficulty,
 	}, nil
 }
 
@@ -211,9 +223,12 @@ func ReverseProxyInit() {
 		StatisticCollector:  statisticCollector,
 		WebDirectory:        *path_webserver,
 		AccessController:    accessController,
+		WAFEngine:           wafEngine,
+		ClientCertStore:     clientCertStore,
 		ForwardAuthRouter:   forwardAuthRouter,
 		OAuth2Router:        oauth2Router,
 		ZorxAuthAgentRouter: zorxAuthRouter,
+		ProxyAPIKeyManager:  proxyApiKeyManager,
 		LoadBalancer:        loadBalancer,
 		PluginManager:       pluginManager,
 		/* Timeouts */
@@ -516,6 +531,12 @@ func ReverseProxyHandleAddEndpoint(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		//The scope check of the router only sees the raw rootname, check the root and every alias
+		if !requestScopeAllowsEndpoint(r, rootOrMatchingDomain) || !requestScopeAllowsHostnames(r, aliasHostnames) {
+			utils.SendErrorResponse(w, "hostname is outside of the endpoints you can manage")
+			return
+		}
+
 		//Generate a default authenticaion provider
 		authMethod := dynamicproxy.AuthMethodNone
 		if requireBasicAuth {
@@ -693,6 +714,7 @@ func ReverseProxyHandleEditEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	useStickySession, _ := utils.PostBool(r, "ss")
+	useGeoProximity, _ := utils.PostBool(r, "geoprox")
 
 	//Load bypass TLS option
 	bpgtls, _ := utils.PostPara(r, "bpgtls")
@@ -824,6 +846,12 @@ func ReverseProxyHandleEditEndpoint(w http.ResponseWriter, r *http.Request) {
 		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodOauth2
 	case 4:
 		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodZorxAuth
+	case 5:
+		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodClientCert
+	case 6:
+		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodJWT
+	case 7:
+		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodAPIKey
 	default:
 		newProxyEndpoint.AuthenticationProvider.AuthMethod = dynamicproxy.AuthMethodNone
 	}
@@ -835,6 +863,7 @@ func ReverseProxyHandleEditEndpoint(w http.ResponseWriter, r *http.Request) {
 	newProxyEndpoint.RequireCaptcha = requireCaptcha
 	newProxyEndpoint.CaptchaConfig = captchaConfig
 	newProxyEndpoint.UseStickySession = useStickySession
+	newProxyEndpoint.UseGeoProximity = useGeoProximity
 	newProxyEndpoint.DisableUptimeMonitor = disbleUtm
 	newProxyEndpoint.UptimeMonitorURI = utmURI
 	newProxyEndpoint.DisableAutoFallback = disableAutoFallback
@@ -947,6 +976,12 @@ func ReverseProxyHandleAlias(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Scoped users can only add aliases under their own hostnames
+	if !requestScopeAllowsHostnames(r, newAlias) {
+		utils.SendErrorResponse(w, "Alias not within your permitted hostnames")
+		return
+	}
+
 	//Set the current alias
 	newProxyEndpoint := dynamicproxy.CopyEndpoint(targetProxyEntry)
 	newProxyEndpoint.MatchingDomainAlias = newAlias
@@ -1767,6 +1802,7 @@ func ReverseProxyListDetail(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		targetEndpoint := dynamicproxy.CopyEndpoint(endpointRaw.(*dynamicproxy.ProxyEndpoint))
+		clearProxyEndpointSecrets(targetEndpoint)
 		js, _ := json.Marshal(targetEndpoint)
 		utils.SendJSONResponse(w, string(js))
 	} else if eptype == "root" {
@@ -1777,6 +1813,20 @@ func ReverseProxyListDetail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// clearProxyEndpointSecrets removes the secrets of a copied proxy endpoint
+// before it is sent to the front-end, the edit UIs only show if they are set
+func clearProxyEndpointSecrets(endpoint *dynamicproxy.ProxyEndpoint) {
+	if endpoint == nil || endpoint.AuthenticationProvider == nil {
+		return
+	}
+	if endpoint.AuthenticationProvider.JWTAuthConfig != nil {
+		endpoint.AuthenticationProvider.JWTAuthConfig.Secret = ""
+	}
+	if endpoint.AuthenticationProvider.OAuth2ClaimForwarding != nil {
+		endpoint.AuthenticationProvider.OAuth2ClaimForwarding.SignedJWTSecret = ""
+	}
+}
+
 // List all tags used in the proxy rules
 func ReverseProxyListTags(w http.ResponseWriter, r *http.Request) {
 	results := []string{}
@@ -1803,8 +1853,14 @@ func ReverseProxyList(w http.ResponseWriter, r *http.Request) {
 
 	if eptype == "host" {
 		results := []*dynamicproxy.ProxyEndpoint{}
+		scope := getRequestUserScope(r)
 		dynamicProxyRouter.ProxyEndpoints.Range(func(key, value interface{}) bool {
-			thisEndpoint := dynamicproxy.CopyEndpoint(value.(*dynamicproxy.ProxyEndpoint))
+			rawEndpoint := value.(*dynamicproxy.ProxyEndpoint)
+			if scope.IsRestricted() && !scope.AllowsEndpoint(rawEndpoint.RootOrMatchingDomain, rawEndpoint.Tags) {
+				//Hide endpoints outside the scope of the current user
+				return true
+			}
+			thisEndpoint := dynamicproxy.CopyEndpoint(rawEndpoint)
 			//Clear the auth passwords before showing to front-end
 			cleanedCredentials := []*dynamicproxy.BasicAuthCredentials{}
 			for _, user := range thisEndpoint.AuthenticationProvider.BasicAuthCredentials {
@@ -1814,6 +1870,7 @@ func ReverseProxyList(w http.ResponseWriter, r *http.Request) {
 				})
 			}
 			thisEndpoint.AuthenticationProvider.BasicAuthCredentials = cleanedCredentials
+			clearProxyEndpointSecrets(thisEndpoint)
 			results = append(results, thisEndpoint)
 			return true
 		})
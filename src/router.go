@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/gorilla/csrf"
+	"imuslab.com/zoraxy/mod/auth"
 	"imuslab.com/zoraxy/mod/sshprox"
 )
 
@@ -41,6 +42,13 @@ func FSHandler(handler http.Handler) http.Handler {
 			return
 		}
 
+		//Plugin UIs and web SSH sessions are system wide, not for viewer or scoped accounts
+		if (strings.HasPrefix(r.URL.Path, "/plugin.ui/") || strings.HasPrefix(r.URL.Path, "/web.ssh/")) &&
+			!requestHasGlobalPermission(r, auth.PermissionSystemManage) {
+			http.Error(w, "403 - Forbidden", http.StatusForbidden)
+			return
+		}
+
 		//For Plugin Routing
 		if strings.HasPrefix(r.URL.Path, "/plugin.ui/") {
 			//Extract the plugin ID from the request path
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"imuslab.com/zoraxy/mod/secrets"
+	"imuslab.com/zoraxy/mod/update"
+)
+
+/*
+	secrets.go
+
+	Unlock the encrypted secrets on startup. -encrypt_secrets creates the
+	keyring, once it exists the master key is required on every startup
+	whether the flag is set or not.
+*/
+
+// initSecretsEncryption unlocks the keyring, or creates it if secrets encryption is being enabled
+func initSecretsEncryption() error {
+	if !secrets.KeyringExists(CONF_SECRETS_KEYRING) {
+		if !*encryptSecrets {
+			return nil
+		}
+		masterKey, err := secrets.ReadMasterKey(&secrets.MasterKeySource{
+			KeyFile: *masterKeyFile,
+			EnvName: secrets.EnvMasterKey,
+			Prompt:  "New master key",
+			Confirm: true,
+		})
+		if err != nil {
+			return err
+		}
+		os.MkdirAll(CONF_FOLDER, 0775)
+		keyring, err := secrets.CreateKeyring(CONF_SECRETS_KEYRING, masterKey)
+		if err != nil {
+			return err
+		}
+		secrets.SetDefault(keyring)
+		fmt.Println("Secrets encryption enabled. Keep the master key safe, encrypted secrets cannot be recovered without it")
+		return nil
+	}
+
+	masterKey, err := secrets.ReadMasterKey(&secrets.MasterKeySource{
+		KeyFile: *masterKeyFile,
+		EnvName: secrets.EnvMasterKey,
+		Prompt:  "Master key",
+	})
+	if err != nil {
+		return err
+	}
+	keyring, err := secrets.OpenKeyring(CONF_SECRETS_KEYRING, masterKey)
+	if err != nil {
+		return err
+	}
+	secrets.SetDefault(keyring)
+	return nil
+}
+
+// rekeySecretsKeyring rewraps the data key with a new master key. The
+// encrypted secrets are not rewritten, only the keyring changes
+func rekeySecretsKeyring() error {
+	if !secrets.KeyringExists(CONF_SECRETS_KEYRING) {
+		return errors.New("secrets encryption is not enabled")
+	}
+	masterKey, err := secrets.ReadMasterKey(&secrets.MasterKeySource{
+		KeyFile: *masterKeyFile,
+		EnvName: secrets.EnvMasterKey,
+		Prompt:  "Current master key",
+	})
+	if err != nil {
+		return err
+	}
+	keyring, err := secrets.OpenKeyring(CONF_SECRETS_KEYRING, masterKey)
+	if err != nil {
+		return err
+	}
+	newMasterKey, err := secrets.ReadMasterKey(&secrets.MasterKeySource{
+		KeyFile: *newMasterKeyFile,
+		EnvName: secrets.EnvNewMasterKey,
+		Prompt:  "New master key",
+		Confirm: true,
+	})
+	if err != nil {
+		return err
+	}
+	return keyring.Rekey(newMasterKey)
+}
+
+// encryptExistingSecrets seals the secrets written before encryption was enabled
+func encryptExistingSecrets() {
+	if !secrets.Enabled() {
+		return
+	}
+	sealed, err := update.EncryptSecretsAtRest(CONF_FOLDER, sysdb)
+	if err != nil {
+		SystemWideLogger.PrintAndLog("secrets", "Failed to encrypt some existing secrets", err)
+	}
+	if sealed > 0 {
+		SystemWideLogger.PrintAndLog("secrets", "Encrypted "+strconv.Itoa(sealed)+" existing secrets", nil)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"imuslab.com/zoraxy/mod/auditlog"
 	"imuslab.com/zoraxy/mod/auth/sso/oauth2"
 	"imuslab.com/zoraxy/mod/eventsystem"
 
@@ -24,7 +26,9 @@ import (
 	"imuslab.com/zoraxy/mod/database/dbinc"
 	"imuslab.com/zoraxy/mod/dockerux"
 	"imuslab.com/zoraxy/mod/dynamicproxy/loadbalance"
+	"imuslab.com/zoraxy/mod/dynamicproxy/mtls"
 	"imuslab.com/zoraxy/mod/dynamicproxy/redirection"
+	"imuslab.com/zoraxy/mod/dynamicproxy/waf"
 	"imuslab.com/zoraxy/mod/forwardproxy"
 	"imuslab.com/zoraxy/mod/geodb"
 	"imuslab.com/zoraxy/mod/info/hardwareinfo"
@@ -41,6 +45,8 @@ import (
 	"imuslab.com/zoraxy/mod/statistic/analytic"
 	"imuslab.com/zoraxy/mod/streamproxy"
 	"imuslab.com/zoraxy/mod/tlscert"
+	"imuslab.com/zoraxy/mod/tlscert/certmonitor"
+	"imuslab.com/zoraxy/mod/tlscert/localca"
 	"imuslab.com/zoraxy/mod/webserv"
 )
 
@@ -99,6 +105,8 @@ func startupSequence() {
 
 	LogViewer = logviewer.NewLogViewer(&logviewer.ViewerOption{
 		RootFolder: *path_logFile,
+		//The configuration audit log is only readable with the system manage permission
+		ExcludePrefixes: []string{auditlog.LOG_FILE_PREFIX},
 	})
 
 	//Create database
@@ -118,6 +126,9 @@ func startupSequence() {
 	//Create tables for the database
 	sysdb.NewTable("settings")
 
+	//Seal the plaintext secrets of existing installs, see secrets.go
+	encryptExistingSecrets()
+
 	//Create tmp folder and conf folder
 	os.MkdirAll(TMP_FOLDER, 0775)
 	os.MkdirAll(CONF_HTTP_PROXY, 0775)
@@ -135,12 +146,40 @@ func startupSequence() {
 	// Create an API key manager for plugin authentication
 	pluginApiKeyManager = auth.NewAPIKeyManager()
 
+	// Create an API key manager for proxy endpoints using API key authentication
+	proxyApiKeyManager, err = auth.NewProxyAPIKeyManager(sysdb)
+	if err != nil {
+		panic(err)
+	}
+
 	//Create a TLS certificate manager
 	tlsCertManager, err = tlscert.NewManager(CONF_CERT_STORE, SystemWideLogger)
 	if err != nil {
 		panic(err)
 	}
 
+	//Create the local certificate authority for internal services
+	localCA, err = localca.NewLocalCA(&localca.Options{
+		StoreFolder: CONF_LOCAL_CA,
+		CertStore:   CONF_CERT_STORE,
+		Logger:      SystemWideLogger,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	//Create the certificate monitor, scanning starts in the final sequence
+	certMonitor, err = certmonitor.NewCertMonitor(&certmonitor.Options{
+		StoreFolder:       CONF_CERT_MONITOR,
+		CertStore:         CONF_CERT_STORE,
+		ListEndpoints:     certMonitorListEndpoints,
+		LookupCertificate: certMonitorLookupCertificate,
+		Logger:            SystemWideLogger,
+	})
+	if err != nil {
+		panic(err)
+	}
+
 	//Create a redirection rule table
 	db.NewTable("redirect")
 	redirectAllowRegexp := false
@@ -158,6 +197,8 @@ func startupSequence() {
 		AllowSlowIpv6Lookup:          !*enableHighSpeedGeoIPLookup,
 		Logger:                       SystemWideLogger,
 		SlowLookupCacheClearInterval: GEODB_CACHE_CLEAR_INTERVAL * time.Minute,
+		MMDBPath:                     *geoIPMMDBPath,
+		ExternalDataPath:             CONF_GEODB_PATH,
 	})
 	if err != nil {
 		panic(err)
@@ -181,6 +222,34 @@ func startupSequence() {
 		panic(err)
 	}
 
+	//Create the web application firewall engine
+	wafEngine, err = waf.NewEngine(&waf.Options{
+		RulesFolder:    CONF_WAF_RULES,
+		AuditLogFolder: *path_logFile,
+		Logger:         SystemWideLogger,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	//Create the audit log for configuration changes made through the management API
+	configAuditLogger, err = auditlog.NewAuditLogger(&auditlog.Options{
+		LogFolder: *path_logFile,
+		Snapshot:  snapshotAuditedConfig,
+		OnChange:  emitConfigChangedEvent,
+		Ignore:    isUnauditedRoute,
+		Logger:    SystemWideLogger,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	//Create the client CA store for mTLS endpoints
+	clientCertStore, err = mtls.NewStore(CONF_MTLS_STORE)
+	if err != nil {
+		panic(err)
+	}
+
 	//Create authentication providers
 	forwardAuthRouter = forward.NewAuthRouter(&forward.AuthRouterOptions{
 		Address:  "",
@@ -194,6 +263,7 @@ func startupSequence() {
 	})
 
 	zorxAuthRouter = zorxauth.NewAuthRouter(sysdb, SystemWideLogger)
+	authAgent.ZorxAuthIssuer = zorxAuthRouter.OIDCIssuerURL
 
 	//Create a statistic collector
 	statisticCollector, err = statistic.NewStatisticCollector(statistic.CollectorOption{
@@ -356,6 +426,7 @@ func startupSequence() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	acmeAutoRenewer.SetRenewalFailedHandler(certMonitor.ReportRenewalFailure)
 
 	/*
 		Plugin Manager
@@ -444,6 +515,24 @@ func finalSequence() {
 
 	//Set the host specific TLS behavior resolver for resolving TLS behavior for each hostname
 	tlsCertManager.SetHostSpecificTlsBehavior(dynamicProxyRouter.ResolveHostSpecificTlsBehaviorForHostname)
+
+	//Serve TLS-ALPN-01 challenge certificates from the main TLS listener
+	tlsCertManager.SetACMEChallengeResolver(func(domain string) (*tls.Certificate, bool) {
+		return acmeHandler.GetTLSALPNChallengeCert(domain)
+	})
+
+	//Allow on-demand certificate issuance for hostnames with auto HTTPS enabled
+	tlsCertManager.SetAutoHTTPSHandler(dynamicProxyRouter.IsAutoHTTPSHostname, obtainAutoHTTPSCertificate)
+
+	//Renew certificates reported as revoked by their OCSP responder
+	tlsCertManager.SetOCSPRevokedHandler(handleCertificateRevoked)
+
+	//Sign certificates for internal proxy endpoints with the local CA
+	localCARegisterSpecialRoutingRule()
+	tlsCertManager.SetLocalCertIssuer(isLocalCAHostname, localCA.IssueForHostname)
+
+	//Start scanning the cert store for expiring certificates and hostname mismatches
+	certMonitor.Start()
 }
 
 /* Shutdown Sequence */
@@ -483,11 +572,36 @@ func ShutdownSeq() {
 		acmeAutoRenewer.Close()
 	}
 
+	SystemWideLogger.Println("Closing Local Certificate Authority")
+	if localCA != nil {
+		localCA.Close()
+	}
+
+	SystemWideLogger.Println("Closing Certificate Monitor")
+	if certMonitor != nil {
+		certMonitor.Close()
+	}
+
+	SystemWideLogger.Println("Closing Certificate Store Watcher")
+	if tlsCertManager != nil {
+		tlsCertManager.Close()
+	}
+
 	if accessController != nil {
 		SystemWideLogger.Println("Closing Access Controller")
 		accessController.Close()
 	}
 
+	if wafEngine != nil {
+		SystemWideLogger.Println("Closing WAF audit log")
+		wafEngine.Close()
+	}
+
+	if configAuditLogger != nil {
+		SystemWideLogger.Println("Closing configuration audit log")
+		configAuditLogger.Close()
+	}
+
 	//Close the zorxauth router to save browser sessions
 	if zorxAuthRouter != nil {
 		SystemWideLogger.Println("Shutting down Zoraxy Auth Router")
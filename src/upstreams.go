@@ -97,17 +97,41 @@ func ReverseProxyUpstreamAdd(w http.ResponseWriter, r *http.Request) {
 	bpwsorg, _ := utils.PostBool(r, "bpwsorg")
 	preactivate, _ := utils.PostBool(r, "active")
 
+	//Upstream TLS trust and client certificate, all optional
+	caBundle, _ := utils.PostPara(r, "cabundle")
+	serverName, _ := utils.PostPara(r, "servername")
+	clientCert, _ := utils.PostPara(r, "clientcert")
+	spkiPins := []string{}
+	if pins, err := utils.PostPara(r, "spkipins"); err == nil {
+		for _, pin := range strings.Split(pins, ",") {
+			pin = strings.TrimSpace(pin)
+			if pin != "" {
+				spkiPins = append(spkiPins, pin)
+			}
+		}
+	}
+
 	//Create a new upstream object
 	newUpstream := loadbalance.Upstream{
 		OriginIpOrDomain:         upstreamOrigin,
 		RequireTLS:               requireTLS,
 		SkipCertValidations:      skipTlsValidation,
 		SkipWebSocketOriginCheck: bpwsorg,
+		TLSCABundle:              strings.TrimSpace(caBundle),
+		TLSServerName:            strings.TrimSpace(serverName),
+		TLSPinnedSPKI:            spkiPins,
+		TLSClientCert:            strings.TrimSpace(clientCert),
 		Weight:                   1,
 		MaxConn:                  maxConn,
 		RespTimeout:              int64(respTimeout),
 	}
 
+	err = dynamicProxyRouter.ValidateUpstreamTLS(&newUpstream)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
 	//Add the new upstream to endpoint
 	err = targetEndpoint.AddUpstreamOrigin(&newUpstream, preactivate)
 	if err != nil {
@@ -178,6 +202,13 @@ func ReverseProxyUpstreamUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Check the TLS options before removing the old upstream
+	err = dynamicProxyRouter.ValidateUpstreamTLS(newUpstream)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
 	//Replace the old upstream with the new one
 	err = targetEndpoint.RemoveUpstreamOrigin(originIP)
 	if err != nil {
@@ -14,10 +14,18 @@ import (
 	"net/http"
 	"strings"
 
+	"imuslab.com/zoraxy/mod/auth"
 	"imuslab.com/zoraxy/mod/dynamicproxy"
 	"imuslab.com/zoraxy/mod/utils"
 )
 
+// requestCanManageVdirType checks if the current user can change the virtual directories
+// of the given endpoint type. The scope check of the router only covers host endpoints,
+// so the default site is limited to users without an endpoint scope
+func requestCanManageVdirType(r *http.Request, eptype string) bool {
+	return eptype != "root" || requestHasGlobalPermission(r, auth.PermissionProxyManage)
+}
+
 // List the Virtual directory under given proxy rule
 func ReverseProxyListVdir(w http.ResponseWriter, r *http.Request) {
 	eptype, err := utils.PostPara(r, "type") //Support root and host
@@ -64,6 +72,11 @@ func ReverseProxyAddVdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requestCanManageVdirType(r, eptype) {
+		utils.SendErrorResponse(w, "virtual directories of the default site can only be changed by unrestricted users")
+		return
+	}
+
 	matchingPath, err := utils.PostPara(r, "path")
 	if err != nil {
 		utils.SendErrorResponse(w, "matching path not defined")
@@ -154,6 +167,11 @@ func ReverseProxyDeleteVdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requestCanManageVdirType(r, eptype) {
+		utils.SendErrorResponse(w, "virtual directories of the default site can only be changed by unrestricted users")
+		return
+	}
+
 	vdir, err := utils.PostPara(r, "vdir")
 	if err != nil {
 		utils.SendErrorResponse(w, "vdir matching key not defined")
@@ -210,6 +228,11 @@ func ReverseProxyEditVdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requestCanManageVdirType(r, eptype) {
+		utils.SendErrorResponse(w, "virtual directories of the default site can only be changed by unrestricted users")
+		return
+	}
+
 	vdir, err := utils.PostPara(r, "vdir")
 	if err != nil {
 		utils.SendErrorResponse(w, "vdir matching key not defined")
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"imuslab.com/zoraxy/mod/auth"
+	"imuslab.com/zoraxy/mod/database"
+	"imuslab.com/zoraxy/mod/database/dbinc"
+	"imuslab.com/zoraxy/mod/dynamicproxy"
+	"imuslab.com/zoraxy/mod/info/logger"
+)
+
+/*
+	Tests for the scope checks of the virtual directory API.
+
+	The router only checks the endpoint named in the request, so the
+	handlers must reject default site changes from scoped users.
+*/
+
+func TestScopedUserCannotChangeRootVdirs(t *testing.T) {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "sys.db"), dbinc.BackendBoltDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sysdb.Close() })
+	systemLogger, _ := logger.NewFmtLogger()
+
+	defaultAuthAgent, defaultRequireAuth, defaultProxyRouter := authAgent, requireAuth, dynamicProxyRouter
+	t.Cleanup(func() {
+		authAgent, requireAuth, dynamicProxyRouter = defaultAuthAgent, defaultRequireAuth, defaultProxyRouter
+	})
+	requireAuth = true
+	authAgent = auth.NewAuthenticationAgent("zoraxy", []byte("test-session-key-0123456789abcdef"), sysdb, true, systemLogger, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+	})
+	authAgent.CreateUserAccount("root", "password", "")
+	authAgent.CreateUserAccount("team", "password", "")
+	authAgent.SetUserRoleAndScope("team", auth.RoleOperator, &auth.AccessScope{Hostnames: []string{"*.team.example.com"}})
+
+	rootVdir := &dynamicproxy.VirtualDirectoryEndpoint{MatchingPath: "/static", Domain: "127.0.0.1:8080"}
+	dynamicProxyRouter = &dynamicproxy.Router{
+		Root: &dynamicproxy.ProxyEndpoint{
+			DefaultSiteOption:  dynamicproxy.DefaultSite_ReverseProxy,
+			VirtualDirectories: []*dynamicproxy.VirtualDirectoryEndpoint{rootVdir},
+		},
+	}
+
+	mux := http.NewServeMux()
+	router := auth.NewManagedHTTPRouter(auth.RouterOption{
+		AuthAgent:   authAgent,
+		RequireAuth: true,
+		TargetMux:   mux,
+		EndpointResolver: func(name string) (string, []string, bool) {
+			if name == "api.team.example.com" {
+				return name, nil, true
+			}
+			return "", nil, false
+		},
+	})
+	router.HandleScopedFunc("/api/proxy/vdir/add", ReverseProxyAddVdir, auth.PermissionProxyManage, "endpoint")
+	router.HandleScopedFunc("/api/proxy/vdir/del", ReverseProxyDeleteVdir, auth.PermissionProxyManage, "path")
+	router.HandleScopedFunc("/api/proxy/vdir/edit", ReverseProxyEditVdir, auth.PermissionProxyManage, "path")
+
+	call := func(username string, path string, form url.Values) string {
+		rec := httptest.NewRecorder()
+		authAgent.LoginUserByRequest(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), username, false)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(rec.Result().Cookies()[0])
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	//In scope hostnames pass the router but must not unlock the default site
+	testcases := []struct {
+		name string
+		path string
+		form url.Values
+	}{
+		{"Add", "/api/proxy/vdir/add", url.Values{"type": {"root"}, "endpoint": {"api.team.example.com"}, "path": {"/team"}, "domain": {"127.0.0.1:9000"}}},
+		{"Delete", "/api/proxy/vdir/del", url.Values{"type": {"root"}, "path": {"api.team.example.com"}, "vdir": {"/static"}}},
+		{"Edit", "/api/proxy/vdir/edit", url.Values{"type": {"root"}, "path": {"api.team.example.com"}, "vdir": {"/static"}, "domain": {"evil.example.com"}}},
+	}
+	for _, tc := range testcases {
+		if resp := call("team", tc.path, tc.form); !strings.Contains(resp, "default site") {
+			t.Errorf("%s: expected scoped user to be rejected, got %s", tc.name, resp)
+		}
+	}
+	if len(dynamicProxyRouter.Root.VirtualDirectories) != 1 || rootVdir.Domain != "127.0.0.1:8080" {
+		t.Errorf("expected default site virtual directories to be unchanged, got %+v", dynamicProxyRouter.Root.VirtualDirectories)
+	}
+
+	//Unrestricted users pass the check
+	resp := call("root", "/api/proxy/vdir/del", url.Values{"type": {"root"}, "path": {"api.team.example.com"}, "vdir": {"/missing"}})
+	if strings.Contains(resp, "default site") {
+		t.Errorf("expected admin to manage the default site, got %s", resp)
+	}
+}
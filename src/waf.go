@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"imuslab.com/zoraxy/mod/dynamicproxy/waf"
+	"imuslab.com/zoraxy/mod/utils"
+)
+
+/*
+	waf.go
+
+	This script handle the API for the web application firewall,
+	including the loaded rules, audit log and per endpoint WAF settings
+*/
+
+type WAFRuleSummary struct {
+	ID            int
+	Phase         int
+	Msg           string
+	Severity      string
+	Tags          []string
+	ParanoiaLevel int
+	File          string
+	Line          int
+}
+
+// handleWAFListRules return the loaded WAF rules and the warnings of skipped rules
+func handleWAFListRules(w http.ResponseWriter, r *http.Request) {
+	rules := []*WAFRuleSummary{}
+	for _, rule := range wafEngine.GetRules() {
+		rules = append(rules, &WAFRuleSummary{
+			ID:            rule.ID,
+			Phase:         rule.Phase,
+			Msg:           rule.Msg,
+			Severity:      rule.Severity,
+			Tags:          rule.Tags,
+			ParanoiaLevel: rule.ParanoiaLevel,
+			File:          rule.File,
+			Line:          rule.Line,
+		})
+	}
+
+	js, _ := json.Marshal(struct {
+		Rules    []*WAFRuleSummary
+		Warnings []string
+	}{
+		Rules:    rules,
+		Warnings: wafEngine.GetWarnings(),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleWAFReloadRules reload the base and custom WAF rules from disk
+func handleWAFReloadRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := wafEngine.Reload()
+	if err != nil {
+		utils.SendErrorResponse(w, "unable to reload WAF rules: "+err.Error())
+		return
+	}
+	SystemWideLogger.PrintAndLog("waf", "WAF rules reloaded", nil)
+	utils.SendOK(w)
+}
+
+// handleWAFAuditLog return the recent WAF audit log entries, newest first
+func handleWAFAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	limitString, err := utils.GetPara(r, "limit")
+	if err == nil {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 {
+			utils.SendErrorResponse(w, "invalid limit given")
+			return
+		}
+	}
+
+	entries, err := wafEngine.GetAuditLogger().ReadRecent(limit)
+	if err != nil {
+		utils.SendErrorResponse(w, "unable to read WAF audit log: "+err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(entries)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// handleWAFEndpointConfig get or set the WAF settings of a proxy endpoint
+func handleWAFEndpointConfig(w http.ResponseWriter, r *http.Request) {
+	domain, err := utils.PostPara(r, "domain")
+	if err != nil {
+		domain, err = utils.GetPara(r, "domain")
+		if err != nil {
+			utils.SendErrorResponse(w, "domain or matching rule not defined")
+			return
+		}
+	}
+
+	targetProxyEndpoint, err := dynamicProxyRouter.LoadProxy(domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "target endpoint not exists")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		config := targetProxyEndpoint.WAFConfig
+		if config == nil {
+			config = waf.DefaultConfig()
+		}
+		js, _ := json.Marshal(config)
+		utils.SendJSONResponse(w, string(js))
+		return
+	} else if r.Method == http.MethodPost {
+		newConfig := waf.DefaultConfig()
+		if targetProxyEndpoint.WAFConfig != nil {
+			//Keep the exclusions if not provided in this request
+			newConfig.RuleExclusions = targetProxyEndpoint.WAFConfig.RuleExclusions
+		}
+
+		newConfig.Enabled, _ = utils.PostBool(r, "enabled")
+		newConfig.DetectionOnly, _ = utils.PostBool(r, "detectionOnly")
+		if paranoiaLevel, err := utils.PostInt(r, "paranoiaLevel"); err == nil {
+			newConfig.ParanoiaLevel = paranoiaLevel
+		}
+		if threshold, err := utils.PostInt(r, "anomalyThreshold"); err == nil {
+			newConfig.AnomalyThreshold = threshold
+		}
+		if maxBodySize, err := utils.PostInt(r, "maxBodyInspectSize"); err == nil {
+			newConfig.MaxBodyInspectSize = int64(maxBodySize)
+		}
+
+		exclusions, err := utils.PostPara(r, "exclusions")
+		if err == nil {
+			newExclusions := []*waf.RuleExclusion{}
+			err = json.Unmarshal([]byte(exclusions), &newExclusions)
+			if err != nil {
+				utils.SendErrorResponse(w, "invalid rule exclusions given")
+				return
+			}
+			newConfig.RuleExclusions = newExclusions
+		}
+
+		err = waf.ValidateConfig(newConfig)
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+
+		targetProxyEndpoint.WAFConfig = newConfig
+		err = SaveReverseProxyConfig(targetProxyEndpoint)
+		if err != nil {
+			utils.SendErrorResponse(w, "save WAF config failed: "+err.Error())
+			return
+		}
+		targetProxyEndpoint.UpdateToRuntime()
+		utils.SendOK(w)
+		return
+	}
+
+	http.Error(w, "405 - Method not allowed", http.StatusMethodNotAllowed)
+}
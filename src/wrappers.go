@@ -477,6 +477,10 @@ func HandleIpAccessCheck(w http.ResponseWriter, r *http.Request) {
 
 	type Result struct {
 		CountryIsoCode string       `json:"countryIsoCode"`
+		ASN            uint32       `json:"asn"`
+		Organization   string       `json:"organization"`
+		Region         string       `json:"region"`
+		City           string       `json:"city"`
 		Rules          []RuleResult `json:"rules"`
 	}
 
@@ -495,6 +499,18 @@ func HandleIpAccessCheck(w http.ResponseWriter, r *http.Request) {
 		Rules:          ruleResults,
 	}
 
+	// Resolve region and city if MMDB is loaded
+	if location, err := geodbStore.ResolveLocationFromIP(ip); err == nil && location.RegionIsoCode != "" {
+		result.Region = strings.ToLower(location.CountryIsoCode + "-" + location.RegionIsoCode)
+		result.City = location.City
+	}
+
+	// Resolve ASN if the ASN dataset is loaded
+	if asnInfo, err := geodbStore.ResolveASNFromIP(ip); err == nil {
+		result.ASN = asnInfo.ASN
+		result.Organization = asnInfo.Organization
+	}
+
 	js, _ := json.Marshal(result)
 	utils.SendJSONResponse(w, string(js))
 }